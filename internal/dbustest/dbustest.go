@@ -0,0 +1,89 @@
+// Package dbustest runs a private D-Bus daemon so that tests can export fake services without
+// touching the system or session bus.
+package dbustest
+
+import (
+	"bufio"
+	"github.com/godbus/dbus/v5"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Bus is a private D-Bus daemon.
+type Bus struct {
+	// Address is the address of the bus, suitable for dbus.Connect.
+	Address string
+}
+
+// New starts a private D-Bus daemon that is stopped when the test ends.
+// The test is skipped if dbus-daemon is not installed.
+func New(t testing.TB) *Bus {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found, skipping test that requires a private bus")
+	}
+
+	cmd := exec.Command(
+		daemon,
+		"--session",
+		"--nofork",
+		"--nopidfile",
+		"--print-address=1",
+		"--address=unix:path="+filepath.Join(t.TempDir(), "bus"),
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("dbustest: failed to get dbus-daemon stdout: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("dbustest: failed to start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dbustest: failed to read dbus-daemon address: %v", err)
+	}
+
+	return &Bus{Address: strings.TrimSpace(address)}
+}
+
+// Connect creates a new connection to the bus that is closed when the test ends.
+func (b *Bus) Connect(t testing.TB) *dbus.Conn {
+	t.Helper()
+
+	conn, err := dbus.Connect(b.Address)
+	if err != nil {
+		t.Fatalf("dbustest: failed to connect to %s: %v", b.Address, err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+// RequestName connects to the bus and acquires the given well-known name.
+// Use the returned connection to export the objects of a fake service.
+func (b *Bus) RequestName(t testing.TB, name string) *dbus.Conn {
+	t.Helper()
+
+	conn := b.Connect(t)
+	reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		t.Fatalf("dbustest: failed to request name %s: %v", name, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("dbustest: name %s already taken", name)
+	}
+
+	return conn
+}
@@ -0,0 +1,511 @@
+// Package login1 is a minimal client of systemd-logind's D-Bus API, [org.freedesktop.login1].
+// It manages the connection, the signal dispatch goroutine, and reference counted match rules so
+// that packages built on top of it only have to deal with their own subscribers.
+//
+// [org.freedesktop.login1]: https://www.freedesktop.org/software/systemd/man/latest/org.freedesktop.login1.html
+package login1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"sync"
+)
+
+const (
+	Dest                = "org.freedesktop.login1"
+	ManagerInterface    = "org.freedesktop.login1.Manager"
+	ManagerPath         = "/org/freedesktop/login1"
+	PropertiesInterface = "org.freedesktop.DBus.Properties"
+	SessionInterface    = "org.freedesktop.login1.Session"
+)
+
+// ErrClosed is returned when using a Conn after Close has been called.
+var ErrClosed = errors.New("login1 connection is closed")
+
+// Conn is a connection to logind. Several Conns can share the same bus connection and dispatch
+// goroutine, see [Shared].
+type Conn struct {
+	bus *bus
+
+	mu            sync.Mutex
+	closed        bool
+	subscriptions map[*Subscription]struct{}
+}
+
+// bus is a D-Bus connection together with its signal dispatch goroutine and match rules.
+type bus struct {
+	conn     *dbus.Conn
+	ownsConn bool
+	signals  chan *dbus.Signal
+	stop     chan struct{}
+	done     chan struct{}
+	// muDispatch is held while handlers are called.
+	muDispatch sync.Mutex
+
+	mu            sync.Mutex
+	refs          int
+	subscriptions map[Rule][]*Subscription
+	// anySenderRules is the number of rules with AnySender, the owner of Dest is tracked while
+	// it is positive.
+	anySenderRules int
+	// owner is the unique name of the owner of Dest, empty if it has no owner.
+	owner string
+	// probes are closed when the probe signal with their token is dispatched, see Conn.Probe.
+	probes   map[string]chan struct{}
+	probeSeq uint64
+}
+
+var (
+	muShared sync.Mutex
+	shared   *bus
+	// buses are the buses of New by connection, so that the Conns of a connection share one.
+	buses = make(map[*dbus.Conn]*bus)
+	// connectShared creates the connection of the shared bus, replaced in tests.
+	connectShared = dbus.ConnectSystemBus
+)
+
+// Rule identifies the signals of a subscription.
+type Rule struct {
+	Path      dbus.ObjectPath
+	Interface string
+	Member    string
+
+	// AnySender omits the sender from the match rule, for buses that reject or ignore match rules
+	// with a well-known name as sender. The sender is instead checked against the unique name
+	// of the owner of Dest, which is tracked using NameOwnerChanged.
+	AnySender bool
+}
+
+func (r Rule) matchOptions() []dbus.MatchOption {
+	options := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(r.Path),
+		dbus.WithMatchInterface(r.Interface),
+		dbus.WithMatchMember(r.Member),
+	}
+	if !r.AnySender {
+		options = append(options, dbus.WithMatchSender(Dest))
+	}
+
+	return options
+}
+
+// matches reports whether the signal belongs to the rule. owner is the unique name of the owner
+// of Dest.
+func (r Rule) matches(s *dbus.Signal, owner string) bool {
+	if r.AnySender && (owner == "" || s.Sender != owner) {
+		return false
+	}
+
+	return s.Path == r.Path && s.Name == r.Interface+"."+r.Member
+}
+
+const (
+	busDest      = "org.freedesktop.DBus"
+	busPath      = "/org/freedesktop/DBus"
+	busInterface = "org.freedesktop.DBus"
+)
+
+// nameOwnerChangedOptions matches changes of the owner of Dest. The sender is omitted for the
+// same reason as for rules with AnySender.
+var nameOwnerChangedOptions = []dbus.MatchOption{
+	dbus.WithMatchObjectPath(busPath),
+	dbus.WithMatchInterface(busInterface),
+	dbus.WithMatchMember("NameOwnerChanged"),
+	dbus.WithMatchArg(0, Dest),
+}
+
+// Subscription is a handler registered for the signals matching a Rule.
+type Subscription struct {
+	conn    *Conn
+	rule    Rule
+	handler func(s *dbus.Signal)
+}
+
+// Shared creates a Conn that uses the system bus connection and dispatch goroutine shared by all
+// Conns created using Shared in this process. The shared connection is created when needed and
+// closed when the last Conn using it is closed.
+func Shared() (*Conn, error) {
+	muShared.Lock()
+	defer muShared.Unlock()
+
+	if shared == nil {
+		conn, err := connectShared()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+		}
+
+		shared = newBus(conn, true)
+	}
+
+	return shared.newConn(), nil
+}
+
+// NewOwned creates a Conn using the given connection. The connection is closed when the Conn is
+// closed.
+func NewOwned(conn *dbus.Conn) *Conn {
+	return newBus(conn, true).newConn()
+}
+
+// New creates a Conn using the given connection. The connection is not closed when the Conn is
+// closed.
+//
+// The Conns created by New for the same connection share a bus, like those of Shared: a match
+// rule is added once for all of them and their handlers are called on one dispatch goroutine.
+// Closing one of them never removes a match rule that another one still needs.
+func New(conn *dbus.Conn) *Conn {
+	muShared.Lock()
+	defer muShared.Unlock()
+
+	b, ok := buses[conn]
+	if !ok {
+		b = newBus(conn, false)
+		buses[conn] = b
+	}
+
+	return b.newConn()
+}
+
+func newBus(conn *dbus.Conn, ownsConn bool) *bus {
+	b := &bus{
+		conn:          conn,
+		ownsConn:      ownsConn,
+		signals:       make(chan *dbus.Signal, 16),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		subscriptions: make(map[Rule][]*Subscription),
+		probes:        make(map[string]chan struct{}),
+	}
+
+	conn.Signal(b.signals)
+	go b.dispatch()
+
+	return b
+}
+
+// newConn creates a Conn using the bus. Must be called before the bus is released by its last
+// Conn.
+func (b *bus) newConn() *Conn {
+	b.mu.Lock()
+	b.refs++
+	b.mu.Unlock()
+
+	return &Conn{
+		bus:           b,
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Manager returns the logind manager object.
+func (c *Conn) Manager() dbus.BusObject {
+	return c.bus.conn.Object(Dest, ManagerPath)
+}
+
+// Object returns the logind object at the given path.
+func (c *Conn) Object(path dbus.ObjectPath) dbus.BusObject {
+	return c.bus.conn.Object(Dest, path)
+}
+
+// Subscribe calls handler for every signal matching the rule. The match rule is added to the
+// bus when the first subscription for it is created and removed when the last one is closed.
+//
+// Handlers are called sequentially on a single goroutine, shared by all Conns on the same bus.
+// They must not block, other than for a short method call, and must not call Close on a
+// Subscription or Conn.
+func (c *Conn) Subscribe(rule Rule, handler func(s *dbus.Signal)) (*Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	s := &Subscription{
+		conn:    c,
+		rule:    rule,
+		handler: handler,
+	}
+	if err := c.bus.add(s); err != nil {
+		return nil, err
+	}
+	c.subscriptions[s] = struct{}{}
+
+	return s, nil
+}
+
+// Close removes the subscription. The match rule is removed if this was the last subscription
+// for it. Closing a subscription twice, or after the Conn has been closed, is a no-op.
+func (s *Subscription) Close() error {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.subscriptions[s]; !ok {
+		return nil
+	}
+	delete(c.subscriptions, s)
+
+	return c.bus.remove(s)
+}
+
+// Rule returns the rule the subscription was created with.
+func (s *Subscription) Rule() Rule {
+	return s.rule
+}
+
+// Verify checks that the subscription is open and that the bus still accepts its match rule, by
+// adding the rule once more and removing that addition again. The bus counts the additions of a
+// rule, so the rule of the subscription is left in place. This cannot prove that the bus still
+// has the rule, but it detects a lost connection or a bus that no longer accepts the rule.
+// Returns ErrClosed if the subscription or its Conn has been closed.
+func (s *Subscription) Verify(ctx context.Context) error {
+	c := s.conn
+	c.mu.Lock()
+	_, ok := c.subscriptions[s]
+	c.mu.Unlock()
+	if !ok {
+		return ErrClosed
+	}
+
+	options := s.rule.matchOptions()
+	if err := c.bus.conn.AddMatchSignalContext(ctx, options...); err != nil {
+		return fmt.Errorf("failed to add match rule for %s.%s: %w", s.rule.Interface, s.rule.Member, err)
+	}
+	if err := c.bus.conn.RemoveMatchSignalContext(ctx, options...); err != nil {
+		return fmt.Errorf("failed to remove match rule for %s.%s: %w", s.rule.Interface, s.rule.Member, err)
+	}
+
+	return nil
+}
+
+func (b *bus) add(s *Subscription) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.subscriptions[s.rule]) == 0 {
+		if s.rule.AnySender {
+			if err := b.trackOwner(); err != nil {
+				return err
+			}
+		}
+
+		if err := b.conn.AddMatchSignal(s.rule.matchOptions()...); err != nil {
+			err = fmt.Errorf("failed to add match rule for %s.%s: %w", s.rule.Interface, s.rule.Member, err)
+			if s.rule.AnySender {
+				err = errors.Join(err, b.untrackOwner())
+			}
+			return err
+		}
+	}
+	b.subscriptions[s.rule] = append(b.subscriptions[s.rule], s)
+
+	return nil
+}
+
+// trackOwner starts tracking the owner of Dest if this is the first rule with AnySender.
+// Holding mu is required.
+func (b *bus) trackOwner() error {
+	b.anySenderRules++
+	if b.anySenderRules > 1 {
+		return nil
+	}
+
+	// Match first so that no change is missed between reading the owner and matching
+	if err := b.conn.AddMatchSignal(nameOwnerChangedOptions...); err != nil {
+		b.anySenderRules--
+		return fmt.Errorf("failed to add match rule for NameOwnerChanged: %w", err)
+	}
+
+	var owner string
+	err := b.conn.BusObject().Call(busInterface+".GetNameOwner", 0, Dest).Store(&owner)
+	switch {
+	case err == nil:
+		b.owner = owner
+	case isNameHasNoOwner(err):
+		b.owner = ""
+	default:
+		err = fmt.Errorf("failed to get owner of %s: %w", Dest, err)
+		return errors.Join(err, b.untrackOwner())
+	}
+
+	return nil
+}
+
+func isNameHasNoOwner(err error) bool {
+	const name = "org.freedesktop.DBus.Error.NameHasNoOwner"
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	return errors.As(err, &dbusErr) && dbusErr.Name == name ||
+		errors.As(err, &dbusErrPtr) && dbusErrPtr.Name == name
+}
+
+// untrackOwner stops tracking the owner of Dest if no rule with AnySender is left.
+// Holding mu is required.
+func (b *bus) untrackOwner() error {
+	b.anySenderRules--
+	if b.anySenderRules > 0 {
+		return nil
+	}
+
+	b.owner = ""
+	if err := b.conn.RemoveMatchSignal(nameOwnerChangedOptions...); err != nil {
+		return fmt.Errorf("failed to remove match rule for NameOwnerChanged: %w", err)
+	}
+
+	return nil
+}
+
+// updateOwner records the new owner of Dest if s is its NameOwnerChanged signal.
+func (b *bus) updateOwner(s *dbus.Signal) {
+	if s.Sender != busDest || s.Path != busPath || s.Name != busInterface+".NameOwnerChanged" {
+		return
+	}
+
+	var name, oldOwner, newOwner string
+	if err := dbus.Store(s.Body, &name, &oldOwner, &newOwner); err != nil || name != Dest {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.anySenderRules > 0 {
+		b.owner = newOwner
+	}
+}
+
+func (b *bus) remove(s *Subscription) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriptions := b.subscriptions[s.rule]
+	i := slices.Index(subscriptions, s)
+	if i == -1 {
+		return nil
+	}
+
+	subscriptions = slices.Delete(subscriptions, i, i+1)
+	if len(subscriptions) > 0 {
+		b.subscriptions[s.rule] = subscriptions
+		return nil
+	}
+
+	delete(b.subscriptions, s.rule)
+	var err error
+	if err = b.conn.RemoveMatchSignal(s.rule.matchOptions()...); err != nil {
+		err = fmt.Errorf("failed to remove match rule for %s.%s: %w", s.rule.Interface, s.rule.Member, err)
+	}
+	if s.rule.AnySender {
+		err = errors.Join(err, b.untrackOwner())
+	}
+
+	return err
+}
+
+// Close removes the subscriptions of this Conn. It blocks until no handler of this Conn is
+// running so no handler is called after Close returns. When this is the last Conn using the bus,
+// the dispatch goroutine is stopped and, if owned, the connection is closed.
+// Close is idempotent. It must not be called from a handler.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+
+	var err error
+	for s := range c.subscriptions {
+		err = errors.Join(err, c.bus.remove(s))
+	}
+	clear(c.subscriptions)
+	c.mu.Unlock()
+
+	// Wait for handlers that were collected before the subscriptions were removed
+	c.bus.muDispatch.Lock()
+	c.bus.muDispatch.Unlock()
+
+	return errors.Join(err, c.bus.release())
+}
+
+// release drops a reference to the bus and shuts it down when it was the last one.
+func (b *bus) release() error {
+	muShared.Lock()
+	b.mu.Lock()
+	b.refs--
+	last := b.refs == 0
+	b.mu.Unlock()
+	if last && shared == b {
+		shared = nil
+	}
+	if last && buses[b.conn] == b {
+		delete(buses, b.conn)
+	}
+	muShared.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	b.conn.RemoveSignal(b.signals)
+	close(b.stop)
+	<-b.done
+
+	if b.ownsConn {
+		if err := b.conn.Close(); err != nil {
+			return fmt.Errorf("failed to close system bus connection: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *bus) dispatch() {
+	defer close(b.done)
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case s, ok := <-b.signals:
+			if !ok {
+				// The connection has been closed
+				return
+			}
+			b.handleSignal(s)
+		}
+	}
+}
+
+func (b *bus) handleSignal(s *dbus.Signal) {
+	if s == nil {
+		return
+	}
+
+	b.muDispatch.Lock()
+	defer b.muDispatch.Unlock()
+
+	b.updateOwner(s)
+
+	var handlers []func(s *dbus.Signal)
+	b.mu.Lock()
+	if b.dispatchProbe(s) {
+		b.mu.Unlock()
+		return
+	}
+	for rule, subscriptions := range b.subscriptions {
+		if !rule.matches(s, b.owner) {
+			continue
+		}
+		for _, subscription := range subscriptions {
+			handlers = append(handlers, subscription.handler)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(s)
+	}
+}
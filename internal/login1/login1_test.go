@@ -0,0 +1,411 @@
+package login1
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/godbus/dbus/v5"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+	c := New(bus.Connect(t))
+	defer c.Close()
+
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"}
+	first := make(chan bool, 10)
+	second := make(chan bool, 10)
+
+	sub1, err := c.Subscribe(rule, func(s *dbus.Signal) { first <- s.Body[0].(bool) })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	_, err = c.Subscribe(rule, func(s *dbus.Signal) { second <- s.Body[0].(bool) })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	emit := func(value bool) {
+		t.Helper()
+		err := service.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", value)
+		if err != nil {
+			t.Fatalf("Emit() error = %v", err)
+		}
+	}
+	receive := func(c <-chan bool, want bool) {
+		t.Helper()
+		select {
+		case got := <-c:
+			if got != want {
+				t.Fatalf("received %t, want %t", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for signal")
+		}
+	}
+
+	emit(true)
+	receive(first, true)
+	receive(second, true)
+
+	if err := sub1.Close(); err != nil {
+		t.Fatalf("Subscription.Close() error = %v", err)
+	}
+	if err := sub1.Close(); err != nil {
+		t.Fatalf("second Subscription.Close() error = %v", err)
+	}
+
+	// The match rule is still needed by the second subscription
+	emit(false)
+	receive(second, false)
+	if len(first) != 0 {
+		t.Fatalf("closed subscription received a signal")
+	}
+}
+
+func TestSubscribeAnySender(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+	impostor := bus.Connect(t)
+	c := New(bus.Connect(t))
+	defer c.Close()
+
+	received := make(chan bool, 10)
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep", AnySender: true}
+	_, err := c.Subscribe(rule, func(s *dbus.Signal) { received <- s.Body[0].(bool) })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	emit := func(conn *dbus.Conn, value bool) {
+		t.Helper()
+		if err := conn.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", value); err != nil {
+			t.Fatalf("Emit() error = %v", err)
+		}
+	}
+	receive := func(want bool) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("received %t, want %t", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for signal")
+		}
+	}
+
+	// Signals of connections that do not own the name are ignored
+	emit(impostor, true)
+	emit(service, false)
+	receive(false)
+
+	if _, err := service.ReleaseName(Dest); err != nil {
+		t.Fatalf("ReleaseName() error = %v", err)
+	}
+	newOwner := bus.RequestName(t, Dest)
+
+	emit(service, true)
+	emit(newOwner, false)
+	receive(false)
+	if len(received) != 0 {
+		t.Fatalf("received a signal of the previous owner")
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := dbustest.New(t)
+	conn := bus.Connect(t)
+	c := New(conn)
+
+	_, err := c.Subscribe(
+		Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"},
+		func(s *dbus.Signal) {},
+	)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+
+	if !conn.Connected() {
+		t.Fatalf("Close() closed a connection it does not own")
+	}
+
+	_, err = c.Subscribe(
+		Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"},
+		func(s *dbus.Signal) {},
+	)
+	if err != ErrClosed {
+		t.Fatalf("Subscribe() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestShared(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+
+	var connections []*dbus.Conn
+	connectShared = func(opts ...dbus.ConnOption) (*dbus.Conn, error) {
+		conn, err := dbus.Connect(bus.Address, opts...)
+		if err == nil {
+			connections = append(connections, conn)
+		}
+		return conn, err
+	}
+	t.Cleanup(func() {
+		connectShared = dbus.ConnectSystemBus
+	})
+
+	first, err := Shared()
+	if err != nil {
+		t.Fatalf("Shared() error = %v", err)
+	}
+	goroutines := runtime.NumGoroutine()
+
+	second, err := Shared()
+	if err != nil {
+		t.Fatalf("Shared() error = %v", err)
+	}
+	if len(connections) != 1 {
+		t.Fatalf("Shared() created %d connections, want 1", len(connections))
+	}
+	if got := runtime.NumGoroutine(); got != goroutines {
+		t.Errorf("second Shared() changed goroutine count from %d to %d", goroutines, got)
+	}
+
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"}
+	received := make(chan bool, 10)
+	_, err = first.Subscribe(rule, func(s *dbus.Signal) {})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	_, err = second.Subscribe(rule, func(s *dbus.Signal) { received <- s.Body[0].(bool) })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !connections[0].Connected() {
+		t.Fatalf("Close() closed the shared connection while still in use")
+	}
+
+	// The match rule is still needed by the second Conn
+	if err := service.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for signal")
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if connections[0].Connected() {
+		t.Fatalf("shared connection not closed after the last Conn was closed")
+	}
+
+	third, err := Shared()
+	if err != nil {
+		t.Fatalf("Shared() after closing all Conns error = %v", err)
+	}
+	defer third.Close()
+	if len(connections) != 2 {
+		t.Fatalf("Shared() did not reconnect after the shared connection was closed")
+	}
+}
+
+func TestNewSharesBus(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+	conn := bus.Connect(t)
+
+	first := New(conn)
+	second := New(conn)
+	if first.bus != second.bus {
+		t.Fatal("New() created a second bus for the same connection")
+	}
+
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"}
+	received := make(chan bool, 10)
+	_, err := first.Subscribe(rule, func(s *dbus.Signal) {})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	_, err = second.Subscribe(rule, func(s *dbus.Signal) { received <- s.Body[0].(bool) })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The match rule is still needed by the second Conn
+	if err := service.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for signal")
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !conn.Connected() {
+		t.Fatalf("Close() closed a connection it does not own")
+	}
+
+	third := New(conn)
+	defer third.Close()
+	if third.bus == first.bus {
+		t.Fatal("New() reused the bus after its last Conn was closed")
+	}
+}
+
+func TestCloseWaitsForHandler(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+	conn := bus.Connect(t)
+	other := New(conn)
+	defer other.Close()
+	c := other.bus.newConn()
+
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	_, err := c.Subscribe(rule, func(s *dbus.Signal) {
+		close(started)
+		<-release
+		close(finished)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := service.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatalf("Close() returned while a handler was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-closed
+	select {
+	case <-finished:
+	default:
+		t.Fatalf("Close() returned before the handler finished")
+	}
+}
+
+func TestSubscriptionVerify(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+	c := New(bus.Connect(t))
+	defer c.Close()
+
+	received := make(chan struct{}, 10)
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"}
+	sub, err := c.Subscribe(rule, func(s *dbus.Signal) { received <- struct{}{} })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := sub.Verify(context.Background()); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// The match rule of the subscription is still in place
+	if err := service.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no signal received after Verify")
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Fatalf("Subscription.Close() error = %v", err)
+	}
+	if err := sub.Verify(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Verify() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, Dest)
+	c := New(bus.Connect(t))
+	defer c.Close()
+
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+
+	// A handler that does not return keeps the probe from being dispatched
+	rule := Rule{Path: ManagerPath, Interface: ManagerInterface, Member: "PrepareForSleep"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_, err := c.Subscribe(rule, func(s *dbus.Signal) {
+		close(started)
+		<-release
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := service.Emit(ManagerPath, ManagerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Probe(ctx)
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Probe() with a stuck handler error = %v, want context.DeadlineExceeded", err)
+	}
+
+	c.bus.mu.Lock()
+	probes := len(c.bus.probes)
+	c.bus.mu.Unlock()
+	if probes != 0 {
+		t.Fatalf("%d probes left behind", probes)
+	}
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("Probe() after the handler returned error = %v", err)
+	}
+
+	_ = c.Close()
+	if _, err := c.Probe(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Probe() after Close error = %v, want ErrClosed", err)
+	}
+}
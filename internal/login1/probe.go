@@ -0,0 +1,103 @@
+package login1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"strconv"
+	"time"
+)
+
+// The probe signal of Conn.Probe. It is only received by the connection that emits it.
+const (
+	probePath      = "/io/github/MatthiasKunnen/system/Probe"
+	probeInterface = "io.github.MatthiasKunnen.system.Probe"
+	probeMember    = "Probe"
+)
+
+// Probe checks that signals still reach the handlers of the bus. It emits a signal that only
+// this connection receives and waits until the dispatch goroutine handled it. A lost
+// connection, a bus that no longer delivers signals to the connection, or a dispatch goroutine
+// stuck in a handler make Probe fail once ctx is done.
+// Returns the time between emitting the signal and its dispatch.
+func (c *Conn) Probe(ctx context.Context) (time.Duration, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+
+	return c.bus.probe(ctx)
+}
+
+func (b *bus) probe(ctx context.Context) (time.Duration, error) {
+	names := b.conn.Names()
+	if len(names) == 0 {
+		return 0, errors.New("bus connection has no unique name")
+	}
+
+	options := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(probePath),
+		dbus.WithMatchInterface(probeInterface),
+		dbus.WithMatchMember(probeMember),
+		dbus.WithMatchSender(names[0]),
+	}
+	if err := b.conn.AddMatchSignalContext(ctx, options...); err != nil {
+		return 0, fmt.Errorf("failed to add match rule for the probe: %w", err)
+	}
+
+	delivered := make(chan struct{})
+	b.mu.Lock()
+	b.probeSeq++
+	token := strconv.FormatUint(b.probeSeq, 10)
+	b.probes[token] = delivered
+	b.mu.Unlock()
+
+	latency, err := b.awaitProbe(ctx, token, delivered)
+
+	b.mu.Lock()
+	delete(b.probes, token)
+	b.mu.Unlock()
+	// Also when ctx is done, the rule must not stay behind
+	if removeErr := b.conn.RemoveMatchSignal(options...); removeErr != nil && err == nil {
+		err = fmt.Errorf("failed to remove match rule for the probe: %w", removeErr)
+	}
+
+	return latency, err
+}
+
+// awaitProbe emits the probe signal with the given token and waits until it is dispatched.
+func (b *bus) awaitProbe(ctx context.Context, token string, delivered <-chan struct{}) (time.Duration, error) {
+	start := time.Now()
+	if err := b.conn.Emit(probePath, probeInterface+"."+probeMember, token); err != nil {
+		return 0, fmt.Errorf("failed to emit the probe signal: %w", err)
+	}
+
+	select {
+	case <-delivered:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("probe signal was not dispatched: %w", ctx.Err())
+	}
+}
+
+// dispatchProbe reports whether s is a probe signal and notifies its Probe call, if any.
+// Holding mu is required.
+func (b *bus) dispatchProbe(s *dbus.Signal) bool {
+	if s.Path != probePath || s.Name != probeInterface+"."+probeMember {
+		return false
+	}
+
+	if len(s.Body) == 1 {
+		if token, ok := s.Body[0].(string); ok {
+			if delivered, ok := b.probes[token]; ok {
+				close(delivered)
+				delete(b.probes, token)
+			}
+		}
+	}
+
+	return true
+}
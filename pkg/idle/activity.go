@@ -0,0 +1,103 @@
+package idle
+
+import (
+	idleNotify "github.com/MatthiasKunnen/go-wayland/wayland/staging/ext-idle-notify-v1"
+	"sync"
+	"time"
+)
+
+// activityDuration is the duration of the notification that tracks the idle state for
+// Controller.IdleSince. It is the resolution of IdleSince and IsIdleFor.
+const activityDuration = time.Second
+
+// activity tracks since when the session is idle using a notification with a short duration.
+type activity struct {
+	// notification is only accessed on the dispatch goroutine and by Close.
+	notification *idleNotify.IdleNotification
+
+	mu sync.Mutex
+	// err is set when the notification could not be created.
+	err       error
+	idle      bool
+	idleSince time.Time
+}
+
+// bind sets the event handlers of the notification. clk is the clock of the controller.
+func (a *activity) bind(notification *idleNotify.IdleNotification, clk clock) {
+	a.notification = notification
+	notification.SetIdledHandler(func(event idleNotify.IdleNotificationIdledEvent) {
+		a.idled(clk.Now())
+	})
+	notification.SetResumedHandler(func(event idleNotify.IdleNotificationResumedEvent) {
+		a.resumed()
+	})
+}
+
+// idled records that the notification fired at now, the last input happened activityDuration
+// earlier.
+func (a *activity) idled(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.idle = true
+	a.idleSince = now.Add(-activityDuration)
+}
+
+func (a *activity) resumed() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.idle = false
+	a.idleSince = time.Time{}
+}
+
+func (a *activity) get() (time.Time, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err != nil {
+		return time.Time{}, false, a.err
+	}
+
+	return a.idleSince, a.idle, nil
+}
+
+// startActivity creates the notification tracking the idle state of the default seat.
+// An error is recorded, not returned, the Controller works without it.
+func (m *waylandIdleController) startActivity() {
+	notification, err := m.createActivityNotification()
+	if err != nil {
+		m.activity.mu.Lock()
+		m.activity.err = err
+		m.activity.mu.Unlock()
+		return
+	}
+
+	m.activity.bind(notification, m.clock)
+}
+
+func (m *waylandIdleController) createActivityNotification() (*idleNotify.IdleNotification, error) {
+	seat, err := m.getSeat("")
+	if err != nil {
+		return nil, err
+	}
+
+	return m.getIdleNotification(activityDuration, seat)
+}
+
+func (m *waylandIdleController) IdleSince() (time.Time, bool, error) {
+	if err := m.Err(); err != nil {
+		return time.Time{}, false, err
+	}
+
+	return m.activity.get()
+}
+
+func (m *waylandIdleController) IsIdleFor(d time.Duration) (bool, error) {
+	since, idle, err := m.IdleSince()
+	if err != nil || !idle {
+		return false, err
+	}
+
+	return m.clock.Now().Sub(since) >= d, nil
+}
@@ -0,0 +1,49 @@
+package idle
+
+import (
+	"time"
+)
+
+// clock abstracts time so that scheduling can be tested without waiting.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) clockTimer
+	// Monotonic returns the time elapsed since an arbitrary reference on a clock that does not
+	// change with the wall clock and, on Linux, does not advance while the system is suspended.
+	Monotonic() time.Duration
+}
+
+type clockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+// monotonicStart is the reference of realClock.Monotonic.
+var monotonicStart = time.Now()
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) clockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) Monotonic() time.Duration {
+	// Since uses the monotonic clock reading of both times
+	return time.Since(monotonicStart)
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
@@ -1,28 +1,335 @@
 package idle
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// ErrNotificationClosed is returned when operating on a Notification after Close has been called.
+var ErrNotificationClosed = errors.New("idle notification is closed")
+
+// ErrNotificationDead is returned by Notification.SetDuration when the notification could not
+// be recreated by the display server and no longer fires. See CreateIdleNotification.OnError.
+var ErrNotificationDead = errors.New("idle notification is dead")
+
+// ErrControllerClosed is returned when operating on a Controller after Close has been called.
+var ErrControllerClosed = errors.New("idle controller is closed")
+
+// ErrConnectionLost is returned by Controller.Err when the connection to the display server
+// failed. The Controller must be closed and a new one created.
+var ErrConnectionLost = errors.New("connection to display server lost")
+
+// ErrDispatchOwned is returned by Controller.Run when the dispatch functions are already being
+// consumed, either by another Run call or because the controller was created without WithRun.
+var ErrDispatchOwned = errors.New("dispatch is already owned by another consumer")
+
+// ErrTooManyNotifications is returned by Controller.AddNotification when the number of open
+// notifications has reached the limit set by WithMaxNotifications.
+var ErrTooManyNotifications = errors.New("too many idle notifications")
+
+// ErrUnsupported is returned by Controller.AddNotification when the display server cannot honor
+// a field of CreateIdleNotification, such as ActivityMask or Devices.
+var ErrUnsupported = errors.New("not supported by the display server")
+
+// ErrSeatNotFound is returned by Controller.AddNotification when the requested seat does not
+// exist.
+var ErrSeatNotFound = errors.New("seat not found")
+
 type Controller interface {
+	// AddNotification returns ErrControllerClosed if the Controller has been closed or the error
+	// returned by Err if the connection has failed.
+	// Returns ErrTooManyNotifications when the limit set by WithMaxNotifications is reached and
+	// ErrUnsupported when the display server cannot honor ActivityMask or Devices.
 	AddNotification(notificationInput *CreateIdleNotification) (Notification, error)
 	// Close closes any connection the Controller might have. Do not use the Controller after
 	// this.
+	// Calling Close more than once is a no-op.
 	Close() error
+
+	// Done returns a channel that is closed when the Controller stops working, either because
+	// Close was called or because the connection failed fatally. No dispatch functions are
+	// handed out after Done is closed.
+	Done() <-chan struct{}
+
+	// Err returns nil while Done is not closed. Afterwards, it returns ErrControllerClosed if
+	// Close was called, or an error wrapping ErrConnectionLost if the connection failed.
+	Err() error
+
+	// Run executes the dispatch functions of the Controller on the calling goroutine until ctx is
+	// done, the Controller stops, or a dispatch function fails. It returns respectively ctx.Err(),
+	// the error returned by Err, or the error of the dispatch function. After a dispatch error,
+	// Run can be called again.
+	// When ctx is done, Run shuts the Controller down before returning: the queued dispatch
+	// functions, such as those of Notification.Close, are executed, the Controller is closed, and
+	// AddNotification calls that are waiting for Run fail with ErrControllerClosed. Errors of the
+	// shutdown are joined with ctx.Err().
+	// While Run is running, AddNotification is executed by Run and must therefore not be called
+	// from the dispatch goroutine, e.g. from CreateIdleNotification.OnError.
+	// Only available when the Controller was created with WithRun, returns ErrDispatchOwned
+	// otherwise or when Run is already running.
+	Run(ctx context.Context) error
+
+	// Seats returns the seats known to the Controller in the order they were announced.
+	Seats() []SeatInfo
+
+	// Capabilities returns the protocol versions negotiated with the display server.
+	Capabilities() Capabilities
+
+	// IdleSince returns since when the default seat is idle, see CreateIdleNotification.SeatName,
+	// and whether it is idle at all. The Controller follows the idle state from the moment it is
+	// created, with a resolution of a second: the session is reported idle once there was no
+	// input for a second, earlier idleness is not known.
+	// Returns the error of Err once Done is closed.
+	//
+	// The state is updated by the dispatch functions, it does not include events that have not
+	// been dispatched yet. Safe to be called from any goroutine, including the one executing the
+	// dispatch functions.
+	IdleSince() (time.Time, bool, error)
+
+	// IsIdleFor reports whether the default seat has been idle for at least d. See IdleSince.
+	IsIdleFor(d time.Duration) (bool, error)
+
+	// Stats returns the number of open notifications and the limit set by WithMaxNotifications.
+	Stats() ControllerStats
+
+	// Debug returns a human-readable description of the Controller's state, including the
+	// statistics of every open notification and, with WithNotificationTraces, where it was
+	// created.
+	Debug() string
+}
+
+// ActivityReporter is implemented by a Controller that decides itself when the session is idle,
+// such as the Controller of NewEvdevIdleController. Use a type assertion to detect it. The
+// Controller of NewWaylandIdleController does not implement it, the display server decides when
+// the session is idle.
+type ActivityReporter interface {
+	// ReportActivity records activity at t that the Controller cannot see, e.g. of a motion
+	// sensor. It counts as input for every notification, regardless of ActivityMask and Devices:
+	// notifications that are idle resume unless their duration passed since t, the others idle
+	// their duration after t at the earliest. IdleSince includes the activity.
+	//
+	// Only how long ago t was is used, according to the clock of the Controller, so t can come
+	// from another clock. A t in the future is taken as now, a t before the last activity of a
+	// notification has no effect on it.
+	// Returns the error of Err once Done is closed.
+	ReportActivity(t time.Time) error
 }
 
 type Notification interface {
 	// Close destroys this notification.
 	// Safe to be called from another goroutine.
 	Close() error
+
+	// SetDuration changes the duration after which the notification fires, keeping the same
+	// channels registered.
+	// If the session is idle when the duration changes, the idle state is re-evaluated against
+	// the new duration and Resume is sent if the session is no longer considered idle.
+	// Returns ErrNotificationClosed if the notification has been closed and ErrNotificationDead
+	// if an earlier change failed.
+	// Safe to be called from another goroutine.
+	SetDuration(d time.Duration) error
+
+	// Stats returns the delivery statistics of this notification.
+	// Statistics are kept across SetDuration calls.
+	// Safe to be called from another goroutine.
+	Stats() NotificationStats
+
+	// ResetStats sets all delivery statistics of this notification to zero.
+	// Safe to be called from another goroutine.
+	ResetStats()
+
+	// LastIdlePeriod returns the IdlePeriod of the last resume event, for consumers of the Idle
+	// and Resume channels which do not receive Event. Returns false if there has not been a
+	// resume event after an idle event yet.
+	// Safe to be called from another goroutine.
+	LastIdlePeriod() (IdlePeriod, bool)
 }
 
+// CreateIdleNotification describes a notification to be created by Controller.AddNotification.
+//
+// Every channel that is set receives every event meant for it, in the order the events
+// occurred. Events are buffered per channel; when a consumer falls too far behind, events for
+// that channel are dropped and counted in the notification's statistics. Closing a channel
+// while the notification is active is not recommended but does not cause a panic.
 type CreateIdleNotification struct {
 	Duration time.Duration
 
+	// SeatName is the name of the seat whose inputs are tracked. When empty, the seat named by
+	// the XDG_SEAT environment variable is used if it exists, otherwise the first seat.
+	SeatName string
+
+	// ActivityMask selects the kinds of input that count as activity, e.g. only
+	// ActivityKeyboard so that moving the mouse does not resume. Zero means every kind of input.
+	// See Capabilities.ActivityMask, AddNotification returns ErrUnsupported when the display
+	// server cannot tell the selected kinds apart.
+	ActivityMask Activity
+
+	// Devices selects the input devices whose input counts as activity, e.g. only the stylus of
+	// a drawing tablet. Input counts when any DeviceMatch selects its device and it is of a kind
+	// selected by ActivityMask. Empty means every device.
+	// See Capabilities.Devices, AddNotification returns ErrUnsupported when the Controller cannot
+	// tell devices apart.
+	Devices []DeviceMatch
+
 	// Idle is the channel that will be notified when the system has idled.
 	Idle chan<- struct{}
 
 	// Resume is the channel that will be notified when the system has resumed.
 	Resume chan<- struct{}
+
+	// Events is the channel that will receive an Event for every idle and resume.
+	// It can be used instead of, or in addition to, Idle and Resume.
+	Events chan<- Event
+
+	// OnError, if set, is called with the errors that occur after AddNotification returned, such
+	// as failing to recreate the notification for a new duration or to destroy it. When
+	// recreating fails, the notification is dead: it no longer fires and SetDuration returns
+	// ErrNotificationDead. Close must still be called.
+	// When OnError is nil, these errors are returned by the dispatch functions instead.
+	//
+	// OnError is called from the dispatch goroutine and must not block.
+	OnError func(err error)
+}
+
+// SeatInfo describes a seat, a group of input devices.
+type SeatInfo struct {
+	// Name is the name of the seat, e.g. "seat0". Empty if the display server did not report it.
+	Name string
+
+	// Version is the negotiated version of the seat protocol, e.g. wl_seat.
+	Version uint32
+}
+
+// Capabilities describes what the display server supports, as negotiated by the Controller.
+type Capabilities struct {
+	// NotifierVersion is the negotiated version of the idle notification protocol, e.g.
+	// ext_idle_notifier_v1.
+	NotifierVersion uint32
+
+	// SeatVersion is the negotiated version of the seat protocol of the first seat, zero if no
+	// seat was announced. See Seats for the version of every seat.
+	SeatVersion uint32
+
+	// InputIdle reports whether notifications can ignore idle inhibitors, such as a video player
+	// preventing the screen from turning off, and only consider user input.
+	InputIdle bool
+
+	// ActivityMask reports whether CreateIdleNotification.ActivityMask can select kinds of input.
+	// The idle notification protocol has no notion of input kinds, only the Controller of
+	// NewEvdevIdleController supports it.
+	ActivityMask bool
+
+	// Devices reports whether CreateIdleNotification.Devices can select input devices. Like
+	// ActivityMask, only the Controller of NewEvdevIdleController supports it.
+	Devices bool
+}
+
+// DeviceMatch selects input devices, see CreateIdleNotification.Devices. A device is selected
+// when every field that is set matches, the zero DeviceMatch selects every device.
+// The fields are patterns as accepted by path.Match.
+type DeviceMatch struct {
+	// Path matches the path of the device node, e.g. "/dev/input/by-id/*-event-mouse". Both the
+	// path the device was opened with and the path with symbolic links resolved are matched.
+	Path string
+
+	// Name matches the name the device reports, e.g. "Wacom Intuos S Pen".
+	Name string
+}
+
+// Activity is a set of kinds of user input, see CreateIdleNotification.ActivityMask.
+type Activity uint
+
+const (
+	ActivityKeyboard Activity = 1 << iota
+	ActivityPointer
+	ActivityTouch
+	ActivityTablet
+
+	// ActivityAll is every kind of input, the same as leaving ActivityMask zero.
+	ActivityAll = ActivityKeyboard | ActivityPointer | ActivityTouch | ActivityTablet
+)
+
+func (a Activity) String() string {
+	if a == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, kind := range []struct {
+		activity Activity
+		name     string
+	}{
+		{ActivityKeyboard, "keyboard"},
+		{ActivityPointer, "pointer"},
+		{ActivityTouch, "touch"},
+		{ActivityTablet, "tablet"},
+	} {
+		if a&kind.activity != 0 {
+			names = append(names, kind.name)
+		}
+	}
+	if unknown := a &^ ActivityAll; unknown != 0 {
+		names = append(names, fmt.Sprintf("Activity(%#x)", uint(unknown)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// EventKind is the type of transition an Event describes.
+type EventKind int
+
+const (
+	// EventIdle means the system has been idle for the duration of the notification.
+	EventIdle EventKind = iota + 1
+	// EventResume means the system is active again after having been idle.
+	EventResume
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventIdle:
+		return "idle"
+	case EventResume:
+		return "resume"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event describes an idle or resume transition of a notification.
+type Event struct {
+	Kind EventKind
+
+	// Time is the moment the event was dispatched by the controller. Events can be delivered
+	// late, e.g. in a burst after resuming from suspend.
+	Time time.Time
+
+	// Duration is the duration the notification was configured with when the event occurred.
+	// For EventIdle, the idle period started at Time minus Duration.
+	Duration time.Duration
+
+	// IdlePeriod is the time between the EventIdle of the notification and this EventResume,
+	// i.e. how long the notification was idle. Zero for EventIdle.
+	IdlePeriod IdlePeriod
+}
+
+// IdlePeriod is the time from an idle event to the following resume event of a notification. It
+// is measured using two clocks, which diverge when the system is suspended or the wall clock is
+// changed during the period:
+//   - Wall is measured using the wall clock, the Time of the events. It includes the time the
+//     system was suspended. It is wrong when the wall clock was changed during the period, e.g.
+//     by NTP or the user, and can even be negative.
+//   - Monotonic is measured using the monotonic clock, which is not affected by changes of the
+//     wall clock but, on Linux, does not advance while the system is suspended. It is the time
+//     the system was running while idle.
+//
+// Trust Wall for how long the user was away: the user was not active while the system was
+// suspended either. Use Monotonic when Wall is negative or the wall clock is known to have been
+// changed.
+type IdlePeriod struct {
+	Wall      time.Duration
+	Monotonic time.Duration
 }
@@ -0,0 +1,242 @@
+package idle
+
+import (
+	"sync"
+	"time"
+)
+
+// engine decides when notifications idle and resume for controllers that observe the input
+// themselves instead of being told by a display server, such as the evdev controller.
+//
+// Every watcher has its own time of last activity because it only counts the input it accepts.
+// A single timer is armed for the earliest deadline of all watchers. Input only moves deadlines
+// later, so it does not rearm the timer unless a watcher resumes; when the timer fires early,
+// the deadline is recomputed.
+type engine struct {
+	clock clock
+	// wake makes run recompute the deadline, e.g. after a watcher was added.
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	mu sync.Mutex
+	// lastInput is the time of the last input of any kind, on any device.
+	lastInput time.Time
+	watchers  map[*watcher]struct{}
+}
+
+// watcher is a notification of the engine.
+type watcher struct {
+	// accepts reports whether the input counts as activity for the watcher.
+	accepts func(device *inputDevice, kind Activity) bool
+	// emit is called with the engine's lock held and must not block.
+	emit func(event Event)
+
+	// The fields below are guarded by the lock of the engine.
+
+	duration time.Duration
+	last     time.Time
+	idle     bool
+}
+
+func newEngine(clk clock) *engine {
+	return &engine{
+		clock:     clk,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		lastInput: clk.Now(),
+		watchers:  make(map[*watcher]struct{}),
+	}
+}
+
+// add starts w, it idles once there was no accepted input for its duration.
+func (e *engine) add(w *watcher) {
+	e.mu.Lock()
+	w.last = e.clock.Now()
+	e.watchers[w] = struct{}{}
+	e.mu.Unlock()
+
+	e.poke()
+}
+
+func (e *engine) remove(w *watcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.watchers, w)
+}
+
+// setDuration changes the duration of w. If w is idle and the new duration has not passed since
+// its last activity, it resumes.
+func (e *engine) setDuration(w *watcher, d time.Duration) {
+	e.mu.Lock()
+	now := e.clock.Now()
+	w.duration = d
+	if w.idle && now.Sub(w.last) < d {
+		w.idle = false
+		w.emit(Event{Kind: EventResume, Time: now, Duration: d})
+	}
+	e.mu.Unlock()
+
+	e.poke()
+}
+
+// input records input of the given kind on device. Watchers that accept it and are idle resume.
+func (e *engine) input(device *inputDevice, kind Activity) {
+	e.mu.Lock()
+	now := e.clock.Now()
+	e.lastInput = now
+	resumed := false
+	for w := range e.watchers {
+		if !w.accepts(device, kind) {
+			continue
+		}
+
+		w.last = now
+		if w.idle {
+			w.idle = false
+			resumed = true
+			w.emit(Event{Kind: EventResume, Time: now, Duration: w.duration})
+		}
+	}
+	e.mu.Unlock()
+
+	// A watcher that resumed has a deadline again
+	if resumed {
+		e.poke()
+	}
+}
+
+// report records activity at t that the devices did not see, for every watcher. Watchers that
+// are idle resume unless their duration passed since t.
+//
+// t is read from the clock of the caller, which can differ from the clock of the engine, e.g.
+// when t has no monotonic reading or was taken on another machine. Only the age of t is used so
+// that the deadlines stay on the clock of the engine; activity in the future happened now.
+func (e *engine) report(t time.Time) {
+	e.mu.Lock()
+	now := e.clock.Now()
+	at := now.Add(-max(now.Sub(t), 0))
+	if at.After(e.lastInput) {
+		e.lastInput = at
+	}
+	resumed := false
+	for w := range e.watchers {
+		if !at.After(w.last) {
+			continue
+		}
+
+		w.last = at
+		if w.idle && now.Sub(at) < w.duration {
+			w.idle = false
+			resumed = true
+			w.emit(Event{Kind: EventResume, Time: now, Duration: w.duration})
+		}
+	}
+	e.mu.Unlock()
+
+	// A watcher that resumed has a deadline again
+	if resumed {
+		e.poke()
+	}
+}
+
+// idleSince returns since when there was no input, see Controller.IdleSince.
+func (e *engine) idleSince() (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.clock.Now().Sub(e.lastInput) < activityDuration {
+		return time.Time{}, false
+	}
+
+	return e.lastInput, true
+}
+
+// expire idles the watchers whose duration passed since their last activity. Returns the
+// earliest deadline of the watchers that are not idle, zero if there is none.
+func (e *engine) expire() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.clock.Now()
+	var next time.Time
+	for w := range e.watchers {
+		if w.idle {
+			continue
+		}
+
+		deadline := w.last.Add(w.duration)
+		if now.Before(deadline) {
+			if next.IsZero() || deadline.Before(next) {
+				next = deadline
+			}
+			continue
+		}
+
+		w.idle = true
+		w.emit(Event{Kind: EventIdle, Time: now, Duration: w.duration})
+	}
+
+	return next
+}
+
+// run fires the deadlines of the watchers until close is called.
+func (e *engine) run() {
+	defer close(e.stopped)
+
+	var timer clockTimer
+	var armed time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		next := e.expire()
+		if !next.Equal(armed) {
+			if timer != nil {
+				timer.Stop()
+				timer = nil
+			}
+			if !next.IsZero() {
+				timer = e.clock.NewTimer(next.Sub(e.clock.Now()))
+			}
+			armed = next
+		}
+
+		var timeout <-chan time.Time
+		if timer != nil {
+			timeout = timer.C()
+		}
+
+		select {
+		case <-timeout:
+			// The timer fired, a new one is needed even for the same deadline
+			timer = nil
+			armed = time.Time{}
+		case <-e.wake:
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// poke makes run recompute the earliest deadline.
+func (e *engine) poke() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops run and waits for it to return. It must only be called once run was started.
+func (e *engine) close() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+	<-e.stopped
+}
@@ -0,0 +1,187 @@
+package idle
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+type recordedWatcher struct {
+	*watcher
+	events []EventKind
+}
+
+func newRecordedWatcher(d time.Duration, accepts Activity) *recordedWatcher {
+	r := &recordedWatcher{}
+	r.watcher = &watcher{
+		accepts: func(device *inputDevice, kind Activity) bool {
+			return kind&accepts != 0
+		},
+		emit: func(event Event) {
+			r.events = append(r.events, event.Kind)
+		},
+		duration: d,
+	}
+
+	return r
+}
+
+func TestEngine(t *testing.T) {
+	clk := newFakeClock()
+	start := clk.Now()
+	e := newEngine(clk)
+	keyboard := newRecordedWatcher(time.Minute, ActivityKeyboard)
+	all := newRecordedWatcher(2*time.Minute, ActivityAll)
+	e.add(keyboard.watcher)
+	e.add(all.watcher)
+	device := &inputDevice{path: "/dev/input/event0"}
+
+	if next := e.expire(); !next.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expire() = %s, want the deadline of the keyboard watcher", next)
+	}
+
+	// Pointer input does not reset the keyboard watcher
+	clk.set(start.Add(30 * time.Second))
+	e.input(device, ActivityPointer)
+	clk.set(start.Add(time.Minute))
+	if next := e.expire(); !next.Equal(start.Add(150 * time.Second)) {
+		t.Fatalf("expire() = %s, want the deadline of the other watcher", next)
+	}
+	if !slices.Equal(keyboard.events, []EventKind{EventIdle}) || len(all.events) != 0 {
+		t.Fatalf("events = %v and %v, want only the keyboard watcher idle", keyboard.events, all.events)
+	}
+
+	clk.set(start.Add(3 * time.Minute))
+	if next := e.expire(); !next.IsZero() {
+		t.Fatalf("expire() = %s with every watcher idle, want zero", next)
+	}
+	since, idle := e.idleSince()
+	if !idle || !since.Equal(start.Add(30*time.Second)) {
+		t.Fatalf("idleSince() = %s %t, want the time of the pointer input", since, idle)
+	}
+
+	e.input(device, ActivityKeyboard)
+	if !slices.Equal(keyboard.events, []EventKind{EventIdle, EventResume}) ||
+		!slices.Equal(all.events, []EventKind{EventIdle, EventResume}) {
+		t.Fatalf("events = %v and %v, want both resumed", keyboard.events, all.events)
+	}
+	if _, idle := e.idleSince(); idle {
+		t.Fatal("idleSince() reports idle right after input")
+	}
+
+	// A shorter duration idles, a longer one resumes
+	e.setDuration(all.watcher, time.Second)
+	clk.set(start.Add(3*time.Minute + 5*time.Second))
+	e.expire()
+	e.setDuration(all.watcher, time.Minute)
+	if want := []EventKind{EventIdle, EventResume, EventIdle, EventResume}; !slices.Equal(all.events, want) {
+		t.Fatalf("events after setDuration = %v, want %v", all.events, want)
+	}
+
+	e.remove(keyboard.watcher)
+	clk.set(start.Add(time.Hour))
+	e.expire()
+	if len(keyboard.events) != 2 {
+		t.Fatalf("removed watcher received %v", keyboard.events)
+	}
+}
+
+func TestEngineRun(t *testing.T) {
+	clk := newFakeClock()
+	start := clk.Now()
+	e := newEngine(clk)
+	go e.run()
+	defer e.close()
+
+	events := make(chan EventKind, 4)
+	w := &watcher{
+		accepts:  func(*inputDevice, Activity) bool { return true },
+		emit:     func(event Event) { events <- event.Kind },
+		duration: 10 * time.Second,
+	}
+	e.add(w)
+
+	timer := clk.nextTimer(t)
+	if timer.d != 10*time.Second {
+		t.Fatalf("timer duration = %s, want 10s", timer.d)
+	}
+
+	clk.set(start.Add(10 * time.Second))
+	timer.c <- clk.Now()
+	if kind := <-events; kind != EventIdle {
+		t.Fatalf("event = %s, want idle", kind)
+	}
+
+	// Resuming arms the timer again
+	clk.set(start.Add(12 * time.Second))
+	e.input(&inputDevice{}, ActivityPointer)
+	if kind := <-events; kind != EventResume {
+		t.Fatalf("event = %s, want resume", kind)
+	}
+	if timer := clk.nextTimer(t); timer.d != 10*time.Second {
+		t.Fatalf("timer duration after resume = %s, want 10s", timer.d)
+	}
+}
+
+func TestEngineReport(t *testing.T) {
+	tests := []struct {
+		name string
+		// reports are the times of the reported activity relative to the start, the watcher has
+		// a duration of a minute and is idle when they are reported after 90 seconds.
+		reports    []time.Duration
+		wantEvents []EventKind
+		// wantDeadline is the deadline of the watcher relative to the start, zero when it is idle.
+		wantDeadline time.Duration
+	}{
+		{
+			name:         "recent activity resumes",
+			reports:      []time.Duration{80 * time.Second},
+			wantEvents:   []EventKind{EventIdle, EventResume},
+			wantDeadline: 140 * time.Second,
+		},
+		{
+			name:       "activity older than the duration",
+			reports:    []time.Duration{20 * time.Second},
+			wantEvents: []EventKind{EventIdle},
+		},
+		{
+			name:         "activity in the future happened now",
+			reports:      []time.Duration{time.Hour},
+			wantEvents:   []EventKind{EventIdle, EventResume},
+			wantDeadline: 150 * time.Second,
+		},
+		{
+			name:         "activity before the last activity",
+			reports:      []time.Duration{80 * time.Second, 70 * time.Second},
+			wantEvents:   []EventKind{EventIdle, EventResume},
+			wantDeadline: 140 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock()
+			start := clk.Now()
+			e := newEngine(clk)
+			w := newRecordedWatcher(time.Minute, ActivityKeyboard)
+			e.add(w.watcher)
+			clk.set(start.Add(90 * time.Second))
+			e.expire()
+
+			for _, at := range tt.reports {
+				e.report(start.Add(at))
+			}
+
+			if !slices.Equal(w.events, tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", w.events, tt.wantEvents)
+			}
+			next := e.expire()
+			if tt.wantDeadline == 0 && !next.IsZero() {
+				t.Fatalf("expire() = %s, want zero with the watcher idle", next)
+			}
+			if tt.wantDeadline != 0 && !next.Equal(start.Add(tt.wantDeadline)) {
+				t.Fatalf("expire() = %s, want %s", next, start.Add(tt.wantDeadline))
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package idle
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evdevIdleController follows the input devices of /dev/input directly, see
+// NewEvdevIdleController.
+type evdevIdleController struct {
+	engine *engine
+	done   chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	err     error
+	devices map[*inputDevice]*os.File
+
+	muNotifications sync.Mutex
+	notifications   map[*evdevIdleNotification]struct{}
+}
+
+type evdevIdleNotification struct {
+	controller *evdevIdleController
+	watcher    *watcher
+	fanOut     *fanOut
+	stats      notificationStats
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewEvdevIdleController creates a Controller that reads the input of the evdev devices at paths,
+// e.g. /dev/input/event3 or /dev/input/by-id/usb-Wacom-event-mouse, for systems without display
+// server. Every device in /dev/input is used when no paths are given.
+// Reading the devices requires permission, usually membership of the input group.
+//
+// The Controller sees all input of the devices, regardless of which application has focus or
+// whether a display server is running, and it is not affected by idle inhibitors. It supports
+// CreateIdleNotification.ActivityMask and CreateIdleNotification.Devices, devices do not belong
+// to a seat and SeatName must be empty. Activity of other sources, such as a motion sensor, can
+// be reported using ActivityReporter.
+//
+// The Controller reads the devices on its own goroutines, there are no dispatch functions and
+// Run returns ErrDispatchOwned. When a device is removed, its input is no longer followed; Done
+// is closed when every device failed.
+func NewEvdevIdleController(paths ...string) (Controller, error) {
+	if len(paths) == 0 {
+		var err error
+		paths, err = filepath.Glob("/dev/input/event*")
+		if err != nil {
+			return nil, fmt.Errorf("could not list input devices: %w", err)
+		}
+		if len(paths) == 0 {
+			return nil, errors.New("no input devices found in /dev/input")
+		}
+	}
+
+	return newEvdevIdleController(paths, realClock{})
+}
+
+func newEvdevIdleController(paths []string, clk clock) (*evdevIdleController, error) {
+	m := &evdevIdleController{
+		engine:        newEngine(clk),
+		done:          make(chan struct{}),
+		devices:       make(map[*inputDevice]*os.File, len(paths)),
+		notifications: make(map[*evdevIdleNotification]struct{}),
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			for _, f := range m.devices {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("could not open input device: %w", err)
+		}
+		m.devices[describeDevice(path)] = f
+	}
+
+	go m.engine.run()
+	for device, f := range m.devices {
+		go m.read(device, f)
+	}
+
+	return m, nil
+}
+
+// read reports the input of device to the engine until reading fails.
+func (m *evdevIdleController) read(device *inputDevice, f *os.File) {
+	err := readEvents(f, func(typ uint16, code uint16) {
+		if kind, ok := classifyEvent(typ, code, device.class); ok {
+			m.engine.input(device, kind)
+		}
+	})
+
+	m.removeDevice(device, err)
+}
+
+// removeDevice stops following device after reading it failed with err. The Controller fails
+// when it was the last device.
+func (m *evdevIdleController) removeDevice(device *inputDevice, err error) {
+	m.mu.Lock()
+	f, ok := m.devices[device]
+	if !ok {
+		// Closed by Close
+		m.mu.Unlock()
+		return
+	}
+	delete(m.devices, device)
+	last := len(m.devices) == 0 && m.err == nil
+	if last {
+		m.err = fmt.Errorf("%w: could not read the last input device %s: %w",
+			ErrConnectionLost, device.path, err)
+		close(m.done)
+	}
+	m.mu.Unlock()
+
+	_ = f.Close()
+	if last {
+		m.engine.close()
+	}
+}
+
+func (m *evdevIdleController) AddNotification(notificationInput *CreateIdleNotification) (Notification, error) {
+	if notificationInput.Idle == nil && notificationInput.Resume == nil &&
+		notificationInput.Events == nil {
+		return nil, fmt.Errorf("either Idle, Resume, or Events is required")
+	}
+
+	if err := m.Err(); err != nil {
+		return nil, err
+	}
+
+	if notificationInput.SeatName != "" {
+		return nil, fmt.Errorf("%w: %q, evdev devices do not belong to a seat",
+			ErrSeatNotFound, notificationInput.SeatName)
+	}
+
+	input := *notificationInput
+	input.Devices = slices.Clone(notificationInput.Devices)
+	n := &evdevIdleNotification{controller: m}
+	n.fanOut = newFanOut(&input, &n.stats, m.done, m.engine.clock)
+	n.watcher = &watcher{
+		accepts: func(device *inputDevice, kind Activity) bool {
+			return acceptsInput(&input, device, kind)
+		},
+		emit:     n.fanOut.emit,
+		duration: input.Duration,
+	}
+
+	m.muNotifications.Lock()
+	m.notifications[n] = struct{}{}
+	m.muNotifications.Unlock()
+
+	m.engine.add(n.watcher)
+
+	return n, nil
+}
+
+func (m *evdevIdleController) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	if m.err == nil {
+		close(m.done)
+	}
+	m.err = ErrControllerClosed
+	devices := m.devices
+	m.devices = nil
+	m.mu.Unlock()
+
+	var err error
+	for device, f := range devices {
+		if closeErr := f.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("error closing input device %s: %w", device.path, closeErr))
+		}
+	}
+	m.engine.close()
+
+	return err
+}
+
+func (m *evdevIdleController) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *evdevIdleController) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+func (m *evdevIdleController) Run(context.Context) error {
+	return ErrDispatchOwned
+}
+
+func (m *evdevIdleController) Seats() []SeatInfo {
+	return nil
+}
+
+func (m *evdevIdleController) Capabilities() Capabilities {
+	return Capabilities{
+		InputIdle:    true,
+		ActivityMask: true,
+		Devices:      true,
+	}
+}
+
+func (m *evdevIdleController) IdleSince() (time.Time, bool, error) {
+	if err := m.Err(); err != nil {
+		return time.Time{}, false, err
+	}
+
+	since, idle := m.engine.idleSince()
+	return since, idle, nil
+}
+
+func (m *evdevIdleController) IsIdleFor(d time.Duration) (bool, error) {
+	since, idle, err := m.IdleSince()
+	if err != nil || !idle {
+		return false, err
+	}
+
+	return m.engine.clock.Now().Sub(since) >= d, nil
+}
+
+func (m *evdevIdleController) ReportActivity(t time.Time) error {
+	if err := m.Err(); err != nil {
+		return err
+	}
+
+	m.engine.report(t)
+	return nil
+}
+
+func (m *evdevIdleController) Stats() ControllerStats {
+	m.muNotifications.Lock()
+	defer m.muNotifications.Unlock()
+
+	return ControllerStats{Notifications: len(m.notifications)}
+}
+
+func (m *evdevIdleController) Debug() string {
+	m.mu.Lock()
+	devices := make([]*inputDevice, 0, len(m.devices))
+	for device := range m.devices {
+		devices = append(devices, device)
+	}
+	m.mu.Unlock()
+	slices.SortFunc(devices, func(a, b *inputDevice) int {
+		return cmp.Compare(a.path, b.path)
+	})
+
+	m.muNotifications.Lock()
+	notifications := make([]*evdevIdleNotification, 0, len(m.notifications))
+	for n := range m.notifications {
+		notifications = append(notifications, n)
+	}
+	m.muNotifications.Unlock()
+	slices.SortFunc(notifications, func(a, b *evdevIdleNotification) int {
+		return cmp.Compare(a.getDuration(), b.getDuration())
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "evdev idle controller, %d device(s), %d notification(s)\n",
+		len(devices), len(notifications))
+	for _, device := range devices {
+		fmt.Fprintf(&b, "  device %s: %q, %s\n", device.path, device.name, device.class)
+	}
+	for _, n := range notifications {
+		fmt.Fprintf(&b, "  %s: %s\n", n.getDuration(), n.Stats())
+	}
+
+	return b.String()
+}
+
+func (n *evdevIdleNotification) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil
+	}
+	n.closed = true
+
+	n.controller.engine.remove(n.watcher)
+	n.fanOut.close()
+	n.controller.muNotifications.Lock()
+	delete(n.controller.notifications, n)
+	n.controller.muNotifications.Unlock()
+
+	return nil
+}
+
+func (n *evdevIdleNotification) SetDuration(d time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNotificationClosed
+	}
+
+	n.controller.engine.setDuration(n.watcher, d)
+
+	return nil
+}
+
+func (n *evdevIdleNotification) getDuration() time.Duration {
+	n.controller.engine.mu.Lock()
+	defer n.controller.engine.mu.Unlock()
+
+	return n.watcher.duration
+}
+
+func (n *evdevIdleNotification) Stats() NotificationStats {
+	return n.stats.snapshot()
+}
+
+func (n *evdevIdleNotification) ResetStats() {
+	n.stats.reset()
+}
+
+func (n *evdevIdleNotification) LastIdlePeriod() (IdlePeriod, bool) {
+	return n.fanOut.lastIdlePeriod()
+}
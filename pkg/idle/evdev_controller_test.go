@@ -0,0 +1,339 @@
+package idle
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/idle/idletest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		typ   uint16
+		code  uint16
+		class Activity
+		want  Activity
+		input bool
+	}{
+		{"key", evKey, 30, ActivityKeyboard, ActivityKeyboard, true},
+		{"mouse button", evKey, btnMouse, ActivityPointer, ActivityPointer, true},
+		{"stylus", evKey, btnStylus, ActivityTablet, ActivityTablet, true},
+		{"pen touch", evKey, btnTouch, ActivityTablet, ActivityTablet, true},
+		{"finger touch", evKey, btnTouch, ActivityTouch, ActivityTouch, true},
+		{"motion", evRel, 0, ActivityPointer, ActivityPointer, true},
+		{"axis", evAbs, 0, ActivityTablet, ActivityTablet, true},
+		{"joystick axis", evAbs, 0, 0, 0, true},
+		{"synchronization", 0, 0, ActivityKeyboard, 0, false},
+		{"lid switch", 0x05, 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, input := classifyEvent(tt.typ, tt.code, tt.class)
+			if got != tt.want || input != tt.input {
+				t.Errorf("classifyEvent() = %s %t, want %s %t", got, input, tt.want, tt.input)
+			}
+		})
+	}
+}
+
+func TestAcceptsInput(t *testing.T) {
+	pen := &inputDevice{
+		path:     "/dev/input/by-id/usb-Wacom-event-mouse",
+		resolved: "/dev/input/event4",
+		name:     "Wacom Intuos S Pen",
+	}
+	keyboard := &inputDevice{path: "/dev/input/event2", resolved: "/dev/input/event2", name: "AT keyboard"}
+
+	tests := []struct {
+		name     string
+		input    CreateIdleNotification
+		device   *inputDevice
+		kind     Activity
+		accepted bool
+	}{
+		{"everything", CreateIdleNotification{}, keyboard, 0, true},
+		{"name", CreateIdleNotification{Devices: []DeviceMatch{{Name: "*Pen"}}}, pen, ActivityTablet, true},
+		{"other name", CreateIdleNotification{Devices: []DeviceMatch{{Name: "*Pen"}}}, keyboard, ActivityKeyboard, false},
+		{"resolved path", CreateIdleNotification{Devices: []DeviceMatch{{Path: "/dev/input/event4"}}}, pen, ActivityTablet, true},
+		{"link path", CreateIdleNotification{Devices: []DeviceMatch{{Path: "/dev/input/by-id/*"}}}, pen, ActivityTablet, true},
+		{"all fields", CreateIdleNotification{Devices: []DeviceMatch{{Path: "/dev/input/event4", Name: "AT*"}}}, pen, ActivityTablet, false},
+		{"any match", CreateIdleNotification{Devices: []DeviceMatch{{Name: "*Pen"}, {Name: "AT*"}}}, keyboard, ActivityKeyboard, true},
+		{"mask", CreateIdleNotification{ActivityMask: ActivityTablet}, pen, ActivityTablet, true},
+		{"masked", CreateIdleNotification{ActivityMask: ActivityTablet}, keyboard, ActivityKeyboard, false},
+		{"unclassified", CreateIdleNotification{ActivityMask: ActivityTablet}, pen, 0, false},
+		{"unclassified all", CreateIdleNotification{ActivityMask: ActivityAll}, pen, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceptsInput(&tt.input, tt.device, tt.kind); got != tt.accepted {
+				t.Errorf("acceptsInput() = %t, want %t", got, tt.accepted)
+			}
+		})
+	}
+}
+
+// fakeEvdevDevice creates a FIFO at dir/name that is read like an evdev device, and the sysfs
+// entry of a device with the given name and key capabilities below sysfsInputDir.
+func fakeEvdevDevice(t *testing.T, dir string, name string, deviceName string, keys ...int) *os.File {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Opening for reading and writing does not block until there is a reader
+	w, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+
+	sysfs := filepath.Join(sysfsInputDir, name, "device")
+	if err := os.MkdirAll(filepath.Join(sysfs, "capabilities"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	words := make([]uint64, 12)
+	for _, key := range keys {
+		words[key/64] |= 1 << (key % 64)
+	}
+	var bitmap []string
+	for i := len(words) - 1; i >= 0; i-- {
+		bitmap = append(bitmap, strconv.FormatUint(words[i], 16))
+	}
+	files := map[string]string{
+		"name":             deviceName,
+		"capabilities/key": strings.Join(bitmap, " "),
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(sysfs, file), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w
+}
+
+func writeEvdevEvent(t *testing.T, w *os.File, typ uint16, code uint16) {
+	t.Helper()
+
+	event := make([]byte, eventSize)
+	binary.NativeEndian.PutUint16(event[eventSize-8:], typ)
+	binary.NativeEndian.PutUint16(event[eventSize-6:], code)
+	if _, err := w.Write(event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitInput waits until the controller processed input at the current time of clk.
+func waitInput(t *testing.T, m *evdevIdleController, clk *fakeClock) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		m.engine.mu.Lock()
+		processed := m.engine.lastInput.Equal(clk.Now())
+		m.engine.mu.Unlock()
+		if processed {
+			return
+		}
+	}
+	t.Fatal("input not processed")
+}
+
+func receiveEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestEvdevIdleController(t *testing.T) {
+	dir := t.TempDir()
+	oldSysfs := sysfsInputDir
+	sysfsInputDir = filepath.Join(dir, "sys")
+	t.Cleanup(func() { sysfsInputDir = oldSysfs })
+
+	pen := fakeEvdevDevice(t, dir, "event1", "Test Pen", btnToolPen, btnTouch, btnStylus)
+	keyboard := fakeEvdevDevice(t, dir, "event2", "Test Keyboard", 30)
+
+	clk := newFakeClock()
+	start := clk.Now()
+	m, err := newEvdevIdleController([]string{pen.Name(), keyboard.Name()}, clk)
+	if err != nil {
+		t.Fatalf("newEvdevIdleController() error = %v", err)
+	}
+	defer m.Close()
+
+	if _, err := m.AddNotification(&CreateIdleNotification{
+		Idle:     make(chan struct{}),
+		SeatName: "seat0",
+	}); !errors.Is(err, ErrSeatNotFound) {
+		t.Fatalf("AddNotification() with a seat error = %v, want ErrSeatNotFound", err)
+	}
+
+	events := make(chan Event, 4)
+	n, err := m.AddNotification(&CreateIdleNotification{
+		Duration: 10 * time.Second,
+		Devices:  []DeviceMatch{{Name: "*Pen"}},
+		Events:   events,
+	})
+	if err != nil {
+		t.Fatalf("AddNotification() error = %v", err)
+	}
+	defer n.Close()
+	timer := clk.nextTimer(t)
+
+	// Typing does not keep the notification from idling
+	clk.set(start.Add(5 * time.Second))
+	writeEvdevEvent(t, keyboard, evKey, 30)
+	waitInput(t, m, clk)
+	clk.set(start.Add(10 * time.Second))
+	timer.c <- clk.Now()
+	if event := receiveEvent(t, events); event.Kind != EventIdle {
+		t.Fatalf("event = %s, want idle", event.Kind)
+	}
+
+	// Axis events of the pen resume
+	clk.set(start.Add(12 * time.Second))
+	writeEvdevEvent(t, pen, evAbs, 0)
+	if event := receiveEvent(t, events); event.Kind != EventResume {
+		t.Fatalf("event = %s, want resume", event.Kind)
+	}
+	if timer := clk.nextTimer(t); timer.d != 10*time.Second {
+		t.Fatalf("timer duration after resume = %s, want 10s", timer.d)
+	}
+
+	if !strings.Contains(m.Debug(), `"Test Pen", tablet`) {
+		t.Errorf("Debug() = %q, want the pen with its class", m.Debug())
+	}
+
+	// Removing a device keeps the controller running, removing the last one fails it
+	_ = keyboard.Close()
+	_ = pen.Close()
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after every device was removed")
+	}
+	if err := m.Err(); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("Err() = %v, want ErrConnectionLost", err)
+	}
+}
+
+func TestEvdevIdleControllerReportActivity(t *testing.T) {
+	dir := t.TempDir()
+	oldSysfs := sysfsInputDir
+	sysfsInputDir = filepath.Join(dir, "sys")
+	t.Cleanup(func() { sysfsInputDir = oldSysfs })
+
+	pen := fakeEvdevDevice(t, dir, "event1", "Test Pen", btnToolPen, btnTouch, btnStylus)
+
+	clk := newFakeClock()
+	start := clk.Now()
+	m, err := newEvdevIdleController([]string{pen.Name()}, clk)
+	if err != nil {
+		t.Fatalf("newEvdevIdleController() error = %v", err)
+	}
+	var controller Controller = m
+	reporter, ok := controller.(ActivityReporter)
+	if !ok {
+		t.Fatal("the evdev controller does not implement ActivityReporter")
+	}
+
+	events := make(chan Event, 4)
+	n, err := m.AddNotification(&CreateIdleNotification{
+		Duration: 10 * time.Second,
+		Devices:  []DeviceMatch{{Name: "*Pen"}},
+		Events:   events,
+	})
+	if err != nil {
+		t.Fatalf("AddNotification() error = %v", err)
+	}
+	defer n.Close()
+	timer := clk.nextTimer(t)
+	clk.set(start.Add(20 * time.Second))
+	timer.c <- clk.Now()
+	if event := receiveEvent(t, events); event.Kind != EventIdle {
+		t.Fatalf("event = %s, want idle", event.Kind)
+	}
+
+	// Reported activity resumes regardless of Devices
+	if err := reporter.ReportActivity(start.Add(15 * time.Second)); err != nil {
+		t.Fatalf("ReportActivity() error = %v", err)
+	}
+	if event := receiveEvent(t, events); event.Kind != EventResume {
+		t.Fatalf("event = %s, want resume", event.Kind)
+	}
+	if timer := clk.nextTimer(t); timer.d != 5*time.Second {
+		t.Fatalf("timer duration after the report = %s, want the 5s left since the activity", timer.d)
+	}
+	since, idle, err := m.IdleSince()
+	if err != nil || !idle || !since.Equal(start.Add(15*time.Second)) {
+		t.Fatalf("IdleSince() = %s %t %v, want the reported activity", since, idle, err)
+	}
+
+	_ = m.Close()
+	if err := reporter.ReportActivity(clk.Now()); !errors.Is(err, ErrControllerClosed) {
+		t.Fatalf("ReportActivity() after Close error = %v, want ErrControllerClosed", err)
+	}
+}
+
+func TestEvdevIdleControllerUinput(t *testing.T) {
+	const btnStylus = 0x14b
+	const keyA = 30
+	stylus := idletest.NewUinputDevice(t, "idle test stylus", btnStylus)
+	keyboard := idletest.NewUinputDevice(t, "idle test keyboard", keyA)
+
+	c, err := NewEvdevIdleController(stylus.Path, keyboard.Path)
+	if err != nil {
+		t.Fatalf("NewEvdevIdleController() error = %v", err)
+	}
+	defer c.Close()
+
+	idle := make(chan struct{}, 1)
+	resume := make(chan struct{}, 1)
+	n, err := c.AddNotification(&CreateIdleNotification{
+		Duration: 200 * time.Millisecond,
+		Devices:  []DeviceMatch{{Name: stylus.Name}},
+		Idle:     idle,
+		Resume:   resume,
+	})
+	if err != nil {
+		t.Fatalf("AddNotification() error = %v", err)
+	}
+	defer n.Close()
+
+	for range 10 {
+		if err := keyboard.Press(keyA); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("keyboard input kept the stylus notification from idling")
+	}
+
+	if err := stylus.Press(btnStylus); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-resume:
+	case <-time.After(time.Second):
+		t.Fatal("stylus input did not resume")
+	}
+}
@@ -0,0 +1,189 @@
+package idle
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// inputDevice is an input device observed by a controller, see DeviceMatch.
+type inputDevice struct {
+	// path is the path the device was opened with.
+	path string
+	// resolved is path with symbolic links resolved, e.g. /dev/input/event3 for a path in
+	// /dev/input/by-id.
+	resolved string
+	// name is the name the device reports, empty if it is not known.
+	name string
+	// class is the kind of input of the axes of the device, which the axis events do not tell.
+	// Zero if it is not known.
+	class Activity
+}
+
+// matches reports whether the device is selected by m.
+func (m DeviceMatch) matches(device *inputDevice) bool {
+	if m.Path != "" && !matchPattern(m.Path, device.path) && !matchPattern(m.Path, device.resolved) {
+		return false
+	}
+	if m.Name != "" && !matchPattern(m.Name, device.name) {
+		return false
+	}
+
+	return true
+}
+
+func matchPattern(pattern string, s string) bool {
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
+
+// acceptsInput returns whether input of kind on device counts as activity for the notification.
+// Input that is not classified only counts when every kind of input does.
+func acceptsInput(input *CreateIdleNotification, device *inputDevice, kind Activity) bool {
+	if mask := input.ActivityMask; mask != 0 && mask&ActivityAll != ActivityAll && mask&kind == 0 {
+		return false
+	}
+	if len(input.Devices) == 0 {
+		return true
+	}
+	for _, m := range input.Devices {
+		if m.matches(device) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event types and codes of linux/input-event-codes.h.
+const (
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+
+	btnMisc       = 0x100
+	btnMouse      = 0x110
+	btnJoystick   = 0x120
+	btnToolPen    = 0x140
+	btnToolFinger = 0x145
+	btnToolMouse  = 0x146
+	btnToolLens   = 0x147
+	btnToolQuint  = 0x148
+	btnStylus3    = 0x149
+	btnTouch      = 0x14a
+	btnStylus     = 0x14b
+	btnStylus2    = 0x14c
+	btnToolQuad   = 0x14f
+	btnWheel      = 0x150
+	keyOk         = 0x160
+)
+
+// classifyEvent returns the kind of input of an event of a device of the given class and whether
+// the event is input at all. The kind is zero for input that is not classified, e.g. of a
+// joystick. Events that are not input, such as synchronization and switches, are ignored.
+func classifyEvent(typ uint16, code uint16, class Activity) (Activity, bool) {
+	switch typ {
+	case evKey:
+		switch {
+		case code < btnMisc || code >= keyOk:
+			return ActivityKeyboard, true
+		case code >= btnMouse && code < btnJoystick:
+			return ActivityPointer, true
+		case code >= btnToolPen && code < btnToolFinger,
+			code == btnToolMouse, code == btnToolLens, code == btnStylus3,
+			code == btnStylus, code == btnStylus2:
+			return ActivityTablet, true
+		case code == btnToolFinger, code == btnToolQuint, code == btnTouch,
+			code > btnStylus2 && code <= btnToolQuad:
+			// BTN_TOUCH is sent by pens too
+			if class == ActivityTablet {
+				return ActivityTablet, true
+			}
+			return ActivityTouch, true
+		case code >= btnWheel:
+			return ActivityPointer, true
+		}
+		return class, true
+	case evRel:
+		return ActivityPointer, true
+	case evAbs:
+		return class, true
+	}
+
+	return 0, false
+}
+
+// eventSize is the size of struct input_event: a struct timeval of two longs, the type, the
+// code, and the value.
+const eventSize = 2*strconv.IntSize/8 + 8
+
+// readEvents reads the events of an evdev device from r and calls f with the type and code of
+// every event. Returns the error that stopped reading.
+func readEvents(r io.Reader, f func(typ uint16, code uint16)) error {
+	br := bufio.NewReaderSize(r, 64*eventSize)
+	event := make([]byte, eventSize)
+	offset := eventSize - 8
+	for {
+		if _, err := io.ReadFull(br, event); err != nil {
+			return err
+		}
+
+		f(binary.NativeEndian.Uint16(event[offset:]), binary.NativeEndian.Uint16(event[offset+2:]))
+	}
+}
+
+// sysfsInputDir is the directory of the input devices in sysfs, a variable for testing.
+var sysfsInputDir = "/sys/class/input"
+
+// describeDevice returns the device opened with path, with the name and class read from sysfs.
+// Devices that are not evdev devices, e.g. in tests, have no name and no class.
+func describeDevice(devicePath string) *inputDevice {
+	device := &inputDevice{path: devicePath, resolved: devicePath}
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		device.resolved = resolved
+	}
+
+	dir := filepath.Join(sysfsInputDir, filepath.Base(device.resolved), "device")
+	if name, err := os.ReadFile(filepath.Join(dir, "name")); err == nil {
+		device.name = strings.TrimSpace(string(name))
+	}
+
+	keys := readCapabilities(filepath.Join(dir, "capabilities", "key"))
+	events := readCapabilities(filepath.Join(dir, "capabilities", "ev"))
+	switch {
+	case keys(btnToolPen) || keys(btnStylus):
+		device.class = ActivityTablet
+	case keys(btnTouch) || keys(btnToolFinger):
+		device.class = ActivityTouch
+	case keys(btnMouse) || events(evRel):
+		device.class = ActivityPointer
+	}
+
+	return device
+}
+
+// readCapabilities reads a capability bitmap of sysfs, hexadecimal words of a long separated by
+// spaces, most significant first. Returns whether a bit is set, false for every bit if the file
+// cannot be read.
+func readCapabilities(file string) func(bit int) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return func(int) bool { return false }
+	}
+
+	words := strings.Fields(string(data))
+	return func(bit int) bool {
+		i := len(words) - 1 - bit/strconv.IntSize
+		if i < 0 {
+			return false
+		}
+
+		word, err := strconv.ParseUint(words[i], 16, 64)
+		return err == nil && word&(1<<(bit%strconv.IntSize)) != 0
+	}
+}
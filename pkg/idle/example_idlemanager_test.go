@@ -1,6 +1,7 @@
 package idle_test
 
 import (
+	"context"
 	"github.com/MatthiasKunnen/system/pkg/idle"
 	"log"
 	"time"
@@ -37,6 +38,40 @@ func Example() {
 		case <-monitorIdle:
 			log.Printf("Monitor idle\n")
 			// turn off monitor here
+		case <-m.Done():
+			// The compositor went away, a new controller can be created once it is back.
+			log.Fatalf("Idle controller stopped: %v", m.Err())
 		}
 	}
 }
+
+func Example_run() {
+	m, _, err := idle.NewWaylandIdleController(idle.WithRun())
+	if err != nil {
+		log.Fatalf("Unable to initialize wayland idle controller: %v", err)
+	}
+	defer m.Close()
+
+	events := make(chan idle.Event, 8)
+	_, err = m.AddNotification(&idle.CreateIdleNotification{
+		Duration: 5 * time.Minute,
+		Events:   events,
+	})
+	if err != nil {
+		log.Fatalf("Failed to add idle notification: %v", err)
+	}
+
+	go func() {
+		for event := range events {
+			log.Printf("Session %s\n", event.Kind)
+		}
+	}()
+
+	for {
+		err := m.Run(context.Background())
+		if m.Err() != nil {
+			log.Fatalf("Idle controller stopped: %v", err)
+		}
+		log.Printf("Dispatch error: %v\n", err)
+	}
+}
@@ -0,0 +1,108 @@
+package idle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// fakeController records the notifications that are added so tests can send events to them.
+type fakeController struct {
+	done chan struct{}
+
+	mu sync.Mutex
+	// events is the Events channel of the last added notification.
+	events        chan<- Event
+	notifications []*fakeNotification
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{done: make(chan struct{})}
+}
+
+func (c *fakeController) AddNotification(input *CreateIdleNotification) (Notification, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := &fakeNotification{input: input, duration: input.Duration}
+	c.events = input.Events
+	c.notifications = append(c.notifications, n)
+
+	return n, nil
+}
+
+// notification returns the open notification with the given duration, nil if there is none.
+func (c *fakeController) notification(d time.Duration) *fakeNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, n := range c.notifications {
+		if !n.isClosed() && n.getDuration() == d {
+			return n
+		}
+	}
+
+	return nil
+}
+
+func (c *fakeController) Close() error                  { return nil }
+func (c *fakeController) Done() <-chan struct{}         { return c.done }
+func (c *fakeController) Err() error                    { return nil }
+func (c *fakeController) Run(ctx context.Context) error { return nil }
+func (c *fakeController) Seats() []SeatInfo             { return nil }
+func (c *fakeController) Capabilities() Capabilities    { return Capabilities{} }
+func (c *fakeController) Stats() ControllerStats        { return ControllerStats{} }
+func (c *fakeController) Debug() string                 { return "" }
+
+func (c *fakeController) IdleSince() (time.Time, bool, error) {
+	return time.Time{}, false, nil
+}
+
+func (c *fakeController) IsIdleFor(d time.Duration) (bool, error) {
+	return false, nil
+}
+
+type fakeNotification struct {
+	input *CreateIdleNotification
+
+	mu       sync.Mutex
+	duration time.Duration
+	closed   bool
+}
+
+func (n *fakeNotification) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	return nil
+}
+
+func (n *fakeNotification) SetDuration(d time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.duration = d
+	return nil
+}
+
+func (n *fakeNotification) Stats() NotificationStats { return NotificationStats{} }
+func (n *fakeNotification) ResetStats()              {}
+func (n *fakeNotification) LastIdlePeriod() (IdlePeriod, bool) {
+	return IdlePeriod{}, false
+}
+
+func (n *fakeNotification) getDuration() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.duration
+}
+
+func (n *fakeNotification) isClosed() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closed
+}
+
+// send delivers an event of the given kind to the notification's Events channel.
+func (n *fakeNotification) send(kind EventKind) {
+	n.input.Events <- Event{Kind: kind, Time: time.Now(), Duration: n.getDuration()}
+}
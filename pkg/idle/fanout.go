@@ -0,0 +1,227 @@
+package idle
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sinkQueueSize is the number of events that are buffered for a channel before new events for
+// that channel are dropped.
+const sinkQueueSize = 16
+
+// fanOut delivers the events of a notification to all of its channels. It is shared by all
+// Controller implementations and defines the delivery semantics:
+//   - Every registered channel receives every event meant for it. Idle only receives idle events,
+//     Resume only receives resume events, and Events receives both.
+//   - Each channel receives its events in the order they were emitted.
+//   - Events are queued per channel so that a slow consumer blocks neither the controller nor the
+//     other channels. If a channel's queue is full, the event is dropped for that channel only and
+//     the drop is counted in the statistics.
+//   - A channel closed by the consumer does not cause a panic, events for it are counted as
+//     dropped.
+//   - Resume events carry the IdlePeriod since the preceding idle event.
+type fanOut struct {
+	sinks    []*sink
+	stats    *notificationStats
+	clock    clock
+	done     <-chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu sync.Mutex
+	// idle is set between an idle event and the next resume event, which started at idleTime
+	// and idleMonotonic.
+	idle          bool
+	idleTime      time.Time
+	idleMonotonic time.Duration
+	// lastPeriod is the IdlePeriod of the last resume event, valid if hasLastPeriod is set.
+	lastPeriod    IdlePeriod
+	hasLastPeriod bool
+}
+
+type sink struct {
+	accepts func(kind EventKind) bool
+	send    func(event Event, done <-chan struct{}, stop <-chan struct{}) bool
+	queue   chan Event
+	dropped *atomic.Uint64
+	// closed is set when the consumer closed the channel. Only accessed by the sink's goroutine.
+	closed bool
+}
+
+// newFanOut creates a fanOut for the channels of the input. Delivery stops once done is closed
+// or close is called. clk is the clock of the Time of the events.
+func newFanOut(
+	input *CreateIdleNotification,
+	stats *notificationStats,
+	done <-chan struct{},
+	clk clock,
+) *fanOut {
+	f := &fanOut{
+		stats: stats,
+		clock: clk,
+		done:  done,
+		stop:  make(chan struct{}),
+	}
+
+	if input.Idle != nil {
+		f.sinks = append(f.sinks, &sink{
+			accepts: func(kind EventKind) bool { return kind == EventIdle },
+			send:    signalSender(input.Idle),
+			dropped: &stats.idleDropped,
+		})
+	}
+
+	if input.Resume != nil {
+		f.sinks = append(f.sinks, &sink{
+			accepts: func(kind EventKind) bool { return kind == EventResume },
+			send:    signalSender(input.Resume),
+			dropped: &stats.resumeDropped,
+		})
+	}
+
+	if input.Events != nil {
+		c := input.Events
+		f.sinks = append(f.sinks, &sink{
+			accepts: func(kind EventKind) bool { return true },
+			send: func(event Event, done <-chan struct{}, stop <-chan struct{}) bool {
+				select {
+				case c <- event:
+					return true
+				case <-done:
+				case <-stop:
+				}
+				return false
+			},
+			dropped: &stats.eventsDropped,
+		})
+	}
+
+	for _, s := range f.sinks {
+		s.queue = make(chan Event, sinkQueueSize)
+		go f.run(s)
+	}
+
+	return f
+}
+
+func signalSender(c chan<- struct{}) func(Event, <-chan struct{}, <-chan struct{}) bool {
+	return func(event Event, done <-chan struct{}, stop <-chan struct{}) bool {
+		select {
+		case c <- struct{}{}:
+			return true
+		case <-done:
+		case <-stop:
+		}
+		return false
+	}
+}
+
+// emit queues the event for all channels that accept it. It never blocks.
+func (f *fanOut) emit(event Event) {
+	event = f.measure(event)
+	f.stats.record(event)
+
+	for _, s := range f.sinks {
+		if !s.accepts(event.Kind) {
+			continue
+		}
+
+		select {
+		case s.queue <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// measure records the start of an idle period and sets the IdlePeriod of the resume event that
+// ends it.
+func (f *fanOut) measure(event Event) Event {
+	monotonic := f.clock.Monotonic()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch event.Kind {
+	case EventIdle:
+		f.idle = true
+		f.idleTime = event.Time
+		f.idleMonotonic = monotonic
+	case EventResume:
+		if !f.idle {
+			break
+		}
+		f.idle = false
+		event.IdlePeriod = IdlePeriod{
+			// Round strips the monotonic clock readings, Sub would use them otherwise
+			Wall:      event.Time.Round(0).Sub(f.idleTime.Round(0)),
+			Monotonic: monotonic - f.idleMonotonic,
+		}
+		f.lastPeriod = event.IdlePeriod
+		f.hasLastPeriod = true
+	}
+
+	return event
+}
+
+// lastIdlePeriod implements Notification.LastIdlePeriod.
+func (f *fanOut) lastIdlePeriod() (IdlePeriod, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastPeriod, f.hasLastPeriod
+}
+
+// close stops delivery. Events that have not been delivered yet are dropped.
+func (f *fanOut) close() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+func (f *fanOut) run(s *sink) {
+	for {
+		select {
+		case event := <-s.queue:
+			if !f.deliver(s, event) {
+				s.dropped.Add(1)
+			}
+		case <-f.done:
+			f.drain(s)
+			return
+		case <-f.stop:
+			f.drain(s)
+			return
+		}
+	}
+}
+
+// drain counts the events that are still queued as dropped.
+func (f *fanOut) drain(s *sink) {
+	for {
+		select {
+		case <-s.queue:
+			s.dropped.Add(1)
+		default:
+			return
+		}
+	}
+}
+
+// deliver sends the event to the sink's channel and reports whether it was received.
+func (f *fanOut) deliver(s *sink, event Event) (delivered bool) {
+	if s.closed {
+		return false
+	}
+
+	defer func() {
+		// Sending on a channel that has been closed by the consumer panics
+		if recover() != nil {
+			s.closed = true
+			delivered = false
+		}
+	}()
+
+	return s.send(event, f.done, f.stop)
+}
@@ -0,0 +1,203 @@
+package idle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func receive[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+		panic("unreachable")
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFanOutSinkCombinations(t *testing.T) {
+	kinds := []EventKind{EventIdle, EventResume, EventIdle, EventResume, EventIdle}
+
+	for combination := 1; combination < 8; combination++ {
+		hasIdle := combination&1 != 0
+		hasResume := combination&2 != 0
+		hasEvents := combination&4 != 0
+
+		t.Run(fmt.Sprintf("idle=%t,resume=%t,events=%t", hasIdle, hasResume, hasEvents), func(t *testing.T) {
+			idle := make(chan struct{})
+			resume := make(chan struct{})
+			events := make(chan Event)
+			input := &CreateIdleNotification{}
+			if hasIdle {
+				input.Idle = idle
+			}
+			if hasResume {
+				input.Resume = resume
+			}
+			if hasEvents {
+				input.Events = events
+			}
+
+			var stats notificationStats
+			f := newFanOut(input, &stats, make(chan struct{}), realClock{})
+			defer f.close()
+
+			for i, kind := range kinds {
+				f.emit(Event{Kind: kind, Time: time.Unix(int64(i), 0)})
+			}
+
+			for i, kind := range kinds {
+				if hasEvents {
+					event := receive(t, events)
+					if event.Kind != kind || event.Time.Unix() != int64(i) {
+						t.Fatalf("event %d = %+v, want kind %s at %d", i, event, kind, i)
+					}
+				}
+				if kind == EventIdle && hasIdle {
+					receive(t, idle)
+				}
+				if kind == EventResume && hasResume {
+					receive(t, resume)
+				}
+			}
+
+			got := stats.snapshot()
+			if got.Idle != 3 || got.Resume != 2 || got.Dropped != 0 {
+				t.Fatalf("stats = %+v, want 3 idle, 2 resume, 0 dropped", got)
+			}
+		})
+	}
+}
+
+func TestFanOutSlowSinkDoesNotAffectOthers(t *testing.T) {
+	const count = sinkQueueSize + 4
+	events := make(chan Event, count)
+	var stats notificationStats
+	f := newFanOut(&CreateIdleNotification{
+		// Never read
+		Idle:   make(chan struct{}),
+		Events: events,
+	}, &stats, make(chan struct{}), realClock{})
+	defer f.close()
+
+	for range count {
+		f.emit(Event{Kind: EventIdle})
+		receive(t, events)
+	}
+
+	got := stats.snapshot()
+	// One event can be in flight, blocked on the unread channel
+	if got.IdleDropped < count-sinkQueueSize-1 || got.IdleDropped > count-sinkQueueSize {
+		t.Fatalf("IdleDropped = %d, want %d or %d", got.IdleDropped, count-sinkQueueSize-1, count-sinkQueueSize)
+	}
+	if got.EventsDropped != 0 {
+		t.Fatalf("EventsDropped = %d, want 0", got.EventsDropped)
+	}
+}
+
+func TestFanOutClosedSink(t *testing.T) {
+	idle := make(chan struct{})
+	events := make(chan Event)
+	var stats notificationStats
+	f := newFanOut(&CreateIdleNotification{
+		Idle:   idle,
+		Events: events,
+	}, &stats, make(chan struct{}), realClock{})
+	defer f.close()
+
+	f.emit(Event{Kind: EventIdle})
+	receive(t, idle)
+	receive(t, events)
+
+	// The consumer closes the channel mid-stream
+	close(idle)
+
+	for range 3 {
+		f.emit(Event{Kind: EventIdle})
+		receive(t, events)
+	}
+
+	waitFor(t, func() bool {
+		return stats.snapshot().IdleDropped == 3
+	})
+}
+
+func TestFanOutClose(t *testing.T) {
+	idle := make(chan struct{})
+	var stats notificationStats
+	f := newFanOut(&CreateIdleNotification{Idle: idle}, &stats, make(chan struct{}), realClock{})
+
+	f.emit(Event{Kind: EventIdle})
+	f.close()
+	f.close()
+
+	waitFor(t, func() bool {
+		return stats.snapshot().IdleDropped == 1
+	})
+
+	select {
+	case <-idle:
+		t.Fatalf("event delivered after close")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestFanOutIdlePeriod(t *testing.T) {
+	clk := newFakeClock()
+	start := clk.Now()
+	events := make(chan Event, 4)
+	var stats notificationStats
+	f := newFanOut(&CreateIdleNotification{Events: events}, &stats, make(chan struct{}), clk)
+	defer f.close()
+
+	if _, ok := f.lastIdlePeriod(); ok {
+		t.Fatalf("lastIdlePeriod() reported a period before any event")
+	}
+
+	// A resume without a preceding idle event has no period
+	f.emit(Event{Kind: EventResume, Time: clk.Now()})
+	if event := receive(t, events); event.IdlePeriod != (IdlePeriod{}) {
+		t.Fatalf("IdlePeriod = %+v, want zero without idle event", event.IdlePeriod)
+	}
+
+	f.emit(Event{Kind: EventIdle, Time: clk.Now()})
+	if event := receive(t, events); event.IdlePeriod != (IdlePeriod{}) {
+		t.Fatalf("IdlePeriod of idle event = %+v, want zero", event.IdlePeriod)
+	}
+	clk.set(start.Add(47 * time.Minute))
+	f.emit(Event{Kind: EventResume, Time: clk.Now()})
+	want := IdlePeriod{Wall: 47 * time.Minute, Monotonic: 47 * time.Minute}
+	if event := receive(t, events); event.IdlePeriod != want {
+		t.Fatalf("IdlePeriod = %+v, want %+v", event.IdlePeriod, want)
+	}
+
+	// The wall clock includes a suspend during the period, the monotonic clock does not
+	f.emit(Event{Kind: EventIdle, Time: clk.Now()})
+	receive(t, events)
+	clk.set(clk.Now().Add(5 * time.Minute))
+	clk.suspend(8 * time.Hour)
+	clk.set(clk.Now().Add(time.Minute))
+	f.emit(Event{Kind: EventResume, Time: clk.Now()})
+	want = IdlePeriod{Wall: 8*time.Hour + 6*time.Minute, Monotonic: 6 * time.Minute}
+	if event := receive(t, events); event.IdlePeriod != want {
+		t.Fatalf("IdlePeriod spanning suspend = %+v, want %+v", event.IdlePeriod, want)
+	}
+	if period, ok := f.lastIdlePeriod(); !ok || period != want {
+		t.Fatalf("lastIdlePeriod() = %+v, %t, want %+v", period, ok, want)
+	}
+}
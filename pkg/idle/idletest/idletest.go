@@ -0,0 +1,88 @@
+// Package idletest provides infrastructure to test idle handling against a real compositor.
+//
+// StartCompositor launches a headless sway in a temporary XDG_RUNTIME_DIR and NewVirtualPointer
+// synthesizes input on it, which lets tests drive idle and resume transitions end to end.
+// NewUinputDevice creates virtual evdev devices for controllers that read /dev/input.
+package idletest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Compositor is a headless compositor started by StartCompositor.
+type Compositor struct {
+	// RuntimeDir is the XDG_RUNTIME_DIR of the compositor.
+	RuntimeDir string
+
+	// Display is the name of the Wayland socket in RuntimeDir, e.g. "wayland-1".
+	Display string
+}
+
+// StartCompositor starts a headless sway and points XDG_RUNTIME_DIR and WAYLAND_DISPLAY of the
+// test at it. The compositor is stopped when the test ends.
+// The test is skipped if sway is not installed.
+func StartCompositor(t testing.TB) *Compositor {
+	t.Helper()
+
+	path, err := exec.LookPath("sway")
+	if err != nil {
+		t.Skip("sway not installed")
+	}
+
+	c := &Compositor{RuntimeDir: t.TempDir()}
+
+	cmd := exec.Command(path, "--config", "/dev/null")
+	cmd.Env = append(
+		os.Environ(),
+		"XDG_RUNTIME_DIR="+c.RuntimeDir,
+		"WLR_BACKENDS=headless",
+		"WLR_LIBINPUT_NO_DEVICES=1",
+		"WLR_RENDERER=pixman",
+		"WAYLAND_DISPLAY=",
+		"DISPLAY=",
+	)
+	if testing.Verbose() {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("idletest: failed to start sway: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for c.Display == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("idletest: sway did not create a Wayland socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+		c.Display = findSocket(c.RuntimeDir)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", c.RuntimeDir)
+	t.Setenv("WAYLAND_DISPLAY", c.Display)
+
+	return c
+}
+
+// findSocket returns the name of the Wayland socket in dir, empty if there is none yet.
+func findSocket(dir string) string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "wayland-*"))
+	for _, match := range matches {
+		if strings.HasSuffix(match, ".lock") {
+			continue
+		}
+		if info, err := os.Stat(match); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return filepath.Base(match)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,165 @@
+package idletest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// ioctl requests of linux/uinput.h.
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+)
+
+const (
+	evSyn = 0x00
+	evKey = 0x01
+)
+
+// uinputUserDevSize is the size of struct uinput_user_dev: the name, struct input_id,
+// ff_effects_max, and four arrays of 64 axis parameters.
+const uinputUserDevSize = 80 + 8 + 4 + 4*64*4
+
+// UinputDevice is a virtual evdev device created with /dev/uinput. Its input is seen by
+// everything that reads /dev/input, including display servers.
+type UinputDevice struct {
+	// Path is the device node of the device, e.g. /dev/input/event7.
+	Path string
+	// Name is the name the device reports.
+	Name string
+
+	f *os.File
+}
+
+// NewUinputDevice creates a virtual device that reports the given key codes of
+// linux/input-event-codes.h, e.g. 30 for KEY_A or 0x14b for BTN_STYLUS. The device is destroyed
+// when the test ends.
+// The test is skipped if /dev/uinput cannot be opened, which usually requires root.
+func NewUinputDevice(t testing.TB, name string, keys ...uint16) *UinputDevice {
+	t.Helper()
+
+	f, err := os.OpenFile("/dev/uinput", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("uinput not available: %v", err)
+	}
+
+	d, err := newUinputDevice(f, name, keys)
+	if err != nil {
+		_ = f.Close()
+		t.Fatalf("idletest: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = d.Close()
+	})
+
+	return d
+}
+
+func newUinputDevice(f *os.File, name string, keys []uint16) (*UinputDevice, error) {
+	d := &UinputDevice{Name: name, f: f}
+	if err := d.ioctl(uiSetEvBit, evKey); err != nil {
+		return nil, fmt.Errorf("unable to enable key events: %w", err)
+	}
+	for _, key := range keys {
+		if err := d.ioctl(uiSetKeyBit, uintptr(key)); err != nil {
+			return nil, fmt.Errorf("unable to enable key %#x: %w", key, err)
+		}
+	}
+
+	setup := make([]byte, uinputUserDevSize)
+	copy(setup[:79], name)
+	// BUS_VIRTUAL
+	binary.NativeEndian.PutUint16(setup[80:], 0x06)
+	if _, err := f.Write(setup); err != nil {
+		return nil, fmt.Errorf("unable to set up device: %w", err)
+	}
+	if err := d.ioctl(uiDevCreate, 0); err != nil {
+		return nil, fmt.Errorf("unable to create device: %w", err)
+	}
+
+	// The device node is created asynchronously
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if d.Path = findEventNode(name); d.Path != "" {
+			return d, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = d.ioctl(uiDevDestroy, 0)
+	return nil, fmt.Errorf("no device node for %q", name)
+}
+
+// findEventNode returns the device node of the input device with the given name.
+func findEventNode(name string) string {
+	dirs, _ := filepath.Glob("/sys/class/input/event*")
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, "device", "name"))
+		if err != nil || strings.TrimSpace(string(data)) != name {
+			continue
+		}
+
+		path := filepath.Join("/dev/input", filepath.Base(dir))
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+func (d *UinputDevice) ioctl(request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), request, arg)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Press presses and releases the key.
+func (d *UinputDevice) Press(key uint16) error {
+	for _, value := range []int32{1, 0} {
+		if err := d.write(evKey, key, value); err != nil {
+			return fmt.Errorf("unable to send key %#x: %w", key, err)
+		}
+		if err := d.write(evSyn, 0, 0); err != nil {
+			return fmt.Errorf("unable to send synchronization: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// write writes a struct input_event with a zero time, which the kernel fills in.
+func (d *UinputDevice) write(typ uint16, code uint16, value int32) error {
+	const timevalSize = 2 * strconv.IntSize / 8
+	event := make([]byte, timevalSize+8)
+	binary.NativeEndian.PutUint16(event[timevalSize:], typ)
+	binary.NativeEndian.PutUint16(event[timevalSize+2:], code)
+	binary.NativeEndian.PutUint32(event[timevalSize+4:], uint32(value))
+	_, err := d.f.Write(event)
+
+	return err
+}
+
+// Close destroys the device. Calling Close more than once is a no-op.
+func (d *UinputDevice) Close() error {
+	if d.f == nil {
+		return nil
+	}
+
+	err := errors.Join(d.ioctl(uiDevDestroy, 0), d.f.Close())
+	d.f = nil
+
+	return err
+}
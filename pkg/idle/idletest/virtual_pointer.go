@@ -0,0 +1,197 @@
+package idletest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/go-wayland/wayland/client"
+	"testing"
+	"time"
+)
+
+// virtualPointerManagerInterfaceName is the name of the [wlr-virtual-pointer-unstable-v1]
+// manager global.
+//
+// [wlr-virtual-pointer-unstable-v1]: https://wayland.app/protocols/wlr-virtual-pointer-unstable-v1
+const virtualPointerManagerInterfaceName = "zwlr_virtual_pointer_manager_v1"
+
+// virtualPointerManager is the zwlr_virtual_pointer_manager_v1 proxy. Only the requests used by
+// VirtualPointer are implemented.
+type virtualPointerManager struct {
+	client.BaseProxy
+}
+
+// createVirtualPointer creates a pointer on the default seat.
+func (i *virtualPointerManager) createVirtualPointer() (*virtualPointer, error) {
+	id := &virtualPointer{}
+	i.Context().Register(id)
+	const opcode = 0
+	const _reqBufLen = 8 + 4 + 4
+	var _reqBuf [_reqBufLen]byte
+	l := 0
+	client.PutUint32(_reqBuf[l:4], i.ID())
+	l += 4
+	client.PutUint32(_reqBuf[l:l+4], uint32(_reqBufLen<<16|opcode&0x0000ffff))
+	l += 4
+	// A null seat selects the default seat
+	client.PutUint32(_reqBuf[l:l+4], 0)
+	l += 4
+	client.PutUint32(_reqBuf[l:l+4], id.ID())
+	l += 4
+	err := i.Context().WriteMsg(_reqBuf[:], nil)
+	return id, err
+}
+
+func (i *virtualPointerManager) destroy() error {
+	defer i.Context().Unregister(i)
+	return writeNoArgs(i, 1)
+}
+
+// virtualPointer is the zwlr_virtual_pointer_v1 proxy.
+type virtualPointer struct {
+	client.BaseProxy
+}
+
+func (i *virtualPointer) motion(time uint32, dx float64, dy float64) error {
+	const opcode = 0
+	const _reqBufLen = 8 + 4 + 4 + 4
+	var _reqBuf [_reqBufLen]byte
+	l := 0
+	client.PutUint32(_reqBuf[l:4], i.ID())
+	l += 4
+	client.PutUint32(_reqBuf[l:l+4], uint32(_reqBufLen<<16|opcode&0x0000ffff))
+	l += 4
+	client.PutUint32(_reqBuf[l:l+4], time)
+	l += 4
+	client.PutFixed(_reqBuf[l:l+4], dx)
+	l += 4
+	client.PutFixed(_reqBuf[l:l+4], dy)
+	l += 4
+	return i.Context().WriteMsg(_reqBuf[:], nil)
+}
+
+func (i *virtualPointer) frame() error {
+	return writeNoArgs(i, 4)
+}
+
+func (i *virtualPointer) destroy() error {
+	defer i.Context().Unregister(i)
+	return writeNoArgs(i, 8)
+}
+
+// writeNoArgs sends a request without arguments.
+func writeNoArgs(p client.Proxy, opcode uint32) error {
+	const _reqBufLen = 8
+	var _reqBuf [_reqBufLen]byte
+	client.PutUint32(_reqBuf[0:4], p.ID())
+	client.PutUint32(_reqBuf[4:8], uint32(_reqBufLen<<16|opcode&0x0000ffff))
+	return p.Context().WriteMsg(_reqBuf[:], nil)
+}
+
+// VirtualPointer synthesizes pointer input on the compositor of WAYLAND_DISPLAY using the
+// wlr-virtual-pointer-unstable-v1 protocol. Pointer motion counts as user activity, so it resumes
+// idle notifications.
+//
+// VirtualPointer has its own connection and is not safe for concurrent use.
+type VirtualPointer struct {
+	display  *client.Display
+	registry *client.Registry
+	manager  *virtualPointerManager
+	pointer  *virtualPointer
+}
+
+// NewVirtualPointer connects to the compositor and creates a virtual pointer on its default seat.
+// The pointer is closed when the test ends.
+func NewVirtualPointer(t testing.TB) *VirtualPointer {
+	t.Helper()
+
+	p, err := newVirtualPointer()
+	if err != nil {
+		t.Fatalf("idletest: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+
+	return p
+}
+
+func newVirtualPointer() (*VirtualPointer, error) {
+	display, err := client.Connect("")
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to Wayland server: %w", err)
+	}
+
+	p := &VirtualPointer{display: display}
+	p.registry, err = display.GetRegistry()
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("error getting Wayland registry: %w", err), p.Close())
+	}
+
+	var bindError error
+	p.registry.SetGlobalHandler(func(e client.RegistryGlobalEvent) {
+		if e.Interface != virtualPointerManagerInterfaceName {
+			return
+		}
+
+		p.manager = &virtualPointerManager{}
+		display.Context().Register(p.manager)
+		if err := p.registry.Bind(e.Name, e.Interface, 1, p.manager); err != nil {
+			bindError = fmt.Errorf("unable to bind %s interface: %w", e.Interface, err)
+		}
+	})
+
+	if err := display.Roundtrip(); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed roundtrip: %w", err), p.Close())
+	}
+	if bindError != nil {
+		return nil, errors.Join(bindError, p.Close())
+	}
+	if p.manager == nil {
+		return nil, errors.Join(
+			fmt.Errorf("compositor does not advertise %s", virtualPointerManagerInterfaceName),
+			p.Close(),
+		)
+	}
+
+	p.pointer, err = p.manager.createVirtualPointer()
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("unable to create virtual pointer: %w", err), p.Close())
+	}
+
+	return p, nil
+}
+
+// Move moves the pointer relatively and waits until the compositor has processed the motion.
+func (p *VirtualPointer) Move(dx float64, dy float64) error {
+	now := uint32(time.Now().UnixMilli())
+	if err := p.pointer.motion(now, dx, dy); err != nil {
+		return fmt.Errorf("unable to send motion: %w", err)
+	}
+	if err := p.pointer.frame(); err != nil {
+		return fmt.Errorf("unable to send frame: %w", err)
+	}
+
+	return p.display.Roundtrip()
+}
+
+// Close destroys the pointer and closes the connection. Calling Close more than once is a no-op.
+func (p *VirtualPointer) Close() error {
+	if p.display == nil {
+		return nil
+	}
+
+	var err error
+	if p.pointer != nil {
+		err = errors.Join(err, p.pointer.destroy())
+	}
+	if p.manager != nil {
+		err = errors.Join(err, p.manager.destroy())
+	}
+	if p.registry != nil {
+		err = errors.Join(err, p.registry.Destroy())
+	}
+	err = errors.Join(err, p.display.Context().Close())
+	p.display = nil
+
+	return err
+}
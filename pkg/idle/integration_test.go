@@ -0,0 +1,65 @@
+//go:build integration
+
+package idle_test
+
+import (
+	"context"
+	"github.com/MatthiasKunnen/system/pkg/idle"
+	"github.com/MatthiasKunnen/system/pkg/idle/idletest"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags integration ./pkg/idle
+func TestIntegrationIdleResume(t *testing.T) {
+	idletest.StartCompositor(t)
+	pointer := idletest.NewVirtualPointer(t)
+
+	controller, _, err := idle.NewWaylandIdleController(idle.WithRun())
+	if err != nil {
+		t.Fatalf("NewWaylandIdleController() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = controller.Close()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- controller.Run(ctx)
+	}()
+
+	events := make(chan idle.Event, 4)
+	notification, err := controller.AddNotification(&idle.CreateIdleNotification{
+		Duration: 200 * time.Millisecond,
+		Events:   events,
+	})
+	if err != nil {
+		t.Fatalf("AddNotification() error = %v", err)
+	}
+	defer notification.Close()
+
+	expect := func(kind idle.EventKind) {
+		t.Helper()
+
+		select {
+		case e := <-events:
+			if e.Kind != kind {
+				t.Fatalf("received %s, want %s", e.Kind, kind)
+			}
+		case err := <-runErr:
+			t.Fatalf("Run() returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", kind)
+		}
+	}
+
+	for range 2 {
+		expect(idle.EventIdle)
+		if err := pointer.Move(10, 10); err != nil {
+			t.Fatalf("Move() error = %v", err)
+		}
+		expect(idle.EventResume)
+	}
+}
@@ -0,0 +1,477 @@
+package idle
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayMatch selects which recorded notifications deliver their events to a notification added
+// to the Controller of NewReplayController.
+type ReplayMatch int
+
+const (
+	// ReplayClosest delivers the events of the recorded notifications whose duration is closest
+	// to the duration of the notification, preferring the shorter duration on a tie. A
+	// notification always receives events as long as the trace has open notifications. This is
+	// the default.
+	ReplayClosest ReplayMatch = iota
+
+	// ReplayStrict only delivers the events of the recorded notifications with the same duration
+	// as the notification. Notifications without a recorded counterpart receive nothing.
+	ReplayStrict
+)
+
+func (m ReplayMatch) String() string {
+	switch m {
+	case ReplayClosest:
+		return "closest"
+	case ReplayStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("ReplayMatch(%d)", int(m))
+	}
+}
+
+// ReplayOption configures the Controller of NewReplayController.
+type ReplayOption func(o *replayOptions)
+
+type replayOptions struct {
+	match ReplayMatch
+}
+
+// WithReplayMatch sets how added notifications are matched with the recorded ones, see
+// ReplayMatch. Defaults to ReplayClosest.
+func WithReplayMatch(match ReplayMatch) ReplayOption {
+	return func(o *replayOptions) {
+		o.match = match
+	}
+}
+
+// replayController replays a trace, see NewReplayController.
+type replayController struct {
+	clock   clock
+	records []traceRecord
+	speed   float64
+	match   ReplayMatch
+	// start is closed when the first notification is added, which starts the replay.
+	start     chan struct{}
+	startOnce sync.Once
+	done      chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+	// replayed is the number of records that have been replayed.
+	replayed int
+	// traced are the recorded notifications that are open at the current point of the trace.
+	traced        map[traceKey]*tracedNotification
+	notifications map[*replayNotification]struct{}
+}
+
+// traceKey identifies a recorded notification, the ids of a trace are unique per backend.
+type traceKey struct {
+	backend string
+	id      uint64
+}
+
+type tracedNotification struct {
+	duration time.Duration
+	idle     bool
+	// idleAt is the replay time of the last idle event.
+	idleAt time.Time
+}
+
+type replayNotification struct {
+	controller *replayController
+	fanOut     *fanOut
+	stats      notificationStats
+
+	// The fields below are guarded by the mutex of the controller.
+
+	closed   bool
+	duration time.Duration
+	// idle is true when Idle has been sent without a following Resume.
+	idle bool
+}
+
+// NewReplayController creates a Controller that replays a trace written by WithRecorder, to
+// reproduce the events a consumer received, e.g. when debugging its state machine.
+//
+// The trace is read completely before NewReplayController returns, ErrInvalidTrace is returned
+// when it cannot be. The replay starts when the first notification is added. Records are
+// replayed at the time they were recorded relative to the first one, divided by speed: 1 replays
+// in real time, 10 ten times as fast, and math.Inf(1) without waiting.
+//
+// The idle and resume events of the trace are delivered to the added notifications as selected by
+// WithReplayMatch, the added notifications need not have the same durations as the recorded
+// ones. A notification that is added while its recorded counterpart is idle immediately receives
+// Idle. Event.Time is the time of the replay, Event.Duration the duration of the receiving
+// notification. SeatName is ignored, the trace does not record seats, and ActivityMask and
+// Devices are not supported.
+//
+// The Controller keeps running after the last record is replayed, Debug tells how far the replay
+// is. There are no dispatch functions, Run returns ErrDispatchOwned.
+func NewReplayController(r io.Reader, speed float64, opts ...ReplayOption) (Controller, error) {
+	return newReplayController(r, speed, realClock{}, opts...)
+}
+
+func newReplayController(
+	r io.Reader,
+	speed float64,
+	clk clock,
+	opts ...ReplayOption,
+) (*replayController, error) {
+	var options replayOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if !(speed > 0) {
+		return nil, fmt.Errorf("replay speed must be positive, got %g", speed)
+	}
+
+	records, err := readTrace(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &replayController{
+		clock:         clk,
+		records:       records,
+		speed:         speed,
+		match:         options.match,
+		start:         make(chan struct{}),
+		done:          make(chan struct{}),
+		traced:        make(map[traceKey]*tracedNotification),
+		notifications: make(map[*replayNotification]struct{}),
+	}
+	go m.replay()
+
+	return m, nil
+}
+
+// replay applies the records at their time until the trace ends or the controller is closed.
+func (m *replayController) replay() {
+	select {
+	case <-m.start:
+	case <-m.done:
+		return
+	}
+
+	start := m.clock.Now()
+	for _, record := range m.records {
+		offset := record.time.Sub(m.records[0].time)
+		if !m.wait(start.Add(time.Duration(float64(offset) / m.speed))) {
+			return
+		}
+		m.apply(record)
+	}
+}
+
+// wait waits until at, returns false if the controller was closed.
+func (m *replayController) wait(at time.Time) bool {
+	d := at.Sub(m.clock.Now())
+	if d <= 0 {
+		select {
+		case <-m.done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := m.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+// apply replays a record.
+func (m *replayController) apply(record traceRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.replayed++
+	key := traceKey{backend: record.backend, id: record.id}
+	switch record.kind {
+	case traceAdd:
+		m.traced[key] = &tracedNotification{duration: record.duration}
+	case traceDuration:
+		if t, ok := m.traced[key]; ok {
+			t.duration = record.duration
+		}
+	case traceClose:
+		delete(m.traced, key)
+	case traceIdle, traceResume:
+		t, ok := m.traced[key]
+		if !ok {
+			// The trace started after the notification was added
+			t = &tracedNotification{}
+			m.traced[key] = t
+		}
+		t.duration = record.duration
+		t.idle = record.kind == traceIdle
+		if t.idle {
+			t.idleAt = m.clock.Now()
+		}
+
+		for n := range m.notifications {
+			if n.idle != t.idle && m.matches(n.duration, t) {
+				n.setIdle(t.idle)
+			}
+		}
+	}
+}
+
+// matches reports whether the events of t are delivered to notifications with duration d.
+// Must be called with mu held.
+func (m *replayController) matches(d time.Duration, t *tracedNotification) bool {
+	if m.match == ReplayStrict {
+		return t.duration == d
+	}
+
+	return t.duration == m.closestDuration(d)
+}
+
+// closestDuration returns the duration of the recorded notifications that is closest to d.
+// Must be called with mu held.
+func (m *replayController) closestDuration(d time.Duration) time.Duration {
+	var closest time.Duration
+	var distance time.Duration = -1
+	for _, t := range m.traced {
+		dt := (t.duration - d).Abs()
+		if distance == -1 || dt < distance || dt == distance && t.duration < closest {
+			closest = t.duration
+			distance = dt
+		}
+	}
+
+	return closest
+}
+
+// matchIdle reports whether a recorded notification that matches duration d is idle.
+// Must be called with mu held.
+func (m *replayController) matchIdle(d time.Duration) bool {
+	for _, t := range m.traced {
+		if t.idle && m.matches(d, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *replayController) AddNotification(notificationInput *CreateIdleNotification) (Notification, error) {
+	if notificationInput.Idle == nil && notificationInput.Resume == nil &&
+		notificationInput.Events == nil {
+		return nil, fmt.Errorf("either Idle, Resume, or Events is required")
+	}
+
+	if mask := notificationInput.ActivityMask; mask != 0 && mask != ActivityAll {
+		return nil, fmt.Errorf("%w: ActivityMask %s, traces do not record input kinds",
+			ErrUnsupported, mask)
+	}
+	if len(notificationInput.Devices) > 0 {
+		return nil, fmt.Errorf("%w: Devices, traces do not record input devices", ErrUnsupported)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	n := &replayNotification{controller: m, duration: notificationInput.Duration}
+	n.fanOut = newFanOut(notificationInput, &n.stats, m.done, m.clock)
+	m.notifications[n] = struct{}{}
+	if m.matchIdle(n.duration) {
+		n.setIdle(true)
+	}
+
+	m.startOnce.Do(func() {
+		close(m.start)
+	})
+
+	return n, nil
+}
+
+func (m *replayController) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+	m.err = ErrControllerClosed
+	close(m.done)
+
+	return nil
+}
+
+func (m *replayController) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *replayController) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+func (m *replayController) Run(context.Context) error {
+	return ErrDispatchOwned
+}
+
+func (m *replayController) Seats() []SeatInfo {
+	return nil
+}
+
+func (m *replayController) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// IdleSince returns since when the earliest idle recorded notification is idle, the start of its
+// idle period as reported by its duration.
+func (m *replayController) IdleSince() (time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return time.Time{}, false, m.err
+	}
+
+	var since time.Time
+	for _, t := range m.traced {
+		if start := t.idleAt.Add(-t.duration); t.idle && (since.IsZero() || start.Before(since)) {
+			since = start
+		}
+	}
+
+	return since, !since.IsZero(), nil
+}
+
+func (m *replayController) IsIdleFor(d time.Duration) (bool, error) {
+	since, idle, err := m.IdleSince()
+	if err != nil || !idle {
+		return false, err
+	}
+
+	return m.clock.Now().Sub(since) >= d, nil
+}
+
+func (m *replayController) Stats() ControllerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return ControllerStats{Notifications: len(m.notifications)}
+}
+
+func (m *replayController) Debug() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notifications := make([]*replayNotification, 0, len(m.notifications))
+	for n := range m.notifications {
+		notifications = append(notifications, n)
+	}
+	slices.SortFunc(notifications, func(a, b *replayNotification) int {
+		return cmp.Compare(a.duration, b.duration)
+	})
+	keys := make([]traceKey, 0, len(m.traced))
+	for key := range m.traced {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b traceKey) int {
+		return cmp.Or(cmp.Compare(a.backend, b.backend), cmp.Compare(a.id, b.id))
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "replay idle controller, %s matching, %d of %d record(s) replayed",
+		m.match, m.replayed, len(m.records))
+	if m.replayed > 0 {
+		fmt.Fprintf(&b, ", at %s", m.records[m.replayed-1].time.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&b, ", %d notification(s)\n", len(notifications))
+	for _, n := range notifications {
+		fmt.Fprintf(&b, "  %s: %s\n", n.duration, n.Stats())
+	}
+	for _, key := range keys {
+		t := m.traced[key]
+		state := "active"
+		if t.idle {
+			state = "idle"
+		}
+		fmt.Fprintf(&b, "  recorded %s %d: %s, %s\n", key.backend, key.id, t.duration, state)
+	}
+
+	return b.String()
+}
+
+// setIdle emits the event of the transition to idle. Must be called with the mutex of the
+// controller held.
+func (n *replayNotification) setIdle(idle bool) {
+	n.idle = idle
+	kind := EventResume
+	if idle {
+		kind = EventIdle
+	}
+
+	n.fanOut.emit(Event{
+		Kind:     kind,
+		Time:     n.controller.clock.Now(),
+		Duration: n.duration,
+	})
+}
+
+func (n *replayNotification) Close() error {
+	n.controller.mu.Lock()
+	defer n.controller.mu.Unlock()
+	if n.closed {
+		return nil
+	}
+
+	n.closed = true
+	delete(n.controller.notifications, n)
+	n.fanOut.close()
+
+	return nil
+}
+
+func (n *replayNotification) SetDuration(d time.Duration) error {
+	m := n.controller
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n.closed {
+		return ErrNotificationClosed
+	}
+
+	n.duration = d
+	if n.idle && !m.matchIdle(d) {
+		n.setIdle(false)
+	}
+
+	return nil
+}
+
+func (n *replayNotification) Stats() NotificationStats {
+	return n.stats.snapshot()
+}
+
+func (n *replayNotification) ResetStats() {
+	n.stats.reset()
+}
+
+func (n *replayNotification) LastIdlePeriod() (IdlePeriod, bool) {
+	return n.fanOut.lastIdlePeriod()
+}
@@ -0,0 +1,138 @@
+package idle
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTrace = `idle-trace v1
+2024-01-01T12:00:00Z wayland 1 add 5m0s
+2024-01-01T12:00:00Z wayland 2 add 10m0s
+2024-01-01T12:05:00Z wayland 1 idle 5m0s
+2024-01-01T12:06:00Z wayland 1 resume 5m0s
+2024-01-01T12:16:00Z wayland 2 idle 10m0s
+`
+
+func addReplayNotification(t *testing.T, m *replayController, d time.Duration) (Notification, <-chan Event) {
+	t.Helper()
+
+	events := make(chan Event, 4)
+	n, err := m.AddNotification(&CreateIdleNotification{Duration: d, Events: events})
+	if err != nil {
+		t.Fatalf("AddNotification() error = %v", err)
+	}
+	t.Cleanup(func() { _ = n.Close() })
+
+	return n, events
+}
+
+func TestReplayController(t *testing.T) {
+	clk := newFakeClock()
+	start := clk.Now()
+	m, err := newReplayController(strings.NewReader(testTrace), 2, clk)
+	if err != nil {
+		t.Fatalf("newReplayController() error = %v", err)
+	}
+	defer m.Close()
+
+	if _, err := m.AddNotification(&CreateIdleNotification{
+		Idle:         make(chan struct{}),
+		ActivityMask: ActivityKeyboard,
+	}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("AddNotification() with ActivityMask error = %v, want ErrUnsupported", err)
+	}
+
+	// The replay starts with the first notification, at twice the speed
+	_, short := addReplayNotification(t, m, 4*time.Minute)
+	if timer := clk.nextTimer(t); timer.d != 150*time.Second {
+		t.Fatalf("first timer = %s, want 2m30s", timer.d)
+	} else {
+		clk.set(start.Add(150 * time.Second))
+		timer.c <- clk.Now()
+	}
+	if event := receiveEvent(t, short); event.Kind != EventIdle || event.Duration != 4*time.Minute ||
+		!event.Time.Equal(clk.Now()) {
+		t.Fatalf("event = %+v, want idle of 4m at the replay time", event)
+	}
+
+	// Added while the closest recorded notification is idle
+	long, longEvents := addReplayNotification(t, m, 10*time.Minute)
+	_, medium := addReplayNotification(t, m, 6*time.Minute)
+	if event := receiveEvent(t, medium); event.Kind != EventIdle {
+		t.Fatalf("event = %s, want idle when added while idle", event.Kind)
+	}
+
+	timer := clk.nextTimer(t)
+	clk.set(start.Add(180 * time.Second))
+	timer.c <- clk.Now()
+	for _, events := range []<-chan Event{short, medium} {
+		if event := receiveEvent(t, events); event.Kind != EventResume {
+			t.Fatalf("event = %s, want resume", event.Kind)
+		}
+	}
+
+	timer = clk.nextTimer(t)
+	if timer.d != 300*time.Second {
+		t.Fatalf("last timer = %s, want 5m", timer.d)
+	}
+	clk.set(start.Add(480 * time.Second))
+	timer.c <- clk.Now()
+	if event := receiveEvent(t, longEvents); event.Kind != EventIdle {
+		t.Fatalf("event = %s, want idle", event.Kind)
+	}
+	if debug := m.Debug(); !strings.Contains(debug, "5 of 5 record(s) replayed") {
+		t.Errorf("Debug() = %q, want the end of the trace", debug)
+	}
+
+	since, idle, err := m.IdleSince()
+	if err != nil || !idle || !since.Equal(start.Add(-2*time.Minute)) {
+		t.Fatalf("IdleSince() = %s, %t, %v, want idle since 10m before the idle event", since, idle, err)
+	}
+
+	// A duration that no longer matches the idle recorded notification resumes
+	if err := long.SetDuration(4 * time.Minute); err != nil {
+		t.Fatalf("SetDuration() error = %v", err)
+	}
+	if event := receiveEvent(t, longEvents); event.Kind != EventResume {
+		t.Fatalf("event = %s, want resume after SetDuration", event.Kind)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := m.AddNotification(&CreateIdleNotification{Idle: make(chan struct{})}); !errors.Is(err, ErrControllerClosed) {
+		t.Fatalf("AddNotification() after Close error = %v, want ErrControllerClosed", err)
+	}
+}
+
+func TestReplayControllerStrict(t *testing.T) {
+	m, err := newReplayController(strings.NewReader(testTrace), math.Inf(1), newFakeClock(),
+		WithReplayMatch(ReplayStrict))
+	if err != nil {
+		t.Fatalf("newReplayController() error = %v", err)
+	}
+	defer m.Close()
+
+	other, _ := addReplayNotification(t, m, 4*time.Minute)
+	_, events := addReplayNotification(t, m, 10*time.Minute)
+
+	// Without waiting, the whole trace is replayed or the notification is added while idle
+	if event := receiveEvent(t, events); event.Kind != EventIdle {
+		t.Fatalf("event = %s, want idle", event.Kind)
+	}
+	if stats := other.Stats(); stats.Idle != 0 || stats.Resume != 0 {
+		t.Fatalf("notification without recorded counterpart received events: %s", stats)
+	}
+}
+
+func TestNewReplayControllerErrors(t *testing.T) {
+	if _, err := NewReplayController(strings.NewReader(testTrace), 0); err == nil {
+		t.Error("NewReplayController() with speed 0 succeeded")
+	}
+	if _, err := NewReplayController(strings.NewReader("idle-trace v0\n"), 1); !errors.Is(err, ErrInvalidTrace) {
+		t.Errorf("NewReplayController() error = %v, want ErrInvalidTrace", err)
+	}
+}
@@ -0,0 +1,246 @@
+package idle
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// StageEvent reports that a stage of Stages became idle or resumed.
+type StageEvent struct {
+	Name string
+	Idle bool
+}
+
+// Stages manages a set of named idle notifications, e.g. dim, lock, and screen off, and reports
+// them on a single channel.
+//
+// Idle events are delivered in order of increasing duration and resume events in the reverse
+// order, regardless of the order in which the Controller reports them. A stage that idles implies
+// that all shorter stages idled, and a stage that resumes implies that all longer stages resumed.
+type Stages struct {
+	controller Controller
+	events     chan StageEvent
+	input      chan stageInput
+	stop       chan struct{}
+	done       chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	stages map[string]*stage
+}
+
+type stage struct {
+	name         string
+	duration     time.Duration
+	notification Notification
+	events       chan Event
+	removed      chan struct{}
+	// idle is true when an idle StageEvent has been delivered without a following resume.
+	idle bool
+}
+
+type stageInput struct {
+	stage *stage
+	kind  EventKind
+}
+
+// NewStages creates a notification for every stage, the map key being the name of the stage.
+func NewStages(ctrl Controller, stages map[string]time.Duration) (*Stages, error) {
+	s := &Stages{
+		controller: ctrl,
+		events:     make(chan StageEvent, sinkQueueSize),
+		input:      make(chan stageInput),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		stages:     make(map[string]*stage),
+	}
+
+	go s.run()
+
+	for name, d := range stages {
+		if err := s.Update(name, d); err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+	}
+
+	return s, nil
+}
+
+// Events returns the channel on which the stage transitions are delivered. The channel is closed
+// by Close.
+func (s *Stages) Events() <-chan StageEvent {
+	return s.events
+}
+
+// Update changes the duration of the stage, adding the stage if it does not exist.
+// Returns ErrControllerClosed after Close.
+func (s *Stages) Update(name string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrControllerClosed
+	}
+
+	if st, ok := s.stages[name]; ok {
+		if err := st.notification.SetDuration(d); err != nil {
+			return fmt.Errorf("failed to update stage %s: %w", name, err)
+		}
+		st.duration = d
+		return nil
+	}
+
+	st := &stage{
+		name:     name,
+		duration: d,
+		events:   make(chan Event, sinkQueueSize),
+		removed:  make(chan struct{}),
+	}
+
+	var err error
+	st.notification, err = s.controller.AddNotification(&CreateIdleNotification{
+		Duration: d,
+		Events:   st.events,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add stage %s: %w", name, err)
+	}
+
+	s.stages[name] = st
+	go s.forward(st)
+
+	return nil
+}
+
+// Remove removes the stage. No resume is delivered for a removed stage that is idle.
+// Removing an unknown stage is a no-op.
+func (s *Stages) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.stages[name]
+	if !ok {
+		return nil
+	}
+
+	delete(s.stages, name)
+	close(st.removed)
+
+	return st.notification.Close()
+}
+
+// Close closes all notifications and the Events channel.
+// Calling Close more than once is a no-op.
+func (s *Stages) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+
+	var err error
+	for name, st := range s.stages {
+		delete(s.stages, name)
+		close(st.removed)
+		err = errors.Join(err, st.notification.Close())
+	}
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+
+	return err
+}
+
+// forward passes the events of the stage's notification to the run goroutine.
+func (s *Stages) forward(st *stage) {
+	for {
+		select {
+		case event := <-st.events:
+			select {
+			case s.input <- stageInput{stage: st, kind: event.Kind}:
+			case <-st.removed:
+				return
+			case <-s.stop:
+				return
+			}
+		case <-st.removed:
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Stages) run() {
+	defer close(s.done)
+	defer close(s.events)
+
+	for {
+		select {
+		case in := <-s.input:
+			for _, event := range s.transition(in.stage, in.kind) {
+				select {
+				case s.events <- event:
+				case <-s.stop:
+					return
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// transition updates the state of the stages for the event of st and returns the events to
+// deliver, in order.
+func (s *Stages) transition(st *stage, kind EventKind) []StageEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stages[st.name] != st {
+		// Removed while the event was underway
+		return nil
+	}
+
+	sorted := make([]*stage, 0, len(s.stages))
+	for _, other := range s.stages {
+		sorted = append(sorted, other)
+	}
+	slices.SortFunc(sorted, compareStages)
+
+	var events []StageEvent
+	switch kind {
+	case EventIdle:
+		for _, other := range sorted {
+			if other.duration > st.duration {
+				break
+			}
+			if !other.idle {
+				other.idle = true
+				events = append(events, StageEvent{Name: other.name, Idle: true})
+			}
+		}
+	case EventResume:
+		for _, other := range slices.Backward(sorted) {
+			if other.duration < st.duration {
+				break
+			}
+			if other.idle {
+				other.idle = false
+				events = append(events, StageEvent{Name: other.name, Idle: false})
+			}
+		}
+	}
+
+	return events
+}
+
+func compareStages(a, b *stage) int {
+	return cmp.Or(cmp.Compare(a.duration, b.duration), cmp.Compare(a.name, b.name))
+}
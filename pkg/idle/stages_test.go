@@ -0,0 +1,143 @@
+package idle
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func receiveStageEvents(t *testing.T, s *Stages, n int) []StageEvent {
+	t.Helper()
+
+	var events []StageEvent
+	for range n {
+		select {
+		case event := <-s.Events():
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after receiving %v", events)
+		}
+	}
+
+	select {
+	case event := <-s.Events():
+		t.Fatalf("unexpected event %v after %v", event, events)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	return events
+}
+
+func newTestStages(t *testing.T) (*Stages, *fakeController) {
+	t.Helper()
+
+	ctrl := newFakeController()
+	s, err := NewStages(ctrl, map[string]time.Duration{
+		"dim":     2 * time.Minute,
+		"lock":    5 * time.Minute,
+		"off":     10 * time.Minute,
+		"suspend": 20 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewStages() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s, ctrl
+}
+
+func TestStagesOrder(t *testing.T) {
+	s, ctrl := newTestStages(t)
+
+	// The controller reports the longer stage first
+	ctrl.notification(5 * time.Minute).send(EventIdle)
+	ctrl.notification(2 * time.Minute).send(EventIdle)
+
+	got := receiveStageEvents(t, s, 2)
+	want := []StageEvent{{"dim", true}, {"lock", true}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("idle events = %v, want %v", got, want)
+	}
+
+	ctrl.notification(20 * time.Minute).send(EventIdle)
+	got = receiveStageEvents(t, s, 2)
+	want = []StageEvent{{"off", true}, {"suspend", true}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("idle events = %v, want %v", got, want)
+	}
+
+	// The shortest stage resumes first, all stages resume in reverse order
+	ctrl.notification(2 * time.Minute).send(EventResume)
+	ctrl.notification(20 * time.Minute).send(EventResume)
+
+	got = receiveStageEvents(t, s, 4)
+	want = []StageEvent{{"suspend", false}, {"off", false}, {"lock", false}, {"dim", false}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("resume events = %v, want %v", got, want)
+	}
+}
+
+func TestStagesUpdate(t *testing.T) {
+	s, ctrl := newTestStages(t)
+
+	ctrl.notification(10 * time.Minute).send(EventIdle)
+	receiveStageEvents(t, s, 3)
+
+	// Lengthening lock beyond the current idle time makes the controller report a resume for
+	// it, the longer stages resume as well.
+	if err := s.Update("lock", 15*time.Minute); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	ctrl.notification(15 * time.Minute).send(EventResume)
+
+	got := receiveStageEvents(t, s, 1)
+	want := []StageEvent{{"lock", false}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("events after update = %v, want %v", got, want)
+	}
+
+	if err := s.Update("screensaver", time.Minute); err != nil {
+		t.Fatalf("Update() new stage error = %v", err)
+	}
+	if ctrl.notification(time.Minute) == nil {
+		t.Fatalf("Update() did not add a notification for the new stage")
+	}
+}
+
+func TestStagesRemoveAndClose(t *testing.T) {
+	s, ctrl := newTestStages(t)
+
+	lock := ctrl.notification(5 * time.Minute)
+	if err := s.Remove("lock"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if !lock.isClosed() {
+		t.Fatalf("Remove() did not close the notification")
+	}
+
+	ctrl.notification(10 * time.Minute).send(EventIdle)
+	got := receiveStageEvents(t, s, 2)
+	want := []StageEvent{{"dim", true}, {"off", true}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("events after remove = %v, want %v", got, want)
+	}
+
+	dim := ctrl.notification(2 * time.Minute)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if !dim.isClosed() {
+		t.Fatalf("Close() did not close the notifications")
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Fatalf("Events channel not closed by Close")
+	}
+	if err := s.Update("dim", time.Minute); err == nil {
+		t.Fatalf("Update() after Close succeeded")
+	}
+}
@@ -0,0 +1,111 @@
+package idle
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ControllerStats describes the notifications of a Controller.
+type ControllerStats struct {
+	// Notifications is the number of notifications that have been added and not closed.
+	Notifications int
+	// MaxNotifications is the limit set by WithMaxNotifications, zero when there is none.
+	MaxNotifications int
+}
+
+// NotificationStats contains delivery statistics of a Notification.
+type NotificationStats struct {
+	// Idle is the number of idle events emitted by the notification.
+	Idle uint64
+	// Resume is the number of resume events emitted by the notification.
+	Resume uint64
+	// Dropped is the total number of events that could not be delivered to a channel.
+	Dropped uint64
+	// IdleDropped is the number of events that could not be delivered to the Idle channel.
+	IdleDropped uint64
+	// ResumeDropped is the number of events that could not be delivered to the Resume channel.
+	ResumeDropped uint64
+	// EventsDropped is the number of events that could not be delivered to the Events channel.
+	EventsDropped uint64
+	// LastIdle is the time of the last idle event. Zero if none has been emitted.
+	LastIdle time.Time
+	// LastResume is the time of the last resume event. Zero if none has been emitted.
+	LastResume time.Time
+}
+
+func (s NotificationStats) String() string {
+	return fmt.Sprintf(
+		"idle=%d (last %s) resume=%d (last %s) dropped=%d",
+		s.Idle,
+		formatStatsTime(s.LastIdle),
+		s.Resume,
+		formatStatsTime(s.LastResume),
+		s.Dropped,
+	)
+}
+
+func formatStatsTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// notificationStats keeps track of NotificationStats using atomic operations so that it can be
+// updated from delivery goroutines while being read from any goroutine.
+type notificationStats struct {
+	idle          atomic.Uint64
+	resume        atomic.Uint64
+	idleDropped   atomic.Uint64
+	resumeDropped atomic.Uint64
+	eventsDropped atomic.Uint64
+	lastIdle      atomic.Int64
+	lastResume    atomic.Int64
+}
+
+// record records the emission of the event.
+func (s *notificationStats) record(event Event) {
+	switch event.Kind {
+	case EventIdle:
+		s.idle.Add(1)
+		s.lastIdle.Store(event.Time.UnixNano())
+	case EventResume:
+		s.resume.Add(1)
+		s.lastResume.Store(event.Time.UnixNano())
+	}
+}
+
+func (s *notificationStats) snapshot() NotificationStats {
+	result := NotificationStats{
+		Idle:          s.idle.Load(),
+		Resume:        s.resume.Load(),
+		IdleDropped:   s.idleDropped.Load(),
+		ResumeDropped: s.resumeDropped.Load(),
+		EventsDropped: s.eventsDropped.Load(),
+		LastIdle:      statsTime(s.lastIdle.Load()),
+		LastResume:    statsTime(s.lastResume.Load()),
+	}
+	result.Dropped = result.IdleDropped + result.ResumeDropped + result.EventsDropped
+
+	return result
+}
+
+func (s *notificationStats) reset() {
+	s.idle.Store(0)
+	s.resume.Store(0)
+	s.idleDropped.Store(0)
+	s.resumeDropped.Store(0)
+	s.eventsDropped.Store(0)
+	s.lastIdle.Store(0)
+	s.lastResume.Store(0)
+}
+
+func statsTime(unixNano int64) time.Time {
+	if unixNano == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, unixNano)
+}
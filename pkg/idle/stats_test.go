@@ -0,0 +1,49 @@
+package idle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotificationStats(t *testing.T) {
+	var s notificationStats
+	idleAt := time.Unix(100, 0)
+	resumeAt := time.Unix(200, 0)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.record(Event{Kind: EventIdle, Time: idleAt})
+			s.record(Event{Kind: EventResume, Time: resumeAt})
+			s.idleDropped.Add(1)
+			s.eventsDropped.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	got := s.snapshot()
+	want := NotificationStats{
+		Idle:          10,
+		Resume:        10,
+		Dropped:       20,
+		IdleDropped:   10,
+		EventsDropped: 10,
+		LastIdle:      idleAt,
+		LastResume:    resumeAt,
+	}
+	if !got.LastIdle.Equal(want.LastIdle) || !got.LastResume.Equal(want.LastResume) {
+		t.Fatalf("snapshot() = %+v, want %+v", got, want)
+	}
+	got.LastIdle, got.LastResume = want.LastIdle, want.LastResume
+	if got != want {
+		t.Fatalf("snapshot() = %+v, want %+v", got, want)
+	}
+
+	s.reset()
+	if got := s.snapshot(); got != (NotificationStats{}) {
+		t.Fatalf("snapshot() after reset = %+v, want zero value", got)
+	}
+}
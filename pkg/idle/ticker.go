@@ -0,0 +1,164 @@
+package idle
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PeriodicIdleTicker sends the current time on C at a fixed interval while the session is idle.
+// It can be used to briefly wake a display, e.g. to shift content as burn-in protection.
+//
+// Ticks stop when the session resumes and start again when it next becomes idle. Like
+// time.Ticker, ticks are dropped when the receiver is slow. When the system was suspended or the
+// clock jumped, missed ticks are not sent in a burst, ticking continues at the interval from the
+// moment the jump was noticed.
+type PeriodicIdleTicker struct {
+	// C receives the ticks.
+	C <-chan time.Time
+
+	c            chan time.Time
+	clock        clock
+	controller   Controller
+	events       chan Event
+	interval     time.Duration
+	notification Notification
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPeriodicIdleTicker creates a ticker that ticks every interval once the session has been idle
+// for idleAfter. The first tick is sent interval after the session became idle.
+//
+// The ticker stops when it is closed or when the controller stops, see Controller.Done. To
+// survive a reconnect, create a new ticker for the new controller.
+func NewPeriodicIdleTicker(
+	ctrl Controller,
+	idleAfter time.Duration,
+	interval time.Duration,
+) (*PeriodicIdleTicker, error) {
+	return newPeriodicIdleTicker(ctrl, idleAfter, interval, realClock{})
+}
+
+func newPeriodicIdleTicker(
+	ctrl Controller,
+	idleAfter time.Duration,
+	interval time.Duration,
+	clk clock,
+) (*PeriodicIdleTicker, error) {
+	if interval <= 0 {
+		return nil, errors.New("non-positive interval for PeriodicIdleTicker")
+	}
+
+	c := make(chan time.Time, 1)
+	t := &PeriodicIdleTicker{
+		C:          c,
+		c:          c,
+		clock:      clk,
+		controller: ctrl,
+		events:     make(chan Event, sinkQueueSize),
+		interval:   interval,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	var err error
+	t.notification, err = ctrl.AddNotification(&CreateIdleNotification{
+		Duration: idleAfter,
+		Events:   t.events,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add idle notification for ticker: %w", err)
+	}
+
+	go t.run()
+
+	return t, nil
+}
+
+// Close stops the ticker. No ticks are sent after Close returns.
+// Calling Close more than once is a no-op.
+func (t *PeriodicIdleTicker) Close() error {
+	var err error
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		<-t.stopped
+		err = t.notification.Close()
+	})
+
+	return err
+}
+
+// Done returns a channel that is closed once the ticker has stopped, either because Close was
+// called or because the controller stopped.
+func (t *PeriodicIdleTicker) Done() <-chan struct{} {
+	return t.stopped
+}
+
+func (t *PeriodicIdleTicker) run() {
+	defer close(t.stopped)
+
+	var timer clockTimer
+	var timerC <-chan time.Time
+	var next time.Time
+
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			timerC = nil
+		}
+	}
+	defer stopTimer()
+
+	for {
+		select {
+		case event := <-t.events:
+			switch event.Kind {
+			case EventIdle:
+				if timer != nil {
+					continue
+				}
+				now := t.clock.Now()
+				next = now.Add(t.interval)
+				timer = t.clock.NewTimer(t.interval)
+				timerC = timer.C()
+			case EventResume:
+				stopTimer()
+			}
+		case <-timerC:
+			now := t.clock.Now()
+			select {
+			case t.c <- now:
+			default:
+			}
+
+			next = nextTick(next, now, t.interval)
+			timer = t.clock.NewTimer(next.Sub(now))
+			timerC = timer.C()
+		case <-t.controller.Done():
+			return
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// nextTick returns the time of the tick following the one scheduled at previous, given that the
+// current time is now. Wall clock time is used so that time spent in suspend is accounted for. If
+// the next tick would be in the past, or unreasonably far in the future because the clock was
+// set back, ticking restarts from now.
+func nextTick(previous time.Time, now time.Time, interval time.Duration) time.Time {
+	previous = previous.Round(0)
+	now = now.Round(0)
+
+	next := previous.Add(interval)
+	if !next.After(now) || next.Sub(now) > interval {
+		return now.Add(interval)
+	}
+
+	return next
+}
@@ -0,0 +1,203 @@
+package idle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu        sync.Mutex
+	now       time.Time
+	monotonic time.Duration
+	timers    chan *fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		timers: make(chan *fakeTimer, 16),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.monotonic += now.Sub(c.now)
+	c.now = now
+}
+
+// suspend advances the wall clock by d without advancing the monotonic clock, like a suspend of
+// the system.
+func (c *fakeClock) suspend(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) Monotonic() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.monotonic
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clockTimer {
+	t := &fakeTimer{c: make(chan time.Time, 1), d: d}
+	c.timers <- t
+	return t
+}
+
+// nextTimer returns the timer created next by the code under test.
+func (c *fakeClock) nextTimer(t *testing.T) *fakeTimer {
+	t.Helper()
+
+	select {
+	case timer := <-c.timers:
+		return timer
+	case <-time.After(time.Second):
+		t.Fatal("no timer created")
+		return nil
+	}
+}
+
+type fakeTimer struct {
+	c chan time.Time
+	d time.Duration
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+func (t *fakeTimer) isStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.stopped
+}
+
+func receiveTick(t *testing.T, ticker *PeriodicIdleTicker) time.Time {
+	t.Helper()
+
+	select {
+	case tick := <-ticker.C:
+		return tick
+	case <-time.After(time.Second):
+		t.Fatal("no tick received")
+		return time.Time{}
+	}
+}
+
+func TestPeriodicIdleTicker(t *testing.T) {
+	clk := newFakeClock()
+	ctrl := newFakeController()
+	ticker, err := newPeriodicIdleTicker(ctrl, time.Minute, 10*time.Second, clk)
+	if err != nil {
+		t.Fatalf("newPeriodicIdleTicker() error = %v", err)
+	}
+	defer ticker.Close()
+
+	start := clk.Now()
+	ctrl.events <- Event{Kind: EventIdle}
+	timer := clk.nextTimer(t)
+	if timer.d != 10*time.Second {
+		t.Errorf("first timer duration = %s, want 10s", timer.d)
+	}
+
+	clk.set(start.Add(10 * time.Second))
+	timer.c <- clk.Now()
+	if got := receiveTick(t, ticker); !got.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("tick = %s, want %s", got, start.Add(10*time.Second))
+	}
+
+	// Suspended for an hour, ticking continues from now instead of catching up
+	timer = clk.nextTimer(t)
+	clk.set(start.Add(time.Hour))
+	timer.c <- clk.Now()
+	receiveTick(t, ticker)
+	timer = clk.nextTimer(t)
+	if timer.d != 10*time.Second {
+		t.Errorf("timer duration after suspend = %s, want 10s", timer.d)
+	}
+
+	ctrl.events <- Event{Kind: EventResume}
+	deadline := time.Now().Add(time.Second)
+	for !timer.isStopped() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !timer.isStopped() {
+		t.Error("timer not stopped on resume")
+	}
+
+	if err := ticker.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := ticker.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+}
+
+func TestPeriodicIdleTickerControllerDone(t *testing.T) {
+	ctrl := newFakeController()
+	ticker, err := newPeriodicIdleTicker(ctrl, time.Minute, time.Second, newFakeClock())
+	if err != nil {
+		t.Fatalf("newPeriodicIdleTicker() error = %v", err)
+	}
+	defer ticker.Close()
+
+	close(ctrl.done)
+
+	select {
+	case <-ticker.Done():
+	case <-time.After(time.Second):
+		t.Fatal("ticker did not stop when the controller stopped")
+	}
+}
+
+func TestNextTick(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	interval := 10 * time.Second
+
+	tests := []struct {
+		name     string
+		previous time.Time
+		now      time.Time
+		want     time.Time
+	}{
+		{"on time", base, base, base.Add(interval)},
+		{"late", base, base.Add(3 * time.Second), base.Add(interval)},
+		{"missed ticks", base, base.Add(time.Hour), base.Add(time.Hour + interval)},
+		{"exactly at next", base, base.Add(interval), base.Add(2 * interval)},
+		{"clock set back", base, base.Add(-time.Hour), base.Add(-time.Hour + interval)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextTick(tt.previous, tt.now, interval); !got.Equal(tt.want) {
+				t.Errorf("nextTick() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package idle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceHeader is the first line of a trace written by WithRecorder. The version is increased when
+// the format changes incompatibly.
+const traceHeader = "idle-trace v1"
+
+// ErrInvalidTrace is returned by NewReplayController when the trace cannot be read.
+var ErrInvalidTrace = errors.New("invalid idle trace")
+
+// traceKind is the type of a record of a trace.
+type traceKind string
+
+const (
+	// traceAdd records that a notification was added, with its duration.
+	traceAdd traceKind = "add"
+	// traceDuration records that the duration of a notification was changed.
+	traceDuration traceKind = "duration"
+	// traceIdle and traceResume record the events of a notification, with the duration of the
+	// event.
+	traceIdle   traceKind = "idle"
+	traceResume traceKind = "resume"
+	// traceClose records that a notification was closed.
+	traceClose traceKind = "close"
+)
+
+// traceRecord is a line of a trace:
+//
+//	<time, RFC 3339> <backend> <notification id> <kind> [<duration>]
+//
+// e.g. "2024-01-01T12:00:00.5Z wayland 3 idle 5m0s". Every kind but close has a duration.
+type traceRecord struct {
+	time     time.Time
+	backend  string
+	id       uint64
+	kind     traceKind
+	duration time.Duration
+}
+
+func (r traceRecord) String() string {
+	s := fmt.Sprintf("%s %s %d %s", r.time.Format(time.RFC3339Nano), r.backend, r.id, r.kind)
+	if r.kind != traceClose {
+		s += " " + r.duration.String()
+	}
+
+	return s
+}
+
+func parseTraceRecord(line string) (traceRecord, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return traceRecord{}, errors.New("missing fields")
+	}
+
+	var r traceRecord
+	var err error
+	if r.time, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		return traceRecord{}, err
+	}
+	r.backend = fields[1]
+	if r.id, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return traceRecord{}, fmt.Errorf("notification id: %w", err)
+	}
+	r.kind = traceKind(fields[3])
+
+	switch r.kind {
+	case traceClose:
+		if len(fields) != 4 {
+			return traceRecord{}, errors.New("unexpected fields after close")
+		}
+	case traceAdd, traceDuration, traceIdle, traceResume:
+		if len(fields) != 5 {
+			return traceRecord{}, fmt.Errorf("%s requires a duration", r.kind)
+		}
+		if r.duration, err = time.ParseDuration(fields[4]); err != nil {
+			return traceRecord{}, err
+		}
+	default:
+		return traceRecord{}, fmt.Errorf("unknown kind %q", r.kind)
+	}
+
+	return r, nil
+}
+
+// readTrace reads the records of a trace written by WithRecorder.
+func readTrace(r io.Reader) ([]traceRecord, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTrace, err)
+		}
+		return nil, fmt.Errorf("%w: empty", ErrInvalidTrace)
+	}
+	if header := scanner.Text(); header != traceHeader {
+		return nil, fmt.Errorf("%w: header %q, want %q", ErrInvalidTrace, header, traceHeader)
+	}
+
+	var records []traceRecord
+	for line := 2; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		record, err := parseTraceRecord(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidTrace, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTrace, err)
+	}
+
+	return records, nil
+}
+
+// WithRecorder writes a trace of the notifications of the Controller to w: when they are added,
+// changed, and closed, and every idle and resume event. The trace can be replayed using
+// NewReplayController, e.g. to reproduce the events a user reported against a state machine.
+//
+// The trace is text, a header followed by a line per record. Writes to w are serialized and
+// happen on the goroutine of the record, w should not block. After a write fails, recording
+// stops and the error is included in Controller.Debug.
+func WithRecorder(w io.Writer) ControllerOption {
+	return func(o *controllerOptions) {
+		o.recorder = w
+	}
+}
+
+// recorder writes a trace. A nil recorder records nothing.
+type recorder struct {
+	backend string
+
+	mu     sync.Mutex
+	w      io.Writer
+	lastID uint64
+	// err is the error of the first write that failed, nothing is written afterwards.
+	err error
+}
+
+// newRecorder returns a recorder that writes the trace of backend to w, nil if w is nil.
+func newRecorder(w io.Writer, backend string) *recorder {
+	if w == nil {
+		return nil
+	}
+
+	r := &recorder{backend: backend, w: w}
+	r.write(traceHeader)
+
+	return r
+}
+
+// add records a new notification and returns its id, zero for a nil recorder.
+func (r *recorder) add(t time.Time, d time.Duration) uint64 {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	r.lastID++
+	id := r.lastID
+	r.mu.Unlock()
+
+	r.record(traceRecord{time: t, id: id, kind: traceAdd, duration: d})
+
+	return id
+}
+
+// record writes the record of the notification with the given id.
+func (r *recorder) record(record traceRecord) {
+	if r == nil {
+		return
+	}
+
+	record.backend = r.backend
+	r.write(record.String())
+}
+
+func (r *recorder) write(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+
+	if _, err := io.WriteString(r.w, line+"\n"); err != nil {
+		r.err = err
+	}
+}
+
+// debug describes the state of the recorder for Controller.Debug, empty for a nil recorder.
+func (r *recorder) debug() string {
+	if r == nil {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return fmt.Sprintf("recording failed: %v", r.err)
+	}
+
+	return fmt.Sprintf("recording, %d notification(s) recorded", r.lastID)
+}
@@ -0,0 +1,127 @@
+package idle
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	var b bytes.Buffer
+	r := newRecorder(&b, "wayland")
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	first := r.add(start, 5*time.Minute)
+	second := r.add(start, 10*time.Minute)
+	r.record(traceRecord{time: start.Add(5 * time.Minute), id: first, kind: traceIdle, duration: 5 * time.Minute})
+	r.record(traceRecord{time: start.Add(6 * time.Minute), id: second, kind: traceDuration, duration: time.Minute})
+	r.record(traceRecord{time: start.Add(6*time.Minute + 500*time.Millisecond), id: first, kind: traceResume, duration: 5 * time.Minute})
+	r.record(traceRecord{time: start.Add(7 * time.Minute), id: second, kind: traceClose})
+
+	want := `idle-trace v1
+2024-01-01T12:00:00Z wayland 1 add 5m0s
+2024-01-01T12:00:00Z wayland 2 add 10m0s
+2024-01-01T12:05:00Z wayland 1 idle 5m0s
+2024-01-01T12:06:00Z wayland 2 duration 1m0s
+2024-01-01T12:06:00.5Z wayland 1 resume 5m0s
+2024-01-01T12:07:00Z wayland 2 close
+`
+	if b.String() != want {
+		t.Fatalf("trace = \n%s\nwant\n%s", b.String(), want)
+	}
+
+	records, err := readTrace(&b)
+	if err != nil {
+		t.Fatalf("readTrace() error = %v", err)
+	}
+	var lines []string
+	for _, record := range records {
+		lines = append(lines, record.String())
+	}
+	if got := traceHeader + "\n" + strings.Join(lines, "\n") + "\n"; got != want {
+		t.Fatalf("records = \n%s\nwant\n%s", got, want)
+	}
+
+	var nilRecorder *recorder
+	if id := nilRecorder.add(start, time.Minute); id != 0 || nilRecorder.debug() != "" {
+		t.Fatalf("nil recorder add() = %d, debug() = %q", id, nilRecorder.debug())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRecorderWriteError(t *testing.T) {
+	r := newRecorder(failingWriter{}, "wayland")
+	r.add(time.Now(), time.Minute)
+	if debug := r.debug(); debug != "recording failed: disk full" {
+		t.Fatalf("debug() = %q", debug)
+	}
+}
+
+func TestReadTraceInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"other version":  "idle-trace v2\n",
+		"unknown kind":   traceHeader + "\n2024-01-01T12:00:00Z wayland 1 lock 5m0s\n",
+		"no duration":    traceHeader + "\n2024-01-01T12:00:00Z wayland 1 idle\n",
+		"close duration": traceHeader + "\n2024-01-01T12:00:00Z wayland 1 close 5m0s\n",
+		"bad time":       traceHeader + "\nnoon wayland 1 idle 5m0s\n",
+		"bad id":         traceHeader + "\n2024-01-01T12:00:00Z wayland one idle 5m0s\n",
+	}
+
+	for name, trace := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readTrace(strings.NewReader(trace)); !errors.Is(err, ErrInvalidTrace) {
+				t.Fatalf("readTrace() error = %v, want ErrInvalidTrace", err)
+			}
+		})
+	}
+}
+
+func TestWaylandRecording(t *testing.T) {
+	var b bytes.Buffer
+	m := newWaylandIdleController()
+	m.recorder = newRecorder(&b, "wayland")
+	input := &CreateIdleNotification{Events: make(chan Event, 4)}
+	n := &waylandIdleNotification{
+		controller: m,
+		duration:   time.Minute,
+		applied:    time.Minute,
+		id:         m.recorder.add(time.Now(), time.Minute),
+	}
+	n.fanOut = newFanOut(input, &n.stats, m.close, realClock{})
+
+	n.emit(EventIdle)
+	n.emit(EventResume)
+	if err := n.SetDuration(time.Hour); err != nil {
+		t.Fatalf("SetDuration() error = %v", err)
+	}
+	if err := n.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	records, err := readTrace(&b)
+	if err != nil {
+		t.Fatalf("readTrace() error = %v", err)
+	}
+	var kinds []traceKind
+	for _, record := range records {
+		if record.backend != "wayland" || record.id != 1 {
+			t.Errorf("record %s, want notification 1 of wayland", record)
+		}
+		kinds = append(kinds, record.kind)
+	}
+	want := []traceKind{traceAdd, traceIdle, traceResume, traceDuration, traceClose}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("recorded %v, want %v", kinds, want)
+	}
+	if !strings.Contains(m.Debug(), "recording, 1 notification(s) recorded") {
+		t.Errorf("Debug() = %q, want the recorder", m.Debug())
+	}
+}
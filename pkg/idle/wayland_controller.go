@@ -1,15 +1,36 @@
 package idle
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/MatthiasKunnen/go-wayland/wayland/client"
 	idleNotify "github.com/MatthiasKunnen/go-wayland/wayland/staging/ext-idle-notify-v1"
+	"io"
 	"math"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var ErrIdleNotifyNotSupported = errors.New("no notifier initialized, ext-idle-notify might not be supported")
 
+// The highest versions of the globals that the bindings support. Binding a higher version than
+// supported results in protocol errors when the display server sends unknown events.
+const (
+	supportedNotifierVersion = 2
+	supportedSeatVersion     = 10
+)
+
+// inputIdleNotifierVersion is the version of ext_idle_notifier_v1 that added
+// get_input_idle_notification.
+const inputIdleNotifierVersion = 2
+
 type waylandIdleController struct {
 	close chan struct{}
 	// The dispatch channel exists to synchronize the wayland communication which is not safe to be
@@ -18,58 +39,376 @@ type waylandIdleController struct {
 	display      *client.Display
 	notifier     *idleNotify.IdleNotifier
 	registry     *client.Registry
-	seat         *client.Seat
+	// notifierVersion is the version notifier is bound with.
+	notifierVersion uint32
+	// clock is the clock of the Time of the events and of the idle time of the notifications.
+	clock clock
+
+	muSeats sync.Mutex
+	seats   []*waylandSeat
+
+	// activity tracks the idle state of the default seat for IdleSince.
+	activity activity
+
+	muNotifications sync.Mutex
+	notifications   map[*waylandIdleNotification]struct{}
+	// maxNotifications is the limit of notifications, zero means no limit.
+	maxNotifications int
+	// traceNotifications enables recording the stack trace of AddNotification.
+	traceNotifications bool
+	// recorder writes the trace of WithRecorder, nil without.
+	recorder *recorder
+
+	runMode bool
+	running atomic.Bool
+	// muRun is held while Run starts or stops and while call executes a function because Run is
+	// not running.
+	muRun sync.Mutex
+	// runStopped is closed when the current Run returns, nil while Run is not running.
+	runStopped chan struct{}
+	// operations hands the functions of call to Run.
+	operations chan operation
+	// queued is the number of functions passed to dispatch that are neither executed nor dropped.
+	queued atomic.Int64
+
+	// done is closed when the controller is closed or the connection failed.
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// operation is a function executed by Run for a caller that waits for the result.
+type operation struct {
+	f      func() error
+	result chan error
+}
+
+type waylandSeat struct {
+	seat    *client.Seat
+	name    string
+	version uint32
 }
 
 type waylandIdleNotification struct {
-	closed       bool
-	controller   *waylandIdleController
+	controller *waylandIdleController
+	seat       *client.Seat
+	fanOut     *fanOut
+	stats      notificationStats
+	onError    func(err error)
+	// trace is the stack trace of the AddNotification call, only set with WithNotificationTraces.
+	trace string
+	// id identifies the notification in the trace of WithRecorder.
+	id uint64
+
+	mu     sync.Mutex
+	closed bool
+	// dead is set when the notification could not be recreated.
+	dead     bool
+	duration time.Duration
+
+	// The fields below are only accessed on the dispatch goroutine.
+
+	// applied is the duration notification was created with.
+	applied      time.Duration
 	notification *idleNotify.IdleNotification
+	// previous is a notification that was replaced by SetDuration while idle. It is kept alive
+	// until either it resumes or the replacement idles, so that no Resume is missed.
+	previous *idleNotify.IdleNotification
+	// isIdle is true when Idle has been sent without a following Resume.
+	isIdle bool
+	// idleAt is the time at which Idle was sent.
+	idleAt time.Time
 }
 
 func (n *waylandIdleNotification) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	if n.closed {
 		return nil
 	}
 	n.closed = true
+	n.controller.removeNotification(n)
+	n.fanOut.close()
+	n.controller.recorder.record(traceRecord{time: time.Now(), id: n.id, kind: traceClose})
 
-	go func() {
-		closeFunc := func() error {
-			// Destroy must be done in the same goroutine as dispatch and other
-			// Wayland interactions.
-			err := n.notification.Destroy()
-			if err != nil {
-				return fmt.Errorf("failed to close wayland idle notification: %w", err)
-			}
+	n.controller.dispatch(func() error {
+		// Destroy must be done in the same goroutine as dispatch and other
+		// Wayland interactions.
+		var err error
+		if n.previous != nil {
+			err = n.destroy(n.previous)
+			n.previous = nil
+		}
 
-			return nil
+		return n.report(errors.Join(err, n.destroy(n.notification)))
+	})
+
+	return nil
+}
+
+func (n *waylandIdleNotification) SetDuration(d time.Duration) error {
+	if _, err := durationToMs(d); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNotificationClosed
+	}
+	if n.dead {
+		return ErrNotificationDead
+	}
+	n.duration = d
+	n.controller.recorder.record(traceRecord{time: time.Now(), id: n.id, kind: traceDuration, duration: d})
+
+	n.controller.dispatch(n.applyDuration)
+
+	return nil
+}
+
+func (n *waylandIdleNotification) getDuration() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.duration
+}
+
+func (n *waylandIdleNotification) Stats() NotificationStats {
+	return n.stats.snapshot()
+}
+
+func (n *waylandIdleNotification) ResetStats() {
+	n.stats.reset()
+}
+
+func (n *waylandIdleNotification) LastIdlePeriod() (IdlePeriod, bool) {
+	return n.fanOut.lastIdlePeriod()
+}
+
+// applyDuration recreates the underlying notification if the requested duration differs from
+// the one in use. Must be called on the dispatch goroutine.
+//
+// When not idle, the replacement is armed and idles once the new duration has passed. When idle
+// and the session has been inactive for at least the new duration, it stays idle; the old
+// notification is kept to be informed of the resume. Otherwise, a Resume is emitted since the
+// session has not been idle for the new duration yet.
+func (n *waylandIdleNotification) applyDuration() error {
+	n.mu.Lock()
+	stopped := n.closed || n.dead
+	d := n.duration
+	n.mu.Unlock()
+
+	if stopped || d == n.applied {
+		return nil
+	}
+
+	notification, err := n.controller.getIdleNotification(d, n.seat)
+	if err != nil {
+		return n.die(err)
+	}
+	n.bind(notification)
+
+	old := n.notification
+	oldDuration := n.applied
+	n.notification = notification
+	n.applied = d
+
+	var totalError error
+	switch {
+	case !n.isIdle:
+		totalError = n.destroy(old)
+	case n.controller.clock.Now().Sub(n.idleAt.Add(-oldDuration)) >= d:
+		// Still idle according to the new duration. Keep the idle notification around to be
+		// informed of the resume, the replacement's idle event will be ignored.
+		if n.previous == nil {
+			n.previous = old
+		} else {
+			totalError = n.destroy(old)
+		}
+	default:
+		// The session has not been idle long enough to satisfy the new duration.
+		if n.previous != nil {
+			totalError = n.destroy(n.previous)
+			n.previous = nil
 		}
+		totalError = errors.Join(totalError, n.destroy(old))
+		n.isIdle = false
+		n.emit(EventResume)
+	}
 
-		select {
-		case <-n.controller.close:
-		case n.controller.dispatchChan <- closeFunc:
+	return n.report(totalError)
+}
+
+// die marks the notification dead after it could not be recreated and destroys the Wayland
+// notifications that are left. Must be called on the dispatch goroutine.
+func (n *waylandIdleNotification) die(cause error) error {
+	n.mu.Lock()
+	n.dead = true
+	n.mu.Unlock()
+
+	err := fmt.Errorf("idle notification is dead: %w", cause)
+	if n.previous != nil {
+		err = errors.Join(err, n.destroy(n.previous))
+		n.previous = nil
+	}
+	err = errors.Join(err, n.destroy(n.notification))
+	n.notification = nil
+
+	return n.report(err)
+}
+
+// report passes err to OnError, if set, and returns nil. Otherwise, err is returned so that it
+// reaches the consumer of the dispatch functions.
+func (n *waylandIdleNotification) report(err error) error {
+	if err == nil || n.onError == nil {
+		return err
+	}
+
+	n.onError(err)
+	return nil
+}
+
+// bind sets the event handlers of the given Wayland notification.
+func (n *waylandIdleNotification) bind(notification *idleNotify.IdleNotification) {
+	notification.SetIdledHandler(func(event idleNotify.IdleNotificationIdledEvent) {
+		if notification != n.notification {
+			return
 		}
-	}()
+
+		if n.previous != nil {
+			n.destroyLater(n.previous)
+			n.previous = nil
+		}
+
+		if n.isIdle {
+			return
+		}
+		n.isIdle = true
+		n.idleAt = n.controller.clock.Now()
+		n.emit(EventIdle)
+	})
+
+	notification.SetResumedHandler(func(event idleNotify.IdleNotificationResumedEvent) {
+		if notification == n.previous {
+			n.destroyLater(n.previous)
+			n.previous = nil
+		} else if notification != n.notification {
+			return
+		}
+
+		if !n.isIdle {
+			return
+		}
+		n.isIdle = false
+		n.emit(EventResume)
+	})
+}
+
+// emit delivers the event to the registered channels without blocking dispatch.
+func (n *waylandIdleNotification) emit(kind EventKind) {
+	event := Event{
+		Kind:     kind,
+		Time:     n.controller.clock.Now(),
+		Duration: n.applied,
+	}
+	n.fanOut.emit(event)
+
+	record := traceRecord{time: event.Time, id: n.id, kind: traceIdle, duration: event.Duration}
+	if kind == EventResume {
+		record.kind = traceResume
+	}
+	n.controller.recorder.record(record)
+}
+
+// destroy destroys the Wayland notification, if any.
+func (n *waylandIdleNotification) destroy(notification *idleNotify.IdleNotification) error {
+	if notification == nil {
+		return nil
+	}
+
+	err := notification.Destroy()
+	if err != nil {
+		return fmt.Errorf("failed to close wayland idle notification: %w", err)
+	}
 
 	return nil
 }
 
+// destroyLater destroys the notification in a later dispatch so that errors can be reported
+// from within event handlers.
+func (n *waylandIdleNotification) destroyLater(notification *idleNotify.IdleNotification) {
+	n.controller.dispatch(func() error {
+		return n.report(n.destroy(notification))
+	})
+}
+
+// ControllerOption configures a Controller.
+type ControllerOption func(o *controllerOptions)
+
+type controllerOptions struct {
+	run                bool
+	maxNotifications   int
+	traceNotifications bool
+	recorder           io.Writer
+}
+
+// WithRun selects Run mode. The Controller is driven by calling Controller.Run instead of
+// executing the functions of the dispatch channel, which is nil in this mode.
+func WithRun() ControllerOption {
+	return func(o *controllerOptions) {
+		o.run = true
+	}
+}
+
+// WithMaxNotifications limits the number of open notifications to n, AddNotification returns
+// ErrTooManyNotifications when the limit is reached. Protects the connection against consumers
+// that never close their notifications, display servers disconnect clients that create too many
+// objects. Every Notification counts once, also while SetDuration replaces the notification of
+// the display server. Zero, the default, means no limit.
+func WithMaxNotifications(n int) ControllerOption {
+	return func(o *controllerOptions) {
+		o.maxNotifications = n
+	}
+}
+
+// WithNotificationTraces records the stack trace of every AddNotification call and includes it
+// in Controller.Debug, to find the code that does not close its notifications. Capturing the
+// trace makes AddNotification slower, only use it for debugging.
+func WithNotificationTraces() ControllerOption {
+	return func(o *controllerOptions) {
+		o.traceNotifications = true
+	}
+}
+
 // NewWaylandIdleController sets up a new Wayland connection.
 // It returns:
 //   - The controller
 //   - The dispatch channel, execute the functions received on this channel on the same goroutine as
-//     other interactions with the Controller.
+//     other interactions with the Controller. Only one goroutine may consume this channel.
+//     Nil when WithRun is used.
 //   - Error that occurred when creating the controller.
-func NewWaylandIdleController() (Controller, <-chan func() error, error) {
-	m := &waylandIdleController{
-		close:        make(chan struct{}, 1),
-		dispatchChan: make(chan func() error),
+func NewWaylandIdleController(opts ...ControllerOption) (Controller, <-chan func() error, error) {
+	var options controllerOptions
+	for _, opt := range opts {
+		opt(&options)
 	}
+
+	m := newWaylandIdleController()
+	m.runMode = options.run
+	m.maxNotifications = max(options.maxNotifications, 0)
+	m.traceNotifications = options.traceNotifications
+	m.recorder = newRecorder(options.recorder, "wayland")
 	var err error
 	m.display, err = client.Connect("")
 	if err != nil {
 		return nil, nil, fmt.Errorf("error connecting to Wayland server: %w", err)
 	}
+	m.display.SetErrorHandler(func(e client.DisplayErrorEvent) {
+		// Errors sent by the compositor are fatal, the connection is unusable afterwards.
+		m.fail(fmt.Errorf("wayland display error, code %d: %s", e.Code, e.Message))
+	})
 
 	m.registry, err = m.display.GetRegistry()
 	if err != nil {
@@ -77,11 +416,15 @@ func NewWaylandIdleController() (Controller, <-chan func() error, error) {
 	}
 
 	var globalHandlerError error
+	var globals []string
 	m.registry.SetGlobalHandler(func(e client.RegistryGlobalEvent) {
+		globals = append(globals, fmt.Sprintf("%s v%d", e.Interface, e.Version))
+
 		switch e.Interface {
 		case idleNotify.IdleNotifierInterfaceName:
 			m.notifier = idleNotify.NewIdleNotifier(m.context())
-			err := m.registry.Bind(e.Name, idleNotify.IdleNotifierInterfaceName, e.Version, m.notifier)
+			m.notifierVersion = bindVersion(e.Version, supportedNotifierVersion)
+			err := m.registry.Bind(e.Name, idleNotify.IdleNotifierInterfaceName, m.notifierVersion, m.notifier)
 			if err != nil {
 				globalHandlerError = errors.Join(
 					globalHandlerError,
@@ -90,14 +433,26 @@ func NewWaylandIdleController() (Controller, <-chan func() error, error) {
 			}
 		case client.SeatInterfaceName:
 			seat := client.NewSeat(m.context())
-			err := m.registry.Bind(e.Name, e.Interface, e.Version, seat)
+			version := bindVersion(e.Version, supportedSeatVersion)
+			err := m.registry.Bind(e.Name, e.Interface, version, seat)
 			if err != nil {
 				globalHandlerError = errors.Join(
 					globalHandlerError,
 					fmt.Errorf("unable to bind %s interface: %v", client.SeatInterfaceName, err),
 				)
+				return
 			}
-			m.seat = seat
+
+			ws := &waylandSeat{seat: seat, version: version}
+			seat.SetNameHandler(func(e client.SeatNameEvent) {
+				m.muSeats.Lock()
+				defer m.muSeats.Unlock()
+				ws.name = e.Name
+			})
+
+			m.muSeats.Lock()
+			m.seats = append(m.seats, ws)
+			m.muSeats.Unlock()
 		}
 	})
 
@@ -117,20 +472,166 @@ func NewWaylandIdleController() (Controller, <-chan func() error, error) {
 	}
 
 	if m.notifier == nil {
-		return nil, nil, errors.Join(ErrIdleNotifyNotSupported, m.Close())
+		return nil, nil, errors.Join(notSupportedError(globals), m.Close())
 	}
 
-	go func() {
-		for {
-			select {
-			case m.dispatchChan <- m.display.Context().GetDispatch():
-			case <-m.close:
-				return
+	m.startActivity()
+
+	go m.readLoop()
+
+	if m.runMode {
+		return m, nil, nil
+	}
+
+	return m, m.dispatchChan, nil
+}
+
+// bindVersion returns the version to bind a global with, the lowest of the version advertised by
+// the display server and the version supported by the bindings.
+func bindVersion(advertised uint32, supported uint32) uint32 {
+	return min(advertised, supported)
+}
+
+// notSupportedError returns ErrIdleNotifyNotSupported listing the globals the display server
+// advertised, which tells which protocols the compositor does implement.
+func notSupportedError(globals []string) error {
+	return fmt.Errorf(
+		"%w, advertised globals: %s",
+		ErrIdleNotifyNotSupported,
+		strings.Join(globals, ", "),
+	)
+}
+
+func newWaylandIdleController() *waylandIdleController {
+	return &waylandIdleController{
+		close:         make(chan struct{}, 1),
+		dispatchChan:  make(chan func() error),
+		operations:    make(chan operation),
+		notifications: make(map[*waylandIdleNotification]struct{}),
+		done:          make(chan struct{}),
+		clock:         realClock{},
+	}
+}
+
+// readLoop reads incoming messages and hands out a dispatch function for each of them until the
+// controller is closed or reading fails.
+func (m *waylandIdleController) readLoop() {
+	ctx := m.context()
+	for {
+		senderID, opcode, fd, data, err := ctx.ReadMsg()
+		if err != nil {
+			m.fail(fmt.Errorf("%w: %w", client.ErrDispatchUnableToReadMsg, err))
+			return
+		}
+
+		dispatch := func() error {
+			sender := ctx.GetProxy(senderID)
+			if sender == nil {
+				return fmt.Errorf("%w (senderID=%d)", client.ErrDispatchSenderNotFound, senderID)
+			}
+			dispatcher, ok := sender.(client.Dispatcher)
+			if !ok {
+				return fmt.Errorf("%w (senderID=%d)", client.ErrDispatchSenderUnsupported, senderID)
 			}
+			dispatcher.Dispatch(opcode, fd, data)
+
+			return nil
+		}
+
+		select {
+		case <-m.done:
+			return
+		default:
 		}
+
+		select {
+		case m.dispatchChan <- dispatch:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// fail marks the controller as no longer working because of a fatal connection error.
+// Has no effect if the controller is already closed or failed.
+func (m *waylandIdleController) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+
+	m.err = fmt.Errorf("%w: %w", ErrConnectionLost, err)
+	close(m.done)
+}
+
+func (m *waylandIdleController) Run(ctx context.Context) error {
+	if !m.runMode || !m.running.CompareAndSwap(false, true) {
+		return ErrDispatchOwned
+	}
+	defer m.running.Store(false)
+
+	m.muRun.Lock()
+	stopped := make(chan struct{})
+	m.runStopped = stopped
+	m.muRun.Unlock()
+	defer func() {
+		m.muRun.Lock()
+		m.runStopped = nil
+		close(stopped)
+		m.muRun.Unlock()
 	}()
 
-	return m, m.dispatchChan, nil
+	for {
+		// A done context takes precedence over the functions that are ready
+		if ctx.Err() != nil {
+			if err := m.shutdown(); err != nil {
+				return errors.Join(ctx.Err(), err)
+			}
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-m.done:
+			return m.Err()
+		case op := <-m.operations:
+			op.result <- op.f()
+		case f := <-m.dispatchChan:
+			if err := f(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// shutdown is the teardown of Run once its context is done. The functions queued by dispatch are
+// executed, so that the notifications closed before are destroyed, and the controller is closed,
+// which fails the pending calls with ErrControllerClosed.
+func (m *waylandIdleController) shutdown() error {
+	var err error
+	for m.queued.Load() > 0 {
+		select {
+		case f := <-m.dispatchChan:
+			err = errors.Join(err, f())
+		case <-m.done:
+			// The queued functions are dropped
+			return errors.Join(err, m.Close())
+		}
+	}
+
+	return errors.Join(err, m.Close())
+}
+
+func (m *waylandIdleController) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *waylandIdleController) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
 }
 
 func (m *waylandIdleController) context() *client.Context {
@@ -138,12 +639,27 @@ func (m *waylandIdleController) context() *client.Context {
 }
 
 func (m *waylandIdleController) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	if m.err == nil {
+		close(m.done)
+	}
+	m.err = ErrControllerClosed
+	m.mu.Unlock()
+
 	var totalError error
-	if m.seat != nil {
-		if err := m.seat.Release(); err != nil {
-			totalError = errors.Join(totalError, fmt.Errorf("error releasing seat: %w", err))
+	m.muSeats.Lock()
+	for _, ws := range m.seats {
+		if err := ws.seat.Release(); err != nil {
+			totalError = errors.Join(totalError, fmt.Errorf("error releasing seat %q: %w", ws.name, err))
 		}
 	}
+	m.seats = nil
+	m.muSeats.Unlock()
 
 	if m.display != nil {
 		err := m.display.Destroy()
@@ -151,6 +667,11 @@ func (m *waylandIdleController) Close() error {
 			totalError = errors.Join(totalError, fmt.Errorf("error destroying display: %w", err))
 		}
 	}
+	if m.activity.notification != nil {
+		if err := m.activity.notification.Destroy(); err != nil {
+			totalError = errors.Join(totalError, fmt.Errorf("error destroying activity notification: %w", err))
+		}
+	}
 	if m.notifier != nil {
 		if err := m.notifier.Destroy(); err != nil {
 			totalError = errors.Join(totalError, fmt.Errorf(
@@ -163,62 +684,301 @@ func (m *waylandIdleController) Close() error {
 
 	close(m.close)
 
-	if err := m.context().Close(); err != nil {
-		totalError = errors.Join(totalError, fmt.Errorf("error closing wayland connection: %w", err))
+	if m.display != nil {
+		if err := m.context().Close(); err != nil {
+			totalError = errors.Join(totalError, fmt.Errorf("error closing wayland connection: %w", err))
+		}
 	}
 
 	return totalError
 }
 
+func (m *waylandIdleController) Debug() string {
+	m.muNotifications.Lock()
+	notifications := make([]*waylandIdleNotification, 0, len(m.notifications))
+	for n := range m.notifications {
+		notifications = append(notifications, n)
+	}
+	m.muNotifications.Unlock()
+
+	slices.SortFunc(notifications, func(a, b *waylandIdleNotification) int {
+		return cmp.Compare(a.getDuration(), b.getDuration())
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "wayland idle controller, %d notification(s)\n", len(notifications))
+	for _, n := range notifications {
+		fmt.Fprintf(&b, "  %s: %s\n", n.getDuration(), n.Stats())
+		if n.trace != "" {
+			fmt.Fprintf(&b, "    created at:\n")
+			for _, line := range strings.Split(strings.TrimSpace(n.trace), "\n") {
+				fmt.Fprintf(&b, "      %s\n", line)
+			}
+		}
+	}
+	if debug := m.recorder.debug(); debug != "" {
+		fmt.Fprintf(&b, "  %s\n", debug)
+	}
+
+	return b.String()
+}
+
+func (m *waylandIdleController) Seats() []SeatInfo {
+	m.muSeats.Lock()
+	defer m.muSeats.Unlock()
+
+	seats := make([]SeatInfo, 0, len(m.seats))
+	for _, ws := range m.seats {
+		seats = append(seats, SeatInfo{Name: ws.name, Version: ws.version})
+	}
+
+	return seats
+}
+
+func (m *waylandIdleController) Capabilities() Capabilities {
+	m.muSeats.Lock()
+	defer m.muSeats.Unlock()
+
+	c := Capabilities{
+		NotifierVersion: m.notifierVersion,
+		InputIdle:       m.notifierVersion >= inputIdleNotifierVersion,
+	}
+	if len(m.seats) > 0 {
+		c.SeatVersion = m.seats[0].version
+	}
+
+	return c
+}
+
+// getSeat returns the seat with the given name, or the default seat if name is empty.
+func (m *waylandIdleController) getSeat(name string) (*client.Seat, error) {
+	m.muSeats.Lock()
+	defer m.muSeats.Unlock()
+
+	names := make([]string, 0, len(m.seats))
+	for _, ws := range m.seats {
+		names = append(names, ws.name)
+	}
+
+	i, err := selectSeat(names, name, os.Getenv("XDG_SEAT"))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.seats[i].seat, nil
+}
+
+// selectSeat returns the index of the seat with the requested name. If requested is empty, the
+// seat named xdgSeat is selected, falling back to the first seat.
+func selectSeat(names []string, requested string, xdgSeat string) (int, error) {
+	if len(names) == 0 {
+		return 0, fmt.Errorf("%w: no seats announced", ErrSeatNotFound)
+	}
+
+	if requested != "" {
+		i := slices.Index(names, requested)
+		if i == -1 {
+			return 0, fmt.Errorf("%w: %q", ErrSeatNotFound, requested)
+		}
+		return i, nil
+	}
+
+	if xdgSeat != "" {
+		if i := slices.Index(names, xdgSeat); i != -1 {
+			return i, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (m *waylandIdleController) Stats() ControllerStats {
+	m.muNotifications.Lock()
+	defer m.muNotifications.Unlock()
+
+	return ControllerStats{
+		Notifications:    len(m.notifications),
+		MaxNotifications: m.maxNotifications,
+	}
+}
+
+// addNotification registers n, returns ErrTooManyNotifications if the limit is reached.
+func (m *waylandIdleController) addNotification(n *waylandIdleNotification) error {
+	m.muNotifications.Lock()
+	defer m.muNotifications.Unlock()
+
+	if m.maxNotifications > 0 && len(m.notifications) >= m.maxNotifications {
+		return fmt.Errorf("%w, limit is %d", ErrTooManyNotifications, m.maxNotifications)
+	}
+	m.notifications[n] = struct{}{}
+
+	return nil
+}
+
+func (m *waylandIdleController) removeNotification(n *waylandIdleNotification) {
+	m.muNotifications.Lock()
+	defer m.muNotifications.Unlock()
+
+	delete(m.notifications, n)
+}
+
+// dispatch executes f on the dispatch goroutine.
+// It does not block and is therefore safe to be called from the dispatch goroutine.
+func (m *waylandIdleController) dispatch(f func() error) {
+	m.queued.Add(1)
+	queued := func() error {
+		m.queued.Add(-1)
+		return f()
+	}
+
+	go func() {
+		select {
+		case <-m.done:
+			m.queued.Add(-1)
+			return
+		default:
+		}
+
+		select {
+		case <-m.done:
+			m.queued.Add(-1)
+		case m.dispatchChan <- queued:
+		}
+	}()
+}
+
+// call executes f on the dispatch goroutine and returns its error. In Run mode, f is handed to Run
+// when it is running. Otherwise, f is executed on the calling goroutine while Run is kept from
+// starting. Returns the error of Err if the controller stops before f is executed.
+// Outside of Run mode, the caller is the dispatch goroutine and f is executed directly.
+// In Run mode, call must not be used from the dispatch goroutine.
+func (m *waylandIdleController) call(f func() error) error {
+	if !m.runMode {
+		return f()
+	}
+
+	op := operation{f: f, result: make(chan error, 1)}
+	for {
+		m.muRun.Lock()
+		stopped := m.runStopped
+		if stopped == nil {
+			err := m.Err()
+			if err == nil {
+				err = f()
+			}
+			m.muRun.Unlock()
+			return err
+		}
+		m.muRun.Unlock()
+
+		select {
+		case m.operations <- op:
+			return <-op.result
+		case <-m.done:
+			return m.Err()
+		case <-stopped:
+			// Run returned without executing f, execute it here or by the next Run
+		}
+	}
+}
+
+// getIdleNotification creates a new Wayland idle notification for the given duration and seat.
+func (m *waylandIdleController) getIdleNotification(
+	d time.Duration,
+	seat *client.Seat,
+) (*idleNotify.IdleNotification, error) {
+	durationMs, err := durationToMs(d)
+	if err != nil {
+		return nil, err
+	}
+
+	notification, err := m.notifier.GetIdleNotification(durationMs, seat)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get idle notification: %w", err)
+	}
+
+	return notification, nil
+}
+
+// durationToMs converts the duration to the milliseconds expected by the Wayland protocol.
+// Negative durations are treated as zero.
+func durationToMs(d time.Duration) (uint32, error) {
+	durationMs := d.Milliseconds()
+	switch {
+	case durationMs > math.MaxUint32:
+		return 0, fmt.Errorf("duration too large, %d > %d", durationMs, math.MaxUint32)
+	case durationMs < 0:
+		durationMs = 0
+	}
+
+	return uint32(durationMs), nil
+}
+
 // AddNotification registers notification handlers on idle and resume.
 // idleEvent will be called when after the session is idle for the given duration.
 // resumeEvent will be called when the session is active again after being idle for the given
 // duration.
-// One of idleEvent or resumeEvent must be non-nil.
+// One of idleEvent, resumeEvent, or Events must be non-nil.
 func (m *waylandIdleController) AddNotification(notificationInput *CreateIdleNotification) (Notification, error) {
-	if notificationInput.Idle == nil && notificationInput.Resume == nil {
-		return nil, fmt.Errorf("either Idle or Resume is required")
+	if notificationInput.Idle == nil && notificationInput.Resume == nil &&
+		notificationInput.Events == nil {
+		return nil, fmt.Errorf("either Idle, Resume, or Events is required")
 	}
 
-	durationMs := notificationInput.Duration.Milliseconds()
-	switch {
-	case durationMs > math.MaxUint32:
-		return nil, fmt.Errorf("duration too large, %d > %d", durationMs, math.MaxUint32)
-	case durationMs < 0:
-		durationMs = 0
+	if err := m.Err(); err != nil {
+		return nil, err
+	}
+
+	if mask := notificationInput.ActivityMask; mask != 0 && mask != ActivityAll {
+		return nil, fmt.Errorf("%w: ActivityMask %s, ext-idle-notify does not distinguish input kinds",
+			ErrUnsupported, mask)
+	}
+	if len(notificationInput.Devices) > 0 {
+		return nil, fmt.Errorf("%w: Devices, ext-idle-notify does not distinguish input devices",
+			ErrUnsupported)
 	}
 
-	notification, err := m.notifier.GetIdleNotification(uint32(durationMs), m.seat)
+	seat, err := m.getSeat(notificationInput.SeatName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get idle notification: %w", err)
+		return nil, err
+	}
+
+	n := &waylandIdleNotification{
+		controller: m,
+		seat:       seat,
+		duration:   notificationInput.Duration,
+		applied:    notificationInput.Duration,
+		onError:    notificationInput.OnError,
+	}
+	if m.traceNotifications {
+		n.trace = string(debug.Stack())
+	}
+
+	// Registering first makes concurrent calls respect the limit
+	if err := m.addNotification(n); err != nil {
+		return nil, err
+	}
+
+	n.id = m.recorder.add(time.Now(), notificationInput.Duration)
+
+	// The handlers of the notification run on the dispatch goroutine, create and bind it there
+	err = m.call(func() error {
+		notification, err := m.getIdleNotification(notificationInput.Duration, seat)
+		if err != nil {
+			return err
+		}
+
+		n.notification = notification
+		n.fanOut = newFanOut(notificationInput, &n.stats, m.close, m.clock)
+		n.bind(notification)
+
+		return nil
+	})
+	if err != nil {
+		m.removeNotification(n)
+		m.recorder.record(traceRecord{time: time.Now(), id: n.id, kind: traceClose})
+		return nil, err
 	}
 
-	if notificationInput.Idle != nil {
-		notification.SetIdledHandler(func(event idleNotify.IdleNotificationIdledEvent) {
-			go func() {
-				// Execute in goroutine to prevent blocking dispatch
-				select {
-				case notificationInput.Idle <- struct{}{}:
-				case <-m.close:
-				}
-			}()
-		})
-	}
-
-	if notificationInput.Resume != nil {
-		notification.SetResumedHandler(func(event idleNotify.IdleNotificationResumedEvent) {
-			go func() {
-				// Execute in goroutine to prevent blocking dispatch
-				select {
-				case notificationInput.Resume <- struct{}{}:
-				case <-m.close:
-				}
-			}()
-		})
-	}
-
-	return &waylandIdleNotification{
-		controller:   m,
-		notification: notification,
-	}, nil
+	return n, nil
 }
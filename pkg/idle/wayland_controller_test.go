@@ -0,0 +1,701 @@
+package idle
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/go-wayland/wayland/client"
+	idleNotify "github.com/MatthiasKunnen/go-wayland/wayland/staging/ext-idle-notify-v1"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaylandIdleControllerFail(t *testing.T) {
+	m := newWaylandIdleController()
+
+	select {
+	case <-m.Done():
+		t.Fatal("Done closed before failure")
+	default:
+	}
+	if err := m.Err(); err != nil {
+		t.Fatalf("Err() = %v, want nil", err)
+	}
+
+	cause := errors.New("broken pipe")
+	m.fail(cause)
+	m.fail(errors.New("second failure"))
+
+	select {
+	case <-m.Done():
+	default:
+		t.Fatal("Done not closed after failure")
+	}
+	if err := m.Err(); !errors.Is(err, ErrConnectionLost) || !errors.Is(err, cause) {
+		t.Errorf("Err() = %v, want ErrConnectionLost wrapping %v", err, cause)
+	}
+
+	_, err := m.AddNotification(&CreateIdleNotification{Idle: make(chan struct{})})
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("AddNotification() error = %v, want ErrConnectionLost", err)
+	}
+
+	m.dispatch(func() error { return nil })
+	select {
+	case <-m.dispatchChan:
+		t.Error("dispatch function handed out after failure")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWaylandIdleControllerClose(t *testing.T) {
+	m := newWaylandIdleController()
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+
+	select {
+	case <-m.Done():
+	default:
+		t.Fatal("Done not closed after Close")
+	}
+	if err := m.Err(); !errors.Is(err, ErrControllerClosed) {
+		t.Errorf("Err() = %v, want ErrControllerClosed", err)
+	}
+
+	_, err := m.AddNotification(&CreateIdleNotification{Idle: make(chan struct{})})
+	if !errors.Is(err, ErrControllerClosed) {
+		t.Errorf("AddNotification() error = %v, want ErrControllerClosed", err)
+	}
+}
+
+func TestSelectSeat(t *testing.T) {
+	tests := []struct {
+		name      string
+		seats     []string
+		requested string
+		xdgSeat   string
+		want      int
+		wantErr   error
+	}{
+		{"first by default", []string{"seat0", "seat1"}, "", "", 0, nil},
+		{"XDG_SEAT", []string{"seat0", "seat1"}, "", "seat1", 1, nil},
+		{"unknown XDG_SEAT", []string{"seat0", "seat1"}, "", "seat9", 0, nil},
+		{"requested", []string{"seat0", "seat1"}, "seat1", "seat0", 1, nil},
+		{"requested unknown", []string{"seat0", "seat1"}, "seat9", "", 0, ErrSeatNotFound},
+		{"unnamed seats", []string{"", ""}, "", "seat0", 0, nil},
+		{"no seats", nil, "", "", 0, ErrSeatNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectSeat(tt.seats, tt.requested, tt.xdgSeat)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("selectSeat() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("selectSeat() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		advertised uint32
+		supported  uint32
+		want       uint32
+	}{
+		{"newer compositor", 9, supportedSeatVersion - 2, supportedSeatVersion - 2},
+		{"older compositor", 1, supportedNotifierVersion, 1},
+		{"same", supportedNotifierVersion, supportedNotifierVersion, supportedNotifierVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bindVersion(tt.advertised, tt.supported); got != tt.want {
+				t.Errorf("bindVersion(%d, %d) = %d, want %d", tt.advertised, tt.supported, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	m := newWaylandIdleController()
+	m.notifierVersion = 1
+	m.seats = []*waylandSeat{{name: "seat0", version: 9}}
+
+	want := Capabilities{NotifierVersion: 1, SeatVersion: 9}
+	if got := m.Capabilities(); got != want {
+		t.Errorf("Capabilities() = %+v, want %+v", got, want)
+	}
+
+	m.notifierVersion = 2
+	if !m.Capabilities().InputIdle {
+		t.Error("Capabilities().InputIdle = false for notifier version 2")
+	}
+}
+
+func TestNotSupportedError(t *testing.T) {
+	err := notSupportedError([]string{"wl_compositor v6", "wl_seat v9"})
+	if !errors.Is(err, ErrIdleNotifyNotSupported) {
+		t.Fatalf("error = %v, want ErrIdleNotifyNotSupported", err)
+	}
+	if !strings.Contains(err.Error(), "wl_compositor v6, wl_seat v9") {
+		t.Errorf("error %q does not list the advertised globals", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	m := newWaylandIdleController()
+	m.runMode = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := make(chan error, 1)
+	go func() {
+		first <- m.Run(ctx)
+	}()
+
+	executed := make(chan struct{})
+	m.dispatch(func() error {
+		close(executed)
+		return nil
+	})
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch function not executed by Run")
+	}
+
+	if err := m.Run(ctx); !errors.Is(err, ErrDispatchOwned) {
+		t.Fatalf("second Run() error = %v, want ErrDispatchOwned", err)
+	}
+
+	// The first Run is unaffected by the rejected one
+	executed = make(chan struct{})
+	m.dispatch(func() error {
+		close(executed)
+		return nil
+	})
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch function not executed after rejected Run")
+	}
+
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the context was canceled")
+	}
+}
+
+func TestRunReturnsDispatchError(t *testing.T) {
+	m := newWaylandIdleController()
+	m.runMode = true
+
+	want := errors.New("dispatch failed")
+	m.dispatch(func() error { return want })
+	if err := m.Run(context.Background()); !errors.Is(err, want) {
+		t.Fatalf("Run() error = %v, want %v", err, want)
+	}
+
+	m.fail(errors.New("broken pipe"))
+	if err := m.Run(context.Background()); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("Run() after failure error = %v, want ErrConnectionLost", err)
+	}
+}
+
+func TestRunWithoutRunMode(t *testing.T) {
+	m := newWaylandIdleController()
+
+	if err := m.Run(context.Background()); !errors.Is(err, ErrDispatchOwned) {
+		t.Fatalf("Run() error = %v, want ErrDispatchOwned", err)
+	}
+}
+
+// startRun starts Run and blocks it in a dispatch function until the returned function is called.
+func startRun(t *testing.T, m *waylandIdleController, ctx context.Context) (<-chan error, func()) {
+	t.Helper()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- m.Run(ctx)
+	}()
+
+	blocking := make(chan struct{})
+	release := make(chan struct{})
+	m.dispatch(func() error {
+		close(blocking)
+		<-release
+		return nil
+	})
+	select {
+	case <-blocking:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch function not executed by Run")
+	}
+
+	return runErr, sync.OnceFunc(func() { close(release) })
+}
+
+func receiveErr(t *testing.T, c <-chan error, what string) error {
+	t.Helper()
+
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return", what)
+		return nil
+	}
+}
+
+func TestCallWithoutRunning(t *testing.T) {
+	m := newWaylandIdleController()
+	m.runMode = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var order []string
+	runErr := make(chan error, 1)
+	dispatched := make(chan struct{})
+	err := m.call(func() error {
+		order = append(order, "call")
+		go func() {
+			runErr <- m.Run(ctx)
+		}()
+		m.dispatch(func() error {
+			order = append(order, "dispatch")
+			close(dispatched)
+			return nil
+		})
+		// Give Run the opportunity to execute the dispatch function too early
+		time.Sleep(20 * time.Millisecond)
+		order = append(order, "call done")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("call() error = %v", err)
+	}
+
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch function not executed by Run")
+	}
+	if want := []string{"call", "call done", "dispatch"}; !slices.Equal(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+
+	// Run is running, it executes the function of call
+	if err := m.call(func() error { return nil }); err != nil {
+		t.Fatalf("call() during Run error = %v", err)
+	}
+
+	cancel()
+	if err := receiveErr(t, runErr, "Run"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	t.Run("before Run", func(t *testing.T) {
+		m := newWaylandIdleController()
+		m.runMode = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := m.Run(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run() error = %v, want context.Canceled", err)
+		}
+		if err := m.call(func() error { return nil }); !errors.Is(err, ErrControllerClosed) {
+			t.Fatalf("call() after Run error = %v, want ErrControllerClosed", err)
+		}
+	})
+
+	t.Run("with queued functions and pending calls", func(t *testing.T) {
+		m := newWaylandIdleController()
+		m.runMode = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr, release := startRun(t, m, ctx)
+		defer release()
+
+		var executed atomic.Int32
+		for range 10 {
+			m.dispatch(func() error {
+				executed.Add(1)
+				return nil
+			})
+		}
+
+		callErr := make(chan error, 3)
+		for range cap(callErr) {
+			go func() {
+				callErr <- m.call(func() error {
+					t.Error("call executed after the context was canceled")
+					return nil
+				})
+			}()
+		}
+
+		cancel()
+		release()
+
+		if err := receiveErr(t, runErr, "Run"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run() error = %v, want context.Canceled", err)
+		}
+		if n := executed.Load(); n != 10 {
+			t.Errorf("%d queued functions executed, want 10", n)
+		}
+		for range cap(callErr) {
+			if err := receiveErr(t, callErr, "call"); !errors.Is(err, ErrControllerClosed) {
+				t.Errorf("call() error = %v, want ErrControllerClosed", err)
+			}
+		}
+		if err := m.Err(); !errors.Is(err, ErrControllerClosed) {
+			t.Errorf("Err() = %v, want ErrControllerClosed", err)
+		}
+	})
+
+	t.Run("during call", func(t *testing.T) {
+		m := newWaylandIdleController()
+		m.runMode = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr, release := startRun(t, m, ctx)
+		release()
+
+		started := make(chan struct{})
+		callErr := make(chan error, 1)
+		go func() {
+			callErr <- m.call(func() error {
+				close(started)
+				// Dispatched while Run waits for this function, executed by the shutdown
+				m.dispatch(func() error { return nil })
+				<-ctx.Done()
+				return nil
+			})
+		}()
+		<-started
+		cancel()
+
+		if err := receiveErr(t, callErr, "call"); err != nil {
+			t.Fatalf("call() error = %v, want the result of the function", err)
+		}
+		if err := receiveErr(t, runErr, "Run"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run() error = %v, want context.Canceled", err)
+		}
+		if n := m.queued.Load(); n != 0 {
+			t.Errorf("%d functions still queued", n)
+		}
+	})
+
+	t.Run("after dispatch error", func(t *testing.T) {
+		m := newWaylandIdleController()
+		m.runMode = true
+
+		want := errors.New("dispatch failed")
+		failing := make(chan struct{})
+		fail := make(chan struct{})
+		m.dispatch(func() error {
+			close(failing)
+			<-fail
+			return want
+		})
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- m.Run(context.Background())
+		}()
+		<-failing
+
+		callErr := make(chan error, 1)
+		go func() {
+			callErr <- m.call(func() error { return nil })
+		}()
+		close(fail)
+
+		if err := receiveErr(t, runErr, "Run"); !errors.Is(err, want) {
+			t.Fatalf("Run() error = %v, want %v", err, want)
+		}
+		// Run stopped without closing the controller, the call is executed on its own goroutine
+		if err := receiveErr(t, callErr, "call"); err != nil {
+			t.Fatalf("call() error = %v", err)
+		}
+	})
+}
+
+func TestNotificationDead(t *testing.T) {
+	tests := []struct {
+		name    string
+		onError bool
+	}{
+		{name: "OnError", onError: true},
+		{name: "dispatch", onError: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newWaylandIdleController()
+			input := &CreateIdleNotification{Idle: make(chan struct{})}
+			var reported []error
+			if tt.onError {
+				input.OnError = func(err error) {
+					reported = append(reported, err)
+				}
+			}
+			n := &waylandIdleNotification{
+				controller: m,
+				duration:   time.Minute,
+				applied:    time.Minute,
+				onError:    input.OnError,
+			}
+			n.fanOut = newFanOut(input, &n.stats, m.close, realClock{})
+
+			cause := errors.New("connection reset")
+			err := n.die(cause)
+			if tt.onError {
+				if err != nil || len(reported) != 1 || !errors.Is(reported[0], cause) {
+					t.Fatalf("die() = %v, reported %v, want %v reported", err, reported, cause)
+				}
+			} else if !errors.Is(err, cause) {
+				t.Fatalf("die() = %v, want %v", err, cause)
+			}
+
+			if err := n.SetDuration(time.Second); !errors.Is(err, ErrNotificationDead) {
+				t.Fatalf("SetDuration() error = %v, want ErrNotificationDead", err)
+			}
+			if err := n.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			select {
+			case f := <-m.dispatchChan:
+				if err := f(); err != nil {
+					t.Fatalf("Close dispatch error = %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Close did not dispatch")
+			}
+		})
+	}
+}
+
+func TestMaxNotifications(t *testing.T) {
+	m := newWaylandIdleController()
+	m.maxNotifications = 2
+	m.traceNotifications = true
+
+	first := &waylandIdleNotification{controller: m, duration: time.Minute, trace: "goroutine 1\nleak()"}
+	second := &waylandIdleNotification{controller: m, duration: time.Hour}
+	for _, n := range []*waylandIdleNotification{first, second} {
+		if err := m.addNotification(n); err != nil {
+			t.Fatalf("addNotification() error = %v", err)
+		}
+	}
+
+	err := m.addNotification(&waylandIdleNotification{controller: m})
+	if !errors.Is(err, ErrTooManyNotifications) {
+		t.Fatalf("addNotification() error = %v, want ErrTooManyNotifications", err)
+	}
+	want := ControllerStats{Notifications: 2, MaxNotifications: 2}
+	if got := m.Stats(); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+	if debug := m.Debug(); !strings.Contains(debug, "      leak()") {
+		t.Fatalf("Debug() does not contain the creation trace:\n%s", debug)
+	}
+
+	m.removeNotification(first)
+	if err := m.addNotification(first); err != nil {
+		t.Fatalf("addNotification() after removing error = %v", err)
+	}
+}
+
+func TestIdleSince(t *testing.T) {
+	m := newWaylandIdleController()
+	clk := newFakeClock()
+	m.clock = clk
+
+	if _, idle, err := m.IdleSince(); err != nil || idle {
+		t.Fatalf("IdleSince() = %t, %v, want active", idle, err)
+	}
+
+	now := clk.Now()
+	m.activity.idled(now.Add(-time.Minute))
+	since, idle, err := m.IdleSince()
+	if err != nil || !idle || !since.Equal(now.Add(-time.Minute-activityDuration)) {
+		t.Fatalf("IdleSince() = %s, %t, %v", since, idle, err)
+	}
+	for d, want := range map[time.Duration]bool{time.Minute: true, time.Hour: false} {
+		if got, err := m.IsIdleFor(d); err != nil || got != want {
+			t.Errorf("IsIdleFor(%s) = %t, %v, want %t", d, got, err, want)
+		}
+	}
+
+	m.activity.resumed()
+	if got, err := m.IsIdleFor(0); err != nil || got {
+		t.Fatalf("IsIdleFor(0) after resume = %t, %v, want false", got, err)
+	}
+
+	m.activity.err = ErrSeatNotFound
+	if _, _, err := m.IdleSince(); !errors.Is(err, ErrSeatNotFound) {
+		t.Fatalf("IdleSince() error = %v, want ErrSeatNotFound", err)
+	}
+
+	m.fail(errors.New("broken pipe"))
+	if _, err := m.IsIdleFor(0); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("IsIdleFor() error = %v, want ErrConnectionLost", err)
+	}
+}
+
+func TestActivityMaskUnsupported(t *testing.T) {
+	m := newWaylandIdleController()
+
+	_, err := m.AddNotification(&CreateIdleNotification{
+		Duration:     time.Minute,
+		Idle:         make(chan struct{}),
+		ActivityMask: ActivityKeyboard,
+	})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("AddNotification() error = %v, want ErrUnsupported", err)
+	}
+	if m.Capabilities().ActivityMask {
+		t.Error("Capabilities().ActivityMask = true")
+	}
+
+	if got := (ActivityKeyboard | ActivityTouch | 1<<7).String(); got != "keyboard|touch|Activity(0x80)" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// newTestWaylandController returns a controller connected to a socket that reads the requests
+// and never answers, for code that sends requests without waiting for events.
+func newTestWaylandController(t *testing.T) (*waylandIdleController, *client.Seat) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wayland-0")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	display, err := client.Connect(path)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = display.Context().Close()
+	})
+
+	m := newWaylandIdleController()
+	m.display = display
+	m.notifier = idleNotify.NewIdleNotifier(display.Context())
+
+	return m, client.NewSeat(display.Context())
+}
+
+func TestApplyDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		// idleFor is how long the notification has been idle, not idle when zero.
+		idleFor  time.Duration
+		duration time.Duration
+		// wantIdle is whether the notification is still idle afterward.
+		wantIdle bool
+		// wantResume is whether a Resume is emitted.
+		wantResume bool
+	}{
+		{name: "not idle re-arms", duration: 5 * time.Minute},
+		{name: "idle past the shorter duration", idleFor: time.Minute, duration: 5 * time.Minute, wantIdle: true},
+		{name: "idle for exactly the duration", idleFor: time.Minute, duration: 11 * time.Minute, wantIdle: true},
+		{name: "idle short of the longer duration", idleFor: time.Minute, duration: 20 * time.Minute, wantResume: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, seat := newTestWaylandController(t)
+			clk := newFakeClock()
+			m.clock = clk
+
+			const oldDuration = 10 * time.Minute
+			input := &CreateIdleNotification{Duration: oldDuration, Events: make(chan Event, 2)}
+			n := &waylandIdleNotification{
+				controller: m,
+				seat:       seat,
+				duration:   tt.duration,
+				applied:    oldDuration,
+			}
+			n.fanOut = newFanOut(input, &n.stats, m.close, clk)
+			defer n.fanOut.close()
+			old, err := m.getIdleNotification(oldDuration, seat)
+			if err != nil {
+				t.Fatalf("getIdleNotification() error = %v", err)
+			}
+			n.notification = old
+			n.bind(old)
+
+			if tt.idleFor > 0 {
+				n.isIdle = true
+				n.idleAt = clk.Now()
+				n.emit(EventIdle)
+				clk.set(clk.Now().Add(tt.idleFor))
+			}
+			before := n.Stats()
+
+			if err := n.applyDuration(); err != nil {
+				t.Fatalf("applyDuration() error = %v", err)
+			}
+
+			if n.applied != tt.duration || n.notification == old {
+				t.Errorf("notification of %s not replaced by one of %s", oldDuration, tt.duration)
+			}
+			if n.isIdle != tt.wantIdle {
+				t.Errorf("idle = %t, want %t", n.isIdle, tt.wantIdle)
+			}
+			// The old notification is kept to be informed of the resume
+			if keptOld := n.previous == old; keptOld != tt.wantIdle {
+				t.Errorf("old notification kept = %t, want %t", keptOld, tt.wantIdle)
+			}
+			resumes := n.Stats().Resume - before.Resume
+			if resumed := resumes > 0; resumed != tt.wantResume {
+				t.Fatalf("%d Resume events emitted, want Resume %t", resumes, tt.wantResume)
+			}
+			if tt.wantResume {
+				period, ok := n.LastIdlePeriod()
+				if !ok || period.Wall != tt.idleFor || period.Monotonic != tt.idleFor {
+					t.Errorf("LastIdlePeriod() = %+v, %t, want %s measured by the clock", period, ok, tt.idleFor)
+				}
+			}
+		})
+	}
+}
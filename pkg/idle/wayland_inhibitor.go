@@ -0,0 +1,113 @@
+package idle
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/go-wayland/wayland/client"
+	idleInhibit "github.com/MatthiasKunnen/go-wayland/wayland/unstable/idle-inhibit-v1"
+	"io"
+)
+
+var ErrIdleInhibitNotSupported = errors.New("compositor does not advertise idle-inhibit-unstable-v1")
+
+type waylandIdleInhibitor struct {
+	closed    bool
+	inhibitor *idleInhibit.IdleInhibitor
+	manager   *idleInhibit.IdleInhibitManager
+	registry  *client.Registry
+}
+
+// NewWaylandIdleInhibitor prevents the compositor from going idle, e.g. blanking the screen or
+// locking, while the given surface is visible. It uses the [idle-inhibit-unstable-v1] protocol.
+// Call Close on the result to release the inhibition.
+//
+// Unlike the logind "idle" inhibitor of pkg/inhibit, which only affects logind's IdleAction, this
+// inhibits the compositor's own idle handling. The inhibition only applies while the surface is
+// visible, e.g. it does not apply when the surface is on another workspace or minimized.
+//
+// ErrIdleInhibitNotSupported is returned if the compositor does not support the protocol.
+//
+// This function performs a roundtrip on the surface's connection. As with all Wayland
+// interactions, it and the Close method must be called on the goroutine that dispatches the
+// connection's events.
+//
+// [idle-inhibit-unstable-v1]: https://wayland.app/protocols/idle-inhibit-unstable-v1
+func NewWaylandIdleInhibitor(surface *client.Surface) (io.Closer, error) {
+	// The display is always the first object registered on a connection
+	display, ok := surface.Context().GetProxy(1).(*client.Display)
+	if !ok {
+		return nil, errors.New("unable to find the Wayland display of the surface")
+	}
+
+	registry, err := display.GetRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("error getting Wayland registry: %w", err)
+	}
+
+	result := &waylandIdleInhibitor{
+		registry: registry,
+	}
+
+	var bindError error
+	registry.SetGlobalHandler(func(e client.RegistryGlobalEvent) {
+		if e.Interface != idleInhibit.IdleInhibitManagerInterfaceName {
+			return
+		}
+
+		result.manager = idleInhibit.NewIdleInhibitManager(surface.Context())
+		err := registry.Bind(e.Name, e.Interface, e.Version, result.manager)
+		if err != nil {
+			bindError = fmt.Errorf("unable to bind %s interface: %w", e.Interface, err)
+		}
+	})
+
+	if err := display.Roundtrip(); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed roundtrip: %w", err), result.Close())
+	}
+
+	if bindError != nil {
+		return nil, errors.Join(bindError, result.Close())
+	}
+
+	if result.manager == nil {
+		return nil, errors.Join(ErrIdleInhibitNotSupported, result.Close())
+	}
+
+	result.inhibitor, err = result.manager.CreateInhibitor(surface)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("unable to create idle inhibitor: %w", err), result.Close())
+	}
+
+	return result, nil
+}
+
+// Close releases the inhibition. Calling Close more than once is a no-op.
+func (w *waylandIdleInhibitor) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	var totalError error
+	if w.inhibitor != nil {
+		if err := w.inhibitor.Destroy(); err != nil {
+			totalError = errors.Join(totalError, fmt.Errorf("error destroying idle inhibitor: %w", err))
+		}
+	}
+
+	if w.manager != nil {
+		if err := w.manager.Destroy(); err != nil {
+			totalError = errors.Join(totalError, fmt.Errorf(
+				"unable to destroy %s: %w",
+				idleInhibit.IdleInhibitManagerInterfaceName,
+				err,
+			))
+		}
+	}
+
+	if err := w.registry.Destroy(); err != nil {
+		totalError = errors.Join(totalError, fmt.Errorf("error destroying registry: %w", err))
+	}
+
+	return totalError
+}
@@ -0,0 +1,137 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+var (
+	// ErrLockAdopted is returned by AdoptLock when the lock has already been adopted in this
+	// process, using the same or a duplicated file descriptor.
+	ErrLockAdopted = errors.New("inhibitor lock already adopted")
+
+	// ErrNotLockFD is returned by AdoptLock when the file is not the pipe of an inhibitor lock.
+	ErrNotLockFD = errors.New("file is not an inhibitor lock")
+)
+
+// LockMetadata describes an inhibitor lock, as passed to Inhibit and listed by logind.
+type LockMetadata struct {
+	Who  string
+	Why  string
+	Mode Mode
+	What []What
+
+	// PID is the process ID of the process that took the lock, logind attributes the lock to
+	// it. Zero matches any process.
+	PID uint32
+}
+
+// lockKey identifies the pipe of a lock, which all duplicates of its file descriptor share.
+type lockKey struct {
+	dev uint64
+	ino uint64
+}
+
+var (
+	muAdopted sync.Mutex
+	// adopted are the pipes of the InhibitLocks that have not been released.
+	adopted = make(map[lockKey]struct{})
+)
+
+// InhibitLock is an inhibitor lock taken by another process, see AdoptLock.
+type InhibitLock struct {
+	inhibitor *Inhibitor
+	meta      LockMetadata
+	key       lockKey
+
+	mu sync.Mutex
+	// file is nil once released.
+	file *os.File
+}
+
+// AdoptLock takes ownership of f, the file descriptor of an inhibitor lock taken by another
+// process, e.g. a supervisor that passed the lock returned by Inhibit to a worker using
+// exec.Cmd.ExtraFiles. The lock is held until Release is called and every other duplicate of the
+// file descriptor, such as the one of the supervisor, is closed.
+//
+// meta describes the lock, it is used by InhibitLock.Verify since logind attributes the lock to
+// the process that took it. Returns ErrNotLockFD when f is not a pipe and ErrLockAdopted when
+// the lock has already been adopted in this process and not been released. f is not closed on
+// error.
+func (i *Inhibitor) AdoptLock(f *os.File, meta LockMetadata) (*InhibitLock, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotLockFD, err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if info.Mode()&os.ModeNamedPipe == 0 || !ok {
+		return nil, fmt.Errorf("%w: %s is not a pipe but %s", ErrNotLockFD, f.Name(), info.Mode().Type())
+	}
+
+	key := lockKey{dev: uint64(stat.Dev), ino: stat.Ino}
+	muAdopted.Lock()
+	defer muAdopted.Unlock()
+	if _, ok := adopted[key]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrLockAdopted, f.Name())
+	}
+	adopted[key] = struct{}{}
+
+	return &InhibitLock{inhibitor: i, meta: meta, key: key, file: f}, nil
+}
+
+// Metadata returns the metadata the lock was adopted with.
+func (l *InhibitLock) Metadata() LockMetadata {
+	return l.meta
+}
+
+// Release closes the file descriptor of the lock. The lock is released when every duplicate of
+// it is closed. Calling Release more than once has no effect.
+func (l *InhibitLock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	muAdopted.Lock()
+	delete(adopted, l.key)
+	muAdopted.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to release inhibitor lock: %w", err)
+	}
+
+	return nil
+}
+
+// Close is Release, so that InhibitLock can be used like the locks returned by Inhibit.
+func (l *InhibitLock) Close() error {
+	return l.Release()
+}
+
+// Verify checks that logind lists a lock matching the metadata while it is held. Like
+// AutoLock.Verify, identical locks cannot be told apart. Returns ErrLockNotListed if logind does
+// not list the lock and nil once it has been released.
+func (l *InhibitLock) Verify(ctx context.Context) error {
+	l.mu.Lock()
+	released := l.file == nil
+	l.mu.Unlock()
+	if released {
+		return nil
+	}
+
+	what := sortedWhat(joinWhat(l.meta.What))
+
+	return l.inhibitor.findLock(ctx, func(inhibitor listedInhibitor) bool {
+		return (l.meta.PID == 0 || inhibitor.PID == l.meta.PID) &&
+			inhibitor.Who == l.meta.Who &&
+			inhibitor.Why == l.meta.Why &&
+			inhibitor.Mode == string(l.meta.Mode) &&
+			sortedWhat(inhibitor.What) == what
+	})
+}
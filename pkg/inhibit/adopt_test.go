@@ -0,0 +1,205 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	// adoptBusEnv is set for the child process of TestAdoptLockChildProcess to the address of
+	// the private bus of the fake logind.
+	adoptBusEnv = "INHIBIT_TEST_ADOPT_BUS"
+	// adoptPIDEnv is set for the child process of TestAdoptLockChildProcess to the PID of the
+	// parent.
+	adoptPIDEnv = "INHIBIT_TEST_ADOPT_PID"
+)
+
+// inherit duplicates the file descriptor of the lock and closes the original, like passing the
+// lock to a child process.
+func inherit(t *testing.T, lock *os.File) *os.File {
+	t.Helper()
+
+	fd, err := syscall.Dup(int(lock.Fd()))
+	if err != nil {
+		t.Fatalf("Dup() error = %v", err)
+	}
+
+	return os.NewFile(uintptr(fd), "inherited")
+}
+
+func TestAdoptLock(t *testing.T) {
+	i := newTestInhibitor(t, &fakeManager{})
+	lock, err := i.Inhibit("supervisor", "worker running", ModeDelay, WhatSleep, WhatShutdown)
+	if err != nil {
+		t.Fatalf("Inhibit() error = %v", err)
+	}
+	f := inherit(t, lock.(*os.File))
+	_ = lock.Close()
+
+	meta := LockMetadata{
+		Who:  "supervisor",
+		Why:  "worker running",
+		Mode: ModeDelay,
+		What: []What{WhatShutdown, WhatSleep},
+		PID:  uint32(os.Getpid()),
+	}
+	adopted, err := i.AdoptLock(f, meta)
+	if err != nil {
+		t.Fatalf("AdoptLock() error = %v", err)
+	}
+	if err := adopted.Verify(context.Background()); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	other := meta
+	other.PID = 1
+	otherLock := &InhibitLock{inhibitor: i, meta: other, file: f}
+	if err := otherLock.Verify(context.Background()); !errors.Is(err, ErrLockNotListed) {
+		t.Fatalf("Verify() with another PID error = %v, want ErrLockNotListed", err)
+	}
+
+	// A duplicate of an adopted lock cannot be adopted again
+	duplicate := inherit(t, f)
+	defer duplicate.Close()
+	if _, err := i.AdoptLock(duplicate, meta); !errors.Is(err, ErrLockAdopted) {
+		t.Fatalf("AdoptLock() of a duplicate error = %v, want ErrLockAdopted", err)
+	}
+
+	if err := adopted.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := adopted.Release(); err != nil {
+		t.Fatalf("second Release() error = %v", err)
+	}
+	if err := adopted.Verify(context.Background()); err != nil {
+		t.Fatalf("Verify() after Release error = %v, want nil", err)
+	}
+
+	// Once released, the remaining duplicate can be adopted
+	readopted, err := i.AdoptLock(duplicate, meta)
+	if err != nil {
+		t.Fatalf("AdoptLock() after Release error = %v", err)
+	}
+	_ = readopted.Release()
+}
+
+func TestAdoptLockNotPipe(t *testing.T) {
+	i := newTestInhibitor(t, &fakeManager{})
+	f, err := os.CreateTemp(t.TempDir(), "lock")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	defer f.Close()
+
+	if _, err := i.AdoptLock(f, LockMetadata{}); !errors.Is(err, ErrNotLockFD) {
+		t.Fatalf("AdoptLock() of a regular file error = %v, want ErrNotLockFD", err)
+	}
+
+	_ = f.Close()
+	if _, err := i.AdoptLock(f, LockMetadata{}); !errors.Is(err, ErrNotLockFD) {
+		t.Fatalf("AdoptLock() of a closed file error = %v, want ErrNotLockFD", err)
+	}
+}
+
+// TestAdoptLockChildProcess passes a lock of the fake logind to a child process that adopts and
+// verifies it, like a supervisor handing a lock to a worker. The test binary is run again as the
+// child, which connects to the same private bus.
+func TestAdoptLockChildProcess(t *testing.T) {
+	if address := os.Getenv(adoptBusEnv); address != "" {
+		adoptLockChild(t, address)
+		return
+	}
+
+	bus := dbustest.New(t)
+	manager := &fakeManager{}
+	service := bus.RequestName(t, login1.Dest)
+	if err := service.Export(manager, login1.ManagerPath, login1.ManagerInterface); err != nil {
+		t.Fatalf("failed to export fake manager: %v", err)
+	}
+	i := newInhibitor(login1.New(bus.Connect(t)))
+	defer i.Close()
+
+	lock, err := i.Inhibit("supervisor", "worker running", ModeDelay, WhatSleep)
+	if err != nil {
+		t.Fatalf("Inhibit() error = %v", err)
+	}
+	defer lock.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestAdoptLockChildProcess$", "-test.v")
+	cmd.Env = append(
+		os.Environ(),
+		adoptBusEnv+"="+bus.Address,
+		adoptPIDEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+	cmd.ExtraFiles = []*os.File{lock.(*os.File)}
+	output, err := cmd.CombinedOutput()
+	manager.mu.Lock()
+	for _, f := range manager.files {
+		_ = f.Close()
+	}
+	manager.mu.Unlock()
+	if err != nil {
+		t.Fatalf("child failed: %v\n%s", err, output)
+	}
+}
+
+// adoptLockChild is the child process of TestAdoptLockChildProcess, it inherits the lock as file
+// descriptor 3.
+func adoptLockChild(t *testing.T, address string) {
+	pid, err := strconv.ParseUint(os.Getenv(adoptPIDEnv), 10, 32)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", adoptPIDEnv, err)
+	}
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", address, err)
+	}
+	i, err := New(WithConn(conn))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	meta := LockMetadata{
+		Who:  "supervisor",
+		Why:  "worker running",
+		Mode: ModeDelay,
+		What: []What{WhatSleep},
+		PID:  uint32(pid),
+	}
+	lock, err := i.AdoptLock(os.NewFile(3, "inherited lock"), meta)
+	if err != nil {
+		t.Fatalf("AdoptLock() error = %v", err)
+	}
+	if _, err := i.AdoptLock(os.NewFile(3, "inherited lock"), meta); !errors.Is(err, ErrLockAdopted) {
+		t.Fatalf("second AdoptLock() error = %v, want ErrLockAdopted", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := lock.Verify(ctx); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// The lock is attributed to the parent, not to this process
+	other := meta
+	other.PID = uint32(os.Getpid())
+	if err := (&InhibitLock{inhibitor: i, meta: other, file: lock.file}).Verify(ctx); !errors.Is(err, ErrLockNotListed) {
+		t.Fatalf("Verify() with the PID of the child error = %v, want ErrLockNotListed", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"io"
+	"sync"
+)
+
+// ErrAutoLockClosed is returned by WaitSleep after the AutoLock has been closed.
+var ErrAutoLockClosed = errors.New("auto lock closed")
+
+// AutoLock holds a delay lock on sleep for as long as it is open. The lock is only released when
+// the consumer acknowledges a pending sleep using AckSleep and is taken again as soon as the
+// system resumes.
+//
+// Taking the lock again starts when the AutoLock receives PrepareForSleep(false) and does not
+// block the delivery of signals. Other PrepareForSleep subscribers, including those of the same
+// Inhibitor, can be notified of the resume before the lock is held. A suspend that starts while
+// the lock is being taken again is still delayed once it is held, and WaitSleep does not return
+// before then. This narrows the window of the pattern shown in the package example, where a
+// second suspend shortly after resuming is not delayed because the lock has not been re-acquired
+// yet.
+type AutoLock struct {
+	inhibitor    *Inhibitor
+	who          string
+	why          string
+	what         []What
+	subscription *login1.Subscription
+	sleep        chan struct{}
+	done         chan struct{}
+
+	// relocks tracks the goroutines taking the lock again, Close waits for them.
+	relocks sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	lock   io.Closer
+	// relocking is closed once the lock that is being taken again is held or failed, nil when
+	// the lock is not being taken again.
+	relocking chan struct{}
+	sleeping  bool
+	// acked is set when AckSleep is called during a sleep, a lock taken again during that sleep
+	// is released right away.
+	acked bool
+	err   error
+}
+
+// AutoLockState is the state of an AutoLock.
+type AutoLockState int
+
+const (
+	// AutoLockArmed means the lock is held and the system is not preparing to sleep.
+	AutoLockArmed AutoLockState = iota + 1
+	// AutoLockSleeping means the system is preparing to sleep and the lock is held until
+	// AckSleep is called.
+	AutoLockSleeping
+	// AutoLockReleased means the lock is not held, either because the sleep was acknowledged,
+	// because the lock is still being taken again after resuming, or because that failed.
+	AutoLockReleased
+	// AutoLockClosed means the AutoLock has been closed.
+	AutoLockClosed
+)
+
+func (s AutoLockState) String() string {
+	switch s {
+	case AutoLockArmed:
+		return "armed"
+	case AutoLockSleeping:
+		return "sleeping"
+	case AutoLockReleased:
+		return "released"
+	case AutoLockClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("AutoLockState(%d)", int(s))
+	}
+}
+
+// AutoInhibit takes a delay lock for what, which must include WhatSleep, and keeps it across
+// suspend cycles. See AutoLock.
+//
+// The AutoLock is owned by the caller but is also closed when the Inhibitor is closed.
+func (i *Inhibitor) AutoInhibit(who string, why string, what ...What) (*AutoLock, error) {
+	if _, err := translate(backendLogind, who, why, ModeDelay, StrictFail, what); err != nil {
+		return nil, err
+	}
+	if !containsWhat(what, WhatSleep) {
+		return nil, fmt.Errorf("%w: what must include %s", ErrInvalidArgument, WhatSleep)
+	}
+
+	a := &AutoLock{
+		inhibitor: i,
+		who:       who,
+		why:       why,
+		what:      what,
+		sleep:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	conn, err := i.conn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to register Dbus PrepareForSleep signal: %w", err)
+	}
+
+	// Subscribe first so that a sleep starting right after the lock is taken is not missed.
+	subscription, err := conn.Subscribe(login1.Rule{
+		Path:      login1.ManagerPath,
+		Interface: login1.ManagerInterface,
+		Member:    "PrepareForSleep",
+	}, a.handlePrepareForSleep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register Dbus PrepareForSleep signal: %w", err)
+	}
+	a.subscription = subscription
+
+	a.mu.Lock()
+	a.lock, err = i.Inhibit(who, why, ModeDelay, what...)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, errors.Join(err, subscription.Close())
+	}
+
+	i.muAutoLocks.Lock()
+	i.autoLocks[a] = struct{}{}
+	i.muAutoLocks.Unlock()
+
+	return a, nil
+}
+
+func containsWhat(what []What, w What) bool {
+	for _, elem := range what {
+		if elem == w {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handlePrepareForSleep is called on the dispatch goroutine. On resume, the lock is taken again
+// on a separate goroutine since Inhibit is a method call that must not stall the delivery of
+// other signals.
+func (a *AutoLock) handlePrepareForSleep(s *dbus.Signal) {
+	if len(s.Body) == 0 {
+		return
+	}
+	start, ok := s.Body[0].(bool)
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return
+	}
+
+	if start {
+		a.sleeping = true
+		a.acked = false
+		select {
+		case a.sleep <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	a.sleeping = false
+	// A sleep that was not waited for is over
+	select {
+	case <-a.sleep:
+	default:
+	}
+
+	if a.lock != nil || a.relocking != nil {
+		return
+	}
+
+	a.relocking = make(chan struct{})
+	a.relocks.Add(1)
+	go a.relock(a.relocking)
+}
+
+// relock takes the lock again after a resume. done is closed when it has finished.
+func (a *AutoLock) relock(done chan struct{}) {
+	defer a.relocks.Done()
+
+	lock, err := a.inhibitor.Inhibit(a.who, a.why, ModeDelay, a.what...)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.relocking = nil
+	close(done)
+
+	switch {
+	case err != nil:
+		if !a.closed {
+			a.err = fmt.Errorf("failed to re-acquire lock after resume: %w", err)
+		}
+	case a.closed:
+		_ = lock.Close()
+	case a.sleeping && a.acked:
+		// The sleep that started while the lock was taken has already been acknowledged
+		if err := lock.Close(); err != nil {
+			a.err = fmt.Errorf("failed to release inhibitor lock: %w", err)
+		}
+	default:
+		a.lock = lock
+	}
+}
+
+// WaitSleep blocks until the system prepares to sleep. The sleep is delayed until AckSleep is
+// called or logind's InhibitDelayMaxSec passes.
+//
+// When the lock is still being taken again after the previous resume, WaitSleep returns once it
+// is held. When the lock could not be taken again, that error is returned once. The next resume
+// tries again.
+// Returns ErrAutoLockClosed when the AutoLock is closed, or the context's error.
+func (a *AutoLock) WaitSleep(ctx context.Context) error {
+	a.mu.Lock()
+	err := a.err
+	a.err = nil
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-a.done:
+		return ErrAutoLockClosed
+	default:
+	}
+
+	select {
+	case <-a.sleep:
+	case <-a.done:
+		return ErrAutoLockClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	a.mu.Lock()
+	relocking := a.relocking
+	a.mu.Unlock()
+	if relocking == nil {
+		return nil
+	}
+
+	select {
+	case <-relocking:
+		return nil
+	case <-a.done:
+		return ErrAutoLockClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AckSleep releases the lock, allowing a pending sleep to continue. It is a no-op when the
+// system is not preparing to sleep.
+func (a *AutoLock) AckSleep() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.sleeping {
+		return nil
+	}
+	a.acked = true
+	if a.lock == nil {
+		return nil
+	}
+
+	err := a.lock.Close()
+	a.lock = nil
+	if err != nil {
+		return fmt.Errorf("failed to release inhibitor lock: %w", err)
+	}
+
+	return nil
+}
+
+// State returns the current state of the AutoLock.
+func (a *AutoLock) State() AutoLockState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case a.closed:
+		return AutoLockClosed
+	case a.lock == nil:
+		return AutoLockReleased
+	case a.sleeping:
+		return AutoLockSleeping
+	default:
+		return AutoLockArmed
+	}
+}
+
+// Close releases the lock and stops taking it on resume. A lock that is being taken again is
+// released before Close returns. Close is idempotent.
+func (a *AutoLock) Close() error {
+	a.inhibitor.muAutoLocks.Lock()
+	delete(a.inhibitor.autoLocks, a)
+	a.inhibitor.muAutoLocks.Unlock()
+
+	return a.close()
+}
+
+func (a *AutoLock) close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.done)
+
+	var err error
+	if a.lock != nil {
+		err = a.lock.Close()
+		a.lock = nil
+	}
+	a.mu.Unlock()
+
+	err = errors.Join(err, a.subscription.Close())
+	a.relocks.Wait()
+
+	return err
+}
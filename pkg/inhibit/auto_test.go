@@ -0,0 +1,165 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"testing"
+	"time"
+)
+
+func emitPrepareForSleep(t *testing.T, service *dbus.Conn, start bool) {
+	t.Helper()
+
+	err := service.Emit(login1.ManagerPath, login1.ManagerInterface+".PrepareForSleep", start)
+	if err != nil {
+		t.Fatalf("failed to emit PrepareForSleep: %v", err)
+	}
+}
+
+func TestAutoInhibitBackToBack(t *testing.T) {
+	manager := &fakeManager{}
+	i, service := newTestInhibitorService(t, manager)
+
+	a, err := i.AutoInhibit("agent", "flush", WhatSleep)
+	if err != nil {
+		t.Fatalf("AutoInhibit() error = %v", err)
+	}
+
+	if state := a.State(); state != AutoLockArmed {
+		t.Fatalf("State() = %s, want %s", state, AutoLockArmed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for cycle := 1; cycle <= 2; cycle++ {
+		if cycle == 2 {
+			// Resume immediately followed by the next suspend
+			emitPrepareForSleep(t, service, false)
+		}
+		emitPrepareForSleep(t, service, true)
+
+		if err := a.WaitSleep(ctx); err != nil {
+			t.Fatalf("cycle %d: WaitSleep() error = %v", cycle, err)
+		}
+		if state := a.State(); state != AutoLockSleeping {
+			t.Fatalf("cycle %d: State() = %s, want %s, sleep was not delayed", cycle, state, AutoLockSleeping)
+		}
+		if got := manager.calls(); got != cycle {
+			t.Fatalf("cycle %d: %d locks taken, want %d", cycle, got, cycle)
+		}
+
+		if err := a.AckSleep(); err != nil {
+			t.Fatalf("cycle %d: AckSleep() error = %v", cycle, err)
+		}
+		if state := a.State(); state != AutoLockReleased {
+			t.Fatalf("cycle %d: State() = %s after AckSleep, want %s", cycle, state, AutoLockReleased)
+		}
+	}
+}
+
+// TestAutoInhibitSlowRelock checks that taking the lock again after a resume does not stall the
+// delivery of signals and that a sleep starting meanwhile is delayed once the lock is held.
+func TestAutoInhibitSlowRelock(t *testing.T) {
+	manager := &fakeManager{}
+	i, service := newTestInhibitorService(t, manager)
+
+	a, err := i.AutoInhibit("agent", "flush", WhatSleep)
+	if err != nil {
+		t.Fatalf("AutoInhibit() error = %v", err)
+	}
+	sleep := make(chan bool, 3)
+	if err := i.SubscribePrepareForSleep(sleep); err != nil {
+		t.Fatalf("SubscribePrepareForSleep() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receive := func(want bool) {
+		t.Helper()
+		select {
+		case v := <-sleep:
+			if v != want {
+				t.Fatalf("PrepareForSleep(%t), want PrepareForSleep(%t)", v, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("PrepareForSleep(%t) not delivered, dispatch is blocked", want)
+		}
+	}
+
+	emitPrepareForSleep(t, service, true)
+	receive(true)
+	if err := a.WaitSleep(ctx); err != nil {
+		t.Fatalf("WaitSleep() error = %v", err)
+	}
+	if err := a.AckSleep(); err != nil {
+		t.Fatalf("AckSleep() error = %v", err)
+	}
+
+	gate := make(chan struct{})
+	manager.mu.Lock()
+	manager.gate = gate
+	manager.mu.Unlock()
+
+	emitPrepareForSleep(t, service, false)
+	emitPrepareForSleep(t, service, true)
+	receive(false)
+	receive(true)
+	if state := a.State(); state != AutoLockReleased {
+		t.Fatalf("State() = %s while the lock is taken again, want %s", state, AutoLockReleased)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- a.WaitSleep(ctx)
+	}()
+	select {
+	case err := <-waited:
+		t.Fatalf("WaitSleep() = %v before the lock was held", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gate)
+	if err := <-waited; err != nil {
+		t.Fatalf("WaitSleep() error = %v", err)
+	}
+	if state := a.State(); state != AutoLockSleeping {
+		t.Fatalf("State() = %s, want %s, sleep was not delayed", state, AutoLockSleeping)
+	}
+	if got := manager.calls(); got != 2 {
+		t.Fatalf("%d locks taken, want 2", got)
+	}
+}
+
+func TestAutoInhibitRequiresSleep(t *testing.T) {
+	i := &Inhibitor{}
+
+	_, err := i.AutoInhibit("agent", "flush", WhatShutdown)
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("AutoInhibit() error = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestInhibitorCloseClosesAutoLock(t *testing.T) {
+	i := newTestInhibitor(t, &fakeManager{})
+
+	a, err := i.AutoInhibit("agent", "flush", WhatSleep)
+	if err != nil {
+		t.Fatalf("AutoInhibit() error = %v", err)
+	}
+
+	if err := i.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if state := a.State(); state != AutoLockClosed {
+		t.Errorf("State() = %s after Inhibitor.Close, want %s", state, AutoLockClosed)
+	}
+	if err := a.WaitSleep(context.Background()); !errors.Is(err, ErrAutoLockClosed) {
+		t.Errorf("WaitSleep() error = %v, want ErrAutoLockClosed", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+}
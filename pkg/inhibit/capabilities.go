@@ -0,0 +1,72 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Capabilities reports which features work in the current environment, see
+// Inhibitor.Capabilities. A nil error means the feature is available, otherwise the error wraps
+// ErrBackendUnavailable and names what was tried.
+type Capabilities struct {
+	// Inhibit is the result of probing logind, which is needed by Inhibit, AutoInhibit,
+	// MeasureDelayWindow, and ReportDelayWindows.
+	Inhibit error
+	// Signals is the result of connecting to the system bus, which is needed by the
+	// subscriptions, such as SubscribePrepareForSleep, and by Events. The signals are only sent
+	// when logind runs, see Inhibit.
+	Signals error
+	// Portal is the result of probing the inhibit portal of the session bus, see NewPortal. The
+	// portal can take locks when logind cannot be reached, e.g. in a Flatpak sandbox.
+	Portal error
+}
+
+// Capabilities probes the backends of the features of the package, so that an application can
+// find out up front which features will work instead of handling ErrBackendUnavailable
+// everywhere. The portal is probed using the connection of WithSessionConn or a new session bus
+// connection that is closed afterwards.
+//
+// The result is a snapshot, a bus that becomes available later is connected to when it is used.
+func (i *Inhibitor) Capabilities(ctx context.Context) Capabilities {
+	var c Capabilities
+	_, c.Signals = i.conn()
+	c.Inhibit = c.Signals
+	if c.Signals == nil {
+		c.Inhibit = i.pingLogind(ctx)
+	}
+
+	p, err := NewPortal(WithSessionConn(i.sessionConn))
+	if err != nil {
+		c.Portal = fmt.Errorf("%w: inhibit portal on the session bus: %w", ErrBackendUnavailable, err)
+	} else {
+		c.Portal = errors.Join(p.ping(ctx), p.Close())
+	}
+
+	return c
+}
+
+// pingLogind returns an error wrapping ErrBackendUnavailable when logind does not answer.
+func (i *Inhibitor) pingLogind(ctx context.Context) error {
+	conn, err := i.conn()
+	if err != nil {
+		return err
+	}
+
+	err = conn.Manager().CallWithContext(ctx, "org.freedesktop.DBus.Peer.Ping", 0).Err
+	if err != nil {
+		return fmt.Errorf("%w: logind did not answer: %w", ErrBackendUnavailable, err)
+	}
+
+	return nil
+}
+
+// ping returns an error wrapping ErrBackendUnavailable when the inhibit portal does not answer.
+func (p *Portal) ping(ctx context.Context) error {
+	err := p.obj.CallWithContext(ctx, "org.freedesktop.DBus.Peer.Ping", 0).Err
+	if err != nil {
+		return fmt.Errorf("%w: inhibit portal did not answer: %w", ErrBackendUnavailable, err)
+	}
+
+	return nil
+}
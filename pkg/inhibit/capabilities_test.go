@@ -0,0 +1,86 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"testing"
+)
+
+func TestBackendUnavailable(t *testing.T) {
+	bus := dbustest.New(t)
+	portal := &fakePortal{conn: bus.RequestName(t, portalDest)}
+	if err := portal.conn.Export(portal, portalPath, portalInhibitInterface); err != nil {
+		t.Fatalf("failed to export fake portal: %v", err)
+	}
+
+	// The system bus becomes available after the first attempt
+	attempts := 0
+	i := newInhibitor(nil)
+	i.sessionConn = bus.Connect(t)
+	i.connect = func() (*login1.Conn, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("no such file or directory")
+		}
+
+		service := bus.RequestName(t, login1.Dest)
+		if err := service.Export(&fakeManager{}, login1.ManagerPath, login1.ManagerInterface); err != nil {
+			t.Fatalf("failed to export fake manager: %v", err)
+		}
+		return login1.New(bus.Connect(t)), nil
+	}
+	defer i.Close()
+
+	c := i.Capabilities(context.Background())
+	if !errors.Is(c.Signals, ErrBackendUnavailable) || !errors.Is(c.Inhibit, ErrBackendUnavailable) {
+		t.Fatalf("Capabilities() = %+v, want logind unavailable", c)
+	}
+	if c.Portal != nil {
+		t.Fatalf("Capabilities().Portal = %v, want available", c.Portal)
+	}
+
+	lock, err := i.Inhibit("test", "testing", ModeBlock, WhatIdle)
+	if err != nil {
+		t.Fatalf("Inhibit() error = %v after the bus became available", err)
+	}
+	_ = lock.Close()
+	if attempts != 2 {
+		t.Fatalf("connected %d times, want 2", attempts)
+	}
+	if c := i.Capabilities(context.Background()); c.Inhibit != nil || c.Signals != nil {
+		t.Fatalf("Capabilities() = %+v, want logind available", c)
+	}
+}
+
+func TestBackendUnavailableFeatures(t *testing.T) {
+	i := newInhibitor(nil)
+	i.connect = func() (*login1.Conn, error) {
+		return nil, errors.New("no such file or directory")
+	}
+
+	if _, err := i.Inhibit("test", "testing", ModeBlock, WhatIdle); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("Inhibit() error = %v, want ErrBackendUnavailable", err)
+	}
+	if _, err := i.AutoInhibit("test", "testing", WhatSleep); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("AutoInhibit() error = %v, want ErrBackendUnavailable", err)
+	}
+	if err := i.SubscribePrepareForSleep(make(chan bool)); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("SubscribePrepareForSleep() error = %v, want ErrBackendUnavailable", err)
+	}
+	if _, err := i.Events(context.Background()); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("Events() error = %v, want ErrBackendUnavailable", err)
+	}
+	report, err := i.HealthCheck(context.Background())
+	if err != nil || !errors.Is(report.Bus, ErrBackendUnavailable) {
+		t.Errorf("HealthCheck() = %+v, %v, want the bus unavailable", report, err)
+	}
+
+	if err := i.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := i.Inhibit("test", "testing", ModeBlock, WhatIdle); !errors.Is(err, login1.ErrClosed) {
+		t.Errorf("Inhibit() after Close error = %v, want login1.ErrClosed", err)
+	}
+}
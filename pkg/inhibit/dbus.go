@@ -3,55 +3,130 @@ package inhibit
 import (
 	"errors"
 	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
 	"github.com/godbus/dbus/v5"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
-const (
-	dbusDest             = "org.freedesktop.login1"
-	dbusManagerInterface = "org.freedesktop.login1.Manager"
-	dbusPath             = "/org/freedesktop/login1"
+var (
+	// ErrInvalidArgument is returned by Inhibit when who, why, or what is invalid.
+	ErrInvalidArgument = errors.New("invalid inhibit argument")
+
+	// ErrTooManyInhibitors is returned by Inhibit when logind's maximum number of inhibitors,
+	// InhibitorsMax, has been reached.
+	ErrTooManyInhibitors = errors.New("maximum number of inhibitors reached")
+
+	// ErrNotAuthorized is returned by Inhibit when the caller is not allowed to take the lock,
+	// e.g. because polkit refused a lock in block mode. Taking a delay lock might still be
+	// allowed.
+	ErrNotAuthorized = errors.New("not authorized to take inhibitor lock")
+
+	// ErrBackendUnavailable is returned when the backend of a feature cannot be reached, e.g.
+	// by Inhibit and the subscriptions when there is no system bus in a container. The error
+	// names what was tried. See Inhibitor.Capabilities.
+	ErrBackendUnavailable = errors.New("inhibit backend unavailable")
 )
 
 type Inhibitor struct {
-	conn                   *dbus.Conn
-	login1                 dbus.BusObject
-	muSignals              sync.Mutex
-	closeSignalHandler     chan struct{}
-	prepareForSleepSubs    map[chan<- bool]struct{}
-	prepareForShutdownSubs map[chan<- bool]struct{}
+	// connect connects to logind, nil when the connection was given to newInhibitor.
+	connect func() (*login1.Conn, error)
+	// sessionConn is the session bus connection of WithSessionConn, used by Capabilities.
+	sessionConn *dbus.Conn
+	muConn      sync.Mutex
+	// login1 is nil until conn connected.
+	login1 *login1.Conn
+	closed bool
+
+	muSignals                      sync.Mutex
+	prepareForSleepSubs            map[chan<- bool]struct{}
+	prepareForSleepSubscription    *login1.Subscription
+	prepareForShutdownSubs         map[chan<- bool]struct{}
+	prepareForShutdownSubscription *login1.Subscription
+	eventStreams                   map[*eventStream]struct{}
+	muAutoLocks                    sync.Mutex
+	autoLocks                      map[*AutoLock]struct{}
 }
 
-func New() (*Inhibitor, error) {
-	conn, err := dbus.SystemBus()
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+type options struct {
+	conn        *dbus.Conn
+	sessionConn *dbus.Conn
+}
+
+// Option configures the Inhibitor, see New.
+type Option func(o *options)
+
+// WithConn makes the Inhibitor use conn instead of the system bus connection shared by the
+// Inhibitors and session locks of the process. The Inhibitor adds match rules and a signal
+// channel to conn, shared with the Inhibitors and session locks that use the same conn, signals
+// received by other users of conn are unaffected. conn is not closed when the Inhibitor is
+// closed.
+func WithConn(conn *dbus.Conn) Option {
+	return func(o *options) {
+		o.conn = conn
+	}
+}
+
+// New creates an Inhibitor. Unless WithConn is given, all Inhibitors and session locks of the
+// process share a single private system bus connection. The process-wide connection of
+// dbus.SystemBus is never used, so other users of it are not affected.
+//
+// The system bus is connected to when it is first needed, New does not fail when there is none,
+// e.g. in a container. The features that need it return ErrBackendUnavailable instead, see
+// Capabilities to find out up front which features work. The error is currently always nil.
+func New(opts ...Option) (*Inhibitor, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	inhibitor := &Inhibitor{
-		conn:                   conn,
-		login1:                 conn.Object(dbusDest, dbusPath),
+	var i *Inhibitor
+	if o.conn != nil {
+		i = newInhibitor(login1.New(o.conn))
+	} else {
+		i = newInhibitor(nil)
+		i.connect = login1.Shared
+	}
+	i.sessionConn = o.sessionConn
+
+	return i, nil
+}
+
+func newInhibitor(conn *login1.Conn) *Inhibitor {
+	return &Inhibitor{
+		login1:                 conn,
 		prepareForSleepSubs:    make(map[chan<- bool]struct{}),
 		prepareForShutdownSubs: make(map[chan<- bool]struct{}),
+		eventStreams:           make(map[*eventStream]struct{}),
+		autoLocks:              make(map[*AutoLock]struct{}),
 	}
+}
 
-	c := make(chan *dbus.Signal)
-	conn.Signal(c)
-	go func() {
-		for {
-			select {
-			case <-inhibitor.closeSignalHandler:
-				conn.RemoveSignal(c)
-			case v := <-c:
-				inhibitor.handleIncomingSignal(v)
-			}
-		}
-	}()
+// conn returns the connection to logind, connecting when it is first needed. Returns an error
+// wrapping ErrBackendUnavailable when the system bus cannot be reached, the connection is tried
+// again on the next call.
+func (i *Inhibitor) conn() (*login1.Conn, error) {
+	i.muConn.Lock()
+	defer i.muConn.Unlock()
+
+	switch {
+	case i.closed:
+		return nil, login1.ErrClosed
+	case i.login1 != nil:
+		return i.login1, nil
+	}
+
+	conn, err := i.connect()
+	if err != nil {
+		return nil, fmt.Errorf("%w: logind on the system bus: %w", ErrBackendUnavailable, err)
+	}
+	i.login1 = conn
 
-	return inhibitor, nil
+	return conn, nil
 }
 
 type What string
@@ -69,11 +144,39 @@ const (
 type Mode string
 
 const (
-	ModeBlock     = "block"
-	ModeBlockWeak = "block-weak"
-	ModeDelay     = "delay"
+	ModeBlock     Mode = "block"
+	ModeBlockWeak Mode = "block-weak"
+	ModeDelay     Mode = "delay"
 )
 
+// modes are the modes accepted by logind.
+var modes = []Mode{ModeBlock, ModeBlockWeak, ModeDelay}
+
+// ParseMode returns the Mode with the given name, e.g. "delay".
+// Returns an error wrapping ErrInvalidArgument if s is not a known mode.
+func ParseMode(s string) (Mode, error) {
+	m := Mode(s)
+	if !m.Valid() {
+		return "", invalidModeError(m)
+	}
+
+	return m, nil
+}
+
+// Valid reports whether m is one of the modes accepted by logind.
+func (m Mode) Valid() bool {
+	return slices.Contains(modes, m)
+}
+
+func invalidModeError(m Mode) error {
+	names := make([]string, len(modes))
+	for i, mode := range modes {
+		names[i] = string(mode)
+	}
+
+	return fmt.Errorf("%w: mode %q is not one of %s", ErrInvalidArgument, m, strings.Join(names, ", "))
+}
+
 // Inhibit creates an inhibition lock. It takes four parameters: what, who, why,
 // and mode.
 //   - what is one or more of actions that should be inhibited.
@@ -85,17 +188,96 @@ const (
 //     circumstances.
 //
 // The lock is released the moment when the returned object and all its duplicates are closed.
+// The returned object is an *os.File, which can be passed to another process that adopts it
+// using AdoptLock.
+//
+// who and why must be non-empty valid UTF-8, mode must be valid, and at least one what is
+// required. Delay locks are only supported for WhatSleep and WhatShutdown. ErrInvalidArgument
+// is returned otherwise. ErrTooManyInhibitors and ErrNotAuthorized are returned when logind
+// refuses the lock for these reasons.
 func (i *Inhibitor) Inhibit(who string, why string, mode Mode, what ...What) (io.Closer, error) {
-	var fd dbus.UnixFD
+	lock, _, err := i.InhibitWithPolicy(who, why, mode, StrictFail, what...)
+	return lock, err
+}
+
+// InhibitWithPolicy is like Inhibit, but policy decides what happens with actions that logind
+// cannot inhibit in mode, which are those that cannot be delayed. The returned Translation
+// reports what the lock inhibits. With BestEffort, the lock is a no-op when no action is left,
+// it is an *os.File otherwise.
+func (i *Inhibitor) InhibitWithPolicy(
+	who string,
+	why string,
+	mode Mode,
+	policy Policy,
+	what ...What,
+) (io.Closer, Translation, error) {
+	t, err := translate(backendLogind, who, why, mode, policy, what)
+	if err != nil {
+		return nil, Translation{}, err
+	}
+	if len(t.Inhibited) == 0 {
+		return nopLock{}, t, nil
+	}
+
+	conn, err := i.conn()
+	if err != nil {
+		return nil, Translation{}, fmt.Errorf("failed to create inhibit lock: %w", err)
+	}
 
-	err := i.login1.
-		Call(dbusManagerInterface+".Inhibit", 0, joinWhat(what), who, why, mode).
+	var fd dbus.UnixFD
+	err = conn.Manager().
+		Call(login1.ManagerInterface+".Inhibit", 0, logindWhat(t.Inhibited), who, why, t.Mode).
 		Store(&fd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create inhibit lock: %w", err)
+		err = mapInhibitError(err)
+		return nil, Translation{}, fmt.Errorf("failed to create inhibit lock: %w", err)
+	}
+
+	return os.NewFile(uintptr(fd), "inhibit"), t, nil
+}
+
+func validateInhibit(who string, why string, mode Mode, what []What) error {
+	switch {
+	case who == "":
+		return fmt.Errorf("%w: who is empty", ErrInvalidArgument)
+	case !utf8.ValidString(who):
+		return fmt.Errorf("%w: who is not valid UTF-8", ErrInvalidArgument)
+	case why == "":
+		return fmt.Errorf("%w: why is empty", ErrInvalidArgument)
+	case !utf8.ValidString(why):
+		return fmt.Errorf("%w: why is not valid UTF-8", ErrInvalidArgument)
+	case len(what) == 0:
+		return fmt.Errorf("%w: what is empty", ErrInvalidArgument)
+	case !mode.Valid():
+		return invalidModeError(mode)
+	}
+
+	return nil
+}
+
+// mapInhibitError adds the typed error matching the D-Bus error returned by logind's Inhibit.
+func mapInhibitError(err error) error {
+	var name string
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	switch {
+	case errors.As(err, &dbusErr):
+		name = dbusErr.Name
+	case errors.As(err, &dbusErrPtr):
+		name = dbusErrPtr.Name
+	default:
+		return err
+	}
+
+	switch name {
+	case "org.freedesktop.DBus.Error.LimitsExceeded":
+		return fmt.Errorf("%w: %w", ErrTooManyInhibitors, err)
+	case "org.freedesktop.DBus.Error.AccessDenied",
+		"org.freedesktop.DBus.Error.InteractiveAuthorizationRequired":
+		return fmt.Errorf("%w: %w", ErrNotAuthorized, err)
 	}
 
-	return os.NewFile(uintptr(fd), "inhibit"), nil
+	return err
 }
 
 func (i *Inhibitor) handleIncomingSignal(s *dbus.Signal) {
@@ -104,7 +286,7 @@ func (i *Inhibitor) handleIncomingSignal(s *dbus.Signal) {
 		return
 	}
 
-	if s.Path != i.login1.Path() {
+	if s.Path != login1.ManagerPath {
 		return
 	}
 
@@ -151,15 +333,9 @@ func (i *Inhibitor) SubscribePrepareForSleep(c chan<- bool) error {
 	i.muSignals.Lock()
 	defer i.muSignals.Unlock()
 
-	if len(i.prepareForSleepSubs) == 0 {
-		if err := i.conn.AddMatchSignal(
-			dbus.WithMatchObjectPath(i.login1.Path()),
-			dbus.WithMatchInterface(dbusManagerInterface),
-			dbus.WithMatchSender(dbusDest),
-			dbus.WithMatchMember("PrepareForSleep"),
-		); err != nil {
-			return fmt.Errorf("failed to register Dbus PrepareForSleep signal: %w", err)
-		}
+	err := i.subscribe(&i.prepareForSleepSubscription, "PrepareForSleep")
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus PrepareForSleep signal: %w", err)
 	}
 
 	i.prepareForSleepSubs[c] = struct{}{}
@@ -178,30 +354,19 @@ func (i *Inhibitor) UnsubscribePrepareForSleep(c chan<- bool) error {
 	delete(i.prepareForSleepSubs, c)
 
 	if len(i.prepareForSleepSubs) == 0 {
-		if err := i.removePrepareForSleepSignal(); err != nil {
-			return err
+		if err := unsubscribe(&i.prepareForSleepSubscription); err != nil {
+			return fmt.Errorf("failed to remove Dbus PrepareForSleep signal: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func (i *Inhibitor) removePrepareForSleepSignal() error {
-	if err := i.conn.RemoveMatchSignal(
-		dbus.WithMatchObjectPath(i.login1.Path()),
-		dbus.WithMatchInterface(dbusManagerInterface),
-		dbus.WithMatchSender(dbusDest),
-		dbus.WithMatchMember("PrepareForSleep"),
-	); err != nil {
-		return fmt.Errorf("failed to remove Dbus PrepareForSleep signal: %w", err)
-	}
-
-	return nil
-}
-
 // SubscribePrepareForShutdown registers the channel so that it will be notified when the system
-// wants to shut down or reboot (true). False is not expected since all programs will have closed
-// after restarting the system.
+// wants to shut down or reboot (true), and when a shutdown that was announced is cancelled
+// (false). logind announces scheduled shutdowns, such as those of shutdown +5, and sends false
+// when they are cancelled. A delay lock on shutdown that was released on true must then be taken
+// again to delay the next shutdown.
 // Unregister the channel using UnsubscribePrepareForShutdown.
 func (i *Inhibitor) SubscribePrepareForShutdown(c chan<- bool) error {
 	if c == nil {
@@ -211,15 +376,9 @@ func (i *Inhibitor) SubscribePrepareForShutdown(c chan<- bool) error {
 	i.muSignals.Lock()
 	defer i.muSignals.Unlock()
 
-	if len(i.prepareForShutdownSubs) == 0 {
-		if err := i.conn.AddMatchSignal(
-			dbus.WithMatchObjectPath(i.login1.Path()),
-			dbus.WithMatchInterface(dbusManagerInterface),
-			dbus.WithMatchSender(dbusDest),
-			dbus.WithMatchMember("PrepareForShutdown"),
-		); err != nil {
-			return fmt.Errorf("failed to register Dbus PrepareForShutdown signal: %w", err)
-		}
+	err := i.subscribe(&i.prepareForShutdownSubscription, "PrepareForShutdown")
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus PrepareForShutdown signal: %w", err)
 	}
 
 	i.prepareForShutdownSubs[c] = struct{}{}
@@ -238,40 +397,91 @@ func (i *Inhibitor) UnsubscribePrepareForShutdown(c chan<- bool) error {
 	delete(i.prepareForShutdownSubs, c)
 
 	if len(i.prepareForShutdownSubs) == 0 {
-		if err := i.removePrepareForShutdownSignal(); err != nil {
-			return err
+		if err := unsubscribe(&i.prepareForShutdownSubscription); err != nil {
+			return fmt.Errorf("failed to remove Dbus PrepareForShutdown signal: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func (i *Inhibitor) removePrepareForShutdownSignal() error {
-	if err := i.conn.RemoveMatchSignal(
-		dbus.WithMatchObjectPath(i.login1.Path()),
-		dbus.WithMatchInterface(dbusManagerInterface),
-		dbus.WithMatchSender(dbusDest),
-		dbus.WithMatchMember("PrepareForShutdown"),
-	); err != nil {
-		return fmt.Errorf("failed to remove Dbus PrepareForShutdown signal: %w", err)
+// subscribe subscribes to the given signal of the manager if *subscription is nil.
+// Holding the muSignals mutex is required.
+func (i *Inhibitor) subscribe(subscription **login1.Subscription, member string) error {
+	if *subscription != nil {
+		return nil
+	}
+
+	conn, err := i.conn()
+	if err != nil {
+		return err
 	}
 
+	s, err := conn.Subscribe(login1.Rule{
+		Path:      login1.ManagerPath,
+		Interface: login1.ManagerInterface,
+		Member:    member,
+	}, i.handleIncomingSignal)
+	if err != nil {
+		return err
+	}
+
+	*subscription = s
+
 	return nil
 }
 
-// Close permanently stops processing signals. Discard the inhibitor afterward.
+// unsubscribe closes *subscription, if any, and sets it to nil.
+// Holding the muSignals mutex is required.
+func unsubscribe(subscription **login1.Subscription) error {
+	if *subscription == nil {
+		return nil
+	}
+
+	err := (*subscription).Close()
+	*subscription = nil
+
+	return err
+}
+
+// Close permanently stops processing signals, closes the channels returned by Events and closes
+// the AutoLocks created by AutoInhibit.
+// Locks returned by Inhibit are owned by the caller and are not released.
+// Discard the inhibitor afterward.
 func (i *Inhibitor) Close() error {
-	i.muSignals.Lock()
-	defer i.muSignals.Unlock()
+	i.muAutoLocks.Lock()
+	autoLocks := i.autoLocks
+	i.autoLocks = make(map[*AutoLock]struct{})
+	i.muAutoLocks.Unlock()
 
 	var err error
+	for a := range autoLocks {
+		err = errors.Join(err, a.close())
+	}
 
+	i.muSignals.Lock()
 	clear(i.prepareForSleepSubs)
-	err = errors.Join(err, i.removePrepareForShutdownSignal())
+	err = errors.Join(err, unsubscribe(&i.prepareForSleepSubscription))
 	clear(i.prepareForShutdownSubs)
-	err = errors.Join(err, i.removePrepareForSleepSignal())
+	err = errors.Join(err, unsubscribe(&i.prepareForShutdownSubscription))
+	eventStreams := i.eventStreams
+	i.eventStreams = make(map[*eventStream]struct{})
+	// Release the mutex before closing the connection, the signal handler might be waiting
+	// for it.
+	i.muSignals.Unlock()
+
+	for stream := range eventStreams {
+		err = errors.Join(err, stream.close())
+	}
+
+	i.muConn.Lock()
+	i.closed = true
+	conn := i.login1
+	i.muConn.Unlock()
+	if conn != nil {
+		err = errors.Join(err, conn.Close())
+	}
 
-	close(i.closeSignalHandler)
 	return err
 }
 
@@ -0,0 +1,267 @@
+package inhibit
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeManager implements the Inhibit method of org.freedesktop.login1.Manager.
+type fakeManager struct {
+	err *dbus.Error
+
+	mu sync.Mutex
+	// gate, when set, makes Inhibit wait until it is closed.
+	gate chan struct{}
+	// files keeps the returned file descriptors open until the test ends.
+	files []*os.File
+	// inhibitors are listed by ListInhibitors, locks are never removed.
+	inhibitors []listedInhibitor
+}
+
+func (m *fakeManager) Inhibit(what string, who string, why string, mode string) (dbus.UnixFD, *dbus.Error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.mu.Lock()
+	gate := m.gate
+	m.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, dbus.MakeFailedError(err)
+	}
+	_ = w.Close()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = append(m.files, r)
+	m.inhibitors = append(m.inhibitors, listedInhibitor{
+		What: what,
+		Who:  who,
+		Why:  why,
+		Mode: mode,
+		UID:  uint32(os.Getuid()),
+		PID:  uint32(os.Getpid()),
+	})
+
+	return dbus.UnixFD(r.Fd()), nil
+}
+
+func (m *fakeManager) ListInhibitors() ([]listedInhibitor, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.inhibitors, nil
+}
+
+// calls returns the number of locks handed out.
+func (m *fakeManager) calls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.files)
+}
+
+func newTestInhibitor(t *testing.T, manager *fakeManager) *Inhibitor {
+	t.Helper()
+
+	i, _ := newTestInhibitorService(t, manager)
+	return i
+}
+
+// newTestInhibitorService returns the Inhibitor and the connection of the fake logind, which can
+// be used to emit signals.
+func newTestInhibitorService(t *testing.T, manager *fakeManager) (*Inhibitor, *dbus.Conn) {
+	t.Helper()
+
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, login1.Dest)
+	if err := service.Export(manager, login1.ManagerPath, login1.ManagerInterface); err != nil {
+		t.Fatalf("failed to export fake manager: %v", err)
+	}
+
+	i := newInhibitor(login1.New(bus.Connect(t)))
+	t.Cleanup(func() {
+		_ = i.Close()
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+		for _, f := range manager.files {
+			_ = f.Close()
+		}
+	})
+
+	return i, service
+}
+
+func TestInhibitValidation(t *testing.T) {
+	i := &Inhibitor{}
+
+	tests := []struct {
+		name string
+		who  string
+		why  string
+		mode Mode
+		what []What
+	}{
+		{"empty who", "", "backup", ModeDelay, []What{WhatSleep}},
+		{"invalid who", "agent\xff", "backup", ModeDelay, []What{WhatSleep}},
+		{"empty why", "agent", "", ModeDelay, []What{WhatSleep}},
+		{"invalid why", "agent", "\xc3\x28", ModeDelay, []What{WhatSleep}},
+		{"no what", "agent", "backup", ModeDelay, nil},
+		{"invalid mode", "agent", "backup", "defer", []What{WhatSleep}},
+		{"delay key", "agent", "backup", ModeDelay, []What{WhatSleep, WhatHandlePowerKey}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := i.Inhibit(tt.who, tt.why, tt.mode, tt.what...)
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("Inhibit() error = %v, want ErrInvalidArgument", err)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, want := range []Mode{ModeBlock, ModeBlockWeak, ModeDelay} {
+		got, err := ParseMode(string(want))
+		if err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v", want, got, err)
+		}
+	}
+
+	_, err := ParseMode("Delay")
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("ParseMode() error = %v, want ErrInvalidArgument", err)
+	}
+	if !strings.Contains(err.Error(), "block, block-weak, delay") {
+		t.Errorf("ParseMode() error = %v, want the accepted modes", err)
+	}
+}
+
+func TestInhibitErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     *dbus.Error
+		wantErr error
+	}{
+		{
+			"too many inhibitors",
+			dbus.NewError("org.freedesktop.DBus.Error.LimitsExceeded", []interface{}{
+				"Maximum number of inhibitors (8192) reached, refusing further inhibitors.",
+			}),
+			ErrTooManyInhibitors,
+		},
+		{
+			"access denied",
+			dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []interface{}{"Access denied"}),
+			ErrNotAuthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInhibitor(t, &fakeManager{err: tt.err})
+
+			_, err := i.Inhibit("agent", "backup", ModeBlock, WhatSleep)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Inhibit() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInhibit(t *testing.T) {
+	i := newTestInhibitor(t, &fakeManager{})
+
+	lock, err := i.Inhibit("agent", "backup", ModeDelay, WhatSleep, WhatShutdown)
+	if err != nil {
+		t.Fatalf("Inhibit() error = %v", err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestInhibitWithPolicy(t *testing.T) {
+	manager := &fakeManager{}
+	i := newTestInhibitor(t, manager)
+
+	lock, translation, err := i.InhibitWithPolicy(
+		"agent",
+		"backup",
+		ModeDelay,
+		DropUnsupported,
+		WhatSleep,
+		WhatHandlePowerKey,
+	)
+	if err != nil {
+		t.Fatalf("InhibitWithPolicy() error = %v", err)
+	}
+	defer lock.Close()
+	if len(translation.Dropped) != 1 || translation.Dropped[0] != WhatHandlePowerKey {
+		t.Fatalf("InhibitWithPolicy() dropped %v, want %s", translation.Dropped, WhatHandlePowerKey)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if len(manager.inhibitors) != 1 || manager.inhibitors[0].What != "sleep" {
+		t.Fatalf("logind received %+v, want one lock on sleep", manager.inhibitors)
+	}
+}
+
+func TestWithConn(t *testing.T) {
+	bus := dbustest.New(t)
+	service := bus.RequestName(t, login1.Dest)
+	conn := bus.Connect(t)
+
+	// Another user of the same connection
+	other := make(chan *dbus.Signal, 4)
+	conn.Signal(other)
+	err := conn.AddMatchSignal(dbus.WithMatchInterface(login1.ManagerInterface))
+	if err != nil {
+		t.Fatalf("AddMatchSignal() error = %v", err)
+	}
+
+	i, err := New(WithConn(conn))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sleep := make(chan bool, 1)
+	if err := i.SubscribePrepareForSleep(sleep); err != nil {
+		t.Fatalf("SubscribePrepareForSleep() error = %v", err)
+	}
+
+	emitPrepareForSleep(t, service, true)
+	select {
+	case <-sleep:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("PrepareForSleep not delivered to the Inhibitor")
+	}
+
+	if err := i.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	emitPrepareForSleep(t, service, false)
+	for {
+		select {
+		case s := <-other:
+			if len(s.Body) == 1 && s.Body[0] == false {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("signal not delivered to other user of the connection after Close")
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package inhibit_test
 
 import (
+	"context"
 	"github.com/MatthiasKunnen/system/pkg/inhibit"
 	"io"
 	"log"
@@ -49,3 +50,27 @@ func Example() {
 		}
 	}
 }
+
+func ExampleInhibitor_AutoInhibit() {
+	inhibitor, err := inhibit.New()
+	if err != nil {
+		log.Fatalf("Failed to initialize inhibitor: %v", err)
+	}
+
+	lock, err := inhibitor.AutoInhibit("Name of program", "Reason of delaying", inhibit.WhatSleep)
+	if err != nil {
+		log.Fatalf("Unable to acquire sleep inhibition lock: %v", err)
+	}
+
+	for {
+		if err := lock.WaitSleep(context.Background()); err != nil {
+			log.Printf("Failed to wait for sleep: %v", err)
+			continue
+		}
+
+		log.Printf("System wants to sleep, do our work, then, allow the system to sleep\n")
+		if err := lock.AckSleep(); err != nil {
+			log.Printf("Failed to release inhibitor lock: %v", err)
+		}
+	}
+}
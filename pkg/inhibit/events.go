@@ -0,0 +1,282 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventBuffer is the capacity of the channel returned by Events.
+const eventBuffer = 64
+
+// EventKind is the type of an Event.
+type EventKind int
+
+const (
+	// EventSleep means the system is about to sleep, PrepareForSleep(true).
+	EventSleep EventKind = iota + 1
+	// EventResume means the system resumed from sleep, PrepareForSleep(false).
+	EventResume
+	// EventShutdown means the system is about to shut down or reboot, PrepareForShutdown(true).
+	EventShutdown
+	// EventInhibitorsChanged means an inhibitor lock was taken or released, changing logind's
+	// BlockInhibited or DelayInhibited.
+	EventInhibitorsChanged
+	// EventShutdownCancelled means a shutdown that was announced by EventShutdown was cancelled,
+	// PrepareForShutdown(false).
+	EventShutdownCancelled
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventSleep:
+		return "sleep"
+	case EventResume:
+		return "resume"
+	case EventShutdown:
+		return "shutdown"
+	case EventInhibitorsChanged:
+		return "inhibitors changed"
+	case EventShutdownCancelled:
+		return "shutdown cancelled"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event is a signal of logind, see Inhibitor.Events.
+type Event struct {
+	Kind EventKind
+	// Time is when the signal was received.
+	Time time.Time
+	// Synthetic is true for the events of Inhibitor.FireFakeSleepCycle, which logind did not send.
+	Synthetic bool
+}
+
+// eventStream delivers the events of one Events call.
+type eventStream struct {
+	kinds         []EventKind
+	subscriptions []*login1.Subscription
+
+	mu     sync.Mutex
+	closed bool
+	c      chan Event
+	// done is closed together with c.
+	done chan struct{}
+}
+
+// Events returns a channel that receives the events of the given kinds, all kinds if none are
+// given, in the order they are received from the system bus. Only the signals needed for kinds
+// are subscribed to.
+//
+// The channel is closed when ctx is done or the Inhibitor is closed. It has a buffer of 64
+// events, events are dropped when the consumer falls further behind.
+func (i *Inhibitor) Events(ctx context.Context, kinds ...EventKind) (<-chan Event, error) {
+	if len(kinds) == 0 {
+		kinds = []EventKind{
+			EventSleep,
+			EventResume,
+			EventShutdown,
+			EventInhibitorsChanged,
+			EventShutdownCancelled,
+		}
+	}
+	for _, kind := range kinds {
+		if kind < EventSleep || kind > EventShutdownCancelled {
+			return nil, fmt.Errorf("%w: unknown event kind %s", ErrInvalidArgument, kind)
+		}
+	}
+
+	stream := &eventStream{
+		kinds: kinds,
+		c:     make(chan Event, eventBuffer),
+		done:  make(chan struct{}),
+	}
+
+	conn, err := i.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []login1.Rule
+	if slices.Contains(kinds, EventSleep) || slices.Contains(kinds, EventResume) {
+		rules = append(rules, managerRule(login1.ManagerInterface, "PrepareForSleep"))
+	}
+	if slices.Contains(kinds, EventShutdown) || slices.Contains(kinds, EventShutdownCancelled) {
+		rules = append(rules, managerRule(login1.ManagerInterface, "PrepareForShutdown"))
+	}
+	if slices.Contains(kinds, EventInhibitorsChanged) {
+		rules = append(rules, managerRule(login1.PropertiesInterface, "PropertiesChanged"))
+	}
+
+	for _, rule := range rules {
+		subscription, err := conn.Subscribe(rule, stream.handle)
+		if err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to register Dbus %s signal: %w", rule.Member, err),
+				stream.close(),
+			)
+		}
+		stream.subscriptions = append(stream.subscriptions, subscription)
+	}
+
+	i.muSignals.Lock()
+	i.eventStreams[stream] = struct{}{}
+	i.muSignals.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stream.done:
+			// Closed by Inhibitor.Close
+			return
+		}
+		i.muSignals.Lock()
+		delete(i.eventStreams, stream)
+		i.muSignals.Unlock()
+		_ = stream.close()
+	}()
+
+	return stream.c, nil
+}
+
+// BlockInhibited returns the actions that are blocked by an inhibitor lock, logind's
+// BlockInhibited property, e.g. WhatIdle while a video player prevents the screen from locking.
+// EventInhibitorsChanged is sent when it changes.
+func (i *Inhibitor) BlockInhibited(ctx context.Context) ([]What, error) {
+	conn, err := i.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	var value dbus.Variant
+	err = conn.Manager().
+		CallWithContext(ctx, login1.PropertiesInterface+".Get", 0, login1.ManagerInterface, "BlockInhibited").
+		Store(&value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get BlockInhibited: %w", err)
+	}
+	var blocked string
+	if err := value.Store(&blocked); err != nil {
+		return nil, fmt.Errorf("unexpected type of BlockInhibited: %w", err)
+	}
+
+	var what []What
+	for _, w := range strings.Split(blocked, ":") {
+		if w != "" {
+			what = append(what, What(w))
+		}
+	}
+
+	return what, nil
+}
+
+func managerRule(iface string, member string) login1.Rule {
+	return login1.Rule{
+		Path:      login1.ManagerPath,
+		Interface: iface,
+		Member:    member,
+	}
+}
+
+// handle is called on the dispatch goroutine for every signal of the subscriptions.
+func (e *eventStream) handle(s *dbus.Signal) {
+	e.deliver(s, false)
+}
+
+// deliver sends the event of the signal, if it is of one of the kinds of the stream.
+func (e *eventStream) deliver(s *dbus.Signal, synthetic bool) {
+	kind, ok := eventKind(s)
+	if !ok || !slices.Contains(e.kinds, kind) {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	select {
+	case e.c <- Event{Kind: kind, Time: time.Now(), Synthetic: synthetic}:
+	default:
+	}
+}
+
+// eventKind returns the kind of event the signal represents. Returns false for signals that are
+// not an event, e.g. PropertiesChanged of other properties.
+func eventKind(s *dbus.Signal) (EventKind, bool) {
+	switch s.Name {
+	case login1.ManagerInterface + ".PrepareForSleep":
+		start, ok := signalBool(s)
+		switch {
+		case !ok:
+			return 0, false
+		case start:
+			return EventSleep, true
+		default:
+			return EventResume, true
+		}
+	case login1.ManagerInterface + ".PrepareForShutdown":
+		start, ok := signalBool(s)
+		switch {
+		case !ok:
+			return 0, false
+		case start:
+			return EventShutdown, true
+		default:
+			return EventShutdownCancelled, true
+		}
+	case login1.PropertiesInterface + ".PropertiesChanged":
+		if len(s.Body) < 3 {
+			return 0, false
+		}
+		if iface, ok := s.Body[0].(string); !ok || iface != login1.ManagerInterface {
+			return 0, false
+		}
+		changed, _ := s.Body[1].(map[string]dbus.Variant)
+		invalidated, _ := s.Body[2].([]string)
+		for _, name := range []string{"BlockInhibited", "DelayInhibited"} {
+			if _, ok := changed[name]; ok || slices.Contains(invalidated, name) {
+				return EventInhibitorsChanged, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// signalBool returns the boolean argument of a PrepareForSleep or PrepareForShutdown signal.
+func signalBool(s *dbus.Signal) (bool, bool) {
+	if len(s.Body) == 0 {
+		return false, false
+	}
+
+	v, ok := s.Body[0].(bool)
+	return v, ok
+}
+
+// close removes the subscriptions and closes the channel. Close is idempotent.
+func (e *eventStream) close() error {
+	var err error
+	for _, subscription := range e.subscriptions {
+		err = errors.Join(err, subscription.Close())
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.c)
+		close(e.done)
+	}
+
+	return err
+}
@@ -0,0 +1,173 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"testing"
+	"time"
+)
+
+func emitInhibitorsChanged(t *testing.T, service *dbus.Conn, property string) {
+	t.Helper()
+
+	err := service.Emit(
+		login1.ManagerPath,
+		login1.PropertiesInterface+".PropertiesChanged",
+		login1.ManagerInterface,
+		map[string]dbus.Variant{property: dbus.MakeVariant("sleep")},
+		[]string{},
+	)
+	if err != nil {
+		t.Fatalf("failed to emit PropertiesChanged: %v", err)
+	}
+}
+
+func receiveEvent(t *testing.T, events <-chan Event) EventKind {
+	t.Helper()
+
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		return e.Kind
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return 0
+	}
+}
+
+func TestEvents(t *testing.T) {
+	i, service := newTestInhibitorService(t, &fakeManager{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := i.Events(ctx)
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+
+	emitPrepareForSleep(t, service, true)
+	emitInhibitorsChanged(t, service, "DelayInhibited")
+	emitPrepareForSleep(t, service, false)
+	emitInhibitorsChanged(t, service, "IdleHint") // Not an inhibitor property
+	err = service.Emit(login1.ManagerPath, login1.ManagerInterface+".PrepareForShutdown", true)
+	if err != nil {
+		t.Fatalf("failed to emit PrepareForShutdown: %v", err)
+	}
+
+	want := []EventKind{EventSleep, EventInhibitorsChanged, EventResume, EventShutdown}
+	for n, kind := range want {
+		if got := receiveEvent(t, events); got != kind {
+			t.Fatalf("event %d = %s, want %s", n, got, kind)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("received event after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not closed after cancel")
+	}
+}
+
+func TestEventsKinds(t *testing.T) {
+	i, service := newTestInhibitorService(t, &fakeManager{})
+
+	events, err := i.Events(context.Background(), EventResume, EventInhibitorsChanged)
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+
+	emitPrepareForSleep(t, service, true)
+	emitPrepareForSleep(t, service, false)
+	emitInhibitorsChanged(t, service, "BlockInhibited")
+
+	for _, kind := range []EventKind{EventResume, EventInhibitorsChanged} {
+		if got := receiveEvent(t, events); got != kind {
+			t.Fatalf("event = %s, want %s", got, kind)
+		}
+	}
+
+	if err := i.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("events not closed by Close")
+	}
+}
+
+func TestShutdownCancelled(t *testing.T) {
+	i, service := newTestInhibitorService(t, &fakeManager{})
+
+	shutdown := make(chan bool, 4)
+	if err := i.SubscribePrepareForShutdown(shutdown); err != nil {
+		t.Fatalf("SubscribePrepareForShutdown() error = %v", err)
+	}
+	events, err := i.Events(context.Background(), EventShutdownCancelled)
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+
+	for _, start := range []bool{true, false, true} {
+		err := service.Emit(login1.ManagerPath, login1.ManagerInterface+".PrepareForShutdown", start)
+		if err != nil {
+			t.Fatalf("failed to emit PrepareForShutdown: %v", err)
+		}
+	}
+
+	for n, want := range []bool{true, false, true} {
+		select {
+		case got := <-shutdown:
+			if got != want {
+				t.Fatalf("signal %d = %t, want %t", n, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for signal %d", n)
+		}
+	}
+	if got := receiveEvent(t, events); got != EventShutdownCancelled {
+		t.Fatalf("event = %s, want %s", got, EventShutdownCancelled)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("received %s, only the cancellation was requested", e.Kind)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestEventsInvalidKind(t *testing.T) {
+	i := newTestInhibitor(t, &fakeManager{})
+
+	_, err := i.Events(context.Background(), EventSleep, EventKind(0))
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Events() error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestBlockInhibited(t *testing.T) {
+	i, service := newTestInhibitorService(t, &fakeManager{})
+	properties := &fakeManagerProperties{}
+	err := service.Export(properties, login1.ManagerPath, "org.freedesktop.DBus.Properties")
+	if err != nil {
+		t.Fatalf("failed to export fake properties: %v", err)
+	}
+
+	what, err := i.BlockInhibited(context.Background())
+	if err != nil || len(what) != 0 {
+		t.Fatalf("BlockInhibited() = %v, %v, want nothing", what, err)
+	}
+
+	properties.setBlockInhibited("shutdown:idle")
+	what, err = i.BlockInhibited(context.Background())
+	if err != nil || !slices.Equal(what, []What{WhatShutdown, WhatIdle}) {
+		t.Fatalf("BlockInhibited() = %v, %v, want shutdown and idle", what, err)
+	}
+}
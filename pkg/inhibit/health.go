@@ -0,0 +1,174 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrLockNotListed is returned by AutoLock.Verify when logind does not list the lock.
+var ErrLockNotListed = errors.New("inhibitor lock is not listed by logind")
+
+// HealthReport is the result of Inhibitor.HealthCheck. A nil error means the item is healthy.
+type HealthReport struct {
+	// Bus is the result of pinging logind over the system bus.
+	Bus error
+	// Subscriptions are the signal subscriptions of the Inhibitor.
+	Subscriptions []SubscriptionHealth
+	// Locks are the AutoLocks of the Inhibitor.
+	Locks []LockHealth
+}
+
+// SubscriptionHealth is the health of a signal subscription, see login1.Subscription.Verify.
+type SubscriptionHealth struct {
+	// Signal is the name of the signal, e.g. org.freedesktop.login1.Manager.PrepareForSleep.
+	Signal string
+	Err    error
+}
+
+// LockHealth is the health of an AutoLock, see AutoLock.Verify.
+type LockHealth struct {
+	Who   string
+	Why   string
+	State AutoLockState
+	Err   error
+}
+
+// Err returns the errors of all unhealthy items joined, nil if everything is healthy.
+func (r HealthReport) Err() error {
+	err := r.Bus
+	if err != nil {
+		err = fmt.Errorf("bus: %w", err)
+	}
+	for _, s := range r.Subscriptions {
+		if s.Err != nil {
+			err = errors.Join(err, fmt.Errorf("subscription %s: %w", s.Signal, s.Err))
+		}
+	}
+	for _, l := range r.Locks {
+		if l.Err != nil {
+			err = errors.Join(err, fmt.Errorf("lock of %s: %w", l.Who, l.Err))
+		}
+	}
+
+	return err
+}
+
+// HealthCheck checks whether the Inhibitor can be trusted: whether logind answers on the bus,
+// whether the match rules of its subscriptions are accepted by the bus, and whether logind lists
+// the locks of its AutoLocks. Locks returned by Inhibit are not tracked and not checked.
+//
+// The checks are made in that order and the result of every item is part of the report, see
+// HealthReport.Err. The returned error is only set when ctx is done before the checks finished.
+func (i *Inhibitor) HealthCheck(ctx context.Context) (HealthReport, error) {
+	var report HealthReport
+
+	report.Bus = i.pingLogind(ctx)
+
+	i.muSignals.Lock()
+	var subscriptions []*login1.Subscription
+	for _, s := range []*login1.Subscription{i.prepareForSleepSubscription, i.prepareForShutdownSubscription} {
+		if s != nil {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	for stream := range i.eventStreams {
+		subscriptions = append(subscriptions, stream.subscriptions...)
+	}
+	i.muSignals.Unlock()
+
+	i.muAutoLocks.Lock()
+	autoLocks := make([]*AutoLock, 0, len(i.autoLocks))
+	for a := range i.autoLocks {
+		autoLocks = append(autoLocks, a)
+		subscriptions = append(subscriptions, a.subscription)
+	}
+	i.muAutoLocks.Unlock()
+
+	for _, s := range subscriptions {
+		rule := s.Rule()
+		report.Subscriptions = append(report.Subscriptions, SubscriptionHealth{
+			Signal: rule.Interface + "." + rule.Member,
+			Err:    s.Verify(ctx),
+		})
+	}
+
+	for _, a := range autoLocks {
+		report.Locks = append(report.Locks, LockHealth{
+			Who:   a.who,
+			Why:   a.why,
+			State: a.State(),
+			Err:   a.Verify(ctx),
+		})
+	}
+
+	return report, ctx.Err()
+}
+
+// listedInhibitor is an entry of the ListInhibitors result, a(ssssuu).
+type listedInhibitor struct {
+	What string
+	Who  string
+	Why  string
+	Mode string
+	UID  uint32
+	PID  uint32
+}
+
+// Verify checks that logind lists the lock of the AutoLock while it is held, i.e. while the
+// state is AutoLockArmed or AutoLockSleeping. The lock is recognized by who, why, mode, what, and
+// the process ID; two identical AutoLocks of the same process cannot be told apart.
+// Returns ErrLockNotListed if logind does not list the lock and nil when no lock is held.
+func (a *AutoLock) Verify(ctx context.Context) error {
+	switch a.State() {
+	case AutoLockArmed, AutoLockSleeping:
+	default:
+		return nil
+	}
+
+	what := sortedWhat(joinWhat(a.what))
+	pid := uint32(os.Getpid())
+
+	return a.inhibitor.findLock(ctx, func(inhibitor listedInhibitor) bool {
+		return inhibitor.PID == pid &&
+			inhibitor.Who == a.who &&
+			inhibitor.Why == a.why &&
+			inhibitor.Mode == string(ModeDelay) &&
+			sortedWhat(inhibitor.What) == what
+	})
+}
+
+// findLock returns nil if logind lists a lock for which match returns true, ErrLockNotListed
+// otherwise.
+func (i *Inhibitor) findLock(ctx context.Context, match func(inhibitor listedInhibitor) bool) error {
+	conn, err := i.conn()
+	if err != nil {
+		return err
+	}
+
+	var inhibitors []listedInhibitor
+	err = conn.Manager().
+		CallWithContext(ctx, login1.ManagerInterface+".ListInhibitors", 0).
+		Store(&inhibitors)
+	if err != nil {
+		return fmt.Errorf("failed to list inhibitor locks: %w", err)
+	}
+
+	if slices.ContainsFunc(inhibitors, match) {
+		return nil
+	}
+
+	return ErrLockNotListed
+}
+
+// sortedWhat returns the colon-separated list of what sorted, logind lists them in its own order.
+func sortedWhat(what string) string {
+	elems := strings.Split(what, ":")
+	slices.Sort(elems)
+
+	return strings.Join(elems, ":")
+}
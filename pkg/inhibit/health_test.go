@@ -0,0 +1,56 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	manager := &fakeManager{}
+	i := newTestInhibitor(t, manager)
+
+	if err := i.SubscribePrepareForSleep(make(chan bool, 1)); err != nil {
+		t.Fatalf("SubscribePrepareForSleep() error = %v", err)
+	}
+	a, err := i.AutoInhibit("test", "health", WhatShutdown, WhatSleep)
+	if err != nil {
+		t.Fatalf("AutoInhibit() error = %v", err)
+	}
+
+	report, err := i.HealthCheck(context.Background())
+	if err != nil {
+		t.Fatalf("HealthCheck() error = %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("HealthCheck() report error = %v", err)
+	}
+	if len(report.Subscriptions) != 2 {
+		t.Fatalf("HealthCheck() subscriptions = %+v, want PrepareForSleep and the AutoLock's", report.Subscriptions)
+	}
+	if len(report.Locks) != 1 || report.Locks[0].Who != "test" || report.Locks[0].State != AutoLockArmed {
+		t.Fatalf("HealthCheck() locks = %+v", report.Locks)
+	}
+
+	// logind dropped the lock
+	manager.mu.Lock()
+	manager.inhibitors = nil
+	manager.mu.Unlock()
+
+	report, err = i.HealthCheck(context.Background())
+	if err != nil {
+		t.Fatalf("HealthCheck() error = %v", err)
+	}
+	if !errors.Is(report.Locks[0].Err, ErrLockNotListed) || !errors.Is(report.Err(), ErrLockNotListed) {
+		t.Fatalf("HealthCheck() lock error = %v, want ErrLockNotListed", report.Locks[0].Err)
+	}
+
+	// A closed AutoLock is no longer tracked
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	report, _ = i.HealthCheck(context.Background())
+	if len(report.Locks) != 0 || report.Err() != nil {
+		t.Fatalf("HealthCheck() after Close = %+v", report)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build integration
+
+package inhibit_test
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/inhibit"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// adoptPIDEnv is set for the child process of TestIntegrationAdoptLock to the PID of the parent.
+const adoptPIDEnv = "INHIBIT_TEST_ADOPT_PID"
+
+var adoptMeta = inhibit.LockMetadata{
+	Who:  "inhibit integration test",
+	Why:  "adopting an inherited lock",
+	Mode: inhibit.ModeDelay,
+	What: []inhibit.What{inhibit.WhatSleep},
+}
+
+// Run with: go test -tags integration ./pkg/inhibit
+// Requires logind on the system bus and permission to take a delay lock.
+// TestAdoptLockChildProcess covers the same against a fake logind on a private bus.
+func TestIntegrationAdoptLock(t *testing.T) {
+	i, err := inhibit.New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	lock, err := i.Inhibit(adoptMeta.Who, adoptMeta.Why, adoptMeta.Mode, adoptMeta.What...)
+	if errors.Is(err, inhibit.ErrBackendUnavailable) || errors.Is(err, inhibit.ErrNotAuthorized) {
+		t.Skipf("cannot take a delay lock: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Inhibit() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestIntegrationAdoptLockChild$", "-test.v")
+	cmd.Env = append(os.Environ(), adoptPIDEnv+"="+strconv.Itoa(os.Getpid()))
+	cmd.ExtraFiles = []*os.File{lock.(*os.File)}
+	output, err := cmd.CombinedOutput()
+	_ = lock.Close()
+	if err != nil {
+		t.Fatalf("child failed: %v\n%s", err, output)
+	}
+	t.Logf("child:\n%s", output)
+}
+
+// TestIntegrationAdoptLockChild is run by TestIntegrationAdoptLock in a child process that
+// inherits the lock as file descriptor 3.
+func TestIntegrationAdoptLockChild(t *testing.T) {
+	pid, err := strconv.ParseUint(os.Getenv(adoptPIDEnv), 10, 32)
+	if err != nil {
+		t.Skipf("not run by TestIntegrationAdoptLock")
+	}
+
+	i, err := inhibit.New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	meta := adoptMeta
+	meta.PID = uint32(pid)
+	lock, err := i.AdoptLock(os.NewFile(3, "inherited lock"), meta)
+	if err != nil {
+		t.Fatalf("AdoptLock() error = %v", err)
+	}
+	if _, err := i.AdoptLock(os.NewFile(3, "inherited lock"), meta); !errors.Is(err, inhibit.ErrLockAdopted) {
+		t.Fatalf("second AdoptLock() error = %v, want ErrLockAdopted", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := lock.Verify(ctx); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package inhibit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLeaseExpired is returned when renewing a Lease that has already expired or was closed.
+var ErrLeaseExpired = errors.New("lease expired")
+
+// Lease is a subscription that is automatically unsubscribed when it is not renewed within its
+// time-to-live. This prevents subscriptions from leaking when the subscriber loses track of its
+// channel without unsubscribing.
+type Lease struct {
+	mu          sync.Mutex
+	c           chan<- bool
+	deadline    time.Time
+	expired     bool
+	timer       *time.Timer
+	ttl         time.Duration
+	unsubscribe func(c chan<- bool) error
+}
+
+func newLease(c chan<- bool, ttl time.Duration, unsubscribe func(c chan<- bool) error) *Lease {
+	l := &Lease{
+		c:           c,
+		deadline:    time.Now().Add(ttl),
+		ttl:         ttl,
+		unsubscribe: unsubscribe,
+	}
+	l.timer = time.AfterFunc(ttl, l.expire)
+
+	return l
+}
+
+// Renew extends the lease by its time-to-live.
+// Returns ErrLeaseExpired if the lease has already expired or was closed.
+func (l *Lease) Renew() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.expired {
+		return ErrLeaseExpired
+	}
+
+	l.deadline = time.Now().Add(l.ttl)
+	l.timer.Reset(l.ttl)
+
+	return nil
+}
+
+// Close ends the lease and unsubscribes its channel.
+// Closing an expired lease is a no-op.
+func (l *Lease) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.expired {
+		return nil
+	}
+	l.expired = true
+	l.timer.Stop()
+
+	return l.unsubscribe(l.c)
+}
+
+func (l *Lease) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.expired {
+		return
+	}
+
+	if remaining := time.Until(l.deadline); remaining > 0 {
+		// Renewed while this function was waiting for the lock
+		l.timer.Reset(remaining)
+		return
+	}
+
+	l.expired = true
+	// There is no caller to report the error to, the match rule will be removed on the next
+	// unsubscribe or on Close.
+	_ = l.unsubscribe(l.c)
+}
+
+// SubscribePrepareForSleepLease works like SubscribePrepareForSleep but the subscription expires
+// unless the returned Lease is renewed within ttl.
+// When the lease expires or is closed, the channel is unsubscribed, even if it was also
+// subscribed using SubscribePrepareForSleep.
+func (i *Inhibitor) SubscribePrepareForSleepLease(c chan<- bool, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, errors.New("SubscribePrepareForSleepLease: ttl must be positive")
+	}
+
+	if err := i.SubscribePrepareForSleep(c); err != nil {
+		return nil, err
+	}
+
+	return newLease(c, ttl, i.UnsubscribePrepareForSleep), nil
+}
+
+// SubscribePrepareForShutdownLease works like SubscribePrepareForShutdown but the subscription
+// expires unless the returned Lease is renewed within ttl.
+// When the lease expires or is closed, the channel is unsubscribed, even if it was also
+// subscribed using SubscribePrepareForShutdown.
+func (i *Inhibitor) SubscribePrepareForShutdownLease(c chan<- bool, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, errors.New("SubscribePrepareForShutdownLease: ttl must be positive")
+	}
+
+	if err := i.SubscribePrepareForShutdown(c); err != nil {
+		return nil, err
+	}
+
+	return newLease(c, ttl, i.UnsubscribePrepareForShutdown), nil
+}
+
+// Stats contains the number of subscribers of the Inhibitor.
+type Stats struct {
+	PrepareForSleepSubscribers    int
+	PrepareForShutdownSubscribers int
+}
+
+// Stats returns the current number of subscribers. Useful to detect leaking subscriptions.
+func (i *Inhibitor) Stats() Stats {
+	i.muSignals.Lock()
+	defer i.muSignals.Unlock()
+
+	return Stats{
+		PrepareForSleepSubscribers:    len(i.prepareForSleepSubs),
+		PrepareForShutdownSubscribers: len(i.prepareForShutdownSubs),
+	}
+}
@@ -0,0 +1,63 @@
+package inhibit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeaseExpires(t *testing.T) {
+	unsubscribed := make(chan chan<- bool, 1)
+	c := make(chan bool)
+	l := newLease(c, 20*time.Millisecond, func(c chan<- bool) error {
+		unsubscribed <- c
+		return nil
+	})
+
+	select {
+	case got := <-unsubscribed:
+		if got != c {
+			t.Fatalf("unsubscribed wrong channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("lease did not expire")
+	}
+
+	if err := l.Renew(); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("Renew() after expiry = %v, want ErrLeaseExpired", err)
+	}
+}
+
+func TestLeaseRenew(t *testing.T) {
+	unsubscribed := make(chan chan<- bool, 1)
+	l := newLease(make(chan bool), 50*time.Millisecond, func(c chan<- bool) error {
+		unsubscribed <- c
+		return nil
+	})
+
+	for range 5 {
+		time.Sleep(20 * time.Millisecond)
+		if err := l.Renew(); err != nil {
+			t.Fatalf("Renew() = %v", err)
+		}
+	}
+
+	select {
+	case <-unsubscribed:
+		t.Fatalf("renewed lease expired")
+	default:
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if len(unsubscribed) != 1 {
+		t.Fatalf("Close() did not unsubscribe")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("second Close() = %v", err)
+	}
+	if len(unsubscribed) != 1 {
+		t.Fatalf("second Close() unsubscribed again")
+	}
+}
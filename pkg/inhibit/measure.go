@@ -0,0 +1,153 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"time"
+)
+
+// delayWindowTolerance is how much shorter than InhibitDelayMaxSec the measured window may be to
+// still be considered respected. It covers the delivery of PrepareForSleep and the time between
+// resuming and the delivery of PrepareForSleep(false).
+const delayWindowTolerance = time.Second
+
+// DelayWindow is the result of MeasureDelayWindow.
+type DelayWindow struct {
+	// Budget is logind's InhibitDelayMaxSec, the longest a delay lock can delay a suspend.
+	Budget time.Duration
+	// Window is the time the system stayed awake after PrepareForSleep(true) was received,
+	// measured using the monotonic clock, which does not advance while suspended.
+	Window time.Duration
+	// Suspended is how long the system was suspended, the difference between the wall clock
+	// and the monotonic clock over the cycle.
+	Suspended time.Duration
+	// Respected is true when the system stayed awake for the Budget, i.e. the delay lock was
+	// honored.
+	Respected bool
+}
+
+// MeasureDelayWindow takes a delay lock on sleep and keeps it during the next suspend to find
+// out whether the suspend is delayed for logind's InhibitDelayMaxSec. It returns after the system
+// resumed, so a suspend must be triggered while it runs. Meant for diagnosing delay locks that
+// seem to have no effect, see ReportDelayWindows to measure every suspend.
+//
+// Returns ctx.Err() when ctx is done before the system resumed.
+func (i *Inhibitor) MeasureDelayWindow(ctx context.Context) (DelayWindow, error) {
+	var window DelayWindow
+	err := i.measureDelayWindows(ctx, func(w DelayWindow) bool {
+		window = w
+		return false
+	})
+
+	return window, err
+}
+
+// ReportDelayWindows is the diagnostic mode of MeasureDelayWindow. It holds a delay lock on sleep
+// until ctx is done and calls report with the DelayWindow of every suspend, after the system
+// resumed. report is called on the goroutine of ReportDelayWindows. Every suspend is delayed by
+// up to InhibitDelayMaxSec while it runs.
+//
+// Returns ctx.Err() once ctx is done.
+func (i *Inhibitor) ReportDelayWindows(ctx context.Context, report func(window DelayWindow)) error {
+	return i.measureDelayWindows(ctx, func(window DelayWindow) bool {
+		report(window)
+		return true
+	})
+}
+
+// measureDelayWindows holds a delay lock on sleep and calls report with the DelayWindow of every
+// suspend until report returns false or ctx is done.
+func (i *Inhibitor) measureDelayWindows(ctx context.Context, report func(DelayWindow) bool) error {
+	conn, err := i.conn()
+	if err != nil {
+		return err
+	}
+
+	var variant dbus.Variant
+	err = conn.Manager().CallWithContext(
+		ctx,
+		login1.PropertiesInterface+".Get",
+		0,
+		login1.ManagerInterface,
+		"InhibitDelayMaxUSec",
+	).Store(&variant)
+	if err != nil {
+		return fmt.Errorf("failed to get InhibitDelayMaxUSec: %w", err)
+	}
+	var budgetUSec uint64
+	if err := variant.Store(&budgetUSec); err != nil {
+		return fmt.Errorf("unexpected type of InhibitDelayMaxUSec: %w", err)
+	}
+	budget := time.Duration(budgetUSec) * time.Microsecond
+
+	type edge struct {
+		start bool
+		at    time.Time
+	}
+	edges := make(chan edge, 2)
+	subscription, err := conn.Subscribe(login1.Rule{
+		Path:      login1.ManagerPath,
+		Interface: login1.ManagerInterface,
+		Member:    "PrepareForSleep",
+	}, func(s *dbus.Signal) {
+		// Timestamp on receipt, before anything else can delay it
+		at := time.Now()
+		if len(s.Body) == 0 {
+			return
+		}
+		start, ok := s.Body[0].(bool)
+		if !ok {
+			return
+		}
+		select {
+		case edges <- edge{start: start, at: at}:
+		default:
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus PrepareForSleep signal: %w", err)
+	}
+
+	// A delay lock is not consumed by a suspend, the same lock delays every cycle
+	lock, err := i.Inhibit("MeasureDelayWindow", "Measuring the delay of suspend", ModeDelay, WhatSleep)
+	if err != nil {
+		return errors.Join(err, subscription.Close())
+	}
+
+	var start time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), lock.Close(), subscription.Close())
+		case e := <-edges:
+			switch {
+			case e.start:
+				start = e.at
+				continue
+			case start.IsZero():
+				// Resume of a suspend that started before measuring
+				continue
+			}
+
+			window := newDelayWindow(e.at.Sub(start), e.at.Round(0).Sub(start.Round(0)), budget)
+			start = time.Time{}
+			if !report(window) {
+				return errors.Join(lock.Close(), subscription.Close())
+			}
+		}
+	}
+}
+
+// newDelayWindow computes the DelayWindow of a cycle that took monotonic on the monotonic clock
+// and wall on the wall clock.
+func newDelayWindow(monotonic time.Duration, wall time.Duration, budget time.Duration) DelayWindow {
+	return DelayWindow{
+		Budget:    budget,
+		Window:    monotonic,
+		Suspended: max(wall-monotonic, 0),
+		Respected: monotonic+delayWindowTolerance >= budget,
+	}
+}
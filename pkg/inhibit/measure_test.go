@@ -0,0 +1,177 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeManagerProperties implements org.freedesktop.DBus.Properties for the manager.
+type fakeManagerProperties struct {
+	inhibitDelayMax time.Duration
+
+	mu             sync.Mutex
+	blockInhibited string
+}
+
+func (p *fakeManagerProperties) setBlockInhibited(what string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.blockInhibited = what
+}
+
+func (p *fakeManagerProperties) Get(iface string, name string) (dbus.Variant, *dbus.Error) {
+	switch {
+	case iface != login1.ManagerInterface:
+	case name == "InhibitDelayMaxUSec":
+		return dbus.MakeVariant(uint64(p.inhibitDelayMax.Microseconds())), nil
+	case name == "BlockInhibited":
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return dbus.MakeVariant(p.blockInhibited), nil
+	}
+
+	return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", nil)
+}
+
+func TestMeasureDelayWindow(t *testing.T) {
+	manager := &fakeManager{}
+	i, service := newTestInhibitorService(t, manager)
+	properties := &fakeManagerProperties{inhibitDelayMax: 50 * time.Millisecond}
+	err := service.Export(properties, login1.ManagerPath, "org.freedesktop.DBus.Properties")
+	if err != nil {
+		t.Fatalf("failed to export fake properties: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan DelayWindow, 1)
+	go func() {
+		window, err := i.MeasureDelayWindow(ctx)
+		if err != nil {
+			t.Errorf("MeasureDelayWindow() error = %v", err)
+		}
+		result <- window
+	}()
+
+	// Wait for the lock, then simulate a suspend that honors it
+	for manager.calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	emitPrepareForSleep(t, service, true)
+	// Twice the budget, the signals can be delayed on their way
+	time.Sleep(2 * properties.inhibitDelayMax)
+	emitPrepareForSleep(t, service, false)
+
+	window := <-result
+	if window.Budget != properties.inhibitDelayMax || window.Window < properties.inhibitDelayMax || !window.Respected {
+		t.Fatalf("MeasureDelayWindow() = %+v", window)
+	}
+}
+
+func TestMeasureDelayWindowCanceled(t *testing.T) {
+	manager := &fakeManager{}
+	i, service := newTestInhibitorService(t, manager)
+	properties := &fakeManagerProperties{inhibitDelayMax: time.Second}
+	err := service.Export(properties, login1.ManagerPath, "org.freedesktop.DBus.Properties")
+	if err != nil {
+		t.Fatalf("failed to export fake properties: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := i.MeasureDelayWindow(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("MeasureDelayWindow() error = %v, want context.Canceled", err)
+	}
+	if n := manager.calls(); n != 0 {
+		t.Fatalf("%d locks taken after the context was canceled, want 0", n)
+	}
+}
+
+func TestReportDelayWindows(t *testing.T) {
+	manager := &fakeManager{}
+	i, service := newTestInhibitorService(t, manager)
+	properties := &fakeManagerProperties{inhibitDelayMax: 50 * time.Millisecond}
+	err := service.Export(properties, login1.ManagerPath, "org.freedesktop.DBus.Properties")
+	if err != nil {
+		t.Fatalf("failed to export fake properties: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	windows := make(chan DelayWindow)
+	done := make(chan error, 1)
+	go func() {
+		done <- i.ReportDelayWindows(ctx, func(window DelayWindow) {
+			windows <- window
+		})
+	}()
+
+	for manager.calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// A suspend that honors the lock, then one that ignores it
+	for _, honored := range []bool{true, false} {
+		emitPrepareForSleep(t, service, true)
+		if honored {
+			time.Sleep(2 * properties.inhibitDelayMax)
+		}
+		emitPrepareForSleep(t, service, false)
+
+		window := <-windows
+		if window.Budget != properties.inhibitDelayMax || (window.Window >= window.Budget) != honored {
+			t.Fatalf("reported %+v after a suspend that honored the lock: %t", window, honored)
+		}
+	}
+	if n := manager.calls(); n != 1 {
+		t.Fatalf("%d locks taken, want one for every cycle", n)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReportDelayWindows() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestNewDelayWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		monotonic time.Duration
+		wall      time.Duration
+		want      DelayWindow
+	}{
+		{
+			name:      "respected",
+			monotonic: 5 * time.Second,
+			wall:      time.Hour,
+			want:      DelayWindow{Budget: 5 * time.Second, Window: 5 * time.Second, Suspended: time.Hour - 5*time.Second, Respected: true},
+		},
+		{
+			name:      "ignored",
+			monotonic: 100 * time.Millisecond,
+			wall:      time.Minute,
+			want:      DelayWindow{Budget: 5 * time.Second, Window: 100 * time.Millisecond, Suspended: time.Minute - 100*time.Millisecond},
+		},
+		{
+			name:      "not suspended",
+			monotonic: 5 * time.Second,
+			wall:      5 * time.Second,
+			want:      DelayWindow{Budget: 5 * time.Second, Window: 5 * time.Second, Respected: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newDelayWindow(tt.monotonic, tt.wall, 5*time.Second); got != tt.want {
+				t.Fatalf("newDelayWindow() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"io"
+	"sync"
+)
+
+const (
+	portalDest             = "org.freedesktop.portal.Desktop"
+	portalPath             = "/org/freedesktop/portal/desktop"
+	portalInhibitInterface = "org.freedesktop.portal.Inhibit"
+	portalRequestInterface = "org.freedesktop.portal.Request"
+)
+
+// The flags of the Inhibit method of the portal.
+const (
+	portalFlagLogout     uint32 = 1
+	portalFlagUserSwitch uint32 = 2
+	portalFlagSuspend    uint32 = 4
+	portalFlagIdle       uint32 = 8
+)
+
+// ErrUnsupportedByPortal is returned by Portal.Inhibit when the lock cannot be expressed using the
+// inhibit portal, e.g. a block lock or a lock on the lid switch.
+var ErrUnsupportedByPortal = errors.New("not supported by the inhibit portal")
+
+// Backend takes inhibitor locks. It is implemented by Inhibitor and Portal, see NewAuto.
+type Backend interface {
+	// Inhibit creates an inhibition lock, see Inhibitor.Inhibit.
+	Inhibit(who string, why string, mode Mode, what ...What) (io.Closer, error)
+	// InhibitWithPolicy creates an inhibition lock on the actions that the backend supports
+	// according to policy, see Inhibitor.InhibitWithPolicy.
+	InhibitWithPolicy(
+		who string,
+		why string,
+		mode Mode,
+		policy Policy,
+		what ...What,
+	) (io.Closer, Translation, error)
+	io.Closer
+}
+
+// Portal takes inhibitor locks using the [inhibit portal] of the session bus, which is available
+// in sandboxes such as Flatpak where logind on the system bus cannot be reached. The desktop
+// environment decides how to honor the lock.
+//
+// [inhibit portal]: https://flatpak.github.io/xdg-desktop-portal/docs/doc-org.freedesktop.portal.Inhibit.html
+type Portal struct {
+	conn     *dbus.Conn
+	ownsConn bool
+	obj      dbus.BusObject
+}
+
+// WithSessionConn makes the Portal use conn instead of connecting to the session bus. conn is
+// not closed when the Portal is closed. Only used by NewPortal and NewAuto.
+func WithSessionConn(conn *dbus.Conn) Option {
+	return func(o *options) {
+		o.sessionConn = conn
+	}
+}
+
+// NewPortal creates a Portal. Unless WithSessionConn is given, a new session bus connection is
+// made.
+func NewPortal(opts ...Option) (*Portal, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	p := &Portal{conn: o.sessionConn}
+	if p.conn == nil {
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to session bus: %w", err)
+		}
+		p.conn = conn
+		p.ownsConn = true
+	}
+	p.obj = p.conn.Object(portalDest, portalPath)
+
+	return p, nil
+}
+
+// NewAuto returns an Inhibitor when logind can be reached and a Portal otherwise, so that the
+// same program works inside and outside a sandbox. The options are passed to New and NewPortal.
+func NewAuto(opts ...Option) (Backend, error) {
+	i, err := New(opts...)
+	if err == nil {
+		err = i.pingLogind(context.Background())
+		if err == nil {
+			return i, nil
+		}
+		err = errors.Join(err, i.Close())
+	}
+
+	p, portalErr := NewPortal(opts...)
+	if portalErr == nil {
+		portalErr = p.ping(context.Background())
+		if portalErr == nil {
+			return p, nil
+		}
+		portalErr = errors.Join(portalErr, p.Close())
+	}
+
+	return nil, errors.Join(err, portalErr)
+}
+
+// Inhibit asks the desktop environment to inhibit what. who is not sent, the portal identifies
+// the application itself, why is shown to the user.
+//
+// The portal cannot guarantee that an action is blocked or delayed, only ModeBlockWeak is
+// supported. WhatShutdown inhibits logging out, WhatSleep suspending, and WhatIdle the session
+// becoming idle. ErrUnsupportedByPortal is returned for other modes and actions, see
+// InhibitWithPolicy to drop them instead.
+// Arguments are validated like Inhibitor.Inhibit, ErrInvalidArgument is returned when invalid.
+func (p *Portal) Inhibit(who string, why string, mode Mode, what ...What) (io.Closer, error) {
+	lock, _, err := p.InhibitWithPolicy(who, why, mode, StrictFail, what...)
+	return lock, err
+}
+
+// InhibitWithPolicy is like Inhibit, but policy decides what happens with the modes and actions
+// that the portal does not support. The returned Translation reports what the lock inhibits and
+// in which mode. With BestEffort, the lock is a no-op when no action is left.
+func (p *Portal) InhibitWithPolicy(
+	who string,
+	why string,
+	mode Mode,
+	policy Policy,
+	what ...What,
+) (io.Closer, Translation, error) {
+	t, err := translate(backendPortal, who, why, mode, policy, what)
+	if err != nil {
+		return nil, Translation{}, err
+	}
+	if len(t.Inhibited) == 0 {
+		return nopLock{}, t, nil
+	}
+
+	var handle dbus.ObjectPath
+	err = p.obj.Call(
+		portalInhibitInterface+".Inhibit",
+		0,
+		"",
+		portalFlags(t.Inhibited),
+		map[string]dbus.Variant{"reason": dbus.MakeVariant(why)},
+	).Store(&handle)
+	if err != nil {
+		return nil, Translation{}, fmt.Errorf("failed to create inhibit lock: %w", err)
+	}
+
+	return &portalLock{request: p.conn.Object(portalDest, handle)}, t, nil
+}
+
+// Close closes the session bus connection if it was created by NewPortal. Locks returned by
+// Inhibit are released when the connection is closed.
+func (p *Portal) Close() error {
+	if !p.ownsConn {
+		return nil
+	}
+
+	return p.conn.Close()
+}
+
+// portalLock releases the lock by closing the request of the Inhibit call.
+type portalLock struct {
+	request dbus.BusObject
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Close releases the lock. Calling Close more than once is a no-op.
+func (l *portalLock) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	err := l.request.Call(portalRequestInterface+".Close", 0).Err
+	if err != nil {
+		return fmt.Errorf("failed to release inhibit lock: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package inhibit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakePortal implements the Inhibit method of org.freedesktop.portal.Inhibit.
+type fakePortal struct {
+	conn *dbus.Conn
+
+	mu      sync.Mutex
+	flags   uint32
+	reason  string
+	handles int
+	closed  []dbus.ObjectPath
+}
+
+func (p *fakePortal) Inhibit(
+	window string,
+	flags uint32,
+	options map[string]dbus.Variant,
+) (dbus.ObjectPath, *dbus.Error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.flags = flags
+	p.reason, _ = options["reason"].Value().(string)
+	p.handles++
+	handle := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/portal/desktop/request/1_1/t%d", p.handles))
+	err := p.conn.Export(&fakeRequest{portal: p, path: handle}, handle, portalRequestInterface)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+
+	return handle, nil
+}
+
+// fakeRequest implements org.freedesktop.portal.Request.
+type fakeRequest struct {
+	portal *fakePortal
+	path   dbus.ObjectPath
+}
+
+func (r *fakeRequest) Close() *dbus.Error {
+	r.portal.mu.Lock()
+	defer r.portal.mu.Unlock()
+
+	r.portal.closed = append(r.portal.closed, r.path)
+
+	return nil
+}
+
+func newTestPortal(t *testing.T) (*Portal, *fakePortal) {
+	t.Helper()
+
+	bus := dbustest.New(t)
+	portal := &fakePortal{conn: bus.RequestName(t, portalDest)}
+	if err := portal.conn.Export(portal, portalPath, portalInhibitInterface); err != nil {
+		t.Fatalf("failed to export fake portal: %v", err)
+	}
+
+	p, err := NewPortal(WithSessionConn(bus.Connect(t)))
+	if err != nil {
+		t.Fatalf("NewPortal() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+
+	return p, portal
+}
+
+func TestPortalInhibit(t *testing.T) {
+	p, portal := newTestPortal(t)
+
+	lock, err := p.Inhibit("player", "Playing video", ModeBlockWeak, WhatIdle, WhatSleep)
+	if err != nil {
+		t.Fatalf("Inhibit() error = %v", err)
+	}
+
+	portal.mu.Lock()
+	flags, reason := portal.flags, portal.reason
+	portal.mu.Unlock()
+	if flags != portalFlagIdle|portalFlagSuspend || reason != "Playing video" {
+		t.Fatalf("portal received flags %d and reason %q", flags, reason)
+	}
+
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	if len(portal.closed) != 1 {
+		t.Fatalf("request closed %d times, want once", len(portal.closed))
+	}
+}
+
+func TestPortalInhibitUnsupported(t *testing.T) {
+	p, portal := newTestPortal(t)
+
+	tests := []struct {
+		name string
+		mode Mode
+		what []What
+		want error
+	}{
+		{name: "block", mode: ModeBlock, what: []What{WhatIdle}, want: ErrUnsupportedByPortal},
+		{name: "delay", mode: ModeDelay, what: []What{WhatSleep}, want: ErrUnsupportedByPortal},
+		{name: "lid switch", mode: ModeBlockWeak, what: []What{WhatIdle, WhatHandleLidSwitch}, want: ErrUnsupportedByPortal},
+		{name: "invalid", mode: ModeBlockWeak, what: nil, want: ErrInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Inhibit("player", "Playing video", tt.mode, tt.what...)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Inhibit() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	if portal.handles != 0 {
+		t.Fatalf("portal called %d times for unsupported locks", portal.handles)
+	}
+}
+
+func TestPortalInhibitWithPolicy(t *testing.T) {
+	p, portal := newTestPortal(t)
+
+	lock, translation, err := p.InhibitWithPolicy(
+		"player",
+		"Playing video",
+		ModeBlock,
+		BestEffort,
+		WhatIdle,
+		WhatHandleLidSwitch,
+	)
+	if err != nil {
+		t.Fatalf("InhibitWithPolicy() error = %v", err)
+	}
+	defer lock.Close()
+	want := Translation{
+		Inhibited: []What{WhatIdle},
+		Dropped:   []What{WhatHandleLidSwitch},
+		Mode:      ModeBlockWeak,
+	}
+	if !slices.Equal(translation.Inhibited, want.Inhibited) ||
+		!slices.Equal(translation.Dropped, want.Dropped) ||
+		translation.Mode != want.Mode {
+		t.Fatalf("InhibitWithPolicy() translation = %+v, want %+v", translation, want)
+	}
+	portal.mu.Lock()
+	flags := portal.flags
+	portal.mu.Unlock()
+	if flags != portalFlagIdle {
+		t.Fatalf("portal received flags %d, want %d", flags, portalFlagIdle)
+	}
+
+	// Nothing left to inhibit
+	what := WhatHandleLidSwitch
+	_, _, err = p.InhibitWithPolicy("player", "Playing video", ModeBlockWeak, DropUnsupported, what)
+	if !errors.Is(err, ErrUnsupportedByPortal) {
+		t.Fatalf("InhibitWithPolicy() error = %v, want ErrUnsupportedByPortal", err)
+	}
+	lock, translation, err = p.InhibitWithPolicy("player", "Playing video", ModeBlockWeak, BestEffort, what)
+	if err != nil || len(translation.Inhibited) != 0 {
+		t.Fatalf("InhibitWithPolicy() = %+v, %v, want a lock that inhibits nothing", translation, err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	if portal.handles != 1 {
+		t.Fatalf("portal called %d times, want once", portal.handles)
+	}
+}
+
+func TestNewAuto(t *testing.T) {
+	t.Run("logind", func(t *testing.T) {
+		bus := dbustest.New(t)
+		service := bus.RequestName(t, login1.Dest)
+		err := service.Export(&fakeManager{}, login1.ManagerPath, login1.ManagerInterface)
+		if err != nil {
+			t.Fatalf("failed to export fake manager: %v", err)
+		}
+
+		backend, err := NewAuto(WithConn(bus.Connect(t)), WithSessionConn(bus.Connect(t)))
+		if err != nil {
+			t.Fatalf("NewAuto() error = %v", err)
+		}
+		defer backend.Close()
+		if _, ok := backend.(*Inhibitor); !ok {
+			t.Fatalf("NewAuto() = %T, want *Inhibitor", backend)
+		}
+	})
+
+	t.Run("portal", func(t *testing.T) {
+		bus := dbustest.New(t)
+		portal := &fakePortal{conn: bus.RequestName(t, portalDest)}
+		if err := portal.conn.Export(portal, portalPath, portalInhibitInterface); err != nil {
+			t.Fatalf("failed to export fake portal: %v", err)
+		}
+
+		backend, err := NewAuto(WithConn(bus.Connect(t)), WithSessionConn(bus.Connect(t)))
+		if err != nil {
+			t.Fatalf("NewAuto() error = %v", err)
+		}
+		defer backend.Close()
+		if _, ok := backend.(*Portal); !ok {
+			t.Fatalf("NewAuto() = %T, want *Portal", backend)
+		}
+	})
+
+	t.Run("neither", func(t *testing.T) {
+		bus := dbustest.New(t)
+
+		_, err := NewAuto(WithConn(bus.Connect(t)), WithSessionConn(bus.Connect(t)))
+		if err == nil {
+			t.Fatal("NewAuto() error = nil without logind and portal")
+		}
+	})
+}
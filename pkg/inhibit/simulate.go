@@ -0,0 +1,67 @@
+package inhibit
+
+import (
+	"context"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"time"
+)
+
+// FireFakeSleepCycle simulates a suspend, for development on machines that should not sleep.
+// PrepareForSleep(true) and, after sleepDuration, PrepareForSleep(false) are delivered to the
+// subscribers of this Inhibitor as if logind sent them: the channels of
+// SubscribePrepareForSleep, the AutoLocks, and the channels of Events, whose events have
+// Synthetic set. Nothing is sent over the bus, other Inhibitors and processes are not notified
+// and the system does not sleep. AutoLocks do release and take their lock again, as in a real
+// cycle, and other subscribers can be notified of the resume before that lock is held. Unlike
+// logind, the resume does not wait for delay locks to be released.
+//
+// When ctx is done before sleepDuration has passed, the resume is delivered right away and
+// ctx.Err() is returned.
+func (i *Inhibitor) FireFakeSleepCycle(ctx context.Context, sleepDuration time.Duration) error {
+	i.deliverSynthetic(true)
+
+	timer := time.NewTimer(sleepDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	i.deliverSynthetic(false)
+
+	return ctx.Err()
+}
+
+// deliverSynthetic hands a PrepareForSleep signal that was not received from the bus to the
+// subscribers, through the same handlers as real signals. The AutoLocks are not subscribed through
+// the Inhibitor and are handed the signal separately.
+func (i *Inhibitor) deliverSynthetic(start bool) {
+	s := &dbus.Signal{
+		Path: login1.ManagerPath,
+		Name: login1.ManagerInterface + ".PrepareForSleep",
+		Body: []any{start},
+	}
+
+	i.muAutoLocks.Lock()
+	autoLocks := make([]*AutoLock, 0, len(i.autoLocks))
+	for a := range i.autoLocks {
+		autoLocks = append(autoLocks, a)
+	}
+	i.muAutoLocks.Unlock()
+	for _, a := range autoLocks {
+		a.handlePrepareForSleep(s)
+	}
+
+	i.handleIncomingSignal(s)
+
+	i.muSignals.Lock()
+	streams := make([]*eventStream, 0, len(i.eventStreams))
+	for stream := range i.eventStreams {
+		streams = append(streams, stream)
+	}
+	i.muSignals.Unlock()
+	for _, stream := range streams {
+		stream.deliver(s, true)
+	}
+}
@@ -0,0 +1,115 @@
+package inhibit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestFireFakeSleepCycle checks that the subscribers behave the same for a synthetic cycle as for
+// a cycle of signals emitted by logind.
+func TestFireFakeSleepCycle(t *testing.T) {
+	manager := &fakeManager{}
+	i, service := newTestInhibitorService(t, manager)
+
+	a, err := i.AutoInhibit("agent", "flush", WhatSleep)
+	if err != nil {
+		t.Fatalf("AutoInhibit() error = %v", err)
+	}
+	sleep := make(chan bool, 2)
+	if err := i.SubscribePrepareForSleep(sleep); err != nil {
+		t.Fatalf("SubscribePrepareForSleep() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := i.Events(ctx, EventSleep, EventResume)
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+
+	// cycle goes through a sleep cycle started by start and ended by resume, and returns what
+	// the subscribers observed.
+	cycle := func(synthetic bool, start func(), resume func()) []string {
+		var observed []string
+		observe := func(format string, args ...any) {
+			observed = append(observed, fmt.Sprintf(format, args...))
+		}
+		receive := func() {
+			select {
+			case v := <-sleep:
+				observe("PrepareForSleep(%t)", v)
+			case <-ctx.Done():
+				t.Fatal("timed out waiting for PrepareForSleep")
+			}
+			select {
+			case e := <-events:
+				observe("event %s", e.Kind)
+				if e.Synthetic != synthetic {
+					t.Errorf("event %s Synthetic = %t, want %t", e.Kind, e.Synthetic, synthetic)
+				}
+			case <-ctx.Done():
+				t.Fatal("timed out waiting for event")
+			}
+		}
+
+		locks := manager.calls()
+		start()
+		observe("WaitSleep: %v", a.WaitSleep(ctx))
+		receive()
+		observe("state %s", a.State())
+		observe("AckSleep: %v", a.AckSleep())
+		observe("state %s", a.State())
+
+		resume()
+		receive()
+		for a.State() != AutoLockArmed && ctx.Err() == nil {
+			time.Sleep(time.Millisecond)
+		}
+		observe("state %s, %d lock(s) taken", a.State(), manager.calls()-locks)
+
+		return observed
+	}
+
+	emitted := cycle(
+		false,
+		func() { emitPrepareForSleep(t, service, true) },
+		func() { emitPrepareForSleep(t, service, false) },
+	)
+
+	fireErr := make(chan error, 1)
+	fireCtx, resume := context.WithCancel(context.Background())
+	defer resume()
+	synthetic := cycle(
+		true,
+		func() {
+			go func() {
+				fireErr <- i.FireFakeSleepCycle(fireCtx, time.Hour)
+			}()
+		},
+		resume,
+	)
+	if err := <-fireErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("FireFakeSleepCycle() error = %v, want context.Canceled", err)
+	}
+
+	if !slices.Equal(emitted, synthetic) {
+		t.Fatalf("synthetic cycle observed\n%q\nreal cycle observed\n%q", synthetic, emitted)
+	}
+	want := []string{
+		"WaitSleep: <nil>",
+		"PrepareForSleep(true)",
+		"event sleep",
+		"state sleeping",
+		"AckSleep: <nil>",
+		"state released",
+		"PrepareForSleep(false)",
+		"event resume",
+		"state armed, 1 lock(s) taken",
+	}
+	if !slices.Equal(emitted, want) {
+		t.Fatalf("cycle observed\n%q\nwant\n%q", emitted, want)
+	}
+}
@@ -0,0 +1,188 @@
+package inhibit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy decides what InhibitWithPolicy does with the actions and modes that a backend does not
+// support.
+type Policy int
+
+const (
+	// StrictFail refuses the lock when the backend does not support the mode or one of the
+	// actions. This is what Inhibit does.
+	StrictFail Policy = iota
+	// DropUnsupported takes the lock on the actions that the backend supports and drops the
+	// others. The lock is refused when the mode is not supported or no action is left.
+	DropUnsupported
+	// BestEffort is like DropUnsupported, but an unsupported mode is replaced by the closest mode
+	// of the backend, ModeBlockWeak for the portal. When no action is left, a lock that inhibits
+	// nothing is returned. The Translation reports what was changed.
+	BestEffort
+)
+
+func (p Policy) String() string {
+	switch p {
+	case StrictFail:
+		return "strict-fail"
+	case DropUnsupported:
+		return "drop-unsupported"
+	case BestEffort:
+		return "best-effort"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// Translation reports how a lock requested using InhibitWithPolicy was taken by the backend.
+type Translation struct {
+	// Inhibited are the actions that the lock inhibits.
+	Inhibited []What
+	// Dropped are the requested actions that the backend cannot inhibit in Mode.
+	Dropped []What
+	// Mode is the mode of the lock. It only differs from the requested mode with BestEffort.
+	Mode Mode
+}
+
+// backend identifies the backend a lock is translated for.
+type backend int
+
+const (
+	backendLogind backend = iota
+	backendPortal
+)
+
+// whatTranslation is how the backends express a What.
+type whatTranslation struct {
+	// logind is the name in the what argument of logind's Inhibit.
+	logind string
+	// logindDelay reports whether logind accepts the action in delay locks.
+	logindDelay bool
+	// portal is the flag of the portal's Inhibit, zero when the portal cannot inhibit it.
+	portal uint32
+}
+
+// whatTable translates What to the backends. What values that are not in the table are passed to
+// logind as they are, newer versions of logind add actions, but cannot be delayed or inhibited by
+// the portal.
+var whatTable = map[What]whatTranslation{
+	WhatHandleHibernateKey: {logind: "handle-hibernate-key"},
+	WhatHandleLidSwitch:    {logind: "handle-lid-switch"},
+	WhatHandlePowerKey:     {logind: "handle-power-key"},
+	WhatHandleSuspendKey:   {logind: "handle-suspend-key"},
+	WhatIdle:               {logind: "idle", portal: portalFlagIdle},
+	WhatShutdown:           {logind: "shutdown", logindDelay: true, portal: portalFlagLogout},
+	WhatSleep:              {logind: "sleep", logindDelay: true, portal: portalFlagSuspend},
+}
+
+// supportsMode reports whether the backend supports locks in mode, which must be valid.
+func (b backend) supportsMode(mode Mode) bool {
+	return b == backendLogind || mode == ModeBlockWeak
+}
+
+// supports reports whether the backend can inhibit w in mode.
+func (b backend) supports(mode Mode, w What) bool {
+	t := whatTable[w]
+	if b == backendPortal {
+		return t.portal != 0
+	}
+
+	return mode != ModeDelay || t.logindDelay
+}
+
+// unsupportedMode returns the error for a mode that the backend does not support.
+func (b backend) unsupportedMode(mode Mode) error {
+	return fmt.Errorf("%w: mode %s, only %s", ErrUnsupportedByPortal, mode, ModeBlockWeak)
+}
+
+// unsupported returns the error for an action that the backend cannot inhibit in mode.
+func (b backend) unsupported(mode Mode, w What) error {
+	if b == backendPortal {
+		return fmt.Errorf("%w: %s", ErrUnsupportedByPortal, w)
+	}
+
+	return fmt.Errorf(
+		"%w: %s cannot be delayed, only %s and %s can",
+		ErrInvalidArgument,
+		w,
+		WhatShutdown,
+		WhatSleep,
+	)
+}
+
+// translate validates the arguments and translates mode and what to the backend according to
+// policy.
+func translate(
+	b backend,
+	who string,
+	why string,
+	mode Mode,
+	policy Policy,
+	what []What,
+) (Translation, error) {
+	if err := validateInhibit(who, why, mode, what); err != nil {
+		return Translation{}, err
+	}
+	if policy < StrictFail || policy > BestEffort {
+		return Translation{}, fmt.Errorf("%w: policy %s", ErrInvalidArgument, policy)
+	}
+
+	t := Translation{Mode: mode}
+	if !b.supportsMode(mode) {
+		if policy != BestEffort {
+			return Translation{}, b.unsupportedMode(mode)
+		}
+		t.Mode = ModeBlockWeak
+	}
+
+	for _, w := range what {
+		if b.supports(t.Mode, w) {
+			t.Inhibited = append(t.Inhibited, w)
+		} else {
+			t.Dropped = append(t.Dropped, w)
+		}
+	}
+
+	switch {
+	case policy == StrictFail && len(t.Dropped) > 0:
+		return Translation{}, b.unsupported(t.Mode, t.Dropped[0])
+	case policy == DropUnsupported && len(t.Inhibited) == 0:
+		return Translation{}, fmt.Errorf(
+			"no action can be inhibited: %w",
+			b.unsupported(t.Mode, t.Dropped[0]),
+		)
+	}
+
+	return t, nil
+}
+
+// logindWhat returns the what argument of logind's Inhibit for the actions.
+func logindWhat(what []What) string {
+	names := make([]string, len(what))
+	for i, w := range what {
+		names[i] = whatTable[w].logind
+		if names[i] == "" {
+			names[i] = string(w)
+		}
+	}
+
+	return strings.Join(names, ":")
+}
+
+// portalFlags returns the flags argument of the portal's Inhibit for the actions.
+func portalFlags(what []What) uint32 {
+	var flags uint32
+	for _, w := range what {
+		flags |= whatTable[w].portal
+	}
+
+	return flags
+}
+
+// nopLock is returned by BestEffort when no action is left to inhibit.
+type nopLock struct{}
+
+func (nopLock) Close() error {
+	return nil
+}
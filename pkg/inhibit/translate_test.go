@@ -0,0 +1,124 @@
+package inhibit
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	// handle-reboot-key is an action of newer versions of logind that is not in the table
+	const whatRebootKey What = "handle-reboot-key"
+	allWhat := []What{
+		WhatHandleHibernateKey,
+		WhatHandleLidSwitch,
+		WhatHandlePowerKey,
+		WhatHandleSuspendKey,
+		WhatIdle,
+		WhatShutdown,
+		WhatSleep,
+		whatRebootKey,
+	}
+	backends := []struct {
+		name string
+		b    backend
+		// supported are the actions the backend can inhibit per mode, a missing mode is not
+		// supported.
+		supported map[Mode][]What
+		// fallback is the mode BestEffort uses for unsupported modes.
+		fallback Mode
+		// err is the error for unsupported modes and actions.
+		err error
+	}{
+		{
+			name: "logind",
+			b:    backendLogind,
+			supported: map[Mode][]What{
+				ModeBlock:     allWhat,
+				ModeBlockWeak: allWhat,
+				ModeDelay:     {WhatShutdown, WhatSleep},
+			},
+			err: ErrInvalidArgument,
+		},
+		{
+			name:      "portal",
+			b:         backendPortal,
+			supported: map[Mode][]What{ModeBlockWeak: {WhatIdle, WhatShutdown, WhatSleep}},
+			fallback:  ModeBlockWeak,
+			err:       ErrUnsupportedByPortal,
+		},
+	}
+
+	for _, backend := range backends {
+		for _, policy := range []Policy{StrictFail, DropUnsupported, BestEffort} {
+			for _, mode := range modes {
+				for _, w := range allWhat {
+					// Alone and together with an action every backend supports in every mode
+					for _, what := range [][]What{{w}, {WhatSleep, w}} {
+						name := fmt.Sprintf("%s/%s/%s/%v", backend.name, policy, mode, what)
+						t.Run(name, func(t *testing.T) {
+							want := Translation{Mode: mode}
+							supported, modeOK := backend.supported[mode]
+							if !modeOK && policy == BestEffort {
+								want.Mode = backend.fallback
+								supported, modeOK = backend.supported[backend.fallback]
+							}
+							for _, action := range what {
+								if slices.Contains(supported, action) {
+									want.Inhibited = append(want.Inhibited, action)
+								} else {
+									want.Dropped = append(want.Dropped, action)
+								}
+							}
+							wantErr := !modeOK ||
+								policy == StrictFail && len(want.Dropped) > 0 ||
+								policy == DropUnsupported && len(want.Inhibited) == 0
+
+							got, err := translate(backend.b, "agent", "backup", mode, policy, what)
+							if wantErr {
+								if !errors.Is(err, backend.err) {
+									t.Fatalf("translate() error = %v, want %v", err, backend.err)
+								}
+								return
+							}
+							if err != nil {
+								t.Fatalf("translate() error = %v", err)
+							}
+							if !slices.Equal(got.Inhibited, want.Inhibited) ||
+								!slices.Equal(got.Dropped, want.Dropped) ||
+								got.Mode != want.Mode {
+								t.Fatalf("translate() = %+v, want %+v", got, want)
+							}
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTranslateInvalid(t *testing.T) {
+	for _, b := range []backend{backendLogind, backendPortal} {
+		_, err := translate(b, "agent", "backup", ModeBlockWeak, Policy(3), []What{WhatSleep})
+		if !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("translate() with an unknown policy error = %v, want ErrInvalidArgument", err)
+		}
+		_, err = translate(b, "", "backup", ModeBlockWeak, BestEffort, []What{WhatSleep})
+		if !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("translate() without who error = %v, want ErrInvalidArgument", err)
+		}
+	}
+}
+
+func TestTranslateBackendArguments(t *testing.T) {
+	what := []What{WhatSleep, WhatIdle, WhatShutdown, WhatHandleLidSwitch, "handle-reboot-key"}
+	want := "sleep:idle:shutdown:handle-lid-switch:handle-reboot-key"
+	if got := logindWhat(what); got != want {
+		t.Errorf("logindWhat() = %q, want %q", got, want)
+	}
+	wantFlags := portalFlagSuspend | portalFlagIdle | portalFlagLogout
+	if got := portalFlags(what[:3]); got != wantFlags {
+		t.Errorf("portalFlags() = %d, want %d", got, wantFlags)
+	}
+}
@@ -0,0 +1,378 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/pkg/idle"
+	"github.com/MatthiasKunnen/system/pkg/inhibit"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// policyBuffer is the capacity of the channels the AutoLockPolicy registers.
+const policyBuffer = 16
+
+// ErrPolicyRunning is returned by AutoLockPolicy.Run when it is already running.
+var ErrPolicyRunning = errors.New("auto-lock policy is already running")
+
+// AutoLockInhibitor tells whether an inhibitor lock prevents locking. It is implemented by
+// *inhibit.Inhibitor.
+type AutoLockInhibitor interface {
+	Events(ctx context.Context, kinds ...inhibit.EventKind) (<-chan inhibit.Event, error)
+	BlockInhibited(ctx context.Context) ([]inhibit.What, error)
+}
+
+// AutoLockSession is the part of a Lock that an AutoLockPolicy uses. It is implemented by the
+// Lock returned by NewDbusSessionLock, use a type assertion to get it from the Lock.
+type AutoLockSession interface {
+	SetLocked(locked bool) error
+	AddLockSignal(c chan<- struct{}) error
+	RemoveLockSignal(c chan<- struct{}) error
+	AddLockedSignalWithState(c chan<- bool) error
+	RemoveLockedSignal(c chan<- bool) error
+}
+
+// AutoLockConfig configures an AutoLockPolicy, see NewAutoLockPolicy.
+type AutoLockConfig struct {
+	// Lock is the lock of the session, required. Its Lock signal locks immediately and its
+	// locked state tells when the session has been unlocked. SetLocked(true) is called after
+	// locking, the Lock must not be read-only.
+	Lock AutoLockSession
+
+	// Idle creates the idle notification, required. The caller dispatches the Controller, e.g.
+	// using Controller.Run.
+	Idle idle.Controller
+
+	// IdleThreshold is the time without input after which the session is idle, required.
+	IdleThreshold time.Duration
+
+	// Grace is the time between becoming idle and locking. Input during the grace period
+	// cancels locking, e.g. after dimming the screen as a warning. Zero locks as soon as the
+	// session is idle.
+	Grace time.Duration
+
+	// Inhibitor, if set, prevents locking while an inhibitor lock blocks inhibit.WhatIdle, e.g.
+	// the lock of a video player. The grace period starts over when the inhibitor lock is
+	// released while the session is still idle.
+	Inhibitor AutoLockInhibitor
+
+	// Action locks the session, e.g. by starting a screen locker, and returns once it is
+	// locked. Nil only calls SetLocked(true). Action is called on the goroutine of Run, the
+	// events that occur meanwhile are handled after it returns.
+	Action func(ctx context.Context) error
+
+	// OnStateChange, if set, is called with the new state on every change, on the goroutine of
+	// Run. It must not block.
+	OnStateChange func(state PolicyState)
+
+	// OnError, if set, is called with the errors that do not stop the policy, such as a failing
+	// Action, on the goroutine of Run. When OnError is nil, Run returns these errors instead.
+	OnError func(err error)
+}
+
+// PolicyState is the state of an AutoLockPolicy.
+type PolicyState int
+
+const (
+	// PolicyActive means the session is in use, or idle after it has been unlocked. Locking
+	// requires a new idle period.
+	PolicyActive PolicyState = iota
+	// PolicyGrace means the session is idle and will be locked when the grace period ends.
+	PolicyGrace
+	// PolicyInhibited means the session is idle but an inhibitor lock prevents locking.
+	PolicyInhibited
+	// PolicyLocking means Action is running.
+	PolicyLocking
+	// PolicyLocked means the session is locked, until the locked state of the Lock becomes
+	// false.
+	PolicyLocked
+)
+
+func (s PolicyState) String() string {
+	switch s {
+	case PolicyActive:
+		return "active"
+	case PolicyGrace:
+		return "grace"
+	case PolicyInhibited:
+		return "inhibited"
+	case PolicyLocking:
+		return "locking"
+	case PolicyLocked:
+		return "locked"
+	default:
+		return fmt.Sprintf("PolicyState(%d)", int(s))
+	}
+}
+
+// AutoLockPolicy locks the session after it has been idle, unless an inhibitor lock prevents
+// it, and immediately when the Lock signal is received:
+//   - The session becoming idle starts the grace period, or waits while inhibited. Input during
+//     either returns to PolicyActive.
+//   - An inhibitor lock taken during the grace period stops it, releasing the lock while still
+//     idle starts a new grace period.
+//   - The Lock signal locks in every state but PolicyLocking and PolicyLocked, even while
+//     inhibited.
+//   - Input that occurs while Action runs does not cancel locking.
+//   - Once locked, the policy waits until the locked state of the Lock becomes false, e.g.
+//     because the screen locker called SetLocked(false), and the session must become idle
+//     again before it is locked again. A session locked by someone else is followed the same
+//     way.
+type AutoLockPolicy struct {
+	cfg     AutoLockConfig
+	running atomic.Bool
+
+	mu    sync.Mutex
+	state PolicyState
+
+	// The following fields are only accessed by Run.
+	// idle is whether the session is idle according to the idle notification.
+	idle bool
+	// sawLocked is set when the locked state was true after the policy locked, so that a stale
+	// false from before locking does not count as unlocking.
+	sawLocked bool
+	timer     *time.Timer
+	// err is the first error reported while OnError is nil.
+	err error
+}
+
+// NewAutoLockPolicy creates an AutoLockPolicy, which does nothing until Run is called.
+func NewAutoLockPolicy(cfg AutoLockConfig) (*AutoLockPolicy, error) {
+	switch {
+	case cfg.Lock == nil:
+		return nil, errors.New("auto-lock policy requires a Lock")
+	case cfg.Idle == nil:
+		return nil, errors.New("auto-lock policy requires an idle Controller")
+	case cfg.IdleThreshold <= 0:
+		return nil, fmt.Errorf("auto-lock idle threshold must be positive, got %s", cfg.IdleThreshold)
+	case cfg.Grace < 0:
+		return nil, fmt.Errorf("auto-lock grace period must not be negative, got %s", cfg.Grace)
+	}
+
+	return &AutoLockPolicy{cfg: cfg}, nil
+}
+
+// State returns the current state of the policy. Safe to be called from any goroutine.
+func (p *AutoLockPolicy) State() PolicyState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state
+}
+
+// Run applies the policy until ctx is done, the idle Controller stops, or, when OnError is nil,
+// an error occurs. It returns respectively ctx.Err(), the error of the Controller, or the error.
+// The notification and channels registered by Run are removed before it returns. Returns
+// ErrPolicyRunning when Run is already running.
+func (p *AutoLockPolicy) Run(ctx context.Context) (err error) {
+	if !p.running.CompareAndSwap(false, true) {
+		return ErrPolicyRunning
+	}
+	defer p.running.Store(false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p.err = nil
+	p.idle = false
+	p.setState(PolicyActive)
+	defer p.stopTimer()
+
+	idleEvents := make(chan idle.Event, policyBuffer)
+	notification, err := p.cfg.Idle.AddNotification(&idle.CreateIdleNotification{
+		Duration: p.cfg.IdleThreshold,
+		Events:   idleEvents,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add idle notification: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, notification.Close())
+	}()
+
+	lockSignals := make(chan struct{}, policyBuffer)
+	if err := p.cfg.Lock.AddLockSignal(lockSignals); err != nil {
+		return fmt.Errorf("failed to register lock signal: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, p.cfg.Lock.RemoveLockSignal(lockSignals))
+	}()
+
+	lockedSignals := make(chan bool, policyBuffer)
+	if err := p.cfg.Lock.AddLockedSignalWithState(lockedSignals); err != nil {
+		return fmt.Errorf("failed to register locked signal: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, p.cfg.Lock.RemoveLockedSignal(lockedSignals))
+	}()
+
+	var inhibitorEvents <-chan inhibit.Event
+	if p.cfg.Inhibitor != nil {
+		inhibitorEvents, err = p.cfg.Inhibitor.Events(ctx, inhibit.EventInhibitorsChanged)
+		if err != nil {
+			return fmt.Errorf("failed to follow inhibitor locks: %w", err)
+		}
+	}
+
+	for p.err == nil {
+		var timerC <-chan time.Time
+		if p.timer != nil {
+			timerC = p.timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.cfg.Idle.Done():
+			return p.cfg.Idle.Err()
+		case event := <-idleEvents:
+			p.handleIdle(ctx, event.Kind)
+		case <-lockSignals:
+			if state := p.State(); state != PolicyLocking && state != PolicyLocked {
+				p.lock(ctx)
+			}
+		case locked := <-lockedSignals:
+			p.handleLocked(locked)
+		case _, ok := <-inhibitorEvents:
+			if !ok {
+				// Closed when the Inhibitor is closed, keep going without it
+				inhibitorEvents = nil
+				continue
+			}
+			if state := p.State(); state == PolicyGrace || state == PolicyInhibited {
+				p.countdown(ctx)
+			}
+		case <-timerC:
+			p.timer = nil
+			if p.State() == PolicyGrace {
+				p.lock(ctx)
+			}
+		}
+	}
+
+	return p.err
+}
+
+func (p *AutoLockPolicy) handleIdle(ctx context.Context, kind idle.EventKind) {
+	switch kind {
+	case idle.EventIdle:
+		p.idle = true
+		if p.State() == PolicyActive {
+			p.countdown(ctx)
+		}
+	case idle.EventResume:
+		p.idle = false
+		if state := p.State(); state == PolicyGrace || state == PolicyInhibited {
+			p.stopTimer()
+			p.setState(PolicyActive)
+		}
+	}
+}
+
+func (p *AutoLockPolicy) handleLocked(locked bool) {
+	if locked {
+		p.sawLocked = true
+		switch p.State() {
+		case PolicyActive, PolicyGrace, PolicyInhibited:
+			// Locked by someone else
+			p.stopTimer()
+			p.setState(PolicyLocked)
+		}
+		return
+	}
+
+	if p.State() == PolicyLocked && p.sawLocked {
+		p.setState(PolicyActive)
+	}
+}
+
+// countdown starts the grace period, or waits while an inhibitor lock prevents locking. A grace
+// period that is already running continues.
+func (p *AutoLockPolicy) countdown(ctx context.Context) {
+	if p.inhibited(ctx) {
+		p.stopTimer()
+		p.setState(PolicyInhibited)
+		return
+	}
+
+	if p.State() == PolicyGrace {
+		return
+	}
+	if p.cfg.Grace == 0 {
+		p.lock(ctx)
+		return
+	}
+	p.setState(PolicyGrace)
+	p.timer = time.NewTimer(p.cfg.Grace)
+}
+
+// inhibited reports whether an inhibitor lock blocks idle. When that cannot be determined, the
+// error is reported and locking is not prevented.
+func (p *AutoLockPolicy) inhibited(ctx context.Context) bool {
+	if p.cfg.Inhibitor == nil {
+		return false
+	}
+
+	what, err := p.cfg.Inhibitor.BlockInhibited(ctx)
+	if err != nil {
+		p.report(fmt.Errorf("failed to get inhibitor locks: %w", err))
+		return false
+	}
+
+	return slices.Contains(what, inhibit.WhatIdle)
+}
+
+// lock runs the Action and sets the locked state. When locking fails, the policy returns to
+// PolicyActive.
+func (p *AutoLockPolicy) lock(ctx context.Context) {
+	p.stopTimer()
+	p.setState(PolicyLocking)
+	p.sawLocked = false
+
+	if p.cfg.Action != nil {
+		if err := p.cfg.Action(ctx); err != nil {
+			p.report(fmt.Errorf("auto-lock action failed: %w", err))
+			p.setState(PolicyActive)
+			return
+		}
+	}
+
+	if err := p.cfg.Lock.SetLocked(true); err != nil {
+		p.report(fmt.Errorf("failed to set locked state: %w", err))
+		if p.cfg.Action == nil {
+			p.setState(PolicyActive)
+			return
+		}
+	}
+	p.setState(PolicyLocked)
+}
+
+func (p *AutoLockPolicy) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+func (p *AutoLockPolicy) setState(state PolicyState) {
+	p.mu.Lock()
+	changed := p.state != state
+	p.state = state
+	p.mu.Unlock()
+
+	if changed && p.cfg.OnStateChange != nil {
+		p.cfg.OnStateChange(state)
+	}
+}
+
+func (p *AutoLockPolicy) report(err error) {
+	if p.cfg.OnError != nil {
+		p.cfg.OnError(err)
+		return
+	}
+	if p.err == nil {
+		p.err = err
+	}
+}
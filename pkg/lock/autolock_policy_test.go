@@ -0,0 +1,364 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/MatthiasKunnen/system/pkg/idle"
+	"github.com/MatthiasKunnen/system/pkg/inhibit"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIdleController implements the part of idle.Controller used by AutoLockPolicy. Events are
+// sent using emit.
+type fakeIdleController struct {
+	idle.Controller
+	done chan struct{}
+
+	mu    sync.Mutex
+	input *idle.CreateIdleNotification
+}
+
+type fakeIdleNotification struct {
+	idle.Notification
+	controller *fakeIdleController
+}
+
+func (c *fakeIdleController) AddNotification(input *idle.CreateIdleNotification) (idle.Notification, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.input = input
+
+	return &fakeIdleNotification{controller: c}, nil
+}
+
+func (c *fakeIdleController) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *fakeIdleController) Err() error {
+	return idle.ErrControllerClosed
+}
+
+func (n *fakeIdleNotification) Close() error {
+	n.controller.mu.Lock()
+	defer n.controller.mu.Unlock()
+	n.controller.input = nil
+
+	return nil
+}
+
+func (c *fakeIdleController) emit(t *testing.T, kind idle.EventKind) {
+	t.Helper()
+
+	c.mu.Lock()
+	input := c.input
+	c.mu.Unlock()
+	if input == nil {
+		t.Fatalf("no idle notification to emit %s on", kind)
+	}
+	input.Events <- idle.Event{Kind: kind, Time: time.Now(), Duration: input.Duration}
+}
+
+// fakeAutoLockInhibitor implements AutoLockInhibitor. set changes the blocked actions and sends
+// EventInhibitorsChanged.
+type fakeAutoLockInhibitor struct {
+	events chan inhibit.Event
+
+	mu      sync.Mutex
+	blocked []inhibit.What
+}
+
+func (f *fakeAutoLockInhibitor) Events(ctx context.Context, kinds ...inhibit.EventKind) (<-chan inhibit.Event, error) {
+	return f.events, nil
+}
+
+func (f *fakeAutoLockInhibitor) BlockInhibited(ctx context.Context) ([]inhibit.What, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.blocked, nil
+}
+
+func (f *fakeAutoLockInhibitor) set(blocked ...inhibit.What) {
+	f.mu.Lock()
+	f.blocked = blocked
+	f.mu.Unlock()
+
+	f.events <- inhibit.Event{Kind: inhibit.EventInhibitorsChanged, Time: time.Now()}
+}
+
+type policyTest struct {
+	dc        *dbusCon
+	logind    *fakeLogin1
+	idle      *fakeIdleController
+	inhibitor *fakeAutoLockInhibitor
+	policy    *AutoLockPolicy
+	states    chan PolicyState
+	result    chan error
+}
+
+// startPolicy runs an AutoLockPolicy on a fake Lock, idle Controller, and Inhibitor. The
+// Lock, Idle, Inhibitor, and OnStateChange fields of cfg are set by startPolicy.
+func startPolicy(t *testing.T, cfg AutoLockConfig) *policyTest {
+	t.Helper()
+
+	pt := &policyTest{
+		idle:      &fakeIdleController{done: make(chan struct{})},
+		inhibitor: &fakeAutoLockInhibitor{events: make(chan inhibit.Event, 4)},
+		states:    make(chan PolicyState, 16),
+		result:    make(chan error, 1),
+	}
+	pt.dc, pt.logind = newTestLockWithLogin1(t)
+	cfg.Lock = pt.dc
+	cfg.Idle = pt.idle
+	cfg.Inhibitor = pt.inhibitor
+	cfg.OnStateChange = func(state PolicyState) {
+		pt.states <- state
+	}
+	if cfg.IdleThreshold == 0 {
+		cfg.IdleThreshold = time.Minute
+	}
+
+	var err error
+	pt.policy, err = NewAutoLockPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewAutoLockPolicy() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		pt.result <- pt.policy.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-pt.result
+	})
+
+	// Wait for the registrations of Run
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		pt.dc.muSignals.Lock()
+		registered := len(pt.dc.lockedHintSignals) > 0
+		pt.dc.muSignals.Unlock()
+		pt.idle.mu.Lock()
+		registered = registered && pt.idle.input != nil
+		pt.idle.mu.Unlock()
+		if registered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not register its channels")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return pt
+}
+
+// expect waits for the next states of the policy.
+func (pt *policyTest) expect(t *testing.T, states ...PolicyState) {
+	t.Helper()
+
+	for _, want := range states {
+		select {
+		case got := <-pt.states:
+			if got != want {
+				t.Fatalf("state = %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for state %s", want)
+		}
+	}
+}
+
+// expectNone fails if the state changes within a short while.
+func (pt *policyTest) expectNone(t *testing.T) {
+	t.Helper()
+
+	select {
+	case got := <-pt.states:
+		t.Fatalf("unexpected state %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (pt *policyTest) emitLockSignal(t *testing.T) {
+	t.Helper()
+
+	err := pt.logind.conn.Emit(pt.logind.sessions[0].path, login1.SessionInterface+".Lock")
+	if err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+}
+
+func TestAutoLockPolicy(t *testing.T) {
+	pt := startPolicy(t, AutoLockConfig{Grace: 20 * time.Millisecond})
+
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyGrace, PolicyLocking, PolicyLocked)
+	if locked, err := pt.dc.GetLocked(); err != nil || !locked {
+		t.Fatalf("GetLocked() = %t, %v, want locked", locked, err)
+	}
+
+	// Input while locked, e.g. typing the password, changes nothing
+	pt.idle.emit(t, idle.EventResume)
+	pt.expectNone(t)
+
+	// The screen locker unlocks
+	if err := pt.dc.SetLocked(false); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+	pt.expect(t, PolicyActive)
+}
+
+func TestAutoLockPolicyResumeDuringGrace(t *testing.T) {
+	pt := startPolicy(t, AutoLockConfig{Grace: time.Hour})
+
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyGrace)
+	pt.idle.emit(t, idle.EventResume)
+	pt.expect(t, PolicyActive)
+	pt.expectNone(t)
+	if locked, _ := pt.dc.GetLocked(); locked {
+		t.Fatalf("locked after input during the grace period")
+	}
+}
+
+func TestAutoLockPolicyLockSignal(t *testing.T) {
+	t.Run("during grace period", func(t *testing.T) {
+		pt := startPolicy(t, AutoLockConfig{Grace: time.Hour})
+
+		pt.idle.emit(t, idle.EventIdle)
+		pt.expect(t, PolicyGrace)
+		pt.emitLockSignal(t)
+		pt.expect(t, PolicyLocking, PolicyLocked)
+	})
+
+	t.Run("while active", func(t *testing.T) {
+		pt := startPolicy(t, AutoLockConfig{Grace: time.Hour})
+
+		pt.emitLockSignal(t)
+		pt.expect(t, PolicyLocking, PolicyLocked)
+	})
+
+	t.Run("while inhibited", func(t *testing.T) {
+		pt := startPolicy(t, AutoLockConfig{Grace: time.Hour})
+		pt.inhibitor.set(inhibit.WhatIdle)
+
+		pt.idle.emit(t, idle.EventIdle)
+		pt.expect(t, PolicyInhibited)
+		pt.emitLockSignal(t)
+		pt.expect(t, PolicyLocking, PolicyLocked)
+	})
+}
+
+func TestAutoLockPolicyInhibitor(t *testing.T) {
+	pt := startPolicy(t, AutoLockConfig{Grace: time.Hour})
+
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyGrace)
+
+	// Other actions being blocked do not matter
+	pt.inhibitor.set(inhibit.WhatSleep)
+	pt.expectNone(t)
+
+	// An inhibitor lock taken mid-countdown stops it, releasing it starts over
+	pt.inhibitor.set(inhibit.WhatSleep, inhibit.WhatIdle)
+	pt.expect(t, PolicyInhibited)
+	pt.inhibitor.set()
+	pt.expect(t, PolicyGrace)
+
+	// Input while inhibited
+	pt.inhibitor.set(inhibit.WhatIdle)
+	pt.expect(t, PolicyInhibited)
+	pt.idle.emit(t, idle.EventResume)
+	pt.expect(t, PolicyActive)
+
+	// Idle while inhibited
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyInhibited)
+	pt.inhibitor.set()
+	pt.expect(t, PolicyGrace)
+}
+
+func TestAutoLockPolicyResumeRacesAction(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pt := startPolicy(t, AutoLockConfig{
+		Action: func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		},
+	})
+
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyLocking)
+	<-started
+	pt.idle.emit(t, idle.EventResume)
+	close(release)
+	pt.expect(t, PolicyLocked)
+
+	// The stale unlocked state from before locking does not unlock
+	pt.expectNone(t)
+	if err := pt.dc.SetLocked(false); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+	pt.expect(t, PolicyActive)
+}
+
+func TestAutoLockPolicyLockedElsewhere(t *testing.T) {
+	pt := startPolicy(t, AutoLockConfig{Grace: time.Hour})
+
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyGrace)
+	pt.logind.sessions[0].setProperty("LockedHint", true)
+	pt.expect(t, PolicyLocked)
+	pt.logind.sessions[0].setProperty("LockedHint", false)
+	pt.expect(t, PolicyActive)
+}
+
+func TestAutoLockPolicyActionError(t *testing.T) {
+	errLocker := errors.New("locker not found")
+	pt := startPolicy(t, AutoLockConfig{
+		Action: func(ctx context.Context) error {
+			return errLocker
+		},
+	})
+
+	pt.idle.emit(t, idle.EventIdle)
+	pt.expect(t, PolicyLocking, PolicyActive)
+	select {
+	case err := <-pt.result:
+		pt.result <- err
+		if !errors.Is(err, errLocker) {
+			t.Fatalf("Run() error = %v, want the error of Action", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return the error of Action")
+	}
+	if locked, _ := pt.dc.GetLocked(); locked {
+		t.Fatalf("locked after Action failed")
+	}
+}
+
+func TestNewAutoLockPolicyInvalid(t *testing.T) {
+	dc, _ := newTestLock(t)
+	controller := &fakeIdleController{}
+	tests := map[string]AutoLockConfig{
+		"no lock":        {Idle: controller, IdleThreshold: time.Minute},
+		"no controller":  {Lock: dc, IdleThreshold: time.Minute},
+		"no threshold":   {Lock: dc, Idle: controller},
+		"negative grace": {Lock: dc, Idle: controller, IdleThreshold: time.Minute, Grace: -time.Second},
+	}
+
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewAutoLockPolicy(cfg); err == nil {
+				t.Fatal("NewAutoLockPolicy() succeeded")
+			}
+		})
+	}
+}
@@ -3,113 +3,421 @@ package lock
 import (
 	"errors"
 	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
 	"github.com/godbus/dbus/v5"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned when logind does not list the requested session.
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrMalformedSessionList is returned when logind's ListSessions result cannot be parsed.
+	ErrMalformedSessionList = errors.New("malformed ListSessions result")
+
+	// ErrNoVT is returned by VT related methods when the session is not running on a virtual
+	// terminal, e.g. on seats without TTY support.
+	ErrNoVT = errors.New("session has no virtual terminal")
+
+	// ErrNotRegistered is returned when replacing a channel that is not registered.
+	ErrNotRegistered = errors.New("channel is not registered")
+
+	// ErrSessionGone is returned when the session has been removed by logind.
+	ErrSessionGone = errors.New("session is gone")
+
+	// ErrLogindUnavailable is returned when logind did not answer, not even after retrying, e.g.
+	// because it did not come back after a restart. See WithRetry.
+	ErrLogindUnavailable = errors.New("logind is unavailable")
+
+	// ErrReadOnly is returned by methods that change the session when the Lock was created with
+	// WithReadOnly.
+	ErrReadOnly = errors.New("lock is read-only")
 )
 
 type dbusCon struct {
-	conn               *dbus.Conn
+	login1        *login1.Conn
+	sessionId     string
+	readOnly      bool
+	looseMatching bool
+	retryAttempts int
+	retryDelay    time.Duration
+	recreateGrace time.Duration
+	// hintOwnership is set by WithHintOwnership, the reassert fields by WithHintReassert.
+	hintOwnership    bool
+	reassertInterval time.Duration
+	reassertAttempts int
+	// coalesceWindow is set by WithLockSignalCoalescing.
+	coalesceWindow time.Duration
+
+	muHint        sync.Mutex
+	hint          hintState
+	reassertTimer *time.Timer
+
+	muSession          sync.Mutex
 	loginSessionObject dbus.BusObject
-	muSignals          sync.Mutex
-	closeSignalHandler chan struct{}
+	// recreateTimer is running between the removal of the session and the end of the grace
+	// period of WithRecreateGrace.
+	recreateTimer *time.Timer
+
+	muSignals sync.Mutex
 
 	lockSignals       map[chan<- struct{}]struct{}
 	lockedHintSignals map[chan<- bool]struct{}
-	unlockSignals     map[chan<- struct{}]struct{}
+	// pendingLockedState holds the changes for the channels of AddLockedSignalWithState whose
+	// current state is still being read, so that they are delivered after it.
+	pendingLockedState map[chan<- bool]*pendingState
+	unlockSignals      map[chan<- struct{}]struct{}
+	vtSignals          map[chan<- uint32]struct{}
+	stateSignals       map[chan<- SessionState]struct{}
+	lockStateSignals   map[chan<- LockEvent]struct{}
+	// hintConflictSignals are notified of changes of LockedHint by other programs.
+	hintConflictSignals map[chan<- HintConflict]struct{}
+	// lastLockEvent is the last event delivered to lockStateSignals, used for de-duplication.
+	lastLockEvent LockEvent
+	coalesce      coalesceState
+
+	sessionRemovedSignals      map[chan<- struct{}]struct{}
+	sessionRemovedSubscription *login1.Subscription
+	sessionNewSubscription     *login1.Subscription
+	sessionRecreatedSignals    map[chan<- struct{}]struct{}
+	// sessionRemovedNotified is set once sessionRemovedSignals have been notified.
+	sessionRemovedNotified bool
+	// gone is set once the session has been removed.
+	gone atomic.Bool
+
+	lockSubscription              *login1.Subscription
+	propertiesChangedSubscription *login1.Subscription
+	unlockSubscription            *login1.Subscription
+
+	// seatObject is the seat of the session, set when seatSubscription is created.
+	seatObject       dbus.BusObject
+	seatSubscription *login1.Subscription
+
+	// muProperties serializes the delivery of the session properties, so that a value read for
+	// an invalidated property is never delivered after a newer value, see fetchInvalidated.
+	muProperties sync.Mutex
+	// propertyChanges counts the values of every property delivered from the body of a
+	// PropertiesChanged signal.
+	propertyChanges map[string]uint64
+
+	muOffload  sync.Mutex
+	offloaded  []func()
+	offloading bool
+
+	// closed is closed by Close.
+	closed chan struct{}
+}
+
+type options struct {
+	conn             *dbus.Conn
+	readOnly         bool
+	looseMatching    bool
+	retryAttempts    int
+	retryDelay       time.Duration
+	recreateGrace    time.Duration
+	hintOwnership    bool
+	reassertInterval time.Duration
+	reassertAttempts int
+	coalesceWindow   time.Duration
+}
+
+// Option configures the Lock, see NewDbusSessionLock.
+type Option func(o *options)
+
+// WithConn makes the Lock use conn instead of the system bus connection shared by the session
+// locks and Inhibitors of the process. The Locks and Inhibitors using the same conn share its
+// match rules, which are reference counted, so closing one of them never affects the signals of
+// the others. conn is not closed when the Lock is closed.
+func WithConn(conn *dbus.Conn) Option {
+	return func(o *options) {
+		o.conn = conn
+	}
+}
 
-	lockSignalActive        bool
-	propertiesChangedActive bool
-	unlockSignalActive      bool
+// WithReadOnly makes SetLocked return ErrReadOnly without calling logind. Reading the state and
+// receiving signals keeps working. Creating the Lock only reads from logind, with or without
+// this option, so a read-only Lock never changes the session.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithLooseMatching omits the sender from the match rules of the signals, for system buses that
+// reject match rules with a sender or never deliver signals matched by a well-known name.
+// Signals are instead checked to come from the current owner of org.freedesktop.login1.
+func WithLooseMatching() Option {
+	return func(o *options) {
+		o.looseMatching = true
+	}
+}
+
+// WithRetry sets how GetLocked and SetLocked deal with logind restarting, during which the
+// session object briefly disappears or logind does not answer. The call is made at most attempts
+// times, waiting delay before the second attempt and doubling the wait after every attempt.
+// Defaults to 3 attempts and a delay of 250ms. An attempts value of 1 disables retrying.
+func WithRetry(attempts int, delay time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = attempts
+		o.retryDelay = delay
+	}
+}
+
+// WithRecreateGrace makes the Lock follow the session when logind removes it and creates a
+// session with the same ID within grace, e.g. during fast user switching. The signals are
+// subscribed again on the new session object and the channels registered with
+// AddSessionRecreatedSignal are notified. When no such session appears within grace, the session
+// is considered removed as usual, see AddSessionRemovedSignal.
+// Defaults to zero, the session is considered removed immediately.
+func WithRecreateGrace(grace time.Duration) Option {
+	return func(o *options) {
+		o.recreateGrace = grace
+	}
+}
+
+// WithHintOwnership makes the Lock remember the value it set using SetLocked. When another
+// program, e.g. a greeter and a locker that both set LockedHint, changes the hint away from that
+// value, the channels registered with AddHintConflictSignal are notified instead of the state
+// silently diverging. See WithHintReassert to set the value again.
+func WithHintOwnership() Option {
+	return func(o *options) {
+		o.hintOwnership = true
+	}
+}
+
+// WithHintReassert implies WithHintOwnership and sets the value of SetLocked again when another
+// program changes it, at most once per interval. When the hint is changed away again within
+// twice the interval, another program is assumed to be reasserting as well and the attempt
+// counts towards the same conflict. After attempts reasserts of one conflict, the Lock gives up
+// until the next SetLocked, so that two programs reasserting different values stop fighting
+// after a bounded number of changes, leaving the value of the program that gave up last.
+func WithHintReassert(interval time.Duration, attempts int) Option {
+	return func(o *options) {
+		o.hintOwnership = true
+		o.reassertInterval = interval
+		o.reassertAttempts = attempts
+	}
+}
+
+// WithLockSignalCoalescing suppresses repeated Lock signals of lockers that emit it while the
+// session is already locked. Once LockedHint=true has been observed, or SetLocked(true)
+// succeeded, and until an unlock is observed, at most one Lock signal per window is delivered to
+// the channels of AddLockSignal and AddLockStateSignal, the others are counted, see
+// SuppressedLockSignals. Without this option, every Lock signal is delivered.
+// Unlock signals and LockedHint changes are never suppressed.
+func WithLockSignalCoalescing(window time.Duration) Option {
+	return func(o *options) {
+		o.coalesceWindow = window
+	}
 }
 
 // NewDbusSessionLock creates and initializes a D-Bus [org.freedesktop.login1] implementation of the
 // Lock interface for the given session.
 //
+// The Lock also implements the optional interfaces of this package, use a type assertion to
+// access them:
+//   - LockedStateSignaler
+//   - VTWatcher
+//   - SignalReplacer
+//   - SessionRemovalNotifier
+//   - SessionStateWatcher
+//   - LockStateSignaler
+//   - SessionRecreationNotifier
+//   - HintConflictNotifier
+//   - LockSignalCoalescer
+//   - LockIterator
+//   - SelfTester
+//
 // sessionId is the ID of the session. Usually set to the XDG_SESSION_ID env var.
 //
+// Unless WithConn is given, all session locks and inhibitors of the process share a single
+// system bus connection.
+//
 // [org.freedesktop.login1]: https://www.freedesktop.org/software/systemd/man/latest/org.freedesktop.login1.html
-func NewDbusSessionLock(sessionId string) (Lock, error) {
+func NewDbusSessionLock(sessionId string, opts ...Option) (Lock, error) {
 	if sessionId == "" {
 		return nil, errors.New("sessionId is empty")
 	}
 
-	conn, err := dbus.ConnectSystemBus()
+	o := options{
+		retryAttempts: defaultRetryAttempts,
+		retryDelay:    defaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var conn *login1.Conn
+	if o.conn != nil {
+		conn = login1.New(o.conn)
+	} else {
+		var err error
+		conn, err = login1.Shared()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dc, err := newDbusCon(conn, sessionId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
-	}
-	result := &dbusCon{
-		conn:                    conn,
-		loginSessionObject:      conn.Object("org.freedesktop.login1", "/org/freedesktop/login1"),
-		lockSignals:             make(map[chan<- struct{}]struct{}),
-		lockSignalActive:        false,
-		unlockSignals:           make(map[chan<- struct{}]struct{}),
-		unlockSignalActive:      false,
-		lockedHintSignals:       make(map[chan<- bool]struct{}),
-		propertiesChangedActive: false,
-		closeSignalHandler:      make(chan struct{}),
+		return nil, errors.Join(err, conn.Close())
+	}
+	dc.readOnly = o.readOnly
+	dc.looseMatching = o.looseMatching
+	dc.retryAttempts = o.retryAttempts
+	dc.retryDelay = o.retryDelay
+	dc.hintOwnership = o.hintOwnership
+	dc.reassertInterval = o.reassertInterval
+	dc.reassertAttempts = max(o.reassertAttempts, 0)
+	dc.coalesceWindow = max(o.coalesceWindow, 0)
+	if o.recreateGrace > 0 {
+		if err := dc.followRecreate(o.recreateGrace); err != nil {
+			return nil, errors.Join(err, dc.Close())
+		}
 	}
+
+	return dc, nil
+}
+
+// newDbusCon creates the Lock for the given session using the given logind connection.
+func newDbusCon(conn *login1.Conn, sessionId string) (*dbusCon, error) {
 	var sessions []interface{}
-	err = conn.Object("org.freedesktop.login1", "/org/freedesktop/login1").
-		Call("org.freedesktop.login1.Manager.ListSessions", 0).
+	err := conn.Manager().
+		Call(login1.ManagerInterface+".ListSessions", 0).
 		Store(&sessions)
 	if err != nil {
 		return nil, err
 	}
 
-	for i, sessionInt := range sessions {
-		session, ok := sessionInt.([]interface{})
-		if !ok {
-			return nil, fmt.Errorf("session %d is not an array of interface: %+v", i, session)
-		}
-		currentSessionId, ok := session[0].(string)
+	sessionPath, err := findSessionPath(sessions, sessionId)
+	if err != nil {
+		return nil, err
+	}
 
-		if !ok {
-			return nil, fmt.Errorf("session %d[0] is not a string: %+v", i, session[0])
-		}
+	return &dbusCon{
+		login1:             conn,
+		sessionId:          sessionId,
+		loginSessionObject: conn.Object(sessionPath),
+		retryAttempts:      defaultRetryAttempts,
+		retryDelay:         defaultRetryDelay,
+		lockSignals:        make(map[chan<- struct{}]struct{}),
+		unlockSignals:      make(map[chan<- struct{}]struct{}),
+		lockedHintSignals:  make(map[chan<- bool]struct{}),
+		pendingLockedState: make(map[chan<- bool]*pendingState),
+		vtSignals:          make(map[chan<- uint32]struct{}),
+		stateSignals:       make(map[chan<- SessionState]struct{}),
+		lockStateSignals:   make(map[chan<- LockEvent]struct{}),
+
+		hintConflictSignals: make(map[chan<- HintConflict]struct{}),
+
+		sessionRemovedSignals:   make(map[chan<- struct{}]struct{}),
+		sessionRecreatedSignals: make(map[chan<- struct{}]struct{}),
+
+		propertyChanges: make(map[string]uint64),
+
+		closed: make(chan struct{}),
+	}, nil
+}
 
-		if currentSessionId == sessionId {
-			sessionPath, ok := session[4].(dbus.ObjectPath)
-			if !ok {
-				return nil, fmt.Errorf("session %d[4] is not an ObjectPath: %+v", i, session[4])
-			}
+// sessionListEntry is an entry of the ListSessions result, a(susso).
+// logind might add columns in the future, these are ignored.
+type sessionListEntry struct {
+	ID   string
+	UID  uint32
+	User string
+	Seat string
+	Path dbus.ObjectPath
+}
 
-			result.loginSessionObject = conn.Object("org.freedesktop.login1", sessionPath)
-			break
-		}
+// parseSessionListEntry decodes the known leading columns of a ListSessions entry.
+func parseSessionListEntry(v interface{}) (sessionListEntry, error) {
+	var entry sessionListEntry
+	fields, ok := v.([]interface{})
+	if !ok {
+		return entry, fmt.Errorf("entry is %T, not a struct", v)
 	}
 
-	if result.loginSessionObject == nil {
-		return nil, fmt.Errorf("failed to find session object")
+	if len(fields) < 5 {
+		return entry, fmt.Errorf("entry has %d fields, expected at least 5", len(fields))
 	}
 
-	c := make(chan *dbus.Signal)
-	conn.Signal(c)
-	go func() {
-		for {
-			select {
-			case <-result.closeSignalHandler:
-				conn.RemoveSignal(c)
-			case v := <-c:
-				result.handleIncomingSignal(v)
-			}
+	err := dbus.Store(fields[:5], &entry.ID, &entry.UID, &entry.User, &entry.Seat, &entry.Path)
+	if err != nil {
+		return entry, err
+	}
+
+	if !entry.Path.IsValid() {
+		return entry, fmt.Errorf("invalid object path %q", entry.Path)
+	}
+
+	return entry, nil
+}
+
+// findSessionPath returns the object path of the session with the given ID from the result of
+// ListSessions.
+func findSessionPath(sessions []interface{}, sessionId string) (dbus.ObjectPath, error) {
+	for i, session := range sessions {
+		entry, err := parseSessionListEntry(session)
+		if err != nil {
+			return "", fmt.Errorf("%w: session %d: %w", ErrMalformedSessionList, i, err)
+		}
+
+		if entry.ID == sessionId {
+			return entry.Path, nil
 		}
-	}()
+	}
 
-	return result, nil
+	return "", fmt.Errorf("%w: %s", ErrSessionNotFound, sessionId)
 }
 
 func (dc *dbusCon) SetLocked(locked bool) error {
-	err := dc.loginSessionObject.
-		Call("org.freedesktop.login1.Session.SetLockedHint", 0, locked).Err
+	if dc.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	restore := func() {}
+	if dc.hintOwnership {
+		if err := dc.subscribeHintOwnership(); err != nil {
+			return err
+		}
+		// Before the call, the change can be received before the call returns
+		restore = dc.ownHint(locked)
+	}
+
+	err := dc.retry(func() error {
+		return dc.session().
+			Call("org.freedesktop.login1.Session.SetLockedHint", 0, locked).Err
+	})
 	if err != nil {
+		restore()
 		return fmt.Errorf("could not set locked hint: %w", err)
 	}
 
+	dc.muSignals.Lock()
+	dc.coalesceLocked(locked)
+	dc.muSignals.Unlock()
+
 	return nil
 }
 
 func (dc *dbusCon) GetLocked() (bool, error) {
-	variant, err := dc.loginSessionObject.GetProperty("org.freedesktop.login1.Session.LockedHint")
+	if err := dc.checkSession(); err != nil {
+		return false, err
+	}
+
+	var variant dbus.Variant
+	err := dc.retry(func() error {
+		var err error
+		variant, err = dc.session().GetProperty(login1.SessionInterface + ".LockedHint")
+		return err
+	})
 	if err != nil {
 		return false, fmt.Errorf("could not get locked hint: %w", err)
 	}
@@ -127,22 +435,24 @@ func (dc *dbusCon) AddLockSignal(c chan<- struct{}) error {
 		return errors.New("AddLockSignal: channel cannot be nil")
 	}
 
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
 	dc.muSignals.Lock()
 	defer dc.muSignals.Unlock()
-	dc.lockSignals[c] = struct{}{}
 
-	if !dc.lockSignalActive {
-		if err := dc.conn.AddMatchSignal(
-			dbus.WithMatchObjectPath(dc.loginSessionObject.Path()),
-			dbus.WithMatchInterface("org.freedesktop.login1.Session"),
-			dbus.WithMatchSender("org.freedesktop.login1"),
-			dbus.WithMatchMember("Lock"),
-		); err != nil {
-			return fmt.Errorf("failed to register Dbus Lock signal: %w", err)
+	err := dc.subscribe(&dc.lockSubscription, login1.SessionInterface, "Lock")
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus Lock signal: %w", err)
+	}
+	if dc.coalesceWindow > 0 {
+		// LockedHint tells whether the session is locked
+		if err := dc.subscribePropertiesChanged(); err != nil {
+			return errors.Join(err, dc.unsubscribeLockIfUnused())
 		}
-
-		dc.lockSignalActive = true
 	}
+	dc.lockSignals[c] = struct{}{}
 
 	return nil
 }
@@ -157,57 +467,47 @@ func (dc *dbusCon) RemoveLockSignal(c chan<- struct{}) error {
 
 	delete(dc.lockSignals, c)
 
-	if len(dc.lockSignals) == 0 {
-		if err := dc.removeLockSignal(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return errors.Join(dc.unsubscribeLockIfUnused(), dc.unsubscribePropertiesChangedIfUnused())
 }
 
-// removeLockSignal removes the Lock signal.
+// unsubscribeLockIfUnused unsubscribes from the Lock signal when no channel needs it anymore.
 // Holding the muSignals mutex is required.
-func (dc *dbusCon) removeLockSignal() error {
-	if !dc.lockSignalActive {
+func (dc *dbusCon) unsubscribeLockIfUnused() error {
+	if len(dc.lockSignals) > 0 || len(dc.lockStateSignals) > 0 {
 		return nil
 	}
 
-	if err := dc.conn.RemoveMatchSignal(
-		dbus.WithMatchObjectPath(dc.loginSessionObject.Path()),
-		dbus.WithMatchInterface("org.freedesktop.login1.Session"),
-		dbus.WithMatchSender("org.freedesktop.login1"),
-		dbus.WithMatchMember("Lock"),
-	); err != nil {
+	if err := unsubscribe(&dc.lockSubscription); err != nil {
 		return fmt.Errorf("failed to remove Dbus Lock signal: %w", err)
 	}
 
-	dc.lockSignalActive = false
-
 	return nil
 }
 
+func (dc *dbusCon) ReplaceLockSignal(old chan<- struct{}, new chan<- struct{}) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.lockSignals, old, new)
+}
+
 func (dc *dbusCon) AddUnlockSignal(c chan<- struct{}) error {
 	if c == nil {
 		return errors.New("AddUnlockSignal: channel cannot be nil")
 	}
 
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
 	dc.muSignals.Lock()
 	defer dc.muSignals.Unlock()
-	dc.unlockSignals[c] = struct{}{}
-
-	if !dc.unlockSignalActive {
-		if err := dc.conn.AddMatchSignal(
-			dbus.WithMatchObjectPath(dc.loginSessionObject.Path()),
-			dbus.WithMatchInterface("org.freedesktop.login1.Session"),
-			dbus.WithMatchSender("org.freedesktop.login1"),
-			dbus.WithMatchMember("Unlock"),
-		); err != nil {
-			return fmt.Errorf("failed to register Dbus Unlock signal: %w", err)
-		}
 
-		dc.unlockSignalActive = true
+	err := dc.subscribe(&dc.unlockSubscription, login1.SessionInterface, "Unlock")
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus Unlock signal: %w", err)
 	}
+	dc.unlockSignals[c] = struct{}{}
 
 	return nil
 }
@@ -222,56 +522,141 @@ func (dc *dbusCon) RemoveUnlockSignal(c chan<- struct{}) error {
 
 	delete(dc.unlockSignals, c)
 
-	if len(dc.unlockSignals) == 0 {
-		if err := dc.removeUnlockSignal(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return dc.unsubscribeUnlockIfUnused()
 }
 
-// removeUnlockSignal Removes the Unlock signal if it was registered.
+// unsubscribeUnlockIfUnused unsubscribes from the Unlock signal when no channel needs it
+// anymore.
 // Holding the muSignals mutex is required.
-func (dc *dbusCon) removeUnlockSignal() error {
-	if !dc.unlockSignalActive {
+func (dc *dbusCon) unsubscribeUnlockIfUnused() error {
+	if len(dc.unlockSignals) > 0 || len(dc.lockStateSignals) > 0 {
 		return nil
 	}
 
-	if err := dc.conn.RemoveMatchSignal(
-		dbus.WithMatchObjectPath(dc.loginSessionObject.Path()),
-		dbus.WithMatchInterface("org.freedesktop.login1.Session"),
-		dbus.WithMatchSender("org.freedesktop.login1"),
-		dbus.WithMatchMember("Unlock"),
-	); err != nil {
+	if err := unsubscribe(&dc.unlockSubscription); err != nil {
 		return fmt.Errorf("failed to remove Dbus Unlock signal: %w", err)
 	}
 
-	dc.unlockSignalActive = false
-
 	return nil
 }
 
+func (dc *dbusCon) ReplaceUnlockSignal(old chan<- struct{}, new chan<- struct{}) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.unlockSignals, old, new)
+}
+
 func (dc *dbusCon) AddLockedSignal(c chan<- bool) error {
 	if c == nil {
 		return errors.New("AddLockedSignal: channel cannot be nil")
 	}
 
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
 	dc.muSignals.Lock()
 	defer dc.muSignals.Unlock()
+
+	if err := dc.subscribePropertiesChanged(); err != nil {
+		return err
+	}
+	dc.lockedHintSignals[c] = struct{}{}
+
+	return nil
+}
+
+func (dc *dbusCon) AddLockedSignalWithState(c chan<- bool) error {
+	if c == nil {
+		return errors.New("AddLockedSignalWithState: channel cannot be nil")
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	// The channel is registered before reading the state so that no change can occur
+	// unnoticed. The changes received while reading are held back until the current state has
+	// been delivered. muSignals is not held while reading, GetLocked can be retried for a while
+	// and the signal handlers, which need muSignals, run on the dispatch goroutine shared by
+	// every Lock and Inhibitor on the bus.
+	pending := &pendingState{c: c}
+	dc.muSignals.Lock()
+	if err := dc.subscribePropertiesChanged(); err != nil {
+		dc.muSignals.Unlock()
+		return err
+	}
 	dc.lockedHintSignals[c] = struct{}{}
+	dc.pendingLockedState[c] = pending
+	dc.muSignals.Unlock()
+
+	locked, err := dc.GetLocked()
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	// The channel might have been replaced, removed, or unregistered by Close meanwhile
+	c = pending.c
+	if dc.pendingLockedState[c] != pending {
+		return err
+	}
+	delete(dc.pendingLockedState, c)
+
+	if err != nil {
+		delete(dc.lockedHintSignals, c)
+		return errors.Join(err, dc.unsubscribePropertiesChangedIfUnused())
+	}
 
-	if !dc.propertiesChangedActive {
-		if err := dc.conn.AddMatchSignal(
-			dbus.WithMatchObjectPath(dc.loginSessionObject.Path()),
-			dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
-			dbus.WithMatchSender("org.freedesktop.login1"),
-			dbus.WithMatchMember("PropertiesChanged"),
-		); err != nil {
-			return fmt.Errorf("failed to register Dbus signal for LockedHint: %w", err)
+	for _, value := range append([]bool{locked}, pending.changes...) {
+		select {
+		case c <- value:
+		default:
 		}
+	}
+
+	return nil
+}
 
-		dc.propertiesChangedActive = true
+// pendingState is a channel of AddLockedSignalWithState whose current state is being read.
+type pendingState struct {
+	// c is the channel, which changes when it is replaced while the state is read.
+	c chan<- bool
+	// changes are the changes received while reading the state.
+	changes []bool
+}
+
+// subscribePropertiesChanged subscribes to the PropertiesChanged signal of the session if not
+// yet subscribed.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) subscribePropertiesChanged() error {
+	err := dc.subscribe(
+		&dc.propertiesChangedSubscription,
+		login1.PropertiesInterface,
+		"PropertiesChanged",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus PropertiesChanged signal: %w", err)
+	}
+
+	return nil
+}
+
+// unsubscribePropertiesChangedIfUnused unsubscribes from the PropertiesChanged signal of the
+// session when no channel needs it anymore.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) unsubscribePropertiesChangedIfUnused() error {
+	if len(dc.lockedHintSignals) > 0 ||
+		len(dc.stateSignals) > 0 ||
+		len(dc.lockStateSignals) > 0 ||
+		len(dc.hintConflictSignals) > 0 ||
+		(dc.coalesceWindow > 0 && len(dc.lockSignals) > 0) ||
+		dc.ownsHint() {
+		return nil
+	}
+
+	if err := unsubscribe(&dc.propertiesChangedSubscription); err != nil {
+		return fmt.Errorf("failed to remove Dbus PropertiesChanged signal: %w", err)
 	}
 
 	return nil
@@ -286,52 +671,127 @@ func (dc *dbusCon) RemoveLockedSignal(c chan<- bool) error {
 	defer dc.muSignals.Unlock()
 
 	delete(dc.lockedHintSignals, c)
+	delete(dc.pendingLockedState, c)
 
-	if len(dc.lockedHintSignals) == 0 {
-		if err := dc.removePropertiesChangedSignal(); err != nil {
-			return err
-		}
+	return dc.unsubscribePropertiesChangedIfUnused()
+}
+
+func (dc *dbusCon) ReplaceLockedSignal(old chan<- bool, new chan<- bool) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	if err := replaceSignal(dc.lockedHintSignals, old, new); err != nil {
+		return err
+	}
+	if pending, ok := dc.pendingLockedState[old]; ok {
+		delete(dc.pendingLockedState, old)
+		pending.c = new
+		dc.pendingLockedState[new] = pending
 	}
 
 	return nil
 }
 
-// removePropertiesChangedSignal Removes the PropertiesChangedSignal if it was registered.
+// replaceSignal replaces the old channel with the new one. The subscription is left untouched
+// since the number of channels does not change.
 // Holding the muSignals mutex is required.
-func (dc *dbusCon) removePropertiesChangedSignal() error {
-	if !dc.propertiesChangedActive {
+func replaceSignal[T any](signals map[chan<- T]struct{}, old chan<- T, new chan<- T) error {
+	if old == nil || new == nil {
+		return errors.New("replace signal: channel cannot be nil")
+	}
+
+	if _, ok := signals[old]; !ok {
+		return ErrNotRegistered
+	}
+
+	delete(signals, old)
+	signals[new] = struct{}{}
+
+	return nil
+}
+
+// rule returns the rule for the signal, taking WithLooseMatching into account.
+func (dc *dbusCon) rule(path dbus.ObjectPath, iface string, member string) login1.Rule {
+	return login1.Rule{
+		Path:      path,
+		Interface: iface,
+		Member:    member,
+		AnySender: dc.looseMatching,
+	}
+}
+
+// subscribe subscribes to the given signal of the session if *subscription is nil.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) subscribe(subscription **login1.Subscription, iface string, member string) error {
+	if *subscription != nil {
 		return nil
 	}
 
-	if err := dc.conn.RemoveMatchSignal(
-		dbus.WithMatchObjectPath(dc.loginSessionObject.Path()),
-		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
-		dbus.WithMatchSender("org.freedesktop.login1"),
-		dbus.WithMatchMember("PropertiesChanged"),
-	); err != nil {
-		return fmt.Errorf("failed to remove Dbus PropertiesChanged signal: %w", err)
+	s, err := dc.login1.Subscribe(
+		dc.rule(dc.session().Path(), iface, member),
+		dc.handleIncomingSignal,
+	)
+	if err != nil {
+		return err
 	}
 
-	dc.propertiesChangedActive = false
+	*subscription = s
 
 	return nil
 }
 
+// unsubscribe closes *subscription, if any, and sets it to nil.
+// Holding the muSignals mutex is required.
+func unsubscribe(subscription **login1.Subscription) error {
+	if *subscription == nil {
+		return nil
+	}
+
+	err := (*subscription).Close()
+	*subscription = nil
+
+	return err
+}
+
+// Close unregisters all channels and closes the connection to the system bus. It blocks until
+// signal processing has stopped, no channel is notified after Close returns.
+// Calling Close more than once is a no-op.
 func (dc *dbusCon) Close() error {
 	dc.muSignals.Lock()
-	defer dc.muSignals.Unlock()
-
 	var err error
-
 	clear(dc.lockSignals)
-	err = errors.Join(err, dc.removeLockSignal())
+	err = errors.Join(err, unsubscribe(&dc.lockSubscription))
 	clear(dc.unlockSignals)
-	err = errors.Join(err, dc.removeUnlockSignal())
+	err = errors.Join(err, unsubscribe(&dc.unlockSubscription))
 	clear(dc.lockedHintSignals)
-	err = errors.Join(err, dc.removePropertiesChangedSignal())
+	clear(dc.pendingLockedState)
+	clear(dc.stateSignals)
+	clear(dc.lockStateSignals)
+	clear(dc.hintConflictSignals)
+	dc.stopHintOwnership()
+	err = errors.Join(err, unsubscribe(&dc.propertiesChangedSubscription))
+	clear(dc.vtSignals)
+	err = errors.Join(err, unsubscribe(&dc.seatSubscription))
+	clear(dc.sessionRemovedSignals)
+	err = errors.Join(err, unsubscribe(&dc.sessionRemovedSubscription))
+	clear(dc.sessionRecreatedSignals)
+	err = errors.Join(err, unsubscribe(&dc.sessionNewSubscription))
+	dc.muSession.Lock()
+	if dc.recreateTimer != nil {
+		dc.recreateTimer.Stop()
+		dc.recreateTimer = nil
+	}
+	dc.muSession.Unlock()
+	select {
+	case <-dc.closed:
+	default:
+		close(dc.closed)
+	}
+	// Release the mutex before closing the connection, the signal handler might be waiting
+	// for it.
+	dc.muSignals.Unlock()
 
-	close(dc.closeSignalHandler)
-	return err
+	return errors.Join(err, dc.login1.Close())
 }
 
 func (dc *dbusCon) handleIncomingSignal(s *dbus.Signal) {
@@ -340,45 +800,199 @@ func (dc *dbusCon) handleIncomingSignal(s *dbus.Signal) {
 		return
 	}
 
-	if s.Path != dc.loginSessionObject.Path() {
+	if s.Path != dc.session().Path() {
 		return
 	}
 
-	dc.muSignals.Lock()
-	defer dc.muSignals.Unlock()
+	// Before anything that can delay handling, such as waiting for muSignals
+	receivedAt := time.Now()
 
 	switch s.Name {
 	case "org.freedesktop.login1.Session.Lock":
+		dc.muSignals.Lock()
+		defer dc.muSignals.Unlock()
+		if dc.suppressLockSignal(receivedAt) {
+			return
+		}
 		for c := range dc.lockSignals {
 			select {
 			case c <- struct{}{}:
 			default:
 			}
 		}
+		dc.deliverLockEvent(LockEvent{
+			Locked:   true,
+			Source:   LockEventSourceLockSignal,
+			Time:     receivedAt,
+			Sequence: uint64(s.Sequence),
+		})
 	case "org.freedesktop.login1.Session.Unlock":
+		dc.muSignals.Lock()
+		defer dc.muSignals.Unlock()
+		dc.coalesceLocked(false)
 		for c := range dc.unlockSignals {
 			select {
 			case c <- struct{}{}:
 			default:
 			}
 		}
+		dc.deliverLockEvent(LockEvent{
+			Locked:   false,
+			Source:   LockEventSourceUnlockSignal,
+			Time:     receivedAt,
+			Sequence: uint64(s.Sequence),
+		})
 	case "org.freedesktop.DBus.Properties.PropertiesChanged":
-		changedProperties := s.Body[1].(map[string]dbus.Variant)
-		lockedHintProperty, hasLockedHint := changedProperties["LockedHint"]
-		if !hasLockedHint {
+		changed, invalidated, ok := sessionPropertiesChanged(s.Body)
+		if !ok {
 			return
 		}
 
-		isLocked, ok := lockedHintProperty.Value().(bool)
-		if !ok {
-			panic("PropertiesChanged signal's LockedHint is not a boolean")
+		// State first, a closing session is removed before its LockedHint is delivered
+		for _, name := range []string{"State", "LockedHint"} {
+			if variant, ok := changed[name]; ok {
+				dc.muProperties.Lock()
+				dc.propertyChanges[name]++
+				dc.deliverProperty(name, variant.Value(), receivedAt, uint64(s.Sequence))
+				dc.muProperties.Unlock()
+			} else if slices.Contains(invalidated, name) {
+				dc.fetchInvalidated(name, receivedAt, uint64(s.Sequence))
+			}
 		}
+	}
+}
 
-		for c := range dc.lockedHintSignals {
-			select {
-			case c <- isLocked:
-			default:
-			}
+// sessionPropertiesChanged returns the changed and invalidated properties of a
+// PropertiesChanged signal of the session interface.
+// Returns false if the signal concerns another interface or the body is malformed.
+func sessionPropertiesChanged(body []interface{}) (map[string]dbus.Variant, []string, bool) {
+	if len(body) < 3 {
+		return nil, nil, false
+	}
+
+	if iface, ok := body[0].(string); !ok || iface != login1.SessionInterface {
+		return nil, nil, false
+	}
+
+	changed, ok := body[1].(map[string]dbus.Variant)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// A malformed list of invalidated properties does not make the changed ones less valid
+	invalidated, _ := body[2].([]string)
+
+	return changed, invalidated, true
+}
+
+// fetchInvalidated reads the value of a session property that a PropertiesChanged signal only
+// reported as invalidated. Reading is offloaded, signal handlers must not make method calls. The
+// value is dropped when a newer value of the property has been delivered from the body of a
+// PropertiesChanged signal meanwhile.
+func (dc *dbusCon) fetchInvalidated(name string, receivedAt time.Time, sequence uint64) {
+	dc.muProperties.Lock()
+	changes := dc.propertyChanges[name]
+	dc.muProperties.Unlock()
+
+	dc.offload(func() {
+		if err := dc.checkSession(); err != nil {
+			return
+		}
+
+		variant, err := dc.session().GetProperty(login1.SessionInterface + "." + name)
+		if err != nil {
+			return
+		}
+
+		dc.muProperties.Lock()
+		defer dc.muProperties.Unlock()
+		if dc.propertyChanges[name] != changes {
+			return
+		}
+		dc.deliverProperty(name, variant.Value(), receivedAt, sequence)
+	})
+}
+
+// deliverProperty delivers the new value of the session property to the channels that need it.
+// Holding the muProperties mutex is required.
+func (dc *dbusCon) deliverProperty(
+	name string,
+	value interface{},
+	receivedAt time.Time,
+	sequence uint64,
+) {
+	switch name {
+	case "State":
+		if state, ok := value.(string); ok {
+			dc.deliverState(SessionState(state))
+		}
+	case "LockedHint":
+		if isLocked, ok := value.(bool); ok {
+			dc.deliverLockedHint(isLocked, receivedAt, sequence)
+		}
+	}
+}
+
+// deliverLockedHint delivers a change of LockedHint.
+func (dc *dbusCon) deliverLockedHint(isLocked bool, receivedAt time.Time, sequence uint64) {
+	conflict, isConflict := dc.hintChanged(isLocked, receivedAt)
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+	if isConflict {
+		dc.deliverHintConflict(conflict)
+	}
+	dc.coalesceLocked(isLocked)
+	for c := range dc.lockedHintSignals {
+		if pending, ok := dc.pendingLockedState[c]; ok {
+			pending.changes = append(pending.changes, isLocked)
+			continue
+		}
+		select {
+		case c <- isLocked:
+		default:
+		}
+	}
+	dc.deliverLockEvent(LockEvent{
+		Locked:   isLocked,
+		Source:   LockEventSourceLockedHint,
+		Time:     receivedAt,
+		Sequence: sequence,
+	})
+}
+
+// offload runs f on a goroutine of the Lock instead of the dispatch goroutine, for work that
+// makes method calls. The functions run one at a time, in the order they are offloaded. Those
+// that have not started when the Lock is closed are dropped.
+func (dc *dbusCon) offload(f func()) {
+	dc.muOffload.Lock()
+	defer dc.muOffload.Unlock()
+
+	dc.offloaded = append(dc.offloaded, f)
+	if !dc.offloading {
+		dc.offloading = true
+		go dc.runOffloaded()
+	}
+}
+
+// runOffloaded runs the offloaded functions until none is left.
+func (dc *dbusCon) runOffloaded() {
+	for {
+		dc.muOffload.Lock()
+		if len(dc.offloaded) == 0 {
+			dc.offloading = false
+			dc.muOffload.Unlock()
+			return
+		}
+		f := dc.offloaded[0]
+		dc.offloaded[0] = nil
+		dc.offloaded = dc.offloaded[1:]
+		dc.muOffload.Unlock()
+
+		select {
+		case <-dc.closed:
+		default:
+			f()
 		}
 	}
 }
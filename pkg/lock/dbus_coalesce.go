@@ -0,0 +1,61 @@
+package lock
+
+import (
+	"time"
+)
+
+// coalesceState tracks the Lock signals delivered while the session is locked, see
+// WithLockSignalCoalescing. Guarded by muSignals.
+type coalesceState struct {
+	// locked is set once LockedHint=true has been observed, or SetLocked(true) succeeded, and
+	// no unlock has been observed since.
+	locked bool
+	// lastLock is when the last Lock signal was delivered while locked.
+	lastLock time.Time
+	// suppressed is the number of Lock signals that were not delivered.
+	suppressed uint64
+}
+
+// coalesceLocked records an observed change of the locked state.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) coalesceLocked(locked bool) {
+	if dc.coalesceWindow == 0 {
+		return
+	}
+
+	dc.coalesce.locked = locked
+	if !locked {
+		dc.coalesce.lastLock = time.Time{}
+	}
+}
+
+// suppressLockSignal reports whether the Lock signal received at now duplicates one delivered
+// less than the coalescing window earlier while locked. Counts the signal if so.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) suppressLockSignal(now time.Time) bool {
+	if dc.coalesceWindow == 0 {
+		return false
+	}
+
+	c := &dc.coalesce
+	if !c.locked {
+		// The first Lock signal of a lock is always delivered, it is what makes the locker lock
+		c.lastLock = now
+		return false
+	}
+
+	if !c.lastLock.IsZero() && now.Sub(c.lastLock) < dc.coalesceWindow {
+		c.suppressed++
+		return true
+	}
+	c.lastLock = now
+
+	return false
+}
+
+func (dc *dbusCon) SuppressedLockSignals() uint64 {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return dc.coalesce.suppressed
+}
@@ -0,0 +1,127 @@
+package lock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockSignalCoalescing(t *testing.T) {
+	dc := newTestDbusCon(map[string]interface{}{})
+	dc.coalesceWindow = time.Hour
+	lock := make(chan struct{}, 8)
+	dc.lockSignals[lock] = struct{}{}
+
+	// Not locked yet, every Lock signal is delivered
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	if len(lock) != 2 {
+		t.Fatalf("received %d Lock signals before locking, want 2", len(lock))
+	}
+
+	dc.handleIncomingSignal(lockedHintChanged(true))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	if len(lock) != 2 {
+		t.Fatalf("received %d Lock signals, want duplicates suppressed", len(lock))
+	}
+	if n := dc.SuppressedLockSignals(); n != 2 {
+		t.Fatalf("SuppressedLockSignals() = %d, want 2", n)
+	}
+
+	// Once the window passed, one is delivered again
+	dc.coalesce.lastLock = dc.coalesce.lastLock.Add(-dc.coalesceWindow)
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	if len(lock) != 3 {
+		t.Fatalf("received %d Lock signals, want 3 after the window", len(lock))
+	}
+
+	// An unlock ends the lock, the next Lock signal starts a new one
+	dc.handleIncomingSignal(sessionSignal("Unlock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	if len(lock) != 4 {
+		t.Fatalf("received %d Lock signals, want 4 after unlocking", len(lock))
+	}
+	if n := dc.SuppressedLockSignals(); n != 3 {
+		t.Fatalf("SuppressedLockSignals() = %d, want 3", n)
+	}
+}
+
+func TestLockSignalCoalescingDisabled(t *testing.T) {
+	dc := newTestDbusCon(map[string]interface{}{})
+	lock := make(chan struct{}, 4)
+	dc.lockSignals[lock] = struct{}{}
+
+	dc.handleIncomingSignal(lockedHintChanged(true))
+	for range 3 {
+		dc.handleIncomingSignal(sessionSignal("Lock"))
+	}
+
+	if len(lock) != 3 || dc.SuppressedLockSignals() != 0 {
+		t.Fatalf("received %d Lock signals, suppressed %d, want all delivered",
+			len(lock), dc.SuppressedLockSignals())
+	}
+}
+
+func TestLockSignalCoalescingLockState(t *testing.T) {
+	dc := newTestDbusCon(map[string]interface{}{})
+	dc.coalesceWindow = time.Hour
+	events := make(chan LockEvent, 8)
+	dc.lockStateSignals[events] = struct{}{}
+
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(lockedHintChanged(true))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(sessionSignal("Unlock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	close(events)
+
+	var got []LockEventSource
+	for e := range events {
+		got = append(got, e.Source)
+	}
+	want := []LockEventSource{
+		LockEventSourceLockSignal,
+		LockEventSourceUnlockSignal,
+		LockEventSourceLockSignal,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("received %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLockSignalCoalescingSetLocked(t *testing.T) {
+	dc, _ := newTestLock(t)
+	dc.coalesceWindow = time.Hour
+
+	lock := make(chan struct{}, 4)
+	if err := dc.AddLockSignal(lock); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+	if dc.propertiesChangedSubscription == nil {
+		t.Fatalf("PropertiesChanged not subscribed, LockedHint cannot be observed")
+	}
+
+	if err := dc.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	if len(lock) != 1 || dc.SuppressedLockSignals() != 1 {
+		t.Fatalf("received %d Lock signals, suppressed %d, want 1 and 1",
+			len(lock), dc.SuppressedLockSignals())
+	}
+
+	if err := dc.RemoveLockSignal(lock); err != nil {
+		t.Fatalf("RemoveLockSignal() error = %v", err)
+	}
+	if dc.lockSubscription != nil || dc.propertiesChangedSubscription != nil {
+		t.Fatalf("subscriptions left after removing the last channel")
+	}
+}
@@ -0,0 +1,173 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+// transitionQueueSize is the number of transitions the iterators of a Lock buffer for a consumer
+// that has not yet asked for the next one.
+const transitionQueueSize = 64
+
+// ErrTransitionsDropped is yielded by the iterators of a Lock, such as LockedTransitions, when
+// transitions were dropped because the consumer fell behind.
+var ErrTransitionsDropped = errors.New("transitions dropped")
+
+func (dc *dbusCon) LockedTransitions(ctx context.Context) iter.Seq2[bool, error] {
+	return transitions(ctx, dc, dc.AddLockedSignal, dc.RemoveLockedSignal)
+}
+
+func (dc *dbusCon) LockSignals(ctx context.Context) iter.Seq2[struct{}, error] {
+	return transitions(ctx, dc, dc.AddLockSignal, dc.RemoveLockSignal)
+}
+
+func (dc *dbusCon) UnlockSignals(ctx context.Context) iter.Seq2[struct{}, error] {
+	return transitions(ctx, dc, dc.AddUnlockSignal, dc.RemoveUnlockSignal)
+}
+
+// transitions returns an iterator over the values delivered to a channel registered using add.
+// The channel is registered when iteration starts and unregistered using remove when it ends.
+func transitions[T any](
+	ctx context.Context,
+	dc *dbusCon,
+	add func(c chan<- T) error,
+	remove func(c chan<- T) error,
+) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		removed := make(chan struct{}, 1)
+		if err := dc.AddSessionRemovedSignal(removed); err != nil {
+			yield(zero, err)
+			return
+		}
+		defer func() { _ = dc.RemoveSessionRemovedSignal(removed) }()
+
+		// Delivery does not block, the channel is emptied into the queue right away
+		c := make(chan T, transitionQueueSize)
+		if err := add(c); err != nil {
+			yield(zero, err)
+			return
+		}
+		defer func() { _ = remove(c) }()
+
+		q := newTransitionQueue[T]()
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			for {
+				select {
+				case v := <-c:
+					q.push(v)
+				case <-removed:
+					// Values are delivered to c before the removal is, keep them
+					for len(c) > 0 {
+						q.push(<-c)
+					}
+					q.end()
+					return
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		for {
+			v, dropped, ok := q.pop()
+			switch {
+			case ok:
+				if dropped > 0 && !yield(zero, fmt.Errorf("%w: %d", ErrTransitionsDropped, dropped)) {
+					return
+				}
+				if !yield(v, nil) {
+					return
+				}
+				continue
+			case q.ended():
+				yield(zero, ErrSessionGone)
+				return
+			}
+
+			select {
+			case <-q.ready:
+			case <-ctx.Done():
+				return
+			case <-dc.closed:
+				return
+			}
+		}
+	}
+}
+
+// transitionQueue is a bounded queue that drops the oldest value when it is full.
+type transitionQueue[T any] struct {
+	// ready is signalled when a value is pushed or the queue ends.
+	ready chan struct{}
+
+	mu      sync.Mutex
+	values  []T
+	dropped int
+	// done is set by end, no values follow.
+	done bool
+}
+
+func newTransitionQueue[T any]() *transitionQueue[T] {
+	return &transitionQueue[T]{ready: make(chan struct{}, 1)}
+}
+
+func (q *transitionQueue[T]) push(v T) {
+	q.mu.Lock()
+	if len(q.values) == transitionQueueSize {
+		q.values = q.values[1:]
+		q.dropped++
+	}
+	q.values = append(q.values, v)
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// end marks that no values follow.
+func (q *transitionQueue[T]) end() {
+	q.mu.Lock()
+	q.done = true
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// ended reports whether end was called.
+func (q *transitionQueue[T]) ended() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.done
+}
+
+func (q *transitionQueue[T]) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the oldest value and the number of values dropped before it.
+func (q *transitionQueue[T]) pop() (T, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.values) == 0 {
+		var zero T
+		return zero, 0, false
+	}
+
+	v := q.values[0]
+	q.values = q.values[1:]
+	dropped := q.dropped
+	q.dropped = 0
+
+	return v, dropped, true
+}
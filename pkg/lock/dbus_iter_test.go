@@ -0,0 +1,112 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type transition struct {
+	locked bool
+	err    error
+}
+
+// iterateLocked runs LockedTransitions on a goroutine until it has registered its channel.
+// The returned channel is closed when iteration ends.
+func iterateLocked(t *testing.T, ctx context.Context, dc *dbusCon) <-chan transition {
+	t.Helper()
+
+	results := make(chan transition, 16)
+	go func() {
+		defer close(results)
+		for locked, err := range dc.LockedTransitions(ctx) {
+			results <- transition{locked, err}
+		}
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		dc.muSignals.Lock()
+		registered := len(dc.lockedHintSignals)
+		dc.muSignals.Unlock()
+		if registered > 0 {
+			return results
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("LockedTransitions did not register its channel")
+	return nil
+}
+
+func receiveTransition(t *testing.T, results <-chan transition) (transition, bool) {
+	t.Helper()
+
+	select {
+	case r, ok := <-results:
+		return r, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for transition")
+		return transition{}, false
+	}
+}
+
+func TestLockedTransitions(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	session := logind.sessions[0]
+	results := iterateLocked(t, context.Background(), dc)
+
+	session.setProperty("LockedHint", true)
+	session.setProperty("LockedHint", false)
+	logind.removeSession(t, session, true)
+
+	for _, want := range []transition{{true, nil}, {false, nil}, {false, ErrSessionGone}} {
+		got, ok := receiveTransition(t, results)
+		if !ok || got.locked != want.locked || !errors.Is(got.err, want.err) {
+			t.Fatalf("transition = %+v, want %+v", got, want)
+		}
+	}
+	if _, ok := receiveTransition(t, results); ok {
+		t.Fatal("iteration did not end after the session was removed")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+	if len(dc.lockedHintSignals) != 0 || len(dc.sessionRemovedSignals) != 0 {
+		t.Fatal("channels still registered after iteration ended")
+	}
+}
+
+func TestLockedTransitionsEnd(t *testing.T) {
+	dc, _ := newTestLock(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := iterateLocked(t, ctx, dc)
+	cancel()
+	if r, ok := receiveTransition(t, results); ok {
+		t.Fatalf("received %+v after ctx was cancelled", r)
+	}
+
+	results = iterateLocked(t, context.Background(), dc)
+	if err := dc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if r, ok := receiveTransition(t, results); ok {
+		t.Fatalf("received %+v after Close", r)
+	}
+}
+
+func TestTransitionQueue(t *testing.T) {
+	q := newTransitionQueue[int]()
+	for i := range transitionQueueSize + 2 {
+		q.push(i)
+	}
+
+	v, dropped, ok := q.pop()
+	if !ok || v != 2 || dropped != 2 {
+		t.Fatalf("pop() = %d, %d dropped, want 2, 2 dropped", v, dropped)
+	}
+	v, dropped, _ = q.pop()
+	if v != 3 || dropped != 0 {
+		t.Fatalf("second pop() = %d, %d dropped, want 3, 0 dropped", v, dropped)
+	}
+}
@@ -0,0 +1,152 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"time"
+)
+
+// lockEventWindow is the time within which a signal and a LockedHint change reporting the same
+// transition are considered duplicates.
+const lockEventWindow = time.Second
+
+// LockEventSource is the origin of a LockEvent.
+type LockEventSource int
+
+const (
+	// LockEventSourceLockSignal means logind emitted the Lock signal, the system should be
+	// locked.
+	LockEventSourceLockSignal LockEventSource = iota + 1
+	// LockEventSourceUnlockSignal means logind emitted the Unlock signal, the system should be
+	// unlocked.
+	LockEventSourceUnlockSignal
+	// LockEventSourceLockedHint means the LockedHint property of the session changed, usually
+	// because a locker called SetLocked.
+	LockEventSourceLockedHint
+)
+
+func (s LockEventSource) String() string {
+	switch s {
+	case LockEventSourceLockSignal:
+		return "lock signal"
+	case LockEventSourceUnlockSignal:
+		return "unlock signal"
+	case LockEventSourceLockedHint:
+		return "LockedHint"
+	default:
+		return fmt.Sprintf("LockEventSource(%d)", int(s))
+	}
+}
+
+// isSignal reports whether the source is one of the Lock and Unlock signals.
+func (s LockEventSource) isSignal() bool {
+	return s == LockEventSourceLockSignal || s == LockEventSourceUnlockSignal
+}
+
+// LockEvent is a change of the locked state of the session, see Lock.AddLockStateSignal.
+type LockEvent struct {
+	// Locked is true when the session is or should be locked.
+	Locked bool
+	// Source is what reported the change.
+	Source LockEventSource
+	// Time is when the signal reporting the change was received, taken before it is handled. It
+	// carries a monotonic clock reading, the events of a burst have non-decreasing times.
+	// D-Bus messages carry no send time, so no earlier time is available.
+	Time time.Time
+	// Sequence is the position of the signal in the order the bus connection read its messages.
+	// When the connection falls behind during a burst of signals, godbus can deliver them out of
+	// order; sorting by Sequence restores the order of the bus.
+	Sequence uint64
+}
+
+func (dc *dbusCon) AddLockStateSignal(c chan<- LockEvent) error {
+	if c == nil {
+		return errors.New("AddLockStateSignal: channel cannot be nil")
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	err := dc.subscribeLockState()
+	if err != nil {
+		return errors.Join(err, dc.unsubscribeLockStateIfUnused())
+	}
+	dc.lockStateSignals[c] = struct{}{}
+
+	return nil
+}
+
+// subscribeLockState subscribes to all sources of lock events.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) subscribeLockState() error {
+	err := dc.subscribe(&dc.lockSubscription, login1.SessionInterface, "Lock")
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus Lock signal: %w", err)
+	}
+
+	err = dc.subscribe(&dc.unlockSubscription, login1.SessionInterface, "Unlock")
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus Unlock signal: %w", err)
+	}
+
+	return dc.subscribePropertiesChanged()
+}
+
+// unsubscribeLockStateIfUnused unsubscribes from the sources of lock events that are no longer
+// needed.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) unsubscribeLockStateIfUnused() error {
+	return errors.Join(
+		dc.unsubscribeLockIfUnused(),
+		dc.unsubscribeUnlockIfUnused(),
+		dc.unsubscribePropertiesChangedIfUnused(),
+	)
+}
+
+func (dc *dbusCon) RemoveLockStateSignal(c chan<- LockEvent) error {
+	if c == nil {
+		return errors.New("RemoveLockStateSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	delete(dc.lockStateSignals, c)
+
+	return dc.unsubscribeLockStateIfUnused()
+}
+
+func (dc *dbusCon) ReplaceLockStateSignal(old chan<- LockEvent, new chan<- LockEvent) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.lockStateSignals, old, new)
+}
+
+// deliverLockEvent notifies the lock state channels of the event unless it duplicates the
+// previously delivered event. An event is a duplicate when the previous one reported the same
+// state less than lockEventWindow earlier and came from the other kind of source, i.e. a signal
+// followed by a LockedHint change or the reverse.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) deliverLockEvent(e LockEvent) {
+	last := dc.lastLockEvent
+	if last.Source != 0 &&
+		last.Locked == e.Locked &&
+		last.Source.isSignal() != e.Source.isSignal() &&
+		e.Time.Sub(last.Time) < lockEventWindow {
+		return
+	}
+	dc.lastLockEvent = e
+
+	for c := range dc.lockStateSignals {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package lock
+
+import (
+	"cmp"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"testing"
+	"time"
+)
+
+func sessionSignal(member string) *dbus.Signal {
+	return &dbus.Signal{
+		Path: testSessionPath,
+		Name: "org.freedesktop.login1.Session." + member,
+	}
+}
+
+func lockedHintChanged(locked bool) *dbus.Signal {
+	return propertiesChanged(
+		"org.freedesktop.login1.Session",
+		map[string]dbus.Variant{"LockedHint": dbus.MakeVariant(locked)},
+		[]string{},
+	)
+}
+
+func TestLockStateSignal(t *testing.T) {
+	type event struct {
+		locked bool
+		source LockEventSource
+	}
+
+	tests := []struct {
+		name    string
+		signals []*dbus.Signal
+		want    []event
+	}{
+		{
+			name:    "signal then hint",
+			signals: []*dbus.Signal{sessionSignal("Lock"), lockedHintChanged(true)},
+			want:    []event{{true, LockEventSourceLockSignal}},
+		},
+		{
+			name:    "hint then signal",
+			signals: []*dbus.Signal{lockedHintChanged(false), sessionSignal("Unlock")},
+			want:    []event{{false, LockEventSourceLockedHint}},
+		},
+		{
+			name:    "hint only",
+			signals: []*dbus.Signal{lockedHintChanged(true), lockedHintChanged(false)},
+			want: []event{
+				{true, LockEventSourceLockedHint},
+				{false, LockEventSourceLockedHint},
+			},
+		},
+		{
+			name:    "repeated signal",
+			signals: []*dbus.Signal{sessionSignal("Lock"), sessionSignal("Lock")},
+			want: []event{
+				{true, LockEventSourceLockSignal},
+				{true, LockEventSourceLockSignal},
+			},
+		},
+		{
+			name: "full cycle",
+			signals: []*dbus.Signal{
+				sessionSignal("Lock"),
+				lockedHintChanged(true),
+				sessionSignal("Unlock"),
+				lockedHintChanged(false),
+			},
+			want: []event{
+				{true, LockEventSourceLockSignal},
+				{false, LockEventSourceUnlockSignal},
+			},
+		},
+		{
+			name:    "different state",
+			signals: []*dbus.Signal{sessionSignal("Lock"), lockedHintChanged(false)},
+			want: []event{
+				{true, LockEventSourceLockSignal},
+				{false, LockEventSourceLockedHint},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newTestDbusCon(map[string]interface{}{})
+			c := make(chan LockEvent, len(tt.signals))
+			dc.lockStateSignals[c] = struct{}{}
+
+			for _, s := range tt.signals {
+				dc.handleIncomingSignal(s)
+			}
+			close(c)
+
+			var got []event
+			for e := range c {
+				got = append(got, event{e.Locked, e.Source})
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("received %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("received %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLockStateSignalWindow(t *testing.T) {
+	dc := newTestDbusCon(map[string]interface{}{})
+	c := make(chan LockEvent, 2)
+	dc.lockStateSignals[c] = struct{}{}
+
+	dc.handleIncomingSignal(sessionSignal("Lock"))
+	// Pretend the Lock signal was received before the window
+	dc.lastLockEvent.Time = dc.lastLockEvent.Time.Add(-lockEventWindow)
+	dc.handleIncomingSignal(lockedHintChanged(true))
+
+	if len(c) != 2 {
+		t.Fatalf("received %d events, want 2", len(c))
+	}
+	<-c
+	if e := <-c; !e.Locked || e.Source != LockEventSourceLockedHint {
+		t.Fatalf("received %+v, want locked from LockedHint", e)
+	}
+}
+
+func TestLockStateSignalSubscriptions(t *testing.T) {
+	dc, _ := newTestLock(t)
+
+	events := make(chan LockEvent, 4)
+	lock := make(chan struct{}, 1)
+	if err := dc.AddLockSignal(lock); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+	if err := dc.AddLockStateSignal(events); err != nil {
+		t.Fatalf("AddLockStateSignal() error = %v", err)
+	}
+
+	if err := dc.RemoveLockSignal(lock); err != nil {
+		t.Fatalf("RemoveLockSignal() error = %v", err)
+	}
+	if dc.lockSubscription == nil {
+		t.Fatalf("Lock subscription removed while AddLockStateSignal needs it")
+	}
+
+	if err := dc.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+	select {
+	case e := <-events:
+		if !e.Locked || e.Source != LockEventSourceLockedHint {
+			t.Fatalf("received %+v, want locked from LockedHint", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no lock event received")
+	}
+
+	if err := dc.RemoveLockStateSignal(events); err != nil {
+		t.Fatalf("RemoveLockStateSignal() error = %v", err)
+	}
+	if dc.lockSubscription != nil ||
+		dc.unlockSubscription != nil ||
+		dc.propertiesChangedSubscription != nil {
+		t.Fatalf("subscriptions left after removing the last channel")
+	}
+}
+
+func TestLockStateSignalTimes(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	session := logind.sessions[0]
+
+	// Large enough for the connection to fall behind, which reorders signals
+	const burst = 64
+	events := make(chan LockEvent, burst)
+	if err := dc.AddLockStateSignal(events); err != nil {
+		t.Fatalf("AddLockStateSignal() error = %v", err)
+	}
+
+	for i := range burst {
+		member := "Lock"
+		if i%2 == 1 {
+			member = "Unlock"
+		}
+		err := logind.conn.Emit(session.path, "org.freedesktop.login1.Session."+member)
+		if err != nil {
+			t.Fatalf("failed to emit %s: %v", member, err)
+		}
+	}
+
+	received := make([]LockEvent, 0, burst)
+	for i := range burst {
+		var e LockEvent
+		select {
+		case e = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d events", i, burst)
+		}
+
+		if e.Sequence == 0 {
+			t.Fatalf("event %d has no sequence", i)
+		}
+		if i > 0 && e.Time.Before(received[i-1].Time) {
+			t.Fatalf("event %d received at %v, before event %d at %v", i, e.Time, i-1, received[i-1].Time)
+		}
+		received = append(received, e)
+	}
+
+	// Sequence restores the order of the bus
+	slices.SortFunc(received, func(a, b LockEvent) int {
+		return cmp.Compare(a.Sequence, b.Sequence)
+	})
+	for i, e := range received {
+		if i > 0 && e.Sequence == received[i-1].Sequence {
+			t.Fatalf("events %d and %d have the same sequence", i-1, i)
+		}
+		if e.Locked != (i%2 == 0) {
+			t.Fatalf("event %d in sequence order is locked=%t, want the emitted order", i, e.Locked)
+		}
+	}
+}
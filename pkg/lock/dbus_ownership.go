@@ -0,0 +1,228 @@
+package lock
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"slices"
+	"time"
+)
+
+// HintConflict reports that another program changed LockedHint away from the value set using
+// SetLocked, see WithHintOwnership.
+type HintConflict struct {
+	// Ours is the value last set using SetLocked.
+	Ours bool
+	// Theirs is the value set by the other program.
+	Theirs bool
+	// Time is when the change was received.
+	Time time.Time
+	// Reasserted is true when Ours will be set again, see WithHintReassert.
+	Reasserted bool
+	// GaveUp is true when Ours is not set again because the other program kept changing it, see
+	// WithHintReassert. Reasserting resumes after the next SetLocked.
+	GaveUp bool
+	// Err is set when setting Ours again failed. The other fields describe the conflict that
+	// was being resolved.
+	Err error
+}
+
+// hintState is the LockedHint owned by the Lock, see WithHintOwnership.
+type hintState struct {
+	// owned is set once SetLocked has been called.
+	owned bool
+	value bool
+	// pending are the values set by this Lock whose PropertiesChanged has not been received yet.
+	pending []pendingHint
+	// lastReassert is the time the last reassert was, or is scheduled to be, made.
+	lastReassert time.Time
+	// reasserts is the number of reasserts of the current conflict.
+	reasserts int
+	gaveUp    bool
+}
+
+type pendingHint struct {
+	value bool
+	at    time.Time
+}
+
+// ownHint records that the Lock sets LockedHint to locked. Returns a function restoring the
+// previous state, for when setting fails.
+func (dc *dbusCon) ownHint(locked bool) (restore func()) {
+	dc.muHint.Lock()
+	defer dc.muHint.Unlock()
+
+	previous := dc.hint
+	previous.pending = slices.Clone(previous.pending)
+	dc.hint.owned = true
+	dc.hint.value = locked
+	dc.hint.pending = append(dc.hint.pending, pendingHint{value: locked, at: time.Now()})
+	dc.hint.reasserts = 0
+	dc.hint.gaveUp = false
+
+	return func() {
+		dc.muHint.Lock()
+		defer dc.muHint.Unlock()
+		dc.hint = previous
+	}
+}
+
+// ownsHint reports whether the PropertiesChanged subscription is needed to watch the hint.
+func (dc *dbusCon) ownsHint() bool {
+	dc.muHint.Lock()
+	defer dc.muHint.Unlock()
+
+	return dc.hint.owned
+}
+
+// subscribeHintOwnership makes sure that changes of the owned hint are received.
+func (dc *dbusCon) subscribeHintOwnership() error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return dc.subscribePropertiesChanged()
+}
+
+// hintChanged checks a change of LockedHint against the owned value and schedules a reassert if
+// needed. Returns false if the change does not conflict.
+func (dc *dbusCon) hintChanged(locked bool, now time.Time) (HintConflict, bool) {
+	dc.muHint.Lock()
+	defer dc.muHint.Unlock()
+
+	h := &dc.hint
+	if !h.owned {
+		return HintConflict{}, false
+	}
+
+	// Values set by this Lock arrive in order, possibly after a later SetLocked
+	h.pending = slices.DeleteFunc(h.pending, func(p pendingHint) bool {
+		return now.Sub(p.at) >= lockEventWindow
+	})
+	if i := slices.IndexFunc(h.pending, func(p pendingHint) bool { return p.value == locked }); i != -1 {
+		h.pending = h.pending[i+1:]
+		return HintConflict{}, false
+	}
+
+	if locked == h.value {
+		return HintConflict{}, false
+	}
+
+	c := HintConflict{Ours: h.value, Theirs: locked, Time: now}
+	if dc.reassertAttempts == 0 {
+		return c, true
+	}
+	if h.gaveUp {
+		c.GaveUp = true
+		return c, true
+	}
+
+	// A conflict shortly after reasserting means another program is reasserting too
+	if !h.lastReassert.IsZero() && now.Sub(h.lastReassert) < 2*dc.reassertInterval {
+		h.reasserts++
+	} else {
+		h.reasserts = 1
+	}
+	if h.reasserts > dc.reassertAttempts {
+		h.gaveUp = true
+		c.GaveUp = true
+		return c, true
+	}
+
+	delay := max(h.lastReassert.Add(dc.reassertInterval).Sub(now), 0)
+	h.lastReassert = now.Add(delay)
+	if dc.reassertTimer == nil {
+		dc.reassertTimer = time.AfterFunc(delay, dc.reassertHint)
+	}
+	c.Reasserted = true
+
+	return c, true
+}
+
+// reassertHint sets LockedHint to the owned value again. Called by reassertTimer.
+func (dc *dbusCon) reassertHint() {
+	dc.muHint.Lock()
+	dc.reassertTimer = nil
+	if !dc.hint.owned || dc.hint.gaveUp {
+		dc.muHint.Unlock()
+		return
+	}
+	value := dc.hint.value
+	dc.hint.pending = append(dc.hint.pending, pendingHint{value: value, at: time.Now()})
+	dc.muHint.Unlock()
+
+	err := dc.session().Call(login1.SessionInterface+".SetLockedHint", 0, value).Err
+	if err == nil {
+		return
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+	dc.deliverHintConflict(HintConflict{
+		Ours:   value,
+		Theirs: !value,
+		Time:   time.Now(),
+		Err:    err,
+	})
+}
+
+// stopHintOwnership stops reasserting, used by Close.
+func (dc *dbusCon) stopHintOwnership() {
+	dc.muHint.Lock()
+	defer dc.muHint.Unlock()
+
+	dc.hint = hintState{}
+	if dc.reassertTimer != nil {
+		dc.reassertTimer.Stop()
+		dc.reassertTimer = nil
+	}
+}
+
+func (dc *dbusCon) AddHintConflictSignal(c chan<- HintConflict) error {
+	if c == nil {
+		return errors.New("AddHintConflictSignal: channel cannot be nil")
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	if err := dc.subscribePropertiesChanged(); err != nil {
+		return err
+	}
+	dc.hintConflictSignals[c] = struct{}{}
+
+	return nil
+}
+
+func (dc *dbusCon) RemoveHintConflictSignal(c chan<- HintConflict) error {
+	if c == nil {
+		return errors.New("RemoveHintConflictSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	delete(dc.hintConflictSignals, c)
+
+	return dc.unsubscribePropertiesChangedIfUnused()
+}
+
+func (dc *dbusCon) ReplaceHintConflictSignal(old chan<- HintConflict, new chan<- HintConflict) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.hintConflictSignals, old, new)
+}
+
+// deliverHintConflict notifies the hint conflict channels.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) deliverHintConflict(conflict HintConflict) {
+	for c := range dc.hintConflictSignals {
+		select {
+		case c <- conflict:
+		default:
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package lock
+
+import (
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"testing"
+	"time"
+)
+
+func TestHintConflict(t *testing.T) {
+	dc := newTestDbusCon(map[string]interface{}{})
+	dc.hintOwnership = true
+	dc.hintConflictSignals = make(map[chan<- HintConflict]struct{})
+	c := make(chan HintConflict, 4)
+	dc.hintConflictSignals[c] = struct{}{}
+
+	// Not owned before SetLocked
+	dc.handleIncomingSignal(lockedHintChanged(true))
+	if len(c) != 0 {
+		t.Fatalf("conflict reported before SetLocked")
+	}
+
+	// Two changes in quick succession, the first change is received after the second call
+	dc.ownHint(true)
+	dc.ownHint(false)
+	dc.handleIncomingSignal(lockedHintChanged(true))
+	dc.handleIncomingSignal(lockedHintChanged(false))
+	if len(c) != 0 {
+		t.Fatalf("own changes reported as conflict: %+v", <-c)
+	}
+
+	dc.handleIncomingSignal(lockedHintChanged(true))
+	select {
+	case conflict := <-c:
+		if conflict.Ours || !conflict.Theirs || conflict.Reasserted || conflict.GaveUp {
+			t.Fatalf("conflict = %+v, want ours false, theirs true", conflict)
+		}
+	default:
+		t.Fatalf("conflict not reported")
+	}
+}
+
+func TestHintReassert(t *testing.T) {
+	dc, session := newTestLock(t)
+	dc.hintOwnership = true
+	dc.reassertInterval = 10 * time.Millisecond
+	dc.reassertAttempts = 3
+
+	conflicts := make(chan HintConflict, 4)
+	if err := dc.AddHintConflictSignal(conflicts); err != nil {
+		t.Fatalf("AddHintConflictSignal() error = %v", err)
+	}
+	if err := dc.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+
+	// Let the change of SetLocked arrive
+	time.Sleep(lockEventWindow)
+	session.setProperty("LockedHint", false)
+
+	select {
+	case conflict := <-conflicts:
+		if !conflict.Ours || conflict.Theirs || !conflict.Reasserted {
+			t.Fatalf("conflict = %+v, want reasserted", conflict)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("conflict not reported")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		locked, err := dc.GetLocked()
+		if err != nil {
+			t.Fatalf("GetLocked() error = %v", err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("LockedHint not reasserted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHintReassertFight(t *testing.T) {
+	a, logind := newTestLockWithLogin1(t)
+	b, err := newDbusCon(login1.New(logind.bus.Connect(t)), "1")
+	if err != nil {
+		t.Fatalf("newDbusCon() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = b.Close()
+	})
+
+	const attempts = 3
+	gaveUp := make(chan struct{}, 2)
+	for _, dc := range []*dbusCon{a, b} {
+		dc.hintOwnership = true
+		dc.reassertInterval = 10 * time.Millisecond
+		dc.reassertAttempts = attempts
+
+		conflicts := make(chan HintConflict, 16)
+		if err := dc.AddHintConflictSignal(conflicts); err != nil {
+			t.Fatalf("AddHintConflictSignal() error = %v", err)
+		}
+		go func() {
+			for conflict := range conflicts {
+				if conflict.GaveUp {
+					gaveUp <- struct{}{}
+					return
+				}
+			}
+		}()
+	}
+
+	if err := a.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+	if err := b.SetLocked(false); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+
+	// Once one of them gives up, the other one's value stays
+	select {
+	case <-gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Locks kept fighting")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	session := logind.sessions[0]
+	session.mu.Lock()
+	calls := session.setLockedHintCalls
+	session.mu.Unlock()
+	// The SetLocked calls and at most attempts reasserts per Lock
+	if calls > 2+2*attempts {
+		t.Fatalf("LockedHint set %d times, want at most %d", calls, 2+2*attempts)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.setLockedHintCalls != calls {
+		t.Fatalf("LockedHint set after both Locks gave up")
+	}
+}
@@ -0,0 +1,177 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"time"
+)
+
+func (dc *dbusCon) AddSessionRecreatedSignal(c chan<- struct{}) error {
+	if c == nil {
+		return errors.New("AddSessionRecreatedSignal: channel cannot be nil")
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	dc.sessionRecreatedSignals[c] = struct{}{}
+
+	return nil
+}
+
+func (dc *dbusCon) RemoveSessionRecreatedSignal(c chan<- struct{}) error {
+	if c == nil {
+		return errors.New("RemoveSessionRecreatedSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	delete(dc.sessionRecreatedSignals, c)
+
+	return nil
+}
+
+func (dc *dbusCon) ReplaceSessionRecreatedSignal(old chan<- struct{}, new chan<- struct{}) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.sessionRecreatedSignals, old, new)
+}
+
+// session returns the object of the session. It changes when the session is recreated, see
+// WithRecreateGrace.
+func (dc *dbusCon) session() dbus.BusObject {
+	dc.muSession.Lock()
+	defer dc.muSession.Unlock()
+
+	return dc.loginSessionObject
+}
+
+// followRecreate subscribes to the signals needed to follow a recreated session, see
+// WithRecreateGrace. Must be called before the Lock is used.
+func (dc *dbusCon) followRecreate(grace time.Duration) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	dc.recreateGrace = grace
+	if err := dc.subscribeSessionRemoved(); err != nil {
+		return err
+	}
+
+	subscription, err := dc.login1.Subscribe(
+		dc.rule(login1.ManagerPath, login1.ManagerInterface, "SessionNew"),
+		dc.handleSessionNew,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus SessionNew signal: %w", err)
+	}
+	dc.sessionNewSubscription = subscription
+
+	return nil
+}
+
+// awaitRecreate starts the grace period after the session has been removed. The session is
+// considered removed when no session with the same ID appears before it ends.
+func (dc *dbusCon) awaitRecreate() {
+	dc.muSession.Lock()
+	defer dc.muSession.Unlock()
+
+	if dc.recreateTimer != nil {
+		return
+	}
+
+	dc.recreateTimer = time.AfterFunc(dc.recreateGrace, func() {
+		dc.muSession.Lock()
+		dc.recreateTimer = nil
+		dc.muSession.Unlock()
+
+		dc.sessionRemoved()
+	})
+}
+
+// handleSessionNew handles the SessionNew signal of the manager. A session with the same ID as
+// the removed session is followed if it appears during the grace period.
+func (dc *dbusCon) handleSessionNew(s *dbus.Signal) {
+	var id string
+	var path dbus.ObjectPath
+	if err := dbus.Store(s.Body, &id, &path); err != nil || id != dc.sessionId {
+		return
+	}
+
+	dc.muSession.Lock()
+	timer := dc.recreateTimer
+	if timer == nil || !timer.Stop() {
+		// Not removed or the grace period is over
+		dc.muSession.Unlock()
+		return
+	}
+	dc.recreateTimer = nil
+	dc.muSession.Unlock()
+
+	// Subscribing again closes subscriptions, which handlers must not do
+	go dc.rebind(path)
+}
+
+// rebind moves the Lock to the session object at path and subscribes to its signals again. The
+// session is considered removed if subscribing fails.
+func (dc *dbusCon) rebind(path dbus.ObjectPath) {
+	dc.muSignals.Lock()
+
+	dc.muSession.Lock()
+	dc.loginSessionObject = dc.login1.Object(path)
+	// Calls made during the recreation might have found the session to be gone
+	dc.gone.Store(false)
+	dc.muSession.Unlock()
+
+	subscriptions := []struct {
+		subscription **login1.Subscription
+		iface        string
+		member       string
+	}{
+		{&dc.lockSubscription, login1.SessionInterface, "Lock"},
+		{&dc.unlockSubscription, login1.SessionInterface, "Unlock"},
+		{&dc.propertiesChangedSubscription, login1.PropertiesInterface, "PropertiesChanged"},
+	}
+	var err error
+	for _, s := range subscriptions {
+		if *s.subscription == nil {
+			continue
+		}
+		err = errors.Join(
+			err,
+			unsubscribe(s.subscription),
+			dc.subscribe(s.subscription, s.iface, s.member),
+		)
+	}
+	if err != nil {
+		dc.muSignals.Unlock()
+		dc.sessionRemoved()
+		return
+	}
+
+	for c := range dc.sessionRecreatedSignals {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	dc.muSignals.Unlock()
+}
+
+// markGone marks the session as gone, unless it might be recreated, see WithRecreateGrace.
+func (dc *dbusCon) markGone() {
+	dc.muSession.Lock()
+	defer dc.muSession.Unlock()
+
+	if dc.recreateTimer != nil {
+		return
+	}
+	dc.gone.Store(true)
+}
@@ -0,0 +1,103 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionRecreated(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	if err := dc.followRecreate(5 * time.Second); err != nil {
+		t.Fatalf("followRecreate() error = %v", err)
+	}
+
+	locked := make(chan bool, 1)
+	recreated := make(chan struct{}, 1)
+	removed := make(chan struct{}, 1)
+	if err := dc.AddLockedSignal(locked); err != nil {
+		t.Fatalf("AddLockedSignal() error = %v", err)
+	}
+	if err := dc.AddSessionRecreatedSignal(recreated); err != nil {
+		t.Fatalf("AddSessionRecreatedSignal() error = %v", err)
+	}
+	if err := dc.AddSessionRemovedSignal(removed); err != nil {
+		t.Fatalf("AddSessionRemovedSignal() error = %v", err)
+	}
+
+	session := logind.recreateSession(t, logind.sessions[0], "/org/freedesktop/login1/session/_31b")
+
+	select {
+	case <-recreated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the session to be recreated")
+	}
+
+	session.setProperty("LockedHint", true)
+	select {
+	case got := <-locked:
+		if !got {
+			t.Fatal("received unlocked, want locked")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LockedHint of the recreated session not delivered")
+	}
+
+	if err := dc.SetLocked(false); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+	if got, err := dc.GetLocked(); err != nil || got {
+		t.Fatalf("GetLocked() = %t, %v, want false", got, err)
+	}
+
+	select {
+	case <-removed:
+		t.Fatal("SessionRemoved delivered for a recreated session")
+	default:
+	}
+}
+
+func TestSessionRecreateGraceExpired(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	if err := dc.followRecreate(20 * time.Millisecond); err != nil {
+		t.Fatalf("followRecreate() error = %v", err)
+	}
+
+	recreated := make(chan struct{}, 1)
+	removed := make(chan struct{}, 1)
+	if err := dc.AddSessionRecreatedSignal(recreated); err != nil {
+		t.Fatalf("AddSessionRecreatedSignal() error = %v", err)
+	}
+	if err := dc.AddSessionRemovedSignal(removed); err != nil {
+		t.Fatalf("AddSessionRemovedSignal() error = %v", err)
+	}
+
+	session := logind.sessions[0]
+	logind.removeSession(t, session, true)
+
+	select {
+	case <-removed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for SessionRemoved")
+	}
+
+	logind.addSessionAt(t, session.id, "/org/freedesktop/login1/session/_31b")
+	err := logind.conn.Emit(
+		"/org/freedesktop/login1",
+		"org.freedesktop.login1.Manager.SessionNew",
+		session.id,
+		"/org/freedesktop/login1/session/_31b",
+	)
+	if err != nil {
+		t.Fatalf("failed to emit SessionNew: %v", err)
+	}
+
+	select {
+	case <-recreated:
+		t.Fatal("session followed after the grace period")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := dc.GetLocked(); !errors.Is(err, ErrSessionGone) {
+		t.Fatalf("GetLocked() error = %v, want ErrSessionGone", err)
+	}
+}
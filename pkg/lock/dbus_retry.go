@@ -0,0 +1,104 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelay    = 250 * time.Millisecond
+)
+
+// errorKind tells whether an error of a call to logind can be caused by logind restarting.
+type errorKind int
+
+const (
+	// errorPermanent is an error that does not go away by retrying.
+	errorPermanent errorKind = iota
+	// errorObjectGone means the session object does not exist, either because the session was
+	// removed or because logind is restarting.
+	errorObjectGone
+	// errorUnavailable means logind did not answer.
+	errorUnavailable
+)
+
+// dbusErrorName returns the name of the D-Bus error, empty if err is not a D-Bus error.
+func dbusErrorName(err error) string {
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	switch {
+	case errors.As(err, &dbusErr):
+		return dbusErr.Name
+	case errors.As(err, &dbusErrPtr):
+		return dbusErrPtr.Name
+	default:
+		return ""
+	}
+}
+
+func classifyError(err error) errorKind {
+	switch dbusErrorName(err) {
+	case "org.freedesktop.DBus.Error.UnknownObject", "org.freedesktop.DBus.Error.NoSuchObject":
+		return errorObjectGone
+	case "org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.DBus.Error.NameHasNoOwner",
+		"org.freedesktop.DBus.Error.NoReply":
+		return errorUnavailable
+	default:
+		return errorPermanent
+	}
+}
+
+// retry calls f until it succeeds or fails with an error that is not caused by logind
+// restarting, at most retryAttempts times. When the session object is missing, the session is
+// looked up again before retrying, ErrSessionGone is returned without retrying when logind no
+// longer knows it.
+func (dc *dbusCon) retry(f func() error) error {
+	delay := dc.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		kind := classifyError(err)
+		if err == nil || kind == errorPermanent || attempt >= dc.retryAttempts {
+			if kind == errorUnavailable {
+				return fmt.Errorf("%w: %w", ErrLogindUnavailable, err)
+			}
+
+			return dc.sessionError(err)
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+
+		if kind == errorObjectGone {
+			if err := dc.resolveSession(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resolveSession looks up the session by its ID. Returns an error wrapping ErrSessionGone if
+// logind no longer knows the session or if its object path changed, since the signals are
+// subscribed using the old path. Other errors are ignored, logind might still be restarting.
+func (dc *dbusCon) resolveSession() error {
+	var path dbus.ObjectPath
+	err := dc.login1.Manager().
+		Call(login1.ManagerInterface+".GetSession", 0, dc.sessionId).
+		Store(&path)
+	switch {
+	case dbusErrorName(err) == "org.freedesktop.login1.NoSuchSession":
+		dc.markGone()
+		return fmt.Errorf("%w: %w", ErrSessionGone, err)
+	case err != nil:
+		return nil
+	case path != dc.session().Path():
+		dc.markGone()
+		return fmt.Errorf("%w: session %s moved to %s", ErrSessionGone, dc.sessionId, path)
+	}
+
+	return nil
+}
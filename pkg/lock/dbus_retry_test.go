@@ -0,0 +1,64 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryLogindRestart(t *testing.T) {
+	dc, session := newTestLock(t)
+
+	restart := func() {
+		session.mu.Lock()
+		session.removed = true
+		session.mu.Unlock()
+		time.AfterFunc(15*time.Millisecond, func() {
+			session.mu.Lock()
+			session.removed = false
+			session.mu.Unlock()
+		})
+	}
+
+	restart()
+	if err := dc.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked() error = %v", err)
+	}
+
+	restart()
+	locked, err := dc.GetLocked()
+	if err != nil {
+		t.Fatalf("GetLocked() error = %v", err)
+	}
+	if !locked {
+		t.Fatal("GetLocked() = false, want true")
+	}
+}
+
+func TestRetrySessionGone(t *testing.T) {
+	dc, session := newTestLock(t)
+	dc.retryDelay = time.Millisecond
+	session.login1.removeSession(t, session, false)
+
+	_, err := dc.GetLocked()
+	if !errors.Is(err, ErrSessionGone) {
+		t.Fatalf("GetLocked() error = %v, want ErrSessionGone", err)
+	}
+}
+
+func TestRetryLogindUnavailable(t *testing.T) {
+	dc, session := newTestLock(t)
+	dc.retryDelay = time.Millisecond
+
+	if _, err := session.login1.conn.ReleaseName("org.freedesktop.login1"); err != nil {
+		t.Fatalf("ReleaseName() error = %v", err)
+	}
+
+	err := dc.SetLocked(true)
+	if !errors.Is(err, ErrLogindUnavailable) {
+		t.Fatalf("SetLocked() error = %v, want ErrLogindUnavailable", err)
+	}
+	if err := dc.checkSession(); err != nil {
+		t.Fatalf("session marked gone while logind is unavailable: %v", err)
+	}
+}
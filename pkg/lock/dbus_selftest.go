@@ -0,0 +1,93 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"time"
+)
+
+// selfTestTimeout is how long SelfTest waits for the probe signal when ctx has no deadline.
+const selfTestTimeout = 5 * time.Second
+
+// SelfTestReport is the result of Lock.SelfTest. A nil error means the item works.
+type SelfTestReport struct {
+	// Session is the result of reading LockedHint, which requires logind to answer and the
+	// session to exist.
+	Session error
+	// Locked is the value of LockedHint, false when Session is not nil.
+	Locked bool
+	// Subscriptions are the signal subscriptions of the Lock and whether the bus still accepts
+	// their match rules.
+	Subscriptions []SubscriptionCheck
+	// Delivery is the result of sending a probe signal through the bus connection and the
+	// goroutine that dispatches the signals to the channels of the Lock.
+	Delivery error
+	// Latency is the time it took to dispatch the probe signal, zero when Delivery is not nil.
+	Latency time.Duration
+}
+
+// SubscriptionCheck is the result of checking a signal subscription, see SelfTestReport.
+type SubscriptionCheck struct {
+	// Signal is the name of the signal, e.g. org.freedesktop.login1.Session.Lock.
+	Signal string
+	Err    error
+}
+
+// Err returns the errors of all failed items joined, nil if everything works.
+func (r SelfTestReport) Err() error {
+	err := r.Session
+	if err != nil {
+		err = fmt.Errorf("session: %w", err)
+	}
+	for _, s := range r.Subscriptions {
+		if s.Err != nil {
+			err = errors.Join(err, fmt.Errorf("subscription %s: %w", s.Signal, s.Err))
+		}
+	}
+	if r.Delivery != nil {
+		err = errors.Join(err, fmt.Errorf("delivery: %w", r.Delivery))
+	}
+
+	return err
+}
+
+func (dc *dbusCon) SelfTest(ctx context.Context) (SelfTestReport, error) {
+	var report SelfTestReport
+	report.Locked, report.Session = dc.GetLocked()
+
+	dc.muSignals.Lock()
+	var subscriptions []*login1.Subscription
+	for _, s := range []*login1.Subscription{
+		dc.lockSubscription,
+		dc.unlockSubscription,
+		dc.propertiesChangedSubscription,
+		dc.seatSubscription,
+		dc.sessionRemovedSubscription,
+		dc.sessionNewSubscription,
+	} {
+		if s != nil {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	dc.muSignals.Unlock()
+
+	for _, s := range subscriptions {
+		rule := s.Rule()
+		report.Subscriptions = append(report.Subscriptions, SubscriptionCheck{
+			Signal: rule.Interface + "." + rule.Member,
+			Err:    s.Verify(ctx),
+		})
+	}
+
+	probeCtx := ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		probeCtx, cancel = context.WithTimeout(ctx, selfTestTimeout)
+		defer cancel()
+	}
+	report.Latency, report.Delivery = dc.login1.Probe(probeCtx)
+
+	return report, ctx.Err()
+}
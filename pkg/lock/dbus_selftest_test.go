@@ -0,0 +1,56 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"testing"
+	"time"
+)
+
+func TestSelfTest(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	session := logind.sessions[0]
+	session.set("LockedHint", true)
+	if err := dc.AddLockSignal(make(chan struct{})); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+
+	report, err := dc.SelfTest(context.Background())
+	if err != nil || report.Err() != nil {
+		t.Fatalf("SelfTest() = %+v, %v, want no errors", report, err)
+	}
+	if !report.Locked || report.Latency <= 0 {
+		t.Fatalf("SelfTest() = %+v, want locked and the latency of the probe", report)
+	}
+	if len(report.Subscriptions) != 1 || report.Subscriptions[0].Signal != "org.freedesktop.login1.Session.Lock" {
+		t.Fatalf("SelfTest().Subscriptions = %+v, want the Lock signal", report.Subscriptions)
+	}
+
+	// A handler that does not return keeps the signals from being delivered
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_, err = dc.login1.Subscribe(dc.rule(session.path, login1.SessionInterface, "Lock"), func(*dbus.Signal) {
+		close(started)
+		<-release
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := logind.conn.Emit(session.path, login1.SessionInterface+".Lock"); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report, err = dc.SelfTest(ctx)
+	close(release)
+	if !errors.Is(report.Delivery, context.DeadlineExceeded) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SelfTest() with a stuck dispatch = %+v, %v, want the delivery to fail", report, err)
+	}
+	if report.Session != nil {
+		t.Fatalf("SelfTest().Session = %v, reading the state does not depend on dispatch", report.Session)
+	}
+}
@@ -0,0 +1,142 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+)
+
+func (dc *dbusCon) AddSessionRemovedSignal(c chan<- struct{}) error {
+	if c == nil {
+		return errors.New("AddSessionRemovedSignal: channel cannot be nil")
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	if err := dc.subscribeSessionRemoved(); err != nil {
+		return err
+	}
+	dc.sessionRemovedSignals[c] = struct{}{}
+
+	return nil
+}
+
+// subscribeSessionRemoved subscribes to the SessionRemoved signal of the manager if not yet
+// subscribed.
+// Holding the muSignals mutex is required.
+func (dc *dbusCon) subscribeSessionRemoved() error {
+	if dc.sessionRemovedSubscription != nil {
+		return nil
+	}
+
+	subscription, err := dc.login1.Subscribe(
+		dc.rule(login1.ManagerPath, login1.ManagerInterface, "SessionRemoved"),
+		dc.handleSessionRemoved,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register Dbus SessionRemoved signal: %w", err)
+	}
+	dc.sessionRemovedSubscription = subscription
+
+	return nil
+}
+
+func (dc *dbusCon) RemoveSessionRemovedSignal(c chan<- struct{}) error {
+	if c == nil {
+		return errors.New("RemoveSessionRemovedSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	delete(dc.sessionRemovedSignals, c)
+
+	// WithRecreateGrace needs the signal for as long as the Lock is open
+	if len(dc.sessionRemovedSignals) == 0 && dc.recreateGrace == 0 {
+		if err := unsubscribe(&dc.sessionRemovedSubscription); err != nil {
+			return fmt.Errorf("failed to remove Dbus SessionRemoved signal: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (dc *dbusCon) ReplaceSessionRemovedSignal(old chan<- struct{}, new chan<- struct{}) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.sessionRemovedSignals, old, new)
+}
+
+// handleSessionRemoved handles the SessionRemoved signal of the manager. The signals of the
+// session object are handled by handleIncomingSignal.
+func (dc *dbusCon) handleSessionRemoved(s *dbus.Signal) {
+	if len(s.Body) < 2 {
+		return
+	}
+
+	path, ok := s.Body[1].(dbus.ObjectPath)
+	if !ok || path != dc.session().Path() {
+		return
+	}
+
+	if dc.recreateGrace > 0 {
+		dc.awaitRecreate()
+		return
+	}
+
+	dc.sessionRemoved()
+}
+
+// sessionRemoved marks the session as gone and notifies the SessionRemoved channels. The channels
+// are only notified the first time.
+func (dc *dbusCon) sessionRemoved() {
+	dc.gone.Store(true)
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+	if dc.sessionRemovedNotified {
+		return
+	}
+	dc.sessionRemovedNotified = true
+	for c := range dc.sessionRemovedSignals {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// checkSession returns ErrSessionGone if the session has been removed.
+func (dc *dbusCon) checkSession() error {
+	if dc.gone.Load() {
+		return ErrSessionGone
+	}
+
+	return nil
+}
+
+// sessionError translates the error of a call on the session object. If the object no longer
+// exists, the session is marked as gone and ErrSessionGone is returned.
+func (dc *dbusCon) sessionError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if classifyError(err) == errorObjectGone {
+		// The channels are notified when the SessionRemoved signal arrives
+		dc.markGone()
+	}
+
+	if dc.gone.Load() {
+		return fmt.Errorf("%w: %w", ErrSessionGone, err)
+	}
+
+	return err
+}
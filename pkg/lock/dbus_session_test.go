@@ -0,0 +1,59 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionRemovedSignal(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	other := logind.addSession(t, "2")
+
+	c := make(chan struct{}, 2)
+	if err := dc.AddSessionRemovedSignal(c); err != nil {
+		t.Fatalf("AddSessionRemovedSignal() error = %v", err)
+	}
+
+	// Removal of another session is ignored
+	logind.removeSession(t, other, true)
+	logind.removeSession(t, logind.sessions[0], true)
+
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for SessionRemoved")
+	}
+
+	select {
+	case <-c:
+		t.Fatal("SessionRemoved delivered more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := dc.GetLocked(); !errors.Is(err, ErrSessionGone) {
+		t.Errorf("GetLocked() error = %v, want ErrSessionGone", err)
+	}
+	if err := dc.SetLocked(true); !errors.Is(err, ErrSessionGone) {
+		t.Errorf("SetLocked() error = %v, want ErrSessionGone", err)
+	}
+	if err := dc.AddLockSignal(make(chan struct{})); !errors.Is(err, ErrSessionGone) {
+		t.Errorf("AddLockSignal() error = %v, want ErrSessionGone", err)
+	}
+	if err := dc.RemoveSessionRemovedSignal(c); err != nil {
+		t.Errorf("RemoveSessionRemovedSignal() error = %v", err)
+	}
+}
+
+func TestSessionGoneWithoutSignal(t *testing.T) {
+	dc, session := newTestLock(t)
+
+	session.login1.removeSession(t, session, false)
+
+	if _, err := dc.GetLocked(); !errors.Is(err, ErrSessionGone) {
+		t.Fatalf("GetLocked() error = %v, want ErrSessionGone", err)
+	}
+	if _, err := dc.GetVTNr(); !errors.Is(err, ErrSessionGone) {
+		t.Fatalf("GetVTNr() error = %v, want ErrSessionGone", err)
+	}
+}
@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+)
+
+// SessionState is the state of a session as reported by logind.
+type SessionState string
+
+const (
+	// SessionStateOnline means the session is logged in but not in the foreground.
+	SessionStateOnline SessionState = "online"
+	// SessionStateActive means the session is logged in and in the foreground.
+	SessionStateActive SessionState = "active"
+	// SessionStateClosing means the session is being logged out but some of its processes are
+	// still running.
+	SessionStateClosing SessionState = "closing"
+)
+
+func (dc *dbusCon) GetState() (SessionState, error) {
+	if err := dc.checkSession(); err != nil {
+		return "", err
+	}
+
+	variant, err := dc.session().GetProperty(login1.SessionInterface + ".State")
+	if err != nil {
+		return "", fmt.Errorf("could not get State: %w", dc.sessionError(err))
+	}
+
+	state, ok := variant.Value().(string)
+	if !ok {
+		return "", fmt.Errorf("State property result is not a string")
+	}
+
+	return SessionState(state), nil
+}
+
+func (dc *dbusCon) AddStateSignal(c chan<- SessionState) error {
+	if c == nil {
+		return errors.New("AddStateSignal: channel cannot be nil")
+	}
+
+	if err := dc.checkSession(); err != nil {
+		return err
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	if err := dc.subscribePropertiesChanged(); err != nil {
+		return err
+	}
+	dc.stateSignals[c] = struct{}{}
+
+	return nil
+}
+
+func (dc *dbusCon) RemoveStateSignal(c chan<- SessionState) error {
+	if c == nil {
+		return errors.New("RemoveStateSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	delete(dc.stateSignals, c)
+
+	return dc.unsubscribePropertiesChangedIfUnused()
+}
+
+func (dc *dbusCon) ReplaceStateSignal(old chan<- SessionState, new chan<- SessionState) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.stateSignals, old, new)
+}
+
+// deliverState notifies the state channels. A closing session is treated as removed, unless the
+// Lock waits for SessionRemoved to follow a recreated session, see WithRecreateGrace.
+func (dc *dbusCon) deliverState(state SessionState) {
+	dc.muSignals.Lock()
+	for c := range dc.stateSignals {
+		select {
+		case c <- state:
+		default:
+		}
+	}
+	dc.muSignals.Unlock()
+
+	if state == SessionStateClosing && dc.recreateGrace == 0 {
+		dc.sessionRemoved()
+	}
+}
@@ -0,0 +1,65 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStateSignal(t *testing.T) {
+	dc, session := newTestLock(t)
+
+	state, err := dc.GetState()
+	if err != nil {
+		t.Fatalf("GetState() error = %v", err)
+	}
+	if state != SessionStateActive {
+		t.Fatalf("GetState() = %q, want %q", state, SessionStateActive)
+	}
+
+	states := make(chan SessionState, 4)
+	removed := make(chan struct{}, 1)
+	locked := make(chan bool, 4)
+	if err := dc.AddStateSignal(states); err != nil {
+		t.Fatalf("AddStateSignal() error = %v", err)
+	}
+	if err := dc.AddSessionRemovedSignal(removed); err != nil {
+		t.Fatalf("AddSessionRemovedSignal() error = %v", err)
+	}
+	if err := dc.AddLockedSignal(locked); err != nil {
+		t.Fatalf("AddLockedSignal() error = %v", err)
+	}
+
+	receive := func(want SessionState) {
+		t.Helper()
+		select {
+		case got := <-states:
+			if got != want {
+				t.Fatalf("received state %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for state %q", want)
+		}
+	}
+
+	session.setProperty("State", "online")
+	receive(SessionStateOnline)
+
+	// Both use the PropertiesChanged subscription, removing one keeps the other working
+	if err := dc.RemoveLockedSignal(locked); err != nil {
+		t.Fatalf("RemoveLockedSignal() error = %v", err)
+	}
+
+	session.setProperty("State", "closing")
+	receive(SessionStateClosing)
+
+	select {
+	case <-removed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing state not treated as session removal")
+	}
+
+	if _, err := dc.GetState(); !errors.Is(err, ErrSessionGone) {
+		t.Errorf("GetState() error = %v, want ErrSessionGone", err)
+	}
+}
@@ -0,0 +1,778 @@
+package lock
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const testSessionPath = dbus.ObjectPath("/org/freedesktop/login1/session/_31")
+
+// fakeSessionObject is a dbus.BusObject for a login1 session that serves properties from a map.
+// Methods that are not overridden panic.
+type fakeSessionObject struct {
+	dbus.BusObject
+	properties map[string]interface{}
+}
+
+func (o *fakeSessionObject) Path() dbus.ObjectPath {
+	return testSessionPath
+}
+
+func (o *fakeSessionObject) GetProperty(p string) (dbus.Variant, error) {
+	v, ok := o.properties[p]
+	if !ok {
+		return dbus.Variant{}, dbus.MakeFailedError(dbus.ErrMsgUnknownInterface)
+	}
+
+	return dbus.MakeVariant(v), nil
+}
+
+func newTestDbusCon(properties map[string]interface{}) *dbusCon {
+	return &dbusCon{
+		loginSessionObject: &fakeSessionObject{properties: properties},
+		lockSignals:        make(map[chan<- struct{}]struct{}),
+		unlockSignals:      make(map[chan<- struct{}]struct{}),
+		lockedHintSignals:  make(map[chan<- bool]struct{}),
+		pendingLockedState: make(map[chan<- bool]*pendingState),
+		vtSignals:          make(map[chan<- uint32]struct{}),
+		lockStateSignals:   make(map[chan<- LockEvent]struct{}),
+		propertyChanges:    make(map[string]uint64),
+		closed:             make(chan struct{}),
+	}
+}
+
+func propertiesChanged(body ...interface{}) *dbus.Signal {
+	return &dbus.Signal{
+		Path: testSessionPath,
+		Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+		Body: body,
+	}
+}
+
+func TestOptionalInterfaces(t *testing.T) {
+	dc, _ := newTestLock(t)
+	var l Lock = dc
+
+	if _, ok := l.(LockedStateSignaler); !ok {
+		t.Error("Lock does not implement LockedStateSignaler")
+	}
+	if _, ok := l.(VTWatcher); !ok {
+		t.Error("Lock does not implement VTWatcher")
+	}
+	if _, ok := l.(SignalReplacer); !ok {
+		t.Error("Lock does not implement SignalReplacer")
+	}
+	if _, ok := l.(SessionRemovalNotifier); !ok {
+		t.Error("Lock does not implement SessionRemovalNotifier")
+	}
+	if _, ok := l.(SessionStateWatcher); !ok {
+		t.Error("Lock does not implement SessionStateWatcher")
+	}
+	if _, ok := l.(LockStateSignaler); !ok {
+		t.Error("Lock does not implement LockStateSignaler")
+	}
+	if _, ok := l.(SessionRecreationNotifier); !ok {
+		t.Error("Lock does not implement SessionRecreationNotifier")
+	}
+	if _, ok := l.(HintConflictNotifier); !ok {
+		t.Error("Lock does not implement HintConflictNotifier")
+	}
+	if _, ok := l.(LockSignalCoalescer); !ok {
+		t.Error("Lock does not implement LockSignalCoalescer")
+	}
+	if _, ok := l.(LockIterator); !ok {
+		t.Error("Lock does not implement LockIterator")
+	}
+	if _, ok := l.(SelfTester); !ok {
+		t.Error("Lock does not implement SelfTester")
+	}
+	if _, ok := l.(AutoLockSession); !ok {
+		t.Error("Lock does not implement AutoLockSession")
+	}
+}
+
+func TestHandleIncomingSignalLockedHint(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal *dbus.Signal
+		want   bool
+	}{
+		{
+			name: "changed",
+			signal: propertiesChanged(
+				"org.freedesktop.login1.Session",
+				map[string]dbus.Variant{"LockedHint": dbus.MakeVariant(true)},
+				[]string{},
+			),
+			want: true,
+		},
+		{
+			name: "invalidated",
+			signal: propertiesChanged(
+				"org.freedesktop.login1.Session",
+				map[string]dbus.Variant{},
+				[]string{"LockedHint"},
+			),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newTestDbusCon(map[string]interface{}{
+				"org.freedesktop.login1.Session.LockedHint": false,
+			})
+			c := make(chan bool, 1)
+			dc.lockedHintSignals[c] = struct{}{}
+
+			dc.handleIncomingSignal(tt.signal)
+
+			// An invalidated LockedHint is read off the dispatch goroutine
+			select {
+			case got := <-c:
+				if got != tt.want {
+					t.Fatalf("received %t, want %t", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no LockedHint delivered")
+			}
+		})
+	}
+}
+
+func TestInvalidatedLockedHintDoesNotBlockDispatch(t *testing.T) {
+	dc, session := newTestLock(t)
+	lockedHint := make(chan bool, 2)
+	if err := dc.AddLockedSignal(lockedHint); err != nil {
+		t.Fatalf("AddLockedSignal() error = %v", err)
+	}
+	lock := make(chan struct{}, 1)
+	if err := dc.AddLockSignal(lock); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+
+	// Reading the invalidated LockedHint does not return until released
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	session.setOnGet(func(name string) {
+		close(reading)
+		<-release
+	})
+	session.invalidateProperty("LockedHint", true)
+	<-reading
+
+	err := session.login1.conn.Emit(session.path, "org.freedesktop.login1.Session.Lock")
+	if err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	select {
+	case <-lock:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("Lock signal was not delivered while an invalidated LockedHint was read")
+	}
+
+	close(release)
+	select {
+	case got := <-lockedHint:
+		if !got {
+			t.Fatalf("received %t, want true", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalidated LockedHint was not delivered")
+	}
+}
+
+func TestInvalidatedLockedHintStale(t *testing.T) {
+	dc, session := newTestLock(t)
+	lockedHint := make(chan bool, 2)
+	if err := dc.AddLockedSignal(lockedHint); err != nil {
+		t.Fatalf("AddLockedSignal() error = %v", err)
+	}
+
+	// The value is read before it changes again, and returned after that change is delivered
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	session.setOnGet(func(name string) {
+		close(reading)
+		<-release
+	})
+	session.invalidateProperty("LockedHint", false)
+	<-reading
+	session.setProperty("LockedHint", true)
+
+	select {
+	case got := <-lockedHint:
+		if !got {
+			t.Fatalf("received %t, want true", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LockedHint was not delivered")
+	}
+
+	close(release)
+	waitOffloaded(t, dc)
+	if len(lockedHint) != 0 {
+		t.Fatalf("stale LockedHint %t delivered after a newer value", <-lockedHint)
+	}
+}
+
+// waitOffloaded waits until the functions offloaded by the Lock have run.
+func waitOffloaded(t *testing.T, dc *dbusCon) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dc.muOffload.Lock()
+		offloading := dc.offloading
+		dc.muOffload.Unlock()
+		if !offloading {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the offloaded functions")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandleIncomingSignalMalformed(t *testing.T) {
+	signals := []*dbus.Signal{
+		propertiesChanged(),
+		propertiesChanged("org.freedesktop.login1.Session"),
+		propertiesChanged("org.freedesktop.login1.Session", "not a map", []string{}),
+		propertiesChanged(
+			"org.freedesktop.login1.Session",
+			map[string]dbus.Variant{"LockedHint": dbus.MakeVariant("yes")},
+			[]string{},
+		),
+		propertiesChanged(
+			"org.freedesktop.login1.Session",
+			map[string]dbus.Variant{},
+			"not a slice",
+		),
+		propertiesChanged(
+			"org.freedesktop.login1.Session",
+			map[string]dbus.Variant{},
+			[]string{"Active"},
+		),
+		propertiesChanged(
+			42,
+			map[string]dbus.Variant{"LockedHint": dbus.MakeVariant(true)},
+			[]string{},
+		),
+	}
+
+	dc := newTestDbusCon(map[string]interface{}{})
+	c := make(chan bool, len(signals))
+	dc.lockedHintSignals[c] = struct{}{}
+
+	for _, s := range signals {
+		dc.handleIncomingSignal(s)
+	}
+
+	if len(c) != 0 {
+		t.Fatalf("malformed signals delivered %d values", len(c))
+	}
+}
+
+func TestHandleIncomingSignalForeignInterface(t *testing.T) {
+	signals := []*dbus.Signal{
+		propertiesChanged(
+			"org.example.Other",
+			map[string]dbus.Variant{"LockedHint": dbus.MakeVariant(true)},
+			[]string{},
+		),
+		propertiesChanged(
+			"org.example.Other",
+			map[string]dbus.Variant{},
+			[]string{"LockedHint"},
+		),
+	}
+
+	dc := newTestDbusCon(map[string]interface{}{
+		"org.freedesktop.login1.Session.LockedHint": false,
+	})
+	c := make(chan bool, len(signals))
+	dc.lockedHintSignals[c] = struct{}{}
+
+	for _, s := range signals {
+		dc.handleIncomingSignal(s)
+	}
+
+	if len(c) != 0 {
+		t.Fatalf("signals of another interface delivered %d values", len(c))
+	}
+}
+
+func TestFindSessionPath(t *testing.T) {
+	sessions := []interface{}{
+		[]interface{}{"2", uint32(1000), "user", "seat0", dbus.ObjectPath("/org/freedesktop/login1/session/_32")},
+		// Extra trailing field as could be added by future versions of logind
+		[]interface{}{"3", uint32(1000), "user", "seat0", testSessionPath, "extra"},
+	}
+
+	path, err := findSessionPath(sessions, "3")
+	if err != nil {
+		t.Fatalf("findSessionPath() error = %v", err)
+	}
+	if path != testSessionPath {
+		t.Fatalf("findSessionPath() = %s, want %s", path, testSessionPath)
+	}
+
+	_, err = findSessionPath(sessions, "4")
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("findSessionPath() for unknown session error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func FuzzFindSessionPath(f *testing.F) {
+	f.Add("3", uint32(1000), "/org/freedesktop/login1/session/_33", uint8(0), "3")
+	f.Add("3", uint32(1000), "/org/freedesktop/login1/session/_33", uint8(0xff), "3")
+	f.Add("", uint32(0), "", uint8(0x04), "")
+
+	f.Fuzz(func(t *testing.T, id string, uid uint32, path string, shape uint8, target string) {
+		var entry []interface{}
+		if shape&0x01 == 0 {
+			entry = append(entry, id)
+		} else {
+			entry = append(entry, uid)
+		}
+		entry = append(entry, uid, "user", "seat0")
+		if shape&0x02 == 0 {
+			entry = append(entry, dbus.ObjectPath(path))
+		} else {
+			entry = append(entry, path)
+		}
+		if shape&0x04 != 0 {
+			entry = entry[:shape%5]
+		}
+		if shape&0x08 != 0 {
+			entry = append(entry, "extra", uint64(shape))
+		}
+
+		var session interface{} = entry
+		if shape&0x10 != 0 {
+			session = id
+		}
+
+		got, err := findSessionPath([]interface{}{session}, target)
+		if err != nil {
+			if !errors.Is(err, ErrSessionNotFound) && !errors.Is(err, ErrMalformedSessionList) {
+				t.Fatalf("findSessionPath() returned untyped error: %v", err)
+			}
+			return
+		}
+
+		if id != target {
+			t.Fatalf("findSessionPath() matched session %q while looking for %q", id, target)
+		}
+		if string(got) != path || !got.IsValid() {
+			t.Fatalf("findSessionPath() = %q, want valid path %q", got, path)
+		}
+	})
+}
+
+func TestAddLockedSignalWithState(t *testing.T) {
+	dc, session := newTestLock(t)
+
+	// Toggle the property after the match rule has been installed but before the state is
+	// returned to the Lock.
+	session.setOnGet(func(name string) {
+		session.setProperty("LockedHint", true)
+	})
+
+	c := make(chan bool, 2)
+	if err := dc.AddLockedSignalWithState(c); err != nil {
+		t.Fatalf("AddLockedSignalWithState() error = %v", err)
+	}
+
+	for _, want := range []bool{false, true} {
+		select {
+		case got := <-c:
+			if got != want {
+				t.Fatalf("received %t, want %t", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %t", want)
+		}
+	}
+}
+
+func TestAddLockedSignalWithStateDoesNotBlockDispatch(t *testing.T) {
+	bus := dbustest.New(t)
+	logind := newFakeLogin1(t, bus)
+	session := logind.addSession(t, "1")
+	conn := bus.Connect(t)
+
+	newLock := func() *dbusCon {
+		t.Helper()
+
+		dc, err := newDbusCon(login1.New(conn), session.id)
+		if err != nil {
+			t.Fatalf("newDbusCon() error = %v", err)
+		}
+		t.Cleanup(func() {
+			_ = dc.Close()
+		})
+
+		return dc
+	}
+	slow := newLock()
+	other := newLock()
+
+	otherLock := make(chan struct{}, 1)
+	if err := other.AddLockSignal(otherLock); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+
+	// GetLocked of the slow Lock does not return until released
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	session.setOnGet(func(name string) {
+		close(reading)
+		<-release
+	})
+
+	c := make(chan bool, 2)
+	added := make(chan error, 1)
+	go func() {
+		added <- slow.AddLockedSignalWithState(c)
+	}()
+	<-reading
+
+	// The handler of the slow Lock runs on the dispatch goroutine before that of the Lock signal
+	session.setProperty("LockedHint", true)
+	err := session.login1.conn.Emit(session.path, "org.freedesktop.login1.Session.Lock")
+	if err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	select {
+	case <-otherLock:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("Lock signal was not delivered while the state of another Lock was read")
+	}
+
+	close(release)
+	if err := <-added; err != nil {
+		t.Fatalf("AddLockedSignalWithState() error = %v", err)
+	}
+	// The state read before the change is delivered first, then the change
+	for _, want := range []bool{false, true} {
+		select {
+		case got := <-c:
+			if got != want {
+				t.Fatalf("received %t, want %t", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %t", want)
+		}
+	}
+}
+
+func TestVTChangeSignal(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+	session := logind.sessions[0]
+	session.set("VTNr", uint32(2))
+	other := logind.addSession(t, "2")
+	other.set("VTNr", uint32(3))
+	logind.seat.set("ActiveSession", fakeObjectRef{ID: session.id, Path: session.path})
+
+	vtNr, err := dc.GetVTNr()
+	if err != nil || vtNr != 2 {
+		t.Fatalf("GetVTNr() = %d, %v, want 2", vtNr, err)
+	}
+
+	c := make(chan uint32, 1)
+	if err := dc.AddVTChangeSignal(c); err != nil {
+		t.Fatalf("AddVTChangeSignal() error = %v", err)
+	}
+
+	for want, s := range map[uint32]*fakeSession{3: other, 2: session} {
+		logind.seat.setProperty("ActiveSession", fakeObjectRef{ID: s.id, Path: s.path})
+
+		select {
+		case got := <-c:
+			if got != want {
+				t.Fatalf("received VT %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for VT %d", want)
+		}
+	}
+}
+
+func TestVTNotApplicable(t *testing.T) {
+	dc, logind := newTestLockWithLogin1(t)
+
+	if _, err := dc.GetVTNr(); !errors.Is(err, ErrNoVT) {
+		t.Fatalf("GetVTNr() error = %v, want ErrNoVT", err)
+	}
+
+	logind.sessions[0].set("VTNr", uint32(2))
+	logind.seat.set("CanTTY", false)
+	if err := dc.AddVTChangeSignal(make(chan uint32)); !errors.Is(err, ErrNoVT) {
+		t.Fatalf("AddVTChangeSignal() error = %v, want ErrNoVT", err)
+	}
+}
+
+func TestCloseDoesNotLeak(t *testing.T) {
+	bus := dbustest.New(t)
+	logind := newFakeLogin1(t, bus)
+	logind.addSession(t, "1")
+
+	createAndClose := func() {
+		conn, err := dbus.Connect(bus.Address)
+		if err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+
+		dc, err := newDbusCon(login1.NewOwned(conn), "1")
+		if err != nil {
+			t.Fatalf("newDbusCon() error = %v", err)
+		}
+		if err := dc.AddLockSignal(make(chan struct{})); err != nil {
+			t.Fatalf("AddLockSignal() error = %v", err)
+		}
+		if err := dc.AddLockedSignal(make(chan bool)); err != nil {
+			t.Fatalf("AddLockedSignal() error = %v", err)
+		}
+		if err := dc.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if err := dc.Close(); err != nil {
+			t.Fatalf("second Close() error = %v", err)
+		}
+	}
+
+	// Warm up so that lazily started goroutines of the runtime and packages are accounted for
+	createAndClose()
+	goroutines := runtime.NumGoroutine()
+	fds := countOpenFiles(t)
+
+	for range 50 {
+		createAndClose()
+	}
+
+	// Goroutines of closed connections might take a moment to exit
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("goroutines grew from %d to %d", goroutines, got)
+	}
+	if got := countOpenFiles(t); got > fds {
+		t.Errorf("open file descriptors grew from %d to %d", fds, got)
+	}
+}
+
+func countOpenFiles(t *testing.T) int {
+	t.Helper()
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("unable to count open file descriptors: %v", err)
+	}
+
+	return len(entries)
+}
+
+func TestReplaceLockSignal(t *testing.T) {
+	dc, session := newTestLock(t)
+
+	const signals = 500
+	a := make(chan struct{}, signals)
+	b := make(chan struct{}, signals)
+	if err := dc.AddLockSignal(a); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+
+	if err := dc.ReplaceLockSignal(b, a); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("ReplaceLockSignal() with unregistered channel error = %v, want ErrNotRegistered", err)
+	}
+
+	stop := make(chan struct{})
+	replaced := make(chan error, 1)
+	go func() {
+		current, other := a, b
+		for {
+			select {
+			case <-stop:
+				replaced <- nil
+				return
+			default:
+			}
+
+			if err := dc.ReplaceLockSignal(current, other); err != nil {
+				replaced <- err
+				return
+			}
+			current, other = other, current
+		}
+	}()
+
+	for range signals {
+		err := session.login1.conn.Emit(session.path, "org.freedesktop.login1.Session.Lock")
+		if err != nil {
+			t.Fatalf("Emit() error = %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(a)+len(b) < signals && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	if err := <-replaced; err != nil {
+		t.Fatalf("ReplaceLockSignal() error = %v", err)
+	}
+
+	if got := len(a) + len(b); got != signals {
+		t.Fatalf("received %d signals over both channels, want %d", got, signals)
+	}
+}
+
+func TestWithConnSharedSession(t *testing.T) {
+	bus := dbustest.New(t)
+	logind := newFakeLogin1(t, bus)
+	session := logind.addSession(t, "1")
+	conn := bus.Connect(t)
+
+	newLock := func() (Lock, chan struct{}) {
+		t.Helper()
+
+		l, err := NewDbusSessionLock(session.id, WithConn(conn))
+		if err != nil {
+			t.Fatalf("NewDbusSessionLock() error = %v", err)
+		}
+		t.Cleanup(func() {
+			_ = l.Close()
+		})
+		c := make(chan struct{}, 10)
+		if err := l.AddLockSignal(c); err != nil {
+			t.Fatalf("AddLockSignal() error = %v", err)
+		}
+
+		return l, c
+	}
+	first, firstLock := newLock()
+	second, secondLock := newLock()
+
+	emitLock := func() {
+		t.Helper()
+
+		err := session.login1.conn.Emit(session.path, "org.freedesktop.login1.Session.Lock")
+		if err != nil {
+			t.Fatalf("Emit() error = %v", err)
+		}
+	}
+	receive := func(c <-chan struct{}, which string) {
+		t.Helper()
+
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s Lock did not receive the Lock signal", which)
+		}
+	}
+
+	emitLock()
+	receive(firstLock, "first")
+	receive(secondLock, "second")
+
+	// Removing the last channel of the first Lock removes its subscription, not the match rule
+	if err := first.RemoveLockSignal(firstLock); err != nil {
+		t.Fatalf("RemoveLockSignal() error = %v", err)
+	}
+	emitLock()
+	receive(secondLock, "second")
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	emitLock()
+	receive(secondLock, "second")
+	if len(firstLock) != 0 {
+		t.Fatal("first Lock received a signal after removing its channel")
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !conn.Connected() {
+		t.Fatal("Close() closed the connection given by WithConn")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dc, session := newTestLock(t)
+	dc.readOnly = true
+
+	if err := dc.SetLocked(true); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("SetLocked() error = %v, want ErrReadOnly", err)
+	}
+
+	session.mu.Lock()
+	locked := session.properties["LockedHint"]
+	session.mu.Unlock()
+	if locked != false {
+		t.Fatalf("LockedHint = %v after read-only SetLocked, want false", locked)
+	}
+
+	if _, err := dc.GetLocked(); err != nil {
+		t.Fatalf("GetLocked() error = %v", err)
+	}
+}
+
+func TestMatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		loose bool
+	}{
+		{"strict", false},
+		{"loose", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc, session := newTestLock(t)
+			dc.looseMatching = tt.loose
+			impostor := session.login1.bus.Connect(t)
+
+			c := make(chan struct{}, 10)
+			if err := dc.AddLockSignal(c); err != nil {
+				t.Fatalf("AddLockSignal() error = %v", err)
+			}
+
+			err := impostor.Emit(session.path, "org.freedesktop.login1.Session.Lock")
+			if err != nil {
+				t.Fatalf("Emit() error = %v", err)
+			}
+			err = session.login1.conn.Emit(session.path, "org.freedesktop.login1.Session.Lock")
+			if err != nil {
+				t.Fatalf("Emit() error = %v", err)
+			}
+
+			select {
+			case <-c:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for Lock")
+			}
+
+			// Wait for a potential signal of the impostor
+			time.Sleep(50 * time.Millisecond)
+			if len(c) != 0 {
+				t.Fatal("received Lock emitted by a connection other than logind")
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"slices"
+)
+
+func (dc *dbusCon) GetVTNr() (uint32, error) {
+	if err := dc.checkSession(); err != nil {
+		return 0, err
+	}
+
+	vtNr, err := getVTNr(dc.session())
+	return vtNr, dc.sessionError(err)
+}
+
+// getVTNr returns the VTNr property of the given session object.
+func getVTNr(session dbus.BusObject) (uint32, error) {
+	variant, err := session.GetProperty(login1.SessionInterface + ".VTNr")
+	if err != nil {
+		return 0, fmt.Errorf("could not get VTNr: %w", err)
+	}
+
+	vtNr, ok := variant.Value().(uint32)
+	if !ok {
+		return 0, fmt.Errorf("VTNr property result is not a uint32")
+	}
+
+	if vtNr == 0 {
+		return 0, ErrNoVT
+	}
+
+	return vtNr, nil
+}
+
+func (dc *dbusCon) AddVTChangeSignal(c chan<- uint32) error {
+	if c == nil {
+		return errors.New("AddVTChangeSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	if dc.seatSubscription == nil {
+		if _, err := dc.GetVTNr(); err != nil {
+			return err
+		}
+
+		seat, err := dc.getSeat()
+		if err != nil {
+			return err
+		}
+
+		subscription, err := dc.login1.Subscribe(
+			dc.rule(seat.Path(), login1.PropertiesInterface, "PropertiesChanged"),
+			dc.handleSeatSignal,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to register Dbus signal for ActiveSession: %w", err)
+		}
+
+		dc.seatObject = seat
+		dc.seatSubscription = subscription
+	}
+
+	dc.vtSignals[c] = struct{}{}
+
+	return nil
+}
+
+func (dc *dbusCon) RemoveVTChangeSignal(c chan<- uint32) error {
+	if c == nil {
+		return errors.New("RemoveVTChangeSignal: channel cannot be nil")
+	}
+
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	delete(dc.vtSignals, c)
+
+	if len(dc.vtSignals) == 0 {
+		if err := unsubscribe(&dc.seatSubscription); err != nil {
+			return fmt.Errorf("failed to remove Dbus PropertiesChanged signal of seat: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (dc *dbusCon) ReplaceVTChangeSignal(old chan<- uint32, new chan<- uint32) error {
+	dc.muSignals.Lock()
+	defer dc.muSignals.Unlock()
+
+	return replaceSignal(dc.vtSignals, old, new)
+}
+
+// getSeat returns the seat of the session. Returns ErrNoVT if the session has no seat or the
+// seat does not support TTYs.
+func (dc *dbusCon) getSeat() (dbus.BusObject, error) {
+	variant, err := dc.session().GetProperty(login1.SessionInterface + ".Seat")
+	if err != nil {
+		return nil, fmt.Errorf("could not get Seat: %w", err)
+	}
+
+	_, seatPath, err := parseObjectRef(variant.Value())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Seat property: %w", err)
+	}
+
+	if seatPath == "/" {
+		return nil, ErrNoVT
+	}
+
+	seat := dc.login1.Object(seatPath)
+	variant, err = seat.GetProperty("org.freedesktop.login1.Seat.CanTTY")
+	if err != nil {
+		return nil, fmt.Errorf("could not get CanTTY: %w", err)
+	}
+
+	canTTY, ok := variant.Value().(bool)
+	if !ok {
+		return nil, fmt.Errorf("CanTTY property result is not a boolean")
+	}
+
+	if !canTTY {
+		return nil, ErrNoVT
+	}
+
+	return seat, nil
+}
+
+// parseObjectRef parses the (so) structs logind uses to reference other objects.
+func parseObjectRef(v interface{}) (string, dbus.ObjectPath, error) {
+	var id string
+	var path dbus.ObjectPath
+	fields, ok := v.([]interface{})
+	if !ok || len(fields) != 2 {
+		return "", "", fmt.Errorf("%v is not an (so) struct", v)
+	}
+
+	if err := dbus.Store(fields, &id, &path); err != nil {
+		return "", "", err
+	}
+
+	return id, path, nil
+}
+
+func (dc *dbusCon) handleSeatSignal(s *dbus.Signal) {
+	if len(s.Body) < 3 {
+		return
+	}
+
+	if iface, ok := s.Body[0].(string); !ok || iface != "org.freedesktop.login1.Seat" {
+		return
+	}
+
+	changedProperties, ok := s.Body[1].(map[string]dbus.Variant)
+	if !ok {
+		return
+	}
+	invalidatedProperties, _ := s.Body[2].([]string)
+
+	activeSession, hasActiveSession := changedProperties["ActiveSession"]
+	if !hasActiveSession && !slices.Contains(invalidatedProperties, "ActiveSession") {
+		return
+	}
+
+	// Handlers must not make method calls, reading the VT of the session is offloaded
+	dc.offload(func() {
+		if !hasActiveSession {
+			dc.muSignals.Lock()
+			seat := dc.seatObject
+			dc.muSignals.Unlock()
+			if seat == nil {
+				return
+			}
+
+			var err error
+			activeSession, err = seat.GetProperty("org.freedesktop.login1.Seat.ActiveSession")
+			if err != nil {
+				return
+			}
+		}
+
+		_, sessionPath, err := parseObjectRef(activeSession.Value())
+		if err != nil || sessionPath == "/" {
+			return
+		}
+
+		vtNr, err := getVTNr(dc.login1.Object(sessionPath))
+		if err != nil {
+			return
+		}
+
+		dc.muSignals.Lock()
+		defer dc.muSignals.Unlock()
+		for c := range dc.vtSignals {
+			select {
+			case c <- vtNr:
+			default:
+			}
+		}
+	})
+}
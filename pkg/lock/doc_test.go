@@ -1,6 +1,8 @@
 package lock_test
 
 import (
+	"context"
+	"errors"
 	"github.com/MatthiasKunnen/system/pkg/lock"
 	"log"
 	"os"
@@ -65,3 +67,32 @@ func ExampleLock_dbus() {
 		}
 	}
 }
+
+func ExampleLockIterator() {
+	l, err := lock.NewDbusSessionLock(os.Getenv("XDG_SESSION_ID"))
+	if err != nil {
+		log.Fatalf("Failed to initialize dbus lock: %v", err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	iterator, ok := l.(lock.LockIterator)
+	if !ok {
+		log.Fatal("The Lock cannot iterate over its signals")
+	}
+
+	for locked, err := range iterator.LockedTransitions(ctx) {
+		switch {
+		case errors.Is(err, lock.ErrTransitionsDropped):
+			log.Printf("Fell behind: %v", err)
+		case err != nil:
+			log.Fatalf("Failed to follow the locked state: %v", err)
+		case locked:
+			log.Println("The system is now locked")
+		default:
+			log.Println("The system is now unlocked")
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package lock
+
+import (
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/internal/login1"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLogin1 is a minimal org.freedesktop.login1 service exported on a private bus.
+type fakeLogin1 struct {
+	bus  *dbustest.Bus
+	conn *dbus.Conn
+	seat *fakeObject
+
+	mu       sync.Mutex
+	sessions []*fakeSession
+}
+
+// fakeObject is a logind object with properties of a single interface.
+type fakeObject struct {
+	login1 *fakeLogin1
+	path   dbus.ObjectPath
+	iface  string
+
+	mu         sync.Mutex
+	properties map[string]interface{}
+	// onGet, if set, is called once when a property is next read, before the value is returned.
+	onGet func(name string)
+	// removed makes all calls fail with UnknownObject. It is also set while logind restarts.
+	removed bool
+}
+
+type fakeSession struct {
+	*fakeObject
+	id string
+	// setLockedHintCalls is the number of SetLockedHint calls, guarded by mu.
+	setLockedHintCalls int
+}
+
+type fakeSessionListEntry struct {
+	ID   string
+	UID  uint32
+	User string
+	Seat string
+	Path dbus.ObjectPath
+}
+
+type fakeObjectRef struct {
+	ID   string
+	Path dbus.ObjectPath
+}
+
+func newFakeLogin1(t *testing.T, bus *dbustest.Bus) *fakeLogin1 {
+	t.Helper()
+
+	f := &fakeLogin1{
+		bus:  bus,
+		conn: bus.RequestName(t, "org.freedesktop.login1"),
+	}
+
+	err := f.conn.Export(
+		(*fakeManager)(f),
+		"/org/freedesktop/login1",
+		"org.freedesktop.login1.Manager",
+	)
+	if err != nil {
+		t.Fatalf("failed to export fake login1 manager: %v", err)
+	}
+
+	f.seat = f.newObject(t, "/org/freedesktop/login1/seat/seat0", "org.freedesktop.login1.Seat")
+	f.seat.properties["ActiveSession"] = fakeObjectRef{ID: "", Path: "/"}
+	f.seat.properties["CanTTY"] = true
+
+	return f
+}
+
+// newObject exports an object with an org.freedesktop.DBus.Properties implementation.
+func (f *fakeLogin1) newObject(t *testing.T, path dbus.ObjectPath, iface string) *fakeObject {
+	t.Helper()
+
+	o := &fakeObject{
+		login1:     f,
+		path:       path,
+		iface:      iface,
+		properties: make(map[string]interface{}),
+	}
+
+	err := f.conn.Export((*fakeProperties)(o), path, "org.freedesktop.DBus.Properties")
+	if err != nil {
+		t.Fatalf("failed to export properties of %s: %v", path, err)
+	}
+
+	return o
+}
+
+func (f *fakeLogin1) addSession(t *testing.T, id string) *fakeSession {
+	t.Helper()
+
+	return f.addSessionAt(t, id, dbus.ObjectPath("/org/freedesktop/login1/session/_3"+id))
+}
+
+// addSessionAt adds a session with the given ID and object path.
+func (f *fakeLogin1) addSessionAt(t *testing.T, id string, path dbus.ObjectPath) *fakeSession {
+	t.Helper()
+
+	s := &fakeSession{
+		fakeObject: f.newObject(t, path, "org.freedesktop.login1.Session"),
+		id:         id,
+	}
+	s.properties["LockedHint"] = false
+	s.properties["State"] = "active"
+	s.properties["VTNr"] = uint32(0)
+	s.properties["Seat"] = fakeObjectRef{ID: "seat0", Path: f.seat.path}
+
+	if err := f.conn.Export(s, s.path, "org.freedesktop.login1.Session"); err != nil {
+		t.Fatalf("failed to export fake session: %v", err)
+	}
+
+	f.mu.Lock()
+	f.sessions = append(f.sessions, s)
+	f.mu.Unlock()
+
+	return s
+}
+
+// removeSession removes the session, calls on it fail like they do for objects logind no longer
+// knows. SessionRemoved is emitted if emit is true.
+func (f *fakeLogin1) removeSession(t *testing.T, s *fakeSession, emit bool) {
+	t.Helper()
+
+	f.mu.Lock()
+	f.sessions = slices.DeleteFunc(f.sessions, func(other *fakeSession) bool { return other == s })
+	f.mu.Unlock()
+
+	s.mu.Lock()
+	s.removed = true
+	s.mu.Unlock()
+
+	if !emit {
+		return
+	}
+
+	err := f.conn.Emit(
+		"/org/freedesktop/login1",
+		"org.freedesktop.login1.Manager.SessionRemoved",
+		s.id,
+		s.path,
+	)
+	if err != nil {
+		t.Fatalf("failed to emit SessionRemoved: %v", err)
+	}
+}
+
+// recreateSession removes the session and creates a session with the same ID at path, emitting
+// SessionRemoved and SessionNew.
+func (f *fakeLogin1) recreateSession(t *testing.T, s *fakeSession, path dbus.ObjectPath) *fakeSession {
+	t.Helper()
+
+	f.removeSession(t, s, true)
+	recreated := f.addSessionAt(t, s.id, path)
+	err := f.conn.Emit(
+		"/org/freedesktop/login1",
+		"org.freedesktop.login1.Manager.SessionNew",
+		recreated.id,
+		recreated.path,
+	)
+	if err != nil {
+		t.Fatalf("failed to emit SessionNew: %v", err)
+	}
+
+	return recreated
+}
+
+// fakeManager implements the methods of org.freedesktop.login1.Manager.
+type fakeManager fakeLogin1
+
+func (m *fakeManager) ListSessions() ([]fakeSessionListEntry, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]fakeSessionListEntry, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		result = append(result, fakeSessionListEntry{
+			ID:   s.id,
+			UID:  1000,
+			User: "user",
+			Seat: "seat0",
+			Path: s.path,
+		})
+	}
+
+	return result, nil
+}
+
+func (m *fakeManager) GetSession(id string) (dbus.ObjectPath, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.id == id {
+			return s.path, nil
+		}
+	}
+
+	return "", dbus.NewError(
+		"org.freedesktop.login1.NoSuchSession",
+		[]interface{}{fmt.Sprintf("No session '%s' known", id)},
+	)
+}
+
+func (s *fakeSession) SetLockedHint(locked bool) *dbus.Error {
+	s.mu.Lock()
+	removed := s.removed
+	s.setLockedHintCalls++
+	s.mu.Unlock()
+	if removed {
+		return errUnknownObject(s.path)
+	}
+
+	s.setProperty("LockedHint", locked)
+	return nil
+}
+
+func errUnknownObject(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError(
+		"org.freedesktop.DBus.Error.UnknownObject",
+		[]interface{}{fmt.Sprintf("Unknown object '%s'.", path)},
+	)
+}
+
+// setProperty changes the property and emits PropertiesChanged.
+func (o *fakeObject) setProperty(name string, value interface{}) {
+	o.mu.Lock()
+	o.properties[name] = value
+	o.mu.Unlock()
+
+	err := o.login1.conn.Emit(
+		o.path,
+		"org.freedesktop.DBus.Properties.PropertiesChanged",
+		o.iface,
+		map[string]dbus.Variant{name: dbus.MakeVariant(value)},
+		[]string{},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("failed to emit PropertiesChanged: %v", err))
+	}
+}
+
+// invalidateProperty changes the property and emits PropertiesChanged reporting it as
+// invalidated, without its value.
+func (o *fakeObject) invalidateProperty(name string, value interface{}) {
+	o.mu.Lock()
+	o.properties[name] = value
+	o.mu.Unlock()
+
+	err := o.login1.conn.Emit(
+		o.path,
+		"org.freedesktop.DBus.Properties.PropertiesChanged",
+		o.iface,
+		map[string]dbus.Variant{},
+		[]string{name},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("failed to emit PropertiesChanged: %v", err))
+	}
+}
+
+// set changes the property without emitting PropertiesChanged.
+func (o *fakeObject) set(name string, value interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.properties[name] = value
+}
+
+// setOnGet sets the function that is called once when a property is next read.
+func (o *fakeObject) setOnGet(f func(name string)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.onGet = f
+}
+
+// fakeProperties implements org.freedesktop.DBus.Properties for a fakeObject.
+type fakeProperties fakeObject
+
+func (p *fakeProperties) Get(iface string, name string) (dbus.Variant, *dbus.Error) {
+	o := (*fakeObject)(p)
+	o.mu.Lock()
+	value, ok := o.properties[name]
+	onGet := o.onGet
+	o.onGet = nil
+	removed := o.removed
+	o.mu.Unlock()
+
+	if removed {
+		return dbus.Variant{}, errUnknownObject(o.path)
+	}
+
+	if iface != o.iface || !ok {
+		return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf("unknown property %s.%s", iface, name))
+	}
+
+	if onGet != nil {
+		onGet(name)
+	}
+
+	return dbus.MakeVariant(value), nil
+}
+
+// newTestLock creates a dbusCon connected to a fake login1 service with a single session.
+func newTestLock(t *testing.T) (*dbusCon, *fakeSession) {
+	t.Helper()
+
+	dc, logind := newTestLockWithLogin1(t)
+	return dc, logind.sessions[0]
+}
+
+// newTestLockWithLogin1 creates a dbusCon for session "1" of a new fake login1 service.
+func newTestLockWithLogin1(t *testing.T) (*dbusCon, *fakeLogin1) {
+	t.Helper()
+
+	bus := dbustest.New(t)
+	logind := newFakeLogin1(t, bus)
+	session := logind.addSession(t, "1")
+
+	dc, err := newDbusCon(login1.New(bus.Connect(t)), session.id)
+	if err != nil {
+		t.Fatalf("newDbusCon() error = %v", err)
+	}
+	dc.retryDelay = 10 * time.Millisecond
+	t.Cleanup(func() {
+		_ = dc.Close()
+	})
+
+	return dc, logind
+}
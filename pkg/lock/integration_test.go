@@ -0,0 +1,44 @@
+//go:build integration
+
+package lock_test
+
+import (
+	"context"
+	"github.com/MatthiasKunnen/system/pkg/lock"
+	"os"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags integration ./pkg/lock
+// Requires logind and a session, XDG_SESSION_ID must be set.
+func TestIntegrationSelfTest(t *testing.T) {
+	sessionID := os.Getenv("XDG_SESSION_ID")
+	if sessionID == "" {
+		t.Skip("XDG_SESSION_ID is not set")
+	}
+
+	l, err := lock.NewDbusSessionLock(sessionID, lock.WithReadOnly())
+	if err != nil {
+		t.Fatalf("NewDbusSessionLock() error = %v", err)
+	}
+	defer l.Close()
+	if err := l.AddLockSignal(make(chan struct{})); err != nil {
+		t.Fatalf("AddLockSignal() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tester, ok := l.(lock.SelfTester)
+	if !ok {
+		t.Fatal("Lock does not implement SelfTester")
+	}
+	report, err := tester.SelfTest(ctx)
+	if err != nil {
+		t.Fatalf("SelfTest() error = %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("SelfTest() report error = %v", err)
+	}
+	t.Logf("probe latency %s", report.Latency)
+}
@@ -1,6 +1,10 @@
 package lock
 
-import "io"
+import (
+	"context"
+	"io"
+	"iter"
+)
 
 // Lock represents the lock state of a system.
 // It allows:
@@ -9,13 +13,20 @@ import "io"
 //   - being notified of lock signals
 //   - being notified of unlock signals
 //
+// Implementations can offer more using the optional interfaces of this package, such as
+// VTWatcher and SelfTester. Detect them using a type assertion.
+//
 // It is safe to call Lock's methods concurrently.
 type Lock interface {
 
 	// GetLocked gets the current state of the system; true=Locked, false=unlocked.
+	// Failures caused by logind restarting are retried, see WithRetry. Returns ErrSessionGone or
+	// ErrLogindUnavailable when retrying does not help.
 	GetLocked() (bool, error)
 
 	// SetLocked sets the current state of the system; true=Locked, false=unlocked.
+	// Retried like GetLocked.
+	// Returns ErrReadOnly if the Lock was created with WithReadOnly.
 	SetLocked(locked bool) error
 
 	// AddLockSignal registers a channel that will be notified when the "Lock" signal is received.
@@ -54,3 +65,234 @@ type Lock interface {
 	RemoveLockedSignal(c chan<- bool) error
 	io.Closer
 }
+
+// LockedStateSignaler is implemented by a Lock that can deliver the current locked state before
+// the changes, such as the Lock returned by NewDbusSessionLock. Use a type assertion to detect
+// it.
+type LockedStateSignaler interface {
+	// AddLockedSignalWithState works like AddLockedSignal but first delivers the current locked
+	// state to the channel.
+	// The subscription is in place before the state is read, so every change that happens
+	// after the current state has been read is delivered after it, no change goes unnoticed.
+	// Reading the state does not hold up the signals of other channels.
+	//
+	// Writing to this channel does not block, use a buffered channel to receive the current
+	// state. Unregister the channel using RemoveLockedSignal.
+	AddLockedSignalWithState(c chan<- bool) error
+}
+
+// VTWatcher is implemented by a Lock that can tell the virtual terminal of the session and follow
+// VT switches, such as the Lock returned by NewDbusSessionLock. Use a type assertion to detect it.
+type VTWatcher interface {
+	// GetVTNr returns the number of the virtual terminal the session runs on.
+	// Returns ErrNoVT if the session does not run on a virtual terminal.
+	GetVTNr() (uint32, error)
+
+	// AddVTChangeSignal registers a channel that will be notified with the number of the virtual
+	// terminal that becomes active when the active session of the seat changes, e.g. when the
+	// user switches to another VT or back to the VT of this session.
+	// Returns ErrNoVT if the session does not run on a virtual terminal or its seat does not
+	// support TTYs.
+	//
+	// Writing to this channel does not block.
+	// Use a buffered channel if you don't want to miss anything.
+	AddVTChangeSignal(c chan<- uint32) error
+
+	// RemoveVTChangeSignal unregisters a channel previously registered with AddVTChangeSignal.
+	// RemoveVTChangeSignal can be safely called with an unregistered channel.
+	RemoveVTChangeSignal(c chan<- uint32) error
+
+	// ReplaceVTChangeSignal atomically replaces a channel registered with AddVTChangeSignal by
+	// another channel. A VT switch that happens during the replacement is delivered to exactly
+	// one of them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceVTChangeSignal(old chan<- uint32, new chan<- uint32) error
+}
+
+// SignalReplacer is implemented by a Lock that can swap the channels of its signals without
+// missing or duplicating one, such as the Lock returned by NewDbusSessionLock. Use a type
+// assertion to detect it.
+type SignalReplacer interface {
+	// ReplaceLockSignal atomically replaces a channel registered with AddLockSignal by another
+	// channel. A signal received during the replacement is delivered to exactly one of them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceLockSignal(old chan<- struct{}, new chan<- struct{}) error
+
+	// ReplaceUnlockSignal atomically replaces a channel registered with AddUnlockSignal by another
+	// channel. A signal received during the replacement is delivered to exactly one of them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceUnlockSignal(old chan<- struct{}, new chan<- struct{}) error
+
+	// ReplaceLockedSignal atomically replaces a channel registered with AddLockedSignal or
+	// AddLockedSignalWithState by another channel. A change received during the replacement is
+	// delivered to exactly one of them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceLockedSignal(old chan<- bool, new chan<- bool) error
+}
+
+// SessionRemovalNotifier is implemented by a Lock that notices the removal of its session, such
+// as the Lock returned by NewDbusSessionLock. Use a type assertion to detect it.
+type SessionRemovalNotifier interface {
+	// AddSessionRemovedSignal registers a channel that will be notified once when logind removes
+	// the session, e.g. because the user logged out.
+	// Once the session is gone, all methods except Remove*Signal and Close return ErrSessionGone.
+	//
+	// Writing to this channel does not block.
+	AddSessionRemovedSignal(c chan<- struct{}) error
+
+	// RemoveSessionRemovedSignal unregisters a channel previously registered with
+	// AddSessionRemovedSignal.
+	// RemoveSessionRemovedSignal can be safely called with an unregistered channel.
+	RemoveSessionRemovedSignal(c chan<- struct{}) error
+
+	// ReplaceSessionRemovedSignal atomically replaces a channel registered with
+	// AddSessionRemovedSignal by another channel.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceSessionRemovedSignal(old chan<- struct{}, new chan<- struct{}) error
+}
+
+// SessionStateWatcher is implemented by a Lock that can tell the state of its session and follow
+// its changes, such as the Lock returned by NewDbusSessionLock. Use a type assertion to detect
+// it.
+type SessionStateWatcher interface {
+	// GetState returns the state of the session.
+	GetState() (SessionState, error)
+
+	// AddStateSignal registers a channel that will be notified of every change of the session's
+	// state. Observing SessionStateClosing is treated like the removal of the session, see
+	// AddSessionRemovedSignal.
+	//
+	// Writing to this channel does not block.
+	// Use a buffered channel if you don't want to miss anything.
+	AddStateSignal(c chan<- SessionState) error
+
+	// RemoveStateSignal unregisters a channel previously registered with AddStateSignal.
+	// RemoveStateSignal can be safely called with an unregistered channel.
+	RemoveStateSignal(c chan<- SessionState) error
+
+	// ReplaceStateSignal atomically replaces a channel registered with AddStateSignal by another
+	// channel. A change received during the replacement is delivered to exactly one of them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceStateSignal(old chan<- SessionState, new chan<- SessionState) error
+}
+
+// LockStateSignaler is implemented by a Lock that can deliver its lock signals and locked state
+// as a single stream of events, such as the Lock returned by NewDbusSessionLock. Use a type
+// assertion to detect it.
+type LockStateSignaler interface {
+	// AddLockStateSignal registers a channel that receives the Lock signal, the Unlock signal
+	// and changes of LockedHint as a single stream of events. This covers lockers that only set
+	// LockedHint, which logind does not turn into a Lock or Unlock signal, and the reverse.
+	//
+	// Events are delivered in the order they are received from the system bus, see
+	// LockEvent.Sequence for bursts of signals. When a signal and a LockedHint change report the
+	// same state within a second of each other, only the first is delivered. Other repeated
+	// events, e.g. two Lock signals, are all delivered.
+	//
+	// Writing to this channel does not block.
+	// Use a buffered channel if you don't want to miss anything.
+	AddLockStateSignal(c chan<- LockEvent) error
+
+	// RemoveLockStateSignal unregisters a channel previously registered with AddLockStateSignal.
+	// RemoveLockStateSignal can be safely called with an unregistered channel.
+	RemoveLockStateSignal(c chan<- LockEvent) error
+
+	// ReplaceLockStateSignal atomically replaces a channel registered with AddLockStateSignal by
+	// another channel. An event received during the replacement is delivered to exactly one of
+	// them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceLockStateSignal(old chan<- LockEvent, new chan<- LockEvent) error
+}
+
+// SessionRecreationNotifier is implemented by a Lock that can follow its session when logind
+// recreates it, such as the Lock returned by NewDbusSessionLock. Use a type assertion to detect
+// it.
+type SessionRecreationNotifier interface {
+	// AddSessionRecreatedSignal registers a channel that will be notified when logind removed the
+	// session and created a session with the same ID, which the Lock now follows. Only happens
+	// when the Lock was created with WithRecreateGrace.
+	//
+	// Writing to this channel does not block.
+	AddSessionRecreatedSignal(c chan<- struct{}) error
+
+	// RemoveSessionRecreatedSignal unregisters a channel previously registered with
+	// AddSessionRecreatedSignal.
+	// RemoveSessionRecreatedSignal can be safely called with an unregistered channel.
+	RemoveSessionRecreatedSignal(c chan<- struct{}) error
+
+	// ReplaceSessionRecreatedSignal atomically replaces a channel registered with
+	// AddSessionRecreatedSignal by another channel.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceSessionRecreatedSignal(old chan<- struct{}, new chan<- struct{}) error
+}
+
+// HintConflictNotifier is implemented by a Lock that notices other programs changing the locked
+// state it set, such as the Lock returned by NewDbusSessionLock. Use a type assertion to detect
+// it.
+type HintConflictNotifier interface {
+	// AddHintConflictSignal registers a channel that will be notified when another program
+	// changes LockedHint away from the value set using SetLocked. Only happens when the Lock was
+	// created with WithHintOwnership or WithHintReassert, and after SetLocked has been called.
+	//
+	// Writing to this channel does not block.
+	// Use a buffered channel if you don't want to miss anything.
+	AddHintConflictSignal(c chan<- HintConflict) error
+
+	// RemoveHintConflictSignal unregisters a channel previously registered with
+	// AddHintConflictSignal.
+	// RemoveHintConflictSignal can be safely called with an unregistered channel.
+	RemoveHintConflictSignal(c chan<- HintConflict) error
+
+	// ReplaceHintConflictSignal atomically replaces a channel registered with
+	// AddHintConflictSignal by another channel. A conflict detected during the replacement is
+	// delivered to exactly one of them.
+	// Returns ErrNotRegistered if old is not registered.
+	ReplaceHintConflictSignal(old chan<- HintConflict, new chan<- HintConflict) error
+}
+
+// LockSignalCoalescer is implemented by a Lock that can suppress repeated Lock signals, such as
+// the Lock returned by NewDbusSessionLock. Use a type assertion to detect it.
+type LockSignalCoalescer interface {
+	// SuppressedLockSignals returns the number of Lock signals that were not delivered because
+	// they repeated a Lock signal of the same lock, see WithLockSignalCoalescing.
+	SuppressedLockSignals() uint64
+}
+
+// LockIterator is implemented by a Lock that can iterate over its signals, such as the Lock
+// returned by NewDbusSessionLock. Use a type assertion to detect it.
+type LockIterator interface {
+	// LockedTransitions returns an iterator over the changes of the locked state, true=locked,
+	// false=unlocked, as delivered by AddLockedSignal. The channel is registered when iteration
+	// starts and unregistered when it ends.
+	//
+	// Changes received while the loop body runs are queued. When more than 64 are queued, the
+	// oldest is dropped and the next change is preceded by an error wrapping
+	// ErrTransitionsDropped, yielded with a zero state. Iteration ends when ctx is done or the
+	// Lock is closed. When the session is removed, the queued changes are yielded followed by
+	// ErrSessionGone. When registering fails, the error is yielded and iteration ends.
+	LockedTransitions(ctx context.Context) iter.Seq2[bool, error]
+
+	// LockSignals returns an iterator over the Lock signals, as delivered by AddLockSignal. See
+	// LockedTransitions for queueing and when iteration ends.
+	LockSignals(ctx context.Context) iter.Seq2[struct{}, error]
+
+	// UnlockSignals returns an iterator over the Unlock signals, as delivered by
+	// AddUnlockSignal. See LockedTransitions for queueing and when iteration ends.
+	UnlockSignals(ctx context.Context) iter.Seq2[struct{}, error]
+}
+
+// SelfTester is implemented by a Lock that can check its signal delivery end to end, such as the
+// Lock returned by NewDbusSessionLock. Use a type assertion to detect it.
+type SelfTester interface {
+	// SelfTest checks the whole path from logind to the channels without side effects: it reads
+	// LockedHint, verifies that the bus still accepts the match rules of the signals the Lock is
+	// subscribed to, and sends a probe signal, which only this process receives, through the bus
+	// connection and the goroutine that dispatches the signals to the channels. A dispatch
+	// goroutine that is stuck, e.g. in a handler, makes the probe fail. The probe is given five
+	// seconds when ctx has no deadline.
+	//
+	// The result of every item is part of the report, see SelfTestReport.Err. The returned error
+	// is only set when ctx is done before the checks finished. Cheap enough to be called
+	// periodically.
+	SelfTest(ctx context.Context) (SelfTestReport, error)
+}
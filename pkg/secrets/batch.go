@@ -0,0 +1,220 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"maps"
+	"sync/atomic"
+)
+
+const (
+	// dbusMaxMessageSize is the maximum size of a D-Bus message allowed by the specification,
+	// 128 MiB.
+	dbusMaxMessageSize = 128 << 20
+
+	// defaultItemSize is the estimated size of a secret in a GetSecrets reply before any reply
+	// has been observed.
+	defaultItemSize = 1024
+
+	// secretOverhead is the size of a secret in a GetSecrets reply apart from the path, the
+	// session, the parameters, the value, and the content type: lengths and padding.
+	secretOverhead = 32
+)
+
+// ErrMessageTooLarge is returned when the secret of a single item does not fit in a D-Bus
+// message, see WithMaxMessageSize.
+var ErrMessageTooLarge = errors.New("D-Bus message too large")
+
+// errNoSession is the error of services for a session that does not exist, e.g. because the
+// service ended it when KeePassXC locked its database.
+const errNoSession = "org.freedesktop.Secret.Error.NoSession"
+
+// PartialSecretsError is returned when the service ended the session while secrets were
+// retrieved in batches, and ended the session that was opened to replace it as well.
+type PartialSecretsError struct {
+	// Completed are the items whose secrets were retrieved, or omitted by the service because
+	// they are locked.
+	Completed []dbus.ObjectPath
+	// Missing are the items whose secrets were not retrieved.
+	Missing []dbus.ObjectPath
+	// Err is the error of the last call.
+	Err error
+}
+
+func (e *PartialSecretsError) Error() string {
+	return fmt.Sprintf(
+		"could not get the secrets of %d of %d items, the session ended: %v",
+		len(e.Missing),
+		len(e.Completed)+len(e.Missing),
+		e.Err,
+	)
+}
+
+func (e *PartialSecretsError) Unwrap() error {
+	return e.Err
+}
+
+// WithMaxMessageSize sets the maximum size of a D-Bus message. Defaults to the maximum of the
+// D-Bus specification, 128 MiB; buses and providers can enforce a lower limit.
+//
+// The secrets of many items, e.g. of FindItems and Export, are retrieved in batches whose replies
+// are estimated to fit in a message, based on the sizes of earlier replies. When the bus reports
+// that a reply exceeded its limit, org.freedesktop.DBus.Error.LimitsExceeded, the batch is split
+// and the limit is lowered for later batches. ErrMessageTooLarge is only returned when a single
+// secret exceeds the limit.
+func WithMaxMessageSize(size int) Option {
+	return func(o *options) {
+		o.maxMessageSize = size
+	}
+}
+
+// messageLimit keeps track of the maximum message size and the size of secrets.
+type messageLimit struct {
+	// max is the maximum size of a message, lowered when the bus reports a smaller limit.
+	max atomic.Int64
+	// itemSize is the estimated size of a secret in a reply, zero until a reply was observed.
+	itemSize atomic.Int64
+}
+
+// batchSize returns the number of secrets that fit in a reply.
+func (l *messageLimit) batchSize() int {
+	itemSize := l.itemSize.Load()
+	if itemSize == 0 {
+		itemSize = defaultItemSize
+	}
+
+	return int(max(l.max.Load()/itemSize, 1))
+}
+
+// observe updates the estimated size of a secret with the average of a reply of n secrets of
+// the given total size.
+func (l *messageLimit) observe(size int64, n int) {
+	if n == 0 {
+		return
+	}
+
+	average := max(size/int64(n), 1)
+	if previous := l.itemSize.Load(); previous != 0 {
+		average = (previous + average) / 2
+	}
+	l.itemSize.Store(average)
+}
+
+// exceeded lowers the maximum size below size, the size of a reply that exceeded the limit.
+func (l *messageLimit) exceeded(size int64) {
+	for {
+		current := l.max.Load()
+		if size > current || l.max.CompareAndSwap(current, max(size-1, 1)) {
+			return
+		}
+	}
+}
+
+// secretSize returns the estimated size of the secret in a GetSecrets reply.
+func secretSize(path dbus.ObjectPath, s secret) int64 {
+	return int64(len(path) + len(s.Session) + len(s.Parameters) + len(s.Value) + len(s.ContentType) +
+		secretOverhead)
+}
+
+// getSecrets retrieves the secrets of paths in batches that fit in a message. When the service
+// ends the session between batches, reopen is called once for a new session and the remaining
+// batches are retrieved in it. Returns the secrets retrieved so far and a *PartialSecretsError
+// when the new session ends as well or cannot be opened.
+func (s *Secrets) getSecrets(
+	ctx context.Context,
+	session dbus.ObjectPath,
+	paths []dbus.ObjectPath,
+	reopen func(ctx context.Context) (dbus.ObjectPath, error),
+) (map[dbus.ObjectPath]secret, error) {
+	result := make(map[dbus.ObjectPath]secret, len(paths))
+	var completed []dbus.ObjectPath
+	reopened := false
+	for len(paths) > 0 {
+		n := min(s.limit.batchSize(), len(paths))
+		_, err := s.getSecretsBatch(ctx, session, paths[:n], result)
+		if isDbusError(err, errNoSession) && !reopened {
+			reopened = true
+			if session, err = reopen(ctx); err == nil {
+				continue
+			}
+			err = fmt.Errorf("could not replace the ended session: %w", err)
+
+			return result, partialSecrets(completed, paths, result, err)
+		}
+		if isDbusError(err, errNoSession) {
+			return result, partialSecrets(completed, paths, result, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		completed = append(completed, paths[:n]...)
+		paths = paths[n:]
+	}
+
+	return result, nil
+}
+
+// partialSecrets returns the error for the remaining paths that could not be retrieved. The
+// remaining paths already in result, retrieved in half a batch, count as completed.
+func partialSecrets(
+	completed []dbus.ObjectPath,
+	remaining []dbus.ObjectPath,
+	result map[dbus.ObjectPath]secret,
+	err error,
+) *PartialSecretsError {
+	partial := &PartialSecretsError{Completed: completed, Err: err}
+	for _, path := range remaining {
+		if _, ok := result[path]; ok {
+			partial.Completed = append(partial.Completed, path)
+		} else {
+			partial.Missing = append(partial.Missing, path)
+		}
+	}
+
+	return partial
+}
+
+// getSecretsBatch retrieves the secrets of paths in one call and adds them to result. The batch
+// is split in halves when the reply exceeds the limit of the bus. Returns the size of the
+// secrets.
+func (s *Secrets) getSecretsBatch(
+	ctx context.Context,
+	session dbus.ObjectPath,
+	paths []dbus.ObjectPath,
+	result map[dbus.ObjectPath]secret,
+) (int64, error) {
+	var secrets map[dbus.ObjectPath]secret
+	err := s.call(ctx, s.obj, dbusServiceInterface+".GetSecrets", paths, session).Store(&secrets)
+	if isDbusError(err, "org.freedesktop.DBus.Error.LimitsExceeded") {
+		if len(paths) == 1 {
+			return 0, fmt.Errorf("%w: secret of %s: %w", ErrMessageTooLarge, paths[0], err)
+		}
+
+		half := len(paths) / 2
+		first, err := s.getSecretsBatch(ctx, session, paths[:half], result)
+		if err != nil {
+			return 0, err
+		}
+		second, err := s.getSecretsBatch(ctx, session, paths[half:], result)
+		if err != nil {
+			return 0, err
+		}
+		s.limit.exceeded(first + second)
+
+		return first + second, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get secrets: %w", err)
+	}
+
+	var size int64
+	for path, secret := range secrets {
+		size += secretSize(path, secret)
+	}
+	s.limit.observe(size, len(secrets))
+	maps.Copy(result, secrets)
+
+	return size, nil
+}
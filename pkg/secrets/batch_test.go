@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"testing"
+)
+
+func TestGetSecretsBatches(t *testing.T) {
+	service := secretstest.New(t)
+	service.SetMaxMessageSize(3500)
+	s, err := New(WithConn(service.Connect(t)), WithMaxMessageSize(3500))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	attributes := map[string]string{"app": "batch"}
+	for i := range 10 {
+		value := bytes.Repeat([]byte{byte('a' + i)}, 1000)
+		service.AddItem(t, testLoginCollection, fmt.Sprint("item ", i), attributes, value)
+	}
+	checkSecrets := func() {
+		t.Helper()
+
+		items, err := s.FindItems(attributes, FindOpts{WithSecrets: true})
+		if err != nil {
+			t.Fatalf("FindItems() error = %v", err)
+		}
+		if len(items) != 10 {
+			t.Fatalf("FindItems() returned %d items, want 10", len(items))
+		}
+		for _, item := range items {
+			if len(item.Secret) != 1000 {
+				t.Fatalf("item %q has a secret of %d bytes, want 1000", item.Label, len(item.Secret))
+			}
+		}
+	}
+
+	// The batches fit from the start
+	checkSecrets()
+	if calls := service.Calls("org.freedesktop.Secret.Service.GetSecrets"); calls < 4 {
+		t.Fatalf("GetSecrets called %d times, want batches of at most 3", calls)
+	}
+
+	// A bus with a lower limit than configured splits the batches and lowers the limit
+	service.SetMaxMessageSize(2500)
+	checkSecrets()
+	if limit := s.limit.max.Load(); limit >= 3500 {
+		t.Fatalf("limit = %d, want it lowered", limit)
+	}
+
+	service.AddItem(t, testLoginCollection, "large", attributes, make([]byte, 5000))
+	_, err = s.FindItems(attributes, FindOpts{WithSecrets: true})
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("FindItems() with a large secret error = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestGetSecretsSessionEnded(t *testing.T) {
+	service := secretstest.New(t)
+	service.SetMaxMessageSize(3500)
+	s, err := New(WithConn(service.Connect(t)), WithMaxMessageSize(3500))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	attributes := map[string]string{"app": "batch"}
+	for i := range 10 {
+		value := bytes.Repeat([]byte{byte('a' + i)}, 1000)
+		service.AddItem(t, testLoginCollection, fmt.Sprint("item ", i), attributes, value)
+	}
+
+	// The session ends after the third of four batches and is replaced
+	service.SetSessionLifetime(3)
+	items, err := s.FindItems(attributes, FindOpts{WithSecrets: true})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	for _, item := range items {
+		if len(item.Secret) != 1000 {
+			t.Fatalf("item %q has a secret of %d bytes, want 1000", item.Label, len(item.Secret))
+		}
+	}
+	if calls := service.Calls("org.freedesktop.Secret.Service.OpenSession"); calls != 2 {
+		t.Fatalf("OpenSession called %d times, want 2", calls)
+	}
+	if open := service.OpenSessions(); len(open) != 0 {
+		t.Fatalf("sessions %v left open", open)
+	}
+
+	// The replacement ends as well after the second batch
+	service.SetSessionLifetime(1)
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+	first := session.Path()
+	secrets, err := s.GetSecrets(items, session)
+	var partial *PartialSecretsError
+	if !errors.As(err, &partial) {
+		t.Fatalf("GetSecrets() error = %v, want a PartialSecretsError", err)
+	}
+	if !isDbusError(err, errNoSession) {
+		t.Errorf("GetSecrets() error = %v, want NoSession", err)
+	}
+	if len(partial.Completed) != 6 || len(partial.Missing) != 4 {
+		t.Fatalf(
+			"GetSecrets() completed %d and missed %d items, want 6 and 4",
+			len(partial.Completed),
+			len(partial.Missing),
+		)
+	}
+	if len(secrets) != 6 {
+		t.Fatalf("GetSecrets() returned %d secrets, want the 6 completed", len(secrets))
+	}
+	for _, path := range partial.Completed {
+		if _, ok := secrets[path]; !ok {
+			t.Errorf("GetSecrets() did not return the completed %s", path)
+		}
+	}
+	if session.Path() == first {
+		t.Error("GetSecrets() did not replace the ended session")
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() of an ended session error = %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Chunked secrets
+//
+// Secrets larger than the size set by WithChunkSize are stored as a set of items, the chunks.
+// Format version 1, which is the only version, is defined as follows so that other
+// implementations can read and write it:
+//
+//   - Every chunk has the attributes of the secret plus:
+//     secret-chunk-format: "1";
+//     secret-chunk-index: the zero-based index of the chunk, in decimal;
+//     secret-chunk-total: the number of chunks, in decimal;
+//     secret-chunk-checksum: the lowercase hexadecimal SHA-256 of the complete secret.
+//   - Chunk i holds bytes [i*size, (i+1)*size) of the secret, the last chunk holds the rest. Every
+//     chunk has the content type of the secret.
+//   - The first chunk has the label of the secret, chunk i the label followed by
+//     " (part i+1 of total)".
+//   - The chunks of a secret are the items whose attributes are equal apart from
+//     secret-chunk-index. The secret is complete when every index from 0 to total-1 is present
+//     exactly once and the SHA-256 of the concatenated chunks equals secret-chunk-checksum.
+const (
+	chunkFormat = "1"
+
+	chunkAttrFormat   = "secret-chunk-format"
+	chunkAttrIndex    = "secret-chunk-index"
+	chunkAttrTotal    = "secret-chunk-total"
+	chunkAttrChecksum = "secret-chunk-checksum"
+)
+
+// chunkAttrs are the attributes that chunks have in addition to those of the secret.
+var chunkAttrs = []string{chunkAttrFormat, chunkAttrIndex, chunkAttrTotal, chunkAttrChecksum}
+
+// ErrCorruptSecret is returned when the chunks of a chunked secret are incomplete or do not match
+// their checksum, see WithChunkSize, or when a compressed secret cannot be decompressed, see
+// WithCompression.
+var ErrCorruptSecret = errors.New("stored secret is corrupt")
+
+// WithChunkSize makes GetOrCreate store secrets larger than size bytes as several items of at
+// most size bytes, for providers that limit the size of an item. Set it below the limit of the
+// provider. Zero, the default, disables chunking.
+//
+// Chunked secrets are reassembled by FindItems and GetOrCreate, whether or not this option is
+// set, and deleted as a whole by Item.Delete. The format is documented in the source, chunk.go.
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		o.chunkSize = size
+	}
+}
+
+// splitChunks returns the chunks of value, each at most size bytes.
+func splitChunks(value []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(value) > size {
+		chunks = append(chunks, value[:size])
+		value = value[size:]
+	}
+
+	return append(chunks, value)
+}
+
+// chunkLabel returns the label of chunk index of total of a secret with label.
+func chunkLabel(label string, index int, total int) string {
+	if index == 0 {
+		return label
+	}
+
+	return fmt.Sprintf("%s (part %d of %d)", label, index+1, total)
+}
+
+// createChunks creates the chunks of value in the unlocked collection. When creating a chunk
+// fails, the chunks created so far are deleted. Returns the paths of the chunks in order.
+func (s *Secrets) createChunks(
+	ctx context.Context,
+	collection dbus.ObjectPath,
+	label string,
+	attributes map[string]string,
+	value Secret,
+) ([]dbus.ObjectPath, error) {
+	chunks := splitChunks(value.Value, s.chunkSize)
+	checksum := sha256.Sum256(value.Value)
+
+	paths := make([]dbus.ObjectPath, 0, len(chunks))
+	for i, chunk := range chunks {
+		chunkAttributes := maps.Clone(attributes)
+		chunkAttributes[chunkAttrFormat] = chunkFormat
+		chunkAttributes[chunkAttrIndex] = strconv.Itoa(i)
+		chunkAttributes[chunkAttrTotal] = strconv.Itoa(len(chunks))
+		chunkAttributes[chunkAttrChecksum] = hex.EncodeToString(checksum[:])
+
+		path, err := s.createItemIn(
+			ctx,
+			collection,
+			chunkLabel(label, i, len(chunks)),
+			chunkAttributes,
+			Secret{Value: chunk, ContentType: value.ContentType},
+			false,
+		)
+		if err != nil {
+			err = fmt.Errorf("could not create chunk %d of %d: %w", i+1, len(chunks), err)
+			// The chunks are removed even when ctx is the cause of the failure
+			return nil, errors.Join(err, s.deleteItems(context.WithoutCancel(ctx), paths))
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// deleteItems deletes the items, the last one first, so that an interrupted deletion of chunks
+// leaves a set that is detected as incomplete.
+func (s *Secrets) deleteItems(ctx context.Context, paths []dbus.ObjectPath) error {
+	var err error
+	for _, path := range slices.Backward(paths) {
+		err = errors.Join(err, s.deleteItem(ctx, path))
+	}
+
+	return err
+}
+
+// getChunkSet reads the properties of the chunks of a chunked secret, in order, and returns the
+// item of the secret.
+func (s *Secrets) getChunkSet(ctx context.Context, paths []dbus.ObjectPath) (Item, error) {
+	chunks := make([]Item, 0, len(paths))
+	for _, path := range paths {
+		chunk, err := s.getItem(ctx, nil, path)
+		if err != nil {
+			return Item{}, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return joinChunkSet(chunks)
+}
+
+// joinChunks replaces the chunks among items by a single item per chunked secret, at the
+// position of its first chunk. The secret is reassembled if every chunk has its secret.
+// Returns an error wrapping ErrCorruptSecret when the chunks of a secret are incomplete or do
+// not match the checksum.
+func joinChunks(items []Item) ([]Item, error) {
+	sets := make(map[string][]Item)
+	var keys []string
+	for _, item := range items {
+		if _, ok := item.Attributes[chunkAttrFormat]; !ok {
+			continue
+		}
+
+		key := chunkSetKey(item.Attributes)
+		if _, ok := sets[key]; !ok {
+			keys = append(keys, key)
+		}
+		sets[key] = append(sets[key], item)
+	}
+	if len(sets) == 0 {
+		return items, nil
+	}
+
+	joined := make(map[string]Item, len(sets))
+	for _, key := range keys {
+		item, err := joinChunkSet(sets[key])
+		if err != nil {
+			return nil, err
+		}
+		joined[key] = item
+	}
+
+	result := make([]Item, 0, len(items))
+	for _, item := range items {
+		if _, ok := item.Attributes[chunkAttrFormat]; !ok {
+			result = append(result, item)
+			continue
+		}
+
+		key := chunkSetKey(item.Attributes)
+		if j, ok := joined[key]; ok {
+			result = append(result, j)
+			delete(joined, key)
+		}
+	}
+
+	return result, nil
+}
+
+// chunkSetKey returns a key that is equal for the chunks of the same secret.
+func chunkSetKey(attributes map[string]string) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(attributes)) {
+		if name == chunkAttrIndex {
+			continue
+		}
+		b.WriteString(strconv.Quote(name))
+		b.WriteString(strconv.Quote(attributes[name]))
+	}
+
+	return b.String()
+}
+
+// joinChunkSet returns the item of the secret made up of chunks.
+func joinChunkSet(chunks []Item) (Item, error) {
+	first := chunks[0]
+	if format := first.Attributes[chunkAttrFormat]; format != chunkFormat {
+		return Item{}, fmt.Errorf("item %s has unsupported chunk format %q", first.Path, format)
+	}
+
+	total, err := strconv.Atoi(first.Attributes[chunkAttrTotal])
+	if err != nil || total < 1 {
+		return Item{}, fmt.Errorf("%w: item %s has invalid chunk total %q",
+			ErrCorruptSecret, first.Path, first.Attributes[chunkAttrTotal])
+	}
+
+	ordered := make([]*Item, total)
+	for i := range chunks {
+		index, err := strconv.Atoi(chunks[i].Attributes[chunkAttrIndex])
+		if err != nil || index < 0 || index >= total {
+			return Item{}, fmt.Errorf("%w: item %s has invalid chunk index %q",
+				ErrCorruptSecret, chunks[i].Path, chunks[i].Attributes[chunkAttrIndex])
+		}
+		if ordered[index] != nil {
+			return Item{}, fmt.Errorf("%w: items %s and %s are both chunk %d",
+				ErrCorruptSecret, ordered[index].Path, chunks[i].Path, index+1)
+		}
+		ordered[index] = &chunks[i]
+	}
+
+	for index, chunk := range ordered {
+		if chunk == nil {
+			return Item{}, fmt.Errorf("%w: chunk %d of %d of %q is missing",
+				ErrCorruptSecret, index+1, total, first.Label)
+		}
+	}
+
+	item := *ordered[0]
+	for _, chunk := range ordered {
+		item.chunks = append(item.chunks, chunk.Path)
+		item.Locked = item.Locked || chunk.Locked
+		if chunk.Created.Before(item.Created) {
+			item.Created = chunk.Created
+		}
+		if chunk.Modified.After(item.Modified) {
+			item.Modified = chunk.Modified
+		}
+	}
+
+	item.Attributes = maps.Clone(item.Attributes)
+	for _, name := range chunkAttrs {
+		delete(item.Attributes, name)
+	}
+
+	item.Secret = nil
+	if item.Locked || slices.ContainsFunc(ordered, func(chunk *Item) bool { return chunk.Secret == nil }) {
+		return item, nil
+	}
+
+	var value bytes.Buffer
+	for _, chunk := range ordered {
+		value.Write(chunk.Secret)
+	}
+	checksum := sha256.Sum256(value.Bytes())
+	if hex.EncodeToString(checksum[:]) != first.Attributes[chunkAttrChecksum] {
+		return Item{}, fmt.Errorf("%w: checksum of %q does not match", ErrCorruptSecret, first.Label)
+	}
+	item.Secret = value.Bytes()
+
+	return item, nil
+}
@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"maps"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+	service := secretstest.New(t)
+	service.SetMaxSecretSize(1024)
+	s, err := New(WithConn(service.Connect(t)), WithChunkSize(1000))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	value := make([]byte, 10_500)
+	_, _ = rand.Read(value)
+	attributes := map[string]string{"app": "blob"}
+	generate := func() (Secret, error) {
+		return Secret{Value: value, ContentType: "application/octet-stream"}, nil
+	}
+	ctx := context.Background()
+
+	item, secret, created, err := s.GetOrCreateContext(ctx, "Blob", attributes, generate)
+	if err != nil {
+		t.Fatalf("GetOrCreate() error = %v", err)
+	}
+	if !created || !bytes.Equal(secret.Value, value) || len(item.chunks) != 11 {
+		t.Fatalf("GetOrCreate() = %d chunks, created %t, equal %t",
+			len(item.chunks), created, bytes.Equal(secret.Value, value))
+	}
+
+	item, secret, created, err = s.GetOrCreateContext(ctx, "Blob", attributes, generate)
+	if err != nil || created || !bytes.Equal(secret.Value, value) {
+		t.Fatalf("second GetOrCreate() created %t, error %v", created, err)
+	}
+	if !maps.Equal(item.Attributes, attributes) || item.Label != "Blob" {
+		t.Fatalf("GetOrCreate() item = %q %v, want the attributes without chunk attributes",
+			item.Label, item.Attributes)
+	}
+
+	// Remove a chunk behind the back of the item
+	if err := s.deleteItem(ctx, item.chunks[4]); err != nil {
+		t.Fatalf("deleteItem() error = %v", err)
+	}
+	_, err = s.FindItems(attributes, FindOpts{})
+	if !errors.Is(err, ErrCorruptSecret) {
+		t.Fatalf("FindItems() error = %v, want ErrCorruptSecret", err)
+	}
+
+	// Delete removes all chunks
+	item.chunks = append(item.chunks[:4], item.chunks[5:]...)
+	if err := item.DeleteContext(ctx); err != nil {
+		t.Fatalf("DeleteContext() error = %v", err)
+	}
+	items, err := s.FindItems(attributes, FindOpts{})
+	if err != nil || len(items) != 0 {
+		t.Fatalf("FindItems() after DeleteContext = %v, %v", items, err)
+	}
+}
+
+func TestJoinChunkSetChecksum(t *testing.T) {
+	chunk := func(index string, secret string) Item {
+		return Item{
+			Path: dbus.ObjectPath("/org/freedesktop/secrets/collection/login/" + index),
+			Attributes: map[string]string{
+				chunkAttrFormat:   chunkFormat,
+				chunkAttrIndex:    index,
+				chunkAttrTotal:    "2",
+				chunkAttrChecksum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			},
+			Secret: []byte(secret),
+		}
+	}
+
+	// The checksum is the SHA-256 of "hello"
+	item, err := joinChunkSet([]Item{chunk("1", "llo"), chunk("0", "he")})
+	if err != nil || string(item.Secret) != "hello" || len(item.Attributes) != 0 {
+		t.Fatalf("joinChunkSet() = %q %v, %v", item.Secret, item.Attributes, err)
+	}
+
+	_, err = joinChunkSet([]Item{chunk("0", "he"), chunk("1", "LLO")})
+	if !errors.Is(err, ErrCorruptSecret) {
+		t.Fatalf("joinChunkSet() error = %v, want ErrCorruptSecret", err)
+	}
+}
@@ -0,0 +1,268 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"time"
+)
+
+// ErrAliasNotFound is returned by ReadAlias when no collection has the alias.
+var ErrAliasNotFound = errors.New("alias not found")
+
+// ItemProperties are the properties of an item to create, see Collection.CreateItem.
+type ItemProperties struct {
+	Label      string
+	Attributes map[string]string
+}
+
+// Collection is a collection of items, also known as a keyring.
+type Collection struct {
+	secrets *Secrets
+	path    dbus.ObjectPath
+}
+
+// GetCollections returns every collection of the secret service.
+func (s *Secrets) GetCollections() ([]Collection, error) {
+	return s.GetCollectionsContext(context.Background())
+}
+
+// GetCollectionsContext is like GetCollections but aborts the call when ctx is done.
+func (s *Secrets) GetCollectionsContext(ctx context.Context) ([]Collection, error) {
+	var paths []dbus.ObjectPath
+	err := s.call(ctx, s.obj, propertiesInterface+".Get", dbusServiceInterface, "Collections").
+		Store(&paths)
+	if err != nil {
+		return nil, fmt.Errorf("could not get collections: %w", err)
+	}
+
+	collections := make([]Collection, 0, len(paths))
+	for _, path := range paths {
+		collections = append(collections, Collection{secrets: s, path: path})
+	}
+
+	return collections, nil
+}
+
+// ReadAlias returns the collection with the given alias, e.g. "default" for the collection new
+// items are stored in. Returns ErrAliasNotFound when no collection has the alias.
+func (s *Secrets) ReadAlias(name string) (Collection, error) {
+	return s.ReadAliasContext(context.Background(), name)
+}
+
+// ReadAliasContext is like ReadAlias but aborts the call when ctx is done.
+func (s *Secrets) ReadAliasContext(ctx context.Context, name string) (Collection, error) {
+	var path dbus.ObjectPath
+	err := s.call(ctx, s.obj, dbusServiceInterface+".ReadAlias", name).Store(&path)
+	if err != nil {
+		return Collection{}, fmt.Errorf("could not read alias %q: %w", name, err)
+	}
+	if path == noPrompt {
+		return Collection{}, fmt.Errorf("%w: %q", ErrAliasNotFound, name)
+	}
+
+	return Collection{secrets: s, path: path}, nil
+}
+
+// CreateCollection creates a collection with the given label. When alias is not empty, the
+// collection gets the alias, e.g. "default". A service that already has a collection with the
+// alias may return that collection instead.
+//
+// Services such as gnome-keyring show a prompt to ask for the password of the new collection,
+// CreateCollection waits until the user answers it. Returns ErrDismissed if the prompt is
+// dismissed and ErrClosed if Secrets is closed while waiting.
+func (s *Secrets) CreateCollection(label string, alias string) (Collection, error) {
+	return s.CreateCollectionContext(context.Background(), label, alias)
+}
+
+// CreateCollectionContext is like CreateCollection but dismisses the prompt and returns an error
+// wrapping ctx.Err() when ctx is done.
+func (s *Secrets) CreateCollectionContext(
+	ctx context.Context,
+	label string,
+	alias string,
+) (Collection, error) {
+	properties := map[string]dbus.Variant{
+		dbusCollectionInterface + ".Label": dbus.MakeVariant(label),
+	}
+
+	var path, prompt dbus.ObjectPath
+	err := s.call(ctx, s.obj, dbusServiceInterface+".CreateCollection", properties, alias).
+		Store(&path, &prompt)
+	if err != nil {
+		return Collection{}, fmt.Errorf("could not create collection %q: %w", label, err)
+	}
+
+	if prompt != noPrompt {
+		result, dismissed, err := s.PerformPromptContext(ctx, prompt, "")
+		if err != nil {
+			return Collection{}, fmt.Errorf("could not create collection %q: %w", label, err)
+		}
+		if dismissed {
+			return Collection{}, fmt.Errorf("could not create collection %q: %w", label, ErrDismissed)
+		}
+		if err := result.Store(&path); err != nil {
+			return Collection{}, fmt.Errorf("unexpected result of prompt %s: %w", prompt, err)
+		}
+	}
+	if path == noPrompt {
+		return Collection{}, fmt.Errorf("could not create collection %q: service returned no collection", label)
+	}
+
+	return Collection{secrets: s, path: path}, nil
+}
+
+// CreateItem creates an item with the properties and secret in the collection. With replace, an
+// item of the collection with the same attributes is replaced instead, otherwise an item is
+// added even if one with the same attributes exists. Returns ErrReadOnlyCollection when the
+// collection is read-only, see ReadOnly.
+//
+// Some services show a prompt when the collection is locked, CreateItem waits until the user
+// answers it and returns ErrDismissed if it is dismissed. Other services fail instead, unlock the
+// collection first using Secrets.WithUnlocked.
+func (c Collection) CreateItem(props ItemProperties, secret Secret, replace bool) (Item, error) {
+	return c.CreateItemContext(context.Background(), props, secret, replace)
+}
+
+// CreateItemContext is like CreateItem but aborts the calls and the prompt when ctx is done.
+func (c Collection) CreateItemContext(
+	ctx context.Context,
+	props ItemProperties,
+	secret Secret,
+	replace bool,
+) (Item, error) {
+	if err := c.secrets.checkWritable(ctx, c.path); err != nil {
+		return Item{}, err
+	}
+
+	path, err := c.secrets.createItemIn(
+		ctx,
+		c.path,
+		props.Label,
+		props.Attributes,
+		secret,
+		replace,
+	)
+	if err != nil {
+		return Item{}, err
+	}
+
+	items, err := c.secrets.getItems(ctx, newPropertyCache(), []dbus.ObjectPath{path})
+	if err != nil {
+		return Item{}, err
+	}
+
+	return items[0], nil
+}
+
+// Path returns the object path of the collection.
+func (c Collection) Path() dbus.ObjectPath {
+	return c.path
+}
+
+// Label returns the label of the collection.
+func (c Collection) Label() (string, error) {
+	return c.LabelContext(context.Background())
+}
+
+// LabelContext is like Label but aborts the call when ctx is done.
+func (c Collection) LabelContext(ctx context.Context) (string, error) {
+	var label string
+	if err := c.getProperty(ctx, "Label", &label); err != nil {
+		return "", err
+	}
+
+	return label, nil
+}
+
+// Locked returns whether the collection is locked.
+func (c Collection) Locked() (bool, error) {
+	return c.LockedContext(context.Background())
+}
+
+// LockedContext is like Locked but aborts the call when ctx is done.
+func (c Collection) LockedContext(ctx context.Context) (bool, error) {
+	var locked bool
+	if err := c.getProperty(ctx, "Locked", &locked); err != nil {
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// Created returns the time the collection was created.
+func (c Collection) Created() (time.Time, error) {
+	return c.CreatedContext(context.Background())
+}
+
+// CreatedContext is like Created but aborts the call when ctx is done.
+func (c Collection) CreatedContext(ctx context.Context) (time.Time, error) {
+	var created uint64
+	if err := c.getProperty(ctx, "Created", &created); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(int64(created), 0), nil
+}
+
+// Modified returns the time the collection was last changed. Some providers, such as keepassxc,
+// only update it when an item is written.
+func (c Collection) Modified() (time.Time, error) {
+	return c.ModifiedContext(context.Background())
+}
+
+// ModifiedContext is like Modified but aborts the call when ctx is done.
+func (c Collection) ModifiedContext(ctx context.Context) (time.Time, error) {
+	var modified uint64
+	if err := c.getProperty(ctx, "Modified", &modified); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(int64(modified), 0), nil
+}
+
+// SetLabel changes the label of the collection. Returns ErrReadOnlyCollection when the collection
+// is read-only, see ReadOnly.
+func (c Collection) SetLabel(label string) error {
+	return c.SetLabelContext(context.Background(), label)
+}
+
+// SetLabelContext is like SetLabel but aborts the call when ctx is done.
+func (c Collection) SetLabelContext(ctx context.Context, label string) error {
+	if err := c.secrets.checkWritable(ctx, c.path); err != nil {
+		return err
+	}
+
+	obj := c.secrets.conn.Object(c.secrets.dest, c.path)
+	err := c.secrets.call(
+		ctx,
+		obj,
+		propertiesInterface+".Set",
+		dbusCollectionInterface,
+		"Label",
+		dbus.MakeVariant(label),
+	).Err
+	if err != nil {
+		err = c.secrets.writeError(c.path, err)
+		return fmt.Errorf("could not set Label of collection %s: %w", c.path, err)
+	}
+
+	return nil
+}
+
+func (c Collection) getProperty(ctx context.Context, name string, value interface{}) error {
+	var variant dbus.Variant
+	obj := c.secrets.conn.Object(c.secrets.dest, c.path)
+	err := c.secrets.call(ctx, obj, propertiesInterface+".Get", dbusCollectionInterface, name).
+		Store(&variant)
+	if err != nil {
+		return fmt.Errorf("could not get %s of collection %s: %w", name, c.path, err)
+	}
+
+	if err := variant.Store(value); err != nil {
+		return fmt.Errorf("unexpected type of %s of collection %s: %w", name, c.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"testing"
+)
+
+func TestGetCollections(t *testing.T) {
+	s, service := newTestService(t)
+	work := service.AddCollection(t, "work", "Work")
+	service.SetLocked(work, true)
+
+	collections, err := s.GetCollections()
+	if err != nil {
+		t.Fatalf("GetCollections() error = %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, c := range collections {
+		label, err := c.Label()
+		if err != nil {
+			t.Fatalf("Label() of %s error = %v", c.Path(), err)
+		}
+		locked, err := c.Locked()
+		if err != nil {
+			t.Fatalf("Locked() of %s error = %v", c.Path(), err)
+		}
+		got[label] = locked
+	}
+	if len(got) != 2 || got["Login"] || !got["Work"] {
+		t.Fatalf("GetCollections() labels and locked states = %v, want Login unlocked and Work locked", got)
+	}
+}
+
+func TestReadAlias(t *testing.T) {
+	s, service := newTestService(t)
+
+	c, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	if c.Path() != testLoginCollection {
+		t.Fatalf("ReadAlias() = %s, want %s", c.Path(), testLoginCollection)
+	}
+
+	work := service.AddCollection(t, "work", "Work")
+	service.SetAlias("work", work)
+	c, err = s.ReadAlias("work")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	if label, err := c.Label(); err != nil || label != "Work" {
+		t.Fatalf("Label() = %q, %v, want Work", label, err)
+	}
+
+	if _, err := s.ReadAlias("missing"); !errors.Is(err, ErrAliasNotFound) {
+		t.Fatalf("ReadAlias() error = %v, want ErrAliasNotFound", err)
+	}
+}
+
+func TestCreateCollection(t *testing.T) {
+	tests := map[string]bool{
+		"without prompt": false,
+		"with prompt":    true,
+	}
+
+	for name, prompts := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, service := newTestService(t)
+			service.SetCreateCollectionPrompts(prompts)
+
+			c, err := s.CreateCollection("Work", "work")
+			if err != nil {
+				t.Fatalf("CreateCollection() error = %v", err)
+			}
+			if label, err := c.Label(); err != nil || label != "Work" {
+				t.Fatalf("Label() = %q, %v, want Work", label, err)
+			}
+			aliased, err := s.ReadAlias("work")
+			if err != nil {
+				t.Fatalf("ReadAlias() error = %v", err)
+			}
+			if aliased.Path() != c.Path() {
+				t.Fatalf("ReadAlias() = %s, want %s", aliased.Path(), c.Path())
+			}
+
+			wantPrompts := 0
+			if prompts {
+				wantPrompts = 1
+			}
+			if n := service.Calls("org.freedesktop.Secret.Prompt.Prompt"); n != wantPrompts {
+				t.Fatalf("Prompt called %d times, want %d", n, wantPrompts)
+			}
+		})
+	}
+}
+
+func TestCreateCollectionDismissed(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetCreateCollectionPrompts(true)
+	service.SetPromptDismissed(true)
+
+	if _, err := s.CreateCollection("Work", "work"); !errors.Is(err, ErrDismissed) {
+		t.Fatalf("CreateCollection() error = %v, want ErrDismissed", err)
+	}
+	if _, err := s.ReadAlias("work"); !errors.Is(err, ErrAliasNotFound) {
+		t.Fatalf("ReadAlias() error = %v, want ErrAliasNotFound", err)
+	}
+}
+
+func TestCollectionCreateItem(t *testing.T) {
+	s, _ := newTestService(t)
+	c := Collection{secrets: s, path: testLoginCollection}
+	props := ItemProperties{Label: "token", Attributes: map[string]string{"app": "a"}}
+
+	first, err := c.CreateItem(props, Secret{Value: []byte("1"), ContentType: "text/plain"}, false)
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	if first.Label != "token" || first.Attributes["app"] != "a" || first.CollectionLabel != "Login" {
+		t.Fatalf("CreateItem() = %+v, want the properties of the new item", first)
+	}
+
+	// Without replace, an item with the same attributes is added
+	duplicate, err := c.CreateItem(props, Secret{Value: []byte("2"), ContentType: "text/plain"}, false)
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	if duplicate.Path == first.Path {
+		t.Fatalf("CreateItem() without replace returned the existing item %s", first.Path)
+	}
+
+	props.Label = "replaced"
+	replaced, err := c.CreateItem(props, Secret{Value: []byte("3"), ContentType: "text/plain"}, true)
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	if replaced.Path != first.Path || replaced.Label != "replaced" {
+		t.Fatalf("CreateItem() with replace = %+v, want %s replaced", replaced, first.Path)
+	}
+	items, err := s.FindItems(map[string]string{"app": "a"}, FindOpts{WithSecrets: true})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	secrets := make(map[dbus.ObjectPath]string)
+	for _, item := range items {
+		secrets[item.Path] = string(item.Secret)
+	}
+	if len(secrets) != 2 || secrets[first.Path] != "3" || secrets[duplicate.Path] != "2" {
+		t.Fatalf("FindItems() secrets = %v, want the first item replaced and the duplicate kept", secrets)
+	}
+}
+
+func TestCollectionCreateItemLocked(t *testing.T) {
+	s, service := newTestService(t)
+	c := Collection{secrets: s, path: testLoginCollection}
+	props := ItemProperties{Label: "token", Attributes: map[string]string{"app": "a"}}
+	service.SetLocked(testLoginCollection, true)
+
+	if _, err := c.CreateItem(props, Secret{Value: []byte("1")}, false); !isDbusError(err, errIsLocked) {
+		t.Fatalf("CreateItem() error = %v, want IsLocked", err)
+	}
+
+	service.SetCreateItemPrompts(true)
+	service.SetPromptDismissed(true)
+	if _, err := c.CreateItem(props, Secret{Value: []byte("1")}, false); !errors.Is(err, ErrDismissed) {
+		t.Fatalf("CreateItem() error = %v, want ErrDismissed", err)
+	}
+
+	service.SetPromptDismissed(false)
+	item, err := c.CreateItem(props, Secret{Value: []byte("1")}, false)
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	if item.Label != "token" || item.Locked {
+		t.Fatalf("CreateItem() = %+v, want an unlocked item", item)
+	}
+}
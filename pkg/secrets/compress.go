@@ -0,0 +1,142 @@
+package secrets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+)
+
+// Compressed secrets
+//
+// Secrets larger than the threshold set by WithCompression are stored compressed when that makes
+// them smaller. The format is defined as follows so that other implementations can read and
+// write it:
+//
+//   - The item has the attributes of the secret plus secret-compression: "gzip". The attribute,
+//     not the content type, marks the secret as compressed, some providers do not store content
+//     types.
+//   - The secret of the item is the gzip stream, RFC 1952, of the secret.
+//   - The content type of the item is "application/gzip" with the content type of the secret, if
+//     any, in the parameter content-type, e.g. `application/gzip; content-type="text/plain"`.
+//   - A compressed secret can be chunked, see chunk.go. The secret is compressed first and the
+//     chunks are made of the gzip stream, every chunk has the compression attribute.
+//
+// Readers that do not know the format find compressed items using the attributes of the secret,
+// e.g. secret-tool lookup, and get the gzip stream instead of the secret. The stream starts with
+// the bytes 0x1f 0x8b, which is not valid UTF-8, and the content type is not that of the secret,
+// so that readers that expect text or check the content type fail instead of using the stream.
+// Only enable compression when every reader of the secrets uses this package or implements the
+// format.
+const (
+	compressionAttr = "secret-compression"
+	compressionGzip = "gzip"
+
+	compressedContentType      = "application/gzip"
+	compressedContentTypeParam = "content-type"
+)
+
+// WithCompression makes GetOrCreate store secrets larger than threshold bytes gzip-compressed,
+// for providers that are slow with or refuse large items. A secret that does not get smaller is
+// stored as it is. Zero, the default, disables compression. Compression is applied before
+// chunking, see WithChunkSize.
+//
+// Compressed secrets are decompressed by FindItems and GetOrCreate, whether or not this option
+// is set. Other readers get the compressed bytes, see the format in the source, compress.go.
+func WithCompression(threshold int) Option {
+	return func(o *options) {
+		o.compressionThreshold = threshold
+	}
+}
+
+// compressSecret returns the compressed value and the attributes of the compressed item. The
+// value and attributes are returned as they are when compressing does not make value smaller.
+func compressSecret(
+	value Secret,
+	attributes map[string]string,
+) (Secret, map[string]string, error) {
+	compressed, err := gzipSecret(value)
+	if err != nil {
+		return Secret{}, nil, err
+	}
+	if len(compressed.Value) >= len(value.Value) {
+		return value, attributes, nil
+	}
+
+	attributes = maps.Clone(attributes)
+	attributes[compressionAttr] = compressionGzip
+
+	return compressed, attributes, nil
+}
+
+// gzipSecret returns the compressed form of value.
+func gzipSecret(value Secret) (Secret, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(value.Value); err != nil {
+		return Secret{}, fmt.Errorf("could not compress secret: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return Secret{}, fmt.Errorf("could not compress secret: %w", err)
+	}
+
+	contentType := compressedContentType
+	if value.ContentType != "" {
+		contentType = mime.FormatMediaType(
+			compressedContentType,
+			map[string]string{compressedContentTypeParam: value.ContentType},
+		)
+	}
+
+	return Secret{Value: b.Bytes(), ContentType: contentType}, nil
+}
+
+// decompressItems replaces the compressed secrets among items by the secrets, see
+// decompressItem.
+func decompressItems(items []Item) ([]Item, error) {
+	for i := range items {
+		if err := decompressItem(&items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// decompressItem removes the compression attribute of a compressed secret and decompresses the
+// secret, if it was retrieved. Returns an error wrapping ErrCorruptSecret when the secret cannot
+// be decompressed.
+func decompressItem(item *Item) error {
+	format, ok := item.Attributes[compressionAttr]
+	if !ok {
+		return nil
+	}
+	if format != compressionGzip {
+		return fmt.Errorf("item %s has unsupported compression %q", item.Path, format)
+	}
+
+	item.compressed = true
+	item.Attributes = maps.Clone(item.Attributes)
+	delete(item.Attributes, compressionAttr)
+	if item.Secret == nil {
+		return nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(item.Secret))
+	if err == nil {
+		item.Secret, err = io.ReadAll(r)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: could not decompress secret of %q: %w", ErrCorruptSecret, item.Label, err)
+	}
+
+	_, params, err := mime.ParseMediaType(item.ContentType)
+	item.ContentType = ""
+	if err == nil {
+		item.ContentType = params[compressedContentTypeParam]
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"maps"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCompression(t *testing.T) {
+	service := secretstest.New(t)
+	service.SetMaxSecretSize(1024)
+	s, err := New(WithConn(service.Connect(t)), WithCompression(100), WithChunkSize(1000))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	// Compressed to about 200 bytes, below the size limit of the service
+	value := bytes.Repeat([]byte("credential bundle "), 10_000)
+	attributes := map[string]string{"app": "bundle"}
+	generate := func() (Secret, error) {
+		return Secret{Value: value, ContentType: "text/plain"}, nil
+	}
+
+	item, secret, created, err := s.GetOrCreateContext(ctx, "Bundle", attributes, generate)
+	if err != nil {
+		t.Fatalf("GetOrCreate() error = %v", err)
+	}
+	if !created || !bytes.Equal(secret.Value, value) || secret.ContentType != "text/plain" {
+		t.Fatalf("GetOrCreate() = %q, created %t, equal %t",
+			secret.ContentType, created, bytes.Equal(secret.Value, value))
+	}
+	if !maps.Equal(item.Attributes, attributes) || len(item.chunks) != 0 {
+		t.Fatalf("GetOrCreate() item = %v, %d chunks, want a single item without the compression "+
+			"attribute", item.Attributes, len(item.chunks))
+	}
+
+	// Readers that do not know the format get the gzip stream and the marker
+	stored, _ := service.Secret(item.Path)
+	storedAttributes, _ := service.Attributes(item.Path)
+	if utf8.Valid(stored) || len(stored) >= len(value) {
+		t.Fatalf("stored secret of %d bytes is not compressed", len(stored))
+	}
+	if storedAttributes[compressionAttr] != compressionGzip {
+		t.Fatalf("stored attributes = %v, want %s", storedAttributes, compressionAttr)
+	}
+	unaware, _, err := s.SearchItems(attributes)
+	if err != nil || len(unaware) != 1 || unaware[0].Attributes[compressionAttr] != compressionGzip {
+		t.Fatalf("SearchItems() = %v, %v, want the item as stored", unaware, err)
+	}
+
+	// The setters keep the item compressed
+	if err := item.SetAttributes(map[string]string{"app": "bundle", "version": "2"}); err != nil {
+		t.Fatalf("SetAttributes() error = %v", err)
+	}
+	value = bytes.Repeat([]byte("rotated bundle "), 10_000)
+	if err := item.SetSecret(Secret{Value: value, ContentType: "application/json"}); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	items, err := s.FindItems(attributes, FindOpts{WithSecrets: true})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("FindItems() = %v, %v, want the item", items, err)
+	}
+	if !bytes.Equal(items[0].Secret, value) || items[0].ContentType != "application/json" {
+		t.Fatalf("FindItems() secret = %q, equal %t, want the new secret",
+			items[0].ContentType, bytes.Equal(items[0].Secret, value))
+	}
+	if items[0].Attributes["version"] != "2" || len(items[0].Attributes) != 2 {
+		t.Fatalf("FindItems() attributes = %v", items[0].Attributes)
+	}
+	if err := items[0].Reload(); err != nil || !maps.Equal(items[0].Attributes, item.Attributes) {
+		t.Fatalf("Reload() attributes = %v, %v, want %v", items[0].Attributes, err, item.Attributes)
+	}
+
+	// Secrets that do not compress are chunked as they are
+	random := make([]byte, 3000)
+	_, _ = rand.Read(random)
+	generate = func() (Secret, error) {
+		return Secret{Value: random}, nil
+	}
+	randomAttributes := map[string]string{"app": "random"}
+	item, secret, _, err = s.GetOrCreateContext(ctx, "Random", randomAttributes, generate)
+	if err != nil || !bytes.Equal(secret.Value, random) {
+		t.Fatalf("GetOrCreate() of random data error = %v", err)
+	}
+	if len(item.chunks) != 3 || item.compressed {
+		t.Fatalf("GetOrCreate() of random data = %d chunks, compressed %t, want 3 plain chunks",
+			len(item.chunks), item.compressed)
+	}
+
+	// Uncompressed items are passed through
+	plain := service.AddItem(t, testLoginCollection, "Plain", map[string]string{"app": "plain"}, value)
+	items, err = s.FindItems(map[string]string{"app": "plain"}, FindOpts{WithSecrets: true})
+	if err != nil || len(items) != 1 || !bytes.Equal(items[0].Secret, value) {
+		t.Fatalf("FindItems() of an uncompressed item = %v, %v", items, err)
+	}
+
+	// A marked secret that is not a gzip stream is corrupt
+	marked := map[string]string{"app": "plain", compressionAttr: compressionGzip}
+	if err := s.SetItemAttributes(plain, marked); err != nil {
+		t.Fatalf("SetItemAttributes() error = %v", err)
+	}
+	_, err = s.FindItems(map[string]string{"app": "plain"}, FindOpts{WithSecrets: true})
+	if !errors.Is(err, ErrCorruptSecret) {
+		t.Fatalf("FindItems() of a corrupt compressed secret error = %v, want ErrCorruptSecret", err)
+	}
+}
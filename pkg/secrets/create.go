@@ -0,0 +1,355 @@
+package secrets
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"slices"
+)
+
+// ErrNoDefaultCollection is returned when the secret service has no default collection to create
+// items in.
+var ErrNoDefaultCollection = errors.New("no default collection")
+
+// Secret is the value of an item together with its content type, e.g. "text/plain".
+type Secret struct {
+	Value       []byte
+	ContentType string
+}
+
+// GetOrCreate returns the item that has the given attributes together with its secret. When
+// there is no such item, generate is called and an item with the given label and the generated
+// secret is created in the default collection. A secret larger than the size set by
+// WithChunkSize is stored in several items and one larger than the threshold set by
+// WithCompression is stored compressed, see FindItems.
+//
+// GetOrCreate can be called by several processes at the same time. After creating the item, the
+// items are searched again and the one created first is kept, the item created by this call is
+// deleted when it lost. Items created in the same second are ordered by path, shorter paths first,
+// so that all callers keep the same item. Services that number their items keep the item that
+// was created first.
+//
+// Locked items and the default collection are unlocked, which can show a prompt to the user.
+// Returns ErrDismissed if the prompt is dismissed. Returns ErrReadOnlyCollection, before
+// generate is called, when there is no such item and the default collection is read-only.
+// Returns ErrPossiblyLocked, instead of creating a duplicate, when the provider hides the items of
+// locked collections and a collection stays locked, see ProviderInfo.HidesLockedItems.
+//
+// The returned bool reports whether the item was created by this call.
+func (s *Secrets) GetOrCreate(
+	label string,
+	attributes map[string]string,
+	generate func() (Secret, error),
+) (*Item, Secret, bool, error) {
+	return s.GetOrCreateContext(context.Background(), label, attributes, generate)
+}
+
+// GetOrCreateContext is like GetOrCreate but aborts the calls when ctx is done. When ctx is done
+// while waiting for a prompt, the prompt is dismissed and ctx.Err() is returned.
+func (s *Secrets) GetOrCreateContext(
+	ctx context.Context,
+	label string,
+	attributes map[string]string,
+	generate func() (Secret, error),
+) (*Item, Secret, bool, error) {
+	return s.getOrCreate(ctx, Target{}, label, attributes, generate)
+}
+
+// GetOrCreateIn is GetOrCreate for the collection of target: only its items are searched and the
+// item is created in it. When no collection has the alias or label of target, the error of
+// ResolveTarget is returned before anything is searched, e.g. so that a CLI can offer to create
+// the collection. For a label, the item is searched in the first collection with the label and
+// created in the first one that is not read-only.
+func (s *Secrets) GetOrCreateIn(
+	target Target,
+	label string,
+	attributes map[string]string,
+	generate func() (Secret, error),
+) (*Item, Secret, bool, error) {
+	return s.GetOrCreateInContext(context.Background(), target, label, attributes, generate)
+}
+
+// GetOrCreateInContext is like GetOrCreateIn but aborts the calls when ctx is done. When ctx is
+// done while waiting for a prompt, the prompt is dismissed and ctx.Err() is returned.
+func (s *Secrets) GetOrCreateInContext(
+	ctx context.Context,
+	target Target,
+	label string,
+	attributes map[string]string,
+	generate func() (Secret, error),
+) (*Item, Secret, bool, error) {
+	if target.IsZero() {
+		target = AliasTarget("default")
+	}
+
+	return s.getOrCreate(ctx, target, label, attributes, generate)
+}
+
+// getOrCreate is GetOrCreateInContext, the zero target searches every collection and creates the
+// item in the default collection, which is read every time.
+func (s *Secrets) getOrCreate(
+	ctx context.Context,
+	target Target,
+	label string,
+	attributes map[string]string,
+	generate func() (Secret, error),
+) (*Item, Secret, bool, error) {
+	search, err := s.resolveTarget(ctx, target, false)
+	if err != nil {
+		return nil, Secret{}, false, err
+	}
+	cache := newPropertyCache()
+	item, err := s.findFirst(ctx, cache, search, attributes)
+	if err != nil {
+		return nil, Secret{}, false, err
+	}
+	if item != nil {
+		return item, itemSecret(item), false, nil
+	}
+
+	var collection dbus.ObjectPath
+	if target.IsZero() {
+		collection, err = s.defaultCollection(ctx)
+		if err == nil {
+			err = s.checkWritable(ctx, collection)
+		}
+	} else {
+		collection, err = s.resolveTarget(ctx, target, true)
+	}
+	if err != nil {
+		return nil, Secret{}, false, err
+	}
+
+	secret, err := generate()
+	if err != nil {
+		return nil, Secret{}, false, fmt.Errorf("could not generate secret: %w", err)
+	}
+
+	created, err := s.createItem(ctx, collection, label, attributes, secret)
+	// The collection has been unlocked
+	cache.forget(collection)
+	if err != nil {
+		return nil, Secret{}, false, err
+	}
+
+	// Another caller might have created an item at the same time
+	if !target.IsZero() {
+		search = collection
+	}
+	item, err = s.findFirst(ctx, cache, search, attributes)
+	if err != nil {
+		return nil, Secret{}, false, err
+	}
+	if item == nil {
+		return nil, Secret{}, false, fmt.Errorf("created item %s is gone", created[0])
+	}
+	if item.Path == created[0] {
+		return item, itemSecret(item), true, nil
+	}
+
+	if err := s.deleteItems(ctx, created); err != nil {
+		return nil, Secret{}, false, fmt.Errorf("could not delete duplicate item: %w", err)
+	}
+
+	return item, itemSecret(item), false, nil
+}
+
+// findFirst returns the first created item with the given attributes in the collection, or in
+// every collection when collection is empty, with its secret, or nil if there is none. The
+// properties are read through cache.
+func (s *Secrets) findFirst(
+	ctx context.Context,
+	cache *propertyCache,
+	collection dbus.ObjectPath,
+	attributes map[string]string,
+) (*Item, error) {
+	opts := FindOpts{WithSecrets: true, Unlock: true}
+	if collection != "" {
+		opts.Target = Target{path: collection}
+	}
+	items, err := s.findItems(ctx, cache, attributes, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	item := slices.MinFunc(items, func(a, b Item) int {
+		return cmp.Or(
+			a.Created.Compare(b.Created),
+			cmp.Compare(len(a.Path), len(b.Path)),
+			cmp.Compare(a.Path, b.Path),
+		)
+	})
+	if item.Locked {
+		return nil, ErrDismissed
+	}
+
+	return &item, nil
+}
+
+func itemSecret(item *Item) Secret {
+	return Secret{
+		Value:       item.Secret,
+		ContentType: item.ContentType,
+	}
+}
+
+// defaultCollection returns the collection with the "default" alias. Returns
+// ErrNoDefaultCollection when there is none.
+func (s *Secrets) defaultCollection(ctx context.Context) (dbus.ObjectPath, error) {
+	var collection dbus.ObjectPath
+	err := s.call(ctx, s.obj, dbusServiceInterface+".ReadAlias", "default").Store(&collection)
+	if err != nil {
+		return "", fmt.Errorf("could not read default collection: %w", err)
+	}
+	if collection == noPrompt {
+		return "", ErrNoDefaultCollection
+	}
+
+	return collection, nil
+}
+
+// createItem creates an item in the collection without replacing existing items, unlocking the
+// collection first. The collection must have been checked using checkWritable. The value is
+// compressed if it is larger than the compression threshold, see WithCompression, and then
+// chunked if it is larger than the chunk size, see WithChunkSize. Returns the paths of the
+// created items, the first is the path of the item.
+func (s *Secrets) createItem(
+	ctx context.Context,
+	collection dbus.ObjectPath,
+	label string,
+	attributes map[string]string,
+	value Secret,
+) ([]dbus.ObjectPath, error) {
+	if err := s.unlockCollection(ctx, collection); err != nil {
+		return nil, err
+	}
+
+	if s.compressionThreshold > 0 && len(value.Value) > s.compressionThreshold {
+		var err error
+		value, attributes, err = compressSecret(value, attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.chunkSize > 0 && len(value.Value) > s.chunkSize {
+		return s.createChunks(ctx, collection, label, attributes, value)
+	}
+
+	path, err := s.createItemIn(ctx, collection, label, attributes, value, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return []dbus.ObjectPath{path}, nil
+}
+
+// unlockCollection unlocks the collection, showing a prompt if needed. Returns ErrDismissed when
+// the collection stays locked.
+func (s *Secrets) unlockCollection(ctx context.Context, collection dbus.ObjectPath) error {
+	unlocked, err := s.unlock(ctx, []dbus.ObjectPath{collection})
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(unlocked, collection) {
+		return ErrDismissed
+	}
+
+	return nil
+}
+
+// createItemIn creates an item in the unlocked collection. With replace, an item with the same
+// attributes is replaced instead.
+func (s *Secrets) createItemIn(
+	ctx context.Context,
+	collection dbus.ObjectPath,
+	label string,
+	attributes map[string]string,
+	value Secret,
+	replace bool,
+) (dbus.ObjectPath, error) {
+	session, err := s.openSession(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	properties := map[string]dbus.Variant{
+		dbusItemInterface + ".Label":      dbus.MakeVariant(label),
+		dbusItemInterface + ".Attributes": dbus.MakeVariant(attributes),
+	}
+	itemSecret := secret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       value.Value,
+		ContentType: value.ContentType,
+	}
+	var item, prompt dbus.ObjectPath
+	err = s.call(
+		ctx,
+		s.conn.Object(s.dest, collection),
+		dbusCollectionInterface+".CreateItem",
+		properties,
+		itemSecret,
+		replace,
+	).Store(&item, &prompt)
+	if err != nil {
+		return "", errors.Join(
+			fmt.Errorf("could not create item: %w", s.writeError(collection, err)),
+			s.closeSession(context.WithoutCancel(ctx), session),
+		)
+	}
+	if err := s.closeSession(context.WithoutCancel(ctx), session); err != nil {
+		return "", err
+	}
+
+	if prompt == noPrompt {
+		return item, nil
+	}
+
+	result, dismissed, err := s.PerformPromptContext(ctx, prompt, "")
+	if err != nil {
+		return "", err
+	}
+	if dismissed {
+		return "", ErrDismissed
+	}
+	if err := result.Store(&item); err != nil {
+		return "", fmt.Errorf("unexpected result of create prompt: %w", err)
+	}
+	if item == noPrompt {
+		return "", errors.New("could not create item: service returned no item")
+	}
+
+	return item, nil
+}
+
+// deleteItem deletes the item, showing a prompt if needed.
+func (s *Secrets) deleteItem(ctx context.Context, item dbus.ObjectPath) error {
+	var prompt dbus.ObjectPath
+	err := s.call(ctx, s.conn.Object(s.dest, item), dbusItemInterface+".Delete").Store(&prompt)
+	if isNoSuchObject(err) {
+		return fmt.Errorf("could not delete item %s: %w: %w", item, ErrNoSuchObject, err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete item %s: %w", item, err)
+	}
+
+	if prompt == noPrompt {
+		return nil
+	}
+
+	_, dismissed, err := s.PerformPromptContext(ctx, prompt, "")
+	if err != nil {
+		return err
+	}
+	if dismissed {
+		return ErrDismissed
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestGetOrCreate(t *testing.T) {
+	s, service := newTestService(t)
+	attributes := map[string]string{"app": "agent"}
+	generated := 0
+	generate := func() (Secret, error) {
+		generated++
+		return Secret{Value: []byte("hunter2"), ContentType: "text/plain"}, nil
+	}
+
+	item, secret, created, err := s.GetOrCreate("token", attributes, generate)
+	if err != nil {
+		t.Fatalf("GetOrCreate() error = %v", err)
+	}
+	if !created || generated != 1 {
+		t.Fatalf("GetOrCreate() created = %t, generated %d times, want created once", created, generated)
+	}
+	if item.Label != "token" || string(secret.Value) != "hunter2" || secret.ContentType != "text/plain" {
+		t.Fatalf("GetOrCreate() = %+v, %+v", item, secret)
+	}
+	if got, _ := service.Secret(item.Path); string(got) != "hunter2" {
+		t.Fatalf("stored secret = %q, want %q", got, "hunter2")
+	}
+
+	again, secret, created, err := s.GetOrCreate("token", attributes, generate)
+	if err != nil {
+		t.Fatalf("GetOrCreate() error = %v", err)
+	}
+	if created || generated != 1 || again.Path != item.Path || string(secret.Value) != "hunter2" {
+		t.Fatalf("GetOrCreate() = %s, created %t, generated %d times, want existing %s",
+			again.Path, created, generated, item.Path)
+	}
+}
+
+func TestGetOrCreateConcurrent(t *testing.T) {
+	s1, service := newTestService(t)
+	s2, err := New(WithConn(service.Connect(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	attributes := map[string]string{"app": "agent"}
+
+	// Both clients find nothing before either creates its item
+	var searched sync.WaitGroup
+	searched.Add(2)
+
+	type result struct {
+		item    *Item
+		secret  Secret
+		created bool
+		err     error
+	}
+	results := make([]result, 2)
+	var wg sync.WaitGroup
+	for i, s := range []*Secrets{s1, s2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value := []byte{byte('a' + i)}
+			r := &results[i]
+			r.item, r.secret, r.created, r.err = s.GetOrCreate(
+				"token",
+				attributes,
+				func() (Secret, error) {
+					searched.Done()
+					searched.Wait()
+					return Secret{Value: value, ContentType: "text/plain"}, nil
+				},
+			)
+		}()
+	}
+	wg.Wait()
+
+	for i, r := range results {
+		if r.err != nil {
+			t.Fatalf("client %d: GetOrCreate() error = %v", i, r.err)
+		}
+	}
+	if results[0].created == results[1].created {
+		t.Fatalf("created = %t and %t, want exactly one creator", results[0].created, results[1].created)
+	}
+	if results[0].item.Path != results[1].item.Path {
+		t.Fatalf("clients got %s and %s, want the same item", results[0].item.Path, results[1].item.Path)
+	}
+	if string(results[0].secret.Value) != string(results[1].secret.Value) {
+		t.Fatalf("clients got secrets %q and %q", results[0].secret.Value, results[1].secret.Value)
+	}
+
+	items, err := s1.FindItems(attributes, FindOpts{})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("%d items left, want 1", len(items))
+	}
+}
+
+func TestGetOrCreateDismissed(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetLocked(testLoginCollection, true)
+	service.SetPromptDismissed(true)
+
+	_, _, _, err := s.GetOrCreate(
+		"token",
+		map[string]string{"app": "agent"},
+		func() (Secret, error) {
+			return Secret{Value: []byte("hunter2"), ContentType: "text/plain"}, nil
+		},
+	)
+	if !errors.Is(err, ErrDismissed) {
+		t.Fatalf("GetOrCreate() error = %v, want ErrDismissed", err)
+	}
+}
@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+)
+
+// ErrNoSuchObject is returned when an item or collection does not exist, e.g. because it has
+// already been deleted.
+var ErrNoSuchObject = errors.New("no such object")
+
+// Delete deletes the item, showing a prompt if needed. Every chunk of a chunked secret is
+// deleted, see WithChunkSize. Returns ErrDismissed if the prompt is dismissed and
+// ErrNoSuchObject if the item has already been deleted. The item must have been returned by
+// Secrets, e.g. by FindItems.
+func (i *Item) Delete() error {
+	return i.DeleteContext(context.Background())
+}
+
+// DeleteContext is like Delete but aborts the calls when ctx is done.
+func (i *Item) DeleteContext(ctx context.Context) error {
+	s, err := i.owner()
+	if err != nil {
+		return fmt.Errorf("could not delete item %s: %w", i.Path, err)
+	}
+
+	if len(i.chunks) > 0 {
+		return s.deleteItems(ctx, i.chunks)
+	}
+
+	return s.deleteItem(ctx, i.Path)
+}
+
+// Delete deletes the collection and all of its items. Services such as gnome-keyring show a
+// prompt to confirm, Delete waits until the user answers it. Returns ErrDismissed if the prompt
+// is dismissed and ErrNoSuchObject if the collection has already been deleted.
+func (c Collection) Delete() error {
+	return c.DeleteContext(context.Background())
+}
+
+// DeleteContext is like Delete but dismisses the prompt and returns an error wrapping ctx.Err()
+// when ctx is done.
+func (c Collection) DeleteContext(ctx context.Context) error {
+	var prompt dbus.ObjectPath
+	obj := c.secrets.conn.Object(c.secrets.dest, c.path)
+	err := c.secrets.call(ctx, obj, dbusCollectionInterface+".Delete").Store(&prompt)
+	if isNoSuchObject(err) {
+		return fmt.Errorf("could not delete collection %s: %w: %w", c.path, ErrNoSuchObject, err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete collection %s: %w", c.path, err)
+	}
+
+	if prompt == noPrompt {
+		return nil
+	}
+
+	_, dismissed, err := c.secrets.PerformPromptContext(ctx, prompt, "")
+	if err != nil {
+		return err
+	}
+	if dismissed {
+		return fmt.Errorf("could not delete collection %s: %w", c.path, ErrDismissed)
+	}
+
+	return nil
+}
+
+// isNoSuchObject reports whether err is the error of the bus or the service for an object that
+// does not exist. UnknownInterface is returned by services that still export other interfaces,
+// such as Introspectable, at the path.
+func isNoSuchObject(err error) bool {
+	return isDbusError(err, "org.freedesktop.DBus.Error.UnknownObject") ||
+		isDbusError(err, "org.freedesktop.DBus.Error.UnknownInterface") ||
+		isDbusError(err, "org.freedesktop.DBus.Error.NoSuchObject") ||
+		isDbusError(err, "org.freedesktop.Secret.Error.NoSuchObject")
+}
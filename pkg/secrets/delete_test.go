@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"testing"
+)
+
+func TestItemDelete(t *testing.T) {
+	s, service := newTestService(t)
+	service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "a"}, []byte("1"))
+
+	items, err := s.FindItems(map[string]string{"app": "a"}, FindOpts{})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("FindItems() = %+v, want one item", items)
+	}
+
+	if err := items[0].Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := items[0].Delete(); !errors.Is(err, ErrNoSuchObject) {
+		t.Fatalf("second Delete() error = %v, want ErrNoSuchObject", err)
+	}
+
+	item := Item{Path: items[0].Path}
+	if err := item.Delete(); err == nil {
+		t.Fatal("Delete() of an Item not returned by Secrets succeeded")
+	}
+}
+
+func TestItemDeleteChunked(t *testing.T) {
+	service := secretstest.New(t)
+	s, err := New(WithConn(service.Connect(t)), WithChunkSize(4))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	// A secret that is not chunked must survive the deletion
+	service.AddItem(t, testLoginCollection, "other", map[string]string{"app": "b"}, []byte("1"))
+
+	attributes := map[string]string{"app": "a"}
+	item, _, _, err := s.GetOrCreate("Blob", attributes, func() (Secret, error) {
+		return Secret{Value: []byte("0123456789")}, nil
+	})
+	if err != nil {
+		t.Fatalf("GetOrCreate() error = %v", err)
+	}
+	if len(item.chunks) != 3 {
+		t.Fatalf("GetOrCreate() stored %d chunks, want 3", len(item.chunks))
+	}
+
+	if err := item.Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	items, err := s.FindItems(nil, FindOpts{})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 1 || items[0].Label != "other" {
+		t.Fatalf("FindItems() after Delete = %+v, want only the other item", items)
+	}
+	if err := item.Delete(); !errors.Is(err, ErrNoSuchObject) {
+		t.Fatalf("second Delete() error = %v, want ErrNoSuchObject", err)
+	}
+}
+
+func TestCollectionDelete(t *testing.T) {
+	for name, prompts := range map[string]bool{"without prompt": false, "with prompt": true} {
+		t.Run(name, func(t *testing.T) {
+			s, service := newTestService(t)
+			service.SetDeleteCollectionPrompts(prompts)
+			c, err := s.CreateCollection("Work", "work")
+			if err != nil {
+				t.Fatalf("CreateCollection() error = %v", err)
+			}
+
+			if err := c.Delete(); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := s.ReadAlias("work"); !errors.Is(err, ErrAliasNotFound) {
+				t.Fatalf("ReadAlias() error = %v, want ErrAliasNotFound", err)
+			}
+			if err := c.Delete(); !errors.Is(err, ErrNoSuchObject) {
+				t.Fatalf("second Delete() error = %v, want ErrNoSuchObject", err)
+			}
+		})
+	}
+}
+
+func TestCollectionDeleteDismissed(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetDeleteCollectionPrompts(true)
+	c, err := s.CreateCollection("Work", "work")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	service.SetPromptDismissed(true)
+
+	if err := c.Delete(); !errors.Is(err, ErrDismissed) {
+		t.Fatalf("Delete() error = %v, want ErrDismissed", err)
+	}
+	if _, err := c.Label(); err != nil {
+		t.Fatalf("collection is gone after a dismissed prompt: %v", err)
+	}
+}
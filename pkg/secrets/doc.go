@@ -1,5 +1,10 @@
 // Package secrets allows communication with [org.freedesktop.Secret].
 // Program that provide this API include Gnome Keyring, KDE Wallet, and keepassxc.
 //
+// Every function that calls the secret service has a variant with the Context suffix that takes
+// a context.Context as first argument, e.g. FindItems and FindItemsContext. When ctx is done, the
+// call is aborted and an error wrapping ctx.Err() is returned. The variant without suffix uses
+// context.Background(), so only the timeout set by WithCallTimeout applies.
+//
 // [org.freedesktop.Secret]: https://specifications.freedesktop.org/secret-service-spec/latest/
 package secrets
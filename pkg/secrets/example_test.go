@@ -0,0 +1,27 @@
+package secrets_test
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets"
+	"log"
+)
+
+// Removes the items a test stored, whether or not the test removed some of them already.
+func ExampleItem_Delete() {
+	s, err := secrets.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	items, err := s.FindItems(map[string]string{"test": "my-test"}, secrets.FindOpts{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, item := range items {
+		err := item.Delete()
+		if err != nil && !errors.Is(err, secrets.ErrNoSuchObject) {
+			log.Printf("could not clean up %s: %v", item.Path, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"io"
+	"maps"
+)
+
+// exportVersion is the version of the export format written by Export.
+const exportVersion = 1
+
+// ExportFile is the JSON document written by Secrets.Export and read by Secrets.Import:
+//
+//	{
+//	  "version": 1,
+//	  "items": [
+//	    {
+//	      "label": "GitHub token",
+//	      "attributes": {"service": "github.com", "username": "alice"},
+//	      "content_type": "text/plain",
+//	      "secret": "aHVudGVyMg==",
+//	      "created": 1700000000,
+//	      "modified": 1700000000
+//	    }
+//	  ]
+//	}
+//
+// Items carry the label, lookup attributes and secret, the same data libsecret stores for an
+// item, so they can be recreated by any provider. The secret is base64 encoded. Created and
+// Modified are Unix timestamps in seconds; they are informational, the secret service sets them
+// itself when importing.
+type ExportFile struct {
+	Version int            `json:"version"`
+	Items   []ExportedItem `json:"items"`
+}
+
+// ExportedItem is an item of ExportFile.
+type ExportedItem struct {
+	Label       string            `json:"label"`
+	Attributes  map[string]string `json:"attributes"`
+	ContentType string            `json:"content_type"`
+	Secret      []byte            `json:"secret"`
+	Created     int64             `json:"created"`
+	Modified    int64             `json:"modified"`
+}
+
+// ImportSummary reports what Import did with the items.
+type ImportSummary struct {
+	// Created is the number of items that were created.
+	Created int
+	// Replaced is the number of existing items whose label and secret were replaced.
+	Replaced int
+	// Skipped is the number of items that were left alone because an item with the same
+	// attributes exists.
+	Skipped int
+	// Failed contains an error for every item that could not be imported.
+	Failed []ImportError
+}
+
+// ImportError is the failure to import a single item.
+type ImportError struct {
+	// Index is the position of the item in ExportFile.Items.
+	Index int
+	Label string
+	Err   error
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("could not import item %d (%s): %v", e.Index, e.Label, e.Err)
+}
+
+func (e ImportError) Unwrap() error {
+	return e.Err
+}
+
+// Export writes all items of the collection with their secrets to w, see ExportFile for the
+// format.
+//
+// The collection is unlocked, which can show a prompt to the user. Returns ErrDismissed if the
+// prompt is dismissed.
+func (s *Secrets) Export(collection dbus.ObjectPath, w io.Writer) error {
+	return s.ExportContext(context.Background(), collection, w)
+}
+
+// ExportContext is like Export but aborts the calls when ctx is done. When ctx is done while
+// waiting for a prompt, the prompt is dismissed and ctx.Err() is returned.
+func (s *Secrets) ExportContext(ctx context.Context, collection dbus.ObjectPath, w io.Writer) error {
+	if err := s.unlockCollection(ctx, collection); err != nil {
+		return err
+	}
+
+	cache := newPropertyCache()
+	var paths []dbus.ObjectPath
+	err := s.getCachedProperty(ctx, cache, collection, dbusCollectionInterface, "Items", &paths)
+	if err != nil {
+		return fmt.Errorf("could not get items of collection: %w", err)
+	}
+
+	items := make([]Item, 0, len(paths))
+	for _, path := range paths {
+		item, err := s.getItem(ctx, cache, path)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	if len(paths) > 0 {
+		if err := s.fillSecrets(ctx, items, paths); err != nil {
+			return err
+		}
+	}
+
+	export := ExportFile{
+		Version: exportVersion,
+		Items:   make([]ExportedItem, 0, len(items)),
+	}
+	for _, item := range items {
+		export.Items = append(export.Items, ExportedItem{
+			Label:       item.Label,
+			Attributes:  item.Attributes,
+			ContentType: item.ContentType,
+			Secret:      item.Secret,
+			Created:     item.Created.Unix(),
+			Modified:    item.Modified.Unix(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return fmt.Errorf("could not write export: %w", err)
+	}
+
+	return nil
+}
+
+// Import creates the items read from r, written by Export, in the collection.
+//
+// An item matches an existing item of the collection when their attributes are equal. With
+// replace, the label and secret of a matching item are replaced, otherwise the item is skipped.
+// Either way, importing the same data twice does not create duplicates.
+//
+// An item that cannot be imported does not stop the import, its error is added to
+// ImportSummary.Failed. An error is only returned when the data cannot be read or the collection
+// cannot be unlocked, see Export for the unlock prompt.
+func (s *Secrets) Import(collection dbus.ObjectPath, r io.Reader, replace bool) (ImportSummary, error) {
+	return s.ImportContext(context.Background(), collection, r, replace)
+}
+
+// ImportContext is like Import but aborts the calls and the unlock prompt when ctx is done, which
+// is returned as an error.
+func (s *Secrets) ImportContext(
+	ctx context.Context,
+	collection dbus.ObjectPath,
+	r io.Reader,
+	replace bool,
+) (ImportSummary, error) {
+	var export ExportFile
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return ImportSummary{}, fmt.Errorf("could not read export: %w", err)
+	}
+	if export.Version != exportVersion {
+		return ImportSummary{}, fmt.Errorf("unsupported export version %d", export.Version)
+	}
+
+	if err := s.unlockCollection(ctx, collection); err != nil {
+		return ImportSummary{}, err
+	}
+
+	var summary ImportSummary
+	for i, item := range export.Items {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		err := s.importItem(ctx, collection, item, replace, &summary)
+		if err != nil {
+			summary.Failed = append(summary.Failed, ImportError{Index: i, Label: item.Label, Err: err})
+		}
+	}
+
+	return summary, nil
+}
+
+// importItem imports a single item and counts it in the summary.
+func (s *Secrets) importItem(
+	ctx context.Context,
+	collection dbus.ObjectPath,
+	item ExportedItem,
+	replace bool,
+	summary *ImportSummary,
+) error {
+	attributes := item.Attributes
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+
+	exists, err := s.hasItem(ctx, collection, attributes)
+	if err != nil {
+		return err
+	}
+	if exists && !replace {
+		summary.Skipped++
+		return nil
+	}
+
+	value := Secret{Value: item.Secret, ContentType: item.ContentType}
+	if value.Value == nil {
+		value.Value = []byte{}
+	}
+	_, err = s.createItemIn(ctx, collection, item.Label, attributes, value, exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		summary.Replaced++
+	} else {
+		summary.Created++
+	}
+
+	return nil
+}
+
+// hasItem reports whether the collection contains an item with exactly the given attributes.
+func (s *Secrets) hasItem(
+	ctx context.Context,
+	collection dbus.ObjectPath,
+	attributes map[string]string,
+) (bool, error) {
+	var paths []dbus.ObjectPath
+	err := s.call(
+		ctx,
+		s.conn.Object(s.dest, collection),
+		dbusCollectionInterface+".SearchItems",
+		attributes,
+	).Store(&paths)
+	if err != nil {
+		return false, fmt.Errorf("could not search items: %w", err)
+	}
+
+	// SearchItems also returns items that have more attributes
+	var errs []error
+	for _, path := range paths {
+		existing, err := s.GetItemAttributes(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if maps.Equal(existing, attributes) {
+			return true, nil
+		}
+	}
+
+	return false, errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	s, service := newTestService(t)
+	binary := []byte{0x00, 0xff, 0x10, '\n', 0x80}
+	service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	service.AddItem(t, testLoginCollection, "key", map[string]string{"app": "ssh", "type": "ed25519"}, binary)
+	service.AddItem(t, testLoginCollection, "bare", map[string]string{}, []byte{})
+	target := service.AddCollection(t, "keepass", "KeePass")
+
+	var exported bytes.Buffer
+	if err := s.Export(testLoginCollection, &exported); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	summary, err := s.Import(target, bytes.NewReader(exported.Bytes()), true)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if summary.Created != 3 || summary.Replaced != 0 || len(summary.Failed) != 0 {
+		t.Fatalf("Import() = %+v, want 3 created", summary)
+	}
+
+	var roundTrip bytes.Buffer
+	if err := s.Export(target, &roundTrip); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	want := decodeExport(t, exported.Bytes())
+	got := decodeExport(t, roundTrip.Bytes())
+	if len(got.Items) != len(want.Items) {
+		t.Fatalf("exported %d items, want %d", len(got.Items), len(want.Items))
+	}
+	for i := range want.Items {
+		w, g := want.Items[i], got.Items[i]
+		if g.Label != w.Label ||
+			!maps.Equal(g.Attributes, w.Attributes) ||
+			!bytes.Equal(g.Secret, w.Secret) ||
+			g.ContentType != w.ContentType {
+			t.Fatalf("item %d = %+v, want %+v", i, g, w)
+		}
+	}
+	if !bytes.Equal(got.Items[1].Secret, binary) {
+		t.Fatalf("binary secret = %x, want %x", got.Items[1].Secret, binary)
+	}
+
+	summary, err = s.Import(target, bytes.NewReader(exported.Bytes()), true)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if summary.Created != 0 || summary.Replaced != 3 || len(summary.Failed) != 0 {
+		t.Fatalf("second Import() = %+v, want 3 replaced", summary)
+	}
+
+	summary, err = s.Import(target, bytes.NewReader(exported.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if summary.Created != 0 || summary.Skipped != 3 || len(summary.Failed) != 0 {
+		t.Fatalf("Import() without replace = %+v, want 3 skipped", summary)
+	}
+
+	var final bytes.Buffer
+	if err := s.Export(target, &final); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if n := len(decodeExport(t, final.Bytes()).Items); n != 3 {
+		t.Fatalf("collection has %d items after importing again, want 3", n)
+	}
+}
+
+func TestImportReplacesOnlyEqualAttributes(t *testing.T) {
+	s, service := newTestService(t)
+	existing := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent", "user": "a"}, []byte("old"))
+
+	data := `{"version": 1, "items": [{"label": "token", "attributes": {"app": "agent"}, "secret": "bmV3"}]}`
+	summary, err := s.Import(testLoginCollection, strings.NewReader(data), true)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if summary.Created != 1 || summary.Replaced != 0 {
+		t.Fatalf("Import() = %+v, want 1 created", summary)
+	}
+	if got, _ := service.Secret(existing); string(got) != "old" {
+		t.Fatalf("secret of item with more attributes = %q, want %q", got, "old")
+	}
+}
+
+func TestImportUnsupportedVersion(t *testing.T) {
+	s, service := newTestService(t)
+
+	_, err := s.Import(testLoginCollection, strings.NewReader(`{"version": 2}`), true)
+	if err == nil {
+		t.Fatalf("Import() error = nil, want unsupported version")
+	}
+	if n := service.Calls("org.freedesktop.Secret.Collection.CreateItem"); n != 0 {
+		t.Fatalf("CreateItem called %d times", n)
+	}
+}
+
+func decodeExport(t *testing.T, data []byte) ExportFile {
+	t.Helper()
+
+	var export ExportFile
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatalf("invalid export: %v", err)
+	}
+
+	return export
+}
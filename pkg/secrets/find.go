@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	pathpkg "path"
+	"slices"
+	"time"
+)
+
+// Item is an item of the secret service with its properties.
+type Item struct {
+	Path       dbus.ObjectPath
+	Label      string
+	Attributes map[string]string
+	Locked     bool
+	Created    time.Time
+	// Modified is the time the item was last changed. Some providers, such as keepassxc, only
+	// update it when the secret is written and not when, e.g., the label changes.
+	Modified time.Time
+
+	// Collection is the collection that contains the item.
+	Collection dbus.ObjectPath
+	// CollectionLabel is the label of Collection.
+	CollectionLabel string
+
+	// Secret is only set when FindOpts.WithSecrets is used and the item is unlocked.
+	Secret      []byte
+	ContentType string
+
+	// chunks are the items of a chunked secret in order, see WithChunkSize. Path is the first.
+	chunks []dbus.ObjectPath
+	// compressed is true for a compressed secret, see WithCompression.
+	compressed bool
+	// secrets is the Secrets the item was read by, nil if the Item was created by the caller.
+	secrets *Secrets
+}
+
+// FindOpts configures FindItems.
+type FindOpts struct {
+	// WithSecrets also retrieves the secret of every unlocked item.
+	WithSecrets bool
+
+	// Unlock unlocks the locked items that match, which can show a prompt to the user.
+	// When the prompt is dismissed, the items are returned with Locked set. For providers that
+	// hide the items of locked collections, the locked collections are unlocked when nothing is
+	// found.
+	Unlock bool
+
+	// Target limits the search to a collection, e.g. LabelTarget("Work"). Read-only collections
+	// are not skipped. The zero Target searches every collection.
+	Target Target
+}
+
+// FindItems returns the items of all collections that have the given attributes. A chunked
+// secret, see WithChunkSize, is returned as a single item and a compressed secret, see
+// WithCompression, is decompressed. Returns an error wrapping ErrCorruptSecret if the chunks are
+// incomplete or, when retrieving secrets, do not match or cannot be decompressed.
+//
+// When nothing is found while collections are locked and the provider hides the items of locked
+// collections, see ProviderInfo.HidesLockedItems, ErrPossiblyLocked is returned instead. With
+// FindOpts.Unlock, the locked collections are unlocked and the search is repeated.
+func (s *Secrets) FindItems(attributes map[string]string, opts FindOpts) ([]Item, error) {
+	return s.FindItemsContext(context.Background(), attributes, opts)
+}
+
+// FindItemsContext is like FindItems but aborts the calls and the unlock prompt when ctx is done.
+func (s *Secrets) FindItemsContext(
+	ctx context.Context,
+	attributes map[string]string,
+	opts FindOpts,
+) ([]Item, error) {
+	return s.findItems(ctx, newPropertyCache(), attributes, opts)
+}
+
+// findItems is FindItemsContext reading the properties through cache.
+func (s *Secrets) findItems(
+	ctx context.Context,
+	cache *propertyCache,
+	attributes map[string]string,
+	opts FindOpts,
+) ([]Item, error) {
+	collection, err := s.resolveTarget(ctx, opts.Target, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlocked, locked []dbus.ObjectPath
+	err = s.call(ctx, s.obj, dbusServiceInterface+".SearchItems", attributes).Store(&unlocked, &locked)
+	if err != nil {
+		return nil, fmt.Errorf("could not search items: %w", err)
+	}
+
+	var collections []dbus.ObjectPath
+	if collection != "" {
+		inOther := func(path dbus.ObjectPath) bool {
+			return collectionOf(path) != collection
+		}
+		unlocked = slices.DeleteFunc(unlocked, inOther)
+		locked = slices.DeleteFunc(locked, inOther)
+		collections = []dbus.ObjectPath{collection}
+	}
+
+	if len(unlocked) == 0 && len(locked) == 0 {
+		err := s.possiblyLocked(ctx, cache, collections)
+		var possiblyLocked *PossiblyLockedError
+		if opts.Unlock && errors.As(err, &possiblyLocked) {
+			_, err := s.unlock(ctx, possiblyLocked.Collections)
+			cache.forget(possiblyLocked.Collections...)
+			if err != nil {
+				return nil, err
+			}
+			opts.Unlock = false
+			opts.Target = Target{path: collection}
+
+			return s.findItems(ctx, cache, attributes, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Unlock && len(locked) > 0 {
+		nowUnlocked, err := s.unlock(ctx, locked)
+		cache.forget(locked...)
+		if err != nil {
+			return nil, err
+		}
+		locked = slices.DeleteFunc(locked, func(path dbus.ObjectPath) bool {
+			return slices.Contains(nowUnlocked, path)
+		})
+		unlocked = append(unlocked, nowUnlocked...)
+	}
+
+	items, err := s.getItems(ctx, cache, slices.Concat(unlocked, locked))
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.WithSecrets && len(unlocked) > 0 {
+		if err := s.fillSecrets(ctx, items, unlocked); err != nil {
+			return nil, err
+		}
+	}
+
+	items, err = joinChunks(items)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressItems(items)
+}
+
+// getItem reads all properties of the item through cache.
+func (s *Secrets) getItem(
+	ctx context.Context,
+	cache *propertyCache,
+	path dbus.ObjectPath,
+) (Item, error) {
+	properties, err := s.getAll(ctx, cache, path, dbusItemInterface)
+	if err != nil {
+		return Item{}, fmt.Errorf("could not get properties of item %s: %w", path, err)
+	}
+
+	item := Item{Path: path, Collection: collectionOf(path), secrets: s}
+	var created, modified uint64
+	err = errors.Join(
+		storeProperty(properties, "Label", &item.Label),
+		storeProperty(properties, "Attributes", &item.Attributes),
+		storeProperty(properties, "Locked", &item.Locked),
+		storeProperty(properties, "Created", &created),
+		storeProperty(properties, "Modified", &modified),
+	)
+	if err != nil {
+		return Item{}, fmt.Errorf("unexpected properties of item %s: %w", path, err)
+	}
+	item.Created = time.Unix(int64(created), 0)
+	item.Modified = time.Unix(int64(modified), 0)
+
+	return item, nil
+}
+
+// collectionOf returns the collection of the item at path. Items are children of their
+// collection, see the Secret Service specification.
+func collectionOf(item dbus.ObjectPath) dbus.ObjectPath {
+	return dbus.ObjectPath(pathpkg.Dir(string(item)))
+}
+
+// fillCollectionLabels sets CollectionLabel of the items, reading the label of every collection
+// once through cache.
+func (s *Secrets) fillCollectionLabels(ctx context.Context, cache *propertyCache, items []Item) error {
+	for i := range items {
+		err := s.getCachedProperty(
+			ctx,
+			cache,
+			items[i].Collection,
+			dbusCollectionInterface,
+			"Label",
+			&items[i].CollectionLabel,
+		)
+		if err != nil {
+			return fmt.Errorf("could not get label of collection: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func storeProperty(properties map[string]dbus.Variant, name string, value interface{}) error {
+	variant, ok := properties[name]
+	if !ok {
+		return fmt.Errorf("%s is missing", name)
+	}
+
+	if err := variant.Store(value); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
+
+// fillSecrets retrieves the secrets of the given paths and sets them on the items. The secrets are
+// retrieved in batches that fit in a message, see WithMaxMessageSize. A session that the service
+// ends halfway is replaced once, returns a *PartialSecretsError when that is not enough.
+func (s *Secrets) fillSecrets(ctx context.Context, items []Item, paths []dbus.ObjectPath) error {
+	session, err := s.openSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	reopen := func(ctx context.Context) (dbus.ObjectPath, error) {
+		// The service ended the session, only its record in the state file is left
+		s.handles.removePath(session)
+		session = ""
+		path, err := s.openSession(ctx)
+		if err != nil {
+			return "", err
+		}
+		session = path
+
+		return path, nil
+	}
+	secrets, err := s.getSecrets(ctx, session, paths, reopen)
+	if session != "" {
+		err = errors.Join(err, s.closeSession(context.WithoutCancel(ctx), session))
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		if secret, ok := secrets[items[i].Path]; ok {
+			items[i].Secret = secret.Value
+			items[i].ContentType = secret.ContentType
+		}
+	}
+
+	return nil
+}
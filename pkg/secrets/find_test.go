@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"testing"
+)
+
+const testLoginCollection = dbus.ObjectPath("/org/freedesktop/secrets/collection/login")
+
+func newTestService(t testing.TB) (*Secrets, *secretstest.Service) {
+	t.Helper()
+
+	service := secretstest.New(t)
+	s, err := New(WithConn(service.Connect(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s, service
+}
+
+func TestFindItems(t *testing.T) {
+	s, service := newTestService(t)
+	work := service.AddCollection(t, "work", "Work")
+	token := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	service.AddItem(t, testLoginCollection, "other", map[string]string{"app": "other"}, []byte("x"))
+	lockedToken := service.AddItem(t, work, "work token", map[string]string{"app": "agent"}, []byte("s3cret"))
+	service.SetLocked(work, true)
+
+	items, err := s.FindItems(map[string]string{"app": "agent"}, FindOpts{WithSecrets: true})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("FindItems() returned %d items, want 2", len(items))
+	}
+
+	if items[0].Path != token || items[0].Label != "token" || items[0].Locked {
+		t.Errorf("items[0] = %+v, want unlocked %s", items[0], token)
+	}
+	if string(items[0].Secret) != "hunter2" || items[0].ContentType != "text/plain" {
+		t.Errorf("items[0] secret = %q (%s), want %q", items[0].Secret, items[0].ContentType, "hunter2")
+	}
+	if items[1].Path != lockedToken || !items[1].Locked || items[1].Secret != nil {
+		t.Errorf("items[1] = %+v, want locked %s without secret", items[1], lockedToken)
+	}
+	if service.Calls("org.freedesktop.Secret.Service.Unlock") != 0 {
+		t.Error("FindItems() unlocked without FindOpts.Unlock")
+	}
+}
+
+func TestFindItemsUnlock(t *testing.T) {
+	s, service := newTestService(t)
+	token := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	service.SetLocked(testLoginCollection, true)
+
+	items, err := s.FindItems(map[string]string{"app": "agent"}, FindOpts{WithSecrets: true, Unlock: true})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 1 || items[0].Path != token || items[0].Locked {
+		t.Fatalf("FindItems() = %+v, want unlocked %s", items, token)
+	}
+	if string(items[0].Secret) != "hunter2" {
+		t.Errorf("secret = %q, want %q", items[0].Secret, "hunter2")
+	}
+}
+
+func TestFindItemsUnlockDismissed(t *testing.T) {
+	s, service := newTestService(t)
+	token := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	service.SetLocked(testLoginCollection, true)
+	service.SetPromptDismissed(true)
+
+	items, err := s.FindItems(map[string]string{"app": "agent"}, FindOpts{WithSecrets: true, Unlock: true})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 1 || items[0].Path != token || !items[0].Locked || items[0].Secret != nil {
+		t.Fatalf("FindItems() = %+v, want locked %s without secret", items, token)
+	}
+}
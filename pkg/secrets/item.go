@@ -0,0 +1,319 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"maps"
+	"slices"
+	"time"
+)
+
+// ItemTimestamps holds the creation and modification time of an item.
+type ItemTimestamps struct {
+	Created  time.Time
+	Modified time.Time
+}
+
+// GetItemLabel returns the label of the item.
+func (s *Secrets) GetItemLabel(item dbus.ObjectPath) (string, error) {
+	return s.GetItemLabelContext(context.Background(), item)
+}
+
+// GetItemLabelContext is like GetItemLabel but aborts the call when ctx is done.
+func (s *Secrets) GetItemLabelContext(ctx context.Context, item dbus.ObjectPath) (string, error) {
+	i := s.itemAt(item)
+	if err := i.ReloadContext(ctx); err != nil {
+		return "", err
+	}
+
+	return i.Label, nil
+}
+
+// SetItemLabel changes the label of the item, see Item.SetLabel.
+func (s *Secrets) SetItemLabel(item dbus.ObjectPath, label string) error {
+	return s.SetItemLabelContext(context.Background(), item, label)
+}
+
+// SetItemLabelContext is like SetItemLabel but aborts the call when ctx is done.
+func (s *Secrets) SetItemLabelContext(
+	ctx context.Context,
+	item dbus.ObjectPath,
+	label string,
+) error {
+	return s.itemAt(item).SetLabelContext(ctx, label)
+}
+
+// GetItemAttributes returns the lookup attributes of the item.
+func (s *Secrets) GetItemAttributes(item dbus.ObjectPath) (map[string]string, error) {
+	return s.GetItemAttributesContext(context.Background(), item)
+}
+
+// GetItemAttributesContext is like GetItemAttributes but aborts the call when ctx is done.
+func (s *Secrets) GetItemAttributesContext(
+	ctx context.Context,
+	item dbus.ObjectPath,
+) (map[string]string, error) {
+	i := s.itemAt(item)
+	if err := i.ReloadContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return i.Attributes, nil
+}
+
+// SetItemAttributes replaces the lookup attributes of the item. The item path does not change.
+func (s *Secrets) SetItemAttributes(item dbus.ObjectPath, attributes map[string]string) error {
+	return s.SetItemAttributesContext(context.Background(), item, attributes)
+}
+
+// SetItemAttributesContext is like SetItemAttributes but aborts the call when ctx is done.
+func (s *Secrets) SetItemAttributesContext(
+	ctx context.Context,
+	item dbus.ObjectPath,
+	attributes map[string]string,
+) error {
+	return s.itemAt(item).SetAttributesContext(ctx, attributes)
+}
+
+// SetSecret replaces the secret of the item, see Item.SetSecret. The path must be that of a
+// single item, writing one chunk of a chunked secret makes the secret corrupt.
+func (s *Secrets) SetSecret(item dbus.ObjectPath, value []byte, contentType string) error {
+	return s.SetSecretContext(context.Background(), item, value, contentType)
+}
+
+// SetSecretContext is like SetSecret but aborts the calls when ctx is done.
+func (s *Secrets) SetSecretContext(
+	ctx context.Context,
+	item dbus.ObjectPath,
+	value []byte,
+	contentType string,
+) error {
+	return s.itemAt(item).SetSecretContext(ctx, Secret{Value: value, ContentType: contentType})
+}
+
+// GetItemTimestamps returns the time the item was created and last modified.
+func (s *Secrets) GetItemTimestamps(item dbus.ObjectPath) (ItemTimestamps, error) {
+	return s.GetItemTimestampsContext(context.Background(), item)
+}
+
+// GetItemTimestampsContext is like GetItemTimestamps but aborts the call when ctx is done.
+func (s *Secrets) GetItemTimestampsContext(
+	ctx context.Context,
+	item dbus.ObjectPath,
+) (ItemTimestamps, error) {
+	i := s.itemAt(item)
+	if err := i.ReloadContext(ctx); err != nil {
+		return ItemTimestamps{}, err
+	}
+
+	return ItemTimestamps{Created: i.Created, Modified: i.Modified}, nil
+}
+
+// itemAt returns the Item at path without reading its properties. Paths are those of single
+// items, a chunked secret cannot be recognized by the path of one of its chunks.
+func (s *Secrets) itemAt(path dbus.ObjectPath) *Item {
+	return &Item{Path: path, Collection: collectionOf(path), secrets: s}
+}
+
+// SetLabel changes the label of the item and updates Label.
+//
+// Every chunk of a chunked secret, see WithChunkSize, is relabeled as the chunk format
+// requires: the first chunk gets label and the others label followed by their part number.
+// The first chunk is relabeled last, so that the secret keeps its previous label if relabeling
+// fails halfway. Chunks that were relabeled by then keep their new label.
+//
+// The setters of Item return ErrReadOnlyCollection when the collection of the item is
+// read-only, see Collection.ReadOnly.
+func (i *Item) SetLabel(label string) error {
+	return i.SetLabelContext(context.Background(), label)
+}
+
+// SetLabelContext is like SetLabel but aborts the calls when ctx is done.
+func (i *Item) SetLabelContext(ctx context.Context, label string) error {
+	s, err := i.owner()
+	if err != nil {
+		return fmt.Errorf("could not set Label of item %s: %w", i.Path, err)
+	}
+	if err := s.checkWritable(ctx, i.Collection); err != nil {
+		return err
+	}
+
+	for index, chunk := range slices.Backward(i.chunks) {
+		if index == 0 {
+			break
+		}
+		err := s.setItemProperty(ctx, chunk, "Label", chunkLabel(label, index, len(i.chunks)))
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.setItemProperty(ctx, i.Path, "Label", label); err != nil {
+		return err
+	}
+	i.Label = label
+
+	return nil
+}
+
+// SetAttributes replaces the lookup attributes of the item and updates Attributes. The
+// attributes of a chunked secret, see WithChunkSize, cannot be changed. A compressed secret, see
+// WithCompression, keeps the attribute that marks it as compressed.
+func (i *Item) SetAttributes(attributes map[string]string) error {
+	return i.SetAttributesContext(context.Background(), attributes)
+}
+
+// SetAttributesContext is like SetAttributes but aborts the call when ctx is done.
+func (i *Item) SetAttributesContext(ctx context.Context, attributes map[string]string) error {
+	s, err := i.owner()
+	if err != nil {
+		return fmt.Errorf("could not set Attributes of item %s: %w", i.Path, err)
+	}
+	if len(i.chunks) > 0 {
+		return fmt.Errorf("could not set Attributes of item %s: item is a chunked secret", i.Path)
+	}
+	if err := s.checkWritable(ctx, i.Collection); err != nil {
+		return err
+	}
+
+	stored := attributes
+	if i.compressed {
+		stored = maps.Clone(attributes)
+		stored[compressionAttr] = compressionGzip
+	}
+	if err := s.setItemProperty(ctx, i.Path, "Attributes", stored); err != nil {
+		return err
+	}
+	i.Attributes = maps.Clone(attributes)
+	if i.compressed {
+		delete(i.Attributes, compressionAttr)
+	}
+
+	return nil
+}
+
+// SetSecret replaces the secret of the item and updates Secret and ContentType. The secret is
+// transferred in a session that is opened for the call. The item must be unlocked, see
+// Secrets.WithUnlocked. The secret of a chunked secret, see WithChunkSize, cannot be changed.
+// The secret of a compressed secret, see WithCompression, is compressed again, whatever its size.
+func (i *Item) SetSecret(value Secret) error {
+	return i.SetSecretContext(context.Background(), value)
+}
+
+// SetSecretContext is like SetSecret but aborts the calls when ctx is done.
+func (i *Item) SetSecretContext(ctx context.Context, value Secret) error {
+	s, err := i.owner()
+	if err != nil {
+		return fmt.Errorf("could not set secret of item %s: %w", i.Path, err)
+	}
+	if len(i.chunks) > 0 {
+		return fmt.Errorf("could not set secret of item %s: item is a chunked secret", i.Path)
+	}
+	if err := s.checkWritable(ctx, i.Collection); err != nil {
+		return err
+	}
+
+	stored := value
+	if i.compressed {
+		if stored, err = gzipSecret(value); err != nil {
+			return err
+		}
+	}
+
+	session, err := s.openSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	itemSecret := secret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       stored.Value,
+		ContentType: stored.ContentType,
+	}
+	obj := s.conn.Object(s.dest, i.Path)
+	err = s.call(ctx, obj, dbusItemInterface+".SetSecret", itemSecret).Err
+	if err != nil {
+		err = s.writeError(i.Collection, err)
+		return errors.Join(
+			fmt.Errorf("could not set secret of item %s: %w", i.Path, err),
+			s.closeSession(context.WithoutCancel(ctx), session),
+		)
+	}
+	if err := s.closeSession(context.WithoutCancel(ctx), session); err != nil {
+		return err
+	}
+	i.Secret = slices.Clone(value.Value)
+	i.ContentType = value.ContentType
+
+	return nil
+}
+
+// Reload reads the properties of the item again, e.g. after it has been changed by another
+// application. Secret, ContentType, and CollectionLabel are left as they are. The chunks of a
+// chunked secret are read again and joined, and the compression attribute is removed, as
+// FindItems does.
+func (i *Item) Reload() error {
+	return i.ReloadContext(context.Background())
+}
+
+// ReloadContext is like Reload but aborts the calls when ctx is done.
+func (i *Item) ReloadContext(ctx context.Context) error {
+	s, err := i.owner()
+	if err != nil {
+		return fmt.Errorf("could not reload item %s: %w", i.Path, err)
+	}
+
+	var item Item
+	if len(i.chunks) == 0 {
+		item, err = s.getItem(ctx, nil, i.Path)
+	} else {
+		item, err = s.getChunkSet(ctx, i.chunks)
+	}
+	if err != nil {
+		return err
+	}
+	if err := decompressItem(&item); err != nil {
+		return err
+	}
+	i.compressed = item.compressed
+	i.Label = item.Label
+	i.Attributes = item.Attributes
+	i.Locked = item.Locked
+	i.Created = item.Created
+	i.Modified = item.Modified
+
+	return nil
+}
+
+// owner returns the Secrets the item was read by.
+func (i *Item) owner() (*Secrets, error) {
+	if i.secrets == nil {
+		return nil, errors.New("item was not returned by Secrets")
+	}
+
+	return i.secrets, nil
+}
+
+func (s *Secrets) setItemProperty(
+	ctx context.Context,
+	item dbus.ObjectPath,
+	name string,
+	value interface{},
+) error {
+	err := s.call(
+		ctx,
+		s.conn.Object(s.dest, item),
+		propertiesInterface+".Set",
+		dbusItemInterface,
+		name,
+		dbus.MakeVariant(value),
+	).Err
+	if err != nil {
+		err = s.writeError(collectionOf(item), err)
+		return fmt.Errorf("could not set %s of item %s: %w", name, item, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package secrets
+
+import (
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"maps"
+	"testing"
+	"time"
+)
+
+func newTestItem(t *testing.T) (*Secrets, *secretstest.Service, dbus.ObjectPath) {
+	t.Helper()
+
+	s, service := newTestService(t)
+	item := service.AddItem(t, testLoginCollection, "token", map[string]string{
+		"app": "agent",
+	}, []byte("hunter2"))
+	service.SetTimestamps(item, time.Unix(1700000000, 0), time.Unix(1700000100, 0))
+
+	return s, service, item
+}
+
+func TestItemLabel(t *testing.T) {
+	s, _, item := newTestItem(t)
+
+	if err := s.SetItemLabel(item, "rotated token"); err != nil {
+		t.Fatalf("SetItemLabel() error = %v", err)
+	}
+
+	label, err := s.GetItemLabel(item)
+	if err != nil {
+		t.Fatalf("GetItemLabel() error = %v", err)
+	}
+	if label != "rotated token" {
+		t.Errorf("GetItemLabel() = %q, want %q", label, "rotated token")
+	}
+}
+
+func TestItemAttributes(t *testing.T) {
+	s, service, item := newTestItem(t)
+
+	want := map[string]string{"app": "agent", "generation": "2"}
+	if err := s.SetItemAttributes(item, want); err != nil {
+		t.Fatalf("SetItemAttributes() error = %v", err)
+	}
+
+	got, err := s.GetItemAttributes(item)
+	if err != nil {
+		t.Fatalf("GetItemAttributes() error = %v", err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetItemAttributes() = %v, want %v", got, want)
+	}
+	if stored, _ := service.Attributes(item); !maps.Equal(stored, want) {
+		t.Errorf("service stored attributes %v, want %v", stored, want)
+	}
+}
+
+func TestItemTimestamps(t *testing.T) {
+	s, _, item := newTestItem(t)
+
+	got, err := s.GetItemTimestamps(item)
+	if err != nil {
+		t.Fatalf("GetItemTimestamps() error = %v", err)
+	}
+
+	want := ItemTimestamps{
+		Created:  time.Unix(1700000000, 0),
+		Modified: time.Unix(1700000100, 0),
+	}
+	if !got.Created.Equal(want.Created) || !got.Modified.Equal(want.Modified) {
+		t.Errorf("GetItemTimestamps() = %v, want %v", got, want)
+	}
+}
+
+func TestItemSecret(t *testing.T) {
+	s, service, item := newTestItem(t)
+
+	if err := s.SetSecret(item, []byte("rotated"), "text/plain"); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	if sessions := service.OpenSessions(); len(sessions) != 0 {
+		t.Fatalf("open sessions after SetSecret() = %v, want none", sessions)
+	}
+
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+	defer session.Close()
+	value, contentType, err := session.GetSecret(item)
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if string(value) != "rotated" || contentType != "text/plain" {
+		t.Fatalf("GetSecret() = %q, %q, want %q, text/plain", value, contentType, "rotated")
+	}
+
+	service.SetLocked(testLoginCollection, true)
+	if err := s.SetSecret(item, []byte("refused"), "text/plain"); !isDbusError(err, errIsLocked) {
+		t.Fatalf("SetSecret() of a locked item error = %v, want IsLocked", err)
+	}
+	if stored, _ := service.Secret(item); string(stored) != "rotated" {
+		t.Errorf("service stored %q after the refused SetSecret(), want %q", stored, "rotated")
+	}
+	if sessions := service.OpenSessions(); len(sessions) != 1 {
+		t.Errorf("open sessions after the refused SetSecret() = %v, want only the test session", sessions)
+	}
+}
+
+func TestItemSetters(t *testing.T) {
+	s, _, path := newTestItem(t)
+	items, err := s.FindItems(map[string]string{"app": "agent"}, FindOpts{})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("FindItems() = %+v, %v, want one item", items, err)
+	}
+	item := items[0]
+	if !item.Created.Equal(time.Unix(1700000000, 0)) || !item.Modified.Equal(time.Unix(1700000100, 0)) {
+		t.Fatalf("timestamps = %s, %s, want the ones of the service", item.Created, item.Modified)
+	}
+
+	if err := item.SetLabel("renamed"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	attributes := map[string]string{"app": "agent", "user": "alice"}
+	if err := item.SetAttributes(attributes); err != nil {
+		t.Fatalf("SetAttributes() error = %v", err)
+	}
+	if item.Label != "renamed" || !maps.Equal(item.Attributes, attributes) {
+		t.Fatalf("item = %+v, want the new label and attributes", item)
+	}
+
+	// Changed by another application
+	if err := s.SetItemLabel(path, "changed elsewhere"); err != nil {
+		t.Fatalf("SetItemLabel() error = %v", err)
+	}
+	if err := item.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if item.Label != "changed elsewhere" || !maps.Equal(item.Attributes, attributes) {
+		t.Fatalf("Reload() = %+v, want the properties of the service", item)
+	}
+
+	if err := (&Item{Path: path}).SetLabel("x"); err == nil {
+		t.Fatal("SetLabel() of an Item not returned by Secrets succeeded")
+	}
+}
+
+func TestItemSetLabelChunked(t *testing.T) {
+	service := secretstest.New(t)
+	s, err := New(WithConn(service.Connect(t)), WithChunkSize(4))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	attributes := map[string]string{"app": "a"}
+	item, _, _, err := s.GetOrCreate("Blob", attributes, func() (Secret, error) {
+		return Secret{Value: []byte("0123456789")}, nil
+	})
+	if err != nil {
+		t.Fatalf("GetOrCreate() error = %v", err)
+	}
+
+	if err := item.SetLabel("Renamed"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	want := []string{"Renamed", "Renamed (part 2 of 3)", "Renamed (part 3 of 3)"}
+	for index, chunk := range item.chunks {
+		label, err := s.GetItemLabel(chunk)
+		if err != nil {
+			t.Fatalf("GetItemLabel() error = %v", err)
+		}
+		if label != want[index] {
+			t.Errorf("label of chunk %d = %q, want %q", index+1, label, want[index])
+		}
+	}
+
+	items, err := s.FindItems(attributes, FindOpts{})
+	if err != nil || len(items) != 1 || items[0].Label != "Renamed" {
+		t.Fatalf("FindItems() = %+v, %v, want the renamed secret", items, err)
+	}
+
+	item.Label = ""
+	item.Attributes = nil
+	if err := item.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if item.Label != "Renamed" || !maps.Equal(item.Attributes, attributes) {
+		t.Fatalf("Reload() = %q %v, want the label and attributes of the secret", item.Label, item.Attributes)
+	}
+}
+
+func TestCollectionMetadata(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetTimestamps(testLoginCollection, time.Unix(1700000000, 0), time.Unix(1700000100, 0))
+	c, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+
+	created, err := c.Created()
+	if err != nil || !created.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("Created() = %s, %v, want %s", created, err, time.Unix(1700000000, 0))
+	}
+	modified, err := c.Modified()
+	if err != nil || !modified.Equal(time.Unix(1700000100, 0)) {
+		t.Fatalf("Modified() = %s, %v, want %s", modified, err, time.Unix(1700000100, 0))
+	}
+
+	if err := c.SetLabel("Personal"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	if label, err := c.Label(); err != nil || label != "Personal" {
+		t.Fatalf("Label() = %q, %v, want Personal", label, err)
+	}
+}
@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrPossiblyLocked is returned when a search finds nothing while collections are locked and the
+// provider hides the items of locked collections, see ProviderInfo.HidesLockedItems. The items
+// may be in the locked collections, or may not exist at all. The error is a *PossiblyLockedError.
+var ErrPossiblyLocked = errors.New("items possibly in locked collections")
+
+// PossiblyLockedError names the locked collections of ErrPossiblyLocked.
+type PossiblyLockedError struct {
+	// Collections are the locked collections that can hold the items.
+	Collections []dbus.ObjectPath
+}
+
+func (e *PossiblyLockedError) Error() string {
+	paths := make([]string, len(e.Collections))
+	for i, collection := range e.Collections {
+		paths[i] = string(collection)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrPossiblyLocked, strings.Join(paths, ", "))
+}
+
+func (e *PossiblyLockedError) Is(target error) bool {
+	return target == ErrPossiblyLocked
+}
+
+// lockedItemsHiddenBy are the executables of providers that hide the items of locked collections:
+// KWallet's bridge returns nothing from SearchItems and an empty Items property instead of
+// listing the items as locked.
+var lockedItemsHiddenBy = []string{"kwalletd5", "kwalletd6", "ksecretd"}
+
+// WithLockedItemsHidden records that the provider hides the items of locked collections, for
+// providers that are not recognized by ProviderInfo.HidesLockedItems, e.g. because they run in
+// another PID namespace.
+func WithLockedItemsHidden() Option {
+	return func(o *options) {
+		o.lockedItemsHidden = true
+	}
+}
+
+// hidesLockedItems reports whether the provider hides the items of locked collections. The result
+// is remembered until the provider is replaced by another owner of the name.
+func (s *Secrets) hidesLockedItems(ctx context.Context) (bool, error) {
+	if s.lockedItemsHidden {
+		return true, nil
+	}
+
+	busObj := s.conn.BusObject()
+	var owner string
+	err := s.call(ctx, busObj, "org.freedesktop.DBus.GetNameOwner", s.dest).Store(&owner)
+	if err != nil {
+		return false, fmt.Errorf("could not get owner of %s: %w", s.dest, err)
+	}
+
+	s.muQuirks.Lock()
+	quirksOwner, hides := s.quirksOwner, s.hidesLocked
+	s.muQuirks.Unlock()
+	if quirksOwner == owner {
+		return hides, nil
+	}
+
+	var pid uint32
+	err = s.call(ctx, busObj, "org.freedesktop.DBus.GetConnectionUnixProcessID", owner).Store(&pid)
+	if err != nil {
+		return false, fmt.Errorf("could not get process of %s: %w", owner, err)
+	}
+	hides = slices.Contains(lockedItemsHiddenBy, programOf(pid))
+
+	s.muQuirks.Lock()
+	defer s.muQuirks.Unlock()
+	s.quirksOwner, s.hidesLocked = owner, hides
+
+	return hides, nil
+}
+
+// programOf returns the name of the executable of the process, empty when it cannot be read, e.g.
+// when the process is in another PID namespace. Linux truncates the name to 15 bytes.
+func programOf(pid uint32) string {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(comm))
+}
+
+// possiblyLocked returns a *PossiblyLockedError for a search in the collections that found
+// nothing, when the provider hides the items of locked collections and some of the collections
+// are locked. All collections are checked when collections is nil. The locked state is read
+// through cache.
+func (s *Secrets) possiblyLocked(
+	ctx context.Context,
+	cache *propertyCache,
+	collections []dbus.ObjectPath,
+) error {
+	hides, err := s.hidesLockedItems(ctx)
+	if err != nil || !hides {
+		return err
+	}
+
+	if collections == nil {
+		err := s.call(ctx, s.obj, propertiesInterface+".Get", dbusServiceInterface, "Collections").
+			Store(&collections)
+		if err != nil {
+			return fmt.Errorf("could not get collections: %w", err)
+		}
+	}
+
+	var locked []dbus.ObjectPath
+	for _, collection := range collections {
+		var isLocked bool
+		err := s.getCachedProperty(ctx, cache, collection, dbusCollectionInterface, "Locked", &isLocked)
+		if err != nil {
+			return fmt.Errorf("could not get locked state of collection: %w", err)
+		}
+		if isLocked {
+			locked = append(locked, collection)
+		}
+	}
+	if len(locked) == 0 {
+		return nil
+	}
+
+	return &PossiblyLockedError{Collections: locked}
+}
@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPossiblyLocked(t *testing.T) {
+	service := secretstest.New(t)
+	s, err := New(WithConn(service.Connect(t)), WithLockedItemsHidden())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	detected, err := New(WithConn(service.Connect(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = detected.Close()
+	})
+
+	attributes := map[string]string{"app": "agent"}
+	service.AddItem(t, testLoginCollection, "token", attributes, []byte("secret"))
+	work := service.AddCollection(t, "work", "Work")
+	service.SetLockedItemsHidden(true)
+	service.SetLocked(testLoginCollection, true)
+	want := []dbus.ObjectPath{testLoginCollection}
+
+	checkPossiblyLocked := func(t *testing.T, name string, err error) {
+		t.Helper()
+
+		if !errors.Is(err, ErrPossiblyLocked) {
+			t.Fatalf("%s error = %v, want ErrPossiblyLocked", name, err)
+		}
+		var possiblyLocked *PossiblyLockedError
+		if !errors.As(err, &possiblyLocked) || !slices.Equal(possiblyLocked.Collections, want) {
+			t.Fatalf("%s error = %v, want the login collection", name, err)
+		}
+	}
+
+	_, err = s.FindItems(attributes, FindOpts{})
+	checkPossiblyLocked(t, "FindItems()", err)
+	_, _, err = s.SearchItems(attributes)
+	checkPossiblyLocked(t, "SearchItems()", err)
+	_, _, err = Collection{secrets: s, path: testLoginCollection}.SearchItems(attributes)
+	checkPossiblyLocked(t, "Collection.SearchItems()", err)
+
+	// Only the locked collection can hide the items
+	unlocked, locked, err := Collection{secrets: s, path: work}.SearchItems(attributes)
+	if err != nil || len(unlocked) != 0 || len(locked) != 0 {
+		t.Fatalf("Collection.SearchItems() of an unlocked collection = %v, %v, %v", unlocked, locked, err)
+	}
+
+	statuses, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	i := slices.IndexFunc(statuses, func(status CollectionStatus) bool {
+		return status.Path == testLoginCollection
+	})
+	if statuses[i].Items != -1 {
+		t.Errorf("Status() Items of the locked collection = %d, want -1", statuses[i].Items)
+	}
+
+	// Providers that are not known to hide items are believed
+	items, err := detected.FindItems(attributes, FindOpts{})
+	if err != nil || len(items) != 0 {
+		t.Fatalf("FindItems() of an undetected provider = %v, %v, want nothing", items, err)
+	}
+	info, err := detected.ProviderInfo()
+	if err != nil {
+		t.Fatalf("ProviderInfo() error = %v", err)
+	}
+	if info.HidesLockedItems {
+		t.Error("ProviderInfo() HidesLockedItems = true for the fake service")
+	}
+
+	// Unlocking makes the items visible
+	items, err = s.FindItems(attributes, FindOpts{WithSecrets: true, Unlock: true})
+	if err != nil {
+		t.Fatalf("FindItems() with Unlock error = %v", err)
+	}
+	if len(items) != 1 || string(items[0].Secret) != "secret" {
+		t.Fatalf("FindItems() with Unlock = %+v, want the item", items)
+	}
+
+	// A search that finds nothing while no collection is locked is believed
+	items, err = s.FindItems(map[string]string{"app": "other"}, FindOpts{})
+	if err != nil || len(items) != 0 {
+		t.Fatalf("FindItems() without locked collections = %v, %v, want nothing", items, err)
+	}
+}
+
+func TestProgramOf(t *testing.T) {
+	want := filepath.Base(os.Args[0])
+	if len(want) > 15 {
+		want = want[:15]
+	}
+	if got := programOf(uint32(os.Getpid())); got != want {
+		t.Errorf("programOf() = %q, want %q", got, want)
+	}
+	if got := programOf(0); got != "" {
+		t.Errorf("programOf(0) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,441 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// Orphaned sessions and prompts
+//
+// Sessions and prompts are objects on the service that the client closes or dismisses when it is
+// done with them. Some providers keep them after the client crashed, until the provider restarts.
+// The specification offers no way to list them, so with WithStateFile they are recorded in a
+// file that survives the client:
+//
+//   - A session is recorded before OpenSession is called and its path is added once it is known.
+//     A crash between the two leaves a record without path, the session cannot be closed.
+//   - A prompt is recorded before it is shown. Prompts that are never shown are not visible to
+//     the user and are not recorded.
+//   - The record is removed once the session is closed or the prompt completed.
+//   - The record holds the unique bus name of the connection, which tells whether the client is
+//     still running. Unique names are only unique on one bus, from the moment it starts until it
+//     stops, so the record also holds the ID of the bus and of the machine it runs on.
+//
+// Opening a session or showing a prompt fails when it cannot be recorded. The file is replaced
+// atomically on every change and changes are serialized between processes using a lock file next
+// to it, statePath + ".lock". The lock is only held to read and write the file, never during a
+// D-Bus call, which can take as long as the call timeout allows.
+
+// stateVersion is the version of the format of the state file.
+const stateVersion = 1
+
+type handleKind string
+
+const (
+	handleSession handleKind = "session"
+	handlePrompt  handleKind = "prompt"
+)
+
+// handleRecord is a session or prompt recorded in the state file.
+type handleRecord struct {
+	ID   string     `json:"id"`
+	Kind handleKind `json:"kind"`
+	// Path is empty while the session is being opened.
+	Path dbus.ObjectPath `json:"path,omitempty"`
+	// Client is the identity set by WithClientIdentity.
+	Client string `json:"client,omitempty"`
+	// Owner is the unique bus name of the connection the handle belongs to, on Bus.
+	Owner string `json:"owner"`
+	// Bus is the ID of the bus, see org.freedesktop.DBus.GetId, which changes when it restarts.
+	Bus string `json:"bus"`
+	// Machine is the machine ID of the bus, PID is only meaningful on that machine.
+	Machine string    `json:"machine"`
+	PID     int       `json:"pid"`
+	Created time.Time `json:"created"`
+}
+
+// busIdentity identifies a bus, see handleRecord.
+type busIdentity struct {
+	bus     string
+	machine string
+}
+
+type stateFile struct {
+	Version int            `json:"version"`
+	Handles []handleRecord `json:"handles"`
+}
+
+// WithStateFile records the sessions and prompts in the file at path so that they can be closed
+// when the client did not close them, e.g. because it crashed. New calls CleanupOrphans for the
+// file before anything is recorded. The directory of path must exist.
+func WithStateFile(path string) Option {
+	return func(o *options) {
+		o.statePath = path
+	}
+}
+
+// WithClientIdentity sets the identity that is written with every record of the state file, see
+// WithStateFile, to trace the handles to the client. CleanupOrphans only considers the records
+// of its own identity, so that clients can share a state file.
+func WithClientIdentity(identity string) Option {
+	return func(o *options) {
+		o.client = identity
+	}
+}
+
+// handleState records handles in a state file. The methods of a nil handleState do nothing.
+type handleState struct {
+	path     string
+	client   string
+	owner    string
+	identity busIdentity
+}
+
+func newHandleState(path string, client string, conn *dbus.Conn, identity busIdentity) *handleState {
+	h := &handleState{path: path, client: client, identity: identity}
+	if names := conn.Names(); len(names) > 0 {
+		h.owner = names[0]
+	}
+
+	return h
+}
+
+// begin records a handle of the given kind before it is created. Returns the ID of the record.
+func (h *handleState) begin(kind handleKind) (string, error) {
+	return h.add(kind, "")
+}
+
+// add records a handle, path is empty if it is not known yet. Returns the ID of the record.
+func (h *handleState) add(kind handleKind, path dbus.ObjectPath) (string, error) {
+	if h == nil {
+		return "", nil
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("could not generate record ID: %w", err)
+	}
+	record := handleRecord{
+		ID:      hex.EncodeToString(id[:]),
+		Kind:    kind,
+		Path:    path,
+		Client:  h.client,
+		Owner:   h.owner,
+		Bus:     h.identity.bus,
+		Machine: h.identity.machine,
+		PID:     os.Getpid(),
+		Created: time.Now(),
+	}
+
+	err := updateState(h.path, func(state *stateFile) error {
+		state.Handles = append(state.Handles, record)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not record %s: %w", kind, err)
+	}
+
+	return record.ID, nil
+}
+
+// created sets the path of the record returned by begin.
+func (h *handleState) created(id string, path dbus.ObjectPath) error {
+	if h == nil {
+		return nil
+	}
+
+	err := updateState(h.path, func(state *stateFile) error {
+		for i := range state.Handles {
+			if state.Handles[i].ID == id {
+				state.Handles[i].Path = path
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not record %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// remove removes the record with the given ID. An error is not returned, a record that is left
+// behind refers to a handle that is gone, which CleanupOrphans drops.
+func (h *handleState) remove(id string) {
+	if h == nil {
+		return
+	}
+
+	_ = updateState(h.path, func(state *stateFile) error {
+		state.Handles = slices.DeleteFunc(state.Handles, func(r handleRecord) bool {
+			return r.ID == id
+		})
+		return nil
+	})
+}
+
+// removePath removes the record of the handle with the given path.
+func (h *handleState) removePath(path dbus.ObjectPath) {
+	if h == nil {
+		return
+	}
+
+	_ = updateState(h.path, func(state *stateFile) error {
+		state.Handles = slices.DeleteFunc(state.Handles, func(r handleRecord) bool {
+			return r.Bus == h.identity.bus && r.Owner == h.owner && r.Path == path
+		})
+		return nil
+	})
+}
+
+// CleanupOrphans closes the sessions and dismisses the prompts recorded in the state file at
+// statePath whose connection no longer exists, i.e. the handles of a client that exited without
+// closing them. See WithStateFile. Only the records of the identity set by WithClientIdentity
+// are considered.
+//
+// Records without path are dropped, the session was either not opened or cannot be found.
+// Handles that no longer exist are not an error. The records of handles that could not be closed
+// are kept for the next cleanup and their errors are returned.
+//
+// Records made on another bus, e.g. before the bus restarted, cannot be checked or closed from
+// this bus. They are dropped once their process no longer runs, when made on this machine, and
+// kept otherwise.
+func (s *Secrets) CleanupOrphans(statePath string) error {
+	return s.CleanupOrphansContext(context.Background(), statePath)
+}
+
+// CleanupOrphansContext is like CleanupOrphans but aborts the calls when ctx is done.
+func (s *Secrets) CleanupOrphansContext(ctx context.Context, statePath string) error {
+	identity, err := s.busIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.cleanupOrphans(ctx, statePath, identity)
+}
+
+// cleanupOrphans implements CleanupOrphans for the bus with the given identity. The state file
+// is read, the handles are closed without holding its lock, and then the records of the closed
+// handles are removed, leaving the records added meanwhile.
+func (s *Secrets) cleanupOrphans(ctx context.Context, statePath string, identity busIdentity) error {
+	var handles []handleRecord
+	err := updateState(statePath, func(state *stateFile) error {
+		handles = slices.Clone(state.Handles)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	resolved := make(map[string]bool)
+	alive := make(map[string]bool)
+	for _, r := range handles {
+		if r.Client != s.client {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if r.Bus != identity.bus {
+			if r.Machine == identity.machine && !processRunning(r.PID) {
+				resolved[r.ID] = true
+			}
+			continue
+		}
+
+		hasOwner, ok := alive[r.Owner]
+		if !ok {
+			callErr := s.call(ctx, s.conn.BusObject(), "org.freedesktop.DBus.NameHasOwner", r.Owner).
+				Store(&hasOwner)
+			if callErr != nil {
+				err = errors.Join(err, fmt.Errorf("could not check connection %s: %w", r.Owner, callErr))
+				continue
+			}
+			alive[r.Owner] = hasOwner
+		}
+
+		switch {
+		case hasOwner:
+			// The client is still running
+			continue
+		case r.Path != "":
+			if closeErr := s.closeOrphan(ctx, r); closeErr != nil {
+				err = errors.Join(err, closeErr)
+				continue
+			}
+		}
+		resolved[r.ID] = true
+	}
+
+	if len(resolved) > 0 {
+		err = errors.Join(err, updateState(statePath, func(state *stateFile) error {
+			state.Handles = slices.DeleteFunc(state.Handles, func(r handleRecord) bool {
+				return resolved[r.ID]
+			})
+			return nil
+		}))
+	}
+
+	return errors.Join(err, ctx.Err())
+}
+
+// busIdentity returns the identity of the bus of the connection.
+func (s *Secrets) busIdentity(ctx context.Context) (busIdentity, error) {
+	var identity busIdentity
+	err := s.call(ctx, s.conn.BusObject(), "org.freedesktop.DBus.GetId").Store(&identity.bus)
+	if err != nil {
+		return busIdentity{}, fmt.Errorf("could not get bus ID: %w", err)
+	}
+	err = s.call(ctx, s.conn.BusObject(), "org.freedesktop.DBus.Peer.GetMachineId").
+		Store(&identity.machine)
+	if err != nil {
+		return busIdentity{}, fmt.Errorf("could not get machine ID of bus: %w", err)
+	}
+
+	return identity, nil
+}
+
+// processRunning reports whether a process with the given PID runs on this machine. A PID that
+// has been reused is reported as running.
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return true
+	}
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// closeOrphan closes the session or dismisses the prompt of the record.
+func (s *Secrets) closeOrphan(ctx context.Context, r handleRecord) error {
+	method := dbusSessionInterface + ".Close"
+	if r.Kind == handlePrompt {
+		method = dbusPromptInterface + ".Dismiss"
+	}
+
+	err := s.call(ctx, s.conn.Object(s.dest, r.Path), method).Err
+	for _, gone := range []string{
+		"org.freedesktop.DBus.Error.NoSuchObject",
+		"org.freedesktop.DBus.Error.UnknownObject",
+		"org.freedesktop.DBus.Error.UnknownInterface",
+		"org.freedesktop.DBus.Error.UnknownMethod",
+		"org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.Secret.Error.NoSuchObject",
+	} {
+		if isDbusError(err, gone) {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not close orphaned %s %s of %s: %w", r.Kind, r.Path, r.Owner, err)
+	}
+
+	return nil
+}
+
+// updateState changes the state file using f while holding the lock of the file. The file is not
+// written when f returns an error, unless f changed the records.
+func updateState(path string, f func(state *stateFile) error) error {
+	unlock, err := lockState(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readState(path)
+	if err != nil {
+		return err
+	}
+
+	before := slices.Clone(state.Handles)
+	err = f(&state)
+	if slices.Equal(before, state.Handles) {
+		return err
+	}
+
+	return errors.Join(err, writeState(path, state))
+}
+
+// lockState takes the lock that serializes changes to the state file between processes.
+func lockState(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open state lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("could not lock state file: %w", err)
+	}
+
+	// Closing the file releases the lock
+	return func() { _ = f.Close() }, nil
+}
+
+func readState(path string) (stateFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stateFile{Version: stateVersion}, nil
+	}
+	if err != nil {
+		return stateFile{}, fmt.Errorf("could not read state file: %w", err)
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return stateFile{}, fmt.Errorf("could not parse state file %s: %w", path, err)
+	}
+	if state.Version != stateVersion {
+		return stateFile{}, fmt.Errorf("state file %s has unsupported version %d", path, state.Version)
+	}
+
+	return state, nil
+}
+
+// writeState replaces the state file so that a crash leaves either the old or the new state.
+func writeState(path string, state stateFile) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return fmt.Errorf("could not encode state file: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+
+	// Persist the rename
+	d, err := os.Open(dir)
+	if err == nil {
+		err = errors.Join(d.Sync(), d.Close())
+	}
+	if err != nil {
+		return fmt.Errorf("could not sync state directory: %w", err)
+	}
+
+	return nil
+}
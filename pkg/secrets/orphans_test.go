@@ -0,0 +1,281 @@
+package secrets
+
+import (
+	"context"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestStateSecrets(t *testing.T, conn *dbus.Conn, statePath string, client string) *Secrets {
+	t.Helper()
+
+	s, err := New(WithConn(conn), WithStateFile(statePath), WithClientIdentity(client))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return s
+}
+
+func readTestState(t *testing.T, statePath string) []handleRecord {
+	t.Helper()
+
+	state, err := readState(statePath)
+	if err != nil {
+		t.Fatalf("readState() error = %v", err)
+	}
+
+	return state.Handles
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	service := secretstest.New(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	crashedConn := service.Connect(t)
+	crashed := newTestStateSecrets(t, crashedConn, statePath, "app")
+	orphan, err := crashed.openSession(context.Background())
+	if err != nil {
+		t.Fatalf("openSession() error = %v", err)
+	}
+	// Crashed before OpenSession returned
+	if _, err := crashed.handles.begin(handleSession); err != nil {
+		t.Fatalf("begin() error = %v", err)
+	}
+	// A prompt that the service removed already
+	if _, err := crashed.handles.add(handlePrompt, "/org/freedesktop/secrets/prompt/999"); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+
+	other := newTestStateSecrets(t, crashedConn, statePath, "other")
+	otherSession, err := other.openSession(context.Background())
+	if err != nil {
+		t.Fatalf("openSession() error = %v", err)
+	}
+
+	running := newTestStateSecrets(t, service.Connect(t), statePath, "app")
+	runningSession, err := running.openSession(context.Background())
+	if err != nil {
+		t.Fatalf("openSession() error = %v", err)
+	}
+
+	if got := len(readTestState(t, statePath)); got != 5 {
+		t.Fatalf("%d records, want 5", got)
+	}
+
+	owner := crashedConn.Names()[0]
+	_ = crashedConn.Close()
+	// Wait for the bus to notice the connection is gone
+	bus := service.Connect(t)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		var hasOwner bool
+		err := bus.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, owner).Store(&hasOwner)
+		if err != nil {
+			t.Fatalf("NameHasOwner() error = %v", err)
+		}
+		if !hasOwner {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// New cleans up the orphans of its identity
+	newTestStateSecrets(t, bus, statePath, "app")
+
+	want := []dbus.ObjectPath{otherSession, runningSession}
+	slices.Sort(want)
+	if got := service.OpenSessions(); !slices.Equal(got, want) {
+		t.Fatalf("open sessions = %v, want %v, %s should be closed", got, want, orphan)
+	}
+
+	var paths []dbus.ObjectPath
+	for _, r := range readTestState(t, statePath) {
+		paths = append(paths, r.Path)
+	}
+	slices.Sort(paths)
+	if !slices.Equal(paths, want) {
+		t.Fatalf("records of %v, want %v", paths, want)
+	}
+
+	// The records of a client that exits cleanly are removed
+	if err := running.closeSession(context.Background(), runningSession); err != nil {
+		t.Fatalf("closeSession() error = %v", err)
+	}
+	if got := len(readTestState(t, statePath)); got != 1 {
+		t.Fatalf("%d records after closeSession, want 1", got)
+	}
+}
+
+func TestStateFileRecordsPrompts(t *testing.T) {
+	service := secretstest.New(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	s := newTestStateSecrets(t, service.Connect(t), statePath, "app")
+	item := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "a"}, []byte("a"))
+	service.SetLocked(testLoginCollection, true)
+
+	err := s.WithUnlocked([]dbus.ObjectPath{item}, func() error {
+		_, err := s.FindItems(map[string]string{"app": "a"}, FindOpts{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithUnlocked() error = %v", err)
+	}
+	if records := readTestState(t, statePath); len(records) != 0 {
+		t.Fatalf("records %+v left after the prompt completed and the session closed", records)
+	}
+}
+
+func TestStateFileWriteAhead(t *testing.T) {
+	service := secretstest.New(t)
+	dir := t.TempDir()
+	s := newTestStateSecrets(t, service.Connect(t), filepath.Join(dir, "state.json"), "app")
+
+	// Without a state file, the session cannot be recorded and must not be opened
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.openSession(context.Background()); err == nil {
+		t.Fatal("openSession() succeeded without recording the session")
+	}
+	if n := service.Calls("org.freedesktop.Secret.Service.OpenSession"); n != 0 {
+		t.Fatalf("OpenSession called %d times, want 0", n)
+	}
+}
+
+// blockingSession implements the Close method of org.freedesktop.Secret.Session, which waits
+// until release is closed.
+type blockingSession struct {
+	closing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSession) Close() *dbus.Error {
+	close(b.closing)
+	<-b.release
+	return nil
+}
+
+func TestCleanupOrphansReleasesStateLock(t *testing.T) {
+	bus := dbustest.New(t)
+	serviceConn := bus.RequestName(t, "org.example.secrets")
+	session := &blockingSession{closing: make(chan struct{}), release: make(chan struct{})}
+	orphanPath := dbus.ObjectPath("/org/freedesktop/secrets/session/orphan")
+	if err := serviceConn.Export(session, orphanPath, dbusSessionInterface); err != nil {
+		t.Fatalf("failed to export fake session: %v", err)
+	}
+
+	s, err := New(WithConn(bus.Connect(t)), WithDest("org.example.secrets"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	identity, err := s.busIdentity(context.Background())
+	if err != nil {
+		t.Fatalf("busIdentity() error = %v", err)
+	}
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	err = writeState(statePath, stateFile{Version: stateVersion, Handles: []handleRecord{{
+		ID:      "orphan",
+		Kind:    handleSession,
+		Path:    orphanPath,
+		Owner:   ":1.999999",
+		Bus:     identity.bus,
+		Machine: identity.machine,
+		PID:     os.Getpid(),
+	}}})
+	if err != nil {
+		t.Fatalf("writeState() error = %v", err)
+	}
+
+	cleaned := make(chan error, 1)
+	go func() {
+		cleaned <- s.CleanupOrphans(statePath)
+	}()
+	<-session.closing
+
+	// Another client records a handle while the orphan is being closed
+	recorded := make(chan error, 1)
+	go func() {
+		h := newHandleState(statePath, "", serviceConn, identity)
+		_, err := h.add(handlePrompt, "/org/freedesktop/secrets/prompt/1")
+		recorded <- err
+	}()
+	select {
+	case err := <-recorded:
+		if err != nil {
+			t.Fatalf("add() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("state file is locked during the D-Bus call")
+	}
+
+	close(session.release)
+	if err := <-cleaned; err != nil {
+		t.Fatalf("CleanupOrphans() error = %v", err)
+	}
+	records := readTestState(t, statePath)
+	if len(records) != 1 || records[0].Kind != handlePrompt {
+		t.Fatalf("records = %+v, want only the prompt recorded during the cleanup", records)
+	}
+}
+
+func TestCleanupOrphansOtherBus(t *testing.T) {
+	service := secretstest.New(t)
+	s, err := New(WithConn(service.Connect(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	identity, err := s.busIdentity(context.Background())
+	if err != nil {
+		t.Fatalf("busIdentity() error = %v", err)
+	}
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatalf("failed to run true: %v", err)
+	}
+	exitedPID := exited.Process.Pid
+
+	record := func(id string, machine string, pid int) handleRecord {
+		return handleRecord{
+			ID:   id,
+			Kind: handleSession,
+			Path: "/org/freedesktop/secrets/session/" + dbus.ObjectPath(id),
+			// A unique name that is in use on this bus, which means nothing for another bus
+			Owner:   s.conn.Names()[0],
+			Bus:     "restarted",
+			Machine: machine,
+			PID:     pid,
+		}
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	err = writeState(statePath, stateFile{Version: stateVersion, Handles: []handleRecord{
+		record("running", identity.machine, os.Getpid()),
+		record("exited", identity.machine, exitedPID),
+		record("elsewhere", "other machine", exitedPID),
+	}})
+	if err != nil {
+		t.Fatalf("writeState() error = %v", err)
+	}
+
+	if err := s.CleanupOrphans(statePath); err != nil {
+		t.Fatalf("CleanupOrphans() error = %v", err)
+	}
+
+	var ids []string
+	for _, r := range readTestState(t, statePath) {
+		ids = append(ids, r.ID)
+	}
+	if want := []string{"running", "elsewhere"}; !slices.Equal(ids, want) {
+		t.Fatalf("records %v are left, want %v", ids, want)
+	}
+}
@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+)
+
+const dbusPromptInterface = "org.freedesktop.Secret.Prompt"
+
+// noPrompt is the path returned by methods that did not need a prompt.
+const noPrompt = dbus.ObjectPath("/")
+
+// PerformPrompt shows the prompt returned by a method of the secret service, such as the one of
+// Unlock or CreateCollection, and waits until the user completes or dismisses it. windowID is the
+// platform-specific ID of the window the prompt belongs to, empty if there is none. Returns the
+// result of the prompt, which depends on the method that returned it, and whether it was
+// dismissed.
+//
+// The prompt is not subject to the call timeout since it waits for the user. When Secrets is
+// closed, the prompt is dismissed and ErrClosed is returned.
+func (s *Secrets) PerformPrompt(
+	prompt dbus.ObjectPath,
+	windowID string,
+) (result dbus.Variant, dismissed bool, err error) {
+	return s.PerformPromptContext(context.Background(), prompt, windowID)
+}
+
+// PerformPromptContext is like PerformPrompt but, when ctx is done, dismisses the prompt, so that
+// it does not stay on screen, and returns ctx.Err().
+func (s *Secrets) PerformPromptContext(
+	ctx context.Context,
+	prompt dbus.ObjectPath,
+	windowID string,
+) (result dbus.Variant, dismissed bool, err error) {
+	matchOptions := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(dbusPromptInterface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := s.conn.AddMatchSignalContext(ctx, matchOptions...); err != nil {
+		return dbus.Variant{}, false, fmt.Errorf("could not subscribe to prompt: %w", err)
+	}
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer func() {
+		s.conn.RemoveSignal(signals)
+		_ = s.conn.RemoveMatchSignal(matchOptions...)
+	}()
+
+	// The prompt is recorded before it is shown, see WithStateFile
+	id, err := s.handles.add(handlePrompt, prompt)
+	if err != nil {
+		return dbus.Variant{}, false, fmt.Errorf("could not show prompt: %w", err)
+	}
+	defer s.handles.remove(id)
+
+	err = s.call(ctx, s.conn.Object(s.dest, prompt), dbusPromptInterface+".Prompt", windowID).Err
+	if err != nil {
+		return dbus.Variant{}, false, fmt.Errorf("could not show prompt: %w", err)
+	}
+
+	for {
+		select {
+		case signal, ok := <-signals:
+			if !ok {
+				return dbus.Variant{}, false, errors.New("connection closed while waiting for prompt")
+			}
+			if signal.Path != prompt || signal.Name != dbusPromptInterface+".Completed" {
+				continue
+			}
+
+			if err := dbus.Store(signal.Body, &dismissed, &result); err != nil {
+				return dbus.Variant{}, false, fmt.Errorf("malformed Completed signal: %w", err)
+			}
+
+			return result, dismissed, nil
+		case <-ctx.Done():
+			// The prompt might already be gone, the context error is what matters. The done ctx
+			// would fail the call before it is sent.
+			obj := s.conn.Object(s.dest, prompt)
+			_ = s.call(context.WithoutCancel(ctx), obj, dbusPromptInterface+".Dismiss").Err
+			return dbus.Variant{}, false, ctx.Err()
+		case <-s.scope.Done():
+			// Calls fail after Close, the prompt is dismissed without waiting for a reply since
+			// the connection might be closing
+			s.conn.Object(s.dest, prompt).Go(dbusPromptInterface+".Dismiss", dbus.FlagNoReplyExpected, nil)
+			return dbus.Variant{}, false, ErrClosed
+		}
+	}
+}
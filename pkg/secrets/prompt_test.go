@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"testing"
+	"time"
+)
+
+// unlockPrompt locks the login collection and returns the prompt of unlocking it.
+func unlockPrompt(t *testing.T) (*Secrets, *secretstest.Service, dbus.ObjectPath) {
+	t.Helper()
+
+	s, service := newTestService(t)
+	service.SetLocked(testLoginCollection, true)
+
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	collections := []dbus.ObjectPath{testLoginCollection}
+	err := s.call(context.Background(), s.obj, dbusServiceInterface+".Unlock", collections).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if prompt == noPrompt {
+		t.Fatal("Unlock() returned no prompt")
+	}
+
+	return s, service, prompt
+}
+
+func TestPerformPrompt(t *testing.T) {
+	s, service, prompt := unlockPrompt(t)
+
+	result, dismissed, err := s.PerformPrompt(prompt, "x11:1234")
+	if err != nil {
+		t.Fatalf("PerformPrompt() error = %v", err)
+	}
+	var unlocked []dbus.ObjectPath
+	if err := result.Store(&unlocked); err != nil {
+		t.Fatalf("unexpected result %s: %v", result, err)
+	}
+	if dismissed || !slices.Equal(unlocked, []dbus.ObjectPath{testLoginCollection}) {
+		t.Fatalf("PerformPrompt() = %v, %t, want %s unlocked", unlocked, dismissed, testLoginCollection)
+	}
+	if service.Locked(testLoginCollection) {
+		t.Fatalf("%s is still locked", testLoginCollection)
+	}
+}
+
+func TestPerformPromptDismissed(t *testing.T) {
+	s, service, prompt := unlockPrompt(t)
+	service.SetPromptDismissed(true)
+
+	_, dismissed, err := s.PerformPrompt(prompt, "")
+	if err != nil {
+		t.Fatalf("PerformPrompt() error = %v", err)
+	}
+	if !dismissed {
+		t.Fatal("PerformPrompt() not dismissed")
+	}
+}
+
+func TestPerformPromptContextDone(t *testing.T) {
+	s, service, prompt := unlockPrompt(t)
+	release := service.HoldPrompts()
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := s.PerformPromptContext(ctx, prompt, "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PerformPrompt() error = %v, want context.DeadlineExceeded", err)
+	}
+	if n := service.Calls("org.freedesktop.Secret.Prompt.Dismiss"); n != 1 {
+		t.Fatalf("Dismiss called %d times, want 1", n)
+	}
+	if !service.Locked(testLoginCollection) {
+		t.Fatalf("%s was unlocked by a cancelled prompt", testLoginCollection)
+	}
+}
@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"strings"
+)
+
+// propertyCache holds the properties read during one operation, e.g. FindItems, so that the
+// properties of an object are read at most once. A cache is created by the exported method that
+// starts the operation and passed explicitly to the functions it calls. It is dropped when the
+// method returns, so that properties changed by others are seen by the next operation.
+//
+// Changes made by the operation itself are not in the cache: the operation must forget the
+// objects it changes, e.g. after unlocking them. A nil cache reads the properties every time.
+type propertyCache struct {
+	objects map[propertyKey]*cachedProperties
+}
+
+type propertyKey struct {
+	path  dbus.ObjectPath
+	iface string
+}
+
+type cachedProperties struct {
+	properties map[string]dbus.Variant
+	// complete is true when properties holds all properties of the interface.
+	complete bool
+	// allErr is the error of GetAll, the properties are then read one by one.
+	allErr error
+}
+
+func newPropertyCache() *propertyCache {
+	return &propertyCache{objects: make(map[propertyKey]*cachedProperties)}
+}
+
+// forget removes the objects, and the objects below them such as the items of a collection, from
+// the cache.
+func (c *propertyCache) forget(paths ...dbus.ObjectPath) {
+	if c == nil {
+		return
+	}
+
+	for key := range c.objects {
+		for _, path := range paths {
+			if key.path == path || strings.HasPrefix(string(key.path), string(path)+"/") {
+				delete(c.objects, key)
+				break
+			}
+		}
+	}
+}
+
+// entry returns the cached properties of the interface of the object, a new entry that is not
+// kept if cache is nil.
+func (c *propertyCache) entry(path dbus.ObjectPath, iface string) *cachedProperties {
+	if c == nil {
+		return &cachedProperties{properties: make(map[string]dbus.Variant)}
+	}
+
+	key := propertyKey{path: path, iface: iface}
+	entry, ok := c.objects[key]
+	if !ok {
+		entry = &cachedProperties{properties: make(map[string]dbus.Variant)}
+		c.objects[key] = entry
+	}
+
+	return entry
+}
+
+// getAll returns all properties of the interface of the object, read using one GetAll call the
+// first time. An IsLocked error of GetAll is cached as well.
+func (s *Secrets) getAll(
+	ctx context.Context,
+	cache *propertyCache,
+	path dbus.ObjectPath,
+	iface string,
+) (map[string]dbus.Variant, error) {
+	entry := cache.entry(path, iface)
+	if err := s.loadAll(ctx, entry, path, iface); err != nil {
+		return nil, err
+	}
+
+	return entry.properties, nil
+}
+
+// loadAll reads all properties of the interface of the object into entry, unless they have been
+// read before.
+func (s *Secrets) loadAll(
+	ctx context.Context,
+	entry *cachedProperties,
+	path dbus.ObjectPath,
+	iface string,
+) error {
+	if entry.complete || entry.allErr != nil {
+		return entry.allErr
+	}
+
+	var properties map[string]dbus.Variant
+	err := s.call(ctx, s.conn.Object(s.dest, path), propertiesInterface+".GetAll", iface).
+		Store(&properties)
+	if isDbusError(err, errIsLocked) {
+		// Only the properties that need the object to be unlocked are refused, e.g. Items
+		entry.allErr = err
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	entry.properties, entry.complete = properties, true
+
+	return nil
+}
+
+// getCachedProperty stores the property of the interface of the object in value. All properties
+// of the interface are read at once the first time, so that the other properties are in the
+// cache as well. When the service refuses that because the object is locked, or there is no
+// cache, the property is read on its own.
+func (s *Secrets) getCachedProperty(
+	ctx context.Context,
+	cache *propertyCache,
+	path dbus.ObjectPath,
+	iface string,
+	name string,
+	value interface{},
+) error {
+	entry := cache.entry(path, iface)
+	if cache != nil {
+		err := s.loadAll(ctx, entry, path, iface)
+		if err != nil && !isDbusError(err, errIsLocked) {
+			return fmt.Errorf("could not get properties of %s: %w", path, err)
+		}
+	}
+
+	variant, ok := entry.properties[name]
+	if !ok && entry.complete {
+		return fmt.Errorf("%s of %s is missing", name, path)
+	}
+	if !ok {
+		err := s.call(ctx, s.conn.Object(s.dest, path), propertiesInterface+".Get", iface, name).
+			Store(&variant)
+		if err != nil {
+			return fmt.Errorf("could not get %s of %s: %w", name, path, err)
+		}
+		entry.properties[name] = variant
+	}
+
+	if err := variant.Store(value); err != nil {
+		return fmt.Errorf("unexpected type of %s of %s: %w", name, path, err)
+	}
+
+	return nil
+}
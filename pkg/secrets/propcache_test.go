@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"github.com/godbus/dbus/v5"
+	"strconv"
+	"testing"
+)
+
+func TestPropertyCache(t *testing.T) {
+	s, service := newTestService(t)
+	ctx := context.Background()
+	const getAll = "org.freedesktop.DBus.Properties.GetAll"
+	const get = "org.freedesktop.DBus.Properties.Get"
+	work := service.AddCollection(t, "work", "Work")
+
+	getLabel := func(cache *propertyCache, collection Collection) string {
+		t.Helper()
+
+		var label string
+		err := s.getCachedProperty(ctx, cache, collection.path, dbusCollectionInterface, "Label", &label)
+		if err != nil {
+			t.Fatalf("getCachedProperty() error = %v", err)
+		}
+
+		return label
+	}
+	login := Collection{secrets: s, path: testLoginCollection}
+
+	// Every property of an object is read using one GetAll per operation
+	cache := newPropertyCache()
+	getLabel(cache, login)
+	var locked bool
+	err := s.getCachedProperty(ctx, cache, testLoginCollection, dbusCollectionInterface, "Locked", &locked)
+	if err != nil {
+		t.Fatalf("getCachedProperty() error = %v", err)
+	}
+	if _, err := s.getAll(ctx, cache, testLoginCollection, dbusCollectionInterface); err != nil {
+		t.Fatalf("getAll() error = %v", err)
+	}
+	if n := service.Calls(getAll); n != 1 {
+		t.Fatalf("GetAll called %d times, want once", n)
+	}
+
+	// Forgotten objects and other operations read the properties again
+	if err := login.SetLabel("Renamed"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	if label := getLabel(cache, login); label != "Login" {
+		t.Fatalf("label = %q, want the cached label", label)
+	}
+	cache.forget(testLoginCollection)
+	if label := getLabel(cache, login); label != "Renamed" {
+		t.Fatalf("label after forget = %q, want Renamed", label)
+	}
+	if label := getLabel(newPropertyCache(), login); label != "Renamed" {
+		t.Fatalf("label in another operation = %q, want Renamed", label)
+	}
+	if n := service.Calls(getAll); n != 3 {
+		t.Fatalf("GetAll called %d times, want 3", n)
+	}
+
+	// Without a cache, only the property is read
+	getLabel(nil, login)
+	getLabel(nil, login)
+	if n := service.Calls(get); n != 2 {
+		t.Fatalf("Get called %d times without a cache, want 2", n)
+	}
+
+	// A collection that refuses GetAll while locked is read one property at a time, once
+	service.SetItemsRequireUnlock(true)
+	service.SetLocked(work, true)
+	cache = newPropertyCache()
+	getLabel(cache, Collection{secrets: s, path: work})
+	getLabel(cache, Collection{secrets: s, path: work})
+	if n := service.Calls(getAll); n != 4 {
+		t.Fatalf("GetAll called %d times for a locked collection, want once", n-3)
+	}
+	if n := service.Calls(get); n != 3 {
+		t.Fatalf("Get called %d times for a locked collection, want once", n-2)
+	}
+}
+
+func TestPropertyCacheOperations(t *testing.T) {
+	s, service := newTestService(t)
+	const getAll = "org.freedesktop.DBus.Properties.GetAll"
+	const get = "org.freedesktop.DBus.Properties.Get"
+	work := service.AddCollection(t, "work", "Work")
+	attributes := map[string]string{"app": "agent"}
+	for _, collection := range []dbus.ObjectPath{testLoginCollection, work} {
+		for i := range 3 {
+			itemAttributes := map[string]string{"app": "agent", "index": strconv.Itoa(i)}
+			service.AddItem(t, collection, "token", itemAttributes, []byte("secret"))
+		}
+	}
+
+	items, err := s.FindItems(attributes, FindOpts{})
+	if err != nil || len(items) != 6 {
+		t.Fatalf("FindItems() = %v, %v", items, err)
+	}
+	// One GetAll per item and per collection, which holds the label
+	if n := service.Calls(getAll); n != 8 {
+		t.Fatalf("FindItems() called GetAll %d times, want 8", n)
+	}
+	if n := service.Calls(get); n != 0 {
+		t.Fatalf("FindItems() called Get %d times, want 0", n)
+	}
+
+	// The items and the label of a collection come from the same GetAll
+	if _, err := s.FindByStableID(items[0].StableID()); err != nil {
+		t.Fatalf("FindByStableID() error = %v", err)
+	}
+	if n := service.Calls(getAll); n != 16 {
+		t.Fatalf("FindByStableID() called GetAll %d times, want 8", n-8)
+	}
+	if n := service.Calls(get); n != 1 {
+		t.Fatalf("FindByStableID() called Get %d times, want once for the collections", n)
+	}
+}
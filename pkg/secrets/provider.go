@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"math/big"
+)
+
+// dhAlgorithm is the encrypted session algorithm of the specification.
+const dhAlgorithm = "dh-ietf1024-sha256-aes128-cbc-pkcs7"
+
+// dhPrime is the 1024-bit MODP group of RFC 2409, used by dhAlgorithm with generator 2.
+var dhPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF",
+	16,
+)
+
+// ProviderInfo describes the program that provides the secret service.
+type ProviderInfo struct {
+	// Owner is the unique bus name of the provider.
+	Owner string
+	// PID is the process ID of the provider.
+	PID uint32
+	// Program is the name of the executable of the provider, e.g. kwalletd6, empty when it
+	// cannot be read. Linux truncates it to 15 bytes.
+	Program string
+	// Interfaces are the interfaces of the service object, e.g. org.freedesktop.Secret.Service.
+	Interfaces []string
+	// EncryptedSessions is true when the provider accepts sessions using
+	// dh-ietf1024-sha256-aes128-cbc-pkcs7. Secrets only uses plain sessions.
+	EncryptedSessions bool
+	// HidesLockedItems is true when the provider returns no items of locked collections from
+	// searches and the Items property, instead of listing them as locked. KWallet does, or
+	// WithLockedItemsHidden is used. Searches that find nothing then return ErrPossiblyLocked
+	// while collections are locked, and Status reports the locked collections with Items -1.
+	HidesLockedItems bool
+}
+
+// Ping checks that the secret service is reachable. A D-Bus activated service that does not start
+// blocks the call until the bus gives up, use PingContext with a short timeout to find out quickly
+// whether a secret service is running.
+func (s *Secrets) Ping() error {
+	return s.PingContext(context.Background())
+}
+
+// PingContext is like Ping but aborts the call when ctx is done.
+func (s *Secrets) PingContext(ctx context.Context) error {
+	err := s.call(ctx, s.obj, "org.freedesktop.DBus.Peer.Ping").Err
+	if err != nil {
+		return fmt.Errorf("secret service %s is not reachable: %w", s.dest, err)
+	}
+
+	return nil
+}
+
+// ProviderInfo returns information about the program that provides the secret service.
+// To find out whether encrypted sessions are supported, one is opened and closed again.
+func (s *Secrets) ProviderInfo() (ProviderInfo, error) {
+	return s.ProviderInfoContext(context.Background())
+}
+
+// ProviderInfoContext is like ProviderInfo but aborts the calls when ctx is done.
+func (s *Secrets) ProviderInfoContext(ctx context.Context) (ProviderInfo, error) {
+	var info ProviderInfo
+	busObj := s.conn.BusObject()
+
+	err := s.call(ctx, busObj, "org.freedesktop.DBus.GetNameOwner", s.dest).Store(&info.Owner)
+	if err != nil {
+		return ProviderInfo{}, fmt.Errorf("could not get owner of %s: %w", s.dest, err)
+	}
+
+	err = s.call(ctx, busObj, "org.freedesktop.DBus.GetConnectionUnixProcessID", info.Owner).
+		Store(&info.PID)
+	if err != nil {
+		return ProviderInfo{}, fmt.Errorf("could not get process of %s: %w", info.Owner, err)
+	}
+	info.Program = programOf(info.PID)
+	info.HidesLockedItems, err = s.hidesLockedItems(ctx)
+	if err != nil {
+		return ProviderInfo{}, err
+	}
+
+	var data string
+	err = s.call(ctx, s.obj, "org.freedesktop.DBus.Introspectable.Introspect").Store(&data)
+	if err != nil {
+		return ProviderInfo{}, fmt.Errorf("could not introspect secret service: %w", err)
+	}
+	var node introspect.Node
+	if err := xml.Unmarshal([]byte(data), &node); err != nil {
+		return ProviderInfo{}, fmt.Errorf("malformed introspection data: %w", err)
+	}
+	for _, iface := range node.Interfaces {
+		info.Interfaces = append(info.Interfaces, iface.Name)
+	}
+
+	info.EncryptedSessions, err = s.supportsEncryption(ctx)
+	if err != nil {
+		return ProviderInfo{}, err
+	}
+
+	return info, nil
+}
+
+// supportsEncryption opens and closes a session using dhAlgorithm. An error reply to OpenSession
+// means that the algorithm is not supported.
+func (s *Secrets) supportsEncryption(ctx context.Context) (bool, error) {
+	private, err := rand.Int(rand.Reader, dhPrime)
+	if err != nil {
+		return false, fmt.Errorf("could not generate key: %w", err)
+	}
+	public := new(big.Int).Exp(big.NewInt(2), private, dhPrime)
+
+	var output dbus.Variant
+	session, err := s.openSessionWith(ctx, dhAlgorithm, dbus.MakeVariant(public.Bytes()), &output)
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	switch {
+	case errors.As(err, &dbusErr), errors.As(err, &dbusErrPtr):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, s.closeSession(context.WithoutCancel(ctx), session)
+}
@@ -0,0 +1,50 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestProviderInfo(t *testing.T) {
+	s, _ := newTestService(t)
+
+	info, err := s.ProviderInfo()
+	if err != nil {
+		t.Fatalf("ProviderInfo() error = %v", err)
+	}
+	if info.Owner == "" || info.Owner[0] != ':' {
+		t.Errorf("Owner = %q, want a unique name", info.Owner)
+	}
+	if info.PID != uint32(os.Getpid()) {
+		t.Errorf("PID = %d, want %d", info.PID, os.Getpid())
+	}
+	if !slices.Contains(info.Interfaces, dbusServiceInterface) {
+		t.Errorf("Interfaces = %v, want %s", info.Interfaces, dbusServiceInterface)
+	}
+	// The fake service only supports plain sessions
+	if info.EncryptedSessions {
+		t.Error("EncryptedSessions = true, want false")
+	}
+}
+
+func TestPing(t *testing.T) {
+	s, service := newTestService(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := s.PingContext(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	missing, err := New(WithConn(service.Connect(t)), WithDest("org.example.Missing"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := missing.PingContext(ctx); err == nil {
+		t.Fatal("Ping() of a missing service succeeded")
+	}
+}
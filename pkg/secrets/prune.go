@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"slices"
+)
+
+// PruneOpts configures PruneOld.
+type PruneOpts struct {
+	// DryRun returns the items that would be deleted without deleting or unlocking them.
+	DryRun bool
+
+	// Target limits the items to a collection, see FindOpts.Target.
+	Target Target
+}
+
+// PruneOld deletes the items that have the given attributes, except for the keep most recently
+// modified ones, e.g. to remove old generations of a rotated token. Items modified in the same
+// second are ordered as GetOrCreate orders them, the item with the longer path, or the greater
+// one when the paths are equally long, is considered the newer. A chunked secret, see
+// WithChunkSize, counts as one item and is deleted as a whole.
+//
+// Locked items that are to be deleted are unlocked first and prompts to confirm the deletion are
+// shown, returns ErrDismissed if a prompt is dismissed. Returns the paths of the deleted items,
+// also when deleting fails halfway. Items deleted by someone else in the meantime are skipped.
+// Returns ErrNoAttributes when attributes is empty and an error when keep is less than 1.
+func (s *Secrets) PruneOld(
+	attributes map[string]string,
+	keep int,
+	opts PruneOpts,
+) ([]dbus.ObjectPath, error) {
+	return s.PruneOldContext(context.Background(), attributes, keep, opts)
+}
+
+// PruneOldContext is like PruneOld but aborts the calls and the prompts when ctx is done.
+func (s *Secrets) PruneOldContext(
+	ctx context.Context,
+	attributes map[string]string,
+	keep int,
+	opts PruneOpts,
+) ([]dbus.ObjectPath, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("could not prune items: keep must be at least 1, is %d", keep)
+	}
+	if len(attributes) == 0 {
+		return nil, ErrNoAttributes
+	}
+
+	items, err := s.FindItemsContext(ctx, attributes, FindOpts{Target: opts.Target})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) <= keep {
+		return nil, nil
+	}
+
+	slices.SortFunc(items, func(a, b Item) int {
+		return cmp.Or(
+			b.Modified.Compare(a.Modified),
+			cmp.Compare(len(b.Path), len(a.Path)),
+			cmp.Compare(b.Path, a.Path),
+		)
+	})
+	old := items[keep:]
+
+	if opts.DryRun {
+		paths := make([]dbus.ObjectPath, 0, len(old))
+		for _, item := range old {
+			paths = append(paths, item.Path)
+		}
+
+		return paths, nil
+	}
+
+	var locked []dbus.ObjectPath
+	for _, item := range old {
+		if item.Locked {
+			locked = append(locked, itemObjects(item)...)
+		}
+	}
+	if len(locked) > 0 {
+		unlocked, err := s.unlock(ctx, locked)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range locked {
+			if !slices.Contains(unlocked, path) {
+				return nil, ErrDismissed
+			}
+		}
+	}
+
+	var removed []dbus.ObjectPath
+	for _, item := range old {
+		err := item.DeleteContext(ctx)
+		if errors.Is(err, ErrNoSuchObject) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, item.Path)
+	}
+
+	return removed, nil
+}
+
+// itemObjects returns the paths of the items the service stores for item, its chunks in order
+// when it is a chunked secret.
+func itemObjects(item Item) []dbus.ObjectPath {
+	if len(item.chunks) > 0 {
+		return item.chunks
+	}
+
+	return []dbus.ObjectPath{item.Path}
+}
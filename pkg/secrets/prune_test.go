@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPruneOld(t *testing.T) {
+	s, service := newTestService(t)
+	attributes := map[string]string{"app": "agent"}
+
+	// The second and the fourth item are modified in the same second, the fourth has the greater
+	// path and is considered the newer: the order is fourth, second, third, first
+	var paths []dbus.ObjectPath
+	for i, modified := range []int64{100, 300, 200, 300} {
+		path := service.AddItem(t, testLoginCollection, "token", attributes, []byte(strconv.Itoa(i)))
+		service.SetTimestamps(path, time.Unix(1700000000, 0), time.Unix(1700000000+modified, 0))
+		paths = append(paths, path)
+	}
+	other := service.AddItem(t, testLoginCollection, "other", map[string]string{"app": "other"}, []byte("x"))
+	want := []dbus.ObjectPath{paths[2], paths[0]}
+
+	if _, err := s.PruneOld(attributes, 0, PruneOpts{}); err == nil {
+		t.Fatal("PruneOld() with keep 0 succeeded")
+	}
+	if n := service.Calls("org.freedesktop.Secret.Service.SearchItems"); n != 0 {
+		t.Fatalf("PruneOld() with keep 0 searched %d times, want 0", n)
+	}
+	if _, err := s.PruneOld(nil, 1, PruneOpts{}); !errors.Is(err, ErrNoAttributes) {
+		t.Fatalf("PruneOld() without attributes error = %v, want ErrNoAttributes", err)
+	}
+
+	service.SetLocked(testLoginCollection, true)
+	got, err := s.PruneOld(attributes, 2, PruneOpts{DryRun: true})
+	if err != nil {
+		t.Fatalf("PruneOld() dry run error = %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("PruneOld() dry run = %v, want %v", got, want)
+	}
+	if n := service.Calls("org.freedesktop.Secret.Item.Delete"); n != 0 {
+		t.Fatalf("PruneOld() dry run deleted %d items, want none", n)
+	}
+	if !service.Locked(testLoginCollection) {
+		t.Fatal("PruneOld() dry run unlocked the collection")
+	}
+
+	service.SetDeleteItemPrompts(true)
+	got, err = s.PruneOld(attributes, 2, PruneOpts{})
+	if err != nil {
+		t.Fatalf("PruneOld() error = %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("PruneOld() = %v, want %v", got, want)
+	}
+	if n := service.Calls("org.freedesktop.Secret.Prompt.Prompt"); n != 3 {
+		t.Errorf("PruneOld() showed %d prompts, want one to unlock and one per deleted item", n)
+	}
+	for _, path := range []dbus.ObjectPath{paths[1], paths[3], other} {
+		if _, ok := service.Secret(path); !ok {
+			t.Errorf("PruneOld() deleted %s", path)
+		}
+	}
+	for _, path := range want {
+		if _, ok := service.Secret(path); ok {
+			t.Errorf("PruneOld() kept %s", path)
+		}
+	}
+
+	got, err = s.PruneOld(attributes, 2, PruneOpts{})
+	if err != nil || len(got) != 0 {
+		t.Fatalf("PruneOld() of pruned items = %v, %v, want nothing removed", got, err)
+	}
+
+	oldest := service.AddItem(t, testLoginCollection, "token", attributes, []byte("oldest"))
+	service.SetTimestamps(oldest, time.Unix(1700000000, 0), time.Unix(1700000000, 0))
+	service.SetPromptDismissed(true)
+	got, err = s.PruneOld(attributes, 2, PruneOpts{})
+	if !errors.Is(err, ErrDismissed) || len(got) != 0 {
+		t.Fatalf("PruneOld() with a dismissed prompt = %v, %v, want ErrDismissed", got, err)
+	}
+	if _, ok := service.Secret(oldest); !ok {
+		t.Error("PruneOld() with a dismissed prompt deleted the item")
+	}
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"slices"
+)
+
+// ErrReadOnlyCollection is returned when writing to a collection that cannot be written to, see
+// Collection.ReadOnly.
+var ErrReadOnlyCollection = errors.New("collection is read-only")
+
+// ReadOnly reports whether the items of the collection cannot be created or changed, e.g.
+// because the collection is on a smartcard or locked by policy. The collection is read-only when
+// its introspection data does not offer CreateItem or a writable Label, or when the service
+// refused a write to it with AccessDenied or PropertyReadOnly.
+//
+// The result is remembered for the lifetime of Secrets. Writes to a read-only collection, such
+// as GetOrCreate and the setters of Item, fail with ErrReadOnlyCollection before any prompt is
+// shown or secret is generated.
+func (c Collection) ReadOnly() (bool, error) {
+	return c.ReadOnlyContext(context.Background())
+}
+
+// ReadOnlyContext is like ReadOnly but aborts the call when ctx is done.
+func (c Collection) ReadOnlyContext(ctx context.Context) (bool, error) {
+	return c.secrets.readOnly(ctx, c.path)
+}
+
+// readOnly reports whether the collection is read-only, see Collection.ReadOnly. The collection
+// is introspected the first time.
+func (s *Secrets) readOnly(ctx context.Context, collection dbus.ObjectPath) (bool, error) {
+	s.muReadOnly.Lock()
+	readOnly, ok := s.readOnlyCollections[collection]
+	s.muReadOnly.Unlock()
+	if ok {
+		return readOnly, nil
+	}
+
+	var data string
+	obj := s.conn.Object(s.dest, collection)
+	err := s.call(ctx, obj, "org.freedesktop.DBus.Introspectable.Introspect").Store(&data)
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	switch {
+	case errors.As(err, &dbusErr), errors.As(err, &dbusErrPtr):
+		// The collection cannot be introspected, only a refused write tells
+		readOnly = false
+	case err != nil:
+		return false, fmt.Errorf("could not introspect collection %s: %w", collection, err)
+	default:
+		var node introspect.Node
+		if err := xml.Unmarshal([]byte(data), &node); err != nil {
+			return false, fmt.Errorf("malformed introspection data of %s: %w", collection, err)
+		}
+		readOnly = introspectedReadOnly(node)
+	}
+
+	s.muReadOnly.Lock()
+	defer s.muReadOnly.Unlock()
+	// A refused write recorded in the meantime wins
+	if recorded, ok := s.readOnlyCollections[collection]; ok {
+		return recorded, nil
+	}
+	s.readOnlyCollections[collection] = readOnly
+
+	return readOnly, nil
+}
+
+// introspectedReadOnly reports whether the introspection data describes a read-only collection.
+// Data without the collection interface, e.g. of services that only list the child nodes, does
+// not tell.
+func introspectedReadOnly(node introspect.Node) bool {
+	i := slices.IndexFunc(node.Interfaces, func(iface introspect.Interface) bool {
+		return iface.Name == dbusCollectionInterface
+	})
+	if i < 0 {
+		return false
+	}
+	iface := node.Interfaces[i]
+
+	createItem := slices.ContainsFunc(iface.Methods, func(m introspect.Method) bool {
+		return m.Name == "CreateItem"
+	})
+	labelReadOnly := slices.ContainsFunc(iface.Properties, func(p introspect.Property) bool {
+		return p.Name == "Label" && p.Access == "read"
+	})
+
+	return !createItem || labelReadOnly
+}
+
+// checkWritable returns an error wrapping ErrReadOnlyCollection when the collection is
+// read-only.
+func (s *Secrets) checkWritable(ctx context.Context, collection dbus.ObjectPath) error {
+	readOnly, err := s.readOnly(ctx, collection)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnlyCollection, collection)
+	}
+
+	return nil
+}
+
+// writeError records the collection as read-only when err is the service refusing a write to it
+// and adds ErrReadOnlyCollection to err. Other errors are returned as they are.
+func (s *Secrets) writeError(collection dbus.ObjectPath, err error) error {
+	if !isDbusError(err, "org.freedesktop.DBus.Error.AccessDenied") &&
+		!isDbusError(err, "org.freedesktop.DBus.Error.PropertyReadOnly") {
+		return err
+	}
+
+	s.muReadOnly.Lock()
+	s.readOnlyCollections[collection] = true
+	s.muReadOnly.Unlock()
+
+	return fmt.Errorf("%w: %w", ErrReadOnlyCollection, err)
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCollectionReadOnly(t *testing.T) {
+	s, service := newTestService(t)
+	smartcard := service.AddCollection(t, "smartcard", "Smartcard")
+	attributes := map[string]string{"app": "agent"}
+	service.AddItem(t, smartcard, "certificate", attributes, []byte("pin"))
+	service.SetReadOnly(smartcard, true)
+	service.SetLocked(smartcard, true)
+
+	login, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	if readOnly, err := login.ReadOnly(); err != nil || readOnly {
+		t.Fatalf("ReadOnly() of the login collection = %t, %v, want false", readOnly, err)
+	}
+
+	service.SetAlias("default", smartcard)
+	c, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	if readOnly, err := c.ReadOnly(); err != nil || !readOnly {
+		t.Fatalf("ReadOnly() of the smartcard collection = %t, %v, want true", readOnly, err)
+	}
+
+	items, err := s.FindItems(attributes, FindOpts{})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("FindItems() = %+v, %v, want the certificate", items, err)
+	}
+	item := items[0]
+
+	secret := Secret{Value: []byte("x")}
+	writes := map[string]func() error{
+		"GetOrCreate": func() error {
+			_, _, _, err := s.GetOrCreate("token", map[string]string{"app": "new"}, func() (Secret, error) {
+				t.Error("GetOrCreate() generated a secret for a read-only collection")
+				return secret, nil
+			})
+			return err
+		},
+		"CreateItem": func() error {
+			_, err := c.CreateItem(ItemProperties{Label: "token"}, secret, false)
+			return err
+		},
+		"Collection.SetLabel": func() error { return c.SetLabel("Renamed") },
+		"Item.SetLabel":       func() error { return item.SetLabel("Renamed") },
+		"Item.SetAttributes":  func() error { return item.SetAttributes(attributes) },
+		"Item.SetSecret":      func() error { return item.SetSecret(secret) },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnlyCollection) {
+			t.Errorf("%s() error = %v, want ErrReadOnlyCollection", name, err)
+		}
+	}
+
+	for _, method := range []string{
+		"org.freedesktop.Secret.Service.Unlock",
+		"org.freedesktop.Secret.Service.OpenSession",
+		"org.freedesktop.Secret.Collection.CreateItem",
+		"org.freedesktop.Secret.Item.SetSecret",
+		"org.freedesktop.DBus.Properties.Set",
+	} {
+		if n := service.Calls(method); n != 0 {
+			t.Errorf("%s called %d times for writes to a read-only collection, want 0", method, n)
+		}
+	}
+	if n := service.Calls("org.freedesktop.DBus.Introspectable.Introspect"); n != 2 {
+		t.Errorf("collections introspected %d times, want once each", n)
+	}
+}
+
+func TestCollectionReadOnlyRefusedWrite(t *testing.T) {
+	s, service, path := newTestItem(t)
+	service.SetReadOnly(testLoginCollection, true)
+	service.SetReadOnlyHidden(true)
+
+	c, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	if readOnly, err := c.ReadOnly(); err != nil || readOnly {
+		t.Fatalf("ReadOnly() before a write = %t, %v, want false", readOnly, err)
+	}
+
+	if err := s.SetItemLabel(path, "Renamed"); !errors.Is(err, ErrReadOnlyCollection) {
+		t.Fatalf("SetItemLabel() error = %v, want ErrReadOnlyCollection", err)
+	}
+	if readOnly, err := c.ReadOnly(); err != nil || !readOnly {
+		t.Fatalf("ReadOnly() after a refused write = %t, %v, want true", readOnly, err)
+	}
+
+	if err := s.SetSecret(path, []byte("x"), "text/plain"); !errors.Is(err, ErrReadOnlyCollection) {
+		t.Fatalf("SetSecret() error = %v, want ErrReadOnlyCollection", err)
+	}
+	if n := service.Calls("org.freedesktop.Secret.Service.OpenSession"); n != 0 {
+		t.Errorf("SetSecret() opened %d sessions for a read-only collection, want 0", n)
+	}
+}
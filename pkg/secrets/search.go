@@ -0,0 +1,124 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+)
+
+// ErrNoAttributes is returned by SearchItems when no attributes are given, which would match
+// every item.
+var ErrNoAttributes = errors.New("no attributes to search for")
+
+// SearchItems returns the items of all collections that have the given attributes, split into
+// the unlocked items, whose secrets can be read, and the locked items, which must be unlocked
+// first, e.g. using WithUnlocked. Unlike FindItems, the items are returned as the service stores
+// them: the chunks of a chunked secret, see WithChunkSize, are separate items. Returns
+// ErrNoAttributes when attributes is empty, and ErrPossiblyLocked when nothing is found while
+// collections are locked and the provider hides the items of locked collections, see
+// ProviderInfo.HidesLockedItems.
+func (s *Secrets) SearchItems(attributes map[string]string) (unlocked []Item, locked []Item, err error) {
+	return s.SearchItemsContext(context.Background(), attributes)
+}
+
+// SearchItemsContext is like SearchItems but aborts the calls when ctx is done.
+func (s *Secrets) SearchItemsContext(
+	ctx context.Context,
+	attributes map[string]string,
+) (unlocked []Item, locked []Item, err error) {
+	if len(attributes) == 0 {
+		return nil, nil, ErrNoAttributes
+	}
+
+	var unlockedPaths, lockedPaths []dbus.ObjectPath
+	err = s.call(ctx, s.obj, dbusServiceInterface+".SearchItems", attributes).
+		Store(&unlockedPaths, &lockedPaths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not search items: %w", err)
+	}
+	cache := newPropertyCache()
+	if len(unlockedPaths) == 0 && len(lockedPaths) == 0 {
+		if err := s.possiblyLocked(ctx, cache, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	unlocked, err = s.getItems(ctx, cache, unlockedPaths)
+	if err != nil {
+		return nil, nil, err
+	}
+	locked, err = s.getItems(ctx, cache, lockedPaths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unlocked, locked, nil
+}
+
+// SearchItems is Secrets.SearchItems for the items of the collection. The service does not tell
+// locked and unlocked items apart here, they are split using the Locked property of every item.
+// Returns ErrPossiblyLocked when nothing is found in the locked collection of a provider that
+// hides the items of locked collections.
+func (c Collection) SearchItems(attributes map[string]string) (unlocked []Item, locked []Item, err error) {
+	return c.SearchItemsContext(context.Background(), attributes)
+}
+
+// SearchItemsContext is like SearchItems but aborts the calls when ctx is done.
+func (c Collection) SearchItemsContext(
+	ctx context.Context,
+	attributes map[string]string,
+) (unlocked []Item, locked []Item, err error) {
+	if len(attributes) == 0 {
+		return nil, nil, ErrNoAttributes
+	}
+
+	var paths []dbus.ObjectPath
+	obj := c.secrets.conn.Object(c.secrets.dest, c.path)
+	err = c.secrets.call(ctx, obj, dbusCollectionInterface+".SearchItems", attributes).Store(&paths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not search items of collection %s: %w", c.path, err)
+	}
+	cache := newPropertyCache()
+	if len(paths) == 0 {
+		if err := c.secrets.possiblyLocked(ctx, cache, []dbus.ObjectPath{c.path}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	items, err := c.secrets.getItems(ctx, cache, paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, item := range items {
+		if item.Locked {
+			locked = append(locked, item)
+		} else {
+			unlocked = append(unlocked, item)
+		}
+	}
+
+	return unlocked, locked, nil
+}
+
+// getItems reads the properties of the items through cache, including the label of their
+// collection.
+func (s *Secrets) getItems(
+	ctx context.Context,
+	cache *propertyCache,
+	paths []dbus.ObjectPath,
+) ([]Item, error) {
+	items := make([]Item, 0, len(paths))
+	for _, path := range paths {
+		item, err := s.getItem(ctx, cache, path)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := s.fillCollectionLabels(ctx, cache, items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
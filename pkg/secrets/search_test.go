@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"testing"
+)
+
+func TestSearchItems(t *testing.T) {
+	s, service := newTestService(t)
+	login := service.AddItem(t, testLoginCollection, "login", map[string]string{"app": "a"}, []byte("a"))
+	work := service.AddCollection(t, "work", "Work")
+	locked := service.AddItem(t, work, "work", map[string]string{"app": "a"}, []byte("b"))
+	service.AddItem(t, work, "other", map[string]string{"app": "b"}, []byte("c"))
+	service.SetLocked(work, true)
+
+	paths := func(items []Item) []dbus.ObjectPath {
+		var result []dbus.ObjectPath
+		for _, item := range items {
+			result = append(result, item.Path)
+		}
+		return result
+	}
+
+	gotUnlocked, gotLocked, err := s.SearchItems(map[string]string{"app": "a"})
+	if err != nil {
+		t.Fatalf("SearchItems() error = %v", err)
+	}
+	if !slices.Equal(paths(gotUnlocked), []dbus.ObjectPath{login}) {
+		t.Fatalf("SearchItems() unlocked = %v, want %s", paths(gotUnlocked), login)
+	}
+	if !slices.Equal(paths(gotLocked), []dbus.ObjectPath{locked}) {
+		t.Fatalf("SearchItems() locked = %v, want %s", paths(gotLocked), locked)
+	}
+	if !gotLocked[0].Locked || gotLocked[0].CollectionLabel != "Work" {
+		t.Fatalf("SearchItems() locked = %+v, want a locked item of Work", gotLocked[0])
+	}
+
+	collection, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	gotUnlocked, gotLocked, err = collection.SearchItems(map[string]string{"app": "a"})
+	if err != nil {
+		t.Fatalf("Collection.SearchItems() error = %v", err)
+	}
+	if !slices.Equal(paths(gotUnlocked), []dbus.ObjectPath{login}) || len(gotLocked) != 0 {
+		t.Fatalf("Collection.SearchItems() = %v, %v, want %s", paths(gotUnlocked), paths(gotLocked), login)
+	}
+
+	workCollection := Collection{secrets: s, path: work}
+	gotUnlocked, gotLocked, err = workCollection.SearchItems(map[string]string{"app": "a"})
+	if err != nil {
+		t.Fatalf("Collection.SearchItems() error = %v", err)
+	}
+	if len(gotUnlocked) != 0 || !slices.Equal(paths(gotLocked), []dbus.ObjectPath{locked}) {
+		t.Fatalf("Collection.SearchItems() = %v, %v, want locked %s", paths(gotUnlocked), paths(gotLocked), locked)
+	}
+}
+
+func TestSearchItemsNoAttributes(t *testing.T) {
+	s, service := newTestService(t)
+	service.AddItem(t, testLoginCollection, "login", map[string]string{"app": "a"}, []byte("a"))
+
+	if _, _, err := s.SearchItems(nil); !errors.Is(err, ErrNoAttributes) {
+		t.Fatalf("SearchItems() error = %v, want ErrNoAttributes", err)
+	}
+	collection := Collection{secrets: s, path: testLoginCollection}
+	if _, _, err := collection.SearchItems(map[string]string{}); !errors.Is(err, ErrNoAttributes) {
+		t.Fatalf("Collection.SearchItems() error = %v, want ErrNoAttributes", err)
+	}
+	if n := service.Calls("org.freedesktop.Secret.Service.SearchItems"); n != 0 {
+		t.Fatalf("SearchItems called %d times, want none", n)
+	}
+}
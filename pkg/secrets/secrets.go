@@ -1,45 +1,307 @@
 package secrets
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/godbus/dbus/v5"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 )
 
 const (
-	dbusDest             = "org.freedesktop.secrets"
-	dbusServiceInterface = "org.freedesktop.Secret.Service"
-	dbusPath             = "/org/freedesktop/secrets"
+	dbusDest                = "org.freedesktop.secrets"
+	dbusServiceInterface    = "org.freedesktop.Secret.Service"
+	dbusPath                = "/org/freedesktop/secrets"
+	dbusItemInterface       = "org.freedesktop.Secret.Item"
+	dbusCollectionInterface = "org.freedesktop.Secret.Collection"
+	propertiesInterface     = "org.freedesktop.DBus.Properties"
 )
 
+// ErrClosed is returned when using Secrets after Close has been called.
+var ErrClosed = errors.New("secrets is closed")
+
 type Secrets struct {
-	conn *dbus.Conn
-	obj  dbus.BusObject
+	conn        *dbus.Conn
+	ownsConn    bool
+	dest        string
+	callTimeout time.Duration
+	chunkSize   int
+	obj         dbus.BusObject
+	// client is the identity set by WithClientIdentity.
+	client string
+	// handles records the sessions and prompts, nil without WithStateFile.
+	handles *handleState
+	// limit is the maximum message size, see WithMaxMessageSize.
+	limit messageLimit
+	// compressionThreshold is set by WithCompression.
+	compressionThreshold int
+	// shared is the connection of Shared, nil for Secrets created by New.
+	shared *sharedConn
+	// scope is cancelled by Close. It ends the calls and prompts of this Secrets without
+	// affecting other Secrets using the same connection.
+	scope      context.Context
+	closeScope context.CancelFunc
+	closeOnce  sync.Once
+
+	muSessions sync.Mutex
+	// sessions are the sessions of OpenSession that have not been closed.
+	sessions map[*Session]struct{}
+
+	muReadOnly sync.Mutex
+	// readOnlyCollections records for the collections checked so far whether they are read-only,
+	// see Collection.ReadOnly.
+	readOnlyCollections map[dbus.ObjectPath]bool
+
+	// lockedItemsHidden is set by WithLockedItemsHidden.
+	lockedItemsHidden bool
+
+	muQuirks sync.Mutex
+	// quirksOwner is the provider that hidesLocked was detected for.
+	quirksOwner string
+	// hidesLocked reports whether the provider hides the items of locked collections.
+	hidesLocked bool
+
+	muTargets sync.Mutex
+	// targets are the resolved aliases and labels, see ResolveTarget.
+	targets map[targetKey]dbus.ObjectPath
+	// targetsOwner is the provider that targets were resolved with.
+	targetsOwner string
+	// targetsGeneration is incremented every time targets is cleared.
+	targetsGeneration uint64
+	// watchingTargets is true once targets is cleared on collection changes.
+	watchingTargets bool
+
+	muSignals sync.Mutex
+	// collectionSignals are the channels of SubscribeCollectionChanges.
+	collectionSignals map[chan<- CollectionChange]struct{}
+	// stopCollectionSignals stops receiving the collection signals, nil while not receiving them.
+	stopCollectionSignals func() error
+	// itemSignals are the channels of Collection.SubscribeItemChanges by collection.
+	itemSignals map[dbus.ObjectPath]map[chan<- ItemChange]struct{}
+	// stopItemSignals stop receiving the item signals of a collection.
+	stopItemSignals map[dbus.ObjectPath]func() error
 }
 
-func New() (*Secrets, error) {
-	conn, err := dbus.ConnectSessionBus()
-	if err != nil {
-		return nil, err
+type options struct {
+	conn        *dbus.Conn
+	address     string
+	dest        string
+	callTimeout time.Duration
+	chunkSize   int
+	statePath   string
+	client      string
+	// maxMessageSize is set by WithMaxMessageSize.
+	maxMessageSize int
+	// lockedItemsHidden is set by WithLockedItemsHidden.
+	lockedItemsHidden bool
+	// compressionThreshold is set by WithCompression.
+	compressionThreshold int
+}
+
+// Option configures Secrets, see New.
+type Option func(o *options)
+
+// WithConn makes Secrets use the given connection instead of connecting to the session bus.
+// The connection is not closed by Secrets.Close.
+func WithConn(conn *dbus.Conn) Option {
+	return func(o *options) {
+		o.conn = conn
+	}
+}
+
+// WithAddress makes Secrets connect to the bus at the given address, e.g. the one of a private
+// dbus-daemon started for tests, instead of the session bus. The connection is closed by
+// Secrets.Close. Ignored when WithConn is given.
+func WithAddress(address string) Option {
+	return func(o *options) {
+		o.address = address
+	}
+}
+
+// WithDest sets the bus name of the secret service. Defaults to "org.freedesktop.secrets".
+func WithDest(dest string) Option {
+	return func(o *options) {
+		o.dest = dest
+	}
+}
+
+// WithCallTimeout sets the maximum duration of every D-Bus call. Zero, the default, means no
+// timeout.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.callTimeout = timeout
+	}
+}
+
+// New creates Secrets. Unless WithConn is given, a new connection is made to the session bus or
+// the bus set by WithAddress.
+func New(opts ...Option) (*Secrets, error) {
+	return NewContext(context.Background(), opts...)
+}
+
+// NewContext is like New but aborts the cleanup of the state file set by WithStateFile when ctx
+// is done. The returned Secrets is not bound to ctx.
+func NewContext(ctx context.Context, opts ...Option) (*Secrets, error) {
+	o := options{
+		dest:           dbusDest,
+		maxMessageSize: dbusMaxMessageSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
 	}
 
 	s := &Secrets{
-		conn: conn,
+		conn:        o.conn,
+		dest:        o.dest,
+		callTimeout: o.callTimeout,
+		chunkSize:   max(o.chunkSize, 0),
+		client:      o.client,
+
+		compressionThreshold: max(o.compressionThreshold, 0),
+		lockedItemsHidden:    o.lockedItemsHidden,
+
+		sessions:            make(map[*Session]struct{}),
+		readOnlyCollections: make(map[dbus.ObjectPath]bool),
+		targets:             make(map[targetKey]dbus.ObjectPath),
+		collectionSignals:   make(map[chan<- CollectionChange]struct{}),
+		itemSignals:         make(map[dbus.ObjectPath]map[chan<- ItemChange]struct{}),
+		stopItemSignals:     make(map[dbus.ObjectPath]func() error),
+	}
+	s.scope, s.closeScope = context.WithCancel(context.Background())
+
+	if s.conn == nil {
+		var conn *dbus.Conn
+		var err error
+		if o.address != "" {
+			conn, err = dbus.Connect(o.address)
+		} else {
+			conn, err = dbus.ConnectSessionBus()
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+		s.ownsConn = true
+	}
+
+	s.obj = s.conn.Object(o.dest, dbusPath)
+	s.limit.max.Store(int64(max(o.maxMessageSize, 1)))
+
+	if o.statePath != "" {
+		identity, err := s.busIdentity(ctx)
+		if err == nil {
+			err = s.cleanupOrphans(ctx, o.statePath, identity)
+		}
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("could not clean up orphans: %w", err), s.Close())
+		}
+		s.handles = newHandleState(o.statePath, o.client, s.conn, identity)
 	}
-	s.obj = conn.Object(dbusDest, dbusPath)
 
 	return s, nil
 }
 
+// Close closes the sessions of OpenSession that are still open, ends the calls and prompts in
+// progress, which return ErrClosed, unregisters the channels of SubscribeCollectionChanges and
+// Collection.SubscribeItemChanges, and closes the connection to the session bus if it was
+// created by New. A connection given using WithConn is left open. For Secrets created by Shared,
+// the connection is closed when the last of them is closed. Calling Close more than once has no
+// effect.
+func (s *Secrets) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		// Before the scope is closed, calls fail afterward
+		s.muSessions.Lock()
+		sessions := slices.Collect(maps.Keys(s.sessions))
+		s.muSessions.Unlock()
+		for _, session := range sessions {
+			err = errors.Join(err, session.Close())
+		}
+
+		s.closeScope()
+
+		s.muSignals.Lock()
+		clear(s.collectionSignals)
+		err = errors.Join(err, s.stopCollectionSignalsIfUnused())
+		for collection := range s.stopItemSignals {
+			clear(s.itemSignals[collection])
+			err = errors.Join(err, s.stopItemSignalsIfUnused(collection))
+		}
+		s.muSignals.Unlock()
+
+		switch {
+		case s.shared != nil:
+			err = errors.Join(err, s.shared.release())
+		case s.ownsConn:
+			err = errors.Join(err, s.conn.Close())
+		}
+	})
+
+	return err
+}
+
+// call calls the method on the given object, taking the call timeout into account. The call
+// fails with ctx.Err() when ctx is done and with ErrClosed when Close is called.
+func (s *Secrets) call(
+	ctx context.Context,
+	obj dbus.BusObject,
+	method string,
+	args ...interface{},
+) *dbus.Call {
+	if s.scope.Err() != nil {
+		return &dbus.Call{Method: method, Err: ErrClosed}
+	}
+
+	var cancel context.CancelFunc
+	if s.callTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.callTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	stop := context.AfterFunc(s.scope, cancel)
+	defer stop()
+
+	call := obj.CallWithContext(ctx, method, 0, args...)
+	if call.Err != nil && s.scope.Err() != nil {
+		call.Err = ErrClosed
+	}
+
+	return call
+}
+
 // Lock locks the given objects. The given objects are prepended by "/org/freedesktop/secrets/".
+//
+// Deprecated: Use LockPaths, which accepts the object paths returned by the other methods.
 func (s *Secrets) Lock(paths []string) error {
 	objs := make([]dbus.ObjectPath, len(paths), len(paths))
 	for i, path := range paths {
 		objs[i] = dbus.ObjectPath(dbusPath + "/" + path)
 	}
-	err := s.obj.Call(dbusServiceInterface+".Lock", 0, objs).Err
-	if err != nil {
-		return fmt.Errorf("could lock collection: %w", err)
+	if _, err := s.LockPaths(objs); err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// LockPaths locks the given collections and items and returns those that were locked. Services
+// that confirm locking show a prompt, LockPaths waits until the user answers it. When the prompt
+// is dismissed, the objects locked without the prompt are returned together with ErrDismissed.
+func (s *Secrets) LockPaths(paths []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	return s.LockPathsContext(context.Background(), paths)
+}
+
+// LockPathsContext is like LockPaths but dismisses the prompt and returns an error wrapping
+// ctx.Err() when ctx is done.
+func (s *Secrets) LockPathsContext(ctx context.Context, paths []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	locked, err := s.lock(ctx, paths)
+	if err != nil {
+		return locked, fmt.Errorf("could not lock: %w", err)
+	}
+
+	return locked, nil
+}
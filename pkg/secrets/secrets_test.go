@@ -0,0 +1,186 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeLockService implements the Lock method of org.freedesktop.Secret.Service.
+type fakeLockService struct {
+	delay  time.Duration
+	locked chan []dbus.ObjectPath
+}
+
+func (f *fakeLockService) Lock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	time.Sleep(f.delay)
+	f.locked <- objects
+	return objects, "/", nil
+}
+
+func newTestSecrets(t *testing.T, service *fakeLockService, opts ...Option) *Secrets {
+	t.Helper()
+
+	bus := dbustest.New(t)
+	serviceConn := bus.RequestName(t, "org.example.secrets")
+	if err := serviceConn.Export(service, dbusPath, dbusServiceInterface); err != nil {
+		t.Fatalf("failed to export fake service: %v", err)
+	}
+
+	opts = append([]Option{WithConn(bus.Connect(t)), WithDest("org.example.secrets")}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s
+}
+
+func TestLock(t *testing.T) {
+	service := &fakeLockService{locked: make(chan []dbus.ObjectPath, 1)}
+	s := newTestSecrets(t, service)
+
+	if err := s.Lock([]string{"collection/login"}); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	got := <-service.locked
+	want := []dbus.ObjectPath{"/org/freedesktop/secrets/collection/login"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("service received %v, want %v", got, want)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	service := &fakeLockService{delay: time.Second, locked: make(chan []dbus.ObjectPath, 1)}
+	s := newTestSecrets(t, service, WithCallTimeout(50*time.Millisecond))
+
+	err := s.Lock([]string{"collection/login"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestLockPathsContextCanceled(t *testing.T) {
+	service := &fakeLockService{delay: time.Second, locked: make(chan []dbus.ObjectPath, 1)}
+	s := newTestSecrets(t, service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := s.LockPathsContext(ctx, []dbus.ObjectPath{testLoginCollection})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("LockPathsContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed >= service.delay {
+		t.Fatalf("LockPathsContext() returned after %v, want it to return when ctx is canceled", elapsed)
+	}
+}
+
+func TestCloseKeepsInjectedConn(t *testing.T) {
+	bus := dbustest.New(t)
+	conn := bus.Connect(t)
+
+	s, err := New(WithConn(conn))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if !conn.Connected() {
+		t.Fatalf("Close() closed the injected connection")
+	}
+}
+
+func TestWithAddress(t *testing.T) {
+	service := secretstest.New(t)
+	service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "a"}, []byte("1"))
+
+	s, err := New(WithAddress(service.Address()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	items, err := s.FindItems(map[string]string{"app": "a"}, FindOpts{})
+	if err != nil {
+		t.Fatalf("FindItems() error = %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("FindItems() = %+v, want the item of the service at the address", items)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if s.conn.Connected() {
+		t.Fatalf("Close() did not close the connection to the address")
+	}
+}
+
+func TestContextVariantsCanceled(t *testing.T) {
+	s, service := newTestService(t)
+	item := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("1"))
+	c, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"Collection.LabelContext": func() error {
+			_, err := c.LabelContext(ctx)
+			return err
+		},
+		"Collection.SetLabelContext": func() error {
+			return c.SetLabelContext(ctx, "x")
+		},
+		"GetItemTimestampsContext": func() error {
+			_, err := s.GetItemTimestampsContext(ctx, item)
+			return err
+		},
+		"SetItemLabelContext": func() error {
+			return s.SetItemLabelContext(ctx, item, "x")
+		},
+		"StatusContext": func() error {
+			_, err := s.StatusContext(ctx)
+			return err
+		},
+		"PingContext": func() error {
+			return s.PingContext(ctx)
+		},
+		"Session.CloseContext": func() error {
+			return session.CloseContext(ctx)
+		},
+		"NewContext": func() error {
+			_, err := NewContext(
+				ctx,
+				WithConn(service.Connect(t)),
+				WithStateFile(filepath.Join(t.TempDir(), "state.json")),
+			)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, context.Canceled) {
+				t.Fatalf("error = %v, want context.Canceled", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,832 @@
+package secretstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"maps"
+	"slices"
+	"time"
+)
+
+// serviceMethods implements org.freedesktop.Secret.Service.
+type serviceMethods Service
+
+func (m *serviceMethods) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".OpenSession")
+
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", dbus.NewError(
+			"org.freedesktop.DBus.Error.NotSupported",
+			[]interface{}{"only the plain algorithm is supported"},
+		)
+	}
+
+	path := s.newPath("session")
+	if err := s.exportObject(&session{s: s, path: path}, path, sessionInterface); err != nil {
+		return dbus.Variant{}, "", dbus.MakeFailedError(err)
+	}
+
+	s.mu.Lock()
+	s.sessions[path] = struct{}{}
+	s.mu.Unlock()
+
+	return dbus.MakeVariant(""), path, nil
+}
+
+func (m *serviceMethods) CreateCollection(
+	props map[string]dbus.Variant,
+	alias string,
+) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".CreateCollection")
+
+	var label string
+	if v, ok := props["org.freedesktop.Secret.Collection.Label"]; ok {
+		label, _ = v.Value().(string)
+	}
+
+	if alias != "" {
+		s.mu.Lock()
+		existing, ok := s.aliases[alias]
+		s.mu.Unlock()
+		if ok {
+			return existing, "/", nil
+		}
+	}
+
+	s.mu.Lock()
+	prompts := s.createCollectionPrompts
+	s.mu.Unlock()
+	if prompts {
+		prompt, err := s.newPrompt(func(dismissed bool) dbus.Variant {
+			if dismissed {
+				return dbus.MakeVariant(dbus.ObjectPath("/"))
+			}
+			path, err := s.createCollection(label, alias)
+			if err != nil {
+				return dbus.MakeVariant(dbus.ObjectPath("/"))
+			}
+			return dbus.MakeVariant(path)
+		})
+		if err != nil {
+			return "", "", dbus.MakeFailedError(err)
+		}
+
+		return "/", prompt, nil
+	}
+
+	path, err := s.createCollection(label, alias)
+	if err != nil {
+		return "", "", dbus.MakeFailedError(err)
+	}
+
+	return path, "/", nil
+}
+
+// createCollection adds a collection with the label and sets the alias, if any, to it.
+func (s *Service) createCollection(label string, alias string) (dbus.ObjectPath, error) {
+	name := label
+	if name == "" {
+		name = alias
+	}
+	if name == "" {
+		name = string(s.newPath("unnamed"))
+	}
+
+	path, err := s.addCollection(name, label)
+	if err != nil {
+		return "", err
+	}
+	if alias != "" {
+		s.SetAlias(alias, path)
+	}
+
+	return path, nil
+}
+
+func (m *serviceMethods) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".SearchItems")
+
+	unlocked, locked := s.search(attributes)
+	return emptyIfNil(unlocked), emptyIfNil(locked), nil
+}
+
+func (m *serviceMethods) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".Unlock")
+
+	var unlocked, needPrompt []dbus.ObjectPath
+	for _, path := range objects {
+		if s.Locked(path) {
+			needPrompt = append(needPrompt, path)
+		} else if s.exists(path) {
+			unlocked = append(unlocked, path)
+		}
+	}
+
+	if len(needPrompt) == 0 {
+		return emptyIfNil(unlocked), "/", nil
+	}
+
+	prompt, err := s.newPrompt(func(dismissed bool) dbus.Variant {
+		if dismissed {
+			return dbus.MakeVariant([]dbus.ObjectPath{})
+		}
+		return dbus.MakeVariant(emptyIfNil(s.setLocked(needPrompt, false)))
+	})
+	if err != nil {
+		return nil, "", dbus.MakeFailedError(err)
+	}
+
+	return emptyIfNil(unlocked), prompt, nil
+}
+
+func (m *serviceMethods) Lock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".Lock")
+
+	s.mu.Lock()
+	prompts := s.lockPrompts
+	s.mu.Unlock()
+	if !prompts {
+		return emptyIfNil(s.setLocked(objects, true)), "/", nil
+	}
+
+	prompt, err := s.newPrompt(func(dismissed bool) dbus.Variant {
+		if dismissed {
+			return dbus.MakeVariant([]dbus.ObjectPath{})
+		}
+		return dbus.MakeVariant(emptyIfNil(s.setLocked(objects, true)))
+	})
+	if err != nil {
+		return nil, "", dbus.MakeFailedError(err)
+	}
+
+	return []dbus.ObjectPath{}, prompt, nil
+}
+
+func (m *serviceMethods) GetSecrets(items []dbus.ObjectPath, sessionPath dbus.ObjectPath) (map[dbus.ObjectPath]Secret, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".GetSecrets")
+
+	if !s.hasSession(sessionPath) {
+		return nil, errNoSession(sessionPath)
+	}
+
+	s.mu.Lock()
+	result := make(map[dbus.ObjectPath]Secret)
+	for _, path := range items {
+		i := s.findItem(path)
+		if i == nil || i.collection.locked {
+			continue
+		}
+		result[path] = Secret{
+			Session:     sessionPath,
+			Parameters:  []byte{},
+			Value:       slices.Clone(i.secret),
+			ContentType: i.contentType,
+		}
+	}
+	if size := replySize(result); s.maxMessageSize > 0 && size > s.maxMessageSize {
+		s.mu.Unlock()
+		return nil, dbus.NewError(
+			"org.freedesktop.DBus.Error.LimitsExceeded",
+			[]interface{}{fmt.Sprintf("reply of %d bytes exceeds the maximum message size", size)},
+		)
+	}
+
+	var ended []dbus.ObjectPath
+	if s.sessionLifetime > 0 {
+		s.secretsCalls++
+		if s.secretsCalls >= s.sessionLifetime {
+			s.secretsCalls = 0
+			ended = slices.Collect(maps.Keys(s.sessions))
+			clear(s.sessions)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, path := range ended {
+		s.unexport(path, sessionInterface)
+	}
+
+	return result, nil
+}
+
+func (m *serviceMethods) ReadAlias(name string) (dbus.ObjectPath, *dbus.Error) {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".ReadAlias")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, ok := s.aliases[name]
+	if !ok {
+		return "/", nil
+	}
+
+	return path, nil
+}
+
+func (m *serviceMethods) SetAlias(name string, collection dbus.ObjectPath) *dbus.Error {
+	s := (*Service)(m)
+	s.called(serviceInterface + ".SetAlias")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if collection == "/" {
+		delete(s.aliases, name)
+		return nil
+	}
+	if _, ok := s.collections[collection]; !ok {
+		return errNoSuchObject(collection)
+	}
+	s.aliases[name] = collection
+
+	return nil
+}
+
+func (s *Service) exists(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.collections[path]
+	return ok || s.findItem(path) != nil
+}
+
+func (s *Service) hasSession(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.sessions[path]
+	return ok
+}
+
+// objectRef refers to a collection or item by path so that a deleted object is not used.
+type objectRef struct {
+	s    *Service
+	path dbus.ObjectPath
+}
+
+// collectionMethods implements org.freedesktop.Secret.Collection.
+type collectionMethods objectRef
+
+func (m *collectionMethods) Delete() (dbus.ObjectPath, *dbus.Error) {
+	s := m.s
+	s.called(collectionInterface + ".Delete")
+
+	s.mu.Lock()
+	_, ok := s.collections[m.path]
+	prompts := s.deleteCollectionPrompts
+	s.mu.Unlock()
+	if !ok {
+		return "", errNoSuchObject(m.path)
+	}
+
+	if prompts {
+		prompt, err := s.newPrompt(func(dismissed bool) dbus.Variant {
+			if !dismissed {
+				s.deleteCollection(m.path)
+			}
+			return dbus.MakeVariant("")
+		})
+		if err != nil {
+			return "", dbus.MakeFailedError(err)
+		}
+
+		return prompt, nil
+	}
+
+	if !s.deleteCollection(m.path) {
+		return "", errNoSuchObject(m.path)
+	}
+
+	return "/", nil
+}
+
+// deleteCollection removes the collection and its items. Returns false if it does not exist.
+func (s *Service) deleteCollection(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	c, ok := s.collections[path]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.collections, path)
+	for alias, aliased := range s.aliases {
+		if aliased == path {
+			delete(s.aliases, alias)
+		}
+	}
+	items := c.items
+	s.mu.Unlock()
+
+	for _, i := range items {
+		s.unexport(i.path, itemInterface, propertiesInterface)
+	}
+	s.unexport(path, collectionInterface, propertiesInterface, introspectInterface)
+	s.emit(BasePath, serviceInterface+".CollectionDeleted", path)
+
+	return true
+}
+
+func (m *collectionMethods) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+	s := m.s
+	s.called(collectionInterface + ".SearchItems")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[m.path]
+	if !ok {
+		return nil, errNoSuchObject(m.path)
+	}
+
+	result := []dbus.ObjectPath{}
+	for _, i := range c.items {
+		if c.locked && s.hideLockedItems {
+			break
+		}
+		if matches(i.attributes, attributes) {
+			result = append(result, i.path)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *collectionMethods) CreateItem(
+	props map[string]dbus.Variant,
+	secret Secret,
+	replace bool,
+) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := m.s
+	s.called(collectionInterface + ".CreateItem")
+
+	if !s.hasSession(secret.Session) {
+		return "", "", errNoSession(secret.Session)
+	}
+	if s.tooLarge(secret.Value) {
+		return "", "", errTooLarge(len(secret.Value))
+	}
+	if s.readOnly(m.path) {
+		return "", "", errAccessDenied(m.path)
+	}
+
+	var label string
+	if v, ok := props[itemInterface+".Label"]; ok {
+		label, _ = v.Value().(string)
+	}
+	attributes := map[string]string{}
+	if v, ok := props[itemInterface+".Attributes"]; ok {
+		_ = v.Store(&attributes)
+	}
+
+	if s.Locked(m.path) {
+		s.mu.Lock()
+		prompts := s.createItemPrompts
+		s.mu.Unlock()
+		if !prompts {
+			return "", "", errIsLocked(m.path)
+		}
+
+		prompt, err := s.newPrompt(func(dismissed bool) dbus.Variant {
+			if dismissed {
+				return dbus.MakeVariant(dbus.ObjectPath("/"))
+			}
+			s.setLocked([]dbus.ObjectPath{m.path}, false)
+			path, err := s.addItem(m.path, label, attributes, secret.Value, secret.ContentType, replace)
+			if err != nil {
+				return dbus.MakeVariant(dbus.ObjectPath("/"))
+			}
+			return dbus.MakeVariant(path)
+		})
+		if err != nil {
+			return "", "", dbus.MakeFailedError(err)
+		}
+
+		return "/", prompt, nil
+	}
+
+	path, err := s.addItem(m.path, label, attributes, secret.Value, secret.ContentType, replace)
+	if err != nil {
+		return "", "", errNoSuchObject(m.path)
+	}
+
+	return path, "/", nil
+}
+
+// itemMethods implements org.freedesktop.Secret.Item.
+type itemMethods objectRef
+
+func (m *itemMethods) Delete() (dbus.ObjectPath, *dbus.Error) {
+	s := m.s
+	s.called(itemInterface + ".Delete")
+
+	s.mu.Lock()
+	i := s.findItem(m.path)
+	if i == nil {
+		s.mu.Unlock()
+		return "", errNoSuchObject(m.path)
+	}
+	if i.collection.locked {
+		s.mu.Unlock()
+		return "", errIsLocked(m.path)
+	}
+	if i.collection.readOnly {
+		s.mu.Unlock()
+		return "", errAccessDenied(m.path)
+	}
+	prompts := s.deleteItemPrompts
+	s.mu.Unlock()
+
+	if prompts {
+		prompt, err := s.newPrompt(func(dismissed bool) dbus.Variant {
+			if !dismissed {
+				s.deleteItem(m.path)
+			}
+			return dbus.MakeVariant("")
+		})
+		if err != nil {
+			return "", dbus.MakeFailedError(err)
+		}
+
+		return prompt, nil
+	}
+
+	if !s.deleteItem(m.path) {
+		return "", errNoSuchObject(m.path)
+	}
+
+	return "/", nil
+}
+
+// deleteItem removes the item. Returns false if it does not exist.
+func (s *Service) deleteItem(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	i := s.findItem(path)
+	if i == nil {
+		s.mu.Unlock()
+		return false
+	}
+	c := i.collection
+	c.items = slices.DeleteFunc(c.items, func(other *item) bool { return other == i })
+	s.mu.Unlock()
+
+	s.unexport(path, itemInterface, propertiesInterface)
+	s.emit(c.path, collectionInterface+".ItemDeleted", path)
+
+	return true
+}
+
+func (m *itemMethods) GetSecret(sessionPath dbus.ObjectPath) (Secret, *dbus.Error) {
+	s := m.s
+	s.called(itemInterface + ".GetSecret")
+
+	if !s.hasSession(sessionPath) {
+		return Secret{}, errNoSession(sessionPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findItem(m.path)
+	if i == nil {
+		return Secret{}, errNoSuchObject(m.path)
+	}
+	if i.collection.locked {
+		return Secret{}, errIsLocked(m.path)
+	}
+
+	return Secret{
+		Session:     sessionPath,
+		Parameters:  []byte{},
+		Value:       slices.Clone(i.secret),
+		ContentType: i.contentType,
+	}, nil
+}
+
+func (m *itemMethods) SetSecret(secret Secret) *dbus.Error {
+	s := m.s
+	s.called(itemInterface + ".SetSecret")
+
+	if !s.hasSession(secret.Session) {
+		return errNoSession(secret.Session)
+	}
+	if s.tooLarge(secret.Value) {
+		return errTooLarge(len(secret.Value))
+	}
+
+	s.mu.Lock()
+	i := s.findItem(m.path)
+	if i == nil {
+		s.mu.Unlock()
+		return errNoSuchObject(m.path)
+	}
+	if i.collection.locked {
+		s.mu.Unlock()
+		return errIsLocked(m.path)
+	}
+	if i.collection.readOnly {
+		s.mu.Unlock()
+		return errAccessDenied(m.path)
+	}
+	i.secret = slices.Clone(secret.Value)
+	i.contentType = secret.ContentType
+	i.modified = time.Now()
+	collectionPath := i.collection.path
+	s.mu.Unlock()
+
+	s.emit(collectionPath, collectionInterface+".ItemChanged", m.path)
+
+	return nil
+}
+
+// session implements org.freedesktop.Secret.Session.
+type session struct {
+	s    *Service
+	path dbus.ObjectPath
+}
+
+func (ss *session) Close() *dbus.Error {
+	ss.s.mu.Lock()
+	delete(ss.s.sessions, ss.path)
+	ss.s.mu.Unlock()
+
+	ss.s.unexport(ss.path, sessionInterface)
+
+	return nil
+}
+
+// prompt implements org.freedesktop.Secret.Prompt.
+type prompt struct {
+	s      *Service
+	path   dbus.ObjectPath
+	action func(dismissed bool) dbus.Variant
+}
+
+func (p *prompt) Prompt(windowID string) *dbus.Error {
+	p.s.called(promptInterface + ".Prompt")
+
+	p.s.mu.Lock()
+	dismissed := p.s.dismiss
+	if p.s.held != nil {
+		p.s.held = append(p.s.held, p)
+		p.s.mu.Unlock()
+		return nil
+	}
+	p.s.mu.Unlock()
+
+	// Completed is emitted after the call returns, like a real prompt shown to the user.
+	go p.complete(dismissed)
+
+	return nil
+}
+
+func (p *prompt) Dismiss() *dbus.Error {
+	p.s.called(promptInterface + ".Dismiss")
+
+	p.s.mu.Lock()
+	p.s.held = slices.DeleteFunc(p.s.held, func(held *prompt) bool { return held == p })
+	p.s.mu.Unlock()
+
+	go p.complete(true)
+
+	return nil
+}
+
+func (p *prompt) complete(dismissed bool) {
+	result := p.action(dismissed)
+	p.s.emit(p.path, promptInterface+".Completed", dismissed, result)
+	p.s.unexport(p.path, promptInterface)
+}
+
+func emptyIfNil(paths []dbus.ObjectPath) []dbus.ObjectPath {
+	if paths == nil {
+		return []dbus.ObjectPath{}
+	}
+
+	return paths
+}
+
+// collectionIntrospection implements org.freedesktop.DBus.Introspectable for a collection. A
+// read-only collection has no CreateItem method and a Label that cannot be written, see
+// SetReadOnly.
+type collectionIntrospection objectRef
+
+func (m *collectionIntrospection) Introspect() (string, *dbus.Error) {
+	s := m.s
+	s.called(introspectInterface + ".Introspect")
+
+	s.mu.Lock()
+	c, ok := s.collections[m.path]
+	readOnly := ok && c.readOnly && !s.hideReadOnly
+	s.mu.Unlock()
+	if !ok {
+		return "", errNoSuchObject(m.path)
+	}
+
+	methods := []introspect.Method{{Name: "Delete"}, {Name: "SearchItems"}}
+	labelAccess := "read"
+	if !readOnly {
+		methods = append(methods, introspect.Method{Name: "CreateItem"})
+		labelAccess = "readwrite"
+	}
+	data, err := xml.Marshal(introspect.Node{
+		Name: string(m.path),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{
+				Name:    collectionInterface,
+				Methods: methods,
+				Properties: []introspect.Property{
+					{Name: "Items", Type: "ao", Access: "read"},
+					{Name: "Label", Type: "s", Access: labelAccess},
+					{Name: "Locked", Type: "b", Access: "read"},
+					{Name: "Created", Type: "t", Access: "read"},
+					{Name: "Modified", Type: "t", Access: "read"},
+				},
+			},
+			{Name: propertiesInterface},
+		},
+	})
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+
+	return string(data), nil
+}
+
+// properties implements org.freedesktop.DBus.Properties for the service, collections, and items.
+type properties struct {
+	s    *Service
+	path dbus.ObjectPath
+}
+
+func (p *properties) Get(iface string, name string) (dbus.Variant, *dbus.Error) {
+	p.s.called(propertiesInterface + ".Get")
+
+	all, err := p.all(iface, name == "Items")
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+
+	v, ok := all[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError(
+			"org.freedesktop.DBus.Error.UnknownProperty",
+			[]interface{}{"unknown property " + iface + "." + name},
+		)
+	}
+
+	return v, nil
+}
+
+func (p *properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	p.s.called(propertiesInterface + ".GetAll")
+
+	return p.all(iface, true)
+}
+
+// all returns the properties of the interface. withItems is set when the Items property is
+// read, which fails for locked collections if SetItemsRequireUnlock is set.
+func (p *properties) all(iface string, withItems bool) (map[string]dbus.Variant, *dbus.Error) {
+	s := p.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch iface {
+	case serviceInterface:
+		if p.path != BasePath {
+			break
+		}
+		var collections []dbus.ObjectPath
+		for _, c := range s.sortedCollections() {
+			collections = append(collections, c.path)
+		}
+		return map[string]dbus.Variant{
+			"Collections": dbus.MakeVariant(emptyIfNil(collections)),
+		}, nil
+	case collectionInterface:
+		c, ok := s.collections[p.path]
+		if !ok {
+			break
+		}
+		if withItems && c.locked && s.itemsRequireUnlock {
+			return nil, errIsLocked(c.path)
+		}
+		items := []dbus.ObjectPath{}
+		for _, i := range c.items {
+			if c.locked && s.hideLockedItems {
+				break
+			}
+			items = append(items, i.path)
+		}
+		return map[string]dbus.Variant{
+			"Items":    dbus.MakeVariant(items),
+			"Label":    dbus.MakeVariant(c.label),
+			"Locked":   dbus.MakeVariant(c.locked),
+			"Created":  dbus.MakeVariant(uint64(c.created.Unix())),
+			"Modified": dbus.MakeVariant(uint64(c.modified.Unix())),
+		}, nil
+	case itemInterface:
+		i := s.findItem(p.path)
+		if i == nil {
+			break
+		}
+		return map[string]dbus.Variant{
+			"Locked":     dbus.MakeVariant(i.collection.locked),
+			"Attributes": dbus.MakeVariant(maps.Clone(i.attributes)),
+			"Label":      dbus.MakeVariant(i.label),
+			"Created":    dbus.MakeVariant(uint64(i.created.Unix())),
+			"Modified":   dbus.MakeVariant(uint64(i.modified.Unix())),
+		}, nil
+	}
+
+	return nil, dbus.NewError(
+		"org.freedesktop.DBus.Error.UnknownInterface",
+		[]interface{}{"unknown interface " + iface},
+	)
+}
+
+func (p *properties) Set(iface string, name string, value dbus.Variant) *dbus.Error {
+	s := p.s
+	s.called(propertiesInterface + ".Set")
+
+	s.mu.Lock()
+	var changedSignal string
+	var changedPath, parent dbus.ObjectPath
+	switch iface {
+	case collectionInterface:
+		c, ok := s.collections[p.path]
+		if !ok || name != "Label" {
+			s.mu.Unlock()
+			return errReadOnly(iface, name)
+		}
+		if c.readOnly {
+			s.mu.Unlock()
+			return errAccessDenied(p.path)
+		}
+		if err := value.Store(&c.label); err != nil {
+			s.mu.Unlock()
+			return dbus.MakeFailedError(err)
+		}
+		c.modified = time.Now()
+		changedSignal, changedPath, parent = serviceInterface+".CollectionChanged", c.path, BasePath
+	case itemInterface:
+		i := s.findItem(p.path)
+		if i == nil {
+			s.mu.Unlock()
+			return errNoSuchObject(p.path)
+		}
+		if i.collection.locked {
+			s.mu.Unlock()
+			return errIsLocked(p.path)
+		}
+		if i.collection.readOnly {
+			s.mu.Unlock()
+			return errAccessDenied(p.path)
+		}
+
+		var err error
+		switch name {
+		case "Label":
+			err = value.Store(&i.label)
+		case "Attributes":
+			var attributes map[string]string
+			err = value.Store(&attributes)
+			if err == nil {
+				i.attributes = attributes
+			}
+		default:
+			s.mu.Unlock()
+			return errReadOnly(iface, name)
+		}
+		if err != nil {
+			s.mu.Unlock()
+			return dbus.MakeFailedError(err)
+		}
+		i.modified = time.Now()
+		changedSignal, changedPath, parent = collectionInterface+".ItemChanged", i.path, i.collection.path
+	default:
+		s.mu.Unlock()
+		return errReadOnly(iface, name)
+	}
+	s.mu.Unlock()
+
+	s.emit(parent, changedSignal, changedPath)
+
+	return nil
+}
+
+func errReadOnly(iface string, name string) *dbus.Error {
+	return dbus.NewError(
+		"org.freedesktop.DBus.Error.PropertyReadOnly",
+		[]interface{}{"property " + iface + "." + name + " is not writable"},
+	)
+}
@@ -0,0 +1,733 @@
+// Package secretstest provides an in-memory implementation of [org.freedesktop.Secret] that runs
+// on a private D-Bus daemon, so that code using the secrets package can be tested without a
+// keyring daemon.
+//
+// It supports collections, items with attributes, plain sessions, locking and unlocking with a
+// scriptable prompt, and the change signals of the specification. Secrets are not encrypted.
+//
+// [org.freedesktop.Secret]: https://specifications.freedesktop.org/secret-service-spec/latest/
+package secretstest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/MatthiasKunnen/system/internal/dbustest"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	Dest     = "org.freedesktop.secrets"
+	BasePath = dbus.ObjectPath("/org/freedesktop/secrets")
+
+	serviceInterface    = "org.freedesktop.Secret.Service"
+	collectionInterface = "org.freedesktop.Secret.Collection"
+	itemInterface       = "org.freedesktop.Secret.Item"
+	sessionInterface    = "org.freedesktop.Secret.Session"
+	promptInterface     = "org.freedesktop.Secret.Prompt"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+	introspectInterface = "org.freedesktop.DBus.Introspectable"
+
+	errorPrefix = "org.freedesktop.Secret.Error."
+)
+
+// Secret is the secret struct of the specification, (oayays).
+type Secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Service is a fake secret service.
+type Service struct {
+	bus  *dbustest.Bus
+	conn *dbus.Conn
+	// muExport serializes exports, godbus does not support concurrent exports on a connection.
+	muExport sync.Mutex
+
+	mu          sync.Mutex
+	collections map[dbus.ObjectPath]*collection
+	aliases     map[string]dbus.ObjectPath
+	sessions    map[dbus.ObjectPath]struct{}
+	nextID      int
+	dismiss     bool
+	calls       map[string]int
+	// itemsRequireUnlock is set by SetItemsRequireUnlock.
+	itemsRequireUnlock bool
+	// maxSecretSize is set by SetMaxSecretSize.
+	maxSecretSize int
+	// maxMessageSize is set by SetMaxMessageSize.
+	maxMessageSize int
+	// held are the prompts shown while prompts are held, non-nil while held, see HoldPrompts.
+	held []*prompt
+	// createCollectionPrompts is set by SetCreateCollectionPrompts.
+	createCollectionPrompts bool
+	// createItemPrompts is set by SetCreateItemPrompts.
+	createItemPrompts bool
+	// lockPrompts is set by SetLockPrompts.
+	lockPrompts bool
+	// deleteCollectionPrompts is set by SetDeleteCollectionPrompts.
+	deleteCollectionPrompts bool
+	// deleteItemPrompts is set by SetDeleteItemPrompts.
+	deleteItemPrompts bool
+	// hideReadOnly is set by SetReadOnlyHidden.
+	hideReadOnly bool
+	// hideLockedItems is set by SetLockedItemsHidden.
+	hideLockedItems bool
+	// sessionLifetime is set by SetSessionLifetime.
+	sessionLifetime int
+	// secretsCalls counts the GetSecrets calls since the sessions were last ended.
+	secretsCalls int
+}
+
+type collection struct {
+	path     dbus.ObjectPath
+	label    string
+	locked   bool
+	readOnly bool
+	created  time.Time
+	modified time.Time
+	items    []*item
+}
+
+type item struct {
+	path        dbus.ObjectPath
+	collection  *collection
+	label       string
+	attributes  map[string]string
+	secret      []byte
+	contentType string
+	created     time.Time
+	modified    time.Time
+}
+
+// New starts a private bus with a fake secret service that owns the name Dest. The service has a
+// single unlocked collection, "login", which is also the "default" alias.
+// The test is skipped if dbus-daemon is not installed.
+func New(t testing.TB) *Service {
+	t.Helper()
+
+	s := &Service{
+		bus:         dbustest.New(t),
+		collections: make(map[dbus.ObjectPath]*collection),
+		aliases:     make(map[string]dbus.ObjectPath),
+		sessions:    make(map[dbus.ObjectPath]struct{}),
+		calls:       make(map[string]int),
+	}
+	s.conn = s.bus.RequestName(t, Dest)
+
+	s.export(t, (*serviceMethods)(s), BasePath, serviceInterface)
+	s.export(t, &properties{s: s, path: BasePath}, BasePath, propertiesInterface)
+	s.export(t, introspect.NewIntrospectable(&introspect.Node{
+		Name: string(BasePath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{Name: serviceInterface},
+			{Name: propertiesInterface},
+		},
+	}), BasePath, introspectInterface)
+
+	login := s.AddCollection(t, "login", "Login")
+	s.SetAlias("default", login)
+
+	return s
+}
+
+func (s *Service) export(t testing.TB, v interface{}, path dbus.ObjectPath, iface string) {
+	t.Helper()
+
+	if err := s.exportObject(v, path, iface); err != nil {
+		t.Fatalf("secretstest: failed to export %s on %s: %v", iface, path, err)
+	}
+}
+
+// exportObject exports v on the connection of the service. Methods of the service run
+// concurrently and export objects, e.g. CreateItem called by several clients.
+func (s *Service) exportObject(v interface{}, path dbus.ObjectPath, iface string) error {
+	s.muExport.Lock()
+	defer s.muExport.Unlock()
+
+	return s.conn.Export(v, path, iface)
+}
+
+// Connect creates a new client connection to the bus of the service.
+func (s *Service) Connect(t testing.TB) *dbus.Conn {
+	t.Helper()
+
+	return s.bus.Connect(t)
+}
+
+// Address returns the address of the private bus.
+func (s *Service) Address() string {
+	return s.bus.Address
+}
+
+// SetPromptDismissed configures whether prompts are dismissed, as if the user cancelled them.
+// Prompts are approved by default.
+func (s *Service) SetPromptDismissed(dismiss bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dismiss = dismiss
+}
+
+// HoldPrompts keeps prompts open after they are shown, as if the user has not answered them yet,
+// until the returned function is called. Dismissed prompts complete immediately.
+func (s *Service) HoldPrompts() (release func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.held = []*prompt{}
+
+	return func() {
+		s.mu.Lock()
+		held := s.held
+		dismissed := s.dismiss
+		s.held = nil
+		s.mu.Unlock()
+
+		for _, p := range held {
+			go p.complete(dismissed)
+		}
+	}
+}
+
+// SetCreateCollectionPrompts configures whether CreateCollection returns a prompt that creates
+// the collection when it is approved, like gnome-keyring which asks for the password of the new
+// collection. By default, collections are created without a prompt.
+func (s *Service) SetCreateCollectionPrompts(prompts bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.createCollectionPrompts = prompts
+}
+
+// SetCreateItemPrompts configures whether CreateItem in a locked collection returns a prompt
+// that unlocks the collection and creates the item when it is approved, like providers that ask
+// to unlock instead of failing. By default, it fails with IsLocked.
+func (s *Service) SetCreateItemPrompts(prompts bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.createItemPrompts = prompts
+}
+
+// SetLockPrompts configures whether Lock returns a prompt that locks the objects when it is
+// approved, like providers that ask to confirm locking. By default, objects are locked without a
+// prompt.
+func (s *Service) SetLockPrompts(prompts bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lockPrompts = prompts
+}
+
+// SetDeleteCollectionPrompts configures whether deleting a collection returns a prompt that
+// deletes it when it is approved, like gnome-keyring which asks to confirm deleting a keyring.
+// By default, collections are deleted without a prompt.
+func (s *Service) SetDeleteCollectionPrompts(prompts bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteCollectionPrompts = prompts
+}
+
+// SetDeleteItemPrompts configures whether deleting an item returns a prompt that deletes it when
+// it is approved, like providers that ask to confirm deleting a secret. By default, items are
+// deleted without a prompt.
+func (s *Service) SetDeleteItemPrompts(prompts bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteItemPrompts = prompts
+}
+
+// SetReadOnly makes the collection read-only, like a collection on a smartcard or one locked by
+// policy. Creating, changing, and deleting its items and changing its label fail with
+// AccessDenied. The introspection data of a read-only collection has no CreateItem method and a
+// Label that cannot be written, unless SetReadOnlyHidden is used.
+func (s *Service) SetReadOnly(collection dbus.ObjectPath, readOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.collections[collection]; ok {
+		c.readOnly = readOnly
+	}
+}
+
+// SetReadOnlyHidden configures whether read-only collections, see SetReadOnly, are described as
+// writable collections in their introspection data, like providers that only refuse the writes.
+func (s *Service) SetReadOnlyHidden(hidden bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hideReadOnly = hidden
+}
+
+// readOnly reports whether path is a read-only collection or an item of one.
+func (s *Service) readOnly(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.collections[path]; ok {
+		return c.readOnly
+	}
+	if i := s.findItem(path); i != nil {
+		return i.collection.readOnly
+	}
+
+	return false
+}
+
+// SetItemsRequireUnlock configures whether the items of a locked collection are hidden, like
+// providers that refuse to list them. When set, reading the Items property of a locked
+// collection, or all of its properties at once, fails with IsLocked.
+func (s *Service) SetItemsRequireUnlock(require bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.itemsRequireUnlock = require
+}
+
+// SetLockedItemsHidden configures whether the items of locked collections are left out of
+// searches and the Items property, like KWallet, instead of being listed as locked.
+func (s *Service) SetLockedItemsHidden(hidden bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hideLockedItems = hidden
+}
+
+// SetMaxSecretSize makes creating an item or setting a secret larger than size bytes fail, like
+// providers that limit the size of an item. Zero, the default, means no limit.
+func (s *Service) SetMaxSecretSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxSecretSize = size
+}
+
+// tooLarge reports whether the secret exceeds the limit set by SetMaxSecretSize.
+func (s *Service) tooLarge(secret []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.maxSecretSize > 0 && len(secret) > s.maxSecretSize
+}
+
+// SetMaxMessageSize makes GetSecrets fail with org.freedesktop.DBus.Error.LimitsExceeded when its
+// reply is larger than size bytes, like a bus that limits the size of a message. Zero, the
+// default, means no limit.
+func (s *Service) SetMaxMessageSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxMessageSize = size
+}
+
+// SetSessionLifetime makes the service end all sessions after every calls GetSecrets calls, like
+// KeePassXC ending the sessions when it locks its database. Calls in an ended session fail with
+// org.freedesktop.Secret.Error.NoSession. Zero, the default, keeps sessions until they are closed.
+func (s *Service) SetSessionLifetime(calls int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessionLifetime = calls
+	s.secretsCalls = 0
+}
+
+// replySize returns the size of the method reply with the given body.
+func replySize(body ...interface{}) int {
+	msg := &dbus.Message{
+		Type: dbus.TypeMethodReply,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldReplySerial: dbus.MakeVariant(uint32(1)),
+			dbus.FieldSignature:   dbus.MakeVariant(dbus.SignatureOf(body...)),
+		},
+		Body: body,
+	}
+
+	var b bytes.Buffer
+	if err := msg.EncodeTo(&b, binary.LittleEndian); err != nil {
+		panic(fmt.Sprintf("secretstest: could not encode reply: %v", err))
+	}
+
+	return b.Len()
+}
+
+// Calls returns the number of times the method, e.g. "org.freedesktop.Secret.Service.Unlock",
+// has been called.
+func (s *Service) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+func (s *Service) called(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[method]++
+}
+
+// OpenSessions returns the paths of the sessions that have not been closed, sorted.
+func (s *Service) OpenSessions() []dbus.ObjectPath {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.sessions))
+}
+
+// AddCollection adds an unlocked collection and returns its path.
+func (s *Service) AddCollection(t testing.TB, name string, label string) dbus.ObjectPath {
+	t.Helper()
+
+	path, err := s.addCollection(name, label)
+	if err != nil {
+		t.Fatalf("secretstest: %v", err)
+	}
+
+	return path
+}
+
+func (s *Service) addCollection(name string, label string) (dbus.ObjectPath, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	c := &collection{
+		path:     BasePath + "/collection/" + dbus.ObjectPath(encodeName(name)),
+		label:    label,
+		created:  now,
+		modified: now,
+	}
+	if _, exists := s.collections[c.path]; exists {
+		s.mu.Unlock()
+		return "", fmt.Errorf("collection %s already exists", c.path)
+	}
+	s.collections[c.path] = c
+	s.mu.Unlock()
+
+	err := s.exportObject((*collectionMethods)(&objectRef{s: s, path: c.path}), c.path, collectionInterface)
+	if err != nil {
+		return "", err
+	}
+	err = s.exportObject(&properties{s: s, path: c.path}, c.path, propertiesInterface)
+	if err != nil {
+		return "", err
+	}
+	introspection := (*collectionIntrospection)(&objectRef{s: s, path: c.path})
+	err = s.exportObject(introspection, c.path, introspectInterface)
+	if err != nil {
+		return "", err
+	}
+
+	s.emit(BasePath, serviceInterface+".CollectionCreated", c.path)
+
+	return c.path, nil
+}
+
+// SetAlias makes the alias refer to the collection.
+func (s *Service) SetAlias(alias string, collection dbus.ObjectPath) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aliases[alias] = collection
+}
+
+// AddItem adds an item to the collection and returns its path.
+func (s *Service) AddItem(
+	t testing.TB,
+	collection dbus.ObjectPath,
+	label string,
+	attributes map[string]string,
+	secret []byte,
+) dbus.ObjectPath {
+	t.Helper()
+
+	path, err := s.addItem(collection, label, attributes, secret, "text/plain", false)
+	if err != nil {
+		t.Fatalf("secretstest: %v", err)
+	}
+
+	return path
+}
+
+func (s *Service) addItem(
+	collectionPath dbus.ObjectPath,
+	label string,
+	attributes map[string]string,
+	secret []byte,
+	contentType string,
+	replace bool,
+) (dbus.ObjectPath, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	c, ok := s.collections[collectionPath]
+	if !ok {
+		s.mu.Unlock()
+		return "", fmt.Errorf("no such collection %s", collectionPath)
+	}
+
+	if replace {
+		for _, existing := range c.items {
+			if maps.Equal(existing.attributes, attributes) {
+				existing.label = label
+				existing.secret = slices.Clone(secret)
+				existing.contentType = contentType
+				existing.modified = now
+				s.mu.Unlock()
+				s.emit(c.path, collectionInterface+".ItemChanged", existing.path)
+				return existing.path, nil
+			}
+		}
+	}
+
+	s.nextID++
+	i := &item{
+		path:        c.path + dbus.ObjectPath(fmt.Sprintf("/%d", s.nextID)),
+		collection:  c,
+		label:       label,
+		attributes:  maps.Clone(attributes),
+		secret:      slices.Clone(secret),
+		contentType: contentType,
+		created:     now,
+		modified:    now,
+	}
+	c.items = append(c.items, i)
+	s.mu.Unlock()
+
+	err := s.exportObject((*itemMethods)(&objectRef{s: s, path: i.path}), i.path, itemInterface)
+	if err != nil {
+		return "", err
+	}
+	err = s.exportObject(&properties{s: s, path: i.path}, i.path, propertiesInterface)
+	if err != nil {
+		return "", err
+	}
+
+	s.emit(c.path, collectionInterface+".ItemCreated", i.path)
+
+	return i.path, nil
+}
+
+// SetLocked locks or unlocks the collection without a prompt.
+func (s *Service) SetLocked(collection dbus.ObjectPath, locked bool) {
+	s.setLocked([]dbus.ObjectPath{collection}, locked)
+}
+
+// Locked reports whether the collection or item is locked.
+func (s *Service) Locked(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.collections[path]; ok {
+		return c.locked
+	}
+	if i := s.findItem(path); i != nil {
+		return i.collection.locked
+	}
+
+	return false
+}
+
+// Secret returns the secret of the item and whether the item exists.
+func (s *Service) Secret(item dbus.ObjectPath) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findItem(item)
+	if i == nil {
+		return nil, false
+	}
+
+	return slices.Clone(i.secret), true
+}
+
+// Attributes returns the attributes of the item and whether the item exists.
+func (s *Service) Attributes(item dbus.ObjectPath) (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findItem(item)
+	if i == nil {
+		return nil, false
+	}
+
+	return maps.Clone(i.attributes), true
+}
+
+// SetTimestamps sets the Created and Modified properties of the item or collection.
+func (s *Service) SetTimestamps(path dbus.ObjectPath, created time.Time, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.collections[path]; ok {
+		c.created = created
+		c.modified = modified
+	}
+	if i := s.findItem(path); i != nil {
+		i.created = created
+		i.modified = modified
+	}
+}
+
+// findItem returns the item with the given path. Holding mu is required.
+func (s *Service) findItem(path dbus.ObjectPath) *item {
+	for _, c := range s.collections {
+		for _, i := range c.items {
+			if i.path == path {
+				return i
+			}
+		}
+	}
+
+	return nil
+}
+
+// setLocked changes the lock state of the given collections and items. Locking an item locks
+// its collection. Returns the paths that were found.
+func (s *Service) setLocked(paths []dbus.ObjectPath, locked bool) []dbus.ObjectPath {
+	var result []dbus.ObjectPath
+	var changed []dbus.ObjectPath
+
+	s.mu.Lock()
+	for _, path := range paths {
+		c, ok := s.collections[path]
+		if !ok {
+			i := s.findItem(path)
+			if i == nil {
+				continue
+			}
+			c = i.collection
+		}
+
+		result = append(result, path)
+		if c.locked != locked {
+			c.locked = locked
+			changed = append(changed, c.path)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, path := range changed {
+		s.emit(BasePath, serviceInterface+".CollectionChanged", path)
+	}
+
+	return result
+}
+
+func (s *Service) search(attributes map[string]string) (unlocked []dbus.ObjectPath, locked []dbus.ObjectPath) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.sortedCollections() {
+		for _, i := range c.items {
+			if !matches(i.attributes, attributes) {
+				continue
+			}
+			if c.locked && s.hideLockedItems {
+				continue
+			}
+			if c.locked {
+				locked = append(locked, i.path)
+			} else {
+				unlocked = append(unlocked, i.path)
+			}
+		}
+	}
+
+	return unlocked, locked
+}
+
+// sortedCollections returns the collections ordered by path. Holding mu is required.
+func (s *Service) sortedCollections() []*collection {
+	return slices.SortedFunc(maps.Values(s.collections), func(a, b *collection) int {
+		return strings.Compare(string(a.path), string(b.path))
+	})
+}
+
+func matches(attributes map[string]string, search map[string]string) bool {
+	for k, v := range search {
+		if attributes[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Service) newPath(kind string) dbus.ObjectPath {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	return BasePath + dbus.ObjectPath(fmt.Sprintf("/%s/%d", kind, s.nextID))
+}
+
+// newPrompt exports a prompt that runs action with the result of the prompt when it is
+// completed. action returns the result of the Completed signal.
+func (s *Service) newPrompt(action func(dismissed bool) dbus.Variant) (dbus.ObjectPath, error) {
+	p := &prompt{s: s, path: s.newPath("prompt"), action: action}
+	if err := s.exportObject(p, p.path, promptInterface); err != nil {
+		return "", err
+	}
+
+	return p.path, nil
+}
+
+func (s *Service) emit(path dbus.ObjectPath, name string, values ...interface{}) {
+	// Emitting only fails when the connection is closed, which happens at the end of the test.
+	_ = s.conn.Emit(path, name, values...)
+}
+
+func (s *Service) unexport(path dbus.ObjectPath, ifaces ...string) {
+	for _, iface := range ifaces {
+		_ = s.exportObject(nil, path, iface)
+	}
+}
+
+func encodeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 128 && (r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "_%02x", r)
+		}
+	}
+
+	return b.String()
+}
+
+func errNoSuchObject(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError(errorPrefix+"NoSuchObject", []interface{}{fmt.Sprintf("no such object %s", path)})
+}
+
+func errIsLocked(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError(errorPrefix+"IsLocked", []interface{}{fmt.Sprintf("%s is locked", path)})
+}
+
+func errTooLarge(size int) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.LimitsExceeded", []interface{}{fmt.Sprintf("secret of %d bytes is too large", size)})
+}
+
+func errAccessDenied(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError(
+		"org.freedesktop.DBus.Error.AccessDenied",
+		[]interface{}{fmt.Sprintf("%s is read-only", path)},
+	)
+}
+
+func errNoSession(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError(errorPrefix+"NoSession", []interface{}{fmt.Sprintf("no such session %s", path)})
+}
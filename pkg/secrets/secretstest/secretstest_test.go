@@ -0,0 +1,119 @@
+package secretstest
+
+import (
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"testing"
+	"time"
+)
+
+func unlock(t *testing.T, conn *dbus.Conn, paths []dbus.ObjectPath) (bool, []dbus.ObjectPath) {
+	t.Helper()
+
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface(promptInterface)); err != nil {
+		t.Fatalf("AddMatchSignal() error = %v", err)
+	}
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := conn.Object(Dest, BasePath).Call(serviceInterface+".Unlock", 0, paths).Store(&unlocked, &prompt)
+	if err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if prompt == "/" {
+		return false, unlocked
+	}
+
+	if err := conn.Object(Dest, prompt).Call(promptInterface+".Prompt", 0, "").Err; err != nil {
+		t.Fatalf("Prompt() error = %v", err)
+	}
+
+	select {
+	case sig := <-signals:
+		if sig.Path != prompt {
+			t.Fatalf("Completed emitted on %s, want %s", sig.Path, prompt)
+		}
+		return sig.Body[0].(bool), sig.Body[1].(dbus.Variant).Value().([]dbus.ObjectPath)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Completed")
+		return false, nil
+	}
+}
+
+func TestUnlockPrompt(t *testing.T) {
+	s := New(t)
+	login := BasePath + "/collection/login"
+	s.SetLocked(login, true)
+
+	dismissed, unlocked := unlock(t, s.Connect(t), []dbus.ObjectPath{login})
+	if dismissed {
+		t.Fatal("prompt dismissed, want approved")
+	}
+	if !slices.Equal(unlocked, []dbus.ObjectPath{login}) {
+		t.Errorf("unlocked = %v, want [%s]", unlocked, login)
+	}
+	if s.Locked(login) {
+		t.Error("collection still locked after approved prompt")
+	}
+}
+
+func TestUnlockPromptDismissed(t *testing.T) {
+	s := New(t)
+	login := BasePath + "/collection/login"
+	s.SetLocked(login, true)
+	s.SetPromptDismissed(true)
+
+	dismissed, _ := unlock(t, s.Connect(t), []dbus.ObjectPath{login})
+	if !dismissed {
+		t.Fatal("prompt approved, want dismissed")
+	}
+	if !s.Locked(login) {
+		t.Error("collection unlocked after dismissed prompt")
+	}
+}
+
+func TestCreateAndGetSecret(t *testing.T) {
+	s := New(t)
+	conn := s.Connect(t)
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err := conn.Object(Dest, BasePath).Call(serviceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &session)
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+
+	props := map[string]dbus.Variant{
+		itemInterface + ".Label":      dbus.MakeVariant("token"),
+		itemInterface + ".Attributes": dbus.MakeVariant(map[string]string{"app": "agent"}),
+	}
+	secret := Secret{Session: session, Parameters: []byte{}, Value: []byte("hunter2"), ContentType: "text/plain"}
+	var item, prompt dbus.ObjectPath
+	err = conn.Object(Dest, BasePath+"/collection/login").Call(collectionInterface+".CreateItem", 0, props, secret, false).
+		Store(&item, &prompt)
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+
+	var got Secret
+	err = conn.Object(Dest, item).Call(itemInterface+".GetSecret", 0, session).Store(&got)
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if string(got.Value) != "hunter2" {
+		t.Errorf("GetSecret() = %q, want %q", got.Value, "hunter2")
+	}
+
+	var unlocked, locked []dbus.ObjectPath
+	err = conn.Object(Dest, BasePath).Call(serviceInterface+".SearchItems", 0, map[string]string{"app": "agent"}).
+		Store(&unlocked, &locked)
+	if err != nil {
+		t.Fatalf("SearchItems() error = %v", err)
+	}
+	if !slices.Equal(unlocked, []dbus.ObjectPath{item}) || len(locked) != 0 {
+		t.Errorf("SearchItems() = %v, %v, want [%s], []", unlocked, locked, item)
+	}
+}
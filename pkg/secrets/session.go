@@ -0,0 +1,229 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"sync"
+)
+
+const dbusSessionInterface = "org.freedesktop.Secret.Session"
+
+// ErrSessionClosed is returned when using a Session after Close has been called.
+var ErrSessionClosed = errors.New("session is closed")
+
+// secret is the Secret struct of the specification, (oayays).
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// openSession opens a session without transport encryption. Close it using closeSession.
+func (s *Secrets) openSession(ctx context.Context) (dbus.ObjectPath, error) {
+	var output dbus.Variant
+	return s.openSessionWith(ctx, "plain", dbus.MakeVariant(""), &output)
+}
+
+// openSessionWith opens a session using the given algorithm and stores the output of the
+// service in output. The session is recorded in the state file, see WithStateFile.
+// Close it using closeSession.
+func (s *Secrets) openSessionWith(
+	ctx context.Context,
+	algorithm string,
+	input dbus.Variant,
+	output *dbus.Variant,
+) (dbus.ObjectPath, error) {
+	id, err := s.handles.begin(handleSession)
+	if err != nil {
+		return "", fmt.Errorf("could not open session: %w", err)
+	}
+
+	var session dbus.ObjectPath
+	err = s.call(ctx, s.obj, dbusServiceInterface+".OpenSession", algorithm, input).
+		Store(output, &session)
+	if err != nil {
+		s.handles.remove(id)
+		return "", fmt.Errorf("could not open session: %w", err)
+	}
+
+	if err := s.handles.created(id, session); err != nil {
+		return "", errors.Join(
+			fmt.Errorf("could not open session: %w", err),
+			s.closeSession(context.WithoutCancel(ctx), session),
+		)
+	}
+
+	return session, nil
+}
+
+// closeSession closes the session. Operations that open a session pass their ctx without its
+// cancellation, see context.WithoutCancel, so that the session is closed when ctx is done.
+func (s *Secrets) closeSession(ctx context.Context, session dbus.ObjectPath) error {
+	obj := s.conn.Object(s.dest, session)
+	err := s.call(ctx, obj, dbusSessionInterface+".Close").Err
+	// A session that the service ended already, e.g. when KeePassXC locked its database, is
+	// closed as well
+	if err != nil && !isNoSuchObject(err) && !isDbusError(err, errNoSession) {
+		return fmt.Errorf("could not close session: %w", err)
+	}
+	s.handles.removePath(session)
+
+	return nil
+}
+
+// Session is a session with the secret service, which secrets are transferred in. Methods such as
+// FindItems open their own session, a Session is for transferring several secrets, possibly
+// over time, without opening a session every time.
+type Session struct {
+	secrets *Secrets
+	path    dbus.ObjectPath
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// OpenSession opens a session without transport encryption, the "plain" algorithm. The session
+// can be used until it is closed using Session.Close or Secrets.Close. It is recorded in the
+// state file, see WithStateFile.
+func (s *Secrets) OpenSession() (*Session, error) {
+	return s.OpenSessionContext(context.Background())
+}
+
+// OpenSessionContext is like OpenSession but aborts the call when ctx is done.
+func (s *Secrets) OpenSessionContext(ctx context.Context) (*Session, error) {
+	path, err := s.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{secrets: s, path: path}
+	s.muSessions.Lock()
+	s.sessions[session] = struct{}{}
+	s.muSessions.Unlock()
+
+	return session, nil
+}
+
+// Path returns the object path of the session.
+func (s *Session) Path() dbus.ObjectPath {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.path
+}
+
+// GetSecret returns the secret of the item and its content type, e.g. "text/plain". The item
+// must be unlocked, see Secrets.WithUnlocked.
+func (s *Session) GetSecret(item dbus.ObjectPath) ([]byte, string, error) {
+	return s.GetSecretContext(context.Background(), item)
+}
+
+// GetSecretContext is like GetSecret but aborts the call when ctx is done.
+func (s *Session) GetSecretContext(ctx context.Context, item dbus.ObjectPath) ([]byte, string, error) {
+	s.mu.Lock()
+	closed, path := s.closed, s.path
+	s.mu.Unlock()
+	if closed {
+		return nil, "", ErrSessionClosed
+	}
+
+	var result secret
+	obj := s.secrets.conn.Object(s.secrets.dest, item)
+	err := s.secrets.call(ctx, obj, dbusItemInterface+".GetSecret", path).Store(&result)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get secret of %s: %w", item, err)
+	}
+
+	return result.Value, result.ContentType, nil
+}
+
+// GetSecrets returns the secrets of the items in as few calls as possible, transferred in the
+// session. Services omit the items they cannot return, such as locked items, instead of failing:
+// callers must check the map for every item they need and unlock the missing ones, e.g. using
+// WithUnlocked. The secret of a chunked item, see WithChunkSize, is that of its first chunk only,
+// use FindItems to get the joined secret.
+//
+// When the service ends the session halfway, e.g. because KeePassXC locked its database, the
+// session is replaced by a new one once and the remaining secrets are retrieved in it. If the
+// new session cannot be opened or ends as well, the secrets retrieved so far are returned
+// together with a *PartialSecretsError listing the items that are missing.
+func (s *Secrets) GetSecrets(items []Item, session *Session) (map[dbus.ObjectPath]Secret, error) {
+	return s.GetSecretsContext(context.Background(), items, session)
+}
+
+// GetSecretsContext is like GetSecrets but aborts the calls when ctx is done.
+func (s *Secrets) GetSecretsContext(
+	ctx context.Context,
+	items []Item,
+	session *Session,
+) (map[dbus.ObjectPath]Secret, error) {
+	session.mu.Lock()
+	closed, path := session.closed, session.path
+	session.mu.Unlock()
+	if closed {
+		return nil, ErrSessionClosed
+	}
+
+	paths := make([]dbus.ObjectPath, 0, len(items))
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+
+	secrets, err := s.getSecrets(ctx, path, paths, session.reopen)
+	var partial *PartialSecretsError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
+	}
+
+	result := make(map[dbus.ObjectPath]Secret, len(secrets))
+	for path, secret := range secrets {
+		result[path] = Secret{Value: secret.Value, ContentType: secret.ContentType}
+	}
+
+	return result, err
+}
+
+// reopen replaces the session that the service ended by a new one.
+func (s *Session) reopen(ctx context.Context) (dbus.ObjectPath, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return "", ErrSessionClosed
+	}
+
+	// The service ended the session, only its record in the state file is left
+	s.secrets.handles.removePath(s.path)
+	path, err := s.secrets.openSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.path = path
+
+	return path, nil
+}
+
+// Close closes the session. Calling Close more than once has no effect, Secrets.Close closes the
+// sessions that are still open.
+func (s *Session) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext is like Close but aborts the call when ctx is done. The session is considered
+// closed even then.
+func (s *Session) CloseContext(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.secrets.muSessions.Lock()
+	delete(s.secrets.sessions, s)
+	s.secrets.muSessions.Unlock()
+
+	return s.secrets.closeSession(ctx, s.path)
+}
@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestOpenSession(t *testing.T) {
+	s, service := newTestService(t)
+	token := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	other := service.AddItem(t, testLoginCollection, "other", map[string]string{"app": "other"}, []byte("x"))
+
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+	if !slices.Contains(service.OpenSessions(), session.Path()) {
+		t.Fatalf("session %s is not open, open sessions %v", session.Path(), service.OpenSessions())
+	}
+
+	// The session is reused for every secret
+	for item, want := range map[dbus.ObjectPath]string{token: "hunter2", other: "x"} {
+		value, contentType, err := session.GetSecret(item)
+		if err != nil {
+			t.Fatalf("GetSecret(%s) error = %v", item, err)
+		}
+		if string(value) != want || contentType != "text/plain" {
+			t.Fatalf("GetSecret(%s) = %q, %q, want %q, text/plain", item, value, contentType, want)
+		}
+	}
+	if calls := service.Calls("org.freedesktop.Secret.Service.OpenSession"); calls != 1 {
+		t.Fatalf("OpenSession called %d times, want 1", calls)
+	}
+
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(service.OpenSessions()) != 0 {
+		t.Fatalf("sessions still open after Close: %v", service.OpenSessions())
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if _, _, err := session.GetSecret(token); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("GetSecret() after Close error = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestGetSecrets(t *testing.T) {
+	s, service := newTestService(t)
+	a := service.AddItem(t, testLoginCollection, "a", map[string]string{"app": "a"}, []byte("1"))
+	b := service.AddItem(t, testLoginCollection, "b", map[string]string{"app": "b"}, []byte("2"))
+	work := service.AddCollection(t, "work", "Work")
+	locked := service.AddItem(t, work, "c", map[string]string{"app": "c"}, []byte("3"))
+	service.SetLocked(work, true)
+
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+	defer session.Close()
+
+	secrets, err := s.GetSecrets([]Item{{Path: a}, {Path: b}, {Path: locked}}, session)
+	if err != nil {
+		t.Fatalf("GetSecrets() error = %v", err)
+	}
+	if len(secrets) != 2 || string(secrets[a].Value) != "1" || string(secrets[b].Value) != "2" {
+		t.Fatalf("GetSecrets() = %v, want the secrets of %s and %s", secrets, a, b)
+	}
+	if secrets[a].ContentType != "text/plain" {
+		t.Fatalf("GetSecrets() content type = %q, want text/plain", secrets[a].ContentType)
+	}
+	if _, ok := secrets[locked]; ok {
+		t.Fatalf("GetSecrets() returned the secret of locked item %s", locked)
+	}
+	if calls := service.Calls("org.freedesktop.Secret.Service.GetSecrets"); calls != 1 {
+		t.Fatalf("GetSecrets called %d times, want 1", calls)
+	}
+
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := s.GetSecrets([]Item{{Path: a}}, session); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("GetSecrets() after Close error = %v, want ErrSessionClosed", err)
+	}
+}
+
+func BenchmarkGetSecrets(b *testing.B) {
+	s, service := newTestService(b)
+	items := make([]Item, 50)
+	for i := range items {
+		attributes := map[string]string{"app": strconv.Itoa(i)}
+		items[i].Path = service.AddItem(b, testLoginCollection, "item", attributes, []byte("secret"))
+	}
+
+	session, err := s.OpenSession()
+	if err != nil {
+		b.Fatalf("OpenSession() error = %v", err)
+	}
+	defer session.Close()
+
+	b.Run("sequential", func(b *testing.B) {
+		for range b.N {
+			for _, item := range items {
+				if _, _, err := session.GetSecret(item.Path); err != nil {
+					b.Fatalf("GetSecret() error = %v", err)
+				}
+			}
+		}
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		for range b.N {
+			if _, err := s.GetSecrets(items, session); err != nil {
+				b.Fatalf("GetSecrets() error = %v", err)
+			}
+		}
+	})
+}
+
+func TestCloseClosesSessions(t *testing.T) {
+	s, service := newTestService(t)
+	token := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "a"}, []byte("1"))
+	first, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+	second, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession() error = %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Secrets.Close() error = %v", err)
+	}
+	if len(service.OpenSessions()) != 0 {
+		t.Fatalf("sessions still open after Secrets.Close: %v", service.OpenSessions())
+	}
+	if _, _, err := second.GetSecret(token); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("GetSecret() after Secrets.Close error = %v, want ErrSessionClosed", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("Session.Close() after Secrets.Close error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Secrets.Close() error = %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"sync"
+)
+
+// sharedConn is the session bus connection of Shared, closed when the last Secrets using it is
+// closed.
+type sharedConn struct {
+	conn *dbus.Conn
+	refs int
+}
+
+var (
+	muShared sync.Mutex
+	shared   *sharedConn
+	// connectShared creates the connection of Shared, replaced in tests.
+	connectShared = func() (*dbus.Conn, error) {
+		return dbus.ConnectSessionBus()
+	}
+)
+
+// Shared creates Secrets that use the session bus connection shared by all Secrets created using
+// Shared in this process, e.g. by several libraries of one application. The shared connection is
+// created when needed and closed when the last Secrets using it is closed. WithConn and
+// WithAddress are ignored.
+//
+// Every Secrets is independent otherwise: Close ends the calls and prompts of that Secrets only,
+// those of the others continue. The sessions with the service are opened per operation and are
+// not shared.
+//
+// Secrets is safe for concurrent use, whether shared or not.
+func Shared(opts ...Option) (*Secrets, error) {
+	return SharedContext(context.Background(), opts...)
+}
+
+// SharedContext is like Shared but aborts the cleanup of the state file set by WithStateFile when
+// ctx is done. The returned Secrets is not bound to ctx.
+func SharedContext(ctx context.Context, opts ...Option) (*Secrets, error) {
+	sc, err := acquireShared()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewContext(ctx, append(opts, WithConn(sc.conn))...)
+	if err != nil {
+		return nil, errors.Join(err, sc.release())
+	}
+	s.shared = sc
+
+	return s, nil
+}
+
+// acquireShared returns the shared connection, connecting if needed, and adds a reference to it.
+func acquireShared() (*sharedConn, error) {
+	muShared.Lock()
+	defer muShared.Unlock()
+
+	if shared == nil {
+		conn, err := connectShared()
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to session bus: %w", err)
+		}
+
+		shared = &sharedConn{conn: conn}
+	}
+	shared.refs++
+
+	return shared, nil
+}
+
+// release drops a reference to the shared connection and closes it when it was the last one.
+func (sc *sharedConn) release() error {
+	muShared.Lock()
+	sc.refs--
+	last := sc.refs == 0
+	if last && shared == sc {
+		shared = nil
+	}
+	muShared.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	if err := sc.conn.Close(); err != nil {
+		return fmt.Errorf("could not close session bus connection: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/system/pkg/secrets/secretstest"
+	"github.com/godbus/dbus/v5"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useSharedService makes Shared connect to the bus of the service and returns the number of
+// connections made.
+func useSharedService(t *testing.T, service *secretstest.Service) *int {
+	t.Helper()
+
+	connects := new(int)
+	prev := connectShared
+	connectShared = func() (*dbus.Conn, error) {
+		*connects++
+		return dbus.Connect(service.Address())
+	}
+	t.Cleanup(func() {
+		connectShared = prev
+	})
+
+	return connects
+}
+
+func newShared(t *testing.T) *Secrets {
+	t.Helper()
+
+	s, err := Shared()
+	if err != nil {
+		t.Fatalf("Shared() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s
+}
+
+func TestShared(t *testing.T) {
+	service := secretstest.New(t)
+	connects := useSharedService(t, service)
+	attributes := map[string]string{"app": "shared"}
+	service.AddItem(t, testLoginCollection, "item", attributes, []byte("secret"))
+
+	a := newShared(t)
+	b := newShared(t)
+	if a.conn != b.conn || *connects != 1 {
+		t.Fatalf("Shared() made %d connection(s), want one shared connection", *connects)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		s := []*Secrets{a, b}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.FindItems(attributes, FindOpts{WithSecrets: true})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("FindItems() error = %v", err)
+		}
+	}
+
+	// Closing one Secrets leaves the connection to the other
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if _, err := a.FindItems(attributes, FindOpts{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("FindItems() after Close error = %v, want ErrClosed", err)
+	}
+	if _, err := b.FindItems(attributes, FindOpts{}); err != nil {
+		t.Fatalf("FindItems() of the other Secrets error = %v", err)
+	}
+
+	conn := b.conn
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if conn.Connected() {
+		t.Fatalf("Close() of the last Secrets kept the connection")
+	}
+
+	// The next Secrets connects again
+	c := newShared(t)
+	if c.conn == conn || *connects != 2 {
+		t.Fatalf("Shared() after the last Close reused the closed connection")
+	}
+}
+
+func TestSharedCloseScope(t *testing.T) {
+	service := secretstest.New(t)
+	useSharedService(t, service)
+	service.SetLocked(testLoginCollection, true)
+	release := service.HoldPrompts()
+
+	a := newShared(t)
+	b := newShared(t)
+	paths := []dbus.ObjectPath{testLoginCollection}
+	errA := make(chan error, 1)
+	errB := make(chan error, 1)
+	go func() {
+		errA <- a.WithUnlocked(paths, func() error {
+			return errors.New("fn of the closed Secrets ran")
+		})
+	}()
+	go func() {
+		errB <- b.WithUnlocked(paths, func() error {
+			if service.Locked(testLoginCollection) {
+				return errors.New("collection locked in fn")
+			}
+			return nil
+		})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for service.Calls("org.freedesktop.Secret.Prompt.Prompt") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("prompts not shown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Closing a ends its prompt only
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := <-errA; !errors.Is(err, ErrClosed) {
+		t.Fatalf("WithUnlocked() of the closed Secrets error = %v, want ErrClosed", err)
+	}
+	select {
+	case err := <-errB:
+		t.Fatalf("WithUnlocked() of the other Secrets returned %v before its prompt completed", err)
+	default:
+	}
+
+	release()
+	if err := <-errB; err != nil {
+		t.Fatalf("WithUnlocked() of the other Secrets error = %v", err)
+	}
+	if !service.Locked(testLoginCollection) {
+		t.Fatalf("collection not locked again")
+	}
+}
+
+func TestSharedConcurrent(t *testing.T) {
+	service := secretstest.New(t)
+	useSharedService(t, service)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				s, err := Shared()
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = s.Status()
+				if err := errors.Join(err, s.Close()); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("error = %v", err)
+	}
+
+	muShared.Lock()
+	defer muShared.Unlock()
+	if shared != nil {
+		t.Fatalf("shared connection left with %d reference(s)", shared.refs)
+	}
+}
+
+func TestSharedConnectError(t *testing.T) {
+	prev := connectShared
+	connectShared = func() (*dbus.Conn, error) {
+		return nil, errors.New("no session bus")
+	}
+	t.Cleanup(func() {
+		connectShared = prev
+	})
+
+	if _, err := Shared(); err == nil {
+		t.Fatalf("Shared() without a session bus succeeded")
+	}
+	if shared != nil {
+		t.Fatalf("Shared() kept a failed connection")
+	}
+}
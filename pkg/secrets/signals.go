@@ -0,0 +1,308 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"strings"
+)
+
+// signalBuffer is the capacity of the channel that receives the signals of the service.
+const signalBuffer = 16
+
+// CollectionChangeKind is the kind of a CollectionChange.
+type CollectionChangeKind int
+
+const (
+	// CollectionCreated means the collection has been created.
+	CollectionCreated CollectionChangeKind = iota
+	// CollectionChanged means the properties of the collection have changed.
+	CollectionChanged
+	// CollectionDeleted means the collection has been deleted.
+	CollectionDeleted
+)
+
+func (k CollectionChangeKind) String() string {
+	switch k {
+	case CollectionCreated:
+		return "created"
+	case CollectionChanged:
+		return "changed"
+	case CollectionDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("CollectionChangeKind(%d)", int(k))
+	}
+}
+
+// CollectionChange is a change of a collection, see SubscribeCollectionChanges.
+type CollectionChange struct {
+	Kind       CollectionChangeKind
+	Collection dbus.ObjectPath
+}
+
+// SubscribeCollectionChanges registers c to receive the collections that are created, changed,
+// or deleted. The change is dropped for c when c is not ready to receive it, use a buffered
+// channel. The signals of the service are only received while at least one channel is
+// registered. Unregister c using UnsubscribeCollectionChanges, Close unregisters every channel.
+func (s *Secrets) SubscribeCollectionChanges(c chan<- CollectionChange) error {
+	if c == nil {
+		return errors.New("SubscribeCollectionChanges: channel cannot be nil")
+	}
+	if s.scope.Err() != nil {
+		return ErrClosed
+	}
+
+	s.muSignals.Lock()
+	defer s.muSignals.Unlock()
+
+	if s.stopCollectionSignals == nil {
+		stop, err := s.receiveCollectionSignals()
+		if err != nil {
+			return fmt.Errorf("could not subscribe to collection changes: %w", err)
+		}
+		s.stopCollectionSignals = stop
+	}
+	s.collectionSignals[c] = struct{}{}
+
+	return nil
+}
+
+// UnsubscribeCollectionChanges unregisters c. No change is sent to c after it returns. The
+// signals of the service are no longer received once the last channel is unregistered.
+func (s *Secrets) UnsubscribeCollectionChanges(c chan<- CollectionChange) error {
+	if c == nil {
+		return errors.New("UnsubscribeCollectionChanges: channel cannot be nil")
+	}
+
+	s.muSignals.Lock()
+	defer s.muSignals.Unlock()
+
+	delete(s.collectionSignals, c)
+
+	return s.stopCollectionSignalsIfUnused()
+}
+
+// stopCollectionSignalsIfUnused stops receiving the collection signals when no channel needs
+// them anymore. Holding the muSignals mutex is required.
+func (s *Secrets) stopCollectionSignalsIfUnused() error {
+	if len(s.collectionSignals) > 0 || s.stopCollectionSignals == nil {
+		return nil
+	}
+
+	err := s.stopCollectionSignals()
+	s.stopCollectionSignals = nil
+	if err != nil {
+		return fmt.Errorf("could not unsubscribe from collection changes: %w", err)
+	}
+
+	return nil
+}
+
+// receiveCollectionSignals starts receiving the collection signals. Holding the muSignals mutex
+// is required.
+func (s *Secrets) receiveCollectionSignals() (stop func() error, err error) {
+	return s.receiveSignals(dbusPath, dbusServiceInterface, s.handleCollectionSignal)
+}
+
+// receiveSignals adds a match rule for the signals of iface emitted on path and passes them to
+// handle until the returned function is called.
+func (s *Secrets) receiveSignals(
+	path dbus.ObjectPath,
+	iface string,
+	handle func(signal *dbus.Signal),
+) (stop func() error, err error) {
+	matchOptions := []dbus.MatchOption{
+		dbus.WithMatchSender(s.dest),
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(iface),
+	}
+	if err := s.conn.AddMatchSignal(matchOptions...); err != nil {
+		return nil, err
+	}
+
+	signals := make(chan *dbus.Signal, signalBuffer)
+	done := make(chan struct{})
+	s.conn.Signal(signals)
+	go func() {
+		for {
+			select {
+			case signal, ok := <-signals:
+				if !ok {
+					// The connection is closed
+					return
+				}
+				// The connection delivers every signal it receives
+				if signal != nil && signal.Path == path && strings.HasPrefix(signal.Name, iface+".") {
+					handle(signal)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		s.conn.RemoveSignal(signals)
+		close(done)
+		return s.conn.RemoveMatchSignal(matchOptions...)
+	}, nil
+}
+
+// handleCollectionSignal delivers the change of a collection signal without blocking.
+func (s *Secrets) handleCollectionSignal(signal *dbus.Signal) {
+	var kind CollectionChangeKind
+	switch signal.Name {
+	case dbusServiceInterface + ".CollectionCreated":
+		kind = CollectionCreated
+	case dbusServiceInterface + ".CollectionChanged":
+		kind = CollectionChanged
+	case dbusServiceInterface + ".CollectionDeleted":
+		kind = CollectionDeleted
+	default:
+		return
+	}
+
+	var collection dbus.ObjectPath
+	if err := dbus.Store(signal.Body, &collection); err != nil {
+		return
+	}
+	change := CollectionChange{Kind: kind, Collection: collection}
+
+	s.muSignals.Lock()
+	defer s.muSignals.Unlock()
+	for c := range s.collectionSignals {
+		select {
+		case c <- change:
+		default:
+		}
+	}
+}
+
+// ItemChangeKind is the kind of an ItemChange.
+type ItemChangeKind int
+
+const (
+	// ItemCreated means the item has been created.
+	ItemCreated ItemChangeKind = iota
+	// ItemChanged means the properties or the secret of the item have changed.
+	ItemChanged
+	// ItemDeleted means the item has been deleted.
+	ItemDeleted
+)
+
+func (k ItemChangeKind) String() string {
+	switch k {
+	case ItemCreated:
+		return "created"
+	case ItemChanged:
+		return "changed"
+	case ItemDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("ItemChangeKind(%d)", int(k))
+	}
+}
+
+// ItemChange is a change of an item of a collection, see Collection.SubscribeItemChanges.
+type ItemChange struct {
+	Kind ItemChangeKind
+	Item dbus.ObjectPath
+}
+
+// SubscribeItemChanges registers ch to receive the items of the collection that are created,
+// changed, or deleted. Like Secrets.SubscribeCollectionChanges, changes are dropped for ch when
+// it is not ready to receive them. The match rule is limited to the path of the collection, ch
+// can be registered for several collections. Unregister ch using UnsubscribeItemChanges,
+// Secrets.Close unregisters every channel.
+func (c Collection) SubscribeItemChanges(ch chan<- ItemChange) error {
+	if ch == nil {
+		return errors.New("SubscribeItemChanges: channel cannot be nil")
+	}
+	s := c.secrets
+	if s.scope.Err() != nil {
+		return ErrClosed
+	}
+
+	s.muSignals.Lock()
+	defer s.muSignals.Unlock()
+
+	if _, ok := s.stopItemSignals[c.path]; !ok {
+		path := c.path
+		stop, err := s.receiveSignals(path, dbusCollectionInterface, func(signal *dbus.Signal) {
+			s.handleItemSignal(path, signal)
+		})
+		if err != nil {
+			return fmt.Errorf("could not subscribe to item changes of %s: %w", c.path, err)
+		}
+		s.stopItemSignals[c.path] = stop
+		s.itemSignals[c.path] = make(map[chan<- ItemChange]struct{})
+	}
+	s.itemSignals[c.path][ch] = struct{}{}
+
+	return nil
+}
+
+// UnsubscribeItemChanges unregisters ch for the collection. No change of the collection is sent
+// to ch after it returns. The signals of the collection are no longer received once its last
+// channel is unregistered.
+func (c Collection) UnsubscribeItemChanges(ch chan<- ItemChange) error {
+	if ch == nil {
+		return errors.New("UnsubscribeItemChanges: channel cannot be nil")
+	}
+	s := c.secrets
+
+	s.muSignals.Lock()
+	defer s.muSignals.Unlock()
+
+	delete(s.itemSignals[c.path], ch)
+
+	return s.stopItemSignalsIfUnused(c.path)
+}
+
+// stopItemSignalsIfUnused stops receiving the item signals of the collection when no channel
+// needs them anymore. Holding the muSignals mutex is required.
+func (s *Secrets) stopItemSignalsIfUnused(collection dbus.ObjectPath) error {
+	stop, ok := s.stopItemSignals[collection]
+	if len(s.itemSignals[collection]) > 0 || !ok {
+		return nil
+	}
+
+	delete(s.stopItemSignals, collection)
+	delete(s.itemSignals, collection)
+	if err := stop(); err != nil {
+		return fmt.Errorf("could not unsubscribe from item changes of %s: %w", collection, err)
+	}
+
+	return nil
+}
+
+// handleItemSignal delivers the change of an item signal of the collection without blocking.
+func (s *Secrets) handleItemSignal(collection dbus.ObjectPath, signal *dbus.Signal) {
+	var kind ItemChangeKind
+	switch signal.Name {
+	case dbusCollectionInterface + ".ItemCreated":
+		kind = ItemCreated
+	case dbusCollectionInterface + ".ItemChanged":
+		kind = ItemChanged
+	case dbusCollectionInterface + ".ItemDeleted":
+		kind = ItemDeleted
+	default:
+		return
+	}
+
+	var item dbus.ObjectPath
+	if err := dbus.Store(signal.Body, &item); err != nil {
+		return
+	}
+	change := ItemChange{Kind: kind, Item: item}
+
+	s.muSignals.Lock()
+	defer s.muSignals.Unlock()
+	for c := range s.itemSignals[collection] {
+		select {
+		case c <- change:
+		default:
+		}
+	}
+}
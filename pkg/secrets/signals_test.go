@@ -0,0 +1,165 @@
+package secrets
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// expectChange waits for the next change received on c.
+func expectChange[T comparable](t *testing.T, c <-chan T, want T) {
+	t.Helper()
+
+	select {
+	case got := <-c:
+		if got != want {
+			t.Fatalf("change = %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for change %+v", want)
+	}
+}
+
+// expectNoChange fails if c receives a change within a short while.
+func expectNoChange[T any](t *testing.T, c <-chan T) {
+	t.Helper()
+
+	select {
+	case got := <-c:
+		t.Fatalf("unexpected change %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeCollectionChanges(t *testing.T) {
+	s, _ := newTestService(t)
+	changes := make(chan CollectionChange, 4)
+	other := make(chan CollectionChange, 4)
+	if err := s.SubscribeCollectionChanges(changes); err != nil {
+		t.Fatalf("SubscribeCollectionChanges() error = %v", err)
+	}
+	if err := s.SubscribeCollectionChanges(other); err != nil {
+		t.Fatalf("SubscribeCollectionChanges() error = %v", err)
+	}
+
+	c, err := s.CreateCollection("Work", "")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	expectChange(t, changes, CollectionChange{Kind: CollectionCreated, Collection: c.Path()})
+	expectChange(t, other, CollectionChange{Kind: CollectionCreated, Collection: c.Path()})
+
+	if err := c.SetLabel("Office"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	expectChange(t, changes, CollectionChange{Kind: CollectionChanged, Collection: c.Path()})
+	expectChange(t, other, CollectionChange{Kind: CollectionChanged, Collection: c.Path()})
+
+	if err := s.UnsubscribeCollectionChanges(other); err != nil {
+		t.Fatalf("UnsubscribeCollectionChanges() error = %v", err)
+	}
+	if err := c.Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	expectChange(t, changes, CollectionChange{Kind: CollectionDeleted, Collection: c.Path()})
+	expectNoChange(t, other)
+
+	// The last channel stops receiving, subscribing again starts over
+	if err := s.UnsubscribeCollectionChanges(changes); err != nil {
+		t.Fatalf("UnsubscribeCollectionChanges() error = %v", err)
+	}
+	if _, err := s.CreateCollection("Archive", ""); err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	expectNoChange(t, changes)
+	if err := s.SubscribeCollectionChanges(changes); err != nil {
+		t.Fatalf("SubscribeCollectionChanges() error = %v", err)
+	}
+	c, err = s.CreateCollection("Personal", "")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	expectChange(t, changes, CollectionChange{Kind: CollectionCreated, Collection: c.Path()})
+}
+
+func TestSubscribeCollectionChangesClose(t *testing.T) {
+	s, service := newTestService(t)
+	changes := make(chan CollectionChange, 4)
+	if err := s.SubscribeCollectionChanges(changes); err != nil {
+		t.Fatalf("SubscribeCollectionChanges() error = %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	service.AddCollection(t, "work", "Work")
+	expectNoChange(t, changes)
+
+	if err := s.SubscribeCollectionChanges(changes); !errors.Is(err, ErrClosed) {
+		t.Fatalf("SubscribeCollectionChanges() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestSubscribeCollectionChangesNonBlocking(t *testing.T) {
+	s, service := newTestService(t)
+	full := make(chan CollectionChange)
+	changes := make(chan CollectionChange, 4)
+	for _, c := range []chan CollectionChange{full, changes} {
+		if err := s.SubscribeCollectionChanges(c); err != nil {
+			t.Fatalf("SubscribeCollectionChanges() error = %v", err)
+		}
+	}
+
+	// Nobody receives from full, changes still receives
+	work := service.AddCollection(t, "work", "Work")
+	expectChange(t, changes, CollectionChange{Kind: CollectionCreated, Collection: work})
+}
+
+func TestSubscribeItemChanges(t *testing.T) {
+	s, service := newTestService(t)
+	login, err := s.ReadAlias("default")
+	if err != nil {
+		t.Fatalf("ReadAlias() error = %v", err)
+	}
+	work := Collection{secrets: s, path: service.AddCollection(t, "work", "Work")}
+	loginChanges := make(chan ItemChange, 4)
+	workChanges := make(chan ItemChange, 4)
+	if err := login.SubscribeItemChanges(loginChanges); err != nil {
+		t.Fatalf("SubscribeItemChanges() error = %v", err)
+	}
+	if err := work.SubscribeItemChanges(workChanges); err != nil {
+		t.Fatalf("SubscribeItemChanges() error = %v", err)
+	}
+
+	props := ItemProperties{Label: "token", Attributes: map[string]string{"app": "a"}}
+	item, err := login.CreateItem(props, Secret{Value: []byte("1")}, false)
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	expectChange(t, loginChanges, ItemChange{Kind: ItemCreated, Item: item.Path})
+	// The signals of another collection are not delivered
+	expectNoChange(t, workChanges)
+
+	if err := item.SetLabel("renamed"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	expectChange(t, loginChanges, ItemChange{Kind: ItemChanged, Item: item.Path})
+
+	if err := item.Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	expectChange(t, loginChanges, ItemChange{Kind: ItemDeleted, Item: item.Path})
+
+	workItem := service.AddItem(t, work.Path(), "work", map[string]string{"app": "b"}, []byte("2"))
+	expectChange(t, workChanges, ItemChange{Kind: ItemCreated, Item: workItem})
+	expectNoChange(t, loginChanges)
+
+	// The last channel of a collection stops receiving its signals only
+	if err := login.UnsubscribeItemChanges(loginChanges); err != nil {
+		t.Fatalf("UnsubscribeItemChanges() error = %v", err)
+	}
+	service.AddItem(t, login.Path(), "other", map[string]string{"app": "c"}, []byte("3"))
+	expectNoChange(t, loginChanges)
+	workItem = service.AddItem(t, work.Path(), "other", map[string]string{"app": "d"}, []byte("4"))
+	expectChange(t, workChanges, ItemChange{Kind: ItemCreated, Item: workItem})
+}
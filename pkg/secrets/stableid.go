@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"hash"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// stableIDPrefix is the version of the StableID format.
+const stableIDPrefix = "1."
+
+var (
+	// ErrItemNotFound is returned by FindByStableID when no item has the ID.
+	ErrItemNotFound = errors.New("item not found")
+
+	// ErrStableIDCollision is returned by FindByStableID when several items have the ID, i.e.
+	// they have the same attributes and are in collections with the same label.
+	ErrStableIDCollision = errors.New("several items have the same stable ID")
+)
+
+// StableID returns an identifier of the item that, unlike its path, does not change when the
+// secret service restarts. It is derived from CollectionLabel and Attributes, so it changes when
+// either of them changes. Use FindByStableID to find the item again.
+//
+// Items that have the same attributes in collections with the same label have the same ID, see
+// ErrStableIDCollision.
+func (i *Item) StableID() string {
+	h := sha256.New()
+	writeStableIDField(h, i.CollectionLabel)
+	for _, name := range slices.Sorted(maps.Keys(i.Attributes)) {
+		writeStableIDField(h, name)
+		writeStableIDField(h, i.Attributes[name])
+	}
+
+	return stableIDPrefix + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// writeStableIDField writes the length of the field followed by the field, so that no two
+// different sets of fields hash the same input.
+func writeStableIDField(h hash.Hash, field string) {
+	_ = binary.Write(h, binary.BigEndian, uint64(len(field)))
+	h.Write([]byte(field))
+}
+
+// FindByStableID returns the item with the given ID, see Item.StableID. The items of all
+// collections are searched, locked items are not unlocked and are returned with Locked set.
+//
+// Returns ErrItemNotFound when no item has the ID and an error wrapping ErrStableIDCollision,
+// listing the items, when several items have it.
+func (s *Secrets) FindByStableID(id string) (*Item, error) {
+	return s.FindByStableIDContext(context.Background(), id)
+}
+
+// FindByStableIDContext is like FindByStableID but aborts the calls when ctx is done.
+func (s *Secrets) FindByStableIDContext(ctx context.Context, id string) (*Item, error) {
+	if !strings.HasPrefix(id, stableIDPrefix) {
+		return nil, fmt.Errorf("%w: unsupported stable ID %q", ErrItemNotFound, id)
+	}
+
+	var collections []dbus.ObjectPath
+	err := s.call(ctx, s.obj, propertiesInterface+".Get", dbusServiceInterface, "Collections").
+		Store(&collections)
+	if err != nil {
+		return nil, fmt.Errorf("could not get collections: %w", err)
+	}
+
+	cache := newPropertyCache()
+	var found []Item
+	for _, collection := range collections {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var paths []dbus.ObjectPath
+		err := s.getCachedProperty(ctx, cache, collection, dbusCollectionInterface, "Items", &paths)
+		if err != nil {
+			return nil, fmt.Errorf("could not get items of collection: %w", err)
+		}
+
+		items := make([]Item, 0, len(paths))
+		for _, path := range paths {
+			item, err := s.getItem(ctx, cache, path)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if err := s.fillCollectionLabels(ctx, cache, items); err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if item.StableID() == id {
+				found = append(found, item)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, ErrItemNotFound
+	case 1:
+		return &found[0], nil
+	}
+
+	paths := make([]string, len(found))
+	for i, item := range found {
+		paths[i] = string(item.Path)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrStableIDCollision, strings.Join(paths, ", "))
+}
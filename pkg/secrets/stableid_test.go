@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStableID(t *testing.T) {
+	item := Item{CollectionLabel: "Login", Attributes: map[string]string{"app": "agent", "user": "a"}}
+	same := Item{
+		Path:            "/org/freedesktop/secrets/collection/login/42",
+		CollectionLabel: "Login",
+		Attributes:      map[string]string{"user": "a", "app": "agent"},
+	}
+	if item.StableID() != same.StableID() {
+		t.Fatalf("StableID() depends on the path or attribute order")
+	}
+
+	different := []Item{
+		{CollectionLabel: "Work", Attributes: item.Attributes},
+		{CollectionLabel: "Login", Attributes: map[string]string{"app": "agent"}},
+		{CollectionLabel: "Login", Attributes: map[string]string{"app": "agentuser", "": "a"}},
+		{CollectionLabel: "Loginapp", Attributes: map[string]string{"agent": "", "user": "a"}},
+	}
+	for _, other := range different {
+		if item.StableID() == other.StableID() {
+			t.Errorf("StableID() of %+v equals StableID() of %+v", other, item)
+		}
+	}
+}
+
+func TestFindByStableID(t *testing.T) {
+	s, service := newTestService(t)
+	path := service.AddItem(t, testLoginCollection, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	service.AddItem(t, testLoginCollection, "other", map[string]string{"app": "other"}, []byte("x"))
+
+	items, err := s.FindItems(map[string]string{"app": "agent"}, FindOpts{})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("FindItems() = %v, %v", items, err)
+	}
+	if items[0].Collection != testLoginCollection || items[0].CollectionLabel == "" {
+		t.Fatalf("FindItems() collection = %s %q", items[0].Collection, items[0].CollectionLabel)
+	}
+	id := items[0].StableID()
+
+	item, err := s.FindByStableID(id)
+	if err != nil {
+		t.Fatalf("FindByStableID() error = %v", err)
+	}
+	if item.Path != path {
+		t.Fatalf("FindByStableID() = %s, want %s", item.Path, path)
+	}
+
+	_, err = s.FindByStableID((&Item{CollectionLabel: "None"}).StableID())
+	if !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("FindByStableID() error = %v, want ErrItemNotFound", err)
+	}
+
+	// Another collection with the same label
+	copied := service.AddCollection(t, "copy", items[0].CollectionLabel)
+	service.AddItem(t, copied, "token", map[string]string{"app": "agent"}, []byte("hunter2"))
+	_, err = s.FindByStableID(id)
+	if !errors.Is(err, ErrStableIDCollision) {
+		t.Fatalf("FindByStableID() error = %v, want ErrStableIDCollision", err)
+	}
+}
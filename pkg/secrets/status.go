@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"slices"
+)
+
+// errIsLocked is the error of providers that refuse to list the items of a locked collection.
+const errIsLocked = "org.freedesktop.Secret.Error.IsLocked"
+
+// CollectionStatus is the state of a collection, see Secrets.Status.
+type CollectionStatus struct {
+	Path   dbus.ObjectPath
+	Label  string
+	Locked bool
+	// Items is the number of items in the collection, -1 when the provider does not list the
+	// items of a locked collection or hides them, see ProviderInfo.HidesLockedItems.
+	Items int
+	// Default is true for the collection the "default" alias refers to.
+	Default bool
+}
+
+// Status returns the state of every collection, sorted by label and then by path. The
+// properties of a collection are read in one call. A collection whose items cannot be listed
+// because it is locked is reported with Items set to -1 instead of failing the report.
+func (s *Secrets) Status() ([]CollectionStatus, error) {
+	return s.StatusContext(context.Background())
+}
+
+// StatusContext is like Status but aborts the calls when ctx is done.
+func (s *Secrets) StatusContext(ctx context.Context) ([]CollectionStatus, error) {
+	var collections []dbus.ObjectPath
+	err := s.call(ctx, s.obj, propertiesInterface+".Get", dbusServiceInterface, "Collections").
+		Store(&collections)
+	if err != nil {
+		return nil, fmt.Errorf("could not get collections: %w", err)
+	}
+
+	var defaultCollection dbus.ObjectPath
+	err = s.call(ctx, s.obj, dbusServiceInterface+".ReadAlias", "default").Store(&defaultCollection)
+	if err != nil {
+		return nil, fmt.Errorf("could not read default collection: %w", err)
+	}
+
+	hidesLocked, err := s.hidesLockedItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := newPropertyCache()
+	statuses := make([]CollectionStatus, 0, len(collections))
+	for _, collection := range collections {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		status, err := s.collectionStatus(ctx, cache, collection)
+		if err != nil {
+			return nil, err
+		}
+		status.Default = collection == defaultCollection
+		if status.Locked && hidesLocked {
+			// The empty Items property does not tell
+			status.Items = -1
+		}
+		statuses = append(statuses, status)
+	}
+
+	slices.SortStableFunc(statuses, func(a, b CollectionStatus) int {
+		return cmp.Or(cmp.Compare(a.Label, b.Label), cmp.Compare(a.Path, b.Path))
+	})
+
+	return statuses, nil
+}
+
+// collectionStatus reads the properties of the collection through cache.
+func (s *Secrets) collectionStatus(
+	ctx context.Context,
+	cache *propertyCache,
+	collection dbus.ObjectPath,
+) (CollectionStatus, error) {
+	status := CollectionStatus{Path: collection, Items: -1}
+
+	properties, err := s.getAll(ctx, cache, collection, dbusCollectionInterface)
+	if isDbusError(err, errIsLocked) {
+		// The provider refuses all properties because Items cannot be read, ask for the label
+		err := s.getCachedProperty(
+			ctx,
+			cache,
+			collection,
+			dbusCollectionInterface,
+			"Label",
+			&status.Label,
+		)
+		if err != nil {
+			return status, fmt.Errorf("could not get label of collection: %w", err)
+		}
+		status.Locked = true
+
+		return status, nil
+	}
+	if err != nil {
+		return status, fmt.Errorf("could not get properties of collection %s: %w", collection, err)
+	}
+
+	err = errors.Join(
+		storeProperty(properties, "Label", &status.Label),
+		storeProperty(properties, "Locked", &status.Locked),
+	)
+	if err != nil {
+		return status, fmt.Errorf("unexpected properties of collection %s: %w", collection, err)
+	}
+
+	if _, ok := properties["Items"]; ok {
+		var items []dbus.ObjectPath
+		if err := storeProperty(properties, "Items", &items); err != nil {
+			return status, fmt.Errorf("unexpected properties of collection %s: %w", collection, err)
+		}
+		status.Items = len(items)
+	}
+
+	return status, nil
+}
+
+// isDbusError reports whether err is a D-Bus error with the given name.
+func isDbusError(err error, name string) bool {
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	switch {
+	case errors.As(err, &dbusErr):
+		return dbusErr.Name == name
+	case errors.As(err, &dbusErrPtr):
+		return dbusErrPtr.Name == name
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	s, service := newTestService(t)
+	service.AddItem(t, testLoginCollection, "a", map[string]string{"app": "a"}, []byte("a"))
+	service.AddItem(t, testLoginCollection, "b", map[string]string{"app": "b"}, []byte("b"))
+	work := service.AddCollection(t, "work", "Work")
+	service.AddItem(t, work, "c", map[string]string{"app": "c"}, []byte("c"))
+	service.SetLocked(work, true)
+	archive := service.AddCollection(t, "archive", "Archive")
+
+	want := []CollectionStatus{
+		{Path: archive, Label: "Archive", Items: 0},
+		{Path: testLoginCollection, Label: "Login", Items: 2, Default: true},
+		{Path: work, Label: "Work", Locked: true, Items: 1},
+	}
+	assertStatus := func(t *testing.T) {
+		t.Helper()
+
+		got, err := s.Status()
+		if err != nil {
+			t.Fatalf("Status() error = %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Status() = %+v, want %+v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("Status() = %+v, want %+v", got, want)
+			}
+		}
+	}
+
+	assertStatus(t)
+	if n := service.Calls("org.freedesktop.DBus.Properties.GetAll"); n != len(want) {
+		t.Fatalf("GetAll called %d times, want once per collection", n)
+	}
+
+	service.SetItemsRequireUnlock(true)
+	want[2].Items = -1
+	assertStatus(t)
+}
@@ -0,0 +1,272 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+)
+
+// ErrCollectionNotFound is returned when no collection has the label of a Target, see
+// LabelTarget.
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// Target is the collection a helper such as GetOrCreateIn works on: the collection with an alias,
+// the first collection with a label, or a given collection. The zero Target means every
+// collection for searches and the default collection for writes.
+type Target struct {
+	alias string
+	label string
+	path  dbus.ObjectPath
+}
+
+// AliasTarget is the collection with the alias, e.g. "default". Resolving it returns an error
+// wrapping ErrAliasNotFound when no collection has the alias, and also ErrNoDefaultCollection
+// for the "default" alias.
+func AliasTarget(alias string) Target {
+	return Target{alias: alias}
+}
+
+// LabelTarget is the first collection, in the order of GetCollections, with the label.
+// Resolving it returns an error wrapping ErrCollectionNotFound when no collection has the label.
+func LabelTarget(label string) Target {
+	return Target{label: label}
+}
+
+// CollectionTarget is the given collection.
+func CollectionTarget(c Collection) Target {
+	return Target{path: c.path}
+}
+
+// IsZero reports whether t is the zero Target.
+func (t Target) IsZero() bool {
+	return t == Target{}
+}
+
+func (t Target) String() string {
+	switch {
+	case t.alias != "":
+		return fmt.Sprintf("alias %q", t.alias)
+	case t.label != "":
+		return fmt.Sprintf("label %q", t.label)
+	case t.path != "":
+		return string(t.path)
+	default:
+		return "default collection"
+	}
+}
+
+// targetKey is the key of a resolved Target, targets resolved for writing skip read-only
+// collections and are cached separately.
+type targetKey struct {
+	target   Target
+	writable bool
+}
+
+// ResolveTarget returns the collection of the target to write to. An alias that refers to a
+// read-only collection, see Collection.ReadOnly, returns an error wrapping
+// ErrReadOnlyCollection. For a label, read-only collections are skipped and
+// ErrReadOnlyCollection is returned, naming them, when only read-only collections have the label.
+// The zero Target resolves to the default collection.
+//
+// Aliases and labels are resolved once and cached until a collection is created, changed, or
+// deleted, or another process takes over the secret service. Errors are not cached, so that a
+// CLI can create the missing collection and resolve the target again. Moving an alias to another
+// collection is not signaled by the secret service, the cached collection is used until one of
+// the above happens.
+func (s *Secrets) ResolveTarget(t Target) (Collection, error) {
+	return s.ResolveTargetContext(context.Background(), t)
+}
+
+// ResolveTargetContext is like ResolveTarget but aborts the calls when ctx is done.
+func (s *Secrets) ResolveTargetContext(ctx context.Context, t Target) (Collection, error) {
+	path, err := s.resolveTarget(ctx, t, true)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return Collection{secrets: s, path: path}, nil
+}
+
+// resolveTarget returns the collection of the target, see ResolveTarget. When writable is false,
+// read-only collections are not skipped and the zero Target resolves to "".
+func (s *Secrets) resolveTarget(
+	ctx context.Context,
+	t Target,
+	writable bool,
+) (dbus.ObjectPath, error) {
+	switch {
+	case t.IsZero() && !writable:
+		return "", nil
+	case t.IsZero():
+		t = AliasTarget("default")
+	case t.path != "":
+		if writable {
+			if err := s.checkWritable(ctx, t.path); err != nil {
+				return "", err
+			}
+		}
+		return t.path, nil
+	}
+
+	key := targetKey{target: t, writable: writable}
+	path, generation, cached, err := s.cachedTarget(ctx, key)
+	if err != nil || cached {
+		return path, err
+	}
+
+	if t.alias != "" {
+		path, err = s.resolveAlias(ctx, t.alias, writable)
+	} else {
+		path, err = s.resolveLabel(ctx, t.label, writable)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.muTargets.Lock()
+	defer s.muTargets.Unlock()
+	// A change received while resolving may have made path stale
+	if generation == s.targetsGeneration {
+		s.targets[key] = path
+	}
+
+	return path, nil
+}
+
+// cachedTarget returns the cached collection of the target. The cache is cleared first when
+// another process owns the secret service now. The returned generation is compared to
+// targetsGeneration before caching a resolved target, 0 when it must not be cached because
+// changes of the collections cannot be received.
+func (s *Secrets) cachedTarget(
+	ctx context.Context,
+	key targetKey,
+) (path dbus.ObjectPath, generation uint64, cached bool, err error) {
+	var owner string
+	err = s.call(ctx, s.conn.BusObject(), "org.freedesktop.DBus.GetNameOwner", s.dest).Store(&owner)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("could not get owner of %s: %w", s.dest, err)
+	}
+
+	s.muTargets.Lock()
+	defer s.muTargets.Unlock()
+
+	if owner != s.targetsOwner {
+		clear(s.targets)
+		s.targetsOwner = owner
+		s.targetsGeneration++
+	}
+	if !s.watchingTargets {
+		if err := s.watchTargets(); err != nil {
+			// Resolve every time rather than miss a change
+			return "", 0, false, nil
+		}
+	}
+	if path, ok := s.targets[key]; ok {
+		return path, 0, true, nil
+	}
+
+	return "", s.targetsGeneration, false, nil
+}
+
+// watchTargets clears the resolved targets on every collection change until Close is called.
+// Holding the muTargets mutex is required.
+func (s *Secrets) watchTargets() error {
+	changes := make(chan CollectionChange, signalBuffer)
+	if err := s.SubscribeCollectionChanges(changes); err != nil {
+		return err
+	}
+	s.watchingTargets = true
+
+	go func() {
+		for {
+			select {
+			case <-changes:
+				s.muTargets.Lock()
+				clear(s.targets)
+				s.targetsGeneration++
+				s.muTargets.Unlock()
+			case <-s.scope.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// resolveAlias returns the collection with the alias, see AliasTarget.
+func (s *Secrets) resolveAlias(
+	ctx context.Context,
+	alias string,
+	writable bool,
+) (dbus.ObjectPath, error) {
+	c, err := s.ReadAliasContext(ctx, alias)
+	if errors.Is(err, ErrAliasNotFound) && alias == "default" {
+		return "", fmt.Errorf("%w: %w", ErrNoDefaultCollection, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if writable {
+		readOnly, err := s.readOnly(ctx, c.path)
+		if err != nil {
+			return "", err
+		}
+		if readOnly {
+			return "", fmt.Errorf("%w: alias %q refers to %s", ErrReadOnlyCollection, alias, c.path)
+		}
+	}
+
+	return c.path, nil
+}
+
+// resolveLabel returns the first collection with the label, see LabelTarget.
+func (s *Secrets) resolveLabel(
+	ctx context.Context,
+	label string,
+	writable bool,
+) (dbus.ObjectPath, error) {
+	collections, err := s.GetCollectionsContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	cache := newPropertyCache()
+	var readOnly []dbus.ObjectPath
+	for _, c := range collections {
+		var l string
+		err := s.getCachedProperty(ctx, cache, c.path, dbusCollectionInterface, "Label", &l)
+		if isNoSuchObject(err) {
+			// Deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not get label of collection: %w", err)
+		}
+		if l != label {
+			continue
+		}
+
+		if writable {
+			isReadOnly, err := s.readOnly(ctx, c.path)
+			if err != nil {
+				return "", err
+			}
+			if isReadOnly {
+				readOnly = append(readOnly, c.path)
+				continue
+			}
+		}
+
+		return c.path, nil
+	}
+
+	if len(readOnly) > 0 {
+		return "", fmt.Errorf("%w: every collection with label %q is read-only: %v",
+			ErrReadOnlyCollection, label, readOnly)
+	}
+
+	return "", fmt.Errorf("%w: no collection has label %q", ErrCollectionNotFound, label)
+}
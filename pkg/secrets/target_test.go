@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"testing"
+	"time"
+)
+
+func TestResolveTarget(t *testing.T) {
+	s, service := newTestService(t)
+	work := service.AddCollection(t, "work", "Work")
+	smartcard := service.AddCollection(t, "smartcard", "Smartcard")
+	service.SetReadOnly(smartcard, true)
+
+	tests := []struct {
+		name   string
+		target Target
+		want   dbus.ObjectPath
+		err    error
+	}{
+		{name: "zero", target: Target{}, want: testLoginCollection},
+		{name: "alias", target: AliasTarget("default"), want: testLoginCollection},
+		{name: "label", target: LabelTarget("Work"), want: work},
+		{name: "collection", target: CollectionTarget(Collection{secrets: s, path: work}), want: work},
+		{name: "unset alias", target: AliasTarget("backup"), err: ErrAliasNotFound},
+		{name: "unknown label", target: LabelTarget("Personal"), err: ErrCollectionNotFound},
+		{name: "read-only label", target: LabelTarget("Smartcard"), err: ErrReadOnlyCollection},
+		{
+			name:   "read-only collection",
+			target: CollectionTarget(Collection{secrets: s, path: smartcard}),
+			err:    ErrReadOnlyCollection,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := s.ResolveTarget(test.target)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ResolveTarget(%v) error = %v, want %v", test.target, err, test.err)
+			}
+			if c.Path() != test.want {
+				t.Fatalf("ResolveTarget(%v) = %s, want %s", test.target, c.Path(), test.want)
+			}
+		})
+	}
+
+	// A read-only collection with the label is skipped
+	shared := service.AddCollection(t, "shared", "Smartcard")
+	c, err := s.ResolveTarget(LabelTarget("Smartcard"))
+	if err != nil || c.Path() != shared {
+		t.Fatalf("ResolveTarget() with a read-only duplicate = %s, %v, want %s", c.Path(), err, shared)
+	}
+
+	// Without a default collection, both errors are reported
+	s, service = newTestService(t)
+	service.SetAlias("default", "/")
+	_, err = s.ResolveTarget(AliasTarget("default"))
+	if !errors.Is(err, ErrAliasNotFound) || !errors.Is(err, ErrNoDefaultCollection) {
+		t.Fatalf("ResolveTarget() without default collection error = %v", err)
+	}
+}
+
+func TestResolveTargetCache(t *testing.T) {
+	s, service := newTestService(t)
+	const getAll = "org.freedesktop.DBus.Properties.GetAll"
+	work := service.AddCollection(t, "work", "Work")
+
+	resolve := func(target Target) dbus.ObjectPath {
+		t.Helper()
+
+		c, err := s.ResolveTarget(target)
+		if err != nil {
+			t.Fatalf("ResolveTarget(%v) error = %v", target, err)
+		}
+
+		return c.Path()
+	}
+
+	// Resolved once
+	resolve(LabelTarget("Work"))
+	n := service.Calls(getAll)
+	if c := resolve(LabelTarget("Work")); c != work {
+		t.Fatalf("ResolveTarget() = %s, want %s", c, work)
+	}
+	if service.Calls(getAll) != n {
+		t.Fatal("ResolveTarget() read the labels again for a cached target")
+	}
+
+	// A change of a collection invalidates the cache, the signal arrives asynchronously
+	waitFor := func(name string, target Target, want error) {
+		t.Helper()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			_, err := s.ResolveTarget(target)
+			if errors.Is(err, want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("ResolveTarget() after %s error = %v, want %v", name, err, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := (Collection{secrets: s, path: work}).SetLabel("Personal"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	waitFor("relabeling", LabelTarget("Work"), ErrCollectionNotFound)
+
+	resolve(LabelTarget("Personal"))
+	if err := (Collection{secrets: s, path: work}).Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	waitFor("deleting", LabelTarget("Personal"), ErrCollectionNotFound)
+
+	// Errors are not cached
+	service.AddCollection(t, "work", "Work")
+	resolve(LabelTarget("Work"))
+}
+
+func TestGetOrCreateIn(t *testing.T) {
+	s, service := newTestService(t)
+	work := service.AddCollection(t, "work", "Work")
+	attributes := map[string]string{"app": "agent"}
+	service.AddItem(t, testLoginCollection, "token", attributes, []byte("login"))
+	generate := func() (Secret, error) {
+		return Secret{Value: []byte("work")}, nil
+	}
+
+	// The item of another collection is not returned
+	item, secret, created, err := s.GetOrCreateIn(LabelTarget("Work"), "token", attributes, generate)
+	if err != nil {
+		t.Fatalf("GetOrCreateIn() error = %v", err)
+	}
+	if !created || item.Collection != work || string(secret.Value) != "work" {
+		t.Fatalf("GetOrCreateIn() = %s, %q, created %t, want a new item in %s",
+			item.Collection, secret.Value, created, work)
+	}
+
+	_, secret, created, err = s.GetOrCreateIn(LabelTarget("Work"), "token", attributes, generate)
+	if err != nil || created || string(secret.Value) != "work" {
+		t.Fatalf("GetOrCreateIn() again = %q, created %t, %v, want the item", secret.Value, created, err)
+	}
+
+	_, _, _, err = s.GetOrCreateIn(LabelTarget("Personal"), "token", attributes, func() (Secret, error) {
+		t.Error("GetOrCreateIn() generated a secret for a missing collection")
+		return Secret{}, nil
+	})
+	if !errors.Is(err, ErrCollectionNotFound) {
+		t.Fatalf("GetOrCreateIn() of a missing collection error = %v, want ErrCollectionNotFound", err)
+	}
+
+	// FindItems and PruneOld are limited to the target as well
+	items, err := s.FindItems(attributes, FindOpts{Target: AliasTarget("default")})
+	if err != nil || len(items) != 1 || items[0].Collection != testLoginCollection {
+		t.Fatalf("FindItems() in the default collection = %+v, %v", items, err)
+	}
+	service.AddItem(t, work, "token", attributes, []byte("old"))
+	removed, err := s.PruneOld(attributes, 1, PruneOpts{DryRun: true, Target: LabelTarget("Work")})
+	if err != nil || len(removed) != 1 || collectionOf(removed[0]) != work {
+		t.Fatalf("PruneOld() in the work collection = %v, %v, want one item", removed, err)
+	}
+}
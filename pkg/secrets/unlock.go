@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"slices"
+	"strings"
+)
+
+// ErrDismissed is returned when the user dismissed a prompt.
+var ErrDismissed = errors.New("prompt dismissed")
+
+// unlock unlocks the objects, showing a prompt if needed, and returns the objects that were
+// unlocked. A dismissed prompt is not an error.
+func (s *Secrets) unlock(ctx context.Context, objects []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.call(ctx, s.obj, dbusServiceInterface+".Unlock", objects).Store(&unlocked, &prompt)
+	if err != nil {
+		return nil, fmt.Errorf("could not unlock: %w", err)
+	}
+
+	if prompt == noPrompt {
+		return unlocked, nil
+	}
+
+	result, dismissed, err := s.PerformPromptContext(ctx, prompt, "")
+	if err != nil {
+		return nil, err
+	}
+	if dismissed {
+		return unlocked, nil
+	}
+
+	var promptUnlocked []dbus.ObjectPath
+	if err := result.Store(&promptUnlocked); err != nil {
+		return nil, fmt.Errorf("unexpected result of unlock prompt: %w", err)
+	}
+
+	return append(unlocked, promptUnlocked...), nil
+}
+
+// WithUnlocked unlocks the given collections and items, runs fn, and locks again those that were
+// locked before, even if fn fails or panics. Unlocking can show a prompt to the user.
+//
+// Objects that were locked by someone else while fn ran are left alone. Returns ErrDismissed,
+// without running fn, if the user dismisses the unlock prompt.
+func (s *Secrets) WithUnlocked(paths []dbus.ObjectPath, fn func() error) error {
+	return s.WithUnlockedContext(context.Background(), paths, fn)
+}
+
+// WithUnlockedContext is like WithUnlocked but aborts the calls and the unlock prompt when ctx is
+// done. Locking again is not aborted.
+func (s *Secrets) WithUnlockedContext(
+	ctx context.Context,
+	paths []dbus.ObjectPath,
+	fn func() error,
+) (err error) {
+	wasLocked, err := s.lockedPaths(ctx, paths)
+	if err != nil {
+		return err
+	}
+
+	if len(wasLocked) == 0 {
+		return fn()
+	}
+
+	unlocked, err := s.unlock(ctx, wasLocked)
+	defer func() {
+		// Runs on panic too, the panic continues afterward
+		err = errors.Join(err, s.relock(context.WithoutCancel(ctx), unlocked))
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range wasLocked {
+		if !slices.Contains(unlocked, path) {
+			return fmt.Errorf("could not unlock %s: %w", path, ErrDismissed)
+		}
+	}
+
+	return fn()
+}
+
+// lockedPaths returns the paths that are locked.
+func (s *Secrets) lockedPaths(ctx context.Context, paths []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var locked []dbus.ObjectPath
+	for _, path := range paths {
+		isLocked, err := s.isLocked(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if isLocked {
+			locked = append(locked, path)
+		}
+	}
+
+	return locked, nil
+}
+
+// isLocked reads the Locked property of the collection or item.
+func (s *Secrets) isLocked(ctx context.Context, path dbus.ObjectPath) (bool, error) {
+	iface := dbusCollectionInterface
+	if isItemPath(path) {
+		iface = dbusItemInterface
+	}
+
+	var variant dbus.Variant
+	err := s.call(ctx, s.conn.Object(s.dest, path), propertiesInterface+".Get", iface, "Locked").
+		Store(&variant)
+	if err != nil {
+		return false, fmt.Errorf("could not get Locked of %s: %w", path, err)
+	}
+
+	locked, ok := variant.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("Locked of %s is %s, not a boolean", path, variant.Signature())
+	}
+
+	return locked, nil
+}
+
+// isItemPath reports whether the path is an item, /org/freedesktop/secrets/collection/xxxx/iiii,
+// rather than a collection.
+func isItemPath(path dbus.ObjectPath) bool {
+	rest, ok := strings.CutPrefix(string(path), dbusPath+"/collection/")
+	return ok && strings.Contains(rest, "/")
+}
+
+// relock locks the paths that are still unlocked.
+func (s *Secrets) relock(ctx context.Context, paths []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	for _, path := range paths {
+		isLocked, err := s.isLocked(ctx, path)
+		if err != nil {
+			return err
+		}
+		if !isLocked {
+			unlocked = append(unlocked, path)
+		}
+	}
+
+	if len(unlocked) == 0 {
+		return nil
+	}
+
+	if _, err := s.lock(ctx, unlocked); err != nil {
+		return fmt.Errorf("could not lock again: %w", err)
+	}
+
+	return nil
+}
+
+// lock locks the objects, showing a prompt if needed. Returns the objects that were locked, and
+// ErrDismissed with those locked without the prompt when the prompt is dismissed.
+func (s *Secrets) lock(ctx context.Context, objects []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var locked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.call(ctx, s.obj, dbusServiceInterface+".Lock", objects).Store(&locked, &prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	if prompt == noPrompt {
+		return locked, nil
+	}
+
+	result, dismissed, err := s.PerformPromptContext(ctx, prompt, "")
+	if err != nil {
+		return nil, err
+	}
+	if dismissed {
+		return locked, ErrDismissed
+	}
+
+	var promptLocked []dbus.ObjectPath
+	if err := result.Store(&promptLocked); err != nil {
+		return nil, fmt.Errorf("unexpected result of lock prompt: %w", err)
+	}
+
+	return append(locked, promptLocked...), nil
+}
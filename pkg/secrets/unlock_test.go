@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"testing"
+)
+
+func TestWithUnlocked(t *testing.T) {
+	s, service := newTestService(t)
+	work := service.AddCollection(t, "work", "Work")
+	service.SetLocked(work, true)
+
+	err := s.WithUnlocked([]dbus.ObjectPath{testLoginCollection, work}, func() error {
+		if service.Locked(work) {
+			t.Error("collection locked while fn runs")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithUnlocked() error = %v", err)
+	}
+
+	if !service.Locked(work) {
+		t.Error("collection that was locked before is not locked again")
+	}
+	if service.Locked(testLoginCollection) {
+		t.Error("collection that was unlocked before has been locked")
+	}
+}
+
+func TestWithUnlockedRelocksOnFailure(t *testing.T) {
+	s, service := newTestService(t)
+	item := service.AddItem(t, testLoginCollection, "token", nil, []byte("hunter2"))
+	service.SetLocked(testLoginCollection, true)
+
+	fnErr := errors.New("fn failed")
+	err := s.WithUnlocked([]dbus.ObjectPath{item}, func() error {
+		return fnErr
+	})
+	if !errors.Is(err, fnErr) {
+		t.Fatalf("WithUnlocked() error = %v, want %v", err, fnErr)
+	}
+	if !service.Locked(item) {
+		t.Error("item not locked again after fn failed")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic of fn was not propagated")
+			}
+		}()
+		_ = s.WithUnlocked([]dbus.ObjectPath{item}, func() error {
+			panic("fn panicked")
+		})
+	}()
+	if !service.Locked(item) {
+		t.Error("item not locked again after fn panicked")
+	}
+}
+
+func TestWithUnlockedLockedConcurrently(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetLocked(testLoginCollection, true)
+
+	err := s.WithUnlocked([]dbus.ObjectPath{testLoginCollection}, func() error {
+		// Another application locks the collection in the meantime
+		service.SetLocked(testLoginCollection, true)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithUnlocked() error = %v", err)
+	}
+	if got := service.Calls("org.freedesktop.Secret.Service.Lock"); got != 0 {
+		t.Errorf("Lock called %d times for an already locked collection", got)
+	}
+}
+
+func TestWithUnlockedDismissed(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetLocked(testLoginCollection, true)
+	service.SetPromptDismissed(true)
+
+	err := s.WithUnlocked([]dbus.ObjectPath{testLoginCollection}, func() error {
+		t.Error("fn called although the prompt was dismissed")
+		return nil
+	})
+	if !errors.Is(err, ErrDismissed) {
+		t.Fatalf("WithUnlocked() error = %v, want ErrDismissed", err)
+	}
+	if !service.Locked(testLoginCollection) {
+		t.Error("collection unlocked after dismissed prompt")
+	}
+}
+
+func TestLockPaths(t *testing.T) {
+	for name, prompts := range map[string]bool{"without prompt": false, "with prompt": true} {
+		t.Run(name, func(t *testing.T) {
+			s, service := newTestService(t)
+			service.SetLockPrompts(prompts)
+			collections, err := s.GetCollections()
+			if err != nil {
+				t.Fatalf("GetCollections() error = %v", err)
+			}
+
+			locked, err := s.LockPaths([]dbus.ObjectPath{collections[0].Path()})
+			if err != nil {
+				t.Fatalf("LockPaths() error = %v", err)
+			}
+			if len(locked) != 1 || locked[0] != testLoginCollection {
+				t.Fatalf("LockPaths() = %v, want %s", locked, testLoginCollection)
+			}
+			if !service.Locked(testLoginCollection) {
+				t.Fatalf("%s is not locked", testLoginCollection)
+			}
+		})
+	}
+}
+
+func TestLockPathsDismissed(t *testing.T) {
+	s, service := newTestService(t)
+	service.SetLockPrompts(true)
+	service.SetPromptDismissed(true)
+
+	locked, err := s.LockPaths([]dbus.ObjectPath{testLoginCollection})
+	if !errors.Is(err, ErrDismissed) {
+		t.Fatalf("LockPaths() error = %v, want ErrDismissed", err)
+	}
+	if len(locked) != 0 || service.Locked(testLoginCollection) {
+		t.Fatalf("LockPaths() = %v, want nothing locked", locked)
+	}
+}